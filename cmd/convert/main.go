@@ -0,0 +1,50 @@
+// Command convert upgrades the data files of every index under a data
+// directory to the binary document encoding
+// Run it with the server stopped
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+
+	"nano-elastic/internal/storage"
+)
+
+func main() {
+	dataPath := flag.String("data", "./data", "directory holding index data")
+	flag.Parse()
+
+	entries, err := os.ReadDir(*dataPath)
+	if err != nil {
+		log.Fatalf("Failed to read data directory: %v", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		schema, err := storage.LoadSchema(storage.OSFS, *dataPath, name)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue // Not an index directory
+			}
+			log.Fatalf("Failed to load schema of %s: %v", name, err)
+		}
+
+		im, err := storage.NewIndexManager(name, *dataPath, schema, storage.WithMergeInterval(0))
+		if err != nil {
+			log.Fatalf("Failed to open %s: %v", name, err)
+		}
+		converted, err := im.UpgradeEncoding()
+		if closeErr := im.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Fatalf("Failed to convert %s: %v", name, err)
+		}
+		log.Printf("%s: converted %d segments and the WAL", name, converted)
+	}
+}
@@ -123,7 +123,7 @@ func main() {
 	if results != nil {
 		fmt.Printf("   ✓ Found in %d documents:\n", results.DocFreq)
 		for _, posting := range results.Postings {
-			doc, _ := indexManager.ReadDocument(posting.DocID)
+			doc, _ := indexManager.ReadDocument(invertedIndex.DocID(posting.Doc))
 			fmt.Printf("      - %s (appears %d times)\n", doc.GetFieldAsText("title"), posting.TermFreq)
 		}
 	} else {
@@ -137,7 +137,7 @@ func main() {
 	if results != nil {
 		fmt.Printf("   ✓ Found in %d documents:\n", results.DocFreq)
 		for _, posting := range results.Postings {
-			doc, _ := indexManager.ReadDocument(posting.DocID)
+			doc, _ := indexManager.ReadDocument(invertedIndex.DocID(posting.Doc))
 			fmt.Printf("      - %s\n", doc.GetFieldAsText("title"))
 		}
 	} else {
@@ -151,7 +151,7 @@ func main() {
 	if results != nil && results.DocFreq > 0 {
 		fmt.Printf("   ✓ Found in %d documents:\n", results.DocFreq)
 		for _, posting := range results.Postings {
-			doc, _ := indexManager.ReadDocument(posting.DocID)
+			doc, _ := indexManager.ReadDocument(invertedIndex.DocID(posting.Doc))
 			fmt.Printf("      - %s\n", doc.GetFieldAsText("title"))
 		}
 	} else {
@@ -165,7 +165,7 @@ func main() {
 	if results != nil {
 		fmt.Printf("   ✓ Found in %d documents:\n", results.DocFreq)
 		for _, posting := range results.Postings {
-			doc, _ := indexManager.ReadDocument(posting.DocID)
+			doc, _ := indexManager.ReadDocument(invertedIndex.DocID(posting.Doc))
 			fmt.Printf("      - %s\n", doc.GetFieldAsText("title"))
 		}
 	} else {
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"nano-elastic/internal/engine"
+	"nano-elastic/internal/search"
+)
+
+// runForceMerge merges an index's segments into one, applying its deletes
+func runForceMerge(dataPath string, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	idx, err := openIndex(dataPath, args[0])
+	if err != nil {
+		return err
+	}
+	before, err := idx.Stats()
+	if err != nil {
+		idx.Close()
+		return err
+	}
+
+	start := time.Now()
+	err = idx.ForceMerge()
+	if closeErr := idx.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: merged %d segments (%d deleted documents) in %s\n",
+		args[0], before.SegmentCount, before.DeletedCount, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// runFsck verifies the given indexes, every index if none, and with -repair
+// rebuilds what it can from the stored documents
+// Exits with status 1 if any index has a problem left unrepaired
+func runFsck(dataPath string, args []string) error {
+	flags := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "rebuild damaged doc indexes and search structures from the stored documents")
+	if err := flags.Parse(args); err != nil {
+		return errUsage // The flag set printed the error
+	}
+	names := flags.Args()
+	if len(names) == 0 {
+		var err error
+		if names, err = indexNames(dataPath); err != nil {
+			return err
+		}
+	}
+	var options []engine.VerifyOption
+	if *repair {
+		options = append(options, engine.WithRepair())
+	}
+
+	damaged := 0
+	for _, name := range names {
+		idx, err := openIndex(dataPath, name)
+		if err != nil {
+			return err
+		}
+		left, err := fsckIndex(idx, name, options)
+		if closeErr := idx.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if left > 0 {
+			damaged++
+		}
+	}
+	if damaged > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// fsckIndex verifies an open index and prints the report
+// After a repair the index is verified again, so problems it couldn't fix
+// (e.g. in the WAL) are told apart; returns how many are left
+func fsckIndex(idx *engine.Index, name string, options []engine.VerifyOption) (int, error) {
+	report, err := idx.Verify(options...)
+	if err != nil {
+		return 0, err
+	}
+	status := "ok"
+	if !report.OK() {
+		status = fmt.Sprintf("%d problem(s)", len(report.Problems))
+	}
+	fmt.Printf("%s: %s (%d segments, %d records, %d WAL entries, %d documents, %d postings)\n",
+		name, status, report.Segments, report.Records, report.WALEntries, report.Documents, report.Postings)
+	for _, problem := range report.Problems {
+		fmt.Printf("  %v\n", problem)
+	}
+	if len(report.Repaired) == 0 {
+		return len(report.Problems), nil
+	}
+
+	for _, repaired := range report.Repaired {
+		fmt.Printf("  rebuilt %s\n", repaired)
+	}
+	recheck, err := idx.Verify()
+	if err != nil {
+		return 0, err
+	}
+	if recheck.OK() {
+		fmt.Printf("  ok after repair\n")
+		return 0, nil
+	}
+	fmt.Printf("  %d problem(s) left after repair\n", len(recheck.Problems))
+	for _, problem := range recheck.Problems {
+		fmt.Printf("    %v\n", problem)
+	}
+	return len(recheck.Problems), nil
+}
+
+// runSearch runs a search request against an index and prints the response
+// The request is the BODY argument ("-" reads it from stdin); -q replaces
+// its query with a query string, like the q URL parameter
+func runSearch(dataPath string, args []string) error {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	q := flags.String("q", "", "query string query, e.g. title:jazz")
+	size := flags.Int("size", 0, "number of hits to return (0 uses the request's, or the default)")
+	if err := flags.Parse(args); err != nil {
+		return errUsage // The flag set printed the error
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return errUsage
+	}
+
+	var body []byte
+	switch flags.Arg(1) {
+	case "":
+	case "-":
+		var err error
+		if body, err = io.ReadAll(os.Stdin); err != nil {
+			return fmt.Errorf("failed to read request: %w", err)
+		}
+	default:
+		body = []byte(flags.Arg(1))
+	}
+	req, err := search.ParseSearchRequest(body)
+	if err != nil {
+		return err
+	}
+	if *q != "" {
+		req.Query = &search.QueryStringQuery{Query: *q}
+	}
+	if *size > 0 {
+		req.Size = size
+	}
+
+	idx, err := openIndex(dataPath, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+	start := time.Now()
+	resp, err := idx.Search(req)
+	if err != nil {
+		return err
+	}
+
+	hits := make([]map[string]interface{}, 0, len(resp.Hits))
+	for _, hit := range resp.Hits {
+		h := map[string]interface{}{"_id": hit.ID, "_score": hit.Score}
+		if hit.Document != nil {
+			h["_source"] = hit.Document.Source()
+		}
+		if hit.Sort != nil {
+			h["sort"] = hit.Sort
+		}
+		if hit.Highlight != nil {
+			h["highlight"] = hit.Highlight
+		}
+		hits = append(hits, h)
+	}
+	result := map[string]interface{}{
+		"took":      time.Since(start).Milliseconds(),
+		"timed_out": resp.TimedOut,
+		"hits": map[string]interface{}{
+			"total":     map[string]interface{}{"value": resp.Total, "relation": resp.TotalRelation},
+			"max_score": resp.MaxScore,
+			"hits":      hits,
+		},
+	}
+	if resp.Aggregations != nil {
+		result["aggregations"] = resp.Aggregations
+	}
+	if resp.Profile != nil {
+		result["profile"] = map[string]interface{}{"shards": resp.Profile}
+	}
+	return writeJSON(result)
+}
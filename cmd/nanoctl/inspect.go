@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"nano-elastic/internal/storage"
+)
+
+// runIndexes lists the indexes of the data directory
+// Documents are those of the segments; unflushed writes are the WAL entries
+// past the checkpoint, replayed when the index is next opened
+func runIndexes(dataPath string, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	names, err := indexNames(dataPath)
+	if err != nil {
+		return err
+	}
+
+	tw := newTable()
+	fmt.Fprintln(tw, "INDEX\tDOCS\tDELETED\tSEGMENTS\tBYTES\tWAL FILES\tWAL BYTES\tUNFLUSHED")
+	for _, name := range names {
+		indexPath, _, err := loadIndex(dataPath, name)
+		if err != nil {
+			return err
+		}
+		manifest, err := storage.ReadManifest(indexPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		docs, deleted, size := 0, 0, int64(0)
+		segments := "?" // Indexes from before manifests until they are opened
+		if manifest != nil {
+			for _, info := range manifest.Segments {
+				docs += info.DocCount - info.Deleted
+				deleted += info.Deleted
+				size += info.Size
+			}
+			segments = fmt.Sprint(len(manifest.Segments))
+		}
+
+		checkpoint, err := storage.ReadCheckpoint(indexPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		files, err := storage.ListWAL(indexPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		walBytes, unflushed := int64(0), 0
+		for _, f := range files {
+			walBytes += f.Size
+			err := storage.ReadWALFile(f.Path, func(offset int64, entry *storage.WALEntry) error {
+				if entry.Sequence > checkpoint {
+					unflushed++
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%d\t%d\t%d\t%d\n", name, docs, deleted, segments, size, len(files), walBytes, unflushed)
+	}
+	return tw.Flush()
+}
+
+// runSchema prints an index's schema as JSON
+func runSchema(dataPath string, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	_, schema, err := loadIndex(dataPath, args[0])
+	if err != nil {
+		return err
+	}
+	return writeJSON(schema)
+}
+
+// runSegments lists an index's segments in lookup order
+func runSegments(dataPath string, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	indexPath, _, err := loadIndex(dataPath, args[0])
+	if err != nil {
+		return err
+	}
+	manifest, err := storage.ReadManifest(indexPath)
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		return fmt.Errorf("%s has no manifest; open it once to write one", args[0])
+	}
+
+	fmt.Printf("generation %d\n", manifest.Generation)
+	tw := newTable()
+	fmt.Fprintln(tw, "SEGMENT\tDOCS\tDELETED\tBYTES\tMIN SEQ\tMAX SEQ")
+	for _, info := range manifest.Segments {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\n", info.ID, info.DocCount, info.Deleted, info.Size, info.MinSequence, info.MaxSequence)
+	}
+	return tw.Flush()
+}
+
+// runSegment prints a segment's header and, with -docs, its doc index
+func runSegment(dataPath string, args []string) error {
+	flags := flag.NewFlagSet("segment", flag.ContinueOnError)
+	docs := flags.Bool("docs", false, "list the doc index: every record's document ID and offset")
+	if err := flags.Parse(args); err != nil {
+		return errUsage // The flag set printed the error
+	}
+	if flags.NArg() != 2 {
+		return errUsage
+	}
+	indexPath, _, err := loadIndex(dataPath, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	dump, err := storage.InspectSegment(indexPath, flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	deleted := 0
+	for _, doc := range dump.Docs {
+		if doc.Deleted {
+			deleted++
+		}
+	}
+	encoding := "json"
+	if dump.Header.Encoding == storage.RecordEncodingBinary {
+		encoding = "binary"
+	}
+	index := "sidecar"
+	if !dump.IndexLoaded {
+		index = "rebuilt" // The next open writes the sidecar
+	}
+
+	tw := newTable()
+	fmt.Fprintf(tw, "path\t%s\n", dump.Path)
+	fmt.Fprintf(tw, "magic\t%s\n", dump.Header.Magic[:])
+	fmt.Fprintf(tw, "version\t%d\n", dump.Header.Version)
+	fmt.Fprintf(tw, "encoding\t%s\n", encoding)
+	fmt.Fprintf(tw, "created\t%s\n", time.Unix(dump.Header.Created, 0).UTC().Format(time.RFC3339))
+	fmt.Fprintf(tw, "header doc count\t%d\n", dump.Header.DocCount)
+	fmt.Fprintf(tw, "records\t%d\n", len(dump.Docs))
+	fmt.Fprintf(tw, "deleted\t%d\n", deleted)
+	fmt.Fprintf(tw, "file bytes\t%d\n", dump.FileSize)
+	fmt.Fprintf(tw, "data bytes\t%d\n", dump.DataSize)
+	fmt.Fprintf(tw, "blocks\t%d\n", dump.Blocks)
+	fmt.Fprintf(tw, "sequence\t%d\n", dump.Sequence)
+	fmt.Fprintf(tw, "doc index\t%s\n", index)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if !*docs {
+		return nil
+	}
+
+	fmt.Println()
+	tw = newTable()
+	fmt.Fprintln(tw, "OFFSET\tID\tDELETED")
+	for _, doc := range dump.Docs {
+		fmt.Fprintf(tw, "%d\t%s\t%t\n", doc.Offset, doc.ID, doc.Deleted)
+	}
+	return tw.Flush()
+}
+
+// runWAL prints the entries of an index's WAL files, oldest first
+func runWAL(dataPath string, args []string) error {
+	flags := flag.NewFlagSet("wal", flag.ContinueOnError)
+	docs := flags.Bool("docs", false, "print each write's document")
+	if err := flags.Parse(args); err != nil {
+		return errUsage // The flag set printed the error
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	indexPath, _, err := loadIndex(dataPath, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	checkpoint, err := storage.ReadCheckpoint(indexPath)
+	if err != nil {
+		return err
+	}
+	files, err := storage.ListWAL(indexPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("checkpoint %d\n", checkpoint)
+	for _, f := range files {
+		fmt.Printf("\n%s: version %d, base sequence %d, %d bytes\n", f.Path, f.Version, f.Base, f.Size)
+		tw := newTable()
+		fmt.Fprintln(tw, "OFFSET\tSEQ\tTYPE\tID\tTIME")
+		err := storage.ReadWALFile(f.Path, func(offset int64, entry *storage.WALEntry) error {
+			id := entry.DocID
+			if entry.Encrypted {
+				id = "(encrypted)"
+			}
+			fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s", offset, entry.Sequence, entryType(entry.Type), id,
+				time.Unix(0, entry.Timestamp).UTC().Format(time.RFC3339Nano))
+			if *docs && entry.Document != nil {
+				source, err := json.Marshal(entry.Document.Source())
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(tw, "\t%s", source)
+			}
+			fmt.Fprintln(tw)
+			return nil
+		})
+		if flushErr := tw.Flush(); err == nil {
+			err = flushErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entryType names a WAL entry type
+func entryType(t storage.WALEntryType) string {
+	switch t {
+	case storage.WALEntryWrite:
+		return "write"
+	case storage.WALEntryDelete:
+		return "delete"
+	case storage.WALEntryUpdate:
+		return "update"
+	}
+	return fmt.Sprintf("unknown(%d)", t)
+}
+
+// runVerify checks the checksums of the given indexes, every index if none
+// Exits with status 1 if any file is damaged
+func runVerify(dataPath string, args []string) error {
+	names := args
+	if len(names) == 0 {
+		var err error
+		if names, err = indexNames(dataPath); err != nil {
+			return err
+		}
+	}
+
+	damaged := 0
+	for _, name := range names {
+		indexPath, _, err := loadIndex(dataPath, name)
+		if err != nil {
+			return err
+		}
+		report, err := storage.VerifyChecksums(indexPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		status := "ok"
+		if !report.OK() {
+			status = fmt.Sprintf("%d problem(s)", len(report.Problems))
+			damaged++
+		}
+		fmt.Printf("%s: %s (%d segments, %d blocks, %d records, %d WAL files, %d WAL entries)\n",
+			name, status, report.Segments, report.Blocks, report.Records, report.WALFiles, report.WALEntries)
+		for _, problem := range report.Problems {
+			fmt.Printf("  %v\n", problem)
+		}
+	}
+	if damaged > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// writeJSON prints a value as indented JSON
+func writeJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
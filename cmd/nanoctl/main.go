@@ -0,0 +1,156 @@
+// Command nanoctl inspects and administers the indexes of a data directory
+// without going through the server
+// Inspection commands only read the files; fsck, forcemerge and search open
+// the index, so run them with the server stopped
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"nano-elastic/internal/engine"
+	"nano-elastic/internal/logging"
+	"nano-elastic/internal/storage"
+	"nano-elastic/internal/types"
+)
+
+// command is a nanoctl subcommand
+type command struct {
+	name  string
+	args  string
+	about string
+	run   func(dataPath string, args []string) error
+}
+
+var commands = []command{
+	{"indexes", "", "list the indexes with their documents, segments and WAL", runIndexes},
+	{"schema", "INDEX", "print an index's schema", runSchema},
+	{"segments", "INDEX", "list an index's segments from its manifest", runSegments},
+	{"segment", "[-docs] INDEX SEGMENT", "print a segment's header and, with -docs, its doc index", runSegment},
+	{"wal", "[-docs] INDEX", "print the entries of an index's WAL, with -docs their documents", runWAL},
+	{"verify", "[INDEX...]", "check the checksums of every segment, WAL and checkpoint file", runVerify},
+	{"fsck", "[-repair] [INDEX...]", "check an index's segments, WAL and postings against each other", runFsck},
+	{"forcemerge", "INDEX", "merge an index's segments into one", runForceMerge},
+	{"search", "[-q QUERY] [-size N] INDEX [BODY]", "run a search request, from BODY or a query string", runSearch},
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("nanoctl: ")
+
+	dataPath := flag.String("data", "./data", "directory holding index data")
+	keysPath := flag.String("encryption-keys", "", "key file of encrypted indexes, as given to the server, for the commands opening an index")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if *keysPath != "" {
+		keys, err := storage.ReadKeyFile(*keysPath)
+		if err != nil {
+			log.Fatalf("invalid -encryption-keys: %v", err)
+		}
+		encryptionKeys = keys
+	}
+
+	name, args := flag.Arg(0), flag.Args()[1:]
+	for _, cmd := range commands {
+		if cmd.name == name {
+			err := cmd.run(*dataPath, args)
+			if errors.Is(err, errUsage) {
+				log.Printf("usage: nanoctl %s %s", cmd.name, cmd.args)
+				os.Exit(2)
+			}
+			if err != nil {
+				log.Fatalf("%s: %v", name, err)
+			}
+			return
+		}
+	}
+	log.Printf("unknown command %q", name)
+	usage()
+	os.Exit(2)
+}
+
+// usage prints the flags and commands
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "Usage: nanoctl [-data DIR] COMMAND [ARGS]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.about)
+	}
+	tw.Flush()
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Flags:")
+	flag.PrintDefaults()
+}
+
+// errUsage is returned by a command given the wrong arguments
+var errUsage = errors.New("wrong arguments")
+
+// loadIndex returns the directory and schema of an index of the data directory
+func loadIndex(dataPath string, name string) (string, *types.Schema, error) {
+	schema, err := storage.LoadSchema(storage.OSFS, dataPath, name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil, fmt.Errorf("no index %s in %s", name, dataPath)
+		}
+		return "", nil, err
+	}
+	return filepath.Join(dataPath, name), schema, nil
+}
+
+// indexNames returns the indexes of the data directory, by name
+func indexNames(dataPath string) ([]string, error) {
+	entries, err := os.ReadDir(dataPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data directory: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dataPath, entry.Name(), storage.SchemaFile)); err == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// encryptionKeys are the keys of encrypted indexes, from -encryption-keys
+var encryptionKeys storage.KeyProvider
+
+// openIndex opens an index for the commands that need more than its files
+// Nothing runs in the background, and only warnings are logged
+func openIndex(dataPath string, name string) (*engine.Index, error) {
+	_, schema, err := loadIndex(dataPath, name)
+	if err != nil {
+		return nil, err
+	}
+	storageOptions := []storage.IndexOption{storage.WithMergeInterval(0)}
+	if encryptionKeys != nil {
+		storageOptions = append(storageOptions, storage.WithEncryption(encryptionKeys))
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	return engine.OpenIndex(name, dataPath, schema,
+		engine.WithRefreshInterval(0),
+		engine.WithStorageOptions(storageOptions...),
+		engine.WithLogger(logging.NewSlogLogger(logger)),
+	)
+}
+
+// newTable returns a writer aligning tab-separated columns on stdout
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"nano-elastic/internal/engine"
+	"nano-elastic/internal/logging"
+	"nano-elastic/internal/metrics"
+	"nano-elastic/internal/search"
+	"nano-elastic/internal/server"
+	"nano-elastic/internal/snapshot"
+	"nano-elastic/internal/storage"
+)
+
+func main() {
+	addr := flag.String("addr", ":9200", "address to listen on")
+	dataPath := flag.String("data", "./data", "directory holding index data")
+	codecName := flag.String("codec", storage.DefaultCodec.Name(), "compression of stored documents in sealed segments: none, lz4, zstd or deflate")
+	durabilityName := flag.String("durability", storage.DefaultDurability.String(), "when the WAL is synced to disk: write, interval or flush")
+	syncInterval := flag.Duration("sync-interval", storage.DefaultSyncInterval, "how often the WAL is synced with -durability interval")
+	refreshInterval := flag.Duration("refresh-interval", engine.DefaultRefreshInterval, "how often new writes become searchable (<= 0 only on explicit refresh)")
+	filterCacheSize := flag.Int("filter-cache-size", search.DefaultFilterCacheSize, "memory for cached filter results, in bytes (0 disables the cache)")
+	searchConcurrency := flag.Int("search-concurrency", runtime.GOMAXPROCS(0), "goroutines searching segments in parallel, shared by all searches (0 searches each index at once)")
+	serveMetrics := flag.Bool("metrics", false, "serve Prometheus metrics at /metrics")
+	logLevel := flag.String("log-level", "info", "lowest level of log records written: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "format of log records: text or json")
+	slowLogThreshold := flag.Duration("slowlog-threshold", 0, "log searches taking at least this long (0 disables the slow log)")
+	slowLogPath := flag.String("slowlog-file", "", "file slow searches are appended to as JSON lines, instead of the main log")
+	segmentStore := flag.String("segment-store", "", "object store sealed segments are moved to when evicted from local disk: fs or s3 (empty keeps them local)")
+	segmentStoreSettings := flag.String("segment-store-settings", "", "comma-separated key=value settings of -segment-store, like a snapshot repository's (e.g. bucket=b,region=us-east-1)")
+	segmentCacheSize := flag.Int64("segment-cache-size", storage.DefaultTieredCacheSize, "local disk for sealed segments with -segment-store, in bytes")
+	pathRepo := flag.String("path-repo", "", "comma-separated directories fs snapshot repositories may be registered in (empty allows none)")
+	repoEndpoints := flag.String("repo-s3-endpoints", "", "comma-separated endpoints s3 snapshot repositories may use besides AWS, e.g. http://localhost:9000")
+	encryptionKeys := flag.String("encryption-keys", "", "file of AES keys encrypting segments and WAL entries, one \"ID HEXKEY\" per line; the highest ID is current")
+	flag.Parse()
+
+	logger, err := newLogger(*logLevel, *logFormat)
+	if err != nil {
+		log.Fatalf("Invalid logging flags: %v", err)
+	}
+	slog.SetDefault(logger) // The log package writes through it too
+
+	var slowLog logging.Logger // nil logs slow searches with everything else
+	if *slowLogPath != "" {
+		slowLogFile, err := os.OpenFile(*slowLogPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalf("Failed to open slow log: %v", err)
+		}
+		defer slowLogFile.Close()
+		slowLog = logging.NewSlogLogger(slog.New(slog.NewJSONHandler(slowLogFile, nil)))
+	}
+
+	codec, err := storage.CodecByName(*codecName)
+	if err != nil {
+		log.Fatalf("Invalid -codec: %v", err)
+	}
+	durability, err := storage.DurabilityByName(*durabilityName)
+	if err != nil {
+		log.Fatalf("Invalid -durability: %v", err)
+	}
+
+	var filterCache *search.FilterCache
+	if *filterCacheSize > 0 {
+		filterCache = search.NewFilterCache(*filterCacheSize)
+	}
+
+	var searchPool *search.SearchPool
+	if *searchConcurrency > 0 {
+		searchPool = search.NewSearchPool(*searchConcurrency)
+	}
+
+	options := []engine.Option{
+		engine.WithStorageOptions(
+			storage.WithCodec(codec),
+			storage.WithDurability(durability),
+			storage.WithSyncInterval(*syncInterval),
+		),
+		engine.WithRefreshInterval(*refreshInterval),
+		engine.WithFilterCache(filterCache),
+		engine.WithSearchPool(searchPool),
+		engine.WithLogger(logging.NewSlogLogger(logger)),
+		engine.WithSlowLog(*slowLogThreshold, slowLog),
+		engine.WithRepositoryAllowlist(snapshot.Allowlist{
+			Paths:     splitList(*pathRepo),
+			Endpoints: splitList(*repoEndpoints),
+		}),
+	}
+	if *segmentStore != "" {
+		settings, err := parseSettings(*segmentStoreSettings)
+		if err != nil {
+			log.Fatalf("Invalid -segment-store-settings: %v", err)
+		}
+		store, err := snapshot.NewRepository(*segmentStore, settings)
+		if err != nil {
+			log.Fatalf("Invalid -segment-store: %v", err)
+		}
+		tiered, err := storage.NewTieredFS(storage.OSFS, *dataPath, store,
+			storage.WithCacheSize(*segmentCacheSize),
+			storage.WithTieredLogger(logging.NewSlogLogger(logger)),
+		)
+		if err != nil {
+			log.Fatalf("Failed to open segment store: %v", err)
+		}
+		options = append(options, engine.WithFS(tiered))
+	}
+	if *encryptionKeys != "" {
+		keys, err := storage.ReadKeyFile(*encryptionKeys)
+		if err != nil {
+			log.Fatalf("Invalid -encryption-keys: %v", err)
+		}
+		options = append(options, engine.WithStorageOptions(storage.WithEncryption(keys)))
+	}
+
+	eng, err := engine.NewEngine(*dataPath, options...)
+	if err != nil {
+		log.Fatalf("Failed to open data directory: %v", err)
+	}
+
+	var serverOptions []server.Option
+	if *serveMetrics {
+		registry := metrics.NewRegistry()
+		registry.Register(eng)
+		serverOptions = append(serverOptions, server.WithMetrics(registry))
+	}
+
+	httpServer := &http.Server{
+		Addr:    *addr,
+		Handler: server.NewServer(eng, serverOptions...).Handler(),
+	}
+
+	// Shut down cleanly on SIGINT/SIGTERM so indexes are flushed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		log.Printf("nano-elastic listening on %s (data: %s)", *addr, *dataPath)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server error: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP shutdown error: %v", err)
+	}
+	if err := eng.Close(); err != nil {
+		log.Printf("Failed to close indexes: %v", err)
+	}
+}
+
+// splitList splits a comma-separated flag, dropping empty elements
+func splitList(s string) []string {
+	var list []string
+	for _, element := range strings.Split(s, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			list = append(list, element)
+		}
+	}
+	return list
+}
+
+// parseSettings parses comma-separated key=value pairs
+func parseSettings(s string) (map[string]string, error) {
+	settings := make(map[string]string)
+	if s == "" {
+		return settings, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+		settings[key] = value
+	}
+	return settings, nil
+}
+
+// newLogger creates the logger writing the server's records to stderr
+func newLogger(levelName string, format string) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(levelName)); err != nil {
+		return nil, fmt.Errorf("-log-level: %w", err)
+	}
+	options := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, options)), nil
+	}
+	return nil, fmt.Errorf("-log-format: unknown format %q, expected text or json", format)
+}
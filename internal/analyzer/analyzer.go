@@ -1,9 +1,5 @@
 package analyzer
 
-import (
-	"strings"
-)
-
 // StopWords is a set of common words to filter out
 // In Go, we use a map[string]bool as a set (map with bool values)
 var StopWords = map[string]bool{
@@ -14,120 +10,83 @@ var StopWords = map[string]bool{
 	"to": true, "was": true, "were": true, "will": true, "with": true,
 }
 
-// Analyzer processes text through a chain of analyzers
+// Analyzer processes text through a tokenizer followed by a chain of token filters
 type Analyzer struct {
-	tokenizer *Tokenizer
-	useStopWords bool
-	useStemming  bool
+	tokenizer Tokenizer
+	filters   []TokenFilter
 }
 
 // NewAnalyzer creates a new analyzer
+// The default pipeline is standard tokenizer -> lowercase -> stop words
 func NewAnalyzer() *Analyzer {
-	return &Analyzer{
-		tokenizer:    NewTokenizer(),
-		useStopWords: true,
-		useStemming:  false, // We'll implement stemming later
-	}
+	return NewAnalyzerWithOptions(true, false)
 }
 
 // NewAnalyzerWithOptions creates an analyzer with custom options
-// This demonstrates Go's variadic function pattern
+// Stop words and stemming are appended to the pipeline when enabled
 func NewAnalyzerWithOptions(useStopWords, useStemming bool) *Analyzer {
-	return &Analyzer{
-		tokenizer:    NewTokenizer(),
-		useStopWords: useStopWords,
-		useStemming:  useStemming,
+	filters := []TokenFilter{LowercaseFilter{}}
+	if useStopWords {
+		filters = append(filters, NewStopWordFilter(StopWords))
+	}
+	if useStemming {
+		filters = append(filters, StemmerFilter{})
 	}
+	return NewCustomAnalyzer(NewTokenizer(), filters...)
 }
 
-// Analyze processes text and returns normalized tokens
-// This is the main entry point for text analysis
-func (a *Analyzer) Analyze(text string) []string {
-	// Step 1: Tokenize
-	tokens := a.tokenizer.Tokenize(text)
-	
-	// Step 2: Filter stop words (if enabled)
-	if a.useStopWords {
-		tokens = a.filterStopWords(tokens)
-	}
-	
-	// Step 3: Stem (if enabled) - TODO in future
-	if a.useStemming {
-		tokens = a.stem(tokens)
+// NewCustomAnalyzer creates an analyzer from a tokenizer and filters applied in order
+func NewCustomAnalyzer(tokenizer Tokenizer, filters ...TokenFilter) *Analyzer {
+	return &Analyzer{
+		tokenizer: tokenizer,
+		filters:   filters,
 	}
-	
-	return tokens
 }
 
-// AnalyzeWithPositions processes text and returns tokens with positions
-func (a *Analyzer) AnalyzeWithPositions(text string) ([]string, []int) {
-	// Tokenize with positions
-	tokens, positions := a.tokenizer.TokenizeWithPositions(text)
-	
-	// Filter stop words (need to adjust positions)
-	if a.useStopWords {
-		tokens, positions = a.filterStopWordsWithPositions(tokens, positions)
-	}
-	
-	return tokens, positions
+// AddFilter appends a filter to the end of the pipeline
+// Returns the analyzer so calls can be chained
+func (a *Analyzer) AddFilter(filter TokenFilter) *Analyzer {
+	a.filters = append(a.filters, filter)
+	return a
 }
 
-// filterStopWords removes stop words from tokens
-// In Go, we use a slice to build the result
-func (a *Analyzer) filterStopWords(tokens []string) []string {
-	var filtered []string
-	for _, token := range tokens {
-		// Check if token is NOT in stop words map
-		// In Go, accessing a map returns (value, exists)
-		if !StopWords[token] {
-			filtered = append(filtered, token)
-		}
-	}
-	return filtered
+// WithFilters returns a new analyzer with extra filters appended to this one's pipeline
+// The receiver is left unchanged, so it is safe to use on shared registry analyzers
+func (a *Analyzer) WithFilters(filters ...TokenFilter) *Analyzer {
+	combined := make([]TokenFilter, 0, len(a.filters)+len(filters))
+	combined = append(combined, a.filters...)
+	combined = append(combined, filters...)
+	return NewCustomAnalyzer(a.tokenizer, combined...)
 }
 
-// filterStopWordsWithPositions removes stop words and adjusts positions
-func (a *Analyzer) filterStopWordsWithPositions(tokens []string, positions []int) ([]string, []int) {
-	var filteredTokens []string
-	var filteredPositions []int
-	
-	for i, token := range tokens {
-		if !StopWords[token] {
-			filteredTokens = append(filteredTokens, token)
-			filteredPositions = append(filteredPositions, positions[i])
-		}
+// AnalyzeTokens runs the full pipeline and returns tokens with positions and offsets
+func (a *Analyzer) AnalyzeTokens(text string) []Token {
+	tokens := a.tokenizer.Tokens(text)
+	for _, filter := range a.filters {
+		tokens = filter.Filter(tokens)
 	}
-	
-	return filteredTokens, filteredPositions
+	return tokens
 }
 
-// stem applies stemming to tokens (Porter Stemmer - simplified version)
-// This is a placeholder for now - we'll implement proper stemming later
-func (a *Analyzer) stem(tokens []string) []string {
-	// Simple stemming: remove common suffixes
-	// Real implementation would use Porter Stemmer algorithm
-	stemmed := make([]string, len(tokens))
+// Analyze processes text and returns normalized tokens
+// This is the main entry point for text analysis
+func (a *Analyzer) Analyze(text string) []string {
+	tokens := a.AnalyzeTokens(text)
+	terms := make([]string, len(tokens))
 	for i, token := range tokens {
-		stemmed[i] = a.stemWord(token)
+		terms[i] = token.Term
 	}
-	return stemmed
+	return terms
 }
 
-// stemWord applies basic stemming to a single word
-func (a *Analyzer) stemWord(word string) string {
-	// Very basic stemming - remove common suffixes
-	// This is simplified - real stemmer is more complex
-	if len(word) > 3 {
-		if strings.HasSuffix(word, "ing") {
-			return word[:len(word)-3]
-		}
-		if strings.HasSuffix(word, "ed") {
-			return word[:len(word)-2]
-		}
-		if strings.HasSuffix(word, "s") && len(word) > 1 {
-			return word[:len(word)-1]
-		}
+// AnalyzeWithPositions processes text and returns tokens with their positions
+func (a *Analyzer) AnalyzeWithPositions(text string) ([]string, []int) {
+	tokens := a.AnalyzeTokens(text)
+	terms := make([]string, len(tokens))
+	positions := make([]int, len(tokens))
+	for i, token := range tokens {
+		terms[i] = token.Term
+		positions[i] = token.Position
 	}
-	return word
+	return terms, positions
 }
-
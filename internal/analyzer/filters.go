@@ -0,0 +1,151 @@
+package analyzer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// TokenFilter transforms a token stream
+// Filters can drop, rewrite, or add tokens, and are chained in any order by an Analyzer
+type TokenFilter interface {
+	Filter(tokens []Token) []Token
+}
+
+// TokenFilterFunc adapts an ordinary function to the TokenFilter interface
+type TokenFilterFunc func(tokens []Token) []Token
+
+// Filter implements TokenFilter
+func (f TokenFilterFunc) Filter(tokens []Token) []Token {
+	return f(tokens)
+}
+
+// LowercaseFilter lowercases every token
+type LowercaseFilter struct{}
+
+// Filter implements TokenFilter
+func (LowercaseFilter) Filter(tokens []Token) []Token {
+	for i := range tokens {
+		tokens[i].Term = strings.ToLower(tokens[i].Term)
+	}
+	return tokens
+}
+
+// StopWordFilter removes tokens found in a stop-word set
+// Positions of the remaining tokens are kept, leaving gaps where stop words were
+type StopWordFilter struct {
+	words map[string]bool
+}
+
+// NewStopWordFilter creates a stop-word filter over the given set
+func NewStopWordFilter(words map[string]bool) *StopWordFilter {
+	return &StopWordFilter{words: words}
+}
+
+// Filter implements TokenFilter
+func (f *StopWordFilter) Filter(tokens []Token) []Token {
+	filtered := tokens[:0]
+	for _, token := range tokens {
+		if !f.words[token.Term] {
+			filtered = append(filtered, token)
+		}
+	}
+	return filtered
+}
+
+// StemmerFilter reduces tokens to a crude stem by stripping common suffixes
+// This is a placeholder for now - a real implementation would use Porter Stemmer
+type StemmerFilter struct{}
+
+// Filter implements TokenFilter
+func (StemmerFilter) Filter(tokens []Token) []Token {
+	for i := range tokens {
+		tokens[i].Term = stemWord(tokens[i].Term)
+	}
+	return tokens
+}
+
+// stemWord applies basic stemming to a single word
+func stemWord(word string) string {
+	// Very basic stemming - remove common suffixes
+	// This is simplified - real stemmer is more complex
+	if len(word) > 3 {
+		if strings.HasSuffix(word, "ing") {
+			return word[:len(word)-3]
+		}
+		if strings.HasSuffix(word, "ed") {
+			return word[:len(word)-2]
+		}
+		if strings.HasSuffix(word, "s") && len(word) > 1 {
+			return word[:len(word)-1]
+		}
+	}
+	return word
+}
+
+// LengthFilter drops tokens shorter than Min or longer than Max characters
+// A Max of 0 means no upper bound
+type LengthFilter struct {
+	Min int
+	Max int
+}
+
+// Filter implements TokenFilter
+func (f LengthFilter) Filter(tokens []Token) []Token {
+	filtered := tokens[:0]
+	for _, token := range tokens {
+		n := utf8.RuneCountInString(token.Term)
+		if n < f.Min || (f.Max > 0 && n > f.Max) {
+			continue
+		}
+		filtered = append(filtered, token)
+	}
+	return filtered
+}
+
+// filterRegistry holds named filters so analyzers can be built from configuration
+var (
+	filterRegistry   = make(map[string]TokenFilter)
+	filterRegistryMu sync.RWMutex
+)
+
+func init() {
+	RegisterFilter("lowercase", LowercaseFilter{})
+	RegisterFilter("stop", NewStopWordFilter(StopWords))
+	for language, words := range languageStopWords {
+		RegisterFilter(language+"_stop", NewStopWordFilter(words))
+	}
+	RegisterFilter("stemmer", StemmerFilter{})
+	RegisterFilter("length", LengthFilter{Min: 1, Max: 255})
+	shingle, _ := NewShingleFilter(DefaultShingleMin, DefaultShingleMax, true)
+	RegisterFilter("shingle", shingle)
+}
+
+// RegisterFilter makes a filter available by name, replacing any existing one
+func RegisterFilter(name string, filter TokenFilter) {
+	filterRegistryMu.Lock()
+	defer filterRegistryMu.Unlock()
+	filterRegistry[name] = filter
+}
+
+// GetFilter looks up a registered filter by name
+func GetFilter(name string) (TokenFilter, bool) {
+	filterRegistryMu.RLock()
+	defer filterRegistryMu.RUnlock()
+	filter, ok := filterRegistry[name]
+	return filter, ok
+}
+
+// BuildAnalyzer creates an analyzer from a tokenizer and registered filter names
+func BuildAnalyzer(tokenizer Tokenizer, filterNames ...string) (*Analyzer, error) {
+	filters := make([]TokenFilter, 0, len(filterNames))
+	for _, name := range filterNames {
+		filter, ok := GetFilter(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown token filter: %s", name)
+		}
+		filters = append(filters, filter)
+	}
+	return NewCustomAnalyzer(tokenizer, filters...), nil
+}
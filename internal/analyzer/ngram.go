@@ -0,0 +1,108 @@
+package analyzer
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Default gram sizes, matching Elasticsearch's edge_ngram and ngram tokenizers
+const (
+	DefaultEdgeNGramMin = 2
+	DefaultEdgeNGramMax = 20
+	DefaultNGramMin     = 2
+	DefaultNGramMax     = 3
+)
+
+// EdgeNGramTokenizer splits text into words and emits prefixes of each word
+// between MinGram and MaxGram characters long, e.g. "gatsby" -> "ga", "gat", "gats", ...
+// It is meant for autocomplete fields, paired with a non-ngram search analyzer
+type EdgeNGramTokenizer struct {
+	MinGram int
+	MaxGram int
+	words   *StandardTokenizer
+}
+
+// NewEdgeNGramTokenizer creates an edge n-gram tokenizer
+func NewEdgeNGramTokenizer(minGram, maxGram int) (*EdgeNGramTokenizer, error) {
+	if err := validateGramSizes(minGram, maxGram); err != nil {
+		return nil, err
+	}
+	return &EdgeNGramTokenizer{MinGram: minGram, MaxGram: maxGram, words: NewTokenizer()}, nil
+}
+
+// Tokens implements Tokenizer
+// Every gram keeps the position of the word it came from
+func (t *EdgeNGramTokenizer) Tokens(text string) []Token {
+	var grams []Token
+	for _, word := range t.words.Tokens(text) {
+		runeEnds := runeBoundaries(word.Term)
+		for n := t.MinGram; n <= t.MaxGram && n <= len(runeEnds); n++ {
+			end := runeEnds[n-1]
+			grams = append(grams, Token{
+				Term:        word.Term[:end],
+				Position:    word.Position,
+				StartOffset: word.StartOffset,
+				EndOffset:   word.StartOffset + end,
+			})
+		}
+	}
+	return grams
+}
+
+// NGramTokenizer splits text into words and emits every substring of each word
+// between MinGram and MaxGram characters long, e.g. "quick" -> "qu", "qui", "ui", ...
+// This supports infix matching at the cost of a much larger term dictionary
+type NGramTokenizer struct {
+	MinGram int
+	MaxGram int
+	words   *StandardTokenizer
+}
+
+// NewNGramTokenizer creates an n-gram tokenizer
+func NewNGramTokenizer(minGram, maxGram int) (*NGramTokenizer, error) {
+	if err := validateGramSizes(minGram, maxGram); err != nil {
+		return nil, err
+	}
+	return &NGramTokenizer{MinGram: minGram, MaxGram: maxGram, words: NewTokenizer()}, nil
+}
+
+// Tokens implements Tokenizer
+func (t *NGramTokenizer) Tokens(text string) []Token {
+	var grams []Token
+	for _, word := range t.words.Tokens(text) {
+		// starts[i] is the byte offset of rune i; the final entry is len(word)
+		starts := append([]int{0}, runeBoundaries(word.Term)...)
+		runes := len(starts) - 1
+		for i := 0; i < runes; i++ {
+			for n := t.MinGram; n <= t.MaxGram && i+n <= runes; n++ {
+				grams = append(grams, Token{
+					Term:        word.Term[starts[i]:starts[i+n]],
+					Position:    word.Position,
+					StartOffset: word.StartOffset + starts[i],
+					EndOffset:   word.StartOffset + starts[i+n],
+				})
+			}
+		}
+	}
+	return grams
+}
+
+// runeBoundaries returns the byte offset just past each rune in s
+func runeBoundaries(s string) []int {
+	ends := make([]int, 0, utf8.RuneCountInString(s))
+	for i, r := range s {
+		ends = append(ends, i+utf8.RuneLen(r))
+	}
+	return ends
+}
+
+// validateGramSizes checks min/max gram settings
+func validateGramSizes(minGram, maxGram int) error {
+	if minGram < 1 {
+		return fmt.Errorf("min gram must be at least 1, got %d", minGram)
+	}
+	if maxGram < minGram {
+		return fmt.Errorf("max gram (%d) must be >= min gram (%d)", maxGram, minGram)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package analyzer
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Names of the built-in analyzers
+const (
+	StandardAnalyzer   = "standard"   // Standard tokenizer, lowercase, English stop words
+	SimpleAnalyzer     = "simple"     // Standard tokenizer, lowercase
+	WhitespaceAnalyzer = "whitespace" // Split on whitespace only, no normalization
+	KeywordAnalyzer    = "keyword"    // Whole input as a single exact token
+	EnglishAnalyzer    = "english"    // Standard analyzer plus stemming
+	EdgeNGramAnalyzer  = "edge_ngram" // Lowercased word prefixes, for autocomplete
+	NGramAnalyzer      = "ngram"      // Lowercased word substrings, for infix matching
+)
+
+// registry maps analyzer names to shared analyzer instances
+// Analyzers are stateless, so one instance can serve every field that uses it
+var (
+	registry   = make(map[string]*Analyzer)
+	registryMu sync.RWMutex
+)
+
+func init() {
+	Register(StandardAnalyzer, NewAnalyzer())
+	Register(SimpleAnalyzer, NewCustomAnalyzer(NewTokenizer(), LowercaseFilter{}))
+	Register(WhitespaceAnalyzer, NewCustomAnalyzer(WhitespaceTokenizer{}))
+	Register(KeywordAnalyzer, NewCustomAnalyzer(KeywordTokenizer{}))
+	Register(EnglishAnalyzer, NewAnalyzerWithOptions(true, true))
+
+	// N-gram analyzers with default gram sizes; register custom ones for other sizes
+	edge, _ := NewEdgeNGramTokenizer(DefaultEdgeNGramMin, DefaultEdgeNGramMax)
+	Register(EdgeNGramAnalyzer, NewCustomAnalyzer(edge, LowercaseFilter{}))
+	ngram, _ := NewNGramTokenizer(DefaultNGramMin, DefaultNGramMax)
+	Register(NGramAnalyzer, NewCustomAnalyzer(ngram, LowercaseFilter{}))
+
+	// Language packs without stemming support yet only remove stop words
+	Register("spanish", NewAnalyzerWithStopWords(SpanishStopWords))
+	Register("german", NewAnalyzerWithStopWords(GermanStopWords))
+	Register("french", NewAnalyzerWithStopWords(FrenchStopWords))
+}
+
+// Register makes an analyzer available by name, replacing any existing one
+func Register(name string, a *Analyzer) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = a
+}
+
+// Get looks up a registered analyzer by name
+func Get(name string) (*Analyzer, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	a, ok := registry[name]
+	return a, ok
+}
+
+// Lookup looks up a registered analyzer, returning an error if it is not registered
+func Lookup(name string) (*Analyzer, error) {
+	a, ok := Get(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown analyzer: %s", name)
+	}
+	return a, nil
+}
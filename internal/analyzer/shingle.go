@@ -0,0 +1,85 @@
+package analyzer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Default shingle settings, matching Elasticsearch's shingle token filter
+const (
+	DefaultShingleMin       = 2
+	DefaultShingleMax       = 2
+	DefaultShingleSeparator = " "
+)
+
+// ShingleFilter adds word n-grams ("shingles") of MinSize to MaxSize tokens,
+// e.g. "quick brown fox" -> "quick brown", "brown fox"
+// Each shingle is at the position of its first word and spans one position
+// per word (see Token.PositionLength), so phrase queries can match a path of
+// shingles and words. Shingles don't span gaps left by removed stop words
+// Indexing shingles makes phrase-like queries cheap and gives the phrase
+// suggester and relevance tuning word-pair terms to work with
+type ShingleFilter struct {
+	MinSize        int
+	MaxSize        int
+	OutputUnigrams bool   // Keep the single words as well as the shingles
+	Separator      string // Between the words of a shingle
+}
+
+// NewShingleFilter creates a shingle filter joining words with a space
+func NewShingleFilter(minSize, maxSize int, outputUnigrams bool) (*ShingleFilter, error) {
+	if minSize < 2 {
+		return nil, fmt.Errorf("min shingle size must be at least 2, got %d", minSize)
+	}
+	if maxSize < minSize {
+		return nil, fmt.Errorf("max shingle size (%d) must be >= min shingle size (%d)", maxSize, minSize)
+	}
+	return &ShingleFilter{
+		MinSize:        minSize,
+		MaxSize:        maxSize,
+		OutputUnigrams: outputUnigrams,
+		Separator:      DefaultShingleSeparator,
+	}, nil
+}
+
+// Filter implements TokenFilter
+// Shingles are built from the first token at each position; other tokens at
+// the position (e.g. synonyms) are kept as unigrams only
+func (f *ShingleFilter) Filter(tokens []Token) []Token {
+	var words []Token
+	for _, token := range tokens {
+		if len(words) == 0 || words[len(words)-1].Position != token.Position {
+			words = append(words, token)
+		}
+	}
+
+	shingled := make([]Token, 0, len(tokens)*(f.MaxSize-f.MinSize+2))
+	next := 0
+	for i, word := range words {
+		for ; next < len(tokens) && tokens[next].Position == word.Position; next++ {
+			if f.OutputUnigrams {
+				shingled = append(shingled, tokens[next])
+			}
+		}
+
+		terms := []string{word.Term}
+		for n := 2; n <= f.MaxSize && i+n <= len(words); n++ {
+			last := words[i+n-1]
+			if last.Position != word.Position+n-1 {
+				break
+			}
+			terms = append(terms, last.Term)
+			if n < f.MinSize {
+				continue
+			}
+			shingled = append(shingled, Token{
+				Term:           strings.Join(terms, f.Separator),
+				Position:       word.Position,
+				PositionLength: n,
+				StartOffset:    word.StartOffset,
+				EndOffset:      last.EndOffset,
+			})
+		}
+	}
+	return shingled
+}
@@ -0,0 +1,100 @@
+package analyzer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Built-in stop-word lists for the language packs
+// These are deliberately short lists of the most frequent function words
+
+// EnglishStopWords is the default English stop-word list
+var EnglishStopWords = StopWords
+
+// SpanishStopWords is a list of common Spanish stop words
+var SpanishStopWords = NewStopWordSet(
+	"a", "al", "como", "con", "de", "del", "el", "en", "es", "esta",
+	"la", "las", "lo", "los", "más", "mi", "no", "o", "para", "pero",
+	"por", "que", "se", "si", "sin", "su", "sus", "un", "una", "y",
+)
+
+// GermanStopWords is a list of common German stop words
+var GermanStopWords = NewStopWordSet(
+	"aber", "als", "am", "an", "auch", "auf", "aus", "bei", "das", "dem",
+	"den", "der", "des", "die", "ein", "eine", "einem", "einen", "einer", "es",
+	"für", "im", "in", "ist", "mit", "nicht", "oder", "sich", "und", "von",
+	"zu", "zum", "zur",
+)
+
+// FrenchStopWords is a list of common French stop words
+var FrenchStopWords = NewStopWordSet(
+	"au", "aux", "avec", "ce", "ces", "dans", "de", "des", "du", "elle",
+	"en", "et", "il", "je", "la", "le", "les", "leur", "mais", "ne",
+	"nous", "ou", "par", "pas", "pour", "qui", "que", "sa", "se", "son",
+	"sur", "un", "une", "vous",
+)
+
+// languageStopWords maps language names to their built-in stop-word lists
+var languageStopWords = map[string]map[string]bool{
+	"english": EnglishStopWords,
+	"spanish": SpanishStopWords,
+	"german":  GermanStopWords,
+	"french":  FrenchStopWords,
+}
+
+// NewStopWordSet builds a stop-word set from a list of words
+// Words are lowercased so the set matches the output of LowercaseFilter
+func NewStopWordSet(words ...string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		set[strings.ToLower(w)] = true
+	}
+	return set
+}
+
+// LanguageStopWords returns the built-in stop-word list for a language
+func LanguageStopWords(language string) (map[string]bool, bool) {
+	set, ok := languageStopWords[strings.ToLower(language)]
+	return set, ok
+}
+
+// LoadStopWords reads a stop-word set from a file
+// The format is one word per line; blank lines and lines starting with # are ignored
+func LoadStopWords(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stop-word file: %w", err)
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stop-word file: %w", err)
+	}
+
+	return NewStopWordSet(words...), nil
+}
+
+// NewAnalyzerWithStopWords creates a standard analyzer using a custom stop-word set
+func NewAnalyzerWithStopWords(words map[string]bool) *Analyzer {
+	return NewCustomAnalyzer(NewTokenizer(), LowercaseFilter{}, NewStopWordFilter(words))
+}
+
+// NewLanguageAnalyzer creates a standard analyzer with a built-in language's stop words
+func NewLanguageAnalyzer(language string) (*Analyzer, error) {
+	words, ok := LanguageStopWords(language)
+	if !ok {
+		return nil, fmt.Errorf("no stop-word list for language: %s", language)
+	}
+	return NewAnalyzerWithStopWords(words), nil
+}
@@ -0,0 +1,215 @@
+package analyzer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// SynonymFilter expands tokens with their synonyms
+// Single-word synonyms are emitted at the same position and offsets as the
+// original token, so phrase queries and highlighting still line up with the
+// source text
+//
+// Multi-word rules such as "ny, new york" match runs of tokens at consecutive
+// positions and produce a token graph: the longest replacement takes one
+// position per word, and shorter ones end with a token spanning the rest (see
+// Token.PositionLength), so "ny" spans both positions of "new york". Tokens
+// after the match move by the difference in length, and phrase queries match
+// any path through the graph
+//
+// Put it in a field's index analyzer to expand postings at index time, or in
+// its search analyzer to expand query terms at query time
+type SynonymFilter struct {
+	rules    map[string][][]string // Space-joined terms -> words of each replacement (may include the terms themselves)
+	maxWords int                   // Longest rule input, in words
+}
+
+// NewSynonymFilter creates a synonym filter from parsed rules
+func NewSynonymFilter(rules map[string][]string) *SynonymFilter {
+	f := &SynonymFilter{rules: make(map[string][][]string, len(rules))}
+	for from, to := range rules {
+		f.maxWords = max(f.maxWords, len(strings.Fields(from)))
+		key := strings.Join(strings.Fields(from), " ")
+		for _, replacement := range to {
+			f.rules[key] = append(f.rules[key], strings.Fields(replacement))
+		}
+	}
+	return f
+}
+
+// Filter implements TokenFilter
+func (f *SynonymFilter) Filter(tokens []Token) []Token {
+	expanded := make([]Token, 0, len(tokens))
+	shift := 0 // Positions added (or removed) by earlier multi-word matches
+	for i := 0; i < len(tokens); {
+		n, key := f.match(tokens[i:])
+		if n == 0 {
+			token := tokens[i]
+			token.Position += shift
+			expanded = append(expanded, token)
+			i++
+			continue
+		}
+		var added int
+		expanded, added = appendSynonyms(expanded, tokens[i:i+n], key, f.rules[key], shift)
+		shift += added
+		i += n
+	}
+	return expanded
+}
+
+// match finds the longest rule matching the start of tokens and returns its
+// length in tokens and its key, or 0 if none matches
+// A rule's words must be at consecutive positions, so a match doesn't span a
+// removed stop word
+func (f *SynonymFilter) match(tokens []Token) (int, string) {
+	n, key := 0, ""
+	words := make([]string, 0, f.maxWords)
+	for j := 0; j < len(tokens) && j < f.maxWords; j++ {
+		if j > 0 && tokens[j].Position != tokens[0].Position+j {
+			break
+		}
+		words = append(words, tokens[j].Term)
+		joined := strings.Join(words, " ")
+		if _, ok := f.rules[joined]; ok {
+			n, key = j+1, joined
+		}
+	}
+	return n, key
+}
+
+// appendSynonyms appends the replacements of matched tokens as a token graph
+// and returns how many positions the graph adds to the stream
+// Words of a replacement equal to the matched terms keep their own offsets;
+// other words get the offsets of the whole match
+func appendSynonyms(out []Token, matched []Token, key string, replacements [][]string, shift int) ([]Token, int) {
+	span := 0
+	for _, words := range replacements {
+		span = max(span, len(words))
+	}
+
+	base := matched[0]
+	base.Position += shift
+	base.PositionLength = 0
+	base.EndOffset = matched[len(matched)-1].EndOffset
+
+	first := len(out)
+	for _, words := range replacements {
+		original := strings.Join(words, " ") == key
+		for j, word := range words {
+			token := base
+			token.Term = word
+			token.Position = base.Position + j
+			if original {
+				token.StartOffset, token.EndOffset = matched[j].StartOffset, matched[j].EndOffset
+			}
+			if j == len(words)-1 && span-j > 1 {
+				token.PositionLength = span - j // The last word spans the positions left
+			}
+			out = append(out, token)
+		}
+	}
+	graph := out[first:]
+	sort.SliceStable(graph, func(a, b int) bool { return graph[a].Position < graph[b].Position })
+	return out, span - len(matched)
+}
+
+// ParseSynonymRules parses synonym rules in the Solr/Elasticsearch format:
+//
+//	car, automobile, auto     equivalent terms, each expands to all of them
+//	sneakers, trainers => shoe explicit mapping, left side is replaced by right side
+//	ny, new york              multi-word terms are matched as consecutive tokens
+//
+// Blank lines and lines starting with # are ignored. Terms are lowercased,
+// so the filter should run after LowercaseFilter. The words of multi-word
+// terms are separated by single spaces in the returned rules
+func ParseSynonymRules(r io.Reader) (map[string][]string, error) {
+	rules := make(map[string][]string)
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var from, to []string
+		var err error
+		if lhs, rhs, ok := strings.Cut(line, "=>"); ok {
+			if from, err = parseSynonymTerms(lhs); err != nil {
+				return nil, fmt.Errorf("synonym rule line %d: %w", lineNum, err)
+			}
+			if to, err = parseSynonymTerms(rhs); err != nil {
+				return nil, fmt.Errorf("synonym rule line %d: %w", lineNum, err)
+			}
+		} else {
+			if from, err = parseSynonymTerms(line); err != nil {
+				return nil, fmt.Errorf("synonym rule line %d: %w", lineNum, err)
+			}
+			to = from
+		}
+
+		for _, term := range from {
+			rules[term] = appendUnique(rules[term], to...)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read synonym rules: %w", err)
+	}
+	return rules, nil
+}
+
+// LoadSynonymFilter reads synonym rules from a file and builds a filter
+func LoadSynonymFilter(path string) (*SynonymFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open synonym file: %w", err)
+	}
+	defer file.Close()
+
+	rules, err := ParseSynonymRules(file)
+	if err != nil {
+		return nil, err
+	}
+	return NewSynonymFilter(rules), nil
+}
+
+// parseSynonymTerms splits a comma-separated list of synonym terms
+func parseSynonymTerms(list string) ([]string, error) {
+	var terms []string
+	for _, part := range strings.Split(list, ",") {
+		term := strings.Join(strings.Fields(strings.ToLower(part)), " ")
+		if term == "" {
+			continue
+		}
+		terms = append(terms, term)
+	}
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("empty synonym list")
+	}
+	return terms, nil
+}
+
+// appendUnique appends terms that are not already present
+func appendUnique(list []string, terms ...string) []string {
+	for _, term := range terms {
+		found := false
+		for _, existing := range list {
+			if existing == term {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, term)
+		}
+	}
+	return list
+}
@@ -5,84 +5,132 @@ import (
 	"unicode"
 )
 
-// Tokenizer splits text into tokens (words)
-type Tokenizer struct {
+// Token is a single term produced by a tokenizer, along with where it came from
+type Token struct {
+	Term           string // The token text
+	Position       int    // Ordinal position in the token stream (used by phrase queries)
+	PositionLength int    // Positions the token spans, e.g. a shingle or multi-word synonym (0 means 1)
+	StartOffset    int    // Byte offset of the first character in the source text
+	EndOffset      int    // Byte offset just past the last character in the source text
+}
+
+// Span returns the number of positions the token covers, at least 1
+// Tokens spanning several positions turn the token stream into a graph, where
+// each path from the first position to the last is one reading of the text
+func (t Token) Span() int {
+	return max(t.PositionLength, 1)
+}
+
+// Tokenizer splits text into a stream of tokens
+// It is the first stage of an analyzer, before any TokenFilter runs
+type Tokenizer interface {
+	Tokens(text string) []Token
+}
+
+// StandardTokenizer splits text into tokens (words) on non-letter, non-digit characters
+type StandardTokenizer struct {
 	// In Go, we can add fields here for configuration later
 }
 
-// NewTokenizer creates a new tokenizer
-func NewTokenizer() *Tokenizer {
-	return &Tokenizer{}
+// NewTokenizer creates a new standard tokenizer
+func NewTokenizer() *StandardTokenizer {
+	return &StandardTokenizer{}
 }
 
-// Tokenize splits text into tokens
-// In Go, strings are immutable, so we work with byte slices or strings
-func (t *Tokenizer) Tokenize(text string) []string {
-	// Split on whitespace and punctuation
-	// Go's strings.Fields splits on whitespace, but we want more control
-	
-	// Convert to lowercase first (case-insensitive search)
-	text = strings.ToLower(text)
-	
-	// Split into words
-	// We'll use a simple approach: split on non-letter characters
-	var tokens []string
-	var current strings.Builder
-	
+// Tokens splits text into tokens without normalizing them
+// Case folding is left to LowercaseFilter so the pipeline controls it
+func (t *StandardTokenizer) Tokens(text string) []Token {
+	var tokens []Token
+	start := -1
+
 	// Iterate over each rune (Unicode character) in the string
-	// In Go, strings are UTF-8, so we use range to get runes
-	for _, r := range text {
+	// In Go, strings are UTF-8, so range gives us byte offsets and runes
+	for i, r := range text {
 		if unicode.IsLetter(r) || unicode.IsDigit(r) {
-			// Accumulate letters and digits
-			current.WriteRune(r)
-		} else {
-			// Non-letter/digit found, save current token if any
-			if current.Len() > 0 {
-				tokens = append(tokens, current.String())
-				current.Reset()
+			if start < 0 {
+				// Start of new token, record offset
+				start = i
 			}
+		} else if start >= 0 {
+			// Non-letter/digit found, save current token
+			tokens = append(tokens, Token{
+				Term:        text[start:i],
+				Position:    len(tokens),
+				StartOffset: start,
+				EndOffset:   i,
+			})
+			start = -1
 		}
 	}
-	
+
 	// Don't forget the last token
-	if current.Len() > 0 {
-		tokens = append(tokens, current.String())
+	if start >= 0 {
+		tokens = append(tokens, Token{
+			Term:        text[start:],
+			Position:    len(tokens),
+			StartOffset: start,
+			EndOffset:   len(text),
+		})
 	}
-	
+
 	return tokens
 }
 
-// TokenizeWithPositions splits text into tokens and returns their positions
-// Returns: tokens and their positions (0-indexed)
-func (t *Tokenizer) TokenizeWithPositions(text string) ([]string, []int) {
-	text = strings.ToLower(text)
-	
-	var tokens []string
-	var positions []int
-	var current strings.Builder
-	position := 0
-	
+// Tokenize splits text into lowercase tokens
+// This is a convenience wrapper around Tokens for callers that only need terms
+func (t *StandardTokenizer) Tokenize(text string) []string {
+	tokens := t.Tokens(text)
+	terms := make([]string, len(tokens))
+	for i, token := range tokens {
+		terms[i] = strings.ToLower(token.Term)
+	}
+	return terms
+}
+
+// TokenizeWithPositions splits text into lowercase tokens and returns their positions
+// Returns: tokens and their ordinal positions (0-indexed)
+func (t *StandardTokenizer) TokenizeWithPositions(text string) ([]string, []int) {
+	tokens := t.Tokens(text)
+	terms := make([]string, len(tokens))
+	positions := make([]int, len(tokens))
+	for i, token := range tokens {
+		terms[i] = strings.ToLower(token.Term)
+		positions[i] = token.Position
+	}
+	return terms, positions
+}
+
+// KeywordTokenizer emits the entire input as a single token
+// Used for fields that should match exactly, without being split into words
+type KeywordTokenizer struct{}
+
+// Tokens implements Tokenizer
+func (KeywordTokenizer) Tokens(text string) []Token {
+	if text == "" {
+		return nil
+	}
+	return []Token{{Term: text, Position: 0, StartOffset: 0, EndOffset: len(text)}}
+}
+
+// WhitespaceTokenizer splits text on whitespace only, keeping punctuation
+type WhitespaceTokenizer struct{}
+
+// Tokens implements Tokenizer
+func (WhitespaceTokenizer) Tokens(text string) []Token {
+	var tokens []Token
+	start := -1
 	for i, r := range text {
-		if unicode.IsLetter(r) || unicode.IsDigit(r) {
-			if current.Len() == 0 {
-				// Start of new token, record position
-				position = i
-			}
-			current.WriteRune(r)
-		} else {
-			if current.Len() > 0 {
-				tokens = append(tokens, current.String())
-				positions = append(positions, position)
-				current.Reset()
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				tokens = append(tokens, Token{Term: text[start:i], Position: len(tokens), StartOffset: start, EndOffset: i})
+				start = -1
 			}
+		} else if start < 0 {
+			start = i
 		}
 	}
-	
-	if current.Len() > 0 {
-		tokens = append(tokens, current.String())
-		positions = append(positions, position)
+	if start >= 0 {
+		tokens = append(tokens, Token{Term: text[start:], Position: len(tokens), StartOffset: start, EndOffset: len(text)})
 	}
-	
-	return tokens, positions
+	return tokens
 }
-
@@ -0,0 +1,325 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"nano-elastic/internal/search"
+)
+
+// aliasesFile is the name of the alias table kept in the data directory
+const aliasesFile = "aliases.json"
+
+// AliasActionType is the operation of one alias action
+type AliasActionType string
+
+const (
+	AliasAdd    AliasActionType = "add"    // Point the alias at the index too
+	AliasRemove AliasActionType = "remove" // Stop pointing the alias at the index
+)
+
+// AliasAction adds or removes one alias-to-index link
+type AliasAction struct {
+	Type  AliasActionType
+	Alias string
+	Index string
+}
+
+// AliasError is returned for invalid alias actions and for writes through
+// aliases that don't resolve to exactly one index
+type AliasError struct {
+	Alias  string
+	Reason string
+}
+
+func (e *AliasError) Error() string {
+	return fmt.Sprintf("alias [%s]: %s", e.Alias, e.Reason)
+}
+
+// UpdateAliases applies a list of actions atomically: either all of them take
+// effect or none do, and readers never see a state in between
+// Re-pointing an alias is a remove and an add in one call:
+//
+//	e.UpdateAliases([]AliasAction{
+//		{Type: AliasRemove, Alias: "products", Index: "products-v1"},
+//		{Type: AliasAdd, Alias: "products", Index: "products-v2"},
+//	})
+func (e *Engine) UpdateAliases(actions []AliasAction) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Apply to a copy so a failed action leaves the current aliases untouched
+	next := make(map[string]map[string]bool, len(e.aliases))
+	for alias, names := range e.aliases {
+		next[alias] = make(map[string]bool, len(names))
+		for _, name := range names {
+			next[alias][name] = true
+		}
+	}
+
+	for _, action := range actions {
+		if err := ValidateIndexName(action.Alias); err != nil {
+			return &AliasError{Alias: action.Alias, Reason: "invalid alias name"}
+		}
+		if _, ok := e.indexes[action.Alias]; ok {
+			return &AliasError{Alias: action.Alias, Reason: "an index with the same name exists"}
+		}
+		if _, ok := e.indexes[action.Index]; !ok {
+			return &IndexNotFoundError{Name: action.Index}
+		}
+
+		switch action.Type {
+		case AliasAdd:
+			if next[action.Alias] == nil {
+				next[action.Alias] = make(map[string]bool)
+			}
+			next[action.Alias][action.Index] = true
+		case AliasRemove:
+			if !next[action.Alias][action.Index] {
+				return &AliasError{Alias: action.Alias, Reason: fmt.Sprintf("not pointing at index [%s]", action.Index)}
+			}
+			delete(next[action.Alias], action.Index)
+		default:
+			return fmt.Errorf("unknown alias action %q", action.Type)
+		}
+	}
+
+	aliases := make(map[string][]string, len(next))
+	for alias, set := range next {
+		if len(set) == 0 {
+			continue
+		}
+		names := make([]string, 0, len(set))
+		for name := range set {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		aliases[alias] = names
+	}
+
+	if err := e.writeAliases(aliases); err != nil {
+		return err
+	}
+	e.aliases = aliases
+	return nil
+}
+
+// Aliases returns every alias with the indexes it points at
+func (e *Engine) Aliases() map[string][]string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	aliases := make(map[string][]string, len(e.aliases))
+	for alias, names := range e.aliases {
+		aliases[alias] = append([]string(nil), names...)
+	}
+	return aliases
+}
+
+// ResolveIndexes returns the index with the given name, or every index the
+// alias with that name points at, sorted by name
+func (e *Engine) ResolveIndexes(name string) ([]*Index, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if idx, ok := e.indexes[name]; ok {
+		return []*Index{idx}, nil
+	}
+	names, ok := e.aliases[name]
+	if !ok {
+		return nil, &IndexNotFoundError{Name: name}
+	}
+
+	indexes := make([]*Index, 0, len(names))
+	for _, n := range names {
+		indexes = append(indexes, e.indexes[n])
+	}
+	return indexes, nil
+}
+
+// ResolveIndex returns the index with the given name, or the single index an
+// alias points at; used for reads and writes of individual documents
+func (e *Engine) ResolveIndex(name string) (*Index, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.resolveIndex(name)
+}
+
+// resolveIndex resolves a name to one index
+// Caller must hold e.mu
+func (e *Engine) resolveIndex(name string) (*Index, error) {
+	if idx, ok := e.indexes[name]; ok {
+		return idx, nil
+	}
+	names, ok := e.aliases[name]
+	if !ok {
+		return nil, &IndexNotFoundError{Name: name}
+	}
+	if len(names) != 1 {
+		return nil, &AliasError{Alias: name, Reason: fmt.Sprintf("points at %d indexes, expected exactly one", len(names))}
+	}
+	return e.indexes[names[0]], nil
+}
+
+// Search runs a request against an index, or against every index behind an alias
+// Hits from several indexes are merged in sort order and carry their index name
+func (e *Engine) Search(name string, req *search.Request) (*search.Response, error) {
+	return e.SearchContext(context.Background(), name, req)
+}
+
+// SearchContext is Search, stopped with the context's error once it is cancelled
+// The request's Timeout bounds the whole search, across every index
+func (e *Engine) SearchContext(ctx context.Context, name string, req *search.Request) (*search.Response, error) {
+	indexes, err := e.ResolveIndexes(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(indexes) == 1 {
+		resp, err := indexes[0].SearchContext(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		for i := range resp.Hits {
+			resp.Hits[i].Index = indexes[0].Name
+		}
+		return resp, nil
+	}
+
+	start := time.Now()
+	resp, err := searchIndexes(ctx, indexes, req)
+	for _, idx := range indexes {
+		idx.hooks.runSearch(SearchEvent{Index: idx.Name, Request: req, Response: resp, Err: err, Took: req.ParseTime + time.Since(start)})
+	}
+	return resp, err
+}
+
+// searchIndexes runs a search across several indexes, merging their hits
+func searchIndexes(ctx context.Context, indexes []*Index, req *search.Request) (*search.Response, error) {
+	// The smallest max result window among the indexes applies
+	maxResultWindow := 0
+	for _, idx := range indexes {
+		idx.mu.RLock()
+		if maxResultWindow == 0 || idx.maxResultWindow < maxResultWindow {
+			maxResultWindow = idx.maxResultWindow
+		}
+		idx.mu.RUnlock()
+	}
+	if err := req.Validate(maxResultWindow); err != nil {
+		return nil, err
+	}
+	if req.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, req.Timeout)
+		defer cancel()
+	}
+
+	byName := make(map[string]*Index, len(indexes))
+	shards := make([]*search.Response, 0, len(indexes))
+	for _, idx := range indexes {
+		shard, err := idx.collectShard(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		byName[idx.Name] = idx
+		shards = append(shards, shard)
+	}
+
+	resp, err := search.Merge(req, shards)
+	if err != nil {
+		return nil, err
+	}
+	profiles := make(map[string]*search.Profile, len(resp.Profile))
+	for _, p := range resp.Profile {
+		profiles[p.Index] = p
+	}
+	for i := range resp.Hits {
+		fetchStart := time.Now()
+		if err := byName[resp.Hits[i].Index].fetchHit(req, &resp.Hits[i]); err != nil {
+			return nil, err
+		}
+		if p := profiles[resp.Hits[i].Index]; p != nil {
+			p.Fetch += time.Since(fetchStart)
+		}
+	}
+	return resp, nil
+}
+
+// Count returns the number of documents matching a query in an index, or in
+// every index behind an alias; a nil query counts every document
+func (e *Engine) Count(name string, query search.Query) (int, error) {
+	return e.CountContext(context.Background(), name, query)
+}
+
+// CountContext is Count, stopped with the context's error once it is cancelled
+func (e *Engine) CountContext(ctx context.Context, name string, query search.Query) (int, error) {
+	indexes, err := e.ResolveIndexes(name)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, idx := range indexes {
+		count, err := idx.CountContext(ctx, query)
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// aliasesWithout returns the aliases with an index removed
+// Caller must hold e.mu
+func (e *Engine) aliasesWithout(index string) map[string][]string {
+	aliases := make(map[string][]string, len(e.aliases))
+	for alias, names := range e.aliases {
+		var kept []string
+		for _, name := range names {
+			if name != index {
+				kept = append(kept, name)
+			}
+		}
+		if len(kept) > 0 {
+			aliases[alias] = kept
+		}
+	}
+	return aliases
+}
+
+// readAliases loads the alias table, which is absent until an alias is created
+func (e *Engine) readAliases() (map[string][]string, error) {
+	aliases := make(map[string][]string)
+	data, err := e.fs.ReadFile(filepath.Join(e.dataPath, aliasesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return aliases, nil
+		}
+		return nil, fmt.Errorf("failed to read aliases: %w", err)
+	}
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return nil, fmt.Errorf("failed to decode aliases: %w", err)
+	}
+	return aliases, nil
+}
+
+// writeAliases saves the alias table atomically (temp file and rename)
+func (e *Engine) writeAliases(aliases map[string][]string) error {
+	data, err := json.MarshalIndent(aliases, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode aliases: %w", err)
+	}
+
+	path := filepath.Join(e.dataPath, aliasesFile)
+	if err := e.fs.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write aliases: %w", err)
+	}
+	if err := e.fs.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to commit aliases: %w", err)
+	}
+	return nil
+}
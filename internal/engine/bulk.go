@@ -0,0 +1,273 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"nano-elastic/internal/errdefs"
+	"nano-elastic/internal/storage"
+	"nano-elastic/internal/types"
+)
+
+// DefaultBulkBatchSize is the number of items a BulkIndexer buffers before
+// writing them when the caller doesn't choose a size
+const DefaultBulkBatchSize = 1000
+
+// BulkAction is the operation performed by one bulk item
+type BulkAction string
+
+const (
+	BulkIndex  BulkAction = "index"  // Store the document, replacing any existing version
+	BulkCreate BulkAction = "create" // Store the document, failing if the ID already exists
+	BulkDelete BulkAction = "delete" // Delete the document
+)
+
+// Bulk item results
+const (
+	ResultCreated  = "created"
+	ResultUpdated  = "updated"
+	ResultDeleted  = "deleted"
+	ResultNotFound = "not_found"
+)
+
+// BulkItem is one operation of a bulk request
+// Index and create items carry a document; delete items only an ID
+type BulkItem struct {
+	Action   BulkAction
+	ID       string
+	Document *types.Document
+}
+
+// BulkResult is the outcome of one bulk item
+type BulkResult struct {
+	Action BulkAction
+	ID     string
+	Result string // One of the Result constants, empty if Err is set
+	Err    error
+}
+
+// DocumentExistsError is the result of creating a document whose ID is taken
+type DocumentExistsError struct {
+	ID string
+}
+
+func (e *DocumentExistsError) Error() string {
+	return fmt.Sprintf("document already exists: %s", e.ID)
+}
+
+// Is matches errdefs.ErrVersionConflict
+func (e *DocumentExistsError) Is(target error) bool {
+	return target == errdefs.ErrVersionConflict
+}
+
+// Bulk applies items in order as a single storage batch: one WAL sync for
+// the whole request instead of one per document
+// Item failures (schema validation, create conflicts, bad actions) are
+// reported in the results and don't stop the other items; the error is a
+// storage failure
+func (idx *Index) Bulk(items []BulkItem) ([]BulkResult, error) {
+	return idx.BulkContext(context.Background(), items)
+}
+
+// BulkContext is Bulk, stopped with the context's error if it is done before
+// the batch is written to the WAL; no item is applied then
+func (idx *Index) BulkContext(ctx context.Context, items []BulkItem) ([]BulkResult, error) {
+	// Hooks run before the write locks are taken, and after they are released
+	rejected := make(map[int]error)
+	for i, item := range items {
+		if item.Document != nil && (item.Action == BulkIndex || item.Action == BulkCreate) {
+			if err := idx.hooks.runBeforeIndex(item.Document); err != nil {
+				rejected[i] = err
+			}
+		}
+	}
+	results, err := idx.bulk(ctx, items, rejected)
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range results {
+		switch {
+		case result.Err != nil:
+		case result.Result == ResultCreated || result.Result == ResultUpdated:
+			idx.hooks.runAfterIndex(items[i].Document)
+		case result.Result == ResultDeleted:
+			idx.hooks.runDelete(result.ID)
+		}
+	}
+	return results, nil
+}
+
+// bulk applies the items as one storage batch, failing the rejected ones with
+// their errors
+func (idx *Index) bulk(ctx context.Context, items []BulkItem, rejected map[int]error) ([]BulkResult, error) {
+	// No single-document write may be between its storage write and its
+	// in-memory update while existence is checked against idx.docIDs
+	idx.lockAllWrites()
+	defer idx.unlockAllWrites()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	results := make([]BulkResult, len(items))
+	ops := make([]storage.BatchOp, 0, len(items))
+	opItems := make([]int, 0, len(items)) // Item index of each op
+
+	// Existence as of each item, so later items see earlier ones in the batch
+	exists := make(map[string]bool)
+	docExists := func(id string) bool {
+		if e, ok := exists[id]; ok {
+			return e
+		}
+		if doc, ok := idx.pending[id]; ok {
+			return doc != nil
+		}
+		_, ok := idx.docIDs[id]
+		return ok
+	}
+
+	for i, item := range items {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		id := item.ID
+		if item.Document != nil {
+			id = item.Document.ID
+		}
+		results[i] = BulkResult{Action: item.Action, ID: id}
+		if err := rejected[i]; err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		switch item.Action {
+		case BulkIndex, BulkCreate:
+			if item.Document == nil {
+				results[i].Err = fmt.Errorf("%s requires a document", item.Action)
+				continue
+			}
+			if idx.Schema != nil {
+				if err := idx.Schema.ApplyDefaults(item.Document); err != nil {
+					results[i].Err = err
+					continue
+				}
+				if err := idx.Schema.ValidateDocument(item.Document); err != nil {
+					results[i].Err = err
+					continue
+				}
+			}
+			if docExists(id) {
+				if item.Action == BulkCreate {
+					results[i].Err = &DocumentExistsError{ID: id}
+					continue
+				}
+				results[i].Result = ResultUpdated
+			} else {
+				results[i].Result = ResultCreated
+			}
+			exists[id] = true
+			ops = append(ops, storage.BatchOp{ID: id, Document: item.Document})
+
+		case BulkDelete:
+			if !docExists(id) {
+				results[i].Result = ResultNotFound
+				continue
+			}
+			results[i].Result = ResultDeleted
+			exists[id] = false
+			ops = append(ops, storage.BatchOp{Delete: true, ID: id})
+
+		default:
+			results[i].Err = fmt.Errorf("unknown bulk action %q", item.Action)
+			continue
+		}
+		opItems = append(opItems, i)
+	}
+
+	errs, err := idx.store.WriteBatchContext(ctx, ops)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write batch: %w", err)
+	}
+
+	for k, op := range ops {
+		i := opItems[k]
+		if errs[k] != nil {
+			results[i].Result = ""
+			results[i].Err = errs[k]
+			continue
+		}
+		idx.pending[op.ID] = op.Document // Nil for deletes
+	}
+	return results, nil
+}
+
+// BulkIndexer buffers items and writes them with Bulk in batches
+// It isn't safe for concurrent use
+type BulkIndexer struct {
+	idx       *Index
+	batchSize int
+	items     []BulkItem
+	results   []BulkResult
+}
+
+// NewBulkIndexer creates a bulk indexer; batchSize <= 0 uses DefaultBulkBatchSize
+// The caller must Flush after the last item
+func (idx *Index) NewBulkIndexer(batchSize int) *BulkIndexer {
+	if batchSize <= 0 {
+		batchSize = DefaultBulkBatchSize
+	}
+	return &BulkIndexer{
+		idx:       idx,
+		batchSize: batchSize,
+		items:     make([]BulkItem, 0, batchSize),
+	}
+}
+
+// Add buffers an item, writing the buffer once it's full
+func (b *BulkIndexer) Add(item BulkItem) error {
+	b.items = append(b.items, item)
+	if len(b.items) >= b.batchSize {
+		return b.Flush()
+	}
+	return nil
+}
+
+// Index buffers a document to store, replacing any existing version
+func (b *BulkIndexer) Index(doc *types.Document) error {
+	return b.Add(BulkItem{Action: BulkIndex, ID: doc.ID, Document: doc})
+}
+
+// Delete buffers a document deletion
+func (b *BulkIndexer) Delete(id string) error {
+	return b.Add(BulkItem{Action: BulkDelete, ID: id})
+}
+
+// Flush writes any buffered items
+func (b *BulkIndexer) Flush() error {
+	if len(b.items) == 0 {
+		return nil
+	}
+
+	results, err := b.idx.Bulk(b.items)
+	if err != nil {
+		return err
+	}
+	b.results = append(b.results, results...)
+	b.items = b.items[:0]
+	return nil
+}
+
+// Results returns the results of every item written so far, in the order added
+func (b *BulkIndexer) Results() []BulkResult {
+	return b.results
+}
+
+// Failed returns the results of the items that failed
+func (b *BulkIndexer) Failed() []BulkResult {
+	var failed []BulkResult
+	for _, r := range b.results {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
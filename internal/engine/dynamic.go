@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"fmt"
+
+	"nano-elastic/internal/analyzer"
+	"nano-elastic/internal/index/vector"
+	"nano-elastic/internal/types"
+)
+
+// DocumentFromSource converts a raw JSON-style document for this index
+// When the schema is dynamic, undeclared fields are first mapped from their
+// values and dynamic templates, and the extended schema is saved
+func (idx *Index) DocumentFromSource(id string, source map[string]interface{}) (*types.Document, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	changes, err := idx.Schema.InferFields(source)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) > 0 {
+		if err := idx.mapDynamicFields(changes); err != nil {
+			return nil, err
+		}
+	}
+	return types.DocumentFromSource(id, source, idx.Schema)
+}
+
+// IndexSource converts a raw document (see DocumentFromSource) and indexes it
+func (idx *Index) IndexSource(id string, source map[string]interface{}) (*types.Document, error) {
+	doc, err := idx.DocumentFromSource(id, source)
+	if err != nil {
+		return nil, err
+	}
+	if err := idx.IndexDocument(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// MapDocumentFields maps the undeclared fields of a typed document by their
+// value types when the schema is dynamic
+func (idx *Index) MapDocumentFields(doc *types.Document) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if changes := idx.Schema.InferDocumentFields(doc); len(changes) > 0 {
+		return idx.mapDynamicFields(changes)
+	}
+	return nil
+}
+
+// mapDynamicFields adds inferred fields to the schema
+// New fields have no indexed values yet, so only their analyzers are registered
+// Caller must hold idx.mu
+func (idx *Index) mapDynamicFields(changes []types.SchemaChange) error {
+	schema, err := idx.Schema.MapDynamicFields(changes...)
+	if err != nil {
+		return &MigrationError{Index: idx.Name, Err: err}
+	}
+
+	// Look up the analyzers of new text fields before saving the schema
+	type fieldAnalyzers struct {
+		index, search *analyzer.Analyzer
+	}
+	analyzers := make(map[string]fieldAnalyzers)
+	quantized := make(map[string]types.VectorQuantization)
+	for _, c := range changes {
+		if c.Def.Type == types.FieldTypeVector && c.Def.Quantization != types.QuantizationNone {
+			if err := vector.ValidateQuantization(c.Def.Quantization); err != nil {
+				return &MigrationError{Index: idx.Name, Err: fmt.Errorf("field %s: %w", c.Field, err)}
+			}
+			quantized[c.Field] = c.Def.Quantization
+		}
+		if c.Def.Type != types.FieldTypeText {
+			continue
+		}
+		var fa fieldAnalyzers
+		if fa.index, err = analyzer.Lookup(schema.FieldAnalyzerName(*c.Def)); err != nil {
+			return &MigrationError{Index: idx.Name, Err: err}
+		}
+		if schema.FieldSearchAnalyzerName(*c.Def) != schema.FieldAnalyzerName(*c.Def) {
+			if fa.search, err = analyzer.Lookup(schema.FieldSearchAnalyzerName(*c.Def)); err != nil {
+				return &MigrationError{Index: idx.Name, Err: err}
+			}
+		}
+		analyzers[c.Field] = fa
+	}
+
+	if err := idx.store.UpdateSchema(schema); err != nil {
+		return err
+	}
+	for name, fa := range analyzers {
+		idx.inverted.SetFieldAnalyzer(name, fa.index)
+		if fa.search != nil {
+			idx.inverted.SetFieldSearchAnalyzer(name, fa.search)
+		}
+	}
+	for name, q := range quantized {
+		idx.vectors.SetFieldQuantization(name, q)
+	}
+	idx.Schema = schema
+	return nil
+}
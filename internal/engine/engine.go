@@ -0,0 +1,319 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"nano-elastic/internal/errdefs"
+	"nano-elastic/internal/ingest"
+	"nano-elastic/internal/search"
+	"nano-elastic/internal/snapshot"
+	"nano-elastic/internal/storage"
+	"nano-elastic/internal/types"
+)
+
+// validIndexName matches index names that are safe to use as directory names
+var validIndexName = regexp.MustCompile(`^[a-z0-9][a-z0-9_\-]*$`)
+
+// Engine hosts many named indexes under one data path
+// Each index lives in its own directory, with its schema saved alongside the
+// segments so the engine can reopen every index on startup
+type Engine struct {
+	dataPath string
+	fs       storage.FS // Of every index, see WithFS
+	options  []Option   // Applied to every index
+	indexes  map[string]*Index
+	aliases  map[string][]string // Alias name to sorted index names
+	mu       sync.RWMutex
+
+	repositories        map[string]RepositoryConfig // Snapshot repositories by name
+	repositoryAllowlist snapshot.Allowlist          // See WithRepositoryAllowlist
+	snapshotMu          sync.Mutex                  // Serializes snapshot operations
+
+	policies  map[string]LifecyclePolicy // Lifecycle policies by name
+	lifecycle *lifecycleRunner
+
+	pipelines map[string]*ingest.Pipeline // Ingest pipelines by name
+	templates map[string]*search.Template // Search templates by name
+}
+
+// IndexNotFoundError is returned for operations on an index that doesn't exist
+type IndexNotFoundError struct {
+	Name string
+}
+
+func (e *IndexNotFoundError) Error() string {
+	return fmt.Sprintf("no such index [%s]", e.Name)
+}
+
+// Is matches errdefs.ErrIndexNotFound
+func (e *IndexNotFoundError) Is(target error) bool {
+	return target == errdefs.ErrIndexNotFound
+}
+
+// IndexExistsError is returned when creating an index whose name is taken
+type IndexExistsError struct {
+	Name string
+}
+
+func (e *IndexExistsError) Error() string {
+	return fmt.Sprintf("index [%s] already exists", e.Name)
+}
+
+// InvalidIndexNameError is returned for names that aren't lowercase letters, digits, '-' or '_'
+type InvalidIndexNameError struct {
+	Name string
+}
+
+func (e *InvalidIndexNameError) Error() string {
+	return fmt.Sprintf("invalid index name [%s]: must be lowercase letters, digits, '-' or '_'", e.Name)
+}
+
+// ValidateIndexName checks that a name can be used for an index
+func ValidateIndexName(name string) error {
+	if !validIndexName.MatchString(name) {
+		return &InvalidIndexNameError{Name: name}
+	}
+	return nil
+}
+
+// NewEngine opens every index found under dataPath
+// The options are applied to every index the engine opens or creates
+func NewEngine(dataPath string, options ...Option) (*Engine, error) {
+	// The engine's own files live with the indexes
+	probe := &Index{fs: storage.OSFS}
+	for _, option := range options {
+		option(probe)
+	}
+	fs := probe.fs
+	if err := fs.MkdirAll(dataPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	// Indexes share one filter cache and search pool unless the options set others
+	options = append([]Option{
+		WithFilterCache(search.NewFilterCache(search.DefaultFilterCacheSize)),
+		WithSearchPool(search.NewSearchPool(runtime.GOMAXPROCS(0))),
+	}, options...)
+
+	e := &Engine{
+		dataPath:            dataPath,
+		fs:                  fs,
+		options:             options,
+		indexes:             make(map[string]*Index),
+		repositoryAllowlist: probe.repositoryAllowlist,
+	}
+
+	aliases, err := e.readAliases()
+	if err != nil {
+		return nil, err
+	}
+	e.aliases = aliases
+
+	repositories, err := e.readRepositories()
+	if err != nil {
+		return nil, err
+	}
+	e.repositories = repositories
+
+	policies, err := e.readPolicies()
+	if err != nil {
+		return nil, err
+	}
+	e.policies = policies
+
+	pipelines, err := e.readPipelines()
+	if err != nil {
+		return nil, err
+	}
+	e.pipelines = pipelines
+
+	templates, err := e.readTemplates()
+	if err != nil {
+		return nil, err
+	}
+	e.templates = templates
+
+	entries, err := fs.ReadDir(dataPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data directory: %w", err)
+	}
+	for _, entry := range entries {
+		// Other directories, e.g. of an interrupted restore, aren't indexes
+		if !entry.IsDir() || !validIndexName.MatchString(entry.Name()) {
+			continue
+		}
+		schema, err := storage.LoadSchema(fs, dataPath, entry.Name())
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue // Not an index directory
+			}
+			e.Close()
+			return nil, err
+		}
+		idx, err := OpenIndex(entry.Name(), dataPath, schema, options...)
+		if err != nil {
+			e.Close()
+			return nil, fmt.Errorf("failed to open index %s: %w", entry.Name(), err)
+		}
+		e.indexes[entry.Name()] = idx
+	}
+
+	e.lifecycle = newLifecycleRunner(e, DefaultLifecycleInterval)
+	e.lifecycle.start()
+	return e, nil
+}
+
+// CreateIndex creates a new index with the given schema
+func (e *Engine) CreateIndex(name string, schema *types.Schema) (*Index, error) {
+	if err := ValidateIndexName(name); err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.indexes[name]; ok {
+		return nil, &IndexExistsError{Name: name}
+	}
+	if _, ok := e.aliases[name]; ok {
+		return nil, &AliasError{Alias: name, Reason: "an alias with the same name exists"}
+	}
+	return e.createIndex(name, schema)
+}
+
+// GetOrCreateIndex resolves an index or single-index alias like ResolveIndex,
+// creating an index with the given schema if nothing has that name
+// A nil schema creates a dynamic index that maps fields as documents arrive
+func (e *Engine) GetOrCreateIndex(name string, schema *types.Schema) (*Index, error) {
+	idx, err := e.ResolveIndex(name)
+	if _, ok := err.(*IndexNotFoundError); !ok {
+		return idx, err
+	}
+	if err := ValidateIndexName(name); err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Another caller may have created it since the check above
+	if idx, ok := e.indexes[name]; ok {
+		return idx, nil
+	}
+	if _, ok := e.aliases[name]; ok {
+		return e.resolveIndex(name)
+	}
+	return e.createIndex(name, schema)
+}
+
+// createIndex creates and opens the index
+// Caller must hold e.mu
+func (e *Engine) createIndex(name string, schema *types.Schema) (*Index, error) {
+	if schema == nil {
+		schema = types.NewSchema(name)
+		schema.Dynamic = true // Fields are mapped from the documents as they arrive
+	}
+	if schema.Created == 0 {
+		schema.Created = time.Now().Unix() // Ages the index for lifecycle policies
+	}
+	if err := validateSettings(schema.IndexSettings()); err != nil {
+		return nil, err
+	}
+
+	// Opening the index saves its schema, so it's found again on restart
+	idx, err := OpenIndex(name, e.dataPath, schema, e.options...)
+	if err != nil {
+		e.fs.RemoveAll(filepath.Join(e.dataPath, name))
+		return nil, err
+	}
+	e.indexes[name] = idx
+	return idx, nil
+}
+
+// GetIndex returns an open index by name
+func (e *Engine) GetIndex(name string) (*Index, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	idx, ok := e.indexes[name]
+	if !ok {
+		return nil, &IndexNotFoundError{Name: name}
+	}
+	return idx, nil
+}
+
+// MigrateIndex applies schema changes to an index (see Index.Migrate)
+func (e *Engine) MigrateIndex(name string, changes ...types.SchemaChange) error {
+	idx, err := e.ResolveIndex(name)
+	if err != nil {
+		return err
+	}
+	return idx.Migrate(changes...)
+}
+
+// ListIndexes returns the names of all indexes, sorted
+func (e *Engine) ListIndexes() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	names := make([]string, 0, len(e.indexes))
+	for name := range e.indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DeleteIndex closes an index and removes its data from disk
+func (e *Engine) DeleteIndex(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	idx, ok := e.indexes[name]
+	if !ok {
+		return &IndexNotFoundError{Name: name}
+	}
+	delete(e.indexes, name)
+
+	// Aliases stop pointing at a deleted index
+	aliases := e.aliasesWithout(name)
+	if err := e.writeAliases(aliases); err != nil {
+		return err
+	}
+	e.aliases = aliases
+
+	if err := idx.Close(); err != nil {
+		return fmt.Errorf("failed to close index %s: %w", name, err)
+	}
+	if err := e.fs.RemoveAll(filepath.Join(e.dataPath, name)); err != nil {
+		return fmt.Errorf("failed to remove index %s: %w", name, err)
+	}
+	return nil
+}
+
+// Close stops applying lifecycle policies and closes every open index
+func (e *Engine) Close() error {
+	if e.lifecycle != nil {
+		e.lifecycle.close()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var firstErr error
+	for name, idx := range e.indexes {
+		if err := idx.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to close index %s: %w", name, err)
+		}
+	}
+	e.indexes = make(map[string]*Index)
+	return firstErr
+}
@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"nano-elastic/internal/search"
+	"nano-elastic/internal/types"
+)
+
+// BeforeIndexHook is called with a document about to be written, before its
+// schema defaults and validation; it may change the document, and an error
+// rejects the write
+type BeforeIndexHook func(doc *types.Document) error
+
+// AfterIndexHook is called with a document once it is stored
+type AfterIndexHook func(doc *types.Document)
+
+// DeleteHook is called with the ID of a document once its delete is stored
+type DeleteHook func(id string)
+
+// SearchHook is called once a search of the index has run
+type SearchHook func(event SearchEvent)
+
+// SearchEvent describes a search for SearchHooks
+// Searches of several indexes, e.g. through an alias, report the merged
+// response to the hooks of every index searched
+type SearchEvent struct {
+	Index    string
+	Request  *search.Request
+	Response *search.Response // Nil if the search failed
+	Err      error
+	Took     time.Duration
+}
+
+// HookError is returned for a write a BeforeIndexHook rejected
+type HookError struct {
+	ID  string
+	Err error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("document %s rejected by hook: %v", e.ID, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// hooks are the callbacks registered on an index, run in registration order
+// The lists are replaced, never changed in place, so they are run without mu
+type hooks struct {
+	mu          sync.RWMutex
+	beforeIndex []BeforeIndexHook
+	afterIndex  []AfterIndexHook
+	delete      []DeleteHook
+	search      []SearchHook
+}
+
+// OnBeforeIndex registers a hook run before every document write: by
+// IndexDocument, IndexSource and the index and create items of Bulk
+// Hooks run on the writer's goroutine before the write takes any lock, so they
+// may call back into the index
+// To hook every index an Engine opens, register hooks in an Option
+func (idx *Index) OnBeforeIndex(hook BeforeIndexHook) {
+	idx.hooks.mu.Lock()
+	defer idx.hooks.mu.Unlock()
+	idx.hooks.beforeIndex = append(idx.hooks.beforeIndex[:len(idx.hooks.beforeIndex):len(idx.hooks.beforeIndex)], hook)
+}
+
+// OnAfterIndex registers a hook run after every stored document write, e.g.
+// for audit logging or replicating writes elsewhere
+// Hooks run on the writer's goroutine once the write's locks are released; the
+// document is stored but becomes searchable on the next refresh
+func (idx *Index) OnAfterIndex(hook AfterIndexHook) {
+	idx.hooks.mu.Lock()
+	defer idx.hooks.mu.Unlock()
+	idx.hooks.afterIndex = append(idx.hooks.afterIndex[:len(idx.hooks.afterIndex):len(idx.hooks.afterIndex)], hook)
+}
+
+// OnDelete registers a hook run after every stored delete, by DeleteDocument
+// or Bulk, e.g. for cache invalidation
+// Documents dropped when their TTL expires are not reported
+func (idx *Index) OnDelete(hook DeleteHook) {
+	idx.hooks.mu.Lock()
+	defer idx.hooks.mu.Unlock()
+	idx.hooks.delete = append(idx.hooks.delete[:len(idx.hooks.delete):len(idx.hooks.delete)], hook)
+}
+
+// OnSearch registers a hook run after every Search of the index, failed ones
+// included, once the search has released its locks; scrolls and counts are
+// not reported
+func (idx *Index) OnSearch(hook SearchHook) {
+	idx.hooks.mu.Lock()
+	defer idx.hooks.mu.Unlock()
+	idx.hooks.search = append(idx.hooks.search[:len(idx.hooks.search):len(idx.hooks.search)], hook)
+}
+
+// runBeforeIndex runs the before-index hooks on a document, stopping at the
+// first that rejects it
+func (h *hooks) runBeforeIndex(doc *types.Document) error {
+	h.mu.RLock()
+	hooks := h.beforeIndex
+	h.mu.RUnlock()
+	for _, hook := range hooks {
+		if err := hook(doc); err != nil {
+			return &HookError{ID: doc.ID, Err: err}
+		}
+	}
+	return nil
+}
+
+// runAfterIndex runs the after-index hooks on a stored document
+func (h *hooks) runAfterIndex(doc *types.Document) {
+	h.mu.RLock()
+	hooks := h.afterIndex
+	h.mu.RUnlock()
+	for _, hook := range hooks {
+		hook(doc)
+	}
+}
+
+// runDelete runs the delete hooks on a deleted document's ID
+func (h *hooks) runDelete(id string) {
+	h.mu.RLock()
+	hooks := h.delete
+	h.mu.RUnlock()
+	for _, hook := range hooks {
+		hook(id)
+	}
+}
+
+// runSearch runs the search hooks on a finished search
+func (h *hooks) runSearch(event SearchEvent) {
+	h.mu.RLock()
+	hooks := h.search
+	h.mu.RUnlock()
+	for _, hook := range hooks {
+		hook(event)
+	}
+}
@@ -0,0 +1,710 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"nano-elastic/internal/index/completion"
+	"nano-elastic/internal/index/docid"
+	"nano-elastic/internal/index/docvalues"
+	"nano-elastic/internal/index/geo"
+	"nano-elastic/internal/index/inverted"
+	"nano-elastic/internal/index/keyword"
+	"nano-elastic/internal/index/numeric"
+	"nano-elastic/internal/index/vector"
+	"nano-elastic/internal/logging"
+	"nano-elastic/internal/search"
+	"nano-elastic/internal/snapshot"
+	"nano-elastic/internal/storage"
+	"nano-elastic/internal/types"
+)
+
+// Index ties document storage to the in-memory search structures for one index
+// Writes go to storage first, then to the inverted, numeric, keyword, geo,
+// vector, completion and doc values indexes
+// Each document also gets a dense ordinal, so filters can be kept as bitmaps
+type Index struct {
+	Name   string
+	Schema *types.Schema
+
+	store       *storage.IndexManager
+	inverted    *inverted.InvertedIndex
+	numeric     *numeric.NumericIndex
+	keywords    *keyword.KeywordIndex
+	geo         *geo.GeoIndex
+	vectors     *vector.VectorIndex
+	completions *completion.CompletionIndex
+	docValues   *docvalues.Store
+	docIDs      map[string]struct{} // Live document IDs
+	ordinals    *docid.Ordinals     // Dense ordinals of the documents, for bitmap filters
+
+	// Writes become searchable on refresh: they wait in pending, and hits are
+	// loaded from a storage snapshot taken at the last refresh
+	pending   map[string]*types.Document // Latest write of each ID since the last refresh, nil for deletes
+	searcher  *storage.Snapshot
+	refreshed chan struct{} // Closed by the next refresh
+	refresher *refresher
+	refreshMu sync.Mutex // Guards refresher, which settings updates replace
+
+	// Filter results are cached, and searches run in parallel, per segment of
+	// the searcher snapshot
+	filterCache *search.FilterCache
+	searchPool  *search.SearchPool
+	segments    map[string]searchSegment // By segment ID, as of the last refresh
+	segmentDocs []*search.SegmentDocs
+
+	fs              storage.FS
+	storageOptions  []storage.IndexOption
+	maxResultWindow int
+	refreshInterval time.Duration
+
+	mu sync.RWMutex
+
+	// Writes of one document are serialized by its stripe, so the storage write
+	// runs without mu and the search structures see writes in storage order
+	writeLocks [writeLockStripes]sync.Mutex
+
+	// Searches and counts run, reported by Stats
+	searchStats searchCounters
+
+	// Callbacks on writes, deletes and searches (see OnBeforeIndex)
+	hooks hooks
+
+	// Queries matched against documents by Percolate
+	percolator percolator
+
+	logger           logging.Logger
+	slowLog          logging.Logger // Searches slower than slowLogThreshold, if > 0
+	slowLogThreshold time.Duration
+
+	repositoryAllowlist snapshot.Allowlist // Only read by NewEngine
+}
+
+// writeLockStripes is the number of locks document IDs are spread over
+const writeLockStripes = 64
+
+// Option configures an Index
+type Option func(*Index)
+
+// WithStorageOptions passes options through to the index's storage manager
+func WithStorageOptions(options ...storage.IndexOption) Option {
+	return func(idx *Index) {
+		idx.storageOptions = append(idx.storageOptions, options...)
+	}
+}
+
+// WithFS keeps the index's files on fs instead of disk (see storage.WithFS),
+// e.g. a storage.MemFS for tests and ephemeral indexes; an engine keeps its
+// own files there too
+func WithFS(fs storage.FS) Option {
+	return func(idx *Index) {
+		idx.fs = fs
+	}
+}
+
+// WithFilterCache sets the cache filter results are kept in, shared by every
+// index given the same cache; nil disables filter caching
+func WithFilterCache(cache *search.FilterCache) Option {
+	return func(idx *Index) {
+		idx.filterCache = cache
+	}
+}
+
+// WithSearchPool sets the goroutines searches run on segment by segment, shared
+// by every index given the same pool; nil searches an index's segments at once
+func WithSearchPool(pool *search.SearchPool) Option {
+	return func(idx *Index) {
+		idx.searchPool = pool
+	}
+}
+
+// WithMaxResultWindow caps from+size for searches (default search.DefaultMaxResultWindow)
+func WithMaxResultWindow(n int) Option {
+	return func(idx *Index) {
+		idx.maxResultWindow = n
+	}
+}
+
+// WithLogger sets the logger of the index and its storage (see
+// storage.WithLogger); records carry the index name
+func WithLogger(logger logging.Logger) Option {
+	return func(idx *Index) {
+		idx.logger = logger
+	}
+}
+
+// OpenIndex opens (or creates) an index and rebuilds its search structures
+// from the segments on disk
+// The schema must be the stored one or a migration of it (see Schema.Migrate);
+// nil uses the stored schema
+func OpenIndex(name string, basePath string, schema *types.Schema, options ...Option) (*Index, error) {
+	idx := &Index{
+		Name:            name,
+		Schema:          schema,
+		fs:              storage.OSFS,
+		maxResultWindow: search.DefaultMaxResultWindow,
+		refreshInterval: DefaultRefreshInterval,
+		refreshed:       make(chan struct{}),
+		searchStats:     newSearchCounters(),
+		logger:          logging.Default(),
+	}
+	for _, option := range options {
+		option(idx)
+	}
+
+	// Refreshes drop the documents merges expired (see types.Schema.TTLField)
+	storageOptions := append([]storage.IndexOption{storage.WithLogger(idx.logger), storage.WithFS(idx.fs)}, idx.storageOptions...)
+	storageOptions = append(storageOptions, storage.WithExpiredTracking())
+	store, err := storage.NewIndexManager(name, basePath, schema, storageOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index storage: %w", err)
+	}
+	idx.store = store
+	idx.Schema = store.Schema // Set from the stored schema if none was given
+	idx.applySettings(idx.Schema.IndexSettings())
+	idx.logger = idx.logger.With("index", name)
+	if idx.slowLog == nil {
+		idx.slowLog = idx.logger
+	} else {
+		idx.slowLog = idx.slowLog.With("index", name)
+	}
+
+	if err := idx.load(); err != nil {
+		store.Close()
+		return nil, err
+	}
+	if err := idx.loadRegisteredQueries(); err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	idx.startRefresher(idx.refreshInterval)
+	return idx, nil
+}
+
+// load rebuilds the in-memory search structures from the segments on disk,
+// making every stored write searchable
+// Caller must hold every write stripe and idx.mu (or be opening the index)
+func (idx *Index) load() error {
+	if err := idx.resetSearchStructures(); err != nil {
+		return err
+	}
+	idx.vectors.SetRescoreLoader(idx.storedVector)
+
+	// Numeric, date and keyword structures are rebuilt from the segments' doc values
+	// files, and text mostly from their postings files; geo-point, vector and
+	// completion fields need the stored documents
+	columns, err := idx.store.LoadDocValues()
+	if err != nil {
+		return err
+	}
+	for name := range columns {
+		if idx.Schema.IsDropped(name) {
+			delete(columns, name) // Still on disk until the segments are merged
+		}
+	}
+	idx.docValues.Load(columns)
+	for name, column := range columns {
+		if !idx.Schema.IsIndexed(name) {
+			continue // Kept for sorting and aggregations only
+		}
+		for id, value := range column {
+			idx.indexDocValue(id, name, value)
+		}
+	}
+
+	// Text postings stored with the segments are loaded as they are; only the
+	// other documents' text is analyzed again
+	loaded, err := idx.store.LoadPostings(idx.inverted)
+	if err != nil {
+		return fmt.Errorf("failed to load postings: %w", err)
+	}
+
+	err = idx.store.Scan(func(doc *types.Document) error {
+		if loaded[doc.ID] {
+			delete(loaded, doc.ID)
+		} else {
+			idx.indexText(doc)
+		}
+		indexed := idx.Schema.IndexedDocument(doc)
+		idx.geo.IndexDocument(indexed)
+		idx.vectors.IndexDocument(indexed)
+		idx.completions.IndexDocument(indexed)
+		idx.docIDs[doc.ID] = struct{}{}
+		idx.ordinals.Add(doc.ID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load documents: %w", err)
+	}
+	for id := range loaded {
+		idx.inverted.RemoveDocument(id) // Gone since the postings were loaded, e.g. expired
+	}
+
+	idx.pending = make(map[string]*types.Document)
+	return idx.setSearcher(idx.store.AcquireSnapshot())
+}
+
+// resetSearchStructures replaces the search structures with empty ones for
+// the schema
+// Caller must hold every write stripe and idx.mu (or be opening the index)
+func (idx *Index) resetSearchStructures() error {
+	invertedIndex, err := inverted.NewInvertedIndexForSchema(idx.Schema)
+	if err != nil {
+		return fmt.Errorf("failed to create inverted index: %w", err)
+	}
+	idx.inverted = invertedIndex
+	idx.numeric = numeric.NewNumericIndex()
+	idx.keywords = keyword.NewKeywordIndex()
+	idx.geo = geo.NewGeoIndex()
+	idx.vectors = vector.NewVectorIndex()
+	idx.completions = completion.NewCompletionIndex()
+	for name, def := range idx.Schema.Fields {
+		if def.Type == types.FieldTypeVector {
+			if err := idx.vectors.SetFieldQuantization(name, def.Quantization); err != nil {
+				return fmt.Errorf("failed to create vector index: %w", err)
+			}
+		}
+	}
+	idx.docValues = docvalues.NewStore()
+	idx.docIDs = make(map[string]struct{})
+	idx.ordinals = invertedIndex.Ordinals() // Shared, so posting lists and filter bitmaps use the same ordinals
+	idx.clearSegments()                     // Cached filters use the old ordinals
+	return nil
+}
+
+// MigrationError is returned for schema changes that can't be applied
+type MigrationError struct {
+	Index string
+	Err   error
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("cannot migrate index %s: %v", e.Index, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// Migrate applies schema changes as the next schema version and saves it
+// Added fields apply to new documents. Dropped fields leave search right away
+// and leave stored documents lazily: they're stripped on read and physically
+// removed when segments merge. The search structures are rebuilt from the
+// stored documents, so a changed analyzer applies to existing documents too
+func (idx *Index) Migrate(changes ...types.SchemaChange) error {
+	idx.lockAllWrites()
+	defer idx.unlockAllWrites()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	schema, err := idx.Schema.Migrate(changes...)
+	if err != nil {
+		return &MigrationError{Index: idx.Name, Err: err}
+	}
+
+	// Check analyzers and vector quantizations exist before the new schema is saved
+	if _, err := inverted.NewInvertedIndexForSchema(schema); err != nil {
+		return &MigrationError{Index: idx.Name, Err: err}
+	}
+	for name, def := range schema.Fields {
+		if err := vector.ValidateQuantization(def.Quantization); err != nil {
+			return &MigrationError{Index: idx.Name, Err: fmt.Errorf("field %s: %w", name, err)}
+		}
+	}
+	if err := idx.store.UpdateSchema(schema); err != nil {
+		return err
+	}
+
+	previous := idx.Schema
+	idx.Schema = schema
+	if err := idx.load(); err != nil {
+		idx.Schema = previous
+		return fmt.Errorf("failed to rebuild index after migration: %w", err)
+	}
+	return nil
+}
+
+// IndexDocument stores a document, which becomes searchable on the next refresh
+// A document with the same ID replaces the previous version
+// Fields missing from the document get their schema defaults first
+func (idx *Index) IndexDocument(doc *types.Document) error {
+	if err := idx.hooks.runBeforeIndex(doc); err != nil {
+		return err
+	}
+	if err := idx.writeDocument(doc); err != nil {
+		return err
+	}
+	idx.hooks.runAfterIndex(doc)
+	return nil
+}
+
+// writeDocument validates and stores a document, queueing it for the next refresh
+func (idx *Index) writeDocument(doc *types.Document) error {
+	if idx.Schema != nil {
+		if err := idx.Schema.ApplyDefaults(doc); err != nil {
+			return err
+		}
+		if err := idx.Schema.ValidateDocument(doc); err != nil {
+			return err
+		}
+	}
+
+	lock := idx.writeLock(doc.ID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Other writers, and searches, proceed while the document is stored
+	if err := idx.store.WriteDocument(doc); err != nil {
+		return fmt.Errorf("failed to store document: %w", err)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.pending[doc.ID] = doc
+	return nil
+}
+
+// GetDocument returns a stored document by ID
+func (idx *Index) GetDocument(id string) (*types.Document, error) {
+	return idx.store.ReadDocument(id)
+}
+
+// MGet returns many stored documents in one call, with a result per ID in order
+// (see storage.IndexManager.MGet)
+func (idx *Index) MGet(ids []string) ([]storage.MGetResult, error) {
+	return idx.store.MGet(ids)
+}
+
+// Scan streams every stored document to fn in storage order, including writes
+// not yet searchable, without collecting IDs first (see storage.IndexManager.Scan)
+// An error from fn stops the scan and is returned
+func (idx *Index) Scan(fn func(doc *types.Document) error) error {
+	return idx.store.Scan(fn)
+}
+
+// DeleteDocument removes a document from storage, and from search results on the next refresh
+func (idx *Index) DeleteDocument(id string) error {
+	if err := idx.deleteDocument(id); err != nil {
+		return err
+	}
+	idx.hooks.runDelete(id)
+	return nil
+}
+
+// deleteDocument stores a delete, queueing it for the next refresh
+func (idx *Index) deleteDocument(id string) error {
+	lock := idx.writeLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := idx.store.DeleteDocument(id); err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.pending[id] = nil
+	return nil
+}
+
+// DocCount returns the number of searchable documents, as of the last refresh
+func (idx *Index) DocCount() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return len(idx.docIDs)
+}
+
+// FieldStats returns how many stored documents have a text field and their
+// total length in tokens, from the norms kept with each segment
+func (idx *Index) FieldStats(field string) (storage.FieldStats, error) {
+	return idx.store.FieldStats(field)
+}
+
+// Search runs a request and loads the stored documents for the returned page of hits
+func (idx *Index) Search(req *search.Request) (*search.Response, error) {
+	return idx.SearchContext(context.Background(), req)
+}
+
+// SearchContext is Search, stopped with the context's error once it is cancelled
+// A deadline, the context's or the request's Timeout, returns the hits found in
+// time (see search.Execute)
+func (idx *Index) SearchContext(ctx context.Context, req *search.Request) (*search.Response, error) {
+	start := time.Now()
+	resp, err := idx.runSearch(ctx, req)
+	idx.hooks.runSearch(SearchEvent{Index: idx.Name, Request: req, Response: resp, Err: err, Took: req.ParseTime + time.Since(start)})
+	return resp, err
+}
+
+// runSearch runs a search and loads its page of hits
+func (idx *Index) runSearch(ctx context.Context, req *search.Request) (*search.Response, error) {
+	start := time.Now()
+	defer idx.searchStats.recordQuery(start)
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	resp, err := search.Execute(idx.reader().WithContext(ctx), req)
+	if err != nil {
+		return nil, err
+	}
+
+	fetchStart := time.Now()
+	for i := range resp.Hits {
+		if err := idx.loadHit(req, &resp.Hits[i]); err != nil {
+			return nil, err
+		}
+	}
+	resp.Timings.Fetch = time.Since(fetchStart)
+	for _, p := range resp.Profile {
+		p.Index = idx.Name
+		p.Fetch = resp.Timings.Fetch
+	}
+
+	if idx.logger.DebugEnabled() {
+		idx.logger.Debug("search completed", "took", time.Since(start), "total", resp.Total, "hits", len(resp.Hits), "timed_out", resp.TimedOut)
+	}
+	idx.logSlowSearch(req, resp, req.ParseTime+time.Since(start))
+	return resp, nil
+}
+
+// Count returns the number of searchable documents matching a query, nil for
+// all of them, without scoring or loading them (see search.Count)
+func (idx *Index) Count(query search.Query) (int, error) {
+	return idx.CountContext(context.Background(), query)
+}
+
+// CountContext is Count, stopped with the context's error once it is cancelled
+func (idx *Index) CountContext(ctx context.Context, query search.Query) (int, error) {
+	defer idx.searchStats.recordCount(time.Now())
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return search.Count(idx.reader().WithContext(ctx), query)
+}
+
+// fetchHit loads a hit's stored document, filtered by the request's source
+// filter, and highlights it if requested
+func (idx *Index) fetchHit(req *search.Request, hit *search.Hit) error {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return idx.loadHit(req, hit)
+}
+
+// loadHit is fetchHit for callers holding idx.mu
+// The document is the version that was searchable when the hit matched
+// Hits without source or highlights aren't read at all
+func (idx *Index) loadHit(req *search.Request, hit *search.Hit) error {
+	if req.Source != nil && req.Source.Disabled && req.Highlight == nil {
+		return nil
+	}
+	doc, err := idx.searcher.ReadDocument(hit.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load hit %s: %w", hit.ID, err)
+	}
+	if req.Highlight != nil {
+		hit.Highlight = search.HighlightDocument(idx.reader(), req.Query, req.Highlight, doc)
+	}
+	if req.Source == nil || !req.Source.Disabled {
+		hit.Document = req.Source.Apply(doc)
+	}
+	return nil
+}
+
+// KnnSearch returns the k documents whose vector field is most similar to the
+// query vector, best first, with their stored documents
+func (idx *Index) KnnSearch(field string, query []float32, k int, metric vector.Metric) (*search.Response, error) {
+	return idx.Search(&search.Request{
+		Query: &search.KnnQuery{Field: field, Vector: query, K: k, Metric: metric},
+		Size:  &k,
+	})
+}
+
+// collectShard collects every hit of a request, with sort values, so they can
+// be merged with hits from other indexes
+func (idx *Index) collectShard(ctx context.Context, req *search.Request) (*search.Response, error) {
+	start := time.Now()
+	defer idx.searchStats.recordQuery(start)
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	resp, err := search.CollectShard(idx.reader().WithContext(ctx), req)
+	if err != nil {
+		return nil, err
+	}
+	for i := range resp.Hits {
+		resp.Hits[i].Index = idx.Name
+	}
+	for _, p := range resp.Profile {
+		p.Index = idx.Name
+	}
+
+	// The page is fetched once the shards are merged, so there is no fetch time
+	idx.logSlowSearch(req, resp, req.ParseTime+time.Since(start))
+	return resp, nil
+}
+
+// ParseQuery parses a query string against this index's schema
+// Bare terms search every text field
+func (idx *Index) ParseQuery(input string) (search.Query, error) {
+	qp := search.NewQueryParser()
+	qp.Schema = idx.Schema
+	return qp.Parse(input)
+}
+
+// ForceMerge merges all segments into one, dropping deleted documents
+// Segments held by open scrolls are deleted once the scrolls are closed
+func (idx *Index) ForceMerge() error {
+	return idx.ForceMergeContext(context.Background())
+}
+
+// ForceMergeContext is ForceMerge, abandoned if the context is done first
+func (idx *Index) ForceMergeContext(ctx context.Context) error {
+	return idx.store.ForceMergeContext(ctx)
+}
+
+// Close closes the index storage
+// Pending writes are stored, and searchable again once the index is reopened
+func (idx *Index) Close() error {
+	// Stop the refresher before taking the locks; a running refresh needs them
+	idx.refreshMu.Lock()
+	if idx.refresher != nil {
+		idx.refresher.close()
+		idx.refresher = nil
+	}
+	idx.refreshMu.Unlock()
+
+	idx.lockAllWrites()
+	defer idx.unlockAllWrites()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if err := idx.setSearcher(nil); err != nil {
+		return err
+	}
+	close(idx.refreshed) // Don't leave writers waiting for a refresh
+	idx.refreshed = make(chan struct{})
+
+	return idx.store.Close()
+}
+
+// writeLock returns the lock serializing writes of a document
+func (idx *Index) writeLock(id string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return &idx.writeLocks[h.Sum32()%writeLockStripes]
+}
+
+// lockAllWrites takes every write stripe, in order, e.g. for a bulk request
+func (idx *Index) lockAllWrites() {
+	for i := range idx.writeLocks {
+		idx.writeLocks[i].Lock()
+	}
+}
+
+// unlockAllWrites releases the stripes taken by lockAllWrites
+func (idx *Index) unlockAllWrites() {
+	for i := range idx.writeLocks {
+		idx.writeLocks[i].Unlock()
+	}
+}
+
+// reader exposes the search structures to the query executor
+// Caller must hold idx.mu
+func (idx *Index) reader() *search.Reader {
+	return &search.Reader{
+		Schema:      idx.Schema,
+		Inverted:    idx.inverted,
+		Numeric:     idx.numeric,
+		Keywords:    idx.keywords,
+		DocValues:   idx.docValues,
+		Geo:         idx.geo,
+		Vectors:     idx.vectors,
+		Completions: idx.completions,
+		AllDocs:     idx.docIDs,
+		Ordinals:    idx.ordinals,
+
+		FilterCache: idx.filterCache,
+		Pool:        idx.searchPool,
+		Segments:    idx.segmentDocs,
+
+		MaxResultWindow: idx.maxResultWindow,
+	}
+}
+
+// indexInMemory adds a document to every search structure
+// Object subfields are indexed under their dot paths; unindexed fields only
+// get doc values
+// Caller must hold idx.mu
+func (idx *Index) indexInMemory(doc *types.Document) {
+	doc = doc.Flatten()
+	indexed := idx.Schema.IndexedDocument(doc)
+	idx.indexText(doc)
+	idx.numeric.IndexDocument(indexed)
+	idx.keywords.IndexDocument(indexed)
+	idx.geo.IndexDocument(indexed)
+	idx.vectors.IndexDocument(indexed)
+	idx.completions.IndexDocument(indexed)
+	idx.docValues.IndexDocument(doc)
+	idx.docIDs[doc.ID] = struct{}{}
+	idx.ordinals.Add(doc.ID)
+}
+
+// indexText adds a document's text fields, including object subfields and
+// copy_to targets, to the inverted index
+func (idx *Index) indexText(doc *types.Document) {
+	for name, texts := range idx.Schema.TextValues(doc) {
+		idx.inverted.IndexValues(doc.ID, name, texts)
+	}
+}
+
+// storedVector reads a vector field's full-precision value from the searchable
+// version of the stored document
+// Quantized vector fields are rescored with it
+func (idx *Index) storedVector(fieldName string, docID string) ([]float32, bool) {
+	doc, err := idx.searcher.ReadDocument(docID)
+	if err != nil {
+		return nil, false
+	}
+	value, ok := doc.GetField(fieldName)
+	if !ok {
+		return nil, false
+	}
+	vec, ok := value.(types.VectorValue)
+	return vec.Value, ok
+}
+
+// indexDocValue adds one doc value to the numeric or keyword index
+func (idx *Index) indexDocValue(docID string, fieldName string, value docvalues.Value) {
+	switch value.Kind {
+	case docvalues.KindNumeric, docvalues.KindDate:
+		idx.numeric.IndexValue(docID, fieldName, value.Num)
+	case docvalues.KindKeyword:
+		idx.keywords.IndexValue(docID, fieldName, value.Str)
+	}
+}
+
+// removeInMemory removes a document from every search structure
+// Caller must hold idx.mu
+func (idx *Index) removeInMemory(id string) {
+	if _, ok := idx.docIDs[id]; !ok {
+		return
+	}
+	idx.inverted.RemoveDocument(id)
+	idx.numeric.RemoveDocument(id)
+	idx.keywords.RemoveDocument(id)
+	idx.geo.RemoveDocument(id)
+	idx.vectors.RemoveDocument(id)
+	idx.completions.RemoveDocument(id)
+	idx.docValues.RemoveDocument(id)
+	delete(idx.docIDs, id)
+	idx.ordinals.Remove(id)
+}
@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"nano-elastic/internal/search"
+	"nano-elastic/internal/storage"
+	"nano-elastic/internal/types"
+)
+
+// storedIndexedSchema has an unstored but indexed field (price) and a stored
+// but unindexed one (sku)
+func storedIndexedSchema() *types.Schema {
+	s := types.NewSchema("products")
+	s.AddField("title", types.FieldTypeText)
+	s.AddField("price", types.FieldTypeNumeric, types.WithStored(false))
+	s.AddField("sku", types.FieldTypeKeyword, types.WithIndexed(false))
+	return s
+}
+
+func openStoredIndexedIndex(t *testing.T, dir string) *Index {
+	t.Helper()
+	idx, err := OpenIndex("products", dir, storedIndexedSchema(),
+		WithStorageOptions(storage.WithMaxSegmentDocs(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+func searchIDs(t *testing.T, idx *Index, body string) ([]string, []search.Hit) {
+	t.Helper()
+	req, err := search.ParseSearchRequest([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := idx.Search(req)
+	if err != nil {
+		t.Fatalf("search %s: %v", body, err)
+	}
+	ids := []string{}
+	for _, hit := range resp.Hits {
+		ids = append(ids, hit.ID)
+	}
+	return ids, resp.Hits
+}
+
+func TestStoredAndIndexedFields(t *testing.T) {
+	dir := t.TempDir()
+	idx := openStoredIndexedIndex(t, dir)
+	products := []struct {
+		id, title, sku string
+		price          float64
+	}{
+		{"p1", "red shoes", "RS-1", 30},
+		{"p2", "blue shoes", "BS-2", 10},
+		{"p3", "green shoes", "GS-3", 20},
+	}
+	for _, p := range products {
+		doc := types.NewDocument(p.id)
+		doc.SetField("title", types.TextValue{Value: p.title})
+		doc.SetField("price", types.NumericValue{Value: p.price})
+		doc.SetField("sku", types.KeywordValue{Value: p.sku})
+		if err := idx.IndexDocument(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(t *testing.T, idx *Index) {
+		if err := idx.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+
+		doc, err := idx.GetDocument("p1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := doc.Fields["price"]; ok {
+			t.Error("unstored price is in the stored document")
+		}
+		if got := doc.Fields["sku"]; got != (types.KeywordValue{Value: "RS-1"}) {
+			t.Errorf("sku = %v, want RS-1", got)
+		}
+
+		queries := []struct {
+			name string
+			body string
+			want []string
+		}{
+			{"unindexed field matches nothing", `{"query": {"term": {"sku": "RS-1"}}}`, []string{}},
+			{"indexed text", `{"query": {"match": {"title": "red"}}}`, []string{"p1"}},
+			{"unstored field is indexed", `{"query": {"range": {"price": {"gte": 15}}}, "sort": ["price"]}`, []string{"p3", "p1"}},
+			{"unstored field sorts by doc values", `{"query": {"match_all": {}}, "sort": [{"price": "desc"}]}`, []string{"p1", "p3", "p2"}},
+			{"unindexed field sorts by doc values", `{"query": {"match_all": {}}, "sort": ["sku"]}`, []string{"p2", "p3", "p1"}},
+		}
+		for _, q := range queries {
+			t.Run(q.name, func(t *testing.T) {
+				if got, _ := searchIDs(t, idx, q.body); !reflect.DeepEqual(got, q.want) {
+					t.Errorf("hits = %v, want %v", got, q.want)
+				}
+			})
+		}
+
+		// The unstored values come back from doc values
+		_, hits := searchIDs(t, idx, `{"query": {"match_all": {}}, "sort": ["price"]}`)
+		var prices []interface{}
+		for _, hit := range hits {
+			prices = append(prices, hit.Sort[0])
+		}
+		if want := []interface{}{10.0, 20.0, 30.0}; !reflect.DeepEqual(prices, want) {
+			t.Errorf("price sort values = %v, want %v", prices, want)
+		}
+	}
+
+	t.Run("open", func(t *testing.T) { check(t, idx) })
+
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+	idx = openStoredIndexedIndex(t, dir)
+	defer idx.Close()
+	t.Run("reopened", func(t *testing.T) { check(t, idx) })
+
+	if err := idx.ForceMerge(); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("merged", func(t *testing.T) { check(t, idx) })
+}
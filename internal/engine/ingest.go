@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"nano-elastic/internal/ingest"
+)
+
+// pipelinesFile is the name of the ingest pipeline table kept in the data directory
+const pipelinesFile = "pipelines.json"
+
+// NoPipeline, as a write's pipeline, skips the index's default pipeline
+const NoPipeline = "_none"
+
+// PipelineNotFoundError is returned for a pipeline that doesn't exist
+type PipelineNotFoundError struct {
+	Name string
+}
+
+func (e *PipelineNotFoundError) Error() string {
+	return fmt.Sprintf("pipeline [%s] not found", e.Name)
+}
+
+// PipelineError is returned for invalid pipelines
+type PipelineError struct {
+	Name   string
+	Reason string
+}
+
+func (e *PipelineError) Error() string {
+	return fmt.Sprintf("pipeline [%s]: %s", e.Name, e.Reason)
+}
+
+// IngestError is returned for a document a pipeline failed on
+type IngestError struct {
+	Pipeline string
+	ID       string
+	Err      error
+}
+
+func (e *IngestError) Error() string {
+	return fmt.Sprintf("pipeline [%s] failed on document %s: %v", e.Pipeline, e.ID, e.Err)
+}
+
+func (e *IngestError) Unwrap() error {
+	return e.Err
+}
+
+// PutPipeline adds an ingest pipeline, replacing any with the same name
+func (e *Engine) PutPipeline(name string, pipeline *ingest.Pipeline) error {
+	if !validIndexName.MatchString(name) {
+		return &PipelineError{Name: name, Reason: "name must be lowercase letters, digits, '-' or '_'"}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	pipelines := make(map[string]*ingest.Pipeline, len(e.pipelines)+1)
+	for n, p := range e.pipelines {
+		pipelines[n] = p
+	}
+	pipelines[name] = pipeline
+	if err := e.writePipelines(pipelines); err != nil {
+		return err
+	}
+	e.pipelines = pipelines
+	return nil
+}
+
+// DeletePipeline removes an ingest pipeline; writes to indexes whose default
+// pipeline it is fail until the setting is changed
+func (e *Engine) DeletePipeline(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.pipelines[name]; !ok {
+		return &PipelineNotFoundError{Name: name}
+	}
+	pipelines := make(map[string]*ingest.Pipeline, len(e.pipelines))
+	for n, p := range e.pipelines {
+		if n != name {
+			pipelines[n] = p
+		}
+	}
+	if err := e.writePipelines(pipelines); err != nil {
+		return err
+	}
+	e.pipelines = pipelines
+	return nil
+}
+
+// Pipelines returns a copy of the ingest pipelines
+func (e *Engine) Pipelines() map[string]*ingest.Pipeline {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	pipelines := make(map[string]*ingest.Pipeline, len(e.pipelines))
+	for name, pipeline := range e.pipelines {
+		pipelines[name] = pipeline
+	}
+	return pipelines
+}
+
+// Pipeline returns an ingest pipeline by name
+func (e *Engine) Pipeline(name string) (*ingest.Pipeline, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	pipeline, ok := e.pipelines[name]
+	if !ok {
+		return nil, &PipelineNotFoundError{Name: name}
+	}
+	return pipeline, nil
+}
+
+// Ingest runs a document written to idx through its ingest pipeline before it
+// is converted and validated (see Index.DocumentFromSource): the pipeline
+// named by the write, or else the index's default_pipeline setting
+// NoPipeline, or no pipeline at all, leaves the document as it is
+func (e *Engine) Ingest(idx *Index, pipeline string, doc *ingest.Document) error {
+	if pipeline == "" {
+		pipeline = idx.Settings().DefaultPipeline
+	}
+	if pipeline == "" || pipeline == NoPipeline {
+		return nil
+	}
+	p, err := e.Pipeline(pipeline)
+	if err != nil {
+		return err
+	}
+	if err := p.Run(doc); err != nil {
+		return &IngestError{Pipeline: pipeline, ID: doc.ID, Err: err}
+	}
+	return nil
+}
+
+// readPipelines loads the ingest pipelines, which are absent until one is added
+func (e *Engine) readPipelines() (map[string]*ingest.Pipeline, error) {
+	pipelines := make(map[string]*ingest.Pipeline)
+	data, err := e.fs.ReadFile(filepath.Join(e.dataPath, pipelinesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return pipelines, nil
+		}
+		return nil, fmt.Errorf("failed to read ingest pipelines: %w", err)
+	}
+	var definitions map[string]json.RawMessage
+	if err := json.Unmarshal(data, &definitions); err != nil {
+		return nil, fmt.Errorf("failed to decode ingest pipelines: %w", err)
+	}
+	for name, definition := range definitions {
+		pipeline, err := ingest.ParsePipeline(definition)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ingest pipeline %s: %w", name, err)
+		}
+		pipelines[name] = pipeline
+	}
+	return pipelines, nil
+}
+
+// writePipelines saves the ingest pipelines atomically (temp file and rename)
+func (e *Engine) writePipelines(pipelines map[string]*ingest.Pipeline) error {
+	data, err := json.MarshalIndent(pipelines, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode ingest pipelines: %w", err)
+	}
+
+	path := filepath.Join(e.dataPath, pipelinesFile)
+	if err := e.fs.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write ingest pipelines: %w", err)
+	}
+	if err := e.fs.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to commit ingest pipelines: %w", err)
+	}
+	return nil
+}
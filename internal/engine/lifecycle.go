@@ -0,0 +1,236 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// lifecycleFile is the name of the lifecycle policy table kept in the data directory
+const lifecycleFile = "lifecycle.json"
+
+// DefaultLifecycleInterval is how often the engine applies its lifecycle policies
+const DefaultLifecycleInterval = time.Minute
+
+// LifecyclePolicy deletes the indexes matching its patterns once they are
+// older than DeleteAfter, e.g. daily log indexes kept for 30 days
+// An index's age counts from its creation (types.Schema.Created); indexes
+// created before creation times were recorded are never deleted
+type LifecyclePolicy struct {
+	IndexPatterns []string      `json:"index_patterns"` // Index name patterns (path.Match syntax), e.g. "logs-*"
+	DeleteAfter   time.Duration `json:"delete_after"`
+}
+
+// LifecyclePolicyNotFoundError is returned for operations on a policy that doesn't exist
+type LifecyclePolicyNotFoundError struct {
+	Name string
+}
+
+func (e *LifecyclePolicyNotFoundError) Error() string {
+	return fmt.Sprintf("policy [%s] not found", e.Name)
+}
+
+// LifecyclePolicyError is returned for invalid lifecycle policies
+type LifecyclePolicyError struct {
+	Name   string
+	Reason string
+}
+
+func (e *LifecyclePolicyError) Error() string {
+	return fmt.Sprintf("policy [%s]: %s", e.Name, e.Reason)
+}
+
+// matches reports whether the policy applies to an index
+func (p LifecyclePolicy) matches(index string) bool {
+	for _, pattern := range p.IndexPatterns {
+		if ok, _ := path.Match(pattern, index); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// PutLifecyclePolicy adds a lifecycle policy, replacing any with the same name
+// It takes effect on the next run (see ApplyLifecycle)
+func (e *Engine) PutLifecyclePolicy(name string, policy LifecyclePolicy) error {
+	switch {
+	case !validIndexName.MatchString(name):
+		return &LifecyclePolicyError{Name: name, Reason: "name must be lowercase letters, digits, '-' or '_'"}
+	case len(policy.IndexPatterns) == 0:
+		return &LifecyclePolicyError{Name: name, Reason: "at least one index pattern is required"}
+	case policy.DeleteAfter <= 0:
+		return &LifecyclePolicyError{Name: name, Reason: "delete age must be positive"}
+	}
+	for _, pattern := range policy.IndexPatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return &LifecyclePolicyError{Name: name, Reason: fmt.Sprintf("invalid index pattern %q", pattern)}
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	policies := make(map[string]LifecyclePolicy, len(e.policies)+1)
+	for n, p := range e.policies {
+		policies[n] = p
+	}
+	policies[name] = policy
+	if err := e.writePolicies(policies); err != nil {
+		return err
+	}
+	e.policies = policies
+	return nil
+}
+
+// DeleteLifecyclePolicy removes a lifecycle policy; the indexes it matched are kept
+func (e *Engine) DeleteLifecyclePolicy(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.policies[name]; !ok {
+		return &LifecyclePolicyNotFoundError{Name: name}
+	}
+	policies := make(map[string]LifecyclePolicy, len(e.policies))
+	for n, p := range e.policies {
+		if n != name {
+			policies[n] = p
+		}
+	}
+	if err := e.writePolicies(policies); err != nil {
+		return err
+	}
+	e.policies = policies
+	return nil
+}
+
+// LifecyclePolicies returns a copy of the lifecycle policies
+func (e *Engine) LifecyclePolicies() map[string]LifecyclePolicy {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	policies := make(map[string]LifecyclePolicy, len(e.policies))
+	for name, policy := range e.policies {
+		policies[name] = policy
+	}
+	return policies
+}
+
+// ApplyLifecycle deletes the indexes that a policy matches and that are older
+// than its age limit at now; the engine runs it every DefaultLifecycleInterval
+// Returns the names of the deleted indexes, sorted
+func (e *Engine) ApplyLifecycle(now time.Time) ([]string, error) {
+	e.mu.RLock()
+	var expired []string
+	for name, idx := range e.indexes {
+		created := idx.Schema.Created
+		if created == 0 {
+			continue
+		}
+		age := now.Sub(time.Unix(created, 0))
+		for _, policy := range e.policies {
+			if policy.matches(name) && age >= policy.DeleteAfter {
+				expired = append(expired, name)
+				break
+			}
+		}
+	}
+	e.mu.RUnlock()
+	sort.Strings(expired)
+
+	deleted := make([]string, 0, len(expired))
+	for _, name := range expired {
+		if err := e.DeleteIndex(name); err != nil {
+			if _, ok := err.(*IndexNotFoundError); ok {
+				continue // Deleted meanwhile
+			}
+			return deleted, err
+		}
+		deleted = append(deleted, name)
+	}
+	return deleted, nil
+}
+
+// readPolicies loads the lifecycle policies, which are absent until one is added
+func (e *Engine) readPolicies() (map[string]LifecyclePolicy, error) {
+	policies := make(map[string]LifecyclePolicy)
+	data, err := e.fs.ReadFile(filepath.Join(e.dataPath, lifecycleFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return policies, nil
+		}
+		return nil, fmt.Errorf("failed to read lifecycle policies: %w", err)
+	}
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return nil, fmt.Errorf("failed to decode lifecycle policies: %w", err)
+	}
+	return policies, nil
+}
+
+// writePolicies saves the lifecycle policies atomically (temp file and rename)
+func (e *Engine) writePolicies(policies map[string]LifecyclePolicy) error {
+	data, err := json.MarshalIndent(policies, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode lifecycle policies: %w", err)
+	}
+
+	path := filepath.Join(e.dataPath, lifecycleFile)
+	if err := e.fs.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write lifecycle policies: %w", err)
+	}
+	if err := e.fs.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to commit lifecycle policies: %w", err)
+	}
+	return nil
+}
+
+// lifecycleRunner applies an engine's lifecycle policies in a background goroutine
+type lifecycleRunner struct {
+	engine   *Engine
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newLifecycleRunner(e *Engine, interval time.Duration) *lifecycleRunner {
+	return &lifecycleRunner{
+		engine:   e,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (r *lifecycleRunner) start() {
+	go r.run()
+}
+
+// close stops the runner and waits for a run in progress to finish
+func (r *lifecycleRunner) close() {
+	r.once.Do(func() {
+		close(r.stop)
+		<-r.done
+	})
+}
+
+func (r *lifecycleRunner) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case now := <-ticker.C:
+			// A failed deletion is retried on the next tick
+			r.engine.ApplyLifecycle(now)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"sort"
+
+	"nano-elastic/internal/metrics"
+	"nano-elastic/internal/search"
+)
+
+// metricPrefix starts the name of every metric the engine exports
+const metricPrefix = "nanoelastic_"
+
+// indexMetric is a per-index metric read from an index's Stats
+type indexMetric struct {
+	name  string
+	help  string
+	typ   metrics.Type
+	value func(s *Stats) float64
+}
+
+// indexMetrics are exported with an index label
+var indexMetrics = []indexMetric{
+	{"docs", "Live documents, including writes not yet searchable.", metrics.GaugeType, func(s *Stats) float64 { return float64(s.DocCount) }},
+	{"searchable_docs", "Documents visible to searches, as of the last refresh.", metrics.GaugeType, func(s *Stats) float64 { return float64(s.SearchableDocs) }},
+	{"deleted_docs", "Deleted documents not yet removed by a merge.", metrics.GaugeType, func(s *Stats) float64 { return float64(s.DeletedCount) }},
+	{"buffered_ops", "Writes and deletes in memtables, not yet flushed to a segment.", metrics.GaugeType, func(s *Stats) float64 { return float64(s.BufferedOps) }},
+	{"segments", "Sealed segments.", metrics.GaugeType, func(s *Stats) float64 { return float64(s.SegmentCount) }},
+	{"segment_bytes", "Size of the segments' data files.", metrics.GaugeType, func(s *Stats) float64 { return float64(s.SegmentBytes) }},
+	{"memory_bytes", "Estimated heap held by memtables and segment metadata.", metrics.GaugeType, func(s *Stats) float64 { return float64(s.MemoryBytes) }},
+	{"terms", "Unique terms in the inverted index.", metrics.GaugeType, func(s *Stats) float64 { return float64(s.Terms.UniqueTerms) }},
+	{"wal_files", "Write-ahead log files.", metrics.GaugeType, func(s *Stats) float64 { return float64(s.WAL.Files) }},
+	{"wal_bytes", "Size of the write-ahead log files.", metrics.GaugeType, func(s *Stats) float64 { return float64(s.WAL.Bytes) }},
+	{"wal_sequence", "Sequence of the last write-ahead log entry.", metrics.GaugeType, func(s *Stats) float64 { return float64(s.WAL.Sequence) }},
+	{"wal_syncs_total", "fsyncs of the write-ahead log.", metrics.CounterType, func(s *Stats) float64 { return float64(s.WAL.Syncs) }},
+	{"wal_sync_seconds_total", "Time spent in write-ahead log fsyncs.", metrics.CounterType, func(s *Stats) float64 { return seconds(s.WAL.SyncTimeMillis) }},
+	{"indexed_docs_total", "Documents written.", metrics.CounterType, func(s *Stats) float64 { return float64(s.Indexing.IndexTotal) }},
+	{"deletes_total", "Documents deleted.", metrics.CounterType, func(s *Stats) float64 { return float64(s.Indexing.DeleteTotal) }},
+	{"indexing_seconds_total", "Time spent writing and deleting documents.", metrics.CounterType, func(s *Stats) float64 { return seconds(s.Indexing.IndexTimeMillis) }},
+	{"flushes_total", "Memtables flushed to segments.", metrics.CounterType, func(s *Stats) float64 { return float64(s.Indexing.FlushTotal) }},
+	{"flush_seconds_total", "Time spent flushing memtables.", metrics.CounterType, func(s *Stats) float64 { return seconds(s.Indexing.FlushTimeMillis) }},
+	{"merges_total", "Segment merges.", metrics.CounterType, func(s *Stats) float64 { return float64(s.Indexing.MergeTotal) }},
+	{"merge_seconds_total", "Time spent merging segments.", metrics.CounterType, func(s *Stats) float64 { return seconds(s.Indexing.MergeTimeMillis) }},
+	{"merged_docs_total", "Documents copied by merges.", metrics.CounterType, func(s *Stats) float64 { return float64(s.Indexing.MergedDocs) }},
+	{"block_cache_hits_total", "Stored document reads served by a segment's decompressed block cache.", metrics.CounterType, func(s *Stats) float64 { return float64(s.BlockCache.Hits) }},
+	{"block_cache_misses_total", "Stored document reads that decompressed a block.", metrics.CounterType, func(s *Stats) float64 { return float64(s.BlockCache.Misses) }},
+	{"count_requests_total", "Count requests.", metrics.CounterType, func(s *Stats) float64 { return float64(s.Search.CountTotal) }},
+	{"count_seconds_total", "Time spent in count requests.", metrics.CounterType, func(s *Stats) float64 { return seconds(s.Search.CountTimeMillis) }},
+}
+
+// seconds converts a duration in milliseconds to seconds
+func seconds(millis int64) float64 {
+	return float64(millis) / 1000
+}
+
+// Collect reports the engine's metrics (see metrics.Collector): the statistics
+// of each index, labelled with its name, including a search latency
+// histogram, and the filter cache's hit and eviction counts
+// Indexes that fail to report, e.g. because they were deleted meanwhile, are left out
+func (e *Engine) Collect(emit func(*metrics.Family)) {
+	e.mu.RLock()
+	indexes := make([]*Index, 0, len(e.indexes))
+	for _, idx := range e.indexes {
+		indexes = append(indexes, idx)
+	}
+	e.mu.RUnlock()
+	sort.Slice(indexes, func(i, j int) bool {
+		return indexes[i].Name < indexes[j].Name
+	})
+
+	families := make([]*metrics.Family, len(indexMetrics))
+	for i, m := range indexMetrics {
+		families[i] = metrics.NewFamily(metricPrefix+m.name, m.help, m.typ)
+	}
+	latency := metrics.NewFamily(metricPrefix+"search_duration_seconds", "Latency of searches.", metrics.HistogramType)
+
+	// The filter cache is normally shared by every index
+	caches := make(map[*search.FilterCache]bool)
+	for _, idx := range indexes {
+		stats, err := idx.Stats()
+		if err != nil {
+			continue
+		}
+		label := metrics.Label{Name: "index", Value: idx.Name}
+		for i, m := range indexMetrics {
+			families[i].Add(m.value(&stats), label)
+		}
+		latency.AddHistogram(idx.searchStats.latency.Snapshot(), label)
+		if idx.filterCache != nil {
+			caches[idx.filterCache] = true
+		}
+	}
+	for _, f := range families {
+		emit(f)
+	}
+	emit(latency)
+
+	if len(caches) == 0 {
+		return
+	}
+	var cacheStats search.FilterCacheStats
+	for cache := range caches {
+		s := cache.Stats()
+		cacheStats.Hits += s.Hits
+		cacheStats.Misses += s.Misses
+		cacheStats.Evictions += s.Evictions
+		cacheStats.Entries += s.Entries
+		cacheStats.Bytes += s.Bytes
+	}
+	cacheFamilies := []struct {
+		name  string
+		help  string
+		typ   metrics.Type
+		value float64
+	}{
+		{"filter_cache_hits_total", "Filters answered from the filter cache.", metrics.CounterType, float64(cacheStats.Hits)},
+		{"filter_cache_misses_total", "Filters computed because a segment's cached result was missing.", metrics.CounterType, float64(cacheStats.Misses)},
+		{"filter_cache_evictions_total", "Cached filter results dropped for the memory limit.", metrics.CounterType, float64(cacheStats.Evictions)},
+		{"filter_cache_entries", "Cached filter results.", metrics.GaugeType, float64(cacheStats.Entries)},
+		{"filter_cache_bytes", "Memory held by cached filter results.", metrics.GaugeType, float64(cacheStats.Bytes)},
+	}
+	for _, c := range cacheFamilies {
+		f := metrics.NewFamily(metricPrefix+c.name, c.help, c.typ)
+		f.Add(c.value)
+		emit(f)
+	}
+}
@@ -0,0 +1,311 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"nano-elastic/internal/search"
+	"nano-elastic/internal/storage"
+	"nano-elastic/internal/types"
+)
+
+// percolatorFile holds an index's registered queries, in its directory
+const percolatorFile = "percolator.json"
+
+// PercolatorQueryNotFoundError is returned for a registered query that doesn't exist
+type PercolatorQueryNotFoundError struct {
+	Index string
+	ID    string
+}
+
+func (e *PercolatorQueryNotFoundError) Error() string {
+	return fmt.Sprintf("no query [%s] registered on index [%s]", e.ID, e.Index)
+}
+
+// PercolatorQueryError is returned when registering a query that can't be parsed
+type PercolatorQueryError struct {
+	ID  string
+	Err error
+}
+
+func (e *PercolatorQueryError) Error() string {
+	return fmt.Sprintf("invalid percolator query [%s]: %v", e.ID, e.Err)
+}
+
+func (e *PercolatorQueryError) Unwrap() error {
+	return e.Err
+}
+
+// PercolateDocumentError is returned for a percolated document that can't be
+// converted or doesn't fit the schema
+type PercolateDocumentError struct {
+	Slot int
+	Err  error
+}
+
+func (e *PercolateDocumentError) Error() string {
+	return fmt.Sprintf("document %d: %v", e.Slot, e.Err)
+}
+
+func (e *PercolateDocumentError) Unwrap() error {
+	return e.Err
+}
+
+// PercolateMatch is a registered query matching percolated documents
+type PercolateMatch struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"` // Best score of the matching documents
+	Slots []int   `json:"slots"` // Positions of the matching documents, ascending
+}
+
+// registeredQuery is a saved query with the DSL it was parsed from
+type registeredQuery struct {
+	source json.RawMessage
+	query  search.Query
+}
+
+// percolator holds an index's registered queries
+// Its own lock keeps registrations from waiting on writes and searches
+type percolator struct {
+	mu      sync.RWMutex
+	queries map[string]*registeredQuery
+}
+
+// RegisterQuery saves a query under an ID, replacing any of the same ID, so
+// Percolate reports the documents it matches
+// The query is in the search DSL, e.g. {"match": {"level": "error"}}
+func (idx *Index) RegisterQuery(id string, dsl json.RawMessage) error {
+	if id == "" {
+		return &PercolatorQueryError{ID: id, Err: fmt.Errorf("an ID is required")}
+	}
+	query, err := search.ParseQueryDSL(dsl)
+	if err != nil {
+		return &PercolatorQueryError{ID: id, Err: err}
+	}
+
+	idx.percolator.mu.Lock()
+	defer idx.percolator.mu.Unlock()
+
+	queries := make(map[string]*registeredQuery, len(idx.percolator.queries)+1)
+	for name, q := range idx.percolator.queries {
+		queries[name] = q
+	}
+	queries[id] = &registeredQuery{source: append(json.RawMessage(nil), dsl...), query: query}
+	if err := idx.writeRegisteredQueries(queries); err != nil {
+		return err
+	}
+	idx.percolator.queries = queries
+	return nil
+}
+
+// UnregisterQuery removes a registered query
+func (idx *Index) UnregisterQuery(id string) error {
+	idx.percolator.mu.Lock()
+	defer idx.percolator.mu.Unlock()
+
+	if _, ok := idx.percolator.queries[id]; !ok {
+		return &PercolatorQueryNotFoundError{Index: idx.Name, ID: id}
+	}
+	queries := make(map[string]*registeredQuery, len(idx.percolator.queries))
+	for name, q := range idx.percolator.queries {
+		if name != id {
+			queries[name] = q
+		}
+	}
+	if err := idx.writeRegisteredQueries(queries); err != nil {
+		return err
+	}
+	idx.percolator.queries = queries
+	return nil
+}
+
+// RegisteredQueries returns the DSL of every registered query by ID
+func (idx *Index) RegisteredQueries() map[string]json.RawMessage {
+	idx.percolator.mu.RLock()
+	defer idx.percolator.mu.RUnlock()
+
+	queries := make(map[string]json.RawMessage, len(idx.percolator.queries))
+	for id, q := range idx.percolator.queries {
+		queries[id] = q.source
+	}
+	return queries
+}
+
+// RegisteredQuery returns the DSL of a registered query
+func (idx *Index) RegisteredQuery(id string) (json.RawMessage, error) {
+	idx.percolator.mu.RLock()
+	defer idx.percolator.mu.RUnlock()
+
+	q, ok := idx.percolator.queries[id]
+	if !ok {
+		return nil, &PercolatorQueryNotFoundError{Index: idx.Name, ID: id}
+	}
+	return q.source, nil
+}
+
+// PercolateSource is Percolate for raw JSON-style documents
+// Fields a dynamic schema would map are mapped for these documents only;
+// the index's schema doesn't change
+func (idx *Index) PercolateSource(sources ...map[string]interface{}) ([]PercolateMatch, error) {
+	idx.mu.RLock()
+	schema := idx.Schema
+	idx.mu.RUnlock()
+
+	docs := make([]*types.Document, len(sources))
+	for i, source := range sources {
+		changes, err := schema.InferFields(source)
+		if err != nil {
+			return nil, &PercolateDocumentError{Slot: i, Err: err}
+		}
+		if len(changes) > 0 {
+			if schema, err = schema.MapDynamicFields(changes...); err != nil {
+				return nil, &PercolateDocumentError{Slot: i, Err: err}
+			}
+		}
+		if docs[i], err = types.DocumentFromSource("", source, schema); err != nil {
+			return nil, &PercolateDocumentError{Slot: i, Err: err}
+		}
+	}
+	return idx.percolate(schema, docs)
+}
+
+// Percolate returns the registered queries matching any of the documents,
+// sorted by ID, with the positions of the documents each one matches
+// The documents are only searched, not indexed
+func (idx *Index) Percolate(docs ...*types.Document) ([]PercolateMatch, error) {
+	idx.mu.RLock()
+	schema := idx.Schema
+	idx.mu.RUnlock()
+	return idx.percolate(schema, docs)
+}
+
+// percolate indexes the documents in a throwaway in-memory index, under
+// their positions as IDs, and runs every registered query against it
+func (idx *Index) percolate(schema *types.Schema, docs []*types.Document) ([]PercolateMatch, error) {
+	memory := &Index{Name: idx.Name, Schema: schema}
+	if err := memory.resetSearchStructures(); err != nil {
+		return nil, err
+	}
+	for i, doc := range docs {
+		// A copy, so defaults aren't added to the caller's document
+		slot := &types.Document{ID: strconv.Itoa(i), Fields: make(map[string]types.FieldValue, len(doc.Fields))}
+		for name, value := range doc.Fields {
+			slot.Fields[name] = value
+		}
+		if err := schema.ApplyDefaults(slot); err != nil {
+			return nil, &PercolateDocumentError{Slot: i, Err: err}
+		}
+		if err := schema.ValidateDocument(slot); err != nil {
+			return nil, &PercolateDocumentError{Slot: i, Err: err}
+		}
+		memory.indexInMemory(slot)
+	}
+	reader := memory.reader()
+
+	idx.percolator.mu.RLock()
+	queries := idx.percolator.queries
+	idx.percolator.mu.RUnlock()
+
+	ids := make([]string, 0, len(queries))
+	for id := range queries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	matches := []PercolateMatch{}
+	for _, id := range ids {
+		hits, err := queries[id].query.Execute(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to run percolator query %s: %w", id, err)
+		}
+		if len(hits) == 0 {
+			continue
+		}
+		match := PercolateMatch{ID: id}
+		for docID, score := range hits {
+			slot, _ := strconv.Atoi(docID)
+			match.Slots = append(match.Slots, slot)
+			match.Score = max(match.Score, score)
+		}
+		sort.Ints(match.Slots)
+		matches = append(matches, match)
+	}
+	return matches, nil
+}
+
+// loadRegisteredQueries reads the index's registered queries
+func (idx *Index) loadRegisteredQueries() error {
+	queries := make(map[string]*registeredQuery)
+	data, err := idx.fs.ReadFile(filepath.Join(idx.store.BasePath, percolatorFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			idx.percolator.queries = queries
+			return nil
+		}
+		return fmt.Errorf("failed to read percolator queries: %w", err)
+	}
+	var sources map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sources); err != nil {
+		return fmt.Errorf("failed to decode percolator queries: %w", err)
+	}
+	for id, source := range sources {
+		query, err := search.ParseQueryDSL(source)
+		if err != nil {
+			return fmt.Errorf("failed to load percolator query %s: %w", id, err)
+		}
+		queries[id] = &registeredQuery{source: source, query: query}
+	}
+	idx.percolator.queries = queries
+	return nil
+}
+
+// writeRegisteredQueries saves the registered queries atomically (temp file and rename)
+// Caller must hold idx.percolator.mu
+func (idx *Index) writeRegisteredQueries(queries map[string]*registeredQuery) error {
+	data, err := encodeRegisteredQueries(queries)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(idx.store.BasePath, percolatorFile)
+	if err := idx.fs.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write percolator queries: %w", err)
+	}
+	if err := idx.fs.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to commit percolator queries: %w", err)
+	}
+	return nil
+}
+
+// percolatorSnapshotFile returns the registered queries as a file for
+// snapshots, or false if there are none
+func (idx *Index) percolatorSnapshotFile() (storage.IndexFile, bool, error) {
+	idx.percolator.mu.RLock()
+	defer idx.percolator.mu.RUnlock()
+
+	if len(idx.percolator.queries) == 0 {
+		return storage.IndexFile{}, false, nil
+	}
+	data, err := encodeRegisteredQueries(idx.percolator.queries)
+	if err != nil {
+		return storage.IndexFile{}, false, err
+	}
+	return storage.IndexFile{Name: percolatorFile, Data: data}, true, nil
+}
+
+func encodeRegisteredQueries(queries map[string]*registeredQuery) ([]byte, error) {
+	sources := make(map[string]json.RawMessage, len(queries))
+	for id, q := range queries {
+		sources[id] = q.source
+	}
+	data, err := json.MarshalIndent(sources, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode percolator queries: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,255 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"nano-elastic/internal/index/bitmap"
+	"nano-elastic/internal/search"
+	"nano-elastic/internal/storage"
+	"nano-elastic/internal/types"
+)
+
+// DefaultRefreshInterval is how often an index makes new writes searchable
+// when it isn't configured otherwise, as in Elasticsearch
+const DefaultRefreshInterval = time.Second
+
+// WithRefreshInterval sets how often writes are made searchable
+// An interval <= 0 disables automatic refreshes; writes then become searchable
+// on an explicit Refresh
+func WithRefreshInterval(interval time.Duration) Option {
+	return func(idx *Index) {
+		idx.refreshInterval = interval
+	}
+}
+
+// Refresh makes every write made so far visible to searches
+// Searches and their hits see the index as of the last refresh; reading a
+// document by ID always sees the latest write
+func (idx *Index) Refresh() error {
+	// No write may be between its storage write and queueing for the refresh,
+	// so the searcher snapshot matches the search structures
+	idx.lockAllWrites()
+	defer idx.unlockAllWrites()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if len(idx.pending) == 0 && !idx.store.HasExpired() {
+		return nil
+	}
+	return idx.refreshLocked()
+}
+
+// WaitForRefresh blocks until the writes made so far are searchable
+// Without automatic refreshes it refreshes the index itself
+func (idx *Index) WaitForRefresh() error {
+	idx.mu.RLock()
+	if idx.refreshInterval <= 0 {
+		idx.mu.RUnlock()
+		return idx.Refresh()
+	}
+	if len(idx.pending) == 0 {
+		idx.mu.RUnlock()
+		return nil
+	}
+	refreshed := idx.refreshed
+	idx.mu.RUnlock()
+
+	<-refreshed
+	return nil
+}
+
+// refreshLocked applies the pending writes, and the documents merges dropped
+// as expired, to the search structures and replaces the searcher snapshot that
+// hits are loaded from
+// Caller must hold every write stripe and idx.mu for writing
+func (idx *Index) refreshLocked() error {
+	snapshot, expired := idx.store.AcquireSnapshotAndExpired()
+
+	for id, doc := range idx.pending {
+		idx.removeInMemory(id)
+		if doc != nil {
+			idx.indexInMemory(doc)
+		}
+	}
+	idx.pending = make(map[string]*types.Document)
+	for _, id := range expired {
+		// Unless written again since it expired
+		if !snapshot.Contains(id) {
+			idx.removeInMemory(id)
+		}
+	}
+
+	if err := idx.setSearcher(snapshot); err != nil {
+		return err
+	}
+
+	// Wake writers waiting for their writes to be searchable
+	close(idx.refreshed)
+	idx.refreshed = make(chan struct{})
+	return nil
+}
+
+// setSearcher replaces the snapshot searches load their hits from
+// Caller must hold idx.mu for writing
+func (idx *Index) setSearcher(snapshot *storage.Snapshot) error {
+	previous := idx.searcher
+	idx.searcher = snapshot
+	idx.updateSegments(snapshot)
+	if previous != nil {
+		if err := previous.Release(); err != nil {
+			return fmt.Errorf("failed to release searcher snapshot: %w", err)
+		}
+	}
+	return nil
+}
+
+// searchSegment is the part of the searchable documents read from one segment
+type searchSegment struct {
+	docs       *search.SegmentDocs
+	generation uint64 // The segment's storage generation
+}
+
+// bufferedSegmentID identifies the documents only in the memtables
+const bufferedSegmentID = "_buffered"
+
+// updateSegments partitions the searchable documents by the segments of a new
+// searcher snapshot, for the filter cache and parallel searches, and drops the
+// cached filters of the segments that changed or were merged away
+// A segment with the same generation and documents keeps its SegmentDocs, and
+// so its cached filters; the memtables' documents get a new one every time
+// Caller must hold idx.mu for writing
+func (idx *Index) updateSegments(snapshot *storage.Snapshot) {
+	if idx.filterCache == nil && idx.searchPool == nil {
+		return
+	}
+	if snapshot == nil {
+		idx.clearSegments()
+		return
+	}
+
+	segments := make(map[string]searchSegment)
+	segmented := bitmap.New()
+	for _, ss := range snapshot.Segments() {
+		docs := idx.ordinals.Bitmap(ss.DocIDs)
+		segmented = segmented.Or(docs)
+		if previous, ok := idx.segments[ss.ID]; ok && previous.generation == ss.Generation && previous.docs.Docs.Equal(docs) {
+			segments[ss.ID] = previous
+			continue
+		}
+		segments[ss.ID] = searchSegment{docs: &search.SegmentDocs{Key: idx.Name + "/" + ss.ID, Docs: docs, Sketches: ss.Sketches}, generation: ss.Generation}
+	}
+	if buffered := idx.ordinals.Live().AndNot(segmented); !buffered.IsEmpty() {
+		segments[bufferedSegmentID] = searchSegment{docs: &search.SegmentDocs{Key: idx.Name + "/" + bufferedSegmentID, Docs: buffered}}
+	}
+
+	for id, previous := range idx.segments {
+		if current, ok := segments[id]; (!ok || current.docs != previous.docs) && idx.filterCache != nil {
+			idx.filterCache.RemoveSegment(previous.docs.Key)
+		}
+	}
+	idx.segments = segments
+	idx.segmentDocs = make([]*search.SegmentDocs, 0, len(segments))
+	for _, seg := range segments {
+		idx.segmentDocs = append(idx.segmentDocs, seg.docs)
+	}
+}
+
+// clearSegments drops the index's cached filters and segment partition
+// Caller must hold idx.mu for writing
+func (idx *Index) clearSegments() {
+	if idx.filterCache != nil {
+		for _, seg := range idx.segments {
+			idx.filterCache.RemoveSegment(seg.docs.Key)
+		}
+	}
+	idx.segments = nil
+	idx.segmentDocs = nil
+}
+
+// Refresh makes the writes to an index, every index behind an alias, or every
+// index (for an empty name or "_all") visible to searches
+// Returns the number of indexes refreshed
+func (e *Engine) Refresh(name string) (int, error) {
+	var indexes []*Index
+	if name == "" || name == "_all" {
+		e.mu.RLock()
+		for _, idx := range e.indexes {
+			indexes = append(indexes, idx)
+		}
+		e.mu.RUnlock()
+	} else {
+		resolved, err := e.ResolveIndexes(name)
+		if err != nil {
+			return 0, err
+		}
+		indexes = resolved
+	}
+
+	for _, idx := range indexes {
+		if err := idx.Refresh(); err != nil {
+			return 0, fmt.Errorf("failed to refresh index %s: %w", idx.Name, err)
+		}
+	}
+	return len(indexes), nil
+}
+
+// refresher refreshes an index in a background goroutine
+type refresher struct {
+	idx      *Index
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newRefresher(idx *Index, interval time.Duration) *refresher {
+	return &refresher{
+		idx:      idx,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (r *refresher) start() {
+	go r.run()
+}
+
+// close stops the refresher and waits for an in-flight refresh to finish
+func (r *refresher) close() {
+	r.once.Do(func() {
+		close(r.stop)
+		<-r.done
+	})
+}
+
+func (r *refresher) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+
+		// Skip taking the write locks when there is nothing to refresh
+		r.idx.mu.RLock()
+		idle := len(r.idx.pending) == 0 && !r.idx.store.HasExpired()
+		r.idx.mu.RUnlock()
+		if idle {
+			continue
+		}
+
+		// A failed refresh leaves the writes pending for the next tick
+		if err := r.idx.Refresh(); err != nil {
+			r.idx.logger.Error("background refresh failed", "error", err)
+		}
+	}
+}
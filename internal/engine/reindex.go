@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"nano-elastic/internal/search"
+	"nano-elastic/internal/types"
+)
+
+// ReindexFunc transforms a document on its way into the destination index
+// Returning a nil document skips it; returning an error records a failure
+type ReindexFunc func(doc *types.Document) (*types.Document, error)
+
+// ReindexOption configures a reindex
+type ReindexOption func(*reindexConfig)
+
+type reindexConfig struct {
+	query     search.Query
+	batchSize int
+}
+
+// WithReindexQuery copies only the documents matching the query
+func WithReindexQuery(query search.Query) ReindexOption {
+	return func(c *reindexConfig) {
+		c.query = query
+	}
+}
+
+// WithReindexBatchSize sets how many documents are read and written per batch
+// (default DefaultBulkBatchSize)
+func WithReindexBatchSize(n int) ReindexOption {
+	return func(c *reindexConfig) {
+		c.batchSize = n
+	}
+}
+
+// ReindexResult summarizes a reindex
+type ReindexResult struct {
+	Total    int          // Documents read from the source
+	Created  int          // New documents in the destination
+	Updated  int          // Documents that replaced an existing destination document
+	Skipped  int          // Documents the transform dropped
+	Failures []BulkResult // Documents rejected by the transform or the destination schema
+}
+
+// Reindex copies every document of src into dst, applying the optional transform
+// The source is read with a Scroll, so documents indexed in it during the
+// reindex aren't copied and updated ones are copied as they were; documents are validated against dst's schema and
+// written in bulk batches. Per-document failures don't stop the reindex
+func Reindex(src, dst *Index, transform ReindexFunc, options ...ReindexOption) (*ReindexResult, error) {
+	return ReindexContext(context.Background(), src, dst, transform, options...)
+}
+
+// ReindexContext is Reindex, stopped between batches once the context is done
+// Batches written before then stay in the destination
+func ReindexContext(ctx context.Context, src, dst *Index, transform ReindexFunc, options ...ReindexOption) (*ReindexResult, error) {
+	if src == dst {
+		return nil, fmt.Errorf("cannot reindex index %s into itself", src.Name)
+	}
+
+	config := reindexConfig{batchSize: DefaultBulkBatchSize}
+	for _, option := range options {
+		option(&config)
+	}
+
+	scroll, err := src.Scroll(&search.Request{Query: config.query}, config.batchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source index: %w", err)
+	}
+	defer scroll.Close()
+
+	result := &ReindexResult{}
+	bulk := dst.NewBulkIndexer(config.batchSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("reindex stopped: %w", err)
+		}
+		hits, err := scroll.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read source index: %w", err)
+		}
+		if len(hits) == 0 {
+			break
+		}
+
+		for _, hit := range hits {
+			result.Total++
+			doc := hit.Document
+			if transform != nil {
+				if doc, err = transform(doc); err != nil {
+					result.Failures = append(result.Failures, BulkResult{Action: BulkIndex, ID: hit.ID, Err: err})
+					continue
+				}
+				if doc == nil {
+					result.Skipped++
+					continue
+				}
+			}
+			// Map fields the destination hasn't seen, e.g. ones added by the transform
+			if err := dst.MapDocumentFields(doc); err != nil {
+				result.Failures = append(result.Failures, BulkResult{Action: BulkIndex, ID: hit.ID, Err: err})
+				continue
+			}
+			if err := bulk.Index(doc); err != nil {
+				return nil, fmt.Errorf("failed to write destination index: %w", err)
+			}
+		}
+	}
+	if err := bulk.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write destination index: %w", err)
+	}
+
+	for _, r := range bulk.Results() {
+		switch {
+		case r.Err != nil:
+			result.Failures = append(result.Failures, r)
+		case r.Result == ResultCreated:
+			result.Created++
+		case r.Result == ResultUpdated:
+			result.Updated++
+		}
+	}
+	return result, nil
+}
+
+// Reindex copies documents between two named indexes (or single-index aliases)
+// A missing destination is created with a copy of the source schema
+func (e *Engine) Reindex(src, dst string, transform ReindexFunc, options ...ReindexOption) (*ReindexResult, error) {
+	return e.ReindexContext(context.Background(), src, dst, transform, options...)
+}
+
+// ReindexContext is Engine.Reindex, stopped between batches once the context is done
+func (e *Engine) ReindexContext(ctx context.Context, src, dst string, transform ReindexFunc, options ...ReindexOption) (*ReindexResult, error) {
+	srcIndex, err := e.ResolveIndex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := *srcIndex.Schema
+	schema.Name = dst
+	schema.Created = 0 // Set when the destination is created
+	schema.Fields = make(map[string]types.FieldDef, len(srcIndex.Schema.Fields))
+	for name, def := range srcIndex.Schema.Fields {
+		schema.Fields[name] = def
+	}
+	dstIndex, err := e.GetOrCreateIndex(dst, &schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return ReindexContext(ctx, srcIndex, dstIndex, transform, options...)
+}
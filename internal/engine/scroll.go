@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"fmt"
+
+	"nano-elastic/internal/search"
+	"nano-elastic/internal/storage"
+)
+
+// DefaultScrollBatchSize is the number of hits returned per scroll batch
+// when the caller doesn't choose one
+const DefaultScrollBatchSize = 1000
+
+// Scroll iterates every hit of a search in batches, over a point-in-time
+// snapshot of the index: documents indexed after the scroll was opened are
+// skipped, and every hit's document is read as it was then
+// Only the snapshot and a cursor are kept; each batch is searched when it's
+// read, resuming after the cursor like search_after, so a document updated
+// or deleted since the scroll was opened is matched and sorted as it is now
+type Scroll struct {
+	idx       *Index
+	snapshot  *storage.Snapshot
+	req       search.Request
+	sorted    bool          // Whether the caller's request had a sort, so hits keep their sort values
+	cursor    []interface{} // Sort values of the last hit returned, nil before the first batch
+	done      bool
+	total     int
+	batchSize int
+}
+
+// Scroll runs a request and opens a scroll over all of its matches
+// From, Size, Timeout and the max result window are ignored, as are
+// aggregations, suggestions and highlights; batchSize <= 0 uses
+// DefaultScrollBatchSize
+// Without a sort, hits are returned by ID, since scores may change between
+// batches as the index does
+// The caller must Close the scroll to release the snapshot
+func (idx *Index) Scroll(req *search.Request, batchSize int) (*Scroll, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultScrollBatchSize
+	}
+
+	s := &Scroll{
+		idx:       idx,
+		req:       *req,
+		sorted:    len(req.Sort) > 0,
+		batchSize: batchSize,
+	}
+	s.req.From = 0
+	s.req.Size = &batchSize
+	s.req.SearchAfter = nil
+	s.req.Timeout = 0
+	s.req.Aggregations = nil
+	s.req.Suggest = nil
+	s.req.Highlight = nil
+	s.req.Profile = false
+	if !s.sorted {
+		s.req.Sort = []search.SortField{{Field: search.IDField, Order: search.SortAsc}}
+	}
+
+	// Hold the read lock so the total and the searcher snapshot see the same documents
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if err := s.req.Validate(batchSize); err != nil {
+		return nil, err
+	}
+	total, err := search.Count(idx.reader(), req.Query)
+	if err != nil {
+		return nil, err
+	}
+	s.total = total
+	s.snapshot = idx.searcher.Clone()
+	return s, nil
+}
+
+// Total returns the number of documents matching the request when the scroll
+// was opened
+func (s *Scroll) Total() int {
+	return s.total
+}
+
+// Next returns the next batch of hits with their documents
+// An empty batch means the scroll is exhausted
+func (s *Scroll) Next() ([]search.Hit, error) {
+	batch := make([]search.Hit, 0, s.batchSize)
+	for len(batch) < s.batchSize && !s.done {
+		hits, err := s.search(s.batchSize - len(batch))
+		if err != nil {
+			return nil, err
+		}
+		if len(hits) < s.batchSize-len(batch) {
+			s.done = true
+		}
+		for _, hit := range hits {
+			s.cursor = hit.Sort
+			if !s.snapshot.Contains(hit.ID) {
+				continue // Indexed after the scroll was opened
+			}
+			doc, err := s.snapshot.ReadDocument(hit.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load scroll hit %s: %w", hit.ID, err)
+			}
+			hit.Document = doc
+			if !s.sorted {
+				hit.Sort = nil
+			}
+			batch = append(batch, hit)
+		}
+	}
+	return batch, nil
+}
+
+// search returns the next size hits after the cursor, with their sort values
+func (s *Scroll) search(size int) ([]search.Hit, error) {
+	req := s.req
+	req.Size = &size
+	req.SearchAfter = s.cursor
+
+	s.idx.mu.RLock()
+	defer s.idx.mu.RUnlock()
+
+	r := s.idx.reader()
+	r.MaxResultWindow = size
+	resp, err := search.Execute(r, &req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search scroll batch: %w", err)
+	}
+	return resp.Hits, nil
+}
+
+// Close releases the scroll's snapshot
+func (s *Scroll) Close() error {
+	return s.snapshot.Release()
+}
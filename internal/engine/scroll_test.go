@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"nano-elastic/internal/search"
+	"nano-elastic/internal/storage"
+	"nano-elastic/internal/types"
+)
+
+func openScrollIndex(t *testing.T, docs int) *Index {
+	t.Helper()
+	s := types.NewSchema("scroll")
+	s.AddField("n", types.FieldTypeNumeric)
+	s.AddField("tag", types.FieldTypeKeyword)
+	idx, err := OpenIndex("scroll", t.TempDir(), s, WithStorageOptions(storage.WithMaxSegmentDocs(4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	for i := 0; i < docs; i++ {
+		indexScrollDoc(t, idx, fmt.Sprintf("d%02d", i), float64(i%7), "old")
+	}
+	if err := idx.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+func indexScrollDoc(t *testing.T, idx *Index, id string, n float64, tag string) {
+	t.Helper()
+	doc := types.NewDocument(id)
+	doc.SetField("n", types.NumericValue{Value: n})
+	doc.SetField("tag", types.KeywordValue{Value: tag})
+	if err := idx.IndexDocument(doc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readScroll reads a scroll to the end, checking every batch is full but the last
+func readScroll(t *testing.T, scroll *Scroll, batchSize int) []search.Hit {
+	t.Helper()
+	var all []search.Hit
+	for {
+		hits, err := scroll.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hits) == 0 {
+			return all
+		}
+		if len(hits) > batchSize {
+			t.Fatalf("batch of %d hits, batch size is %d", len(hits), batchSize)
+		}
+		if len(all)%batchSize != 0 {
+			t.Fatalf("batch after a short one")
+		}
+		all = append(all, hits...)
+	}
+}
+
+func TestScrollOrder(t *testing.T) {
+	idx := openScrollIndex(t, 20)
+
+	tests := []struct {
+		name string
+		body string
+		want func(i, j search.Hit) bool // Whether i may come before j
+	}{
+		{"unsorted by ID", `{"query": {"match_all": {}}}`, func(i, j search.Hit) bool { return i.ID < j.ID }},
+		{"by field then ID", `{"query": {"match_all": {}}, "sort": [{"n": "desc"}]}`, func(i, j search.Hit) bool {
+			a, b := i.Sort[0].(float64), j.Sort[0].(float64)
+			return a > b || (a == b && i.ID < j.ID)
+		}},
+		{"filtered", `{"query": {"range": {"n": {"gte": 3}}}, "sort": ["n"]}`, func(i, j search.Hit) bool {
+			a, b := i.Sort[0].(float64), j.Sort[0].(float64)
+			return a < b || (a == b && i.ID < j.ID)
+		}},
+	}
+
+	for _, tc := range tests {
+		for _, batchSize := range []int{1, 3, 20, 100} {
+			t.Run(fmt.Sprintf("%s/batch %d", tc.name, batchSize), func(t *testing.T) {
+				req, err := search.ParseSearchRequest([]byte(tc.body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				want, _ := searchIDs(t, idx, tc.body[:len(tc.body)-1]+`, "size": 100}`)
+
+				scroll, err := idx.Scroll(req, batchSize)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer scroll.Close()
+				if scroll.Total() != len(want) {
+					t.Errorf("total = %d, want %d", scroll.Total(), len(want))
+				}
+
+				hits := readScroll(t, scroll, batchSize)
+				seen := make(map[string]bool)
+				for k, hit := range hits {
+					if seen[hit.ID] {
+						t.Fatalf("hit %s returned twice", hit.ID)
+					}
+					seen[hit.ID] = true
+					if hit.Document == nil || hit.Document.ID != hit.ID {
+						t.Fatalf("hit %s has no document", hit.ID)
+					}
+					if k > 0 && !tc.want(hits[k-1], hit) {
+						t.Errorf("hit %s returned before %s", hits[k-1].ID, hit.ID)
+					}
+				}
+				if len(hits) != len(want) {
+					t.Errorf("scrolled %d hits, want %d", len(hits), len(want))
+				}
+				if len(req.Sort) == 0 && len(hits) > 0 && hits[0].Sort != nil {
+					t.Error("unsorted scroll returned sort values")
+				}
+			})
+		}
+	}
+}
+
+func TestScrollPointInTime(t *testing.T) {
+	idx := openScrollIndex(t, 10)
+	req, err := search.ParseSearchRequest([]byte(`{"query": {"match_all": {}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scroll, err := idx.Scroll(req, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scroll.Close()
+
+	first, err := scroll.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Documents indexed or updated between batches, and merges, don't
+	// change what the scroll returns
+	for i := 10; i < 15; i++ {
+		indexScrollDoc(t, idx, fmt.Sprintf("d%02d", i), 1, "new")
+	}
+	indexScrollDoc(t, idx, "a-new", 1, "new") // Sorts before the cursor
+	indexScrollDoc(t, idx, "d05", 1, "new")
+	if err := idx.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.ForceMerge(); err != nil {
+		t.Fatal(err)
+	}
+
+	hits := append(first, readScroll(t, scroll, 3)...)
+	var ids []string
+	for _, hit := range hits {
+		ids = append(ids, hit.ID)
+		if tag := hit.Document.Fields["tag"]; tag != (types.KeywordValue{Value: "old"}) {
+			t.Errorf("hit %s has tag %v, want the document from when the scroll was opened", hit.ID, tag)
+		}
+	}
+	want := []string{"d00", "d01", "d02", "d03", "d04", "d05", "d06", "d07", "d08", "d09"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("scrolled %v, want %v", ids, want)
+	}
+}
@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"time"
+
+	"nano-elastic/internal/analyzer"
+	"nano-elastic/internal/storage"
+	"nano-elastic/internal/types"
+)
+
+// Settings returns the settings saved with the index; unset ones follow the
+// index's options
+func (idx *Index) Settings() types.Settings {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.Schema.IndexSettings()
+}
+
+// UpdateSettings changes the index's dynamic settings and saves them with its
+// schema; settings left unset in update keep their values
+// A changed refresh interval restarts the background refresher, and segment and
+// WAL thresholds apply from the next write
+// Returns a *types.SettingsError for an invalid value or a changed static setting
+func (idx *Index) UpdateSettings(update types.Settings) error {
+	idx.refreshMu.Lock()
+	defer idx.refreshMu.Unlock()
+
+	idx.mu.Lock()
+	settings := idx.Schema.IndexSettings().Merge(update)
+	if err := validateSettings(settings); err != nil {
+		idx.mu.Unlock()
+		return err
+	}
+	if err := idx.store.UpdateSettings(settings); err != nil {
+		idx.mu.Unlock()
+		return err
+	}
+	idx.Schema = idx.store.Schema
+	previous := idx.refreshInterval
+	idx.applySettings(settings)
+	interval := idx.refreshInterval
+	idx.mu.Unlock()
+
+	if interval != previous {
+		// A running refresh needs idx.mu, so the refresher is stopped without it
+		if idx.refresher != nil {
+			idx.refresher.close()
+			idx.refresher = nil
+		}
+		idx.startRefresher(interval)
+	}
+	idx.logger.Info("index settings updated")
+	return nil
+}
+
+// applySettings overrides the index's options with its saved settings
+// Caller must hold idx.mu for writing (or be opening the index)
+func (idx *Index) applySettings(settings types.Settings) {
+	if settings.MaxResultWindow > 0 {
+		idx.maxResultWindow = settings.MaxResultWindow
+	}
+	if settings.RefreshInterval != 0 {
+		idx.refreshInterval = settings.RefreshInterval // Negative disables automatic refreshes
+	}
+}
+
+// startRefresher starts refreshing the index in the background, unless
+// interval disables it
+// Caller must hold idx.refreshMu (or be opening the index)
+func (idx *Index) startRefresher(interval time.Duration) {
+	if interval > 0 {
+		idx.refresher = newRefresher(idx, interval)
+		idx.refresher.start()
+	}
+}
+
+// validateSettings checks the settings, including the names of the durability
+// and analyzer the storage and search structures look up
+func validateSettings(settings types.Settings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	if settings.Durability != "" {
+		if _, err := storage.DurabilityByName(settings.Durability); err != nil {
+			return &types.SettingsError{Setting: "durability", Reason: err.Error()}
+		}
+	}
+	if settings.DefaultAnalyzer != "" {
+		if _, err := analyzer.Lookup(settings.DefaultAnalyzer); err != nil {
+			return &types.SettingsError{Setting: "default_analyzer", Reason: err.Error()}
+		}
+	}
+	return nil
+}
+
+// UpdateSettings changes an index's dynamic settings (see Index.UpdateSettings)
+func (e *Engine) UpdateSettings(name string, settings types.Settings) error {
+	idx, err := e.ResolveIndex(name)
+	if err != nil {
+		return err
+	}
+	return idx.UpdateSettings(settings)
+}
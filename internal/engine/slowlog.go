@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"time"
+
+	"nano-elastic/internal/logging"
+	"nano-elastic/internal/search"
+)
+
+// WithSlowLog records searches taking at least threshold, with their query,
+// hit counts, phase timings and segments, as warnings
+// A nil logger writes them to the index's logger (see WithLogger); a
+// threshold <= 0 records nothing
+func WithSlowLog(threshold time.Duration, logger logging.Logger) Option {
+	return func(idx *Index) {
+		idx.slowLogThreshold = threshold
+		idx.slowLog = logger
+	}
+}
+
+// logSlowSearch records a search in the slow log if it took at least the
+// threshold, counting the caller's parse time
+// Caller must hold idx.mu
+func (idx *Index) logSlowSearch(req *search.Request, resp *search.Response, took time.Duration) {
+	if idx.slowLogThreshold <= 0 || took < idx.slowLogThreshold {
+		return
+	}
+	timings := resp.Timings
+	idx.slowLog.Warn("slow search",
+		"took", took,
+		"threshold", idx.slowLogThreshold,
+		"query", search.FormatQuery(req.Query),
+		"from", req.From,
+		"size", req.PageSize(),
+		"total", resp.Total,
+		"hits", len(resp.Hits),
+		"timed_out", resp.TimedOut,
+		"parse_time", timings.Parse,
+		"query_time", timings.Query,
+		"aggregate_time", timings.Aggregate,
+		"sort_time", timings.Sort,
+		"fetch_time", timings.Fetch,
+		"segments", idx.searcher.SegmentIDs(),
+	)
+}
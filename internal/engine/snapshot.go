@@ -0,0 +1,369 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"nano-elastic/internal/snapshot"
+	"nano-elastic/internal/storage"
+)
+
+// repositoriesFile is the name of the snapshot repository registry kept in the data directory
+const repositoriesFile = "repositories.json"
+
+// RepositoryConfig is a registered snapshot repository (see snapshot.NewRepository)
+type RepositoryConfig struct {
+	Type     string            `json:"type"`
+	Settings map[string]string `json:"settings"`
+}
+
+// RepositoryNotFoundError is returned for operations on an unregistered repository
+type RepositoryNotFoundError struct {
+	Name string
+}
+
+func (e *RepositoryNotFoundError) Error() string {
+	return fmt.Sprintf("[%s] missing", e.Name)
+}
+
+// RepositoryError is returned for repository configurations that can't be used
+type RepositoryError struct {
+	Name   string
+	Reason error
+}
+
+func (e *RepositoryError) Error() string {
+	return fmt.Sprintf("[%s] %v", e.Name, e.Reason)
+}
+
+func (e *RepositoryError) Unwrap() error {
+	return e.Reason
+}
+
+// WithRepositoryAllowlist limits where the engine's snapshot repositories may
+// keep their blobs; without it no fs repository can be registered, and s3 ones
+// only with AWS endpoints
+// Like WithFS, it only applies to an engine
+func WithRepositoryAllowlist(allowlist snapshot.Allowlist) Option {
+	return func(idx *Index) {
+		idx.repositoryAllowlist = allowlist
+	}
+}
+
+// PutRepository registers a snapshot repository, replacing any with the same name
+// The repository is opened first, so invalid settings and locations or
+// endpoints outside the allowlist (see WithRepositoryAllowlist) are rejected
+func (e *Engine) PutRepository(name string, config RepositoryConfig) error {
+	if !validIndexName.MatchString(name) {
+		return &RepositoryError{Name: name, Reason: fmt.Errorf("invalid repository name: must be lowercase letters, digits, '-' or '_'")}
+	}
+	if _, err := e.repositoryAllowlist.NewRepository(config.Type, config.Settings); err != nil {
+		return &RepositoryError{Name: name, Reason: err}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	repositories := make(map[string]RepositoryConfig, len(e.repositories)+1)
+	for n, c := range e.repositories {
+		repositories[n] = c
+	}
+	repositories[name] = config
+	if err := e.writeRepositories(repositories); err != nil {
+		return err
+	}
+	e.repositories = repositories
+	return nil
+}
+
+// DeleteRepository unregisters a repository; the snapshots in it are kept
+func (e *Engine) DeleteRepository(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.repositories[name]; !ok {
+		return &RepositoryNotFoundError{Name: name}
+	}
+	repositories := make(map[string]RepositoryConfig, len(e.repositories))
+	for n, c := range e.repositories {
+		if n != name {
+			repositories[n] = c
+		}
+	}
+	if err := e.writeRepositories(repositories); err != nil {
+		return err
+	}
+	e.repositories = repositories
+	return nil
+}
+
+// Repositories returns a copy of the registered repositories
+func (e *Engine) Repositories() map[string]RepositoryConfig {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	repositories := make(map[string]RepositoryConfig, len(e.repositories))
+	for name, config := range e.repositories {
+		repositories[name] = config
+	}
+	return repositories
+}
+
+// CreateSnapshot snapshots indexes into a repository; no names means every index
+// Names may be aliases. Only the files that aren't in the repository yet are
+// uploaded (see snapshot.Create); writes not yet refreshed are included
+func (e *Engine) CreateSnapshot(repoName string, name string, indexNames []string) (*snapshot.Info, error) {
+	var indexes []*Index
+	if len(indexNames) == 0 {
+		e.mu.RLock()
+		for _, idx := range e.indexes {
+			indexes = append(indexes, idx)
+		}
+		e.mu.RUnlock()
+	} else {
+		seen := make(map[string]bool)
+		for _, indexName := range indexNames {
+			resolved, err := e.ResolveIndexes(indexName)
+			if err != nil {
+				return nil, err
+			}
+			for _, idx := range resolved {
+				if !seen[idx.Name] {
+					seen[idx.Name] = true
+					indexes = append(indexes, idx)
+				}
+			}
+		}
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i].Name < indexes[j].Name })
+
+	e.snapshotMu.Lock()
+	defer e.snapshotMu.Unlock()
+
+	repo, err := e.openRepository(repoName)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make([]snapshot.Source, 0, len(indexes))
+	defer func() {
+		for _, source := range sources {
+			source.Files.Release()
+		}
+	}()
+	for _, idx := range indexes {
+		files, err := idx.acquireFiles()
+		if err != nil {
+			return nil, fmt.Errorf("failed to snapshot index %s: %w", idx.Name, err)
+		}
+		sources = append(sources, snapshot.Source{Index: idx.Name, Files: files})
+	}
+	return snapshot.Create(repo, name, sources)
+}
+
+// acquireFiles pins the index's files for a snapshot
+func (idx *Index) acquireFiles() (*storage.IndexFiles, error) {
+	// Holding mu keeps the index from being closed meanwhile
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	files, err := idx.store.AcquireFiles()
+	if err != nil {
+		return nil, err
+	}
+	// Registered queries are kept outside the store
+	file, ok, err := idx.percolatorSnapshotFile()
+	if err != nil {
+		files.Release()
+		return nil, err
+	}
+	if ok {
+		files.Files = append(files.Files, file)
+	}
+	return files, nil
+}
+
+// Snapshots returns the snapshots in a repository, oldest first
+func (e *Engine) Snapshots(repoName string) ([]snapshot.Info, error) {
+	e.snapshotMu.Lock()
+	defer e.snapshotMu.Unlock()
+
+	repo, err := e.openRepository(repoName)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.List(repo)
+}
+
+// DeleteSnapshot removes a snapshot, and the files only it used, from a repository
+func (e *Engine) DeleteSnapshot(repoName string, name string) error {
+	e.snapshotMu.Lock()
+	defer e.snapshotMu.Unlock()
+
+	repo, err := e.openRepository(repoName)
+	if err != nil {
+		return err
+	}
+	return snapshot.Delete(repo, name)
+}
+
+// RestoreSnapshot recreates indexes from a snapshot; no names means every index
+// in it. rename, if not nil, maps each snapshotted index to the name to restore
+// it as; the target indexes must not exist
+// Returns the names of the restored indexes
+func (e *Engine) RestoreSnapshot(repoName string, name string, indexNames []string, rename func(string) string) ([]string, error) {
+	e.snapshotMu.Lock()
+	defer e.snapshotMu.Unlock()
+
+	repo, err := e.openRepository(repoName)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := snapshot.Load(repo, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(indexNames) == 0 {
+		indexNames = manifest.Indexes
+	}
+
+	// Check every target before restoring any, so a bad request restores nothing
+	targets := make([]string, len(indexNames))
+	for i, indexName := range indexNames {
+		if _, ok := manifest.Files[indexName]; !ok {
+			return nil, &IndexNotFoundError{Name: indexName}
+		}
+		targets[i] = indexName
+		if rename != nil {
+			targets[i] = rename(indexName)
+		}
+		if err := ValidateIndexName(targets[i]); err != nil {
+			return nil, err
+		}
+		for _, other := range targets[:i] {
+			if other == targets[i] {
+				return nil, &IndexExistsError{Name: targets[i]}
+			}
+		}
+	}
+
+	if err := e.checkRestoreTargets(targets); err != nil {
+		return nil, err
+	}
+
+	for i, target := range targets {
+		if err := e.restoreIndex(repo, manifest, indexNames[i], target); err != nil {
+			return targets[:i], err
+		}
+	}
+	return targets, nil
+}
+
+// checkRestoreTargets fails if any of the names is taken by an index or alias
+func (e *Engine) checkRestoreTargets(targets []string) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, target := range targets {
+		if _, ok := e.indexes[target]; ok {
+			return &IndexExistsError{Name: target}
+		}
+		if _, ok := e.aliases[target]; ok {
+			return &AliasError{Alias: target, Reason: "an alias with the same name exists"}
+		}
+	}
+	return nil
+}
+
+// restoreIndex downloads an index of a snapshot and opens it as target
+// The files go to a directory that isn't a valid index name, and are only
+// moved into place once complete, so the engine never sees a partial index
+func (e *Engine) restoreIndex(repo snapshot.Repository, manifest *snapshot.Manifest, index string, target string) error {
+	tmpDir := filepath.Join(e.dataPath, target+".restoring")
+	if err := snapshot.RestoreIndex(repo, manifest, index, e.fs, tmpDir); err != nil {
+		return err
+	}
+	defer e.fs.RemoveAll(tmpDir)
+
+	// Another caller may have taken the name during the download
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.indexes[target]; ok {
+		return &IndexExistsError{Name: target}
+	}
+	if _, ok := e.aliases[target]; ok {
+		return &AliasError{Alias: target, Reason: "an alias with the same name exists"}
+	}
+
+	dir := filepath.Join(e.dataPath, target)
+	if err := e.fs.Rename(tmpDir, dir); err != nil {
+		return fmt.Errorf("failed to restore index %s: %w", target, err)
+	}
+	schema, err := storage.LoadSchema(e.fs, e.dataPath, target)
+	if err != nil {
+		e.fs.RemoveAll(dir)
+		return fmt.Errorf("failed to restore index %s: %w", target, err)
+	}
+	// The schema keeps the snapshotted index's name
+	schema.Name = target
+	idx, err := OpenIndex(target, e.dataPath, schema, e.options...)
+	if err != nil {
+		e.fs.RemoveAll(dir)
+		return fmt.Errorf("failed to open restored index %s: %w", target, err)
+	}
+	e.indexes[target] = idx
+	return nil
+}
+
+// openRepository opens a registered repository
+// It's checked against the allowlist again, which may have changed since it was registered
+func (e *Engine) openRepository(name string) (snapshot.Repository, error) {
+	e.mu.RLock()
+	config, ok := e.repositories[name]
+	e.mu.RUnlock()
+	if !ok {
+		return nil, &RepositoryNotFoundError{Name: name}
+	}
+	repo, err := e.repositoryAllowlist.NewRepository(config.Type, config.Settings)
+	if err != nil {
+		return nil, &RepositoryError{Name: name, Reason: err}
+	}
+	return repo, nil
+}
+
+// readRepositories loads the repository registry, which is absent until a repository is registered
+func (e *Engine) readRepositories() (map[string]RepositoryConfig, error) {
+	repositories := make(map[string]RepositoryConfig)
+	data, err := e.fs.ReadFile(filepath.Join(e.dataPath, repositoriesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return repositories, nil
+		}
+		return nil, fmt.Errorf("failed to read repositories: %w", err)
+	}
+	if err := json.Unmarshal(data, &repositories); err != nil {
+		return nil, fmt.Errorf("failed to decode repositories: %w", err)
+	}
+	return repositories, nil
+}
+
+// writeRepositories saves the repository registry atomically (temp file and rename)
+func (e *Engine) writeRepositories(repositories map[string]RepositoryConfig) error {
+	data, err := json.MarshalIndent(repositories, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode repositories: %w", err)
+	}
+
+	path := filepath.Join(e.dataPath, repositoriesFile)
+	if err := e.fs.WriteFile(path+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("failed to write repositories: %w", err)
+	}
+	if err := e.fs.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to commit repositories: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"nano-elastic/internal/snapshot"
+)
+
+func TestPutRepositoryAllowlist(t *testing.T) {
+	dir := t.TempDir()
+	repos := filepath.Join(dir, "repos")
+	data := filepath.Join(dir, "data")
+	e, err := NewEngine(data, WithRepositoryAllowlist(snapshot.Allowlist{
+		Paths:     []string{repos},
+		Endpoints: []string{"http://localhost:9000"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	tests := []struct {
+		name    string
+		config  RepositoryConfig
+		allowed bool
+	}{
+		{"fs inside", RepositoryConfig{Type: "fs", Settings: map[string]string{"location": filepath.Join(repos, "backups")}}, true},
+		{"fs relative", RepositoryConfig{Type: "fs", Settings: map[string]string{"location": "nightly"}}, true},
+		{"fs escape", RepositoryConfig{Type: "fs", Settings: map[string]string{"location": "../../etc"}}, false},
+		{"fs in the data path", RepositoryConfig{Type: "fs", Settings: map[string]string{"location": data}}, false},
+		{"s3 allowed endpoint", RepositoryConfig{Type: "s3", Settings: map[string]string{"bucket": "b", "endpoint": "http://localhost:9000"}}, true},
+		{"s3 other endpoint", RepositoryConfig{Type: "s3", Settings: map[string]string{"bucket": "b", "endpoint": "http://169.254.169.254"}}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := e.PutRepository("repo", tc.config)
+			if tc.allowed {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var repoErr *RepositoryError
+			if !errors.As(err, &repoErr) {
+				t.Fatalf("PutRepository() = %v, want a RepositoryError", err)
+			}
+		})
+	}
+	if _, err := os.Stat(filepath.Join(dir, "etc")); !os.IsNotExist(err) {
+		t.Error("rejected repository directory was created")
+	}
+}
+
+func TestRepositoryAllowlistOnReopen(t *testing.T) {
+	dir := t.TempDir()
+	repos := filepath.Join(dir, "repos")
+	data := filepath.Join(dir, "data")
+	e, err := NewEngine(data, WithRepositoryAllowlist(snapshot.Allowlist{Paths: []string{repos}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.PutRepository("backups", RepositoryConfig{Type: "fs", Settings: map[string]string{"location": repos}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Registered repositories are checked against the allowlist the engine
+	// runs with, not the one they were registered under
+	e, err = NewEngine(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	if _, err := e.openRepository("backups"); err == nil {
+		t.Error("repository outside the allowlist was opened")
+	}
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"sync/atomic"
+	"time"
+
+	"nano-elastic/internal/metrics"
+	"nano-elastic/internal/storage"
+)
+
+// Stats is a point-in-time summary of an index: its storage, search
+// structures and search counters
+type Stats struct {
+	storage.IndexStats
+	SearchableDocs int         `json:"searchable_docs"` // Documents visible to searches, as of the last refresh
+	Terms          TermStats   `json:"terms"`
+	Search         SearchStats `json:"search"`
+}
+
+// TermStats describes the inverted index's term dictionary
+type TermStats struct {
+	UniqueTerms int `json:"unique_terms"` // Entries in the term dictionary
+	TotalTerms  int `json:"total_terms"`  // Indexed tokens across all documents
+}
+
+// SearchStats counts the searches run since the index was opened
+type SearchStats struct {
+	QueryTotal      int64 `json:"query_total"`
+	QueryTimeMillis int64 `json:"query_time_in_millis"`
+	CountTotal      int64 `json:"count_total"`
+	CountTimeMillis int64 `json:"count_time_in_millis"`
+}
+
+// searchCounters accumulates SearchStats without locking
+type searchCounters struct {
+	queryTotal atomic.Int64
+	queryNanos atomic.Int64
+	countTotal atomic.Int64
+	countNanos atomic.Int64
+	latency    *metrics.Histogram // Of searches, exported by Engine.Collect
+}
+
+// newSearchCounters creates counters with a latency histogram of
+// metrics.DefaultLatencyBuckets
+func newSearchCounters() searchCounters {
+	return searchCounters{latency: metrics.NewHistogram(metrics.DefaultLatencyBuckets)}
+}
+
+// recordQuery counts a search that started at start
+func (c *searchCounters) recordQuery(start time.Time) {
+	elapsed := time.Since(start)
+	c.queryTotal.Add(1)
+	c.queryNanos.Add(int64(elapsed))
+	c.latency.Observe(elapsed.Seconds())
+}
+
+// recordCount counts a count request that started at start
+func (c *searchCounters) recordCount(start time.Time) {
+	c.countTotal.Add(1)
+	c.countNanos.Add(int64(time.Since(start)))
+}
+
+// snapshot returns the current counter values
+func (c *searchCounters) snapshot() SearchStats {
+	return SearchStats{
+		QueryTotal:      c.queryTotal.Load(),
+		QueryTimeMillis: time.Duration(c.queryNanos.Load()).Milliseconds(),
+		CountTotal:      c.countTotal.Load(),
+		CountTimeMillis: time.Duration(c.countNanos.Load()).Milliseconds(),
+	}
+}
+
+// Stats returns a snapshot of the index's storage statistics (see
+// storage.IndexManager.Stats), term dictionary size and search counters
+func (idx *Index) Stats() (Stats, error) {
+	storeStats, err := idx.store.Stats()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	totalTerms, _, uniqueTerms := idx.inverted.GetStats()
+	return Stats{
+		IndexStats:     storeStats,
+		SearchableDocs: len(idx.docIDs),
+		Terms:          TermStats{UniqueTerms: uniqueTerms, TotalTerms: totalTerms},
+		Search:         idx.searchStats.snapshot(),
+	}, nil
+}
@@ -0,0 +1,164 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"nano-elastic/internal/search"
+)
+
+// templatesFile is the name of the search template table kept in the data directory
+const templatesFile = "templates.json"
+
+// TemplateNotFoundError is returned for a search template that doesn't exist
+type TemplateNotFoundError struct {
+	Name string
+}
+
+func (e *TemplateNotFoundError) Error() string {
+	return fmt.Sprintf("search template [%s] not found", e.Name)
+}
+
+// TemplateError is returned for invalid search templates, and templates that
+// can't be rendered with the parameters given
+type TemplateError struct {
+	Name string
+	Err  error
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("search template [%s]: %v", e.Name, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// PutTemplate saves a search template, replacing any with the same name
+func (e *Engine) PutTemplate(name string, template *search.Template) error {
+	if !validIndexName.MatchString(name) {
+		return &TemplateError{Name: name, Err: fmt.Errorf("name must be lowercase letters, digits, '-' or '_'")}
+	}
+	if err := template.Validate(); err != nil {
+		return &TemplateError{Name: name, Err: err}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	templates := make(map[string]*search.Template, len(e.templates)+1)
+	for n, t := range e.templates {
+		templates[n] = t
+	}
+	templates[name] = template
+	if err := e.writeTemplates(templates); err != nil {
+		return err
+	}
+	e.templates = templates
+	return nil
+}
+
+// DeleteTemplate removes a search template
+func (e *Engine) DeleteTemplate(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.templates[name]; !ok {
+		return &TemplateNotFoundError{Name: name}
+	}
+	templates := make(map[string]*search.Template, len(e.templates))
+	for n, t := range e.templates {
+		if n != name {
+			templates[n] = t
+		}
+	}
+	if err := e.writeTemplates(templates); err != nil {
+		return err
+	}
+	e.templates = templates
+	return nil
+}
+
+// Templates returns a copy of the search templates
+func (e *Engine) Templates() map[string]*search.Template {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	templates := make(map[string]*search.Template, len(e.templates))
+	for name, template := range e.templates {
+		templates[name] = template
+	}
+	return templates
+}
+
+// Template returns a search template by name
+func (e *Engine) Template(name string) (*search.Template, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	template, ok := e.templates[name]
+	if !ok {
+		return nil, &TemplateNotFoundError{Name: name}
+	}
+	return template, nil
+}
+
+// RenderTemplate renders a saved search template with parameters into a
+// search request
+func (e *Engine) RenderTemplate(name string, params map[string]interface{}) (*search.Request, error) {
+	template, err := e.Template(name)
+	if err != nil {
+		return nil, err
+	}
+	req, err := template.Request(params)
+	if err != nil {
+		return nil, &TemplateError{Name: name, Err: err}
+	}
+	return req, nil
+}
+
+// SearchTemplate runs a saved search template, rendered with parameters,
+// against an index or alias (see SearchContext)
+func (e *Engine) SearchTemplate(ctx context.Context, index string, name string, params map[string]interface{}) (*search.Response, error) {
+	req, err := e.RenderTemplate(name, params)
+	if err != nil {
+		return nil, err
+	}
+	return e.SearchContext(ctx, index, req)
+}
+
+// readTemplates loads the search templates, which are absent until one is added
+func (e *Engine) readTemplates() (map[string]*search.Template, error) {
+	templates := make(map[string]*search.Template)
+	data, err := e.fs.ReadFile(filepath.Join(e.dataPath, templatesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return templates, nil
+		}
+		return nil, fmt.Errorf("failed to read search templates: %w", err)
+	}
+	if err := json.Unmarshal(data, &templates); err != nil {
+		return nil, fmt.Errorf("failed to decode search templates: %w", err)
+	}
+	return templates, nil
+}
+
+// writeTemplates saves the search templates atomically (temp file and rename)
+func (e *Engine) writeTemplates(templates map[string]*search.Template) error {
+	data, err := json.MarshalIndent(templates, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode search templates: %w", err)
+	}
+
+	path := filepath.Join(e.dataPath, templatesFile)
+	if err := e.fs.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write search templates: %w", err)
+	}
+	if err := e.fs.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to commit search templates: %w", err)
+	}
+	return nil
+}
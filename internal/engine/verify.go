@@ -0,0 +1,181 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+
+	"nano-elastic/internal/index/inverted"
+	"nano-elastic/internal/storage"
+	"nano-elastic/internal/types"
+)
+
+// VerifyOption configures a Verify run
+type VerifyOption func(*verifyConfig)
+
+type verifyConfig struct {
+	repair bool
+}
+
+// WithRepair makes Verify rebuild what it can from the stored documents: the
+// segments' doc indexes (see storage.WithRepair) and, if they or the search
+// structures were found wrong, the search structures
+func WithRepair() VerifyOption {
+	return func(c *verifyConfig) {
+		c.repair = true
+	}
+}
+
+// VerifyReport is what Verify found in an index's storage and search
+// structures, and what it repaired
+type VerifyReport struct {
+	storage.VerifyReport
+	Documents int // Searchable documents checked against the inverted index
+	Postings  int // Text postings checked
+}
+
+// Verify checks an index's storage (see storage.IndexManager.Verify) and that
+// its inverted index holds exactly the terms of the searchable documents: each
+// stored document's analyzed text fields against its postings, and every
+// posting against a searchable document
+// Writes and refreshes wait while it runs
+func (idx *Index) Verify(options ...VerifyOption) (*VerifyReport, error) {
+	config := verifyConfig{}
+	for _, option := range options {
+		option(&config)
+	}
+
+	idx.lockAllWrites()
+	defer idx.unlockAllWrites()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	var storeOptions []storage.VerifyOption
+	if config.repair {
+		storeOptions = append(storeOptions, storage.WithRepair())
+	}
+	stored, err := idx.store.Verify(storeOptions...)
+	if err != nil {
+		return nil, err
+	}
+	report := &VerifyReport{VerifyReport: *stored}
+
+	problems := len(report.Problems)
+	if err := idx.verifySearch(report); err != nil {
+		return nil, err
+	}
+	if !config.repair || (len(report.Problems) == problems && len(report.Repaired) == 0) {
+		return report, nil
+	}
+
+	// Rebuilt like on open, from a new snapshot: the searcher read through
+	// the old doc indexes. Pending writes are in the stored documents, so
+	// they become searchable too
+	if err := idx.load(); err != nil {
+		return nil, fmt.Errorf("failed to rebuild search structures: %w", err)
+	}
+	close(idx.refreshed)
+	idx.refreshed = make(chan struct{})
+	report.Repaired = append(report.Repaired, "search structures")
+	return report, nil
+}
+
+// verifySearch checks the search structures against the searcher snapshot
+// they were built from, adding a problem for each kind of mismatch
+// Caller must hold every write stripe and idx.mu for writing
+func (idx *Index) verifySearch(report *VerifyReport) error {
+	// A wrong index is usually wrong for many documents, so each kind of
+	// mismatch is reported once, with a count and the first occurrence
+	mismatches := make(map[string][]string)
+	mismatch := func(kind string, example string) {
+		mismatches[kind] = append(mismatches[kind], example)
+	}
+
+	searchable := make(map[string]bool)
+	expected := make(map[string]int) // Field -> postings of the searchable documents
+	missing := make(map[string]int)  // Field -> of those, postings not found
+	for name, def := range idx.Schema.Fields {
+		if def.Type == types.FieldTypeText && idx.Schema.IsIndexed(name) {
+			expected[name] = 0
+		}
+	}
+	err := idx.searcher.Scan(func(doc *types.Document) error {
+		report.Documents++
+		searchable[doc.ID] = true
+		if _, ok := idx.docIDs[doc.ID]; !ok {
+			mismatch("stored documents are not searchable", doc.ID)
+			return nil
+		}
+		ord, ok := idx.ordinals.Ordinal(doc.ID)
+		if !ok {
+			mismatch("searchable documents have no ordinal", doc.ID)
+			return nil
+		}
+		for name, texts := range idx.Schema.TextValues(doc) {
+			freqs := make(map[string]int)
+			for _, text := range texts {
+				for _, token := range idx.inverted.AnalyzeText(name, text) {
+					freqs[token.Term]++
+				}
+			}
+			expected[name] += len(freqs)
+			for term, freq := range freqs {
+				pl := idx.inverted.TermPostings(name, term)
+				var posting *inverted.Posting
+				if pl != nil {
+					posting, _ = pl.GetPosting(ord)
+				}
+				if posting == nil {
+					missing[name]++
+					mismatch("terms of stored documents have no posting", fmt.Sprintf("%s:%s of %s", name, term, doc.ID))
+				} else if posting.TermFreq != freq {
+					mismatch("postings have the wrong term frequency",
+						fmt.Sprintf("%s:%s of %s, %d instead of %d", name, term, doc.ID, posting.TermFreq, freq))
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read searchable documents: %w", err)
+	}
+
+	for id := range idx.docIDs {
+		if !searchable[id] {
+			mismatch("searchable documents are not stored", id)
+		}
+	}
+
+	// Every posting must belong to a searchable document; a document can't
+	// have more postings than the terms the forward pass found
+	for name, want := range expected {
+		found := 0
+		idx.inverted.Terms(name, func(term string, pl *inverted.PostingList) bool {
+			for _, posting := range pl.Postings {
+				report.Postings++
+				id := idx.ordinals.ID(posting.Doc)
+				if ord, ok := idx.ordinals.Ordinal(id); !ok || ord != posting.Doc || !searchable[id] {
+					mismatch("postings belong to no searchable document", fmt.Sprintf("%s:%s of ordinal %d", name, term, posting.Doc))
+					continue
+				}
+				found++
+			}
+			return true
+		})
+		if extra := found - (want - missing[name]); extra > 0 {
+			mismatch("postings are for terms not in their stored document", fmt.Sprintf("%d in %s", extra, name))
+		}
+	}
+
+	kinds := make([]string, 0, len(mismatches))
+	for kind := range mismatches {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		examples := mismatches[kind]
+		sort.Strings(examples)
+		report.Problems = append(report.Problems, fmt.Errorf("%d %s, e.g. %s", len(examples), kind, examples[0]))
+	}
+	return nil
+}
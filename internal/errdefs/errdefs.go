@@ -0,0 +1,43 @@
+package errdefs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors callers branch on with errors.Is
+// The packages' own error types match them, so the type carries the details
+// (e.g. DocNotFoundError's ID or storage.CorruptionError's offset) while the
+// caller only needs this package
+var (
+	// ErrDocNotFound is matched by reads and deletes of documents that don't exist
+	ErrDocNotFound = errors.New("document not found")
+
+	// ErrVersionConflict is matched by writes conflicting with the document's
+	// current state, e.g. creating a document whose ID is taken
+	ErrVersionConflict = errors.New("version conflict")
+
+	// ErrCorruptSegment is matched by storage files that fail checksum or
+	// framing validation: segment records, their sidecars, and the WAL
+	ErrCorruptSegment = errors.New("corrupt segment")
+
+	// ErrSchemaValidation is matched by documents that don't fit their index's mappings
+	ErrSchemaValidation = errors.New("schema validation failed")
+
+	// ErrIndexNotFound is matched by operations on an index that doesn't exist
+	ErrIndexNotFound = errors.New("index not found")
+)
+
+// DocNotFoundError is returned for a document ID that has no live document
+type DocNotFoundError struct {
+	ID string
+}
+
+func (e *DocNotFoundError) Error() string {
+	return fmt.Sprintf("document not found: %s", e.ID)
+}
+
+// Is matches ErrDocNotFound
+func (e *DocNotFoundError) Is(target error) bool {
+	return target == ErrDocNotFound
+}
@@ -0,0 +1,511 @@
+package bitmap
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// Bitmap is a compressed set of uint32 values in the style of roaring bitmaps
+// Values are grouped by their high 16 bits into containers holding the low 16
+// bits: a sorted array while the container is sparse, a 65536-bit bitset once
+// it is dense. Sets of dense document ordinals stay small, and AND, OR and NOT
+// work a container at a time instead of a value at a time
+// A Bitmap is not safe for concurrent modification
+type Bitmap struct {
+	keys       []uint16 // High 16 bits of each container, sorted
+	containers []*container
+}
+
+// New creates an empty bitmap
+func New() *Bitmap {
+	return &Bitmap{}
+}
+
+// Of creates a bitmap holding the given values
+func Of(values ...uint32) *Bitmap {
+	b := New()
+	for _, v := range values {
+		b.Add(v)
+	}
+	return b
+}
+
+// find returns the position of a container key, and whether it exists
+func (b *Bitmap) find(key uint16) (int, bool) {
+	i := sort.Search(len(b.keys), func(i int) bool { return b.keys[i] >= key })
+	return i, i < len(b.keys) && b.keys[i] == key
+}
+
+// Add inserts a value, returning false if it was already present
+func (b *Bitmap) Add(v uint32) bool {
+	key := uint16(v >> 16)
+	i, ok := b.find(key)
+	if !ok {
+		b.keys = append(b.keys, 0)
+		copy(b.keys[i+1:], b.keys[i:])
+		b.keys[i] = key
+		b.containers = append(b.containers, nil)
+		copy(b.containers[i+1:], b.containers[i:])
+		b.containers[i] = &container{}
+	}
+	return b.containers[i].add(uint16(v))
+}
+
+// Remove deletes a value, returning false if it wasn't present
+func (b *Bitmap) Remove(v uint32) bool {
+	i, ok := b.find(uint16(v >> 16))
+	if !ok || !b.containers[i].remove(uint16(v)) {
+		return false
+	}
+	if b.containers[i].cardinality() == 0 {
+		b.keys = append(b.keys[:i], b.keys[i+1:]...)
+		b.containers = append(b.containers[:i], b.containers[i+1:]...)
+	}
+	return true
+}
+
+// Contains reports whether a value is in the bitmap
+func (b *Bitmap) Contains(v uint32) bool {
+	if b == nil {
+		return false
+	}
+	i, ok := b.find(uint16(v >> 16))
+	return ok && b.containers[i].contains(uint16(v))
+}
+
+// Cardinality returns the number of values in the bitmap
+func (b *Bitmap) Cardinality() int {
+	if b == nil {
+		return 0
+	}
+	n := 0
+	for _, c := range b.containers {
+		n += c.cardinality()
+	}
+	return n
+}
+
+// IsEmpty reports whether the bitmap has no values
+func (b *Bitmap) IsEmpty() bool {
+	return b == nil || len(b.keys) == 0
+}
+
+// Min returns the smallest value, and false if the bitmap is empty
+func (b *Bitmap) Min() (uint32, bool) {
+	if b.IsEmpty() {
+		return 0, false
+	}
+	return uint32(b.keys[0])<<16 | uint32(b.containers[0].min()), true
+}
+
+// Max returns the largest value, and false if the bitmap is empty
+func (b *Bitmap) Max() (uint32, bool) {
+	if b.IsEmpty() {
+		return 0, false
+	}
+	last := len(b.keys) - 1
+	return uint32(b.keys[last])<<16 | uint32(b.containers[last].max()), true
+}
+
+// Clone returns an independent copy of the bitmap
+func (b *Bitmap) Clone() *Bitmap {
+	clone := &Bitmap{
+		keys:       append([]uint16(nil), b.keys...),
+		containers: make([]*container, len(b.containers)),
+	}
+	for i, c := range b.containers {
+		clone.containers[i] = c.clone()
+	}
+	return clone
+}
+
+// And returns the values in both bitmaps
+func (b *Bitmap) And(other *Bitmap) *Bitmap {
+	result := New()
+	i, j := 0, 0
+	for i < len(b.keys) && j < len(other.keys) {
+		switch {
+		case b.keys[i] < other.keys[j]:
+			i++
+		case b.keys[i] > other.keys[j]:
+			j++
+		default:
+			result.appendContainer(b.keys[i], b.containers[i].and(other.containers[j]))
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// Or returns the values in either bitmap
+func (b *Bitmap) Or(other *Bitmap) *Bitmap {
+	result := New()
+	i, j := 0, 0
+	for i < len(b.keys) || j < len(other.keys) {
+		switch {
+		case j == len(other.keys) || (i < len(b.keys) && b.keys[i] < other.keys[j]):
+			result.appendContainer(b.keys[i], b.containers[i].clone())
+			i++
+		case i == len(b.keys) || b.keys[i] > other.keys[j]:
+			result.appendContainer(other.keys[j], other.containers[j].clone())
+			j++
+		default:
+			result.appendContainer(b.keys[i], b.containers[i].or(other.containers[j]))
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// AndNot returns the values in this bitmap that are not in other
+func (b *Bitmap) AndNot(other *Bitmap) *Bitmap {
+	result := New()
+	j := 0
+	for i, key := range b.keys {
+		for j < len(other.keys) && other.keys[j] < key {
+			j++
+		}
+		if j < len(other.keys) && other.keys[j] == key {
+			result.appendContainer(key, b.containers[i].andNot(other.containers[j]))
+			continue
+		}
+		result.appendContainer(key, b.containers[i].clone())
+	}
+	return result
+}
+
+// Equal reports whether both bitmaps hold the same values
+func (b *Bitmap) Equal(other *Bitmap) bool {
+	if b.IsEmpty() || other.IsEmpty() {
+		return b.IsEmpty() == other.IsEmpty()
+	}
+	if len(b.keys) != len(other.keys) {
+		return false
+	}
+	for i, key := range b.keys {
+		if other.keys[i] != key || !b.containers[i].equal(other.containers[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// SizeInBytes estimates the memory held by the bitmap's containers
+func (b *Bitmap) SizeInBytes() int {
+	if b == nil {
+		return 0
+	}
+	size := len(b.keys) * (2 + 8) // Key and container pointer
+	for _, c := range b.containers {
+		size += c.sizeInBytes()
+	}
+	return size
+}
+
+// appendContainer adds a container after the existing ones, dropping empty containers
+func (b *Bitmap) appendContainer(key uint16, c *container) {
+	if c.cardinality() == 0 {
+		return
+	}
+	b.keys = append(b.keys, key)
+	b.containers = append(b.containers, c)
+}
+
+// ForEach calls fn with every value in ascending order until fn returns false
+func (b *Bitmap) ForEach(fn func(v uint32) bool) {
+	if b == nil {
+		return
+	}
+	for i, c := range b.containers {
+		if !c.forEach(uint32(b.keys[i])<<16, fn) {
+			return
+		}
+	}
+}
+
+// ToArray returns the values in ascending order
+func (b *Bitmap) ToArray() []uint32 {
+	values := make([]uint32, 0, b.Cardinality())
+	b.ForEach(func(v uint32) bool {
+		values = append(values, v)
+		return true
+	})
+	return values
+}
+
+// arrayMaxSize is the largest array container; beyond it a bitset is smaller
+const arrayMaxSize = 4096
+
+// bitsetWords is the number of 64-bit words in a bitset container
+const bitsetWords = 1 << 16 / 64
+
+// container holds the low 16 bits of the values sharing a high 16 bits
+// Exactly one of array (sorted) and bitset is in use
+type container struct {
+	array  []uint16
+	bitset []uint64
+	n      int // Cardinality of the bitset
+}
+
+func (c *container) cardinality() int {
+	if c.bitset == nil {
+		return len(c.array)
+	}
+	return c.n
+}
+
+// min returns the smallest value of a non-empty container
+func (c *container) min() uint16 {
+	if c.bitset == nil {
+		return c.array[0]
+	}
+	for w, word := range c.bitset {
+		if word != 0 {
+			return uint16(w*64 + bits.TrailingZeros64(word))
+		}
+	}
+	return 0
+}
+
+// max returns the largest value of a non-empty container
+func (c *container) max() uint16 {
+	if c.bitset == nil {
+		return c.array[len(c.array)-1]
+	}
+	for w := len(c.bitset) - 1; w >= 0; w-- {
+		if word := c.bitset[w]; word != 0 {
+			return uint16(w*64 + 63 - bits.LeadingZeros64(word))
+		}
+	}
+	return 0
+}
+
+func (c *container) search(v uint16) (int, bool) {
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= v })
+	return i, i < len(c.array) && c.array[i] == v
+}
+
+func (c *container) contains(v uint16) bool {
+	if c.bitset != nil {
+		return c.bitset[v>>6]&(1<<(v&63)) != 0
+	}
+	_, ok := c.search(v)
+	return ok
+}
+
+func (c *container) add(v uint16) bool {
+	if c.bitset != nil {
+		mask := uint64(1) << (v & 63)
+		if c.bitset[v>>6]&mask != 0 {
+			return false
+		}
+		c.bitset[v>>6] |= mask
+		c.n++
+		return true
+	}
+
+	i, ok := c.search(v)
+	if ok {
+		return false
+	}
+	if len(c.array) >= arrayMaxSize {
+		c.toBitset()
+		return c.add(v)
+	}
+	c.array = append(c.array, 0)
+	copy(c.array[i+1:], c.array[i:])
+	c.array[i] = v
+	return true
+}
+
+func (c *container) remove(v uint16) bool {
+	if c.bitset != nil {
+		mask := uint64(1) << (v & 63)
+		if c.bitset[v>>6]&mask == 0 {
+			return false
+		}
+		c.bitset[v>>6] &^= mask
+		c.n--
+		c.normalize()
+		return true
+	}
+
+	i, ok := c.search(v)
+	if !ok {
+		return false
+	}
+	c.array = append(c.array[:i], c.array[i+1:]...)
+	return true
+}
+
+// toBitset switches an array container to a bitset
+func (c *container) toBitset() {
+	c.bitset = c.asBitset()
+	c.n = len(c.array)
+	c.array = nil
+}
+
+// asBitset returns the container's values as a bitset, shared for bitset containers
+func (c *container) asBitset() []uint64 {
+	if c.bitset != nil {
+		return c.bitset
+	}
+	bitset := make([]uint64, bitsetWords)
+	for _, v := range c.array {
+		bitset[v>>6] |= 1 << (v & 63)
+	}
+	return bitset
+}
+
+// normalize switches a bitset container back to an array once it is sparse
+func (c *container) normalize() {
+	if c.bitset == nil || c.n > arrayMaxSize {
+		return
+	}
+	array := make([]uint16, 0, c.n)
+	for w, word := range c.bitset {
+		for word != 0 {
+			array = append(array, uint16(w*64+bits.TrailingZeros64(word)))
+			word &= word - 1
+		}
+	}
+	c.array, c.bitset, c.n = array, nil, 0
+}
+
+// fromBitset creates a container from a bitset, counting its values
+func fromBitset(bitset []uint64) *container {
+	c := &container{bitset: bitset}
+	for _, word := range bitset {
+		c.n += bits.OnesCount64(word)
+	}
+	c.normalize()
+	return c
+}
+
+func (c *container) equal(other *container) bool {
+	if c.cardinality() != other.cardinality() {
+		return false
+	}
+	if c.bitset == nil && other.bitset == nil {
+		for i, v := range c.array {
+			if other.array[i] != v {
+				return false
+			}
+		}
+		return true
+	}
+	a, b := c.asBitset(), other.asBitset()
+	for i, word := range a {
+		if b[i] != word {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *container) sizeInBytes() int {
+	if c.bitset != nil {
+		return bitsetWords * 8
+	}
+	return len(c.array) * 2
+}
+
+func (c *container) clone() *container {
+	if c.bitset != nil {
+		return &container{bitset: append([]uint64(nil), c.bitset...), n: c.n}
+	}
+	return &container{array: append([]uint16(nil), c.array...)}
+}
+
+func (c *container) and(other *container) *container {
+	switch {
+	case c.bitset == nil || other.bitset == nil:
+		small, large := c, other
+		if small.bitset != nil {
+			small, large = other, c
+		}
+		result := &container{}
+		for _, v := range small.array {
+			if large.contains(v) {
+				result.array = append(result.array, v)
+			}
+		}
+		return result
+	}
+	bitset := make([]uint64, bitsetWords)
+	for w := range bitset {
+		bitset[w] = c.bitset[w] & other.bitset[w]
+	}
+	return fromBitset(bitset)
+}
+
+func (c *container) or(other *container) *container {
+	if c.bitset == nil && other.bitset == nil && len(c.array)+len(other.array) <= arrayMaxSize {
+		result := &container{array: make([]uint16, 0, len(c.array)+len(other.array))}
+		i, j := 0, 0
+		for i < len(c.array) || j < len(other.array) {
+			switch {
+			case j == len(other.array) || (i < len(c.array) && c.array[i] < other.array[j]):
+				result.array = append(result.array, c.array[i])
+				i++
+			case i == len(c.array) || c.array[i] > other.array[j]:
+				result.array = append(result.array, other.array[j])
+				j++
+			default:
+				result.array = append(result.array, c.array[i])
+				i++
+				j++
+			}
+		}
+		return result
+	}
+
+	a, b := c.asBitset(), other.asBitset()
+	bitset := make([]uint64, bitsetWords)
+	for w := range bitset {
+		bitset[w] = a[w] | b[w]
+	}
+	return fromBitset(bitset)
+}
+
+func (c *container) andNot(other *container) *container {
+	if c.bitset == nil {
+		result := &container{}
+		for _, v := range c.array {
+			if !other.contains(v) {
+				result.array = append(result.array, v)
+			}
+		}
+		return result
+	}
+
+	b := other.asBitset()
+	bitset := make([]uint64, bitsetWords)
+	for w := range bitset {
+		bitset[w] = c.bitset[w] &^ b[w]
+	}
+	return fromBitset(bitset)
+}
+
+// forEach calls fn with each value, offset by high, until fn returns false
+func (c *container) forEach(high uint32, fn func(v uint32) bool) bool {
+	if c.bitset == nil {
+		for _, v := range c.array {
+			if !fn(high | uint32(v)) {
+				return false
+			}
+		}
+		return true
+	}
+	for w, word := range c.bitset {
+		for word != 0 {
+			if !fn(high | uint32(w*64+bits.TrailingZeros64(word))) {
+				return false
+			}
+			word &= word - 1
+		}
+	}
+	return true
+}
@@ -0,0 +1,287 @@
+package completion
+
+import (
+	"container/heap"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"nano-elastic/internal/types"
+)
+
+// Suggestion is one completion of a prefix
+type Suggestion struct {
+	Text   string // The input as indexed, before normalization
+	DocID  string
+	Weight int
+}
+
+// entry is an input ending at a trie node
+type entry struct {
+	text   string
+	docID  string
+	weight int
+}
+
+// node is one byte of the normalized inputs
+// maxWeight bounds the weights below it, so lookups visit the heaviest
+// completions first and stop once they have enough
+type node struct {
+	label     byte
+	children  []*node // Sorted by label
+	entries   []entry
+	maxWeight int
+}
+
+// child returns the child with a label, if any
+func (n *node) child(label byte) (*node, int, bool) {
+	i := sort.Search(len(n.children), func(i int) bool { return n.children[i].label >= label })
+	if i < len(n.children) && n.children[i].label == label {
+		return n.children[i], i, true
+	}
+	return nil, i, false
+}
+
+// updateMaxWeight recomputes the node's bound from its entries and children
+func (n *node) updateMaxWeight() {
+	n.maxWeight = -1
+	for _, e := range n.entries {
+		n.maxWeight = max(n.maxWeight, e.weight)
+	}
+	for _, c := range n.children {
+		n.maxWeight = max(n.maxWeight, c.maxWeight)
+	}
+}
+
+// fieldIndex is one completion field's trie, with each document's inputs for removal
+type fieldIndex struct {
+	root *node
+	docs map[string][]types.CompletionInput
+}
+
+// CompletionIndex holds the inputs of completion fields in one trie per field,
+// keyed by the normalized input, so the top weighted completions of a prefix
+// are found by walking the prefix and then visiting the heaviest subtrees first
+type CompletionIndex struct {
+	fields map[string]*fieldIndex
+	mu     sync.RWMutex
+}
+
+// NewCompletionIndex creates an empty completion index
+func NewCompletionIndex() *CompletionIndex {
+	return &CompletionIndex{
+		fields: make(map[string]*fieldIndex),
+	}
+}
+
+// Normalize returns the form inputs and prefixes are matched in: lowercased,
+// with runs of whitespace collapsed to one space and trimmed at the start
+func Normalize(text string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.TrimLeftFunc(text, unicode.IsSpace) {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	if space {
+		b.WriteByte(' ') // A trailing space asks for the next word
+	}
+	return b.String()
+}
+
+// IndexDocument adds the inputs of every completion field of a document
+func (ci *CompletionIndex) IndexDocument(doc *types.Document) {
+	for name, value := range doc.Fields {
+		if v, ok := value.(types.CompletionValue); ok {
+			ci.IndexValue(doc.ID, name, v.Inputs)
+		}
+	}
+}
+
+// IndexValue adds a document's inputs for a field
+// Indexing a field again for the same document replaces the old inputs
+func (ci *CompletionIndex) IndexValue(docID string, fieldName string, inputs []types.CompletionInput) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	fi, ok := ci.fields[fieldName]
+	if !ok {
+		fi = &fieldIndex{root: &node{maxWeight: -1}, docs: make(map[string][]types.CompletionInput)}
+		ci.fields[fieldName] = fi
+	}
+	fi.remove(docID)
+	for _, in := range inputs {
+		fi.insert(Normalize(in.Input), entry{text: in.Input, docID: docID, weight: in.Weight})
+	}
+	fi.docs[docID] = inputs
+}
+
+// insert adds an entry under a normalized key
+func (fi *fieldIndex) insert(key string, e entry) {
+	n := fi.root
+	n.maxWeight = max(n.maxWeight, e.weight)
+	for i := 0; i < len(key); i++ {
+		c, at, ok := n.child(key[i])
+		if !ok {
+			c = &node{label: key[i], maxWeight: -1}
+			n.children = append(n.children, nil)
+			copy(n.children[at+1:], n.children[at:])
+			n.children[at] = c
+		}
+		c.maxWeight = max(c.maxWeight, e.weight)
+		n = c
+	}
+	n.entries = append(n.entries, e)
+}
+
+// remove drops a document's entries, tightening the weight bounds and
+// pruning nodes left empty
+func (fi *fieldIndex) remove(docID string) {
+	inputs, ok := fi.docs[docID]
+	if !ok {
+		return
+	}
+	for _, in := range inputs {
+		key := Normalize(in.Input)
+		path := []*node{fi.root}
+		n := fi.root
+		for i := 0; i < len(key) && n != nil; i++ {
+			n, _, _ = n.child(key[i])
+			path = append(path, n)
+		}
+		if n == nil {
+			continue
+		}
+		kept := n.entries[:0]
+		for _, e := range n.entries {
+			if e.docID != docID {
+				kept = append(kept, e)
+			}
+		}
+		n.entries = kept
+
+		for i := len(path) - 1; i >= 0; i-- {
+			path[i].updateMaxWeight()
+			if i > 0 && len(path[i].entries) == 0 && len(path[i].children) == 0 {
+				parent := path[i-1]
+				if _, at, ok := parent.child(path[i].label); ok {
+					parent.children = append(parent.children[:at], parent.children[at+1:]...)
+				}
+			}
+		}
+	}
+	delete(fi.docs, docID)
+}
+
+// RemoveDocument removes a document's inputs from every field
+func (ci *CompletionIndex) RemoveDocument(docID string) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	for _, fi := range ci.fields {
+		fi.remove(docID)
+	}
+}
+
+// Suggest returns up to size completions of a prefix, heaviest first
+// skipDuplicates returns each distinct text once, from its heaviest document
+// allow, if not nil, limits the documents suggested, e.g. to searchable ones
+func (ci *CompletionIndex) Suggest(fieldName string, prefix string, size int, skipDuplicates bool, allow func(docID string) bool) []Suggestion {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+
+	fi, ok := ci.fields[fieldName]
+	if !ok || size <= 0 {
+		return nil
+	}
+	key := Normalize(prefix)
+	n := fi.root
+	for i := 0; i < len(key) && n != nil; i++ {
+		n, _, _ = n.child(key[i])
+	}
+	if n == nil {
+		return nil
+	}
+
+	// Best-first over subtrees and entries: a subtree is expanded when its
+	// bound is the heaviest left, so every entry popped outweighs what remains
+	queue := &candidateQueue{{node: n, weight: n.maxWeight}}
+	var out []Suggestion
+	seen := make(map[string]struct{})
+	for queue.Len() > 0 && len(out) < size {
+		c := heap.Pop(queue).(candidate)
+		if c.node == nil {
+			if allow != nil && !allow(c.entry.docID) {
+				continue
+			}
+			if skipDuplicates {
+				if _, dup := seen[c.entry.text]; dup {
+					continue
+				}
+				seen[c.entry.text] = struct{}{}
+			}
+			out = append(out, Suggestion{Text: c.entry.text, DocID: c.entry.docID, Weight: c.entry.weight})
+			continue
+		}
+		for _, e := range c.node.entries {
+			heap.Push(queue, candidate{entry: e, weight: e.weight})
+		}
+		for _, child := range c.node.children {
+			heap.Push(queue, candidate{node: child, weight: child.maxWeight})
+		}
+	}
+	return out
+}
+
+// HasField reports whether any document has inputs for the field
+func (ci *CompletionIndex) HasField(fieldName string) bool {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+
+	fi, ok := ci.fields[fieldName]
+	return ok && len(fi.docs) > 0
+}
+
+// candidate is a subtree (node set) or a single entry waiting to be visited
+type candidate struct {
+	node   *node
+	entry  entry
+	weight int
+}
+
+// candidateQueue is a max-heap of candidates by weight; entries come before
+// subtrees of the same weight, so they are returned without expanding them
+type candidateQueue []candidate
+
+func (q candidateQueue) Len() int { return len(q) }
+func (q candidateQueue) Less(i, j int) bool {
+	if q[i].weight != q[j].weight {
+		return q[i].weight > q[j].weight
+	}
+	if (q[i].node == nil) != (q[j].node == nil) {
+		return q[i].node == nil
+	}
+	if q[i].node == nil {
+		if q[i].entry.text != q[j].entry.text {
+			return q[i].entry.text < q[j].entry.text
+		}
+		return q[i].entry.docID < q[j].entry.docID
+	}
+	return q[i].node.label < q[j].node.label
+}
+func (q candidateQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *candidateQueue) Push(x any)   { *q = append(*q, x.(candidate)) }
+func (q *candidateQueue) Pop() any {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,149 @@
+package docid
+
+import (
+	"sync"
+
+	"nano-elastic/internal/index/bitmap"
+)
+
+// Ordinals maps string document IDs to dense uint32 ordinals, so sets of
+// documents can be kept as bitmaps
+// An ID keeps its ordinal while the map lives, including across deletes and
+// re-indexing; deleted ordinals are tracked in a bitmap rather than reused.
+// The map is rebuilt, and ordinals compacted, when the index is reloaded
+type Ordinals struct {
+	ordinals map[string]uint32
+	ids      []string       // ID of each ordinal
+	live     *bitmap.Bitmap // Ordinals of live documents
+	deleted  *bitmap.Bitmap // Ordinals of deleted documents
+
+	mu sync.RWMutex
+}
+
+// NewOrdinals creates an empty ordinal map
+func NewOrdinals() *Ordinals {
+	return &Ordinals{
+		ordinals: make(map[string]uint32),
+		live:     bitmap.New(),
+		deleted:  bitmap.New(),
+	}
+}
+
+// Add marks a document live and returns its ordinal, assigning the next one to a new ID
+func (o *Ordinals) Add(id string) uint32 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	ord := o.assign(id)
+	o.live.Add(ord)
+	o.deleted.Remove(ord)
+	return ord
+}
+
+// Assign returns a document's ordinal, assigning the next one to a new ID
+// Unlike Add it doesn't mark the document live, e.g. for indexes that share the
+// dictionary but not the document lifecycle
+func (o *Ordinals) Assign(id string) uint32 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.assign(id)
+}
+
+// assign is Assign for callers holding o.mu
+func (o *Ordinals) assign(id string) uint32 {
+	ord, ok := o.ordinals[id]
+	if !ok {
+		ord = uint32(len(o.ids))
+		o.ordinals[id] = ord
+		o.ids = append(o.ids, id)
+	}
+	return ord
+}
+
+// Remove marks a document deleted
+func (o *Ordinals) Remove(id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if ord, ok := o.ordinals[id]; ok && o.live.Remove(ord) {
+		o.deleted.Add(ord)
+	}
+}
+
+// Lookup returns the ordinal assigned to a document, live or not
+func (o *Ordinals) Lookup(id string) (uint32, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	ord, ok := o.ordinals[id]
+	return ord, ok
+}
+
+// Ordinal returns the ordinal of a live document
+func (o *Ordinals) Ordinal(id string) (uint32, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	ord, ok := o.ordinals[id]
+	if !ok || !o.live.Contains(ord) {
+		return 0, false
+	}
+	return ord, true
+}
+
+// ID returns the document ID of an ordinal
+func (o *Ordinals) ID(ord uint32) string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	if int(ord) >= len(o.ids) {
+		return ""
+	}
+	return o.ids[ord]
+}
+
+// Live returns a copy of the bitmap of live documents
+func (o *Ordinals) Live() *bitmap.Bitmap {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return o.live.Clone()
+}
+
+// Deleted returns a copy of the bitmap of deleted documents
+func (o *Ordinals) Deleted() *bitmap.Bitmap {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return o.deleted.Clone()
+}
+
+// Bitmap returns the ordinals of the given live documents, skipping unknown IDs
+func (o *Ordinals) Bitmap(ids []string) *bitmap.Bitmap {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	b := bitmap.New()
+	for _, id := range ids {
+		if ord, ok := o.ordinals[id]; ok && o.live.Contains(ord) {
+			b.Add(ord)
+		}
+	}
+	return b
+}
+
+// IDs returns the document IDs of a bitmap of ordinals
+func (o *Ordinals) IDs(b *bitmap.Bitmap) []string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	ids := make([]string, 0, b.Cardinality())
+	b.ForEach(func(ord uint32) bool {
+		if int(ord) < len(o.ids) {
+			ids = append(ids, o.ids[ord])
+		}
+		return true
+	})
+	return ids
+}
@@ -0,0 +1,189 @@
+package docvalues
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"nano-elastic/internal/index/hll"
+	"nano-elastic/internal/types"
+)
+
+// Kind identifies how a doc value is stored and compared
+type Kind uint8
+
+const (
+	KindNumeric Kind = iota + 1 // float64
+	KindKeyword                 // exact string
+	KindDate                    // epoch milliseconds
+	KindBoolean                 // 0 or 1, so false sorts before true
+)
+
+// Value is a single field value for one document, in sortable form
+type Value struct {
+	Kind Kind
+	Num  float64 // Numeric, date and boolean values
+	Str  string  // Keyword values
+}
+
+// Compare orders two values of the same kind: -1, 0 or 1
+func (v Value) Compare(other Value) int {
+	if v.Kind == KindKeyword {
+		return strings.Compare(v.Str, other.Str)
+	}
+	switch {
+	case v.Num < other.Num:
+		return -1
+	case v.Num > other.Num:
+		return 1
+	}
+	return 0
+}
+
+// Hash returns the hash cardinality sketches record the value by (see
+// hll.Sketch): keywords by their bytes, other kinds by their numeric value
+func (v Value) Hash() uint64 {
+	if v.Kind == KindKeyword {
+		return hll.Hash([]byte(v.Str))
+	}
+	return hll.HashFloat(v.Num)
+}
+
+// Interface returns the value as a plain Go value for API responses
+func (v Value) Interface() interface{} {
+	switch v.Kind {
+	case KindKeyword:
+		return v.Str
+	case KindDate:
+		return int64(v.Num) // Epoch millis, like Elasticsearch's date sort values
+	case KindBoolean:
+		return v.Num != 0
+	}
+	return v.Num
+}
+
+// FromFieldValue converts a document field to a doc value
+// Returns false for field types that have no doc values (text, vectors)
+func FromFieldValue(value types.FieldValue) (Value, bool) {
+	switch v := value.(type) {
+	case types.NumericValue:
+		return Value{Kind: KindNumeric, Num: v.Value}, true
+	case types.KeywordValue:
+		return Value{Kind: KindKeyword, Str: v.Value}, true
+	case types.DateValue:
+		return Value{Kind: KindDate, Num: float64(v.Value.UnixMilli())}, true
+	case types.BooleanValue:
+		if v.Value {
+			return Value{Kind: KindBoolean, Num: 1}, true
+		}
+		return Value{Kind: KindBoolean, Num: 0}, true
+	}
+	return Value{}, false
+}
+
+// FieldValue converts the doc value back to a document field, e.g. for a field
+// that isn't stored (dates keep millisecond precision)
+func (v Value) FieldValue() types.FieldValue {
+	switch v.Kind {
+	case KindKeyword:
+		return types.KeywordValue{Value: v.Str}
+	case KindDate:
+		return types.DateValue{Value: time.UnixMilli(int64(v.Num)).UTC()}
+	case KindBoolean:
+		return types.BooleanValue{Value: v.Num != 0}
+	}
+	return types.NumericValue{Value: v.Num}
+}
+
+// Store holds per-field, column-oriented values so sorting and aggregations
+// can read a field for many documents without deserializing stored documents
+type Store struct {
+	// columns maps field -> docID -> value
+	columns map[string]map[string]Value
+	mu      sync.RWMutex
+}
+
+// NewStore creates an empty doc values store
+func NewStore() *Store {
+	return &Store{
+		columns: make(map[string]map[string]Value),
+	}
+}
+
+// Set records a document's value for a field
+func (s *Store) Set(docID string, fieldName string, value Value) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	column, ok := s.columns[fieldName]
+	if !ok {
+		column = make(map[string]Value)
+		s.columns[fieldName] = column
+	}
+	column[docID] = value
+}
+
+// IndexDocument records doc values for every supported field of a document
+func (s *Store) IndexDocument(doc *types.Document) {
+	for name, fieldValue := range doc.Fields {
+		if value, ok := FromFieldValue(fieldValue); ok {
+			s.Set(doc.ID, name, value)
+		}
+	}
+}
+
+// Load adds every value from a set of columns
+func (s *Store) Load(columns Columns) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, column := range columns {
+		dst, ok := s.columns[name]
+		if !ok {
+			dst = make(map[string]Value, len(column))
+			s.columns[name] = dst
+		}
+		for id, value := range column {
+			dst[id] = value
+		}
+	}
+}
+
+// Column returns a snapshot of one field's values, keyed by document ID
+func (s *Store) Column(fieldName string) map[string]Value {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	column := make(map[string]Value, len(s.columns[fieldName]))
+	for id, value := range s.columns[fieldName] {
+		column[id] = value
+	}
+	return column
+}
+
+// Get returns a document's value for a field
+func (s *Store) Get(fieldName string, docID string) (Value, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.columns[fieldName][docID]
+	return value, ok
+}
+
+// RemoveDocument removes a document's values from every field
+func (s *Store) RemoveDocument(docID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, column := range s.columns {
+		delete(column, docID)
+	}
+}
+
+// HasField reports whether any document has doc values for the field
+func (s *Store) HasField(fieldName string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.columns[fieldName]) > 0
+}
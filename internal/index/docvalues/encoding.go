@@ -0,0 +1,164 @@
+package docvalues
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Columns holds doc values grouped by field: field -> docID -> value
+type Columns map[string]map[string]Value
+
+// Set records a document's value for a field
+func (c Columns) Set(docID string, fieldName string, value Value) {
+	column, ok := c[fieldName]
+	if !ok {
+		column = make(map[string]Value)
+		c[fieldName] = column
+	}
+	column[docID] = value
+}
+
+// RemoveDocument removes a document's values from every field
+func (c Columns) RemoveDocument(docID string) {
+	for _, column := range c {
+		delete(column, docID)
+	}
+}
+
+// Encode serializes the columns, one field after another so each field's
+// values are contiguous. Fields and documents are written in sorted order
+//
+//	[fieldCount:uint32]
+//	per field: [nameLen:uint16][name][count:uint32]
+//	           per doc: [idLen:uint16][id][kind:uint8][value]
+//
+// value is a float64 for numeric, date and boolean kinds and
+// [len:uint32][bytes] for keywords
+func (c Columns) Encode() []byte {
+	fields := make([]string, 0, len(c))
+	for name := range c {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(len(fields)))
+	for _, name := range fields {
+		column := c[name]
+		buf = appendString16(buf, name)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(column)))
+
+		ids := make([]string, 0, len(column))
+		for id := range column {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			value := column[id]
+			buf = appendString16(buf, id)
+			buf = append(buf, byte(value.Kind))
+			if value.Kind == KindKeyword {
+				buf = binary.LittleEndian.AppendUint32(buf, uint32(len(value.Str)))
+				buf = append(buf, value.Str...)
+			} else {
+				buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(value.Num))
+			}
+		}
+	}
+	return buf
+}
+
+// DecodeColumns parses columns written by Encode
+func DecodeColumns(data []byte) (Columns, error) {
+	d := decoder{data: data}
+	fieldCount := d.uint32()
+	columns := make(Columns, fieldCount)
+	for i := uint32(0); i < fieldCount && d.err == nil; i++ {
+		name := d.string16()
+		count := d.uint32()
+		column := make(map[string]Value, count)
+		for j := uint32(0); j < count && d.err == nil; j++ {
+			id := d.string16()
+			value := Value{Kind: Kind(d.uint8())}
+			switch value.Kind {
+			case KindKeyword:
+				value.Str = d.string32()
+			case KindNumeric, KindDate, KindBoolean:
+				value.Num = math.Float64frombits(d.uint64())
+			default:
+				return nil, fmt.Errorf("unknown doc value kind %d for field %s", value.Kind, name)
+			}
+			column[id] = value
+		}
+		columns[name] = column
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return columns, nil
+}
+
+// appendString16 appends a string with a uint16 length prefix
+func appendString16(buf []byte, s string) []byte {
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+// decoder reads fixed-width and length-prefixed values, remembering the first error
+type decoder struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// next returns the next n bytes, or nil once the data runs out
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || d.pos+n > len(d.data) {
+		d.err = fmt.Errorf("doc values truncated at offset %d", d.pos)
+		return nil
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) uint8() uint8 {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) string16() string {
+	return string(d.next(int(d.uint16())))
+}
+
+func (d *decoder) string32() string {
+	return string(d.next(int(d.uint32())))
+}
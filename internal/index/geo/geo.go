@@ -0,0 +1,132 @@
+package geo
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// EarthRadiusMeters is the mean Earth radius used for distances
+const EarthRadiusMeters = 6371008.8
+
+// distanceUnits maps distance unit suffixes to meters, longest suffixes first
+// so "nmi" isn't read as "mi"
+var distanceUnits = []struct {
+	suffix string
+	meters float64
+}{
+	{"nmi", 1852},
+	{"km", 1000},
+	{"mi", 1609.344},
+	{"yd", 0.9144},
+	{"ft", 0.3048},
+	{"in", 0.0254},
+	{"cm", 0.01},
+	{"mm", 0.001},
+	{"NM", 1852},
+	{"m", 1},
+}
+
+// Distance returns the great-circle (haversine) distance between two points in meters
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	rlat1 := lat1 * math.Pi / 180
+	rlat2 := lat2 * math.Pi / 180
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rlat1)*math.Cos(rlat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// ParseDistance parses a distance such as "10km", "500m" or "2.5mi" into meters
+// A bare number is in meters
+func ParseDistance(input string) (float64, error) {
+	s := strings.TrimSpace(input)
+	factor := 1.0
+	for _, unit := range distanceUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, factor = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix)), unit.meters
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("invalid distance %q", input)
+	}
+	return n * factor, nil
+}
+
+// BoundingBox is a latitude/longitude rectangle
+// A box whose Left is greater than its Right crosses the antimeridian
+type BoundingBox struct {
+	Top    float64
+	Left   float64
+	Bottom float64
+	Right  float64
+}
+
+// Validate checks that the box's edges are valid coordinates
+func (b BoundingBox) Validate() error {
+	if b.Top < b.Bottom {
+		return fmt.Errorf("top %v is below bottom %v", b.Top, b.Bottom)
+	}
+	if b.Top > 90 || b.Bottom < -90 || b.Left < -180 || b.Left > 180 || b.Right < -180 || b.Right > 180 {
+		return fmt.Errorf("bounding box is outside the valid coordinate range")
+	}
+	return nil
+}
+
+// Contains reports whether the box contains a point
+func (b BoundingBox) Contains(lat, lon float64) bool {
+	if lat < b.Bottom || lat > b.Top {
+		return false
+	}
+	if b.Left <= b.Right {
+		return lon >= b.Left && lon <= b.Right
+	}
+	return lon >= b.Left || lon <= b.Right
+}
+
+// lonRanges returns the box's longitude span as one or two ranges
+func (b BoundingBox) lonRanges() [][2]float64 {
+	if b.Left <= b.Right {
+		return [][2]float64{{b.Left, b.Right}}
+	}
+	return [][2]float64{{b.Left, 180}, {-180, b.Right}}
+}
+
+// CircleBounds returns a box enclosing every point within meters of the center
+func CircleBounds(lat, lon, meters float64) BoundingBox {
+	delta := meters / EarthRadiusMeters * 180 / math.Pi
+	box := BoundingBox{
+		Top:    math.Min(90, lat+delta),
+		Bottom: math.Max(-90, lat-delta),
+		Left:   -180,
+		Right:  180,
+	}
+
+	// Near the poles, or for huge circles, every longitude is in range
+	if box.Top == 90 || box.Bottom == -90 {
+		return box
+	}
+	lonDelta := math.Asin(math.Min(1, math.Sin(delta*math.Pi/180)/math.Cos(lat*math.Pi/180))) * 180 / math.Pi
+	if lonDelta >= 180 || math.IsNaN(lonDelta) {
+		return box
+	}
+	box.Left = normalizeLon(lon - lonDelta)
+	box.Right = normalizeLon(lon + lonDelta)
+	return box
+}
+
+// normalizeLon wraps a longitude into [-180, 180]
+func normalizeLon(lon float64) float64 {
+	for lon < -180 {
+		lon += 360
+	}
+	for lon > 180 {
+		lon -= 360
+	}
+	return lon
+}
@@ -0,0 +1,197 @@
+package geo
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"nano-elastic/internal/types"
+)
+
+// CellDegrees is the size of a grid cell (about 28 km of latitude)
+const CellDegrees = 0.25
+
+// Point is a latitude/longitude pair
+type Point struct {
+	Lat float64
+	Lon float64
+}
+
+// cell identifies one grid cell by its row and column
+type cell struct {
+	row int
+	col int
+}
+
+// cellOf returns the grid cell holding a coordinate
+func cellOf(lat, lon float64) cell {
+	return cell{row: int(math.Floor(lat / CellDegrees)), col: int(math.Floor(lon / CellDegrees))}
+}
+
+// fieldIndex holds one geo-point field's points, bucketed by grid cell
+type fieldIndex struct {
+	cells  map[cell]map[string]struct{} // Cell -> IDs of the documents in it
+	points map[string]Point             // DocID -> point, for removal and exact checks
+}
+
+// remove removes a document's point
+func (fi *fieldIndex) remove(docID string) {
+	p, ok := fi.points[docID]
+	if !ok {
+		return
+	}
+	c := cellOf(p.Lat, p.Lon)
+	delete(fi.cells[c], docID)
+	if len(fi.cells[c]) == 0 {
+		delete(fi.cells, c)
+	}
+	delete(fi.points, docID)
+}
+
+// GeoIndex maps geo-point field values to documents so they can be filtered by
+// distance or bounding box. Each field buckets points into a fixed grid; a query
+// visits the cells overlapping its bounding box and checks each point exactly
+type GeoIndex struct {
+	fields map[string]*fieldIndex
+	mu     sync.RWMutex
+}
+
+// NewGeoIndex creates a new geo index
+func NewGeoIndex() *GeoIndex {
+	return &GeoIndex{
+		fields: make(map[string]*fieldIndex),
+	}
+}
+
+// IndexValue indexes a point for a document's field
+// Indexing a field again for the same document replaces the old point
+func (gi *GeoIndex) IndexValue(docID string, fieldName string, lat, lon float64) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	fi, ok := gi.fields[fieldName]
+	if !ok {
+		fi = &fieldIndex{cells: make(map[cell]map[string]struct{}), points: make(map[string]Point)}
+		gi.fields[fieldName] = fi
+	}
+	fi.remove(docID)
+
+	c := cellOf(lat, lon)
+	if fi.cells[c] == nil {
+		fi.cells[c] = make(map[string]struct{})
+	}
+	fi.cells[c][docID] = struct{}{}
+	fi.points[docID] = Point{Lat: lat, Lon: lon}
+}
+
+// IndexDocument indexes every GeoPointValue field of a document
+func (gi *GeoIndex) IndexDocument(doc *types.Document) {
+	for name, value := range doc.Fields {
+		if p, ok := value.(types.GeoPointValue); ok {
+			gi.IndexValue(doc.ID, name, p.Lat, p.Lon)
+		}
+	}
+}
+
+// RemoveDocument removes a document's points from every field
+func (gi *GeoIndex) RemoveDocument(docID string) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	for _, fi := range gi.fields {
+		fi.remove(docID)
+	}
+}
+
+// DocIDs returns the IDs of the documents with a point for a field, unordered
+func (gi *GeoIndex) DocIDs(fieldName string) []string {
+	gi.mu.RLock()
+	defer gi.mu.RUnlock()
+
+	fi, ok := gi.fields[fieldName]
+	if !ok {
+		return nil
+	}
+	docIDs := make([]string, 0, len(fi.points))
+	for id := range fi.points {
+		docIDs = append(docIDs, id)
+	}
+	return docIDs
+}
+
+// Point returns a document's indexed point for a field
+func (gi *GeoIndex) Point(fieldName string, docID string) (Point, bool) {
+	gi.mu.RLock()
+	defer gi.mu.RUnlock()
+
+	fi, ok := gi.fields[fieldName]
+	if !ok {
+		return Point{}, false
+	}
+	p, ok := fi.points[docID]
+	return p, ok
+}
+
+// SearchDistance returns the IDs of documents whose point lies within meters of
+// the center, sorted by ID
+func (gi *GeoIndex) SearchDistance(fieldName string, lat, lon, meters float64) []string {
+	return gi.search(fieldName, CircleBounds(lat, lon, meters), func(p Point) bool {
+		return Distance(lat, lon, p.Lat, p.Lon) <= meters
+	})
+}
+
+// SearchBoundingBox returns the IDs of documents whose point lies inside the box,
+// sorted by ID
+func (gi *GeoIndex) SearchBoundingBox(fieldName string, box BoundingBox) []string {
+	return gi.search(fieldName, box, func(p Point) bool {
+		return box.Contains(p.Lat, p.Lon)
+	})
+}
+
+// search collects the documents in the cells overlapping box that pass match
+func (gi *GeoIndex) search(fieldName string, box BoundingBox, match func(Point) bool) []string {
+	gi.mu.RLock()
+	defer gi.mu.RUnlock()
+
+	fi, ok := gi.fields[fieldName]
+	if !ok {
+		return nil
+	}
+
+	var ids []string
+	collect := func(docs map[string]struct{}) {
+		for id := range docs {
+			if match(fi.points[id]) {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	// Visit the box's cells, unless there are more of them than populated cells
+	minRow, maxRow := cellOf(box.Bottom, 0).row, cellOf(box.Top, 0).row
+	ranges := box.lonRanges()
+	visits := 0
+	for _, r := range ranges {
+		visits += (maxRow - minRow + 1) * (cellOf(0, r[1]).col - cellOf(0, r[0]).col + 1)
+	}
+
+	if visits > len(fi.cells) {
+		for c, docs := range fi.cells {
+			if c.row >= minRow && c.row <= maxRow {
+				collect(docs)
+			}
+		}
+	} else {
+		for _, r := range ranges {
+			minCol, maxCol := cellOf(0, r[0]).col, cellOf(0, r[1]).col
+			for row := minRow; row <= maxRow; row++ {
+				for col := minCol; col <= maxCol; col++ {
+					collect(fi.cells[cell{row: row, col: col}])
+				}
+			}
+		}
+	}
+
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,239 @@
+package hll
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+const (
+	// Precision is the number of hash bits that pick a register: 2^14 registers
+	// give a standard error of about 0.8%
+	Precision = 14
+
+	// DefaultThreshold is the number of distinct hashes a sketch keeps exactly
+	// before switching to registers, like Elasticsearch's precision_threshold
+	DefaultThreshold = 3000
+
+	// MaxThreshold caps the exact set, beyond which registers are as accurate
+	MaxThreshold = 40000
+
+	registers = 1 << Precision
+)
+
+// Sketch estimates the number of distinct values added to it, in the style of
+// HyperLogLog++: small sets are kept as exact sets of 64-bit hashes (the
+// sparse form), larger ones as 2^Precision registers holding the longest run
+// of leading zeros seen among the hashes routed to each
+// Sketches of disjoint or overlapping sets merge into the sketch of their union
+// A Sketch is not safe for concurrent modification
+type Sketch struct {
+	threshold int
+	sparse    map[uint64]struct{} // Exact hashes, until more than threshold are added
+	dense     []uint8             // Registers, once the sketch is dense
+}
+
+// New creates an empty sketch that counts exactly up to threshold distinct
+// values; threshold <= 0 uses DefaultThreshold and is capped at MaxThreshold
+func New(threshold int) *Sketch {
+	if threshold <= 0 {
+		threshold = DefaultThreshold
+	}
+	threshold = min(threshold, MaxThreshold)
+	return &Sketch{threshold: threshold, sparse: make(map[uint64]struct{})}
+}
+
+// Add records a value by its bytes
+func (s *Sketch) Add(value []byte) {
+	s.AddHash(Hash(value))
+}
+
+// AddHash records a value by its 64-bit hash (see Hash)
+func (s *Sketch) AddHash(h uint64) {
+	if s.dense != nil {
+		s.addDense(h)
+		return
+	}
+	s.sparse[h] = struct{}{}
+	if len(s.sparse) > s.threshold {
+		s.densify()
+	}
+}
+
+// addDense updates the register a hash routes to
+func (s *Sketch) addDense(h uint64) {
+	idx := h >> (64 - Precision)
+	rank := uint8(bits.LeadingZeros64(h<<Precision|1<<(Precision-1)) + 1)
+	if rank > s.dense[idx] {
+		s.dense[idx] = rank
+	}
+}
+
+// densify moves the exact hashes into registers
+func (s *Sketch) densify() {
+	s.dense = make([]uint8, registers)
+	for h := range s.sparse {
+		s.addDense(h)
+	}
+	s.sparse = nil
+}
+
+// Merge adds every value of another sketch
+func (s *Sketch) Merge(other *Sketch) {
+	if other.dense == nil {
+		for h := range other.sparse {
+			s.AddHash(h)
+		}
+		return
+	}
+	if s.dense == nil {
+		s.densify()
+	}
+	for i, rank := range other.dense {
+		if rank > s.dense[i] {
+			s.dense[i] = rank
+		}
+	}
+}
+
+// Clone returns an independent copy of the sketch
+func (s *Sketch) Clone() *Sketch {
+	c := &Sketch{threshold: s.threshold}
+	if s.dense != nil {
+		c.dense = append([]uint8(nil), s.dense...)
+		return c
+	}
+	c.sparse = make(map[uint64]struct{}, len(s.sparse))
+	for h := range s.sparse {
+		c.sparse[h] = struct{}{}
+	}
+	return c
+}
+
+// Covers reports whether merging the sketch into an empty one of threshold
+// (see New) gives the sketch its values would have built: always while it is
+// sparse, since it holds them exactly, and once dense for thresholds up to its own
+func (s *Sketch) Covers(threshold int) bool {
+	if threshold <= 0 {
+		threshold = DefaultThreshold
+	}
+	return s.dense == nil || min(threshold, MaxThreshold) <= s.threshold
+}
+
+// Encode serializes the sketch as [threshold:uint32][form:uint8] and then, for
+// the sparse form (0), [count:uint32] and the hashes in ascending order, or
+// for the dense form (1), the 2^Precision registers
+func (s *Sketch) Encode() []byte {
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(s.threshold))
+	if s.dense != nil {
+		return append(append(buf, 1), s.dense...)
+	}
+	hashes := make([]uint64, 0, len(s.sparse))
+	for h := range s.sparse {
+		hashes = append(hashes, h)
+	}
+	slices.Sort(hashes)
+	buf = append(buf, 0)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(hashes)))
+	for _, h := range hashes {
+		buf = binary.LittleEndian.AppendUint64(buf, h)
+	}
+	return buf
+}
+
+// Decode parses a sketch written by Encode
+func Decode(data []byte) (*Sketch, error) {
+	if len(data) < 5 {
+		return nil, fmt.Errorf("sketch truncated (%d bytes)", len(data))
+	}
+	threshold := int(binary.LittleEndian.Uint32(data[0:4]))
+	if threshold <= 0 || threshold > MaxThreshold {
+		return nil, fmt.Errorf("invalid sketch threshold %d", threshold)
+	}
+	s := &Sketch{threshold: threshold}
+	switch form, body := data[4], data[5:]; form {
+	case 0:
+		if len(body) < 4 || len(body) != 4+8*int(binary.LittleEndian.Uint32(body)) {
+			return nil, fmt.Errorf("sparse sketch of %d bytes is truncated", len(body))
+		}
+		count := int(binary.LittleEndian.Uint32(body))
+		if count > threshold {
+			return nil, fmt.Errorf("sparse sketch holds %d hashes, over its threshold of %d", count, threshold)
+		}
+		s.sparse = make(map[uint64]struct{}, count)
+		for i := 0; i < count; i++ {
+			s.sparse[binary.LittleEndian.Uint64(body[4+8*i:])] = struct{}{}
+		}
+	case 1:
+		if len(body) != registers {
+			return nil, fmt.Errorf("dense sketch has %d registers, expected %d", len(body), registers)
+		}
+		s.dense = append([]uint8(nil), body...)
+	default:
+		return nil, fmt.Errorf("unknown sketch form %d", form)
+	}
+	return s, nil
+}
+
+// Estimate returns the estimated number of distinct values; exact while the
+// sketch is sparse
+// Dense sketches use linear counting while many registers are still empty,
+// where the raw HyperLogLog estimate is biased, and the raw estimate beyond
+func (s *Sketch) Estimate() uint64 {
+	if s.dense == nil {
+		return uint64(len(s.sparse))
+	}
+
+	m := float64(registers)
+	sum := 0.0
+	zeros := 0
+	for _, rank := range s.dense {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	if estimate <= 5*m/2 && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
+
+// SizeInBytes returns the approximate memory held by the sketch
+func (s *Sketch) SizeInBytes() int {
+	if s.dense != nil {
+		return len(s.dense)
+	}
+	return len(s.sparse) * 16
+}
+
+// Hash returns the 64-bit hash sketches record a value by: FNV-1a, then a
+// finalizer that spreads similar inputs over every bit
+func Hash(value []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, b := range value {
+		h ^= uint64(b)
+		h *= 1099511628211
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// HashFloat returns the hash of a numeric value, by its IEEE 754 bits
+// Negative zero hashes like zero
+func HashFloat(v float64) uint64 {
+	if v == 0 {
+		v = 0
+	}
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	return Hash(buf[:])
+}
@@ -0,0 +1,156 @@
+package inverted
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+// MaxFuzzyEdits is the largest edit distance supported by fuzzy search
+// Larger distances match too much of the term dictionary to be useful
+const MaxFuzzyEdits = 2
+
+// FuzzyMatch is a dictionary term that matched a fuzzy query
+type FuzzyMatch struct {
+	Term     string // Matched term (without the field prefix)
+	Distance int    // Edit distance from the query term
+	DocFreq  int    // Number of documents containing the term
+}
+
+// AutoFuzziness picks an edit distance from the term length, like Elasticsearch's "AUTO":
+// 0 edits for 1-2 characters, 1 edit for 3-5, and 2 edits for longer terms
+func AutoFuzziness(term string) int {
+	n := utf8.RuneCountInString(term)
+	switch {
+	case n <= 2:
+		return 0
+	case n <= 5:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// FuzzyTerms returns the terms in a field within maxEdits of the query term,
+// closest first (ties broken by document frequency, then alphabetically)
+// Transpositions of adjacent characters count as a single edit
+func (idx *InvertedIndex) FuzzyTerms(fieldName string, term string, maxEdits int) []FuzzyMatch {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return idx.fuzzyTerms(fieldName, term, maxEdits)
+}
+
+// SearchFuzzy finds documents containing any term within maxEdits of the query term
+// so typos like "gastby" still find "gatsby"
+// Returns nil if no term matches
+func (idx *InvertedIndex) SearchFuzzy(fieldName string, term string, maxEdits int) *PostingList {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	matches := idx.fuzzyTerms(fieldName, term, maxEdits)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	lists := make([]*PostingList, 0, len(matches))
+	for _, m := range matches {
+		lists = append(lists, idx.termDict[fieldName+":"+m.Term])
+	}
+	return unionPostingLists(lists)
+}
+
+// fuzzyTerms scans the field's terms, skipping those whose length alone rules them out
+// Caller must hold idx.mu
+func (idx *InvertedIndex) fuzzyTerms(fieldName string, term string, maxEdits int) []FuzzyMatch {
+	if maxEdits < 0 {
+		maxEdits = 0
+	}
+	if maxEdits > MaxFuzzyEdits {
+		maxEdits = MaxFuzzyEdits
+	}
+
+	// Normalize the query term the same way indexed terms were
+	tokens := idx.searchAnalyzerFor(fieldName).Analyze(term)
+	if len(tokens) == 0 {
+		return nil
+	}
+	query := []rune(tokens[0])
+
+	prefix := fieldName + ":"
+	var matches []FuzzyMatch
+	for _, termKey := range idx.termKeyRange(prefix) {
+		postingList := idx.termDict[termKey]
+		candidate := termKey[len(prefix):]
+
+		// Length filter: the distance is at least the difference in length
+		lenDiff := utf8.RuneCountInString(candidate) - len(query)
+		if lenDiff > maxEdits || -lenDiff > maxEdits {
+			continue
+		}
+
+		if d := editDistance(query, []rune(candidate), maxEdits); d <= maxEdits {
+			matches = append(matches, FuzzyMatch{Term: candidate, Distance: d, DocFreq: postingList.DocFreq})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		if matches[i].DocFreq != matches[j].DocFreq {
+			return matches[i].DocFreq > matches[j].DocFreq
+		}
+		return matches[i].Term < matches[j].Term
+	})
+
+	return matches
+}
+
+// editDistance computes the optimal string alignment distance between a and b
+// (Levenshtein plus adjacent transpositions)
+// It gives up early and returns max+1 once every alignment exceeds max
+func editDistance(a, b []rune, max int) int {
+	// Three rolling rows: two rows back (for transpositions), previous, and current
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			d := prev[j] + 1 // deletion
+			if v := curr[j-1] + 1; v < d {
+				d = v // insertion
+			}
+			if v := prev[j-1] + cost; v < d {
+				d = v // substitution
+			}
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				if v := prev2[j-2] + 1; v < d {
+					d = v // transposition
+				}
+			}
+
+			curr[j] = d
+			if d < rowMin {
+				rowMin = d
+			}
+		}
+
+		if rowMin > max {
+			return max + 1
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+
+	return prev[len(b)]
+}
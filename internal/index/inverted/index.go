@@ -1,9 +1,12 @@
 package inverted
 
 import (
+	"fmt"
 	"sync"
 
 	"nano-elastic/internal/analyzer"
+	"nano-elastic/internal/index/docid"
+	"nano-elastic/internal/types"
 )
 
 // InvertedIndex is the main inverted index structure
@@ -13,6 +16,16 @@ type InvertedIndex struct {
 	// In Go, maps are reference types and are not thread-safe
 	termDict map[string]*PostingList
 	
+	// termKeys holds the keys of termDict, sorted up to sortedKeys, so the terms
+	// of a field, or those sharing a prefix, form a contiguous range
+	// New keys are appended and deleted ones left in place until the next range
+	// lookup sorts them in (see sortTermKeys), so adding n terms sorts once
+	// rather than shifting the slice n times
+	termKeys    []string
+	sortedKeys  int
+	keysDeleted bool       // Whether termKeys holds keys no longer in termDict
+	keysMu      sync.Mutex // Serializes sortTermKeys between readers
+	
 	// Mutex for thread-safe operations
 	// sync.RWMutex allows multiple readers or one writer
 	mu sync.RWMutex
@@ -20,6 +33,21 @@ type InvertedIndex struct {
 	// Analyzer for processing text
 	analyzer *analyzer.Analyzer
 	
+	// Per-field analyzers, overriding the default analyzer above
+	fieldAnalyzers map[string]*analyzer.Analyzer
+	
+	// Per-field analyzers for query text, when different from the index analyzer
+	searchAnalyzers map[string]*analyzer.Analyzer
+	
+	// fieldLengths maps field -> document ordinal -> number of tokens, for BM25 length normalization
+	fieldLengths map[string]map[uint32]int
+	
+	// fieldTotals maps field -> sum of its fieldLengths, so average lengths are O(1)
+	fieldTotals map[string]int
+	
+	// ordinals maps document IDs to the dense ordinals stored in posting lists
+	ordinals *docid.Ordinals
+	
 	// Statistics
 	totalTerms int // Total number of terms indexed
 	totalDocs  int // Total number of documents indexed
@@ -28,31 +56,150 @@ type InvertedIndex struct {
 // NewInvertedIndex creates a new inverted index
 func NewInvertedIndex() *InvertedIndex {
 	return &InvertedIndex{
-		termDict: make(map[string]*PostingList),
-		analyzer: analyzer.NewAnalyzer(),
+		termDict:       make(map[string]*PostingList),
+		analyzer:        analyzer.NewAnalyzer(),
+		fieldAnalyzers:  make(map[string]*analyzer.Analyzer),
+		searchAnalyzers: make(map[string]*analyzer.Analyzer),
+		fieldLengths:    make(map[string]map[uint32]int),
+		fieldTotals:     make(map[string]int),
+		ordinals:        docid.NewOrdinals(),
 	}
 }
 
 // NewInvertedIndexWithAnalyzer creates an index with a custom analyzer
-func NewInvertedIndexWithAnalyzer(analyzer *analyzer.Analyzer) *InvertedIndex {
+func NewInvertedIndexWithAnalyzer(a *analyzer.Analyzer) *InvertedIndex {
 	return &InvertedIndex{
-		termDict: make(map[string]*PostingList),
-		analyzer: analyzer,
+		termDict:       make(map[string]*PostingList),
+		analyzer:        a,
+		fieldAnalyzers:  make(map[string]*analyzer.Analyzer),
+		searchAnalyzers: make(map[string]*analyzer.Analyzer),
+		fieldLengths:    make(map[string]map[uint32]int),
+		fieldTotals:     make(map[string]int),
+		ordinals:        docid.NewOrdinals(),
+	}
+}
+
+// NewInvertedIndexForSchema creates an index that analyzes each text field
+// with the analyzer named in its schema definition
+func NewInvertedIndexForSchema(schema *types.Schema) (*InvertedIndex, error) {
+	idx := NewInvertedIndex()
+	for name, def := range schema.Fields {
+		if def.Type != types.FieldTypeText {
+			continue
+		}
+		a, err := analyzer.Lookup(schema.FieldAnalyzerName(def))
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", name, err)
+		}
+		idx.fieldAnalyzers[name] = a
+		
+		if schema.FieldSearchAnalyzerName(def) != schema.FieldAnalyzerName(def) {
+			sa, err := analyzer.Lookup(schema.FieldSearchAnalyzerName(def))
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", name, err)
+			}
+			idx.searchAnalyzers[name] = sa
+		}
+	}
+	return idx, nil
+}
+
+// SetFieldAnalyzer sets the analyzer used for a field at index and query time
+func (idx *InvertedIndex) SetFieldAnalyzer(fieldName string, a *analyzer.Analyzer) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.fieldAnalyzers[fieldName] = a
+}
+
+// SetFieldSearchAnalyzer sets a separate analyzer for query text on a field
+func (idx *InvertedIndex) SetFieldSearchAnalyzer(fieldName string, a *analyzer.Analyzer) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.searchAnalyzers[fieldName] = a
+}
+
+// analyzerFor returns the analyzer configured for a field, or the default one
+func (idx *InvertedIndex) analyzerFor(fieldName string) *analyzer.Analyzer {
+	if a, ok := idx.fieldAnalyzers[fieldName]; ok {
+		return a
+	}
+	return idx.analyzer
+}
+
+// searchAnalyzerFor returns the analyzer for query text on a field
+func (idx *InvertedIndex) searchAnalyzerFor(fieldName string) *analyzer.Analyzer {
+	if a, ok := idx.searchAnalyzers[fieldName]; ok {
+		return a
+	}
+	return idx.analyzerFor(fieldName)
+}
+
+// lookupTerm analyzes a query term with the field's search analyzer and returns its postings
+// Tokens sharing the first token's position (e.g. synonyms) are unioned together
+// analyzed is false when the term produced no tokens (e.g. a stop word)
+// Caller must hold idx.mu
+func (idx *InvertedIndex) lookupTerm(fieldName string, term string) (pl *PostingList, analyzed bool) {
+	tokens := idx.searchAnalyzerFor(fieldName).AnalyzeTokens(term)
+	if len(tokens) == 0 {
+		return nil, false
+	}
+	
+	var lists []*PostingList
+	for _, token := range tokens {
+		if token.Position != tokens[0].Position {
+			break
+		}
+		if postingList, exists := idx.termDict[fieldName+":"+token.Term]; exists {
+			lists = append(lists, postingList)
+		}
+	}
+	
+	switch len(lists) {
+	case 0:
+		return nil, true
+	case 1:
+		return lists[0], true
+	default:
+		return unionPostingLists(lists), true
 	}
 }
 
+// PositionIncrementGap separates the positions of a field's values (see
+// IndexValues), so phrases don't match across them
+const PositionIncrementGap = 100
+
 // IndexDocument indexes a document's text field
 // docID: unique document identifier
 // fieldName: name of the text field
 // text: the text content to index
 func (idx *InvertedIndex) IndexDocument(docID string, fieldName string, text string) {
+	idx.IndexValues(docID, fieldName, []string{text})
+}
+
+// IndexValues indexes several values of a document's text field, e.g. values
+// copied into it from other fields, as one field whose values are
+// PositionIncrementGap positions apart
+func (idx *InvertedIndex) IndexValues(docID string, fieldName string, texts []string) {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 	
-	// Analyze the text to get tokens with positions
-	tokens, positions := idx.analyzer.AnalyzeWithPositions(text)
+	// Analyze the texts to get tokens with positions
+	var tokens []string
+	var positions []int
+	start, next := 0, 0
+	for _, text := range texts {
+		valueTokens, valuePositions := idx.analyzerFor(fieldName).AnalyzeWithPositions(text)
+		tokens = append(tokens, valueTokens...)
+		for _, pos := range valuePositions {
+			positions = append(positions, start+pos)
+			next = max(next, start+pos+1+PositionIncrementGap)
+		}
+		start = next
+	}
+	doc := idx.ordinals.Assign(docID)
 	
 	// Index each token
+	lists := make([]*PostingList, 0, len(tokens))
 	for i, token := range tokens {
 		// Create a unique term key: "fieldName:token"
 		// This allows same word in different fields to be separate
@@ -63,33 +210,156 @@ func (idx *InvertedIndex) IndexDocument(docID string, fieldName string, text str
 		if !exists {
 			postingList = NewPostingList()
 			idx.termDict[termKey] = postingList
+			idx.addTermKey(termKey)
 		}
 		
 		// Add posting with position
-		postingList.AddPosting(docID, positions[i])
+		postingList.AddPosting(doc, positions[i])
+		lists = append(lists, postingList)
 		idx.totalTerms++
 	}
 	
+	// Record the field length for scoring
+	lengths, ok := idx.fieldLengths[fieldName]
+	if !ok {
+		lengths = make(map[uint32]int)
+		idx.fieldLengths[fieldName] = lengths
+	}
+	lengths[doc] += len(tokens)
+	idx.fieldTotals[fieldName] += len(tokens)
+	for _, postingList := range lists {
+		postingList.noteFieldLength(lengths[doc])
+	}
+	
 	idx.totalDocs++
 }
 
+// RemoveDocument removes every posting for a document, across all fields
+// Used before re-indexing an updated document and when a document is deleted
+func (idx *InvertedIndex) RemoveDocument(docID string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	
+	doc, ok := idx.ordinals.Lookup(docID)
+	if !ok {
+		return
+	}
+	
+	for termKey, postingList := range idx.termDict {
+		if removed := postingList.RemovePosting(doc); removed > 0 {
+			idx.totalTerms -= removed
+			if postingList.DocFreq == 0 {
+				delete(idx.termDict, termKey)
+				idx.deleteTermKey(termKey)
+			}
+		}
+	}
+	
+	for fieldName, lengths := range idx.fieldLengths {
+		if n, ok := lengths[doc]; ok {
+			idx.fieldTotals[fieldName] -= n
+			delete(lengths, doc)
+		}
+	}
+}
+
+// Ordinals returns the dictionary of document ordinals used in posting lists
+// Indexes can share it so that posting lists and document bitmaps agree
+func (idx *InvertedIndex) Ordinals() *docid.Ordinals {
+	return idx.ordinals
+}
+
+// DocID returns the document ID of an ordinal from a posting list
+func (idx *InvertedIndex) DocID(doc uint32) string {
+	return idx.ordinals.ID(doc)
+}
+
+// DocIDs returns the document IDs of a posting list, in posting order
+func (idx *InvertedIndex) DocIDs(pl *PostingList) []string {
+	docIDs := make([]string, len(pl.Postings))
+	for i, posting := range pl.Postings {
+		docIDs[i] = idx.ordinals.ID(posting.Doc)
+	}
+	return docIDs
+}
+
+// TermPostings returns the posting list for an exact term in a field, without analysis
+// Returns nil if the term is not in the dictionary
+func (idx *InvertedIndex) TermPostings(fieldName string, term string) *PostingList {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	
+	return idx.termDict[fieldName+":"+term]
+}
+
+// AnalyzeQuery runs query text through the field's search analyzer
+func (idx *InvertedIndex) AnalyzeQuery(fieldName string, text string) []analyzer.Token {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	
+	return idx.searchAnalyzerFor(fieldName).AnalyzeTokens(text)
+}
+
+// AnalyzeText runs document text through the field's index analyzer
+// The tokens keep their offsets into text, e.g. for highlighting
+func (idx *InvertedIndex) AnalyzeText(fieldName string, text string) []analyzer.Token {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	
+	return idx.analyzerFor(fieldName).AnalyzeTokens(text)
+}
+
+// FieldLength returns the number of tokens indexed for a document's field
+func (idx *InvertedIndex) FieldLength(fieldName string, docID string) int {
+	doc, ok := idx.ordinals.Lookup(docID)
+	if !ok {
+		return 0
+	}
+	return idx.DocFieldLength(fieldName, doc)
+}
+
+// DocFieldLength is FieldLength by document ordinal, e.g. from a posting
+func (idx *InvertedIndex) DocFieldLength(fieldName string, doc uint32) int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	
+	return idx.fieldLengths[fieldName][doc]
+}
+
+// FieldDocs returns the ordinals of the documents with indexed tokens in a field
+func (idx *InvertedIndex) FieldDocs(fieldName string) []uint32 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	
+	docs := make([]uint32, 0, len(idx.fieldLengths[fieldName]))
+	for doc := range idx.fieldLengths[fieldName] {
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+// FieldStats returns the number of documents with the field and their average length
+func (idx *InvertedIndex) FieldStats(fieldName string) (docCount int, avgLength float64) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	
+	docCount = len(idx.fieldLengths[fieldName])
+	if docCount == 0 {
+		return 0, 0
+	}
+	return docCount, float64(idx.fieldTotals[fieldName]) / float64(docCount)
+}
+
 // Search finds documents containing a term
 // Returns a posting list for the term, or nil if not found
 func (idx *InvertedIndex) Search(term string) *PostingList {
 	idx.mu.RLock() // Read lock (allows multiple concurrent readers)
 	defer idx.mu.RUnlock()
 	
-	// Analyze the search term (normalize it)
-	tokens := idx.analyzer.Analyze(term)
-	if len(tokens) == 0 {
-		return nil
-	}
-	
 	// For now, search in all fields (we can improve this later)
-	// Try to find the term in any field
+	// Try to find the term in any field, analyzing it the way that field expects
 	for fieldName := range idx.getAllFieldNames() {
-		termKey := fieldName + ":" + tokens[0]
-		if postingList, exists := idx.termDict[termKey]; exists {
+		if postingList, _ := idx.lookupTerm(fieldName, term); postingList != nil {
 			return postingList
 		}
 	}
@@ -102,18 +372,8 @@ func (idx *InvertedIndex) SearchInField(fieldName string, term string) *PostingL
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
 	
-	// Analyze the search term
-	tokens := idx.analyzer.Analyze(term)
-	if len(tokens) == 0 {
-		return nil
-	}
-	
-	termKey := fieldName + ":" + tokens[0]
-	postingList, exists := idx.termDict[termKey]
-	if !exists {
-		return nil
-	}
-	
+	// Analyze the search term with the field's search analyzer
+	postingList, _ := idx.lookupTerm(fieldName, term)
 	return postingList
 }
 
@@ -129,24 +389,24 @@ func (idx *InvertedIndex) SearchMultipleTerms(terms []string) *PostingList {
 	
 	// Analyze all terms
 	var postingLists []*PostingList
+	fieldNames := idx.getAllFieldNames()
 	for _, term := range terms {
-		tokens := idx.analyzer.Analyze(term)
-		if len(tokens) == 0 {
-			continue
-		}
-		
 		// Try to find in any field
 		found := false
-		for fieldName := range idx.getAllFieldNames() {
-			termKey := fieldName + ":" + tokens[0]
-			if pl, exists := idx.termDict[termKey]; exists {
+		analyzedAny := false
+		for fieldName := range fieldNames {
+			pl, analyzed := idx.lookupTerm(fieldName, term)
+			if analyzed {
+				analyzedAny = true
+			}
+			if pl != nil {
 				postingLists = append(postingLists, pl)
 				found = true
 				break
 			}
 		}
 		
-		if !found {
+		if !found && analyzedAny {
 			// Term not found, AND query returns empty
 			return NewPostingList()
 		}
@@ -171,16 +431,25 @@ func (idx *InvertedIndex) intersectPostingLists(lists []*PostingList) *PostingLi
 		return lists[0]
 	}
 	
-	// Start with first list
+	// Start with first list; the result's postings are a subset of its own
 	result := NewPostingList()
+	result.MaxTermFreq = lists[0].MaxTermFreq
+	result.MinFieldLength = lists[0].MinFieldLength
+	
+	// Postings are sorted by ordinal, so each list is walked once with a cursor
+	cursors := make([]int, len(lists))
 	
 	// For each document in first list, check if it's in all other lists
 	for _, posting := range lists[0].Postings {
 		inAll := true
 		
-		// Check if this docID exists in all other lists
+		// Advance each other list to this ordinal
 		for i := 1; i < len(lists); i++ {
-			if _, exists := lists[i].GetPosting(posting.DocID); !exists {
+			postings := lists[i].Postings
+			for cursors[i] < len(postings) && postings[cursors[i]].Doc < posting.Doc {
+				cursors[i]++
+			}
+			if cursors[i] == len(postings) || postings[cursors[i]].Doc != posting.Doc {
 				inAll = false
 				break
 			}
@@ -238,6 +507,9 @@ func (idx *InvertedIndex) Clear() {
 	defer idx.mu.Unlock()
 	
 	idx.termDict = make(map[string]*PostingList)
+	idx.termKeys, idx.sortedKeys, idx.keysDeleted = nil, 0, false
+	idx.fieldLengths = make(map[string]map[uint32]int)
+	idx.fieldTotals = make(map[string]int)
 	idx.totalTerms = 0
 	idx.totalDocs = 0
 }
@@ -1,18 +1,27 @@
 package inverted
 
+import "sort"
+
 // Posting represents a single entry in a posting list
 // A posting list contains all documents that contain a specific term
 type Posting struct {
-	DocID     string  // Document ID
+	Doc       uint32  // Document ordinal (see InvertedIndex.DocID)
 	TermFreq  int     // Term frequency (how many times term appears in document)
 	Positions []int   // Positions where term appears (for phrase queries)
 }
 
 // PostingList represents a list of postings for a term
 // This is the core data structure of an inverted index
+// Postings are kept sorted by document ordinal, so lookups are binary searches
+// and lists intersect by merging
 type PostingList struct {
 	Postings []Posting // All documents containing this term
 	DocFreq  int       // Document frequency (how many documents contain this term)
+	
+	// Impact bounds, for skipping documents that can't score high enough
+	// (see search's top-k collection); they are not tightened on removal
+	MaxTermFreq    int // At least the highest term frequency of any posting
+	MinFieldLength int // At most the shortest field length of any posting's document, 0 if unknown
 }
 
 // NewPostingList creates a new empty posting list
@@ -23,46 +32,109 @@ func NewPostingList() *PostingList {
 	}
 }
 
+// search returns the index of the first posting with an ordinal >= doc
+func (pl *PostingList) search(doc uint32) int {
+	return sort.Search(len(pl.Postings), func(i int) bool {
+		return pl.Postings[i].Doc >= doc
+	})
+}
+
 // AddPosting adds a posting to the list
 // If document already exists, it updates the term frequency
-func (pl *PostingList) AddPosting(docID string, position int) {
-	// Check if document already exists in posting list
-	for i := range pl.Postings {
-		if pl.Postings[i].DocID == docID {
-			// Document already exists, update it
-			pl.Postings[i].TermFreq++
-			pl.Postings[i].Positions = append(pl.Postings[i].Positions, position)
-			return
-		}
+func (pl *PostingList) AddPosting(doc uint32, position int) {
+	// A document's tokens are indexed together, so it is usually the last posting
+	i := len(pl.Postings)
+	if i == 0 || pl.Postings[i-1].Doc != doc {
+		i = pl.search(doc)
+	} else {
+		i--
+	}
+	
+	if i < len(pl.Postings) && pl.Postings[i].Doc == doc {
+		// Document already exists, update it
+		pl.Postings[i].TermFreq++
+		pl.Postings[i].Positions = append(pl.Postings[i].Positions, position)
+		pl.MaxTermFreq = max(pl.MaxTermFreq, pl.Postings[i].TermFreq)
+		return
 	}
 	
-	// New document, add it
-	pl.Postings = append(pl.Postings, Posting{
-		DocID:     docID,
+	// New document, insert it in ordinal order
+	pl.Postings = append(pl.Postings, Posting{})
+	copy(pl.Postings[i+1:], pl.Postings[i:])
+	pl.Postings[i] = Posting{
+		Doc:       doc,
 		TermFreq:  1,
 		Positions: []int{position},
-	})
+	}
 	pl.DocFreq++
+	pl.MaxTermFreq = max(pl.MaxTermFreq, 1)
 }
 
-// GetPosting finds a posting for a specific document ID
-// Returns the posting and true if found, nil and false otherwise
-func (pl *PostingList) GetPosting(docID string) (*Posting, bool) {
-	for i := range pl.Postings {
-		if pl.Postings[i].DocID == docID {
-			return &pl.Postings[i], true
+// noteFieldLength lowers MinFieldLength to the field length of a posting's document
+func (pl *PostingList) noteFieldLength(length int) {
+	if pl.MinFieldLength == 0 || length < pl.MinFieldLength {
+		pl.MinFieldLength = length
+	}
+}
+
+// mergeBounds sets the impact bounds of a list combining others' postings:
+// frequencies of the same document may add up
+func (pl *PostingList) mergeBounds(lists []*PostingList) {
+	for i, list := range lists {
+		pl.MaxTermFreq += list.MaxTermFreq
+		if i == 0 || list.MinFieldLength == 0 || list.MinFieldLength < pl.MinFieldLength {
+			pl.MinFieldLength = list.MinFieldLength
 		}
 	}
+}
+
+// GetPosting finds a posting for a specific document ordinal
+// Returns the posting and true if found, nil and false otherwise
+func (pl *PostingList) GetPosting(doc uint32) (*Posting, bool) {
+	if i := pl.search(doc); i < len(pl.Postings) && pl.Postings[i].Doc == doc {
+		return &pl.Postings[i], true
+	}
 	return nil, false
 }
 
-// GetDocIDs returns all document IDs in this posting list
-func (pl *PostingList) GetDocIDs() []string {
-	docIDs := make([]string, len(pl.Postings))
+// RemovePosting removes a document from the list
+// Returns the removed posting's term frequency, or 0 if the document wasn't present
+func (pl *PostingList) RemovePosting(doc uint32) int {
+	i := pl.search(doc)
+	if i == len(pl.Postings) || pl.Postings[i].Doc != doc {
+		return 0
+	}
+	freq := pl.Postings[i].TermFreq
+	pl.Postings = append(pl.Postings[:i], pl.Postings[i+1:]...)
+	pl.DocFreq--
+	return freq
+}
+
+// Between returns a view of the list holding only its postings with ordinals
+// from lo to hi inclusive, none if hi < lo; DocFreq and the impact bounds are
+// the whole list's, so scores don't change. The view shares the list's postings
+func (pl *PostingList) Between(lo, hi uint32) *PostingList {
+	view := *pl
+	start := pl.search(lo)
+	end := start
+	if hi >= lo {
+		end = pl.search(hi)
+		if end < len(pl.Postings) && pl.Postings[end].Doc == hi {
+			end++
+		}
+	}
+	view.Postings = pl.Postings[start:end:end]
+	return &view
+}
+
+// Docs returns the ordinals of all documents in this posting list, in order
+// InvertedIndex.DocIDs translates them to document IDs
+func (pl *PostingList) Docs() []uint32 {
+	docs := make([]uint32, len(pl.Postings))
 	for i, posting := range pl.Postings {
-		docIDs[i] = posting.DocID
+		docs[i] = posting.Doc
 	}
-	return docIDs
+	return docs
 }
 
 // Size returns the number of postings in the list
@@ -70,3 +142,25 @@ func (pl *PostingList) Size() int {
 	return len(pl.Postings)
 }
 
+
+// unionPostingLists merges posting lists into one containing every document
+// that appears in any list (OR semantics)
+// Postings for the same document are combined: frequencies add up and positions are merged
+func unionPostingLists(lists []*PostingList) *PostingList {
+	result := NewPostingList()
+	union := NewDisjunction(lists)
+	for doc, ok := union.Next(); ok; doc, ok = union.Next() {
+		merged := Posting{Doc: doc}
+		for _, m := range union.Matches() {
+			merged.TermFreq += m.Posting.TermFreq
+			merged.Positions = append(merged.Positions, m.Posting.Positions...)
+		}
+		if len(union.Matches()) > 1 {
+			sort.Ints(merged.Positions)
+		}
+		result.Postings = append(result.Postings, merged)
+		result.DocFreq++
+	}
+	result.mergeBounds(lists)
+	return result
+}
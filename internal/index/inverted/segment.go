@@ -1,149 +1,296 @@
 package inverted
 
 import (
+	"bufio"
 	"encoding/binary"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
-	"sync"
-)
+	"sort"
 
-// IndexSegment represents a persisted segment of the inverted index
-// Segments allow us to write indexes to disk and read them back
-type IndexSegment struct {
-	ID       string
-	Path     string
-	termDict map[string]*PostingList
-	mu       sync.RWMutex
-	file     *os.File
-}
+	"nano-elastic/internal/index/docid"
+)
 
 // SegmentHeader for inverted index segment
 type SegmentHeader struct {
 	Magic    [4]byte // "NINV"
 	Version  uint16
 	TermCount uint32
-	Reserved [8]byte
+	DocCount uint32  // Entries in the document ID dictionary (version 2+)
+	Reserved [4]byte
 }
 
+// Segment format versions
+// Version 1 stores each posting's document ID as a string
+// Version 2 stores the segment's document IDs once, in a dictionary after the
+// header, and postings refer to them by their dense segment-local ordinal
+// Version 3 writes every length, count and number after the header as an
+// unsigned varint; ordinals are stored as gaps from the previous posting and
+// positions as gaps from the previous position, so most take a single byte
+// Version 4 writes the terms in sorted order, each as the length of the prefix
+// it shares with the previous term plus the rest, and prefixes each posting
+// list with its encoded size, so terms can be streamed without decoding postings
+// Version 5 stores each posting list's impact bounds (maximum term frequency
+// and minimum field length) after its document frequency
+// Version 6 stores each field's document lengths after the document
+// dictionary, so a loaded segment scores like a freshly indexed one
 const (
 	IndexSegmentMagic   = "NINV"
-	IndexSegmentVersion = 1
+	IndexSegmentVersion = 6
 )
 
-// NewIndexSegment creates a new index segment
-func NewIndexSegment(id string, basePath string) (*IndexSegment, error) {
-	segmentPath := filepath.Join(basePath, fmt.Sprintf("index_segment_%s.dat", id))
-	
-	return &IndexSegment{
-		ID:       id,
-		Path:     segmentPath,
-		termDict: make(map[string]*PostingList),
-	}, nil
-}
-
-// Write writes the index segment to disk
-func (seg *IndexSegment) Write(index *InvertedIndex) error {
-	seg.mu.Lock()
-	defer seg.mu.Unlock()
+// WriteSegment writes an index to out in the current segment format
+// Storage writes one for each data segment it seals, and LoadSegment streams
+// them back into the index at open, a term at a time
+func WriteSegment(out io.Writer, index *InvertedIndex) error {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
 	
-	var err error
-	seg.file, err = os.OpenFile(seg.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open segment file: %w", err)
+	// Number the segment's documents densely, keeping the index's ordinal order
+	// so posting lists stay sorted
+	var docs []uint32
+	local := make(map[uint32]uint32)
+	addDoc := func(doc uint32) {
+		if _, ok := local[doc]; !ok {
+			local[doc] = 0
+			docs = append(docs, doc)
+		}
+	}
+	for _, postingList := range index.termDict {
+		for _, posting := range postingList.Postings {
+			addDoc(posting.Doc)
+		}
+	}
+	for _, lengths := range index.fieldLengths {
+		for doc := range lengths {
+			addDoc(doc)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i] < docs[j] })
+	for i, doc := range docs {
+		local[doc] = uint32(i)
 	}
-	defer seg.file.Close()
+	
+	w := bufio.NewWriter(out)
 	
 	// Write header
 	header := SegmentHeader{
 		Version:   IndexSegmentVersion,
 		TermCount: uint32(len(index.termDict)),
+		DocCount:  uint32(len(docs)),
 	}
 	copy(header.Magic[:], IndexSegmentMagic)
 	
-	if err := binary.Write(seg.file, binary.LittleEndian, header); err != nil {
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
 		return fmt.Errorf("failed to write header: %w", err)
 	}
 	
-	// Write term dictionary
-	index.mu.RLock()
-	defer index.mu.RUnlock()
-	
-	for term, postingList := range index.termDict {
-		// Write term length and term
-		termBytes := []byte(term)
-		termLen := uint16(len(termBytes))
-		if err := binary.Write(seg.file, binary.LittleEndian, termLen); err != nil {
+	// Write document ID dictionary
+	var buf []byte
+	for _, doc := range docs {
+		buf = appendString(buf[:0], index.ordinals.ID(doc))
+		if _, err := w.Write(buf); err != nil {
 			return err
 		}
-		if _, err := seg.file.Write(termBytes); err != nil {
-			return err
+	}
+	
+	// Write field lengths, each field's documents in ordinal order
+	if _, err := w.Write(appendFieldLengths(buf[:0], index.fieldLengths, local)); err != nil {
+		return err
+	}
+	
+	// Write term dictionary, sorted and prefix-compressed
+	index.sortTermKeys()
+	var postings []byte
+	prev := ""
+	for _, term := range index.termKeys {
+		shared := 0
+		for shared < len(prev) && shared < len(term) && prev[shared] == term[shared] {
+			shared++
 		}
-		
-		// Write posting list
-		if err := seg.writePostingList(postingList); err != nil {
+		buf = binary.AppendUvarint(buf[:0], uint64(shared))
+		buf = appendString(buf, term[shared:])
+		postings = appendPostingList(postings[:0], index.termDict[term], local)
+		buf = binary.AppendUvarint(buf, uint64(len(postings)))
+		buf = append(buf, postings...)
+		if _, err := w.Write(buf); err != nil {
 			return err
 		}
+		prev = term
 	}
 	
-	return seg.file.Sync()
+	return w.Flush()
 }
 
-// writePostingList writes a posting list to the file
-func (seg *IndexSegment) writePostingList(pl *PostingList) error {
-	// Write document frequency
-	if err := binary.Write(seg.file, binary.LittleEndian, uint32(pl.DocFreq)); err != nil {
-		return err
+// appendString appends a string prefixed with its varint length
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// appendFieldLengths appends the field lengths in the version 6 encoding:
+// the field count, then per field in name order its name, its document count
+// and each document's segment ordinal, as a gap, and length
+func appendFieldLengths(buf []byte, fieldLengths map[string]map[uint32]int, local map[uint32]uint32) []byte {
+	fields := make([]string, 0, len(fieldLengths))
+	for field := range fieldLengths {
+		fields = append(fields, field)
 	}
+	sort.Strings(fields)
 	
-	// Write each posting
-	for _, posting := range pl.Postings {
-		// Write docID
-		docIDBytes := []byte(posting.DocID)
-		docIDLen := uint16(len(docIDBytes))
-		if err := binary.Write(seg.file, binary.LittleEndian, docIDLen); err != nil {
-			return err
-		}
-		if _, err := seg.file.Write(docIDBytes); err != nil {
-			return err
+	buf = binary.AppendUvarint(buf, uint64(len(fields)))
+	type docLength struct {
+		doc    uint32
+		length int
+	}
+	for _, field := range fields {
+		docs := make([]docLength, 0, len(fieldLengths[field]))
+		for doc, length := range fieldLengths[field] {
+			docs = append(docs, docLength{local[doc], length})
 		}
-		
-		// Write term frequency
-		if err := binary.Write(seg.file, binary.LittleEndian, uint32(posting.TermFreq)); err != nil {
-			return err
+		sort.Slice(docs, func(i, j int) bool { return docs[i].doc < docs[j].doc })
+	
+		buf = appendString(buf, field)
+		buf = binary.AppendUvarint(buf, uint64(len(docs)))
+		prev := uint32(0)
+		for _, d := range docs {
+			buf = binary.AppendUvarint(buf, uint64(d.doc-prev))
+			buf = binary.AppendUvarint(buf, uint64(d.length))
+			prev = d.doc
 		}
-		
-		// Write positions count and positions
-		posCount := uint32(len(posting.Positions))
-		if err := binary.Write(seg.file, binary.LittleEndian, posCount); err != nil {
-			return err
+	}
+	return buf
+}
+
+// appendPostingList appends a posting list in the version 5 encoding
+// local maps index ordinals to the segment's ordinals
+func appendPostingList(buf []byte, pl *PostingList, local map[uint32]uint32) []byte {
+	// Write document frequency and impact bounds
+	buf = binary.AppendUvarint(buf, uint64(len(pl.Postings)))
+	buf = binary.AppendUvarint(buf, uint64(pl.MaxTermFreq))
+	buf = binary.AppendUvarint(buf, uint64(pl.MinFieldLength))
+	
+	var prevDoc uint32
+	positions := make([]int, 0, 8)
+	for i, posting := range pl.Postings {
+		// Ordinal gap from the previous posting (the first is absolute)
+		doc := local[posting.Doc]
+		if i == 0 {
+			buf = binary.AppendUvarint(buf, uint64(doc))
+		} else {
+			buf = binary.AppendUvarint(buf, uint64(doc-prevDoc))
 		}
-		if posCount > 0 {
-			if err := binary.Write(seg.file, binary.LittleEndian, posting.Positions); err != nil {
-				return err
-			}
+		prevDoc = doc
+	
+		// Term frequency, then positions as gaps in ascending order
+		buf = binary.AppendUvarint(buf, uint64(posting.TermFreq))
+		positions = append(positions[:0], posting.Positions...)
+		sort.Ints(positions)
+		buf = binary.AppendUvarint(buf, uint64(len(positions)))
+		prevPos := 0
+		for _, pos := range positions {
+			buf = binary.AppendUvarint(buf, uint64(pos-prevPos))
+			prevPos = pos
 		}
 	}
 	
-	return nil
+	return buf
 }
 
-// Read reads an index segment from disk
-func (seg *IndexSegment) Read() (*InvertedIndex, error) {
-	seg.mu.Lock()
-	defer seg.mu.Unlock()
+// LoadSegment adds the documents of a segment, written by WriteSegment in any
+// format version, that keep accepts (every one if keep is nil)
+// Their postings and field lengths are taken as stored, without analyzing any
+// text; the documents must not be in the index already
+func (idx *InvertedIndex) LoadSegment(r io.Reader, keep func(docID string) bool) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
 	
-	var err error
-	seg.file, err = os.Open(seg.Path)
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	dec, err := newSegmentDecoder(br, idx.ordinals, keep)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open segment file: %w", err)
+		return err
 	}
-	defer seg.file.Close()
 	
+	// Add field lengths
+	for field, lengths := range dec.fieldLengths {
+		fieldLengths, ok := idx.fieldLengths[field]
+		if !ok {
+			fieldLengths = make(map[uint32]int, len(lengths))
+			idx.fieldLengths[field] = fieldLengths
+		}
+		for doc, length := range lengths {
+			fieldLengths[doc] = length
+			idx.fieldTotals[field] += length
+			idx.totalDocs++
+		}
+	}
+	
+	// Add postings; documents already numbered by the index may come before
+	// those of existing postings, and version 1 postings are in insertion order
+	unsorted := make(map[*PostingList]bool)
+	for dec.termCount > 0 {
+		term, err := dec.readTerm()
+		if err != nil {
+			return fmt.Errorf("failed to read term: %w", err)
+		}
+		pl, err := dec.readPostingList()
+		if err != nil {
+			return fmt.Errorf("failed to read postings of %s: %w", term, err)
+		}
+		kept := pl.Postings[:0]
+		for _, posting := range pl.Postings {
+			if posting.Doc != skippedDoc {
+				kept = append(kept, posting)
+			}
+		}
+		if len(kept) == 0 {
+			continue
+		}
+	
+		postingList, exists := idx.termDict[term]
+		if !exists {
+			postingList = NewPostingList()
+			idx.termDict[term] = postingList
+			idx.addTermKey(term)
+		}
+		for _, posting := range kept {
+			if n := len(postingList.Postings); n > 0 && postingList.Postings[n-1].Doc >= posting.Doc {
+				unsorted[postingList] = true
+			}
+			postingList.Postings = append(postingList.Postings, posting)
+			idx.totalTerms += posting.TermFreq
+		}
+		postingList.DocFreq += len(kept)
+	
+		// The stored bounds may be looser than the kept postings need, never tighter
+		postingList.MaxTermFreq = max(postingList.MaxTermFreq, pl.MaxTermFreq)
+		switch {
+		case !exists:
+			postingList.MinFieldLength = pl.MinFieldLength
+		case pl.MinFieldLength == 0:
+			postingList.MinFieldLength = 0 // Unknown
+		case postingList.MinFieldLength > 0:
+			postingList.MinFieldLength = min(postingList.MinFieldLength, pl.MinFieldLength)
+		}
+	}
+	
+	for postingList := range unsorted {
+		sort.Slice(postingList.Postings, func(i, j int) bool {
+			return postingList.Postings[i].Doc < postingList.Postings[j].Doc
+		})
+	}
+	return nil
+}
+
+// newSegmentDecoder reads a segment's header, document dictionary and field
+// lengths, numbering the documents keep accepts with ordinals
+// The returned decoder is positioned at the first term
+func newSegmentDecoder(r byteReader, ordinals *docid.Ordinals, keep func(docID string) bool) (*segmentDecoder, error) {
 	// Read header
 	var header SegmentHeader
-	if err := binary.Read(seg.file, binary.LittleEndian, &header); err != nil {
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
 		return nil, fmt.Errorf("failed to read header: %w", err)
 	}
 	
@@ -151,88 +298,258 @@ func (seg *IndexSegment) Read() (*InvertedIndex, error) {
 	if string(header.Magic[:]) != IndexSegmentMagic {
 		return nil, fmt.Errorf("invalid segment magic")
 	}
+	if header.Version < 1 || header.Version > IndexSegmentVersion {
+		return nil, fmt.Errorf("unsupported segment version %d", header.Version)
+	}
 	
-	// Create index
-	index := NewInvertedIndex()
-	index.termDict = make(map[string]*PostingList, header.TermCount)
+	dec := &segmentDecoder{r: r, version: header.Version, ordinals: ordinals, keep: keep, termCount: header.TermCount}
 	
-	// Read term dictionary
-	for i := uint32(0); i < header.TermCount; i++ {
-		// Read term
-		var termLen uint16
-		if err := binary.Read(seg.file, binary.LittleEndian, &termLen); err != nil {
-			return nil, err
+	// Read document ID dictionary, mapping segment ordinals to the index's
+	if header.Version >= 2 {
+		dec.docs = make([]uint32, 0, min(header.DocCount, 1<<16))
+		for i := uint32(0); i < header.DocCount; i++ {
+			docID, err := dec.readString()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read document dictionary: %w", err)
+			}
+			dec.docs = append(dec.docs, dec.assign(docID))
 		}
-		
-		termBytes := make([]byte, termLen)
-		if _, err := io.ReadFull(seg.file, termBytes); err != nil {
-			return nil, err
+	}
+	
+	// Read field lengths
+	if header.Version >= 6 {
+		if err := dec.readFieldLengths(); err != nil {
+			return nil, fmt.Errorf("failed to read field lengths: %w", err)
 		}
-		term := string(termBytes)
-		
-		// Read posting list
-		postingList, err := seg.readPostingList()
+	}
+	return dec, nil
+}
+
+// skippedDoc stands for the documents a decoder doesn't keep in the postings
+// it reads
+const skippedDoc = ^uint32(0)
+
+// segmentDecoder reads the body of a segment in any format version
+type segmentDecoder struct {
+	r            byteReader
+	version      uint16
+	ordinals     *docid.Ordinals           // Numbers the documents that are kept
+	keep         func(docID string) bool   // Documents to keep, nil for all
+	docs         []uint32                  // Index ordinal of each segment ordinal, or skippedDoc (version 2+)
+	fieldLengths map[string]map[uint32]int // Field -> ordinal -> length of the kept documents (version 6+)
+	termCount    uint32                    // Terms left to read
+	prevTerm     string                    // Previous term, for prefix-compressed terms (version 4+)
+}
+
+// assign returns the ordinal of a document, or skippedDoc if it isn't kept
+func (dec *segmentDecoder) assign(docID string) uint32 {
+	if dec.keep != nil && !dec.keep(docID) {
+		return skippedDoc
+	}
+	return dec.ordinals.Assign(docID)
+}
+
+// readFieldLengths reads the field lengths written by appendFieldLengths
+func (dec *segmentDecoder) readFieldLengths() error {
+	fields, err := binary.ReadUvarint(dec.r)
+	if err != nil {
+		return err
+	}
+	dec.fieldLengths = make(map[string]map[uint32]int, min(fields, 1024))
+	for ; fields > 0; fields-- {
+		field, err := dec.readString()
 		if err != nil {
-			return nil, err
+			return err
 		}
-		
-		index.termDict[term] = postingList
+		count, err := binary.ReadUvarint(dec.r)
+		if err != nil {
+			return err
+		}
+		lengths := make(map[uint32]int, min(count, uint64(len(dec.docs))))
+		var local uint64
+		for ; count > 0; count-- {
+			gap, err := binary.ReadUvarint(dec.r)
+			if err != nil {
+				return err
+			}
+			length, err := binary.ReadUvarint(dec.r)
+			if err != nil {
+				return err
+			}
+			local += gap
+			if local >= uint64(len(dec.docs)) {
+				return fmt.Errorf("field %s refers to document %d of %d", field, local, len(dec.docs))
+			}
+			if doc := dec.docs[local]; doc != skippedDoc {
+				lengths[doc] = int(length)
+			}
+		}
+		dec.fieldLengths[field] = lengths
+	}
+	return nil
+}
+
+// byteReader is read from by segmentDecoder
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// readUint reads a number: fixed-width little endian before version 3
+// (uint16 or uint32 per the width argument), an unsigned varint from version 3
+func (dec *segmentDecoder) readUint(width int) (uint64, error) {
+	if dec.version >= 3 {
+		return binary.ReadUvarint(dec.r)
+	}
+	if width == 16 {
+		var n uint16
+		err := binary.Read(dec.r, binary.LittleEndian, &n)
+		return uint64(n), err
+	}
+	var n uint32
+	err := binary.Read(dec.r, binary.LittleEndian, &n)
+	return uint64(n), err
+}
+
+// readString reads a string prefixed with its length
+func (dec *segmentDecoder) readString() (string, error) {
+	n, err := dec.readUint(16)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(dec.r, b); err != nil {
+		return "", err
 	}
+	return string(b), nil
+}
+
+// readTerm reads the next term key of the term dictionary
+// From version 4 a term is stored as the length of the prefix it shares with
+// the previous term, the rest of the term, then its posting list's size
+func (dec *segmentDecoder) readTerm() (string, error) {
+	if dec.termCount == 0 {
+		return "", io.EOF
+	}
+	dec.termCount--
+	if dec.version < 4 {
+		return dec.readString()
+	}
+	
+	shared, err := binary.ReadUvarint(dec.r)
+	if err != nil {
+		return "", err
+	}
+	if shared > uint64(len(dec.prevTerm)) {
+		return "", fmt.Errorf("term shares %d bytes with the %d byte previous term", shared, len(dec.prevTerm))
+	}
+	suffix, err := dec.readString()
+	if err != nil {
+		return "", err
+	}
+	term := dec.prevTerm[:shared] + suffix
+	dec.prevTerm = term
 	
-	return index, nil
+	// The posting list's size lets readers skip it; LoadSegment decodes it
+	if _, err := binary.ReadUvarint(dec.r); err != nil {
+		return "", err
+	}
+	return term, nil
 }
 
-// readPostingList reads a posting list from the file
-func (seg *IndexSegment) readPostingList() (*PostingList, error) {
+// readPostingList reads a posting list
+// Version 1 postings hold document IDs; later versions hold segment ordinals,
+// translated to the index's ordinals through dec.docs
+func (dec *segmentDecoder) readPostingList() (*PostingList, error) {
 	pl := NewPostingList()
 	
 	// Read document frequency
-	var docFreq uint32
-	if err := binary.Read(seg.file, binary.LittleEndian, &docFreq); err != nil {
+	docFreq, err := dec.readUint(32)
+	if err != nil {
 		return nil, err
 	}
 	pl.DocFreq = int(docFreq)
 	
-	// Read postings
-	pl.Postings = make([]Posting, 0, docFreq)
-	for i := uint32(0); i < docFreq; i++ {
-		// Read docID
-		var docIDLen uint16
-		if err := binary.Read(seg.file, binary.LittleEndian, &docIDLen); err != nil {
+	// Read impact bounds; older segments get the term frequency bound from
+	// the postings and no field length bound
+	if dec.version >= 5 {
+		maxTermFreq, err := dec.readUint(32)
+		if err != nil {
 			return nil, err
 		}
-		
-		docIDBytes := make([]byte, docIDLen)
-		if _, err := io.ReadFull(seg.file, docIDBytes); err != nil {
+		minFieldLength, err := dec.readUint(32)
+		if err != nil {
 			return nil, err
 		}
-		
+		pl.MaxTermFreq = int(maxTermFreq)
+		pl.MinFieldLength = int(minFieldLength)
+	}
+	
+	// Read postings
+	pl.Postings = make([]Posting, 0, docFreq)
+	var local uint64
+	for i := uint64(0); i < docFreq; i++ {
+		// Read document
+		var doc uint32
+		switch {
+		case dec.version == 1:
+			docID, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			doc = dec.assign(docID)
+		default:
+			n, err := dec.readUint(32)
+			if err != nil {
+				return nil, err
+			}
+			if dec.version >= 3 && i > 0 {
+				local += n // Gap from the previous posting
+			} else {
+				local = n
+			}
+			if local >= uint64(len(dec.docs)) {
+				return nil, fmt.Errorf("posting refers to document %d of %d", local, len(dec.docs))
+			}
+			doc = dec.docs[local]
+		}
+	
 		// Read term frequency
-		var termFreq uint32
-		if err := binary.Read(seg.file, binary.LittleEndian, &termFreq); err != nil {
+		termFreq, err := dec.readUint(32)
+		if err != nil {
 			return nil, err
 		}
-		
+	
 		// Read positions
-		var posCount uint32
-		if err := binary.Read(seg.file, binary.LittleEndian, &posCount); err != nil {
+		posCount, err := dec.readUint(32)
+		if err != nil {
 			return nil, err
 		}
-		
+	
 		positions := make([]int, posCount)
-		if posCount > 0 {
-			if err := binary.Read(seg.file, binary.LittleEndian, positions); err != nil {
+		prevPos := 0
+		for j := range positions {
+			pos, err := dec.readUint(32)
+			if err != nil {
 				return nil, err
 			}
+			if dec.version >= 3 {
+				prevPos += int(pos) // Gap from the previous position
+				positions[j] = prevPos
+			} else {
+				positions[j] = int(pos)
+			}
 		}
-		
+	
 		pl.Postings = append(pl.Postings, Posting{
-			DocID:     string(docIDBytes),
+			Doc:       doc,
 			TermFreq:  int(termFreq),
 			Positions: positions,
 		})
+		if dec.version < 5 {
+			pl.MaxTermFreq = max(pl.MaxTermFreq, int(termFreq))
+		}
 	}
 	
 	return pl, nil
 }
-
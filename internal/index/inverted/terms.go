@@ -0,0 +1,79 @@
+package inverted
+
+import (
+	"sort"
+	"strings"
+)
+
+// addTermKey adds a new key of termDict to the term keys
+// Caller must hold idx.mu for writing
+func (idx *InvertedIndex) addTermKey(termKey string) {
+	idx.termKeys = append(idx.termKeys, termKey)
+}
+
+// deleteTermKey notes that a key has left termDict; it leaves the term keys
+// when they are next sorted
+// Caller must hold idx.mu for writing
+func (idx *InvertedIndex) deleteTermKey(termKey string) {
+	idx.keysDeleted = true
+}
+
+// sortTermKeys sorts the keys added since the last call and merges them into
+// the sorted ones, dropping deleted keys
+// Only writers change the keys, so readers holding idx.mu for reading may call
+// it; the first one sorts and the rest find the keys sorted
+// Caller must hold idx.mu
+func (idx *InvertedIndex) sortTermKeys() {
+	idx.keysMu.Lock()
+	defer idx.keysMu.Unlock()
+
+	if idx.sortedKeys == len(idx.termKeys) && !idx.keysDeleted {
+		return
+	}
+	sorted, added := idx.termKeys[:idx.sortedKeys], idx.termKeys[idx.sortedKeys:]
+	sort.Strings(added)
+
+	merged := make([]string, 0, len(idx.termKeys))
+	for len(sorted) > 0 || len(added) > 0 {
+		var termKey string
+		if len(added) == 0 || len(sorted) > 0 && sorted[0] <= added[0] {
+			termKey, sorted = sorted[0], sorted[1:]
+		} else {
+			termKey, added = added[0], added[1:]
+		}
+		if _, ok := idx.termDict[termKey]; !ok {
+			continue // Deleted
+		}
+		if n := len(merged); n > 0 && merged[n-1] == termKey {
+			continue // Deleted and added again
+		}
+		merged = append(merged, termKey)
+	}
+	idx.termKeys, idx.sortedKeys, idx.keysDeleted = merged, len(merged), false
+}
+
+// termKeyRange returns the sorted term keys starting with keyPrefix
+// The result shares the index's storage; caller must hold idx.mu and not modify it
+func (idx *InvertedIndex) termKeyRange(keyPrefix string) []string {
+	idx.sortTermKeys()
+	start := sort.SearchStrings(idx.termKeys, keyPrefix)
+	end := start + sort.Search(len(idx.termKeys)-start, func(i int) bool {
+		return !strings.HasPrefix(idx.termKeys[start+i], keyPrefix)
+	})
+	return idx.termKeys[start:end]
+}
+
+// Terms calls fn with each term of a field in sorted order, with its posting
+// list, until fn returns false
+// fn must not modify the index
+func (idx *InvertedIndex) Terms(fieldName string, fn func(term string, pl *PostingList) bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	prefix := fieldName + ":"
+	for _, termKey := range idx.termKeyRange(prefix) {
+		if !fn(termKey[len(prefix):], idx.termDict[termKey]) {
+			return
+		}
+	}
+}
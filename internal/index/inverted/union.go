@@ -0,0 +1,130 @@
+package inverted
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// PostingIterator walks a posting list in document order
+type PostingIterator struct {
+	list *PostingList
+	i    int
+}
+
+// Iterator returns an iterator positioned on the list's first posting
+func (pl *PostingList) Iterator() *PostingIterator {
+	return &PostingIterator{list: pl}
+}
+
+// Done reports whether the iterator has moved past the last posting
+func (it *PostingIterator) Done() bool {
+	return it.i >= len(it.list.Postings)
+}
+
+// Posting returns the current posting
+// Only valid while !Done()
+func (it *PostingIterator) Posting() *Posting {
+	return &it.list.Postings[it.i]
+}
+
+// Next moves to the next posting
+func (it *PostingIterator) Next() {
+	it.i++
+}
+
+// Advance moves to the first posting with an ordinal >= doc
+func (it *PostingIterator) Advance(doc uint32) {
+	postings := it.list.Postings[it.i:]
+	it.i += sort.Search(len(postings), func(j int) bool {
+		return postings[j].Doc >= doc
+	})
+}
+
+// Disjunction merges posting iterators document-at-a-time (OR semantics)
+// A min-heap keyed by each iterator's current ordinal yields every document
+// once, together with the postings of all the lists containing it, so scores
+// are accumulated per document instead of per list
+type Disjunction struct {
+	heap    iteratorHeap
+	matched []DisjunctionMatch
+}
+
+// DisjunctionMatch is one list's posting for the current document
+type DisjunctionMatch struct {
+	List    int // Index of the list in the order given to NewDisjunction
+	Posting *Posting
+}
+
+// NewDisjunction creates a disjunction over posting lists; nil lists are skipped
+func NewDisjunction(lists []*PostingList) *Disjunction {
+	d := &Disjunction{heap: make(iteratorHeap, 0, len(lists))}
+	for i, pl := range lists {
+		if pl == nil || pl.Size() == 0 {
+			continue
+		}
+		d.heap = append(d.heap, heapEntry{it: pl.Iterator(), list: i})
+	}
+	heap.Init(&d.heap)
+	return d
+}
+
+// Next moves to the next document in any list and returns its ordinal
+// Matches returns the postings for it; ok is false once every list is exhausted
+func (d *Disjunction) Next() (doc uint32, ok bool) {
+	d.matched = d.matched[:0]
+	if len(d.heap) == 0 {
+		return 0, false
+	}
+
+	doc = d.heap[0].it.Posting().Doc
+	for len(d.heap) > 0 && d.heap[0].it.Posting().Doc == doc {
+		top := &d.heap[0]
+		d.matched = append(d.matched, DisjunctionMatch{List: top.list, Posting: top.it.Posting()})
+		top.it.Next()
+		if top.it.Done() {
+			heap.Pop(&d.heap)
+		} else {
+			heap.Fix(&d.heap, 0)
+		}
+	}
+
+	// In list order, so scores are summed in the same order for every document
+	sort.Slice(d.matched, func(i, j int) bool {
+		return d.matched[i].List < d.matched[j].List
+	})
+	return doc, true
+}
+
+// Matches returns the postings of the current document, ordered by list
+// The slice is reused by the next call to Next
+func (d *Disjunction) Matches() []DisjunctionMatch {
+	return d.matched
+}
+
+// heapEntry is an iterator in a Disjunction, with the index of its list
+type heapEntry struct {
+	it   *PostingIterator
+	list int
+}
+
+// iteratorHeap is a min-heap of iterators by current ordinal
+type iteratorHeap []heapEntry
+
+// less orders iterators by current ordinal, then by list
+func (h iteratorHeap) less(a, b heapEntry) bool {
+	if docA, docB := a.it.Posting().Doc, b.it.Posting().Doc; docA != docB {
+		return docA < docB
+	}
+	return a.list < b.list
+}
+
+func (h iteratorHeap) Len() int            { return len(h) }
+func (h iteratorHeap) Less(i, j int) bool  { return h.less(h[i], h[j]) }
+func (h iteratorHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *iteratorHeap) Push(x interface{}) { *h = append(*h, x.(heapEntry)) }
+func (h *iteratorHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
@@ -0,0 +1,112 @@
+package inverted
+
+import "strings"
+
+// SearchPrefix finds documents containing any term in the field that starts with prefix
+// so "amer" matches "american" and "america"
+// Like Elasticsearch, the prefix is not analyzed; pass it in indexed form (e.g. lowercase)
+// Returns nil if no term matches
+func (idx *InvertedIndex) SearchPrefix(fieldName string, prefix string) *PostingList {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return idx.unionMatchingTerms(fieldName, prefix, func(term string) bool {
+		return strings.HasPrefix(term, prefix)
+	})
+}
+
+// SearchWildcard finds documents containing any term in the field matching pattern,
+// where '*' matches any sequence of characters and '?' matches exactly one
+// The pattern is not analyzed; pass it in indexed form (e.g. lowercase)
+// Returns nil if no term matches
+func (idx *InvertedIndex) SearchWildcard(fieldName string, pattern string) *PostingList {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	// Only terms sharing the literal prefix before the first wildcard can match
+	literal := pattern
+	if i := strings.IndexAny(pattern, "*?"); i >= 0 {
+		literal = pattern[:i]
+	}
+
+	patternRunes := []rune(pattern)
+	return idx.unionMatchingTerms(fieldName, literal, func(term string) bool {
+		return wildcardMatch(patternRunes, []rune(term))
+	})
+}
+
+// PrefixTerms returns the terms in a field starting with prefix, sorted alphabetically
+func (idx *InvertedIndex) PrefixTerms(fieldName string, prefix string) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return idx.matchingTerms(fieldName, prefix, func(term string) bool {
+		return strings.HasPrefix(term, prefix)
+	})
+}
+
+// matchingTerms scans the field's terms sharing a literal prefix and returns those
+// accepted by match, sorted alphabetically
+// Caller must hold idx.mu
+func (idx *InvertedIndex) matchingTerms(fieldName string, literal string, match func(term string) bool) []string {
+	// Terms sharing the literal prefix are a contiguous, sorted range of keys
+	var terms []string
+	for _, termKey := range idx.termKeyRange(fieldName + ":" + literal) {
+		term := termKey[len(fieldName)+1:]
+		if match(term) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// unionMatchingTerms unions the posting lists of every term accepted by match
+// Caller must hold idx.mu
+func (idx *InvertedIndex) unionMatchingTerms(fieldName string, literal string, match func(term string) bool) *PostingList {
+	terms := idx.matchingTerms(fieldName, literal, match)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	lists := make([]*PostingList, len(terms))
+	for i, term := range terms {
+		lists[i] = idx.termDict[fieldName+":"+term]
+	}
+	return unionPostingLists(lists)
+}
+
+// WildcardMatch reports whether text matches a pattern of literals, '*' and '?'
+func WildcardMatch(pattern string, text string) bool {
+	return wildcardMatch([]rune(pattern), []rune(text))
+}
+
+// wildcardMatch reports whether text matches a pattern of literals, '*' and '?'
+// It runs in O(len(pattern) * len(text)) using single-star backtracking
+func wildcardMatch(pattern, text []rune) bool {
+	p, t := 0, 0
+	starP, starT := -1, 0
+
+	for t < len(text) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == text[t]):
+			p++
+			t++
+		case p < len(pattern) && pattern[p] == '*':
+			// Remember the star and first try matching it against nothing
+			starP, starT = p, t
+			p++
+		case starP >= 0:
+			// Mismatch: let the last star absorb one more character
+			starT++
+			p, t = starP+1, starT
+		default:
+			return false
+		}
+	}
+
+	// Any remaining pattern must be stars
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
@@ -0,0 +1,149 @@
+package keyword
+
+import (
+	"sort"
+	"sync"
+
+	"nano-elastic/internal/types"
+)
+
+// KeywordIndex maps exact (not analyzed) field values to documents
+// Unlike the inverted index, values are never tokenized or lowercased:
+// "George Orwell" only matches "George Orwell"
+type KeywordIndex struct {
+	// postings maps field -> value -> set of document IDs
+	postings map[string]map[string]map[string]struct{}
+
+	// values maps field -> document ID -> value, for removal and lookups
+	values map[string]map[string]string
+
+	mu sync.RWMutex
+}
+
+// NewKeywordIndex creates a new keyword index
+func NewKeywordIndex() *KeywordIndex {
+	return &KeywordIndex{
+		postings: make(map[string]map[string]map[string]struct{}),
+		values:   make(map[string]map[string]string),
+	}
+}
+
+// IndexValue indexes a keyword value for a document's field
+// Indexing a field again for the same document replaces the old value
+func (ki *KeywordIndex) IndexValue(docID string, fieldName string, value string) {
+	ki.mu.Lock()
+	defer ki.mu.Unlock()
+
+	fieldValues, ok := ki.values[fieldName]
+	if !ok {
+		fieldValues = make(map[string]string)
+		ki.values[fieldName] = fieldValues
+		ki.postings[fieldName] = make(map[string]map[string]struct{})
+	}
+
+	if old, exists := fieldValues[docID]; exists {
+		ki.removeValue(fieldName, docID, old)
+	}
+
+	docs, ok := ki.postings[fieldName][value]
+	if !ok {
+		docs = make(map[string]struct{})
+		ki.postings[fieldName][value] = docs
+	}
+	docs[docID] = struct{}{}
+	fieldValues[docID] = value
+}
+
+// IndexDocument indexes every KeywordValue field of a document
+func (ki *KeywordIndex) IndexDocument(doc *types.Document) {
+	for name, value := range doc.Fields {
+		if kw, ok := value.(types.KeywordValue); ok {
+			ki.IndexValue(doc.ID, name, kw.Value)
+		}
+	}
+}
+
+// RemoveDocument removes a document's values from every field
+func (ki *KeywordIndex) RemoveDocument(docID string) {
+	ki.mu.Lock()
+	defer ki.mu.Unlock()
+
+	for fieldName, fieldValues := range ki.values {
+		if value, exists := fieldValues[docID]; exists {
+			ki.removeValue(fieldName, docID, value)
+		}
+	}
+}
+
+// SearchTerm returns the IDs of documents whose field exactly equals value, sorted
+func (ki *KeywordIndex) SearchTerm(fieldName string, value string) []string {
+	return ki.SearchTerms(fieldName, value)
+}
+
+// SearchTerms returns the IDs of documents whose field exactly equals any of the values, sorted
+func (ki *KeywordIndex) SearchTerms(fieldName string, values ...string) []string {
+	ki.mu.RLock()
+	defer ki.mu.RUnlock()
+
+	fieldPostings, ok := ki.postings[fieldName]
+	if !ok {
+		return nil
+	}
+
+	var docIDs []string
+	for _, value := range values {
+		for docID := range fieldPostings[value] {
+			docIDs = append(docIDs, docID)
+		}
+	}
+	sort.Strings(docIDs)
+	return docIDs
+}
+
+// SearchMatching returns the IDs of documents whose field value is accepted by match, sorted
+// It scans every distinct value of the field, so it suits prefix and wildcard queries
+func (ki *KeywordIndex) SearchMatching(fieldName string, match func(value string) bool) []string {
+	ki.mu.RLock()
+	defer ki.mu.RUnlock()
+
+	var docIDs []string
+	for value, docs := range ki.postings[fieldName] {
+		if !match(value) {
+			continue
+		}
+		for docID := range docs {
+			docIDs = append(docIDs, docID)
+		}
+	}
+	sort.Strings(docIDs)
+	return docIDs
+}
+
+// Value returns a document's indexed value for a field
+func (ki *KeywordIndex) Value(fieldName string, docID string) (string, bool) {
+	ki.mu.RLock()
+	defer ki.mu.RUnlock()
+
+	value, ok := ki.values[fieldName][docID]
+	return value, ok
+}
+
+// DocFreq returns the number of documents with the exact value
+func (ki *KeywordIndex) DocFreq(fieldName string, value string) int {
+	ki.mu.RLock()
+	defer ki.mu.RUnlock()
+
+	return len(ki.postings[fieldName][value])
+}
+
+// removeValue removes one document's value from a field
+// Caller must hold ki.mu
+func (ki *KeywordIndex) removeValue(fieldName string, docID string, value string) {
+	if docs, ok := ki.postings[fieldName][value]; ok {
+		delete(docs, docID)
+		if len(docs) == 0 {
+			delete(ki.postings[fieldName], value)
+		}
+	}
+	delete(ki.values[fieldName], docID)
+}
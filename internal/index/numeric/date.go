@@ -0,0 +1,218 @@
+package numeric
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"nano-elastic/internal/types"
+)
+
+// Dates are indexed as epoch milliseconds, which float64 represents exactly
+// for any date within ±285,000 years of 1970
+
+// DateToMillis converts a time to the value stored in the numeric index
+func DateToMillis(t time.Time) float64 {
+	return float64(t.UnixMilli())
+}
+
+// MillisToDate converts an indexed value back to a UTC time
+func MillisToDate(ms float64) time.Time {
+	return time.UnixMilli(int64(ms)).UTC()
+}
+
+// DateRangeQuery describes bounds on a date field as date strings or date math
+// Each bound accepts a date in one of the field's formats or an expression like
+// "now-7d", "now/d", or "2024-01-01||+1M/M"; empty bounds are open
+type DateRangeQuery struct {
+	GT  string `json:"gt,omitempty"`
+	GTE string `json:"gte,omitempty"`
+	LT  string `json:"lt,omitempty"`
+	LTE string `json:"lte,omitempty"`
+}
+
+// Resolve evaluates the bounds against a reference time and returns a numeric range
+// Rounding follows Elasticsearch: gt and lte round up to the end of the unit,
+// gte and lt round down to its start, so "lte": "now/d" includes all of today
+func (q DateRangeQuery) Resolve(now time.Time, formats []string) (RangeQuery, error) {
+	var r RangeQuery
+	var err error
+	if r.GT, err = resolveDateBound(q.GT, now, formats, true); err != nil {
+		return RangeQuery{}, err
+	}
+	if r.GTE, err = resolveDateBound(q.GTE, now, formats, false); err != nil {
+		return RangeQuery{}, err
+	}
+	if r.LT, err = resolveDateBound(q.LT, now, formats, false); err != nil {
+		return RangeQuery{}, err
+	}
+	if r.LTE, err = resolveDateBound(q.LTE, now, formats, true); err != nil {
+		return RangeQuery{}, err
+	}
+	return r, nil
+}
+
+// resolveDateBound evaluates a single bound, returning nil for an open bound
+func resolveDateBound(expr string, now time.Time, formats []string, roundUp bool) (*float64, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	t, err := ParseDateMath(expr, now, formats, roundUp)
+	if err != nil {
+		return nil, err
+	}
+	ms := DateToMillis(t)
+	return &ms, nil
+}
+
+// SearchDates returns the IDs of documents whose date field falls within the range
+// formats are the field's accepted input formats (nil for the defaults)
+func (ni *NumericIndex) SearchDates(fieldName string, q DateRangeQuery, formats []string) ([]string, error) {
+	r, err := q.Resolve(time.Now(), formats)
+	if err != nil {
+		return nil, err
+	}
+	return ni.Search(fieldName, r), nil
+}
+
+// ParseDateMath evaluates a date math expression
+//
+//	now                  the reference time
+//	2024-01-15||         an explicit anchor date, parsed with formats
+//	+1d, -2h             add or subtract an amount of a unit
+//	/d                   round to the unit (down, or up to its last millisecond if roundUp)
+//
+// Units are y (years), M (months), w (weeks), d (days), h/H (hours), m (minutes), s (seconds)
+// A plain date with no "||" is also accepted
+func ParseDateMath(expr string, now time.Time, formats []string, roundUp bool) (time.Time, error) {
+	expr = strings.TrimSpace(expr)
+
+	var anchor time.Time
+	var ops string
+	switch {
+	case strings.HasPrefix(expr, "now"):
+		anchor = now.UTC()
+		ops = expr[len("now"):]
+	case strings.Contains(expr, "||"):
+		i := strings.Index(expr, "||")
+		t, err := types.ParseDate(expr[:i], formats)
+		if err != nil {
+			return time.Time{}, err
+		}
+		anchor = t
+		ops = expr[i+2:]
+	default:
+		return types.ParseDate(expr, formats)
+	}
+
+	t := anchor
+	for len(ops) > 0 {
+		op := ops[0]
+		ops = ops[1:]
+
+		switch op {
+		case '+', '-':
+			// Read the amount, then the unit
+			n := 0
+			for n < len(ops) && ops[n] >= '0' && ops[n] <= '9' {
+				n++
+			}
+			amount := 1
+			if n > 0 {
+				var err error
+				if amount, err = strconv.Atoi(ops[:n]); err != nil {
+					return time.Time{}, fmt.Errorf("invalid date math amount in %q", expr)
+				}
+			}
+			if n >= len(ops) {
+				return time.Time{}, fmt.Errorf("missing date math unit in %q", expr)
+			}
+			if op == '-' {
+				amount = -amount
+			}
+			var err error
+			if t, err = addDateUnit(t, amount, ops[n]); err != nil {
+				return time.Time{}, fmt.Errorf("%w in %q", err, expr)
+			}
+			ops = ops[n+1:]
+
+		case '/':
+			if len(ops) == 0 {
+				return time.Time{}, fmt.Errorf("missing rounding unit in %q", expr)
+			}
+			var err error
+			if t, err = roundDateUnit(t, ops[0], roundUp); err != nil {
+				return time.Time{}, fmt.Errorf("%w in %q", err, expr)
+			}
+			ops = ops[1:]
+
+		default:
+			return time.Time{}, fmt.Errorf("unexpected %q in date math %q", op, expr)
+		}
+	}
+
+	return t, nil
+}
+
+// TruncateDate rounds t down to the start of its date math unit (y, M, w, d, h, m or s)
+func TruncateDate(t time.Time, unit byte) (time.Time, error) {
+	return roundDateUnit(t, unit, false)
+}
+
+// AddDateUnits adds amount date math units to t
+func AddDateUnits(t time.Time, amount int, unit byte) (time.Time, error) {
+	return addDateUnit(t, amount, unit)
+}
+
+// addDateUnit adds amount units to t
+func addDateUnit(t time.Time, amount int, unit byte) (time.Time, error) {
+	switch unit {
+	case 'y':
+		return t.AddDate(amount, 0, 0), nil
+	case 'M':
+		return t.AddDate(0, amount, 0), nil
+	case 'w':
+		return t.AddDate(0, 0, 7*amount), nil
+	case 'd':
+		return t.AddDate(0, 0, amount), nil
+	case 'h', 'H':
+		return t.Add(time.Duration(amount) * time.Hour), nil
+	case 'm':
+		return t.Add(time.Duration(amount) * time.Minute), nil
+	case 's':
+		return t.Add(time.Duration(amount) * time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown date math unit %q", unit)
+}
+
+// roundDateUnit rounds t down to the start of its unit, or up to the unit's last millisecond
+func roundDateUnit(t time.Time, unit byte, roundUp bool) (time.Time, error) {
+	var start time.Time
+	switch unit {
+	case 'y':
+		start = time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+	case 'M':
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	case 'w':
+		// Weeks start on Monday (ISO 8601)
+		offset := (int(t.Weekday()) + 6) % 7
+		start = time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, t.Location())
+	case 'd':
+		start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	case 'h', 'H':
+		start = t.Truncate(time.Hour)
+	case 'm':
+		start = t.Truncate(time.Minute)
+	case 's':
+		start = t.Truncate(time.Second)
+	default:
+		return time.Time{}, fmt.Errorf("unknown date math unit %q", unit)
+	}
+
+	if !roundUp {
+		return start, nil
+	}
+	next, _ := addDateUnit(start, 1, unit)
+	return next.Add(-time.Millisecond), nil
+}
@@ -0,0 +1,200 @@
+package numeric
+
+import (
+	"sort"
+	"sync"
+
+	"nano-elastic/internal/types"
+)
+
+// entry is a single (value, document) pair in a field's sorted column
+// seq tells a document's current entry from stale ones left by removals
+type entry struct {
+	Value float64
+	DocID string
+	seq   uint64
+}
+
+// liveValue is a document's current value and the seq of its entry
+type liveValue struct {
+	value float64
+	seq   uint64
+}
+
+// fieldIndex holds one numeric field's values, sorted by value for range scans
+// Removing a value only drops it from values, leaving its entry as a tombstone
+// that searches skip; once tombstones outnumber live entries they are
+// compacted away, so removals cost O(1) amortized rather than a slice shift
+type fieldIndex struct {
+	entries []entry              // Sorted by (Value, DocID) once dirty is cleared
+	values  map[string]liveValue // DocID -> current value, for removal and lookups
+	seq     uint64               // Last seq assigned to an entry
+	stale   int                  // Tombstoned entries still in entries
+	dirty   bool                 // entries needs re-sorting before the next search
+}
+
+// NumericIndex maps numeric field values to documents so they can be range-filtered
+// Each field keeps a value-sorted slice; range queries binary-search its bounds
+type NumericIndex struct {
+	fields map[string]*fieldIndex
+	mu     sync.RWMutex
+}
+
+// NewNumericIndex creates a new numeric index
+func NewNumericIndex() *NumericIndex {
+	return &NumericIndex{
+		fields: make(map[string]*fieldIndex),
+	}
+}
+
+// IndexValue indexes a numeric value for a document's field
+// Indexing a field again for the same document replaces the old value
+func (ni *NumericIndex) IndexValue(docID string, fieldName string, value float64) {
+	ni.mu.Lock()
+	defer ni.mu.Unlock()
+
+	fi, ok := ni.fields[fieldName]
+	if !ok {
+		fi = &fieldIndex{values: make(map[string]liveValue)}
+		ni.fields[fieldName] = fi
+	}
+
+	fi.remove(docID)
+
+	// Append now and sort lazily, so bulk indexing stays O(n log n) overall
+	fi.seq++
+	fi.entries = append(fi.entries, entry{Value: value, DocID: docID, seq: fi.seq})
+	fi.values[docID] = liveValue{value: value, seq: fi.seq}
+	fi.dirty = true
+}
+
+// IndexDocument indexes every NumericValue and DateValue field of a document
+// Dates are stored as epoch milliseconds
+func (ni *NumericIndex) IndexDocument(doc *types.Document) {
+	for name, value := range doc.Fields {
+		switch v := value.(type) {
+		case types.NumericValue:
+			ni.IndexValue(doc.ID, name, v.Value)
+		case types.DateValue:
+			ni.IndexValue(doc.ID, name, DateToMillis(v.Value))
+		}
+	}
+}
+
+// RemoveDocument removes a document's values from every field
+func (ni *NumericIndex) RemoveDocument(docID string) {
+	ni.mu.Lock()
+	defer ni.mu.Unlock()
+
+	for _, fi := range ni.fields {
+		fi.remove(docID)
+	}
+}
+
+// Value returns a document's indexed value for a field
+func (ni *NumericIndex) Value(fieldName string, docID string) (float64, bool) {
+	ni.mu.RLock()
+	defer ni.mu.RUnlock()
+
+	fi, ok := ni.fields[fieldName]
+	if !ok {
+		return 0, false
+	}
+	live, ok := fi.values[docID]
+	return live.value, ok
+}
+
+// Search returns the IDs of documents whose field value falls within the range,
+// in ascending value order
+func (ni *NumericIndex) Search(fieldName string, q RangeQuery) []string {
+	ni.mu.Lock() // Write lock: a search may need to sort pending entries
+	defer ni.mu.Unlock()
+
+	fi, ok := ni.fields[fieldName]
+	if !ok {
+		return nil
+	}
+	fi.ensureSorted()
+
+	// Binary search for the first entry past the lower bound
+	start := 0
+	if q.GTE != nil {
+		start = sort.Search(len(fi.entries), func(i int) bool { return fi.entries[i].Value >= *q.GTE })
+	}
+	if q.GT != nil {
+		gt := sort.Search(len(fi.entries), func(i int) bool { return fi.entries[i].Value > *q.GT })
+		if gt > start {
+			start = gt
+		}
+	}
+
+	// And for the first entry past the upper bound
+	end := len(fi.entries)
+	if q.LTE != nil {
+		end = sort.Search(len(fi.entries), func(i int) bool { return fi.entries[i].Value > *q.LTE })
+	}
+	if q.LT != nil {
+		lt := sort.Search(len(fi.entries), func(i int) bool { return fi.entries[i].Value >= *q.LT })
+		if lt < end {
+			end = lt
+		}
+	}
+
+	if start >= end {
+		return nil
+	}
+
+	docIDs := make([]string, 0, end-start)
+	for _, e := range fi.entries[start:end] {
+		if fi.live(e) {
+			docIDs = append(docIDs, e.DocID)
+		}
+	}
+	return docIDs
+}
+
+// ensureSorted sorts the field's entries if values were added since the last search
+func (fi *fieldIndex) ensureSorted() {
+	if !fi.dirty {
+		return
+	}
+	sort.Slice(fi.entries, func(i, j int) bool {
+		if fi.entries[i].Value != fi.entries[j].Value {
+			return fi.entries[i].Value < fi.entries[j].Value
+		}
+		return fi.entries[i].DocID < fi.entries[j].DocID
+	})
+	fi.dirty = false
+}
+
+// live reports whether an entry is its document's current value
+func (fi *fieldIndex) live(e entry) bool {
+	current, ok := fi.values[e.DocID]
+	return ok && current.seq == e.seq
+}
+
+// remove tombstones a document's entry, compacting the field once more than
+// half of its entries are tombstones
+func (fi *fieldIndex) remove(docID string) {
+	if _, ok := fi.values[docID]; !ok {
+		return
+	}
+	delete(fi.values, docID)
+	fi.stale++
+	if fi.stale > len(fi.entries)/2 {
+		fi.compact()
+	}
+}
+
+// compact drops tombstoned entries, keeping the rest in order
+func (fi *fieldIndex) compact() {
+	kept := fi.entries[:0]
+	for _, e := range fi.entries {
+		if fi.live(e) {
+			kept = append(kept, e)
+		}
+	}
+	clear(fi.entries[len(kept):]) // Release the dropped IDs
+	fi.entries = kept
+	fi.stale = 0
+}
@@ -0,0 +1,100 @@
+package numeric
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RangeQuery describes bounds on a numeric field
+// Nil bounds are open; e.g. year >= 1900 AND year < 1960 is Range().Gte(1900).Lt(1960)
+type RangeQuery struct {
+	GT  *float64 `json:"gt,omitempty"`
+	GTE *float64 `json:"gte,omitempty"`
+	LT  *float64 `json:"lt,omitempty"`
+	LTE *float64 `json:"lte,omitempty"`
+}
+
+// Range starts building an unbounded range query
+func Range() RangeQuery {
+	return RangeQuery{}
+}
+
+// Gt sets an exclusive lower bound
+func (q RangeQuery) Gt(v float64) RangeQuery {
+	q.GT = &v
+	return q
+}
+
+// Gte sets an inclusive lower bound
+func (q RangeQuery) Gte(v float64) RangeQuery {
+	q.GTE = &v
+	return q
+}
+
+// Lt sets an exclusive upper bound
+func (q RangeQuery) Lt(v float64) RangeQuery {
+	q.LT = &v
+	return q
+}
+
+// Lte sets an inclusive upper bound
+func (q RangeQuery) Lte(v float64) RangeQuery {
+	q.LTE = &v
+	return q
+}
+
+// Matches reports whether a value falls within the range
+func (q RangeQuery) Matches(v float64) bool {
+	if q.GT != nil && !(v > *q.GT) {
+		return false
+	}
+	if q.GTE != nil && !(v >= *q.GTE) {
+		return false
+	}
+	if q.LT != nil && !(v < *q.LT) {
+		return false
+	}
+	if q.LTE != nil && !(v <= *q.LTE) {
+		return false
+	}
+	return true
+}
+
+// String formats the range in interval notation, e.g. [1900 TO 1960)
+func (q RangeQuery) String() string {
+	var b strings.Builder
+	switch {
+	case q.GT != nil:
+		b.WriteString("(" + formatBound(*q.GT))
+	case q.GTE != nil:
+		b.WriteString("[" + formatBound(*q.GTE))
+	default:
+		b.WriteString("[*")
+	}
+	b.WriteString(" TO ")
+	switch {
+	case q.LT != nil:
+		b.WriteString(formatBound(*q.LT) + ")")
+	case q.LTE != nil:
+		b.WriteString(formatBound(*q.LTE) + "]")
+	default:
+		b.WriteString("*]")
+	}
+	return b.String()
+}
+
+// Validate checks that the range isn't trivially empty
+func (q RangeQuery) Validate() error {
+	if q.GT != nil && q.GTE != nil {
+		return fmt.Errorf("range cannot set both gt and gte")
+	}
+	if q.LT != nil && q.LTE != nil {
+		return fmt.Errorf("range cannot set both lt and lte")
+	}
+	return nil
+}
+
+func formatBound(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,374 @@
+package vector
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"nano-elastic/internal/types"
+)
+
+// Metric is a vector similarity function
+type Metric string
+
+const (
+	MetricCosine     Metric = "cosine"
+	MetricDotProduct Metric = "dot_product"
+	MetricEuclidean  Metric = "l2_norm"
+)
+
+// ParseMetric parses a metric name
+// "euclidean" and "dot" are accepted as aliases; empty is cosine
+func ParseMetric(name string) (Metric, error) {
+	switch name {
+	case "", string(MetricCosine):
+		return MetricCosine, nil
+	case string(MetricDotProduct), "dot":
+		return MetricDotProduct, nil
+	case string(MetricEuclidean), "euclidean", "l2":
+		return MetricEuclidean, nil
+	}
+	return "", fmt.Errorf("unknown similarity metric %q", name)
+}
+
+// Similarity scores two vectors of the same dimension; higher is more similar
+// Scores are non-negative so they combine with other query scores:
+// cosine is (1+cos)/2, dot product is (1+dot)/2 for dot >= 0 and 1/(1-dot)
+// otherwise, and euclidean is 1/(1+distance²)
+func Similarity(metric Metric, a, b []float32) float64 {
+	switch metric {
+	case MetricDotProduct:
+		dot := dotProduct(a, b)
+		if dot < 0 {
+			return 1 / (1 - dot)
+		}
+		return (1 + dot) / 2
+	case MetricEuclidean:
+		var sum float64
+		for i := range a {
+			d := float64(a[i]) - float64(b[i])
+			sum += d * d
+		}
+		return 1 / (1 + sum)
+	}
+	norm := magnitude(a) * magnitude(b)
+	if norm == 0 {
+		return 0
+	}
+	return (1 + dotProduct(a, b)/norm) / 2
+}
+
+// dotProduct returns the dot product of two vectors
+func dotProduct(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+// magnitude returns a vector's euclidean length
+func magnitude(v []float32) float64 {
+	return math.Sqrt(dotProduct(v, v))
+}
+
+// Hit is a document returned by a k-NN search
+type Hit struct {
+	DocID string
+	Score float64
+}
+
+// fieldVectors holds one vector field's values, at full precision or quantized
+type fieldVectors struct {
+	quantization types.VectorQuantization
+	vectors      map[string][]float32       // DocID -> vector, when not quantized
+	quantized    map[string]quantizedVector // DocID -> codes, when quantized
+}
+
+// get returns a document's vector, dequantized if needed
+func (fv *fieldVectors) get(docID string) ([]float32, bool) {
+	if fv.quantization == types.QuantizationNone {
+		vec, ok := fv.vectors[docID]
+		return vec, ok
+	}
+	qv, ok := fv.quantized[docID]
+	if !ok {
+		return nil, false
+	}
+	return qv.dequantize(make([]float32, len(qv.codes))), true
+}
+
+// VectorIndex stores vector field values for nearest-neighbour search
+// Searches are exact: every vector of the field is scored. Quantized fields
+// are scored approximately, then their best candidates are rescored with the
+// full-precision vectors returned by the rescore loader
+type VectorIndex struct {
+	fields  map[string]*fieldVectors
+	rescore RescoreLoader
+	mu      sync.RWMutex
+}
+
+// RescoreLoader returns a document's full-precision vector for a field
+type RescoreLoader func(fieldName string, docID string) ([]float32, bool)
+
+// NewVectorIndex creates a new vector index
+func NewVectorIndex() *VectorIndex {
+	return &VectorIndex{
+		fields: make(map[string]*fieldVectors),
+	}
+}
+
+// SetFieldQuantization sets how a field's vectors are held in memory,
+// converting any vectors already indexed
+// Converting quantized vectors back to full precision keeps their rounding
+func (vi *VectorIndex) SetFieldQuantization(fieldName string, q types.VectorQuantization) error {
+	if err := ValidateQuantization(q); err != nil {
+		return fmt.Errorf("field %s: %w", fieldName, err)
+	}
+
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	old := vi.field(fieldName)
+	if old.quantization == q {
+		return nil
+	}
+	fv := newFieldVectors(q)
+	for id := range old.vectors {
+		fv.set(id, old.vectors[id])
+	}
+	for id := range old.quantized {
+		vec, _ := old.get(id)
+		fv.set(id, vec)
+	}
+	vi.fields[fieldName] = fv
+	return nil
+}
+
+// ValidateQuantization checks that a quantization is supported
+func ValidateQuantization(q types.VectorQuantization) error {
+	if q != types.QuantizationNone && q != types.QuantizationInt8 {
+		return fmt.Errorf("unknown quantization %q", q)
+	}
+	return nil
+}
+
+// SetRescoreLoader sets the source of full-precision vectors for rescoring
+// quantized fields; without one, quantized scores are final
+func (vi *VectorIndex) SetRescoreLoader(loader RescoreLoader) {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	vi.rescore = loader
+}
+
+// newFieldVectors creates an empty field
+func newFieldVectors(q types.VectorQuantization) *fieldVectors {
+	if q == types.QuantizationNone {
+		return &fieldVectors{vectors: make(map[string][]float32)}
+	}
+	return &fieldVectors{quantization: q, quantized: make(map[string]quantizedVector)}
+}
+
+// set stores a document's vector
+func (fv *fieldVectors) set(docID string, vec []float32) {
+	if fv.quantization == types.QuantizationNone {
+		fv.vectors[docID] = vec
+		return
+	}
+	fv.quantized[docID] = quantizeInt8(vec)
+}
+
+// field returns a field's vectors, creating it at full precision if needed
+// Caller must hold vi.mu for writing
+func (vi *VectorIndex) field(fieldName string) *fieldVectors {
+	fv, ok := vi.fields[fieldName]
+	if !ok {
+		fv = newFieldVectors(types.QuantizationNone)
+		vi.fields[fieldName] = fv
+	}
+	return fv
+}
+
+// IndexValue indexes a vector for a document's field
+// Indexing a field again for the same document replaces the old vector
+func (vi *VectorIndex) IndexValue(docID string, fieldName string, vec []float32) {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	vi.field(fieldName).set(docID, vec)
+}
+
+// IndexDocument indexes every VectorValue field of a document
+func (vi *VectorIndex) IndexDocument(doc *types.Document) {
+	for name, value := range doc.Fields {
+		if vec, ok := value.(types.VectorValue); ok {
+			vi.IndexValue(doc.ID, name, vec.Value)
+		}
+	}
+}
+
+// RemoveDocument removes a document's vectors from every field
+func (vi *VectorIndex) RemoveDocument(docID string) {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	for _, fv := range vi.fields {
+		delete(fv.vectors, docID)
+		delete(fv.quantized, docID)
+	}
+}
+
+// DocIDs returns the IDs of the documents with a vector for a field, unordered
+func (vi *VectorIndex) DocIDs(fieldName string) []string {
+	vi.mu.RLock()
+	defer vi.mu.RUnlock()
+
+	fv, ok := vi.fields[fieldName]
+	if !ok {
+		return nil
+	}
+	docIDs := make([]string, 0, len(fv.vectors)+len(fv.quantized))
+	for id := range fv.vectors {
+		docIDs = append(docIDs, id)
+	}
+	for id := range fv.quantized {
+		docIDs = append(docIDs, id)
+	}
+	return docIDs
+}
+
+// Vector returns a document's indexed vector for a field
+// Quantized vectors are returned dequantized
+func (vi *VectorIndex) Vector(fieldName string, docID string) ([]float32, bool) {
+	vi.mu.RLock()
+	defer vi.mu.RUnlock()
+
+	fv, ok := vi.fields[fieldName]
+	if !ok {
+		return nil, false
+	}
+	return fv.get(docID)
+}
+
+// RescoreOversample is how many candidates per requested hit a quantized
+// field's approximate search keeps for rescoring at full precision
+const RescoreOversample = 4
+
+// Search returns the k documents whose vectors are most similar to the query,
+// best first (ties by ID)
+// Vectors of a different dimension than the query are skipped
+func (vi *VectorIndex) Search(fieldName string, query []float32, k int, metric Metric) ([]Hit, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("k must be > 0, got %d", k)
+	}
+	if len(query) == 0 {
+		return nil, fmt.Errorf("query vector is empty")
+	}
+	if metric == MetricCosine && magnitude(query) == 0 {
+		return nil, fmt.Errorf("cosine similarity is undefined for a zero query vector")
+	}
+
+	vi.mu.RLock()
+	defer vi.mu.RUnlock()
+
+	fv, ok := vi.fields[fieldName]
+	if !ok {
+		return nil, nil
+	}
+	if fv.quantization == types.QuantizationNone {
+		top := newTopHits(k)
+		for id, vec := range fv.vectors {
+			if len(vec) == len(query) {
+				top.add(Hit{DocID: id, Score: Similarity(metric, query, vec)})
+			}
+		}
+		return top.sorted(), nil
+	}
+
+	// Approximate scores pick the candidates, reusing one dequantization buffer
+	candidates := newTopHits(k * RescoreOversample)
+	buf := make([]float32, len(query))
+	for id, qv := range fv.quantized {
+		if len(qv.codes) == len(query) {
+			candidates.add(Hit{DocID: id, Score: Similarity(metric, query, qv.dequantize(buf))})
+		}
+	}
+	if vi.rescore == nil {
+		return candidates.best(k), nil
+	}
+
+	top := newTopHits(k)
+	for _, hit := range candidates.hits {
+		if vec, ok := vi.rescore(fieldName, hit.DocID); ok && len(vec) == len(query) {
+			hit.Score = Similarity(metric, query, vec)
+		}
+		top.add(hit)
+	}
+	return top.sorted(), nil
+}
+
+// topHits keeps the best hits seen in a min-heap, so the worst kept hit is
+// replaced first
+type topHits struct {
+	hits hitHeap
+	k    int
+}
+
+// newTopHits creates a collector for the best k hits
+func newTopHits(k int) *topHits {
+	return &topHits{hits: make(hitHeap, 0, k), k: k}
+}
+
+// add offers a hit
+func (t *topHits) add(hit Hit) {
+	if len(t.hits) < t.k {
+		heap.Push(&t.hits, hit)
+	} else if t.hits.less(t.hits[0], hit) {
+		t.hits[0] = hit
+		heap.Fix(&t.hits, 0)
+	}
+}
+
+// sorted returns the kept hits, best first
+func (t *topHits) sorted() []Hit {
+	hits := []Hit(t.hits)
+	sort.Slice(hits, func(i, j int) bool {
+		return t.hits.less(hits[j], hits[i])
+	})
+	return hits
+}
+
+// best returns the best n kept hits, best first
+func (t *topHits) best(n int) []Hit {
+	hits := t.sorted()
+	if len(hits) > n {
+		hits = hits[:n]
+	}
+	return hits
+}
+
+// hitHeap is a min-heap of hits by score
+type hitHeap []Hit
+
+// less orders hits from worst to best: lower scores first, then higher IDs
+func (h hitHeap) less(a, b Hit) bool {
+	if a.Score != b.Score {
+		return a.Score < b.Score
+	}
+	return a.DocID > b.DocID
+}
+
+func (h hitHeap) Len() int            { return len(h) }
+func (h hitHeap) Less(i, j int) bool  { return h.less(h[i], h[j]) }
+func (h hitHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *hitHeap) Push(x interface{}) { *h = append(*h, x.(Hit)) }
+func (h *hitHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	hit := old[n-1]
+	*h = old[:n-1]
+	return hit
+}
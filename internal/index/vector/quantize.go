@@ -0,0 +1,38 @@
+package vector
+
+import "math"
+
+// quantizedVector is a vector scaled into signed bytes
+// Each component is approximately code * scale
+type quantizedVector struct {
+	codes []int8
+	scale float32
+}
+
+// quantizeInt8 maps a vector's components symmetrically onto [-127, 127],
+// so its largest component by magnitude keeps full precision
+func quantizeInt8(vec []float32) quantizedVector {
+	var maxAbs float64
+	for _, x := range vec {
+		maxAbs = math.Max(maxAbs, math.Abs(float64(x)))
+	}
+
+	qv := quantizedVector{codes: make([]int8, len(vec))}
+	if maxAbs == 0 {
+		return qv
+	}
+	qv.scale = float32(maxAbs / 127)
+	for i, x := range vec {
+		qv.codes[i] = int8(math.Round(float64(x) / float64(qv.scale)))
+	}
+	return qv
+}
+
+// dequantize writes the approximate vector into buf, which must have the
+// vector's dimension, and returns it
+func (qv quantizedVector) dequantize(buf []float32) []float32 {
+	for i, c := range qv.codes {
+		buf[i] = float32(c) * qv.scale
+	}
+	return buf
+}
@@ -0,0 +1,198 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// grokPatterns are the named patterns grok expressions can use, a subset of
+// Elasticsearch's; a grok processor's pattern_definitions add to them
+var grokPatterns = map[string]string{
+	"WORD":              `\b\w+\b`,
+	"NOTSPACE":          `\S+`,
+	"SPACE":             `\s*`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+	"INT":               `[+-]?\d+`,
+	"POSINT":            `\b[1-9]\d*\b`,
+	"NONNEGINT":         `\b\d+\b`,
+	"NUMBER":            `[+-]?(?:\d+(?:\.\d*)?|\.\d+)`,
+	"BASE10NUM":         `[+-]?(?:\d+(?:\.\d*)?|\.\d+)`,
+	"USERNAME":          `[a-zA-Z0-9._-]+`,
+	"USER":              `%{USERNAME}`,
+	"EMAILADDRESS":      `[a-zA-Z0-9!#$%&'*+/=?^_{|}~.-]+@%{HOSTNAME}`,
+	"UUID":              `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"IPV4":              `(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)`,
+	"IPV6":              `(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}`,
+	"IP":                `(?:%{IPV4}|%{IPV6})`,
+	"HOSTNAME":          `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?\b`,
+	"IPORHOST":          `(?:%{IP}|%{HOSTNAME})`,
+	"HOSTPORT":          `%{IPORHOST}:%{POSINT}`,
+	"URIPATH":           `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+`,
+	"URIPARAM":          `\?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*`,
+	"URIPATHPARAM":      `%{URIPATH}(?:%{URIPARAM})?`,
+	"QUOTEDSTRING":      `"(?:[^"\\]|\\.)*"`,
+	"LOGLEVEL":          `(?i:trace|debug|info|notice|warn(?:ing)?|err(?:or)?|crit(?:ical)?|fatal|severe|emerg(?:ency)?)`,
+	"TIMESTAMP_ISO8601": `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}(?::\d{2}(?:[.,]\d+)?)?(?:Z|[+-]\d{2}:?\d{2})?`,
+	"HTTPDATE":          `\d{2}/[A-Za-z]{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}`,
+}
+
+// grokReference matches %{PATTERN}, %{PATTERN:field} and %{PATTERN:field:type}
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([\w.@\-]+))?(?::(int|long|float|double))?\}`)
+
+// grokCapture is a field captured by a grok expression's group
+type grokCapture struct {
+	field string
+	kind  string // Conversion of the text: int, long, float, double, or none
+}
+
+// grokExpression is a compiled grok pattern
+type grokExpression struct {
+	regexp   *regexp.Regexp
+	captures map[string]grokCapture // By group name
+}
+
+// grokProcessor extracts fields from a text field with the first of its
+// patterns that matches, e.g. "%{IP:client.ip} %{WORD:method} %{URIPATHPARAM:url}"
+// Patterns are Go regular expressions with %{PATTERN:field} references, and
+// are not anchored
+type grokProcessor struct {
+	commonOptions
+	Field              string            `json:"field"`
+	Patterns           []string          `json:"patterns"`
+	PatternDefinitions map[string]string `json:"pattern_definitions"`
+	IgnoreMissing      bool              `json:"ignore_missing"`
+	expressions        []*grokExpression
+}
+
+func newGrokProcessor(options json.RawMessage) (Processor, error) {
+	p := &grokProcessor{}
+	if err := decodeOptions(options, p); err != nil {
+		return nil, err
+	}
+	if p.Field == "" {
+		return nil, fmt.Errorf("[field] is required")
+	}
+	if len(p.Patterns) == 0 {
+		return nil, fmt.Errorf("[patterns] is required")
+	}
+	for _, pattern := range p.Patterns {
+		expression, err := compileGrok(pattern, p.PatternDefinitions)
+		if err != nil {
+			return nil, err
+		}
+		p.expressions = append(p.expressions, expression)
+	}
+	return p, nil
+}
+
+// compileGrok expands a grok pattern's references into a regular expression
+func compileGrok(pattern string, definitions map[string]string) (*grokExpression, error) {
+	expression := &grokExpression{captures: make(map[string]grokCapture)}
+	expanded, err := expression.expand(pattern, definitions, 0)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid grok pattern %q: %w", pattern, err)
+	}
+	expression.regexp = re
+	return expression, nil
+}
+
+// maxGrokDepth bounds nested pattern references, which could otherwise recurse forever
+const maxGrokDepth = 16
+
+// expand replaces the references in a pattern with their definitions, as
+// capturing groups for the references that name a field
+func (g *grokExpression) expand(pattern string, definitions map[string]string, depth int) (string, error) {
+	if depth > maxGrokDepth {
+		return "", fmt.Errorf("grok pattern references nest deeper than %d, is a pattern recursive?", maxGrokDepth)
+	}
+	var expandErr error
+	expanded := grokReference.ReplaceAllStringFunc(pattern, func(reference string) string {
+		if expandErr != nil {
+			return ""
+		}
+		parts := grokReference.FindStringSubmatch(reference)
+		name, field, kind := parts[1], parts[2], parts[3]
+		definition, ok := definitions[name]
+		if !ok {
+			definition, ok = grokPatterns[name]
+		}
+		if !ok {
+			expandErr = fmt.Errorf("unknown grok pattern [%s]", name)
+			return ""
+		}
+		inner, err := g.expand(definition, definitions, depth+1)
+		if err != nil {
+			expandErr = err
+			return ""
+		}
+		if field == "" {
+			return "(?:" + inner + ")"
+		}
+		// Field names can hold dots, which group names can't
+		group := "g" + strconv.Itoa(len(g.captures))
+		g.captures[group] = grokCapture{field: field, kind: kind}
+		return "(?P<" + group + ">" + inner + ")"
+	})
+	return expanded, expandErr
+}
+
+func (p *grokProcessor) Process(doc *Document) error {
+	value, ok := doc.Get(p.Field)
+	if !ok || value == nil {
+		if p.IgnoreMissing {
+			return nil
+		}
+		return missingField(p.Field)
+	}
+	text, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("field [%s] is not a string", p.Field)
+	}
+
+	for _, expression := range p.expressions {
+		match := expression.regexp.FindStringSubmatch(text)
+		if match == nil {
+			continue
+		}
+		for i, group := range expression.regexp.SubexpNames() {
+			capture, ok := expression.captures[group]
+			if !ok || i >= len(match) || match[i] == "" {
+				continue
+			}
+			converted, err := convertGrokValue(match[i], capture.kind)
+			if err != nil {
+				return fmt.Errorf("field [%s]: %w", capture.field, err)
+			}
+			doc.Set(capture.field, converted)
+		}
+		return nil
+	}
+	return fmt.Errorf("field [%s] doesn't match any of the grok patterns", p.Field)
+}
+
+// convertGrokValue converts captured text to the type a reference asks for
+func convertGrokValue(text string, kind string) (interface{}, error) {
+	switch kind {
+	case "int", "long":
+		n, err := strconv.ParseInt(strings.TrimPrefix(text, "+"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert %q to %s", text, kind)
+		}
+		return float64(n), nil // As JSON numbers decode
+	case "float", "double":
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert %q to %s", text, kind)
+		}
+		return f, nil
+	}
+	return text, nil
+}
@@ -0,0 +1,209 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Document is a document going through a pipeline: its raw source and ID
+// Processors address source fields by dot path ("user.name"); "_id" is the ID
+type Document struct {
+	ID     string
+	Source map[string]interface{}
+}
+
+// Processor is one step of a pipeline
+type Processor interface {
+	Process(doc *Document) error
+}
+
+// Pipeline is an ordered list of processors applied to documents before they
+// are indexed, like an Elasticsearch ingest pipeline:
+//
+//	{"description": "...", "processors": [{"lowercase": {"field": "tag"}}, ...]}
+type Pipeline struct {
+	Description string
+	steps       []step
+	config      json.RawMessage
+}
+
+// step is a configured processor with the options every processor takes
+type step struct {
+	kind          string
+	tag           string
+	ignoreFailure bool
+	processor     Processor
+}
+
+// ProcessorError is returned by Run for a processor that failed on a document
+type ProcessorError struct {
+	Type string
+	Tag  string
+	Err  error
+}
+
+func (e *ProcessorError) Error() string {
+	if e.Tag != "" {
+		return fmt.Sprintf("processor [%s] (tag %s): %v", e.Type, e.Tag, e.Err)
+	}
+	return fmt.Sprintf("processor [%s]: %v", e.Type, e.Err)
+}
+
+func (e *ProcessorError) Unwrap() error {
+	return e.Err
+}
+
+// processorFactories build processors from their JSON options, by type
+var processorFactories = map[string]func(options json.RawMessage) (Processor, error){
+	"set":       newSetProcessor,
+	"rename":    newRenameProcessor,
+	"remove":    newRemoveProcessor,
+	"lowercase": newLowercaseProcessor,
+	"date":      newDateProcessor,
+	"grok":      newGrokProcessor,
+	"script":    newScriptProcessor,
+}
+
+// ParsePipeline reads a pipeline definition
+// Each processor is an object with one key, its type (set, rename, remove,
+// lowercase, date, grok or script), holding its options; every type also
+// takes "tag", reported in errors, and "ignore_failure"
+func ParsePipeline(data []byte) (*Pipeline, error) {
+	var body struct {
+		Description string                       `json:"description"`
+		Processors  []map[string]json.RawMessage `json:"processors"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("invalid pipeline: %w", err)
+	}
+	if len(body.Processors) == 0 {
+		return nil, fmt.Errorf("a pipeline needs at least one processor")
+	}
+
+	p := &Pipeline{Description: body.Description, config: append(json.RawMessage(nil), data...)}
+	for i, definition := range body.Processors {
+		if len(definition) != 1 {
+			return nil, fmt.Errorf("processor %d must have exactly one type, e.g. {\"set\": {...}}", i)
+		}
+		for kind, options := range definition {
+			factory, ok := processorFactories[kind]
+			if !ok {
+				return nil, fmt.Errorf("processor %d: unknown type [%s] (supported: %s)", i, kind, strings.Join(processorTypes(), ", "))
+			}
+			var common commonOptions
+			if err := json.Unmarshal(options, &common); err != nil {
+				return nil, fmt.Errorf("processor %d [%s]: %w", i, kind, err)
+			}
+			processor, err := factory(options)
+			if err != nil {
+				return nil, fmt.Errorf("processor %d [%s]: %w", i, kind, err)
+			}
+			p.steps = append(p.steps, step{kind: kind, tag: common.Tag, ignoreFailure: common.IgnoreFailure, processor: processor})
+		}
+	}
+	return p, nil
+}
+
+// processorTypes returns the supported processor types, sorted
+func processorTypes() []string {
+	kinds := make([]string, 0, len(processorFactories))
+	for kind := range processorFactories {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Run applies the processors to a document in order, stopping at the first
+// that fails unless it ignores failures
+// A failed run may leave the document partly processed
+func (p *Pipeline) Run(doc *Document) error {
+	if doc.Source == nil {
+		doc.Source = make(map[string]interface{})
+	}
+	for _, s := range p.steps {
+		if err := s.processor.Process(doc); err != nil && !s.ignoreFailure {
+			return &ProcessorError{Type: s.kind, Tag: s.tag, Err: err}
+		}
+	}
+	return nil
+}
+
+// MarshalJSON returns the definition the pipeline was parsed from
+func (p *Pipeline) MarshalJSON() ([]byte, error) {
+	return p.config, nil
+}
+
+// Get reads a field by dot path
+// A field whose own name contains dots is found too
+func (d *Document) Get(path string) (interface{}, bool) {
+	if path == "_id" {
+		return d.ID, d.ID != ""
+	}
+	return getPath(d.Source, path)
+}
+
+// Set writes a field by dot path, creating objects on the way
+// Setting "_id" changes the document's ID to the value as a string
+func (d *Document) Set(path string, value interface{}) {
+	if path == "_id" {
+		d.ID = fmt.Sprint(value)
+		return
+	}
+	setPath(d.Source, path, value)
+}
+
+// Remove deletes a field by dot path and reports whether it was there
+func (d *Document) Remove(path string) bool {
+	if path == "_id" {
+		return false // A document keeps an ID
+	}
+	return removePath(d.Source, path)
+}
+
+func getPath(fields map[string]interface{}, path string) (interface{}, bool) {
+	if value, ok := fields[path]; ok {
+		return value, true
+	}
+	head, rest, nested := strings.Cut(path, ".")
+	if !nested {
+		return nil, false
+	}
+	object, ok := fields[head].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return getPath(object, rest)
+}
+
+func setPath(fields map[string]interface{}, path string, value interface{}) {
+	head, rest, nested := strings.Cut(path, ".")
+	if !nested {
+		fields[path] = value
+		return
+	}
+	object, ok := fields[head].(map[string]interface{})
+	if !ok {
+		object = make(map[string]interface{})
+		fields[head] = object
+	}
+	setPath(object, rest, value)
+}
+
+func removePath(fields map[string]interface{}, path string) bool {
+	if _, ok := fields[path]; ok {
+		delete(fields, path)
+		return true
+	}
+	head, rest, nested := strings.Cut(path, ".")
+	if !nested {
+		return false
+	}
+	object, ok := fields[head].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	return removePath(object, rest)
+}
@@ -0,0 +1,338 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"nano-elastic/internal/types"
+)
+
+// commonOptions are the options every processor takes, read by ParsePipeline
+type commonOptions struct {
+	Tag           string `json:"tag"`
+	IgnoreFailure bool   `json:"ignore_failure"`
+}
+
+// decodeOptions reads a processor's options, rejecting unknown ones
+func decodeOptions(data json.RawMessage, options interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(options)
+}
+
+// missingField is the error of a processor reading a field the document lacks
+func missingField(field string) error {
+	return fmt.Errorf("field [%s] not present", field)
+}
+
+// setProcessor sets a field to a value; string values may embed other fields
+// as {{field}} templates, e.g. "{{first}} {{last}}"
+type setProcessor struct {
+	commonOptions
+	Field    string      `json:"field"`
+	Value    interface{} `json:"value"`
+	Override *bool       `json:"override"` // Replace an existing value (default true)
+}
+
+func newSetProcessor(options json.RawMessage) (Processor, error) {
+	p := &setProcessor{}
+	if err := decodeOptions(options, p); err != nil {
+		return nil, err
+	}
+	if p.Field == "" {
+		return nil, fmt.Errorf("[field] is required")
+	}
+	if p.Value == nil {
+		return nil, fmt.Errorf("[value] is required")
+	}
+	return p, nil
+}
+
+// templateField matches {{field}} and {{{field}}} in set values
+var templateField = regexp.MustCompile(`\{\{\{?\s*([^{}\s]+)\s*\}?\}\}`)
+
+func (p *setProcessor) Process(doc *Document) error {
+	if p.Override != nil && !*p.Override {
+		if _, exists := doc.Get(p.Field); exists {
+			return nil
+		}
+	}
+	value := p.Value
+	if s, ok := value.(string); ok && strings.Contains(s, "{{") {
+		value = templateField.ReplaceAllStringFunc(s, func(match string) string {
+			v, ok := doc.Get(templateField.FindStringSubmatch(match)[1])
+			if !ok {
+				return ""
+			}
+			return fmt.Sprint(v)
+		})
+	}
+	doc.Set(p.Field, value)
+	return nil
+}
+
+// renameProcessor moves a field to a new name
+type renameProcessor struct {
+	commonOptions
+	Field         string `json:"field"`
+	TargetField   string `json:"target_field"`
+	IgnoreMissing bool   `json:"ignore_missing"`
+}
+
+func newRenameProcessor(options json.RawMessage) (Processor, error) {
+	p := &renameProcessor{}
+	if err := decodeOptions(options, p); err != nil {
+		return nil, err
+	}
+	if p.Field == "" || p.TargetField == "" {
+		return nil, fmt.Errorf("[field] and [target_field] are required")
+	}
+	return p, nil
+}
+
+func (p *renameProcessor) Process(doc *Document) error {
+	value, ok := doc.Get(p.Field)
+	if !ok {
+		if p.IgnoreMissing {
+			return nil
+		}
+		return missingField(p.Field)
+	}
+	if _, exists := doc.Get(p.TargetField); exists {
+		return fmt.Errorf("field [%s] already exists", p.TargetField)
+	}
+	doc.Remove(p.Field)
+	doc.Set(p.TargetField, value)
+	return nil
+}
+
+// removeProcessor deletes one or more fields
+type removeProcessor struct {
+	commonOptions
+	Field         interface{} `json:"field"` // A field or a list of fields
+	IgnoreMissing bool        `json:"ignore_missing"`
+	fields        []string
+}
+
+func newRemoveProcessor(options json.RawMessage) (Processor, error) {
+	p := &removeProcessor{}
+	if err := decodeOptions(options, p); err != nil {
+		return nil, err
+	}
+	switch field := p.Field.(type) {
+	case string:
+		p.fields = []string{field}
+	case []interface{}:
+		for _, f := range field {
+			name, ok := f.(string)
+			if !ok {
+				return nil, fmt.Errorf("[field] must be a string or a list of strings")
+			}
+			p.fields = append(p.fields, name)
+		}
+	}
+	if len(p.fields) == 0 {
+		return nil, fmt.Errorf("[field] is required")
+	}
+	return p, nil
+}
+
+func (p *removeProcessor) Process(doc *Document) error {
+	for _, field := range p.fields {
+		if !doc.Remove(field) && !p.IgnoreMissing {
+			return missingField(field)
+		}
+	}
+	return nil
+}
+
+// lowercaseProcessor lowercases a string field, or each string of a list
+type lowercaseProcessor struct {
+	commonOptions
+	Field         string `json:"field"`
+	TargetField   string `json:"target_field"` // Default the field itself
+	IgnoreMissing bool   `json:"ignore_missing"`
+}
+
+func newLowercaseProcessor(options json.RawMessage) (Processor, error) {
+	p := &lowercaseProcessor{}
+	if err := decodeOptions(options, p); err != nil {
+		return nil, err
+	}
+	if p.Field == "" {
+		return nil, fmt.Errorf("[field] is required")
+	}
+	if p.TargetField == "" {
+		p.TargetField = p.Field
+	}
+	return p, nil
+}
+
+func (p *lowercaseProcessor) Process(doc *Document) error {
+	value, ok := doc.Get(p.Field)
+	if !ok || value == nil {
+		if p.IgnoreMissing {
+			return nil
+		}
+		return missingField(p.Field)
+	}
+	switch v := value.(type) {
+	case string:
+		doc.Set(p.TargetField, strings.ToLower(v))
+	case []interface{}:
+		lowered := make([]interface{}, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("field [%s] holds a non-string value %v", p.Field, item)
+			}
+			lowered[i] = strings.ToLower(s)
+		}
+		doc.Set(p.TargetField, lowered)
+	default:
+		return fmt.Errorf("field [%s] is not a string", p.Field)
+	}
+	return nil
+}
+
+// DefaultDateOutputFormat is the layout the date processor writes dates in
+const DefaultDateOutputFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// dateProcessor parses a date with the first matching format and writes it in
+// a standard format, e.g. for a date field declared without formats
+// Formats are those of date mappings: Go time layouts, epoch_millis or epoch_second
+type dateProcessor struct {
+	commonOptions
+	Field         string   `json:"field"`
+	TargetField   string   `json:"target_field"` // Default "@timestamp"
+	Formats       []string `json:"formats"`
+	Timezone      string   `json:"timezone"`      // Of dates without a zone (default UTC)
+	OutputFormat  string   `json:"output_format"` // Go time layout (default DefaultDateOutputFormat)
+	IgnoreMissing bool     `json:"ignore_missing"`
+	location      *time.Location
+}
+
+func newDateProcessor(options json.RawMessage) (Processor, error) {
+	p := &dateProcessor{}
+	if err := decodeOptions(options, p); err != nil {
+		return nil, err
+	}
+	if p.Field == "" {
+		return nil, fmt.Errorf("[field] is required")
+	}
+	if len(p.Formats) == 0 {
+		return nil, fmt.Errorf("[formats] is required")
+	}
+	if p.TargetField == "" {
+		p.TargetField = "@timestamp"
+	}
+	if p.OutputFormat == "" {
+		p.OutputFormat = DefaultDateOutputFormat
+	}
+	p.location = time.UTC
+	if p.Timezone != "" {
+		location, err := time.LoadLocation(p.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid [timezone]: %w", err)
+		}
+		p.location = location
+	}
+	return p, nil
+}
+
+func (p *dateProcessor) Process(doc *Document) error {
+	value, ok := doc.Get(p.Field)
+	if !ok || value == nil {
+		if p.IgnoreMissing {
+			return nil
+		}
+		return missingField(p.Field)
+	}
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Errorf("field [%s] is not a date string or number", p.Field)
+	}
+
+	for _, format := range p.Formats {
+		var t time.Time
+		var err error
+		switch format {
+		case types.DateFormatEpochMillis, types.DateFormatEpochSecond:
+			t, err = types.ParseDate(s, []string{format})
+		default:
+			t, err = time.ParseInLocation(format, strings.TrimSpace(s), p.location)
+		}
+		if err == nil {
+			doc.Set(p.TargetField, t.In(p.location).Format(p.OutputFormat))
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot parse date %q of field [%s] with formats %v", s, p.Field, p.Formats)
+}
+
+// ScriptFunc is a script hook: Go code run by script processors, registered
+// by name with RegisterScript
+type ScriptFunc func(doc *Document, params map[string]interface{}) error
+
+var (
+	scriptsMu sync.RWMutex
+	scripts   = make(map[string]ScriptFunc)
+)
+
+// RegisterScript makes a script hook available to script processors as
+// {"script": {"id": name, "params": {...}}}, replacing any of the same name
+// Pipelines look scripts up when they run, so they may name a script before
+// it is registered
+func RegisterScript(name string, fn ScriptFunc) {
+	scriptsMu.Lock()
+	defer scriptsMu.Unlock()
+	scripts[name] = fn
+}
+
+// LookupScript returns the script hook registered under a name
+func LookupScript(name string) (ScriptFunc, error) {
+	scriptsMu.RLock()
+	defer scriptsMu.RUnlock()
+	fn, ok := scripts[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown script [%s]", name)
+	}
+	return fn, nil
+}
+
+// scriptProcessor runs a registered script hook
+type scriptProcessor struct {
+	commonOptions
+	ID     string                 `json:"id"`
+	Params map[string]interface{} `json:"params"`
+}
+
+func newScriptProcessor(options json.RawMessage) (Processor, error) {
+	p := &scriptProcessor{}
+	if err := decodeOptions(options, p); err != nil {
+		return nil, err
+	}
+	if p.ID == "" {
+		return nil, fmt.Errorf("[id] is required: the name of a registered script")
+	}
+	return p, nil
+}
+
+func (p *scriptProcessor) Process(doc *Document) error {
+	fn, err := LookupScript(p.ID)
+	if err != nil {
+		return err
+	}
+	return fn(doc, p.Params)
+}
@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Logger writes leveled, structured log records
+// args are alternating keys and values, as with log/slog
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+
+	// With returns a Logger adding args to every record
+	With(args ...interface{}) Logger
+
+	// DebugEnabled reports whether debug records are written, so callers can
+	// skip building them on hot paths
+	DebugEnabled() bool
+}
+
+// slogLogger adapts a *slog.Logger to Logger
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns a Logger writing to a *slog.Logger
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return slogLogger{logger: logger}
+}
+
+// Default returns a Logger writing to slog.Default, which goes to the standard
+// log package unless the program set another default
+func Default() Logger {
+	return NewSlogLogger(slog.Default())
+}
+
+func (l slogLogger) Debug(msg string, args ...interface{}) { l.logger.Debug(msg, args...) }
+func (l slogLogger) Info(msg string, args ...interface{})  { l.logger.Info(msg, args...) }
+func (l slogLogger) Warn(msg string, args ...interface{})  { l.logger.Warn(msg, args...) }
+func (l slogLogger) Error(msg string, args ...interface{}) { l.logger.Error(msg, args...) }
+
+func (l slogLogger) With(args ...interface{}) Logger {
+	return slogLogger{logger: l.logger.With(args...)}
+}
+
+func (l slogLogger) DebugEnabled() bool {
+	return l.logger.Enabled(context.Background(), slog.LevelDebug)
+}
+
+// discardLogger drops every record
+type discardLogger struct{}
+
+// Discard returns a Logger that writes nothing
+func Discard() Logger {
+	return discardLogger{}
+}
+
+func (discardLogger) Debug(msg string, args ...interface{}) {}
+func (discardLogger) Info(msg string, args ...interface{})  {}
+func (discardLogger) Warn(msg string, args ...interface{})  {}
+func (discardLogger) Error(msg string, args ...interface{}) {}
+func (discardLogger) With(args ...interface{}) Logger       { return discardLogger{} }
+func (discardLogger) DebugEnabled() bool                    { return false }
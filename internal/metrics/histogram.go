@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencyBuckets are upper bounds, in seconds, for request latencies
+var DefaultLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram counts observations in buckets with fixed upper bounds
+// It is updated without locking, so a snapshot taken during an observation may
+// count it in its bucket before its sum
+type Histogram struct {
+	bounds  []float64
+	counts  []atomic.Uint64 // Per bucket, not cumulative; the last one is +Inf
+	sumBits atomic.Uint64   // math.Float64bits of the sum
+}
+
+// HistogramSnapshot is the state of a Histogram, with cumulative bucket
+// counts as the exposition format expects
+type HistogramSnapshot struct {
+	Bounds []float64
+	Counts []uint64 // Observations <= each bound
+	Count  uint64
+	Sum    float64
+}
+
+// NewHistogram creates a histogram with the given sorted bucket upper bounds
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]atomic.Uint64, len(bounds)+1),
+	}
+}
+
+// Observe records one value
+func (h *Histogram) Observe(v float64) {
+	h.counts[sort.SearchFloat64s(h.bounds, v)].Add(1)
+	for {
+		old := h.sumBits.Load()
+		if h.sumBits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// ObserveDuration records the time since start, in seconds
+func (h *Histogram) ObserveDuration(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// Snapshot returns the current counts and sum
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	snapshot := HistogramSnapshot{
+		Bounds: h.bounds,
+		Counts: make([]uint64, len(h.bounds)),
+		Sum:    math.Float64frombits(h.sumBits.Load()),
+	}
+	for i := range h.counts {
+		snapshot.Count += h.counts[i].Load()
+		if i < len(h.bounds) {
+			snapshot.Counts[i] = snapshot.Count
+		}
+	}
+	return snapshot
+}
@@ -0,0 +1,196 @@
+// Package metrics exports counters, gauges and histograms to Prometheus
+// The module has no dependencies, so instead of the client library
+// (github.com/prometheus/client_golang) this package has the small part of it
+// the server needs: Collector plays the role of prometheus.Collector, without
+// Describe since every family is described by its HELP and TYPE lines on each
+// scrape, and Registry writes the text exposition format (version 0.0.4)
+// Prometheus scrapes
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Type is the kind of a metric family, as written in its TYPE line
+type Type string
+
+const (
+	CounterType   Type = "counter"
+	GaugeType     Type = "gauge"
+	HistogramType Type = "histogram"
+)
+
+// ContentType is the content type of the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Label is a name/value pair distinguishing the samples of a family
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is one value of a family; histograms write several samples, told
+// apart by the suffix added to the family name (_bucket, _sum, _count)
+type Sample struct {
+	Suffix string
+	Labels []Label
+	Value  float64
+}
+
+// Family is a named metric with its samples
+type Family struct {
+	Name    string
+	Help    string
+	Type    Type
+	Samples []Sample
+}
+
+// NewFamily creates a family without samples
+func NewFamily(name string, help string, typ Type) *Family {
+	return &Family{Name: name, Help: help, Type: typ}
+}
+
+// Add appends a sample
+func (f *Family) Add(value float64, labels ...Label) {
+	f.Samples = append(f.Samples, Sample{Labels: labels, Value: value})
+}
+
+// AddHistogram appends the bucket, sum and count samples of a histogram
+func (f *Family) AddHistogram(h HistogramSnapshot, labels ...Label) {
+	for i, bound := range h.Bounds {
+		f.Samples = append(f.Samples, Sample{
+			Suffix: "_bucket",
+			Labels: withLabel(labels, "le", formatValue(bound)),
+			Value:  float64(h.Counts[i]),
+		})
+	}
+	f.Samples = append(f.Samples,
+		Sample{Suffix: "_bucket", Labels: withLabel(labels, "le", "+Inf"), Value: float64(h.Count)},
+		Sample{Suffix: "_sum", Labels: labels, Value: h.Sum},
+		Sample{Suffix: "_count", Labels: labels, Value: float64(h.Count)},
+	)
+}
+
+// withLabel returns labels with one more label appended, without modifying labels
+func withLabel(labels []Label, name string, value string) []Label {
+	return append(labels[:len(labels):len(labels)], Label{Name: name, Value: value})
+}
+
+// Collector is implemented by anything that reports metrics, like a
+// prometheus.Collector: Collect is called on every scrape and passes the
+// current value of each family to emit
+type Collector interface {
+	Collect(emit func(*Family))
+}
+
+// CollectorFunc adapts a function to a Collector
+type CollectorFunc func(emit func(*Family))
+
+// Collect calls f
+func (f CollectorFunc) Collect(emit func(*Family)) {
+	f(emit)
+}
+
+// Registry gathers the metrics of its collectors and serves them in the
+// Prometheus text exposition format
+type Registry struct {
+	collectors []Collector
+	mu         sync.RWMutex
+}
+
+// NewRegistry creates a registry without collectors
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a collector
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+// Gather collects every family, sorted by name
+// Samples of families with the same name, from several collectors, are merged
+func (r *Registry) Gather() []*Family {
+	r.mu.RLock()
+	collectors := r.collectors
+	r.mu.RUnlock()
+
+	byName := make(map[string]*Family)
+	var families []*Family
+	for _, c := range collectors {
+		c.Collect(func(f *Family) {
+			if existing, ok := byName[f.Name]; ok {
+				existing.Samples = append(existing.Samples, f.Samples...)
+				return
+			}
+			merged := *f
+			byName[f.Name] = &merged
+			families = append(families, &merged)
+		})
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].Name < families[j].Name
+	})
+	return families
+}
+
+// WriteText writes the gathered families in the text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range r.Gather() {
+		if f.Help != "" {
+			bw.WriteString("# HELP " + f.Name + " " + helpEscaper.Replace(f.Help) + "\n")
+		}
+		bw.WriteString("# TYPE " + f.Name + " " + string(f.Type) + "\n")
+		for _, s := range f.Samples {
+			bw.WriteString(f.Name + s.Suffix)
+			if len(s.Labels) > 0 {
+				bw.WriteByte('{')
+				for i, label := range s.Labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					bw.WriteString(label.Name + `="` + labelEscaper.Replace(label.Value) + `"`)
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteString(" " + formatValue(s.Value) + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP writes the metrics in the text exposition format, so a Registry
+// can be mounted as a /metrics handler
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteText(w)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// formatValue writes a sample value the way Prometheus parses it
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
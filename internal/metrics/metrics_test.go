@@ -0,0 +1,266 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parsedFamily is a family read back from the text exposition format
+type parsedFamily struct {
+	help    string
+	typ     string
+	samples []parsedSample
+}
+
+type parsedSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// parseText parses the text exposition format, failing on anything
+// Prometheus would reject: samples before their TYPE line, a family
+// described twice, or sample names not belonging to their family's type
+func parseText(t *testing.T, r io.Reader) map[string]*parsedFamily {
+	t.Helper()
+	families := make(map[string]*parsedFamily)
+	var current string
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		switch {
+		case strings.HasPrefix(text, "# HELP "):
+			name, help, _ := strings.Cut(strings.TrimPrefix(text, "# HELP "), " ")
+			if _, ok := families[name]; ok {
+				t.Fatalf("line %d: HELP of %s after its samples or repeated", line, name)
+			}
+			families[name] = &parsedFamily{help: strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(help)}
+			current = name
+		case strings.HasPrefix(text, "# TYPE "):
+			name, typ, _ := strings.Cut(strings.TrimPrefix(text, "# TYPE "), " ")
+			f, ok := families[name]
+			if !ok {
+				f = &parsedFamily{}
+				families[name] = f
+			} else if name != current || f.typ != "" {
+				t.Fatalf("line %d: TYPE of %s repeated or not after its HELP", line, name)
+			}
+			switch typ {
+			case "counter", "gauge", "histogram", "summary", "untyped":
+			default:
+				t.Fatalf("line %d: unknown type %q", line, typ)
+			}
+			f.typ = typ
+			current = name
+		case strings.HasPrefix(text, "#"):
+		default:
+			sample := parseSample(t, line, text)
+			f := families[current]
+			if f == nil || f.typ == "" {
+				t.Fatalf("line %d: sample %s before its TYPE line", line, sample.name)
+			}
+			suffix, ok := strings.CutPrefix(sample.name, current)
+			valid := map[string][]string{"histogram": {"_bucket", "_sum", "_count"}}[f.typ]
+			if !ok || (suffix != "" || len(valid) > 0) && !contains(valid, suffix) {
+				t.Fatalf("line %d: sample %s doesn't belong to %s family %s", line, sample.name, f.typ, current)
+			}
+			f.samples = append(f.samples, sample)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return families
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSample parses a line like name{a="1",b="x\"y"} 42
+func parseSample(t *testing.T, line int, text string) parsedSample {
+	t.Helper()
+	s := parsedSample{labels: make(map[string]string)}
+	end := strings.IndexAny(text, "{ ")
+	if end <= 0 {
+		t.Fatalf("line %d: malformed sample %q", line, text)
+	}
+	s.name, text = text[:end], text[end:]
+	if strings.HasPrefix(text, "{") {
+		text = text[1:]
+		for !strings.HasPrefix(text, "}") {
+			name, rest, ok := strings.Cut(text, `="`)
+			if !ok {
+				t.Fatalf("line %d: malformed label in %q", line, text)
+			}
+			var value strings.Builder
+			i := 0
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+					switch rest[i] {
+					case 'n':
+						value.WriteByte('\n')
+					case '\\', '"':
+						value.WriteByte(rest[i])
+					default:
+						t.Fatalf("line %d: invalid escape \\%c", line, rest[i])
+					}
+					continue
+				}
+				value.WriteByte(rest[i])
+			}
+			if i == len(rest) {
+				t.Fatalf("line %d: unterminated label value", line)
+			}
+			s.labels[strings.TrimPrefix(name, ",")] = value.String()
+			text = rest[i+1:]
+		}
+		text = text[1:]
+	}
+	value, ok := strings.CutPrefix(text, " ")
+	if !ok {
+		t.Fatalf("line %d: no value in %q", line, text)
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		t.Fatalf("line %d: invalid value %q: %v", line, value, err)
+	}
+	s.value = v
+	return s
+}
+
+func TestRegistryText(t *testing.T) {
+	latency := NewHistogram([]float64{0.01, 0.1, 1})
+	observations := []float64{0.005, 0.01, 0.05, 0.05, 0.5, 3, 7}
+	for _, v := range observations {
+		latency.Observe(v)
+	}
+
+	registry := NewRegistry()
+	registry.Register(CollectorFunc(func(emit func(*Family)) {
+		docs := NewFamily("test_docs", "Live documents.", GaugeType)
+		docs.Add(3, Label{"index", "books"})
+		docs.Add(5, Label{"index", `odd "name"` + "\n"})
+		emit(docs)
+
+		h := NewFamily("test_search_duration_seconds", "Latency of searches.\nIn seconds.", HistogramType)
+		h.AddHistogram(latency.Snapshot(), Label{"index", "books"})
+		emit(h)
+	}))
+	// A second collector adds samples to a family the first one reports
+	registry.Register(CollectorFunc(func(emit func(*Family)) {
+		docs := NewFamily("test_docs", "Live documents.", GaugeType)
+		docs.Add(8, Label{"index", "music"})
+		emit(docs)
+		total := NewFamily("test_requests_total", "Requests.", CounterType)
+		total.Add(42)
+		emit(total)
+	}))
+
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("content type = %q, want %q", ct, ContentType)
+	}
+	families := parseText(t, rec.Body)
+
+	want := map[string]struct{ help, typ string }{
+		"test_docs":                    {"Live documents.", "gauge"},
+		"test_search_duration_seconds": {"Latency of searches.\nIn seconds.", "histogram"},
+		"test_requests_total":          {"Requests.", "counter"},
+	}
+	if len(families) != len(want) {
+		t.Errorf("got %d families, want %d", len(families), len(want))
+	}
+	for name, w := range want {
+		f := families[name]
+		if f == nil {
+			t.Fatalf("family %s missing", name)
+		}
+		if f.help != w.help || f.typ != w.typ {
+			t.Errorf("%s: HELP %q TYPE %s, want HELP %q TYPE %s", name, f.help, f.typ, w.help, w.typ)
+		}
+	}
+
+	docs := make(map[string]float64)
+	for _, s := range families["test_docs"].samples {
+		docs[s.labels["index"]] = s.value
+	}
+	if fmt.Sprint(docs) != fmt.Sprint(map[string]float64{"books": 3, `odd "name"` + "\n": 5, "music": 8}) {
+		t.Errorf("test_docs samples = %v", docs)
+	}
+
+	// Buckets are cumulative, end with le="+Inf", and agree with _count
+	var buckets []parsedSample
+	var sum, count *parsedSample
+	for i, s := range families["test_search_duration_seconds"].samples {
+		if s.labels["index"] != "books" {
+			t.Errorf("sample %s lost its labels: %v", s.name, s.labels)
+		}
+		switch s.name {
+		case "test_search_duration_seconds_bucket":
+			buckets = append(buckets, s)
+		case "test_search_duration_seconds_sum":
+			sum = &families["test_search_duration_seconds"].samples[i]
+		case "test_search_duration_seconds_count":
+			count = &families["test_search_duration_seconds"].samples[i]
+		}
+	}
+	wantBuckets := []struct {
+		le    string
+		count float64
+	}{{"0.01", 2}, {"0.1", 4}, {"1", 5}, {"+Inf", 7}}
+	if len(buckets) != len(wantBuckets) {
+		t.Fatalf("got %d buckets, want %d", len(buckets), len(wantBuckets))
+	}
+	for i, b := range buckets {
+		if b.labels["le"] != wantBuckets[i].le || b.value != wantBuckets[i].count {
+			t.Errorf("bucket %d: le=%s %v, want le=%s %v", i, b.labels["le"], b.value, wantBuckets[i].le, wantBuckets[i].count)
+		}
+		if i > 0 && b.value < buckets[i-1].value {
+			t.Errorf("bucket le=%s isn't cumulative", b.labels["le"])
+		}
+	}
+	if count == nil || count.value != float64(len(observations)) || count.value != buckets[len(buckets)-1].value {
+		t.Errorf("_count = %v, want %d, the +Inf bucket", count, len(observations))
+	}
+	var wantSum float64
+	for _, v := range observations {
+		wantSum += v
+	}
+	if sum == nil || math.Abs(sum.value-wantSum) > 1e-9 {
+		t.Errorf("_sum = %v, want %v", sum, wantSum)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{0, "0"},
+		{42, "42"},
+		{0.0025, "0.0025"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, tc := range tests {
+		if got := formatValue(tc.value); got != tc.want {
+			t.Errorf("formatValue(%v) = %q, want %q", tc.value, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Aggregation summarizes the documents matching a search, e.g. counting
+// documents per keyword value
+// Aggregations read doc values, so they never load stored documents
+type Aggregation interface {
+	// Aggregate computes the aggregation over the given documents
+	Aggregate(r *Reader, docIDs []string) (AggregationResult, error)
+}
+
+// AggregationResult is the computed value of an aggregation
+// Results encode to JSON in Elasticsearch's response format
+type AggregationResult interface {
+	// Merge combines this result with the same aggregation's result over
+	// other documents, e.g. from another index behind an alias
+	Merge(other AggregationResult) (AggregationResult, error)
+}
+
+// aggregateAll computes named aggregations over the same documents
+func aggregateAll(r *Reader, aggs map[string]Aggregation, docIDs []string) (map[string]AggregationResult, error) {
+	if len(aggs) == 0 {
+		return nil, nil
+	}
+	results := make(map[string]AggregationResult, len(aggs))
+	for name, agg := range aggs {
+		result, err := agg.Aggregate(r, docIDs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute aggregation [%s]: %w", name, err)
+		}
+		results[name] = result
+	}
+	return results, nil
+}
+
+// mergeAggregations merges two sets of named results of the same aggregations
+func mergeAggregations(a, b map[string]AggregationResult) (map[string]AggregationResult, error) {
+	if a == nil {
+		return b, nil
+	}
+	merged := make(map[string]AggregationResult, len(a))
+	for name, result := range a {
+		other, ok := b[name]
+		if !ok {
+			merged[name] = result
+			continue
+		}
+		m, err := result.Merge(other)
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge aggregation [%s]: %w", name, err)
+		}
+		merged[name] = m
+	}
+	return merged, nil
+}
+
+// Bucket is one group of documents of a bucket aggregation, with the results
+// of its sub-aggregations over the group
+type Bucket struct {
+	Key          interface{}
+	KeyAsString  string // Set when the key has a readable form, e.g. dates
+	DocCount     int
+	Aggregations map[string]AggregationResult
+}
+
+// MarshalJSON encodes the bucket with its sub-aggregations inline
+func (b Bucket) MarshalJSON() ([]byte, error) {
+	out := make(map[string]interface{}, len(b.Aggregations)+3)
+	for name, result := range b.Aggregations {
+		out[name] = result
+	}
+	out["key"] = b.Key
+	if b.KeyAsString != "" {
+		out["key_as_string"] = b.KeyAsString
+	}
+	out["doc_count"] = b.DocCount
+	return json.Marshal(out)
+}
+
+// merge adds another bucket with the same key
+func (b Bucket) merge(other Bucket) (Bucket, error) {
+	subs, err := mergeAggregations(b.Aggregations, other.Aggregations)
+	if err != nil {
+		return Bucket{}, err
+	}
+	b.DocCount += other.DocCount
+	b.Aggregations = subs
+	return b, nil
+}
+
+// aggregationParsers decode the body of each aggregation type
+// subs holds the parsed sub-aggregations, for bucket aggregations
+// "count" is accepted as another name for value_count
+var aggregationParsers = map[string]func(body json.RawMessage, subs map[string]Aggregation) (Aggregation, error){
+	"terms":          parseTermsAggregationDSL,
+	"filter":         parseFilterAggregationDSL,
+	"histogram":      parseHistogramDSL,
+	"date_histogram": parseDateHistogramDSL,
+	"min":            metricAggregationParser(MetricMin),
+	"max":            metricAggregationParser(MetricMax),
+	"avg":            metricAggregationParser(MetricAvg),
+	"sum":            metricAggregationParser(MetricSum),
+	"value_count":    metricAggregationParser(MetricValueCount),
+	"count":          metricAggregationParser(MetricValueCount),
+	"stats":          metricAggregationParser(MetricStats),
+	"cardinality":    parseCardinalityDSL,
+}
+
+// parseAggregationsDSL decodes named aggregations:
+//
+//	{"by_author": {"terms": {"field": "author"}, "aggs": {...}}}
+func parseAggregationsDSL(data []byte) (map[string]Aggregation, error) {
+	var named map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &named); err != nil {
+		return nil, fmt.Errorf("[aggs] must be an object of named aggregations: %w", err)
+	}
+
+	aggs := make(map[string]Aggregation, len(named))
+	for name, def := range named {
+		var subs map[string]Aggregation
+		var types []string
+		for key, body := range def {
+			if key == "aggs" || key == "aggregations" {
+				var err error
+				if subs, err = parseAggregationsDSL(body); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			types = append(types, key)
+		}
+		if len(types) != 1 {
+			sort.Strings(types)
+			return nil, fmt.Errorf("[aggs] aggregation [%s] must have exactly one type, got %v", name, types)
+		}
+
+		parse, ok := aggregationParsers[types[0]]
+		if !ok {
+			return nil, fmt.Errorf("[aggs] unknown aggregation type [%s] in [%s]", types[0], name)
+		}
+		agg, err := parse(def[types[0]], subs)
+		if err != nil {
+			return nil, fmt.Errorf("[aggs] [%s]: %w", name, err)
+		}
+		aggs[name] = agg
+	}
+	return aggs, nil
+}
@@ -0,0 +1,144 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"nano-elastic/internal/index/docvalues"
+	"nano-elastic/internal/index/hll"
+	"nano-elastic/internal/types"
+)
+
+// CardinalityAggregation estimates the number of distinct values of a field
+// among the matching documents, like Elasticsearch's cardinality aggregation
+// Up to PrecisionThreshold distinct values are counted exactly; beyond, a
+// HyperLogLog++ sketch (see hll.Sketch) estimates the count within about 1%
+// A sketch is built per segment and the segments' sketches merged, so a
+// segment whose documents all match reuses the sketch stored with it (see
+// SegmentDocs.Sketches) or the one an earlier search built
+type CardinalityAggregation struct {
+	Field              string
+	PrecisionThreshold int // Distinct values counted exactly (default hll.DefaultThreshold, capped at hll.MaxThreshold)
+}
+
+// Aggregate implements Aggregation
+func (a *CardinalityAggregation) Aggregate(r *Reader, docIDs []string) (AggregationResult, error) {
+	if fieldType, ok := r.fieldType(a.Field); ok && fieldType == types.FieldTypeText {
+		return nil, fmt.Errorf("[cardinality] text field %s has no doc values; aggregate a keyword field instead", a.Field)
+	}
+	if a.PrecisionThreshold < 0 {
+		return nil, fmt.Errorf("[cardinality] precision_threshold must be >= 0, got %d", a.PrecisionThreshold)
+	}
+
+	column := r.DocValues.Column(a.Field)
+	sketch := hll.New(a.PrecisionThreshold)
+	if len(r.Segments) == 0 || r.Ordinals == nil {
+		addSketchValues(sketch, column, docIDs)
+		return &CardinalityResult{Sketch: sketch}, nil
+	}
+
+	matched := r.Ordinals.Bitmap(docIDs)
+	rest := matched
+	key := a.Field + "/" + strconv.Itoa(a.PrecisionThreshold)
+	for i, seg := range r.Segments {
+		if err := r.checkContextEvery(i); err != nil {
+			return nil, err
+		}
+		docs := seg.Docs.And(matched)
+		if docs.IsEmpty() {
+			continue
+		}
+		rest = rest.AndNot(seg.Docs)
+		if docs.Cardinality() == seg.Docs.Cardinality() {
+			sketch.Merge(seg.sketch(key, func() *hll.Sketch {
+				whole := hll.New(a.PrecisionThreshold)
+				if stored, ok := seg.Sketches[a.Field]; ok && stored.Covers(a.PrecisionThreshold) {
+					whole.Merge(stored)
+				} else {
+					addSketchValues(whole, column, r.Ordinals.IDs(seg.Docs))
+				}
+				return whole
+			}))
+			continue
+		}
+		addSketchValues(sketch, column, r.Ordinals.IDs(docs))
+	}
+	addSketchValues(sketch, column, r.Ordinals.IDs(rest)) // Indexed since the segments were partitioned
+	return &CardinalityResult{Sketch: sketch}, nil
+}
+
+// sketch returns the segment's cached sketch for key, building it on first use
+// The sketch is shared by every search, so callers must not modify it
+func (s *SegmentDocs) sketch(key string, build func() *hll.Sketch) *hll.Sketch {
+	s.sketchesMu.Lock()
+	defer s.sketchesMu.Unlock()
+
+	if sketch, ok := s.sketches[key]; ok {
+		return sketch
+	}
+	if s.sketches == nil {
+		s.sketches = make(map[string]*hll.Sketch)
+	}
+	sketch := build()
+	s.sketches[key] = sketch
+	return sketch
+}
+
+// addSketchValues records the documents' values of a column in a sketch
+func addSketchValues(sketch *hll.Sketch, column map[string]docvalues.Value, docIDs []string) {
+	for _, id := range docIDs {
+		if value, ok := column[id]; ok {
+			sketch.AddHash(value.Hash())
+		}
+	}
+}
+
+// CardinalityResult is the result of a CardinalityAggregation
+// It keeps the merged sketch, so results from several indexes merge into the
+// estimate of their union rather than the sum of their counts
+type CardinalityResult struct {
+	Sketch *hll.Sketch
+}
+
+// Value returns the estimated number of distinct values
+func (c *CardinalityResult) Value() uint64 {
+	return c.Sketch.Estimate()
+}
+
+// Merge implements AggregationResult
+func (c *CardinalityResult) Merge(other AggregationResult) (AggregationResult, error) {
+	o, ok := other.(*CardinalityResult)
+	if !ok {
+		return nil, fmt.Errorf("cannot merge cardinality result with %T", other)
+	}
+	merged := c.Sketch.Clone()
+	merged.Merge(o.Sketch)
+	return &CardinalityResult{Sketch: merged}, nil
+}
+
+// MarshalJSON encodes the estimate like Elasticsearch: {"value": 42}
+func (c *CardinalityResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{"value": c.Value()})
+}
+
+// parseCardinalityDSL decodes {"field": "author", "precision_threshold": 100}
+func parseCardinalityDSL(body json.RawMessage, subs map[string]Aggregation) (Aggregation, error) {
+	if len(subs) > 0 {
+		return nil, fmt.Errorf("[cardinality] aggregations cannot have sub-aggregations")
+	}
+	var params struct {
+		Field              string `json:"field"`
+		PrecisionThreshold int    `json:"precision_threshold"`
+	}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, fmt.Errorf("[cardinality] invalid parameters: %w", err)
+	}
+	if params.Field == "" {
+		return nil, fmt.Errorf("[cardinality] requires [field]")
+	}
+	if params.PrecisionThreshold < 0 {
+		return nil, fmt.Errorf("[cardinality] precision_threshold must be >= 0, got %d", params.PrecisionThreshold)
+	}
+	return &CardinalityAggregation{Field: params.Field, PrecisionThreshold: params.PrecisionThreshold}, nil
+}
@@ -0,0 +1,401 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"nano-elastic/internal/index/docvalues"
+	"nano-elastic/internal/index/numeric"
+	"nano-elastic/internal/types"
+)
+
+// MaxBuckets caps the buckets of one histogram, counting filled empty buckets,
+// like Elasticsearch's search.max_buckets
+const MaxBuckets = 65536
+
+// CalendarInterval is a date histogram interval that follows the calendar,
+// so months and years have their real lengths. Buckets are in UTC
+type CalendarInterval string
+
+const (
+	CalendarMinute  CalendarInterval = "minute"
+	CalendarHour    CalendarInterval = "hour"
+	CalendarDay     CalendarInterval = "day"
+	CalendarWeek    CalendarInterval = "week" // Weeks start on Monday
+	CalendarMonth   CalendarInterval = "month"
+	CalendarQuarter CalendarInterval = "quarter"
+	CalendarYear    CalendarInterval = "year"
+)
+
+// calendarIntervals maps calendar interval names and their "1x" forms to date math units
+// Quarters are handled as three months
+var calendarIntervals = map[string]CalendarInterval{
+	"minute": CalendarMinute, "1m": CalendarMinute,
+	"hour": CalendarHour, "1h": CalendarHour,
+	"day": CalendarDay, "1d": CalendarDay,
+	"week": CalendarWeek, "1w": CalendarWeek,
+	"month": CalendarMonth, "1M": CalendarMonth,
+	"quarter": CalendarQuarter, "1q": CalendarQuarter,
+	"year": CalendarYear, "1y": CalendarYear,
+}
+
+// unit returns the date math unit of the interval
+func (c CalendarInterval) unit() (byte, error) {
+	switch c {
+	case CalendarMinute:
+		return 'm', nil
+	case CalendarHour:
+		return 'h', nil
+	case CalendarDay:
+		return 'd', nil
+	case CalendarWeek:
+		return 'w', nil
+	case CalendarMonth, CalendarQuarter:
+		return 'M', nil
+	case CalendarYear:
+		return 'y', nil
+	}
+	return 0, fmt.Errorf("unknown calendar interval %q", c)
+}
+
+// HistogramAggregation groups the matching documents into buckets of a numeric
+// or date field, keyed by each bucket's lower bound and returned in key order
+// Date values are epoch milliseconds, so fixed intervals on dates are in milliseconds
+type HistogramAggregation struct {
+	Field            string
+	Interval         float64          // Fixed bucket width; ignored when CalendarInterval is set
+	CalendarInterval CalendarInterval // Date fields only
+	Offset           float64          // Shifts bucket bounds (milliseconds for dates)
+	MinDocCount      int              // 0 (the default) also returns the empty buckets between the first and last
+	Aggregations     map[string]Aggregation
+}
+
+// Aggregate implements Aggregation
+func (a *HistogramAggregation) Aggregate(r *Reader, docIDs []string) (AggregationResult, error) {
+	fieldType, declared := r.fieldType(a.Field)
+	if declared && fieldType != types.FieldTypeNumeric && fieldType != types.FieldTypeDate {
+		return nil, fmt.Errorf("[histogram] field %s is %s, not numeric or date", a.Field, fieldType)
+	}
+	if a.CalendarInterval != "" {
+		if declared && fieldType != types.FieldTypeDate {
+			return nil, fmt.Errorf("[histogram] calendar intervals need a date field, %s is %s", a.Field, fieldType)
+		}
+		if _, err := a.CalendarInterval.unit(); err != nil {
+			return nil, err
+		}
+	} else if !(a.Interval > 0) || math.IsInf(a.Interval, 0) {
+		return nil, fmt.Errorf("[histogram] interval must be > 0, got %v", a.Interval)
+	}
+	if a.MinDocCount < 0 {
+		return nil, fmt.Errorf("[histogram] min_doc_count must be >= 0, got %d", a.MinDocCount)
+	}
+
+	// Group the documents by bucket key
+	column := r.DocValues.Column(a.Field)
+	groups := make(map[float64][]string)
+	dates := fieldType == types.FieldTypeDate
+	for _, id := range docIDs {
+		value, ok := column[id]
+		if !ok {
+			continue
+		}
+		if value.Kind != docvalues.KindNumeric && value.Kind != docvalues.KindDate {
+			return nil, fmt.Errorf("[histogram] field %s is not numeric", a.Field)
+		}
+		dates = value.Kind == docvalues.KindDate
+		key, err := a.key(value.Num)
+		if err != nil {
+			return nil, err
+		}
+		groups[key] = append(groups[key], id)
+	}
+
+	empty, err := aggregateAll(r, a.Aggregations, nil)
+	if err != nil {
+		return nil, err
+	}
+	result := &HistogramResult{MinDocCount: a.MinDocCount, agg: a, dates: dates, empty: empty}
+	for key, ids := range groups {
+		subs, err := aggregateAll(r, a.Aggregations, ids)
+		if err != nil {
+			return nil, err
+		}
+		result.Buckets = append(result.Buckets, result.bucket(key, len(ids), subs))
+		result.keys = append(result.keys, key)
+	}
+	result.sort()
+	if err := result.checkBuckets(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// key returns the lower bound of the bucket holding a value
+func (a *HistogramAggregation) key(v float64) (float64, error) {
+	if a.CalendarInterval == "" {
+		return math.Floor((v-a.Offset)/a.Interval)*a.Interval + a.Offset, nil
+	}
+
+	unit, _ := a.CalendarInterval.unit()
+	t, err := numeric.TruncateDate(numeric.MillisToDate(v-a.Offset), unit)
+	if err != nil {
+		return 0, err
+	}
+	if a.CalendarInterval == CalendarQuarter {
+		t = t.AddDate(0, -int(t.Month()-1)%3, 0)
+	}
+	return numeric.DateToMillis(t) + a.Offset, nil
+}
+
+// next returns the key of the bucket after the one starting at key
+func (a *HistogramAggregation) next(key float64) float64 {
+	if a.CalendarInterval == "" {
+		n := math.Round((key - a.Offset) / a.Interval)
+		return (n+1)*a.Interval + a.Offset
+	}
+
+	unit, _ := a.CalendarInterval.unit()
+	amount := 1
+	if a.CalendarInterval == CalendarQuarter {
+		amount = 3
+	}
+	t, _ := numeric.AddDateUnits(numeric.MillisToDate(key-a.Offset), amount, unit)
+	return numeric.DateToMillis(t) + a.Offset
+}
+
+// HistogramResult is the result of a HistogramAggregation
+// It holds the non-empty buckets; empty ones are filled in when encoded
+type HistogramResult struct {
+	Buckets     []Bucket // Non-empty buckets in key order
+	MinDocCount int
+
+	agg   *HistogramAggregation
+	keys  []float64
+	dates bool
+	empty map[string]AggregationResult // Sub-aggregation results for empty buckets
+}
+
+// bucket creates a bucket for a key
+func (h *HistogramResult) bucket(key float64, docCount int, subs map[string]AggregationResult) Bucket {
+	if h.dates {
+		ms := int64(key)
+		return Bucket{Key: ms, KeyAsString: time.UnixMilli(ms).UTC().Format(time.RFC3339Nano), DocCount: docCount, Aggregations: subs}
+	}
+	return Bucket{Key: key, DocCount: docCount, Aggregations: subs}
+}
+
+// All returns the reported buckets: every bucket between the first and last
+// when MinDocCount is 0, otherwise those with at least MinDocCount documents
+func (h *HistogramResult) All() []Bucket {
+	if h.MinDocCount > 0 {
+		var buckets []Bucket
+		for _, b := range h.Buckets {
+			if b.DocCount >= h.MinDocCount {
+				buckets = append(buckets, b)
+			}
+		}
+		return buckets
+	}
+
+	var buckets []Bucket
+	for i, b := range h.Buckets {
+		if i > 0 {
+			for key := h.agg.next(h.keys[i-1]); key < h.keys[i]; key = h.agg.next(key) {
+				buckets = append(buckets, h.bucket(key, 0, h.empty))
+			}
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+// checkBuckets fails when filling the empty buckets would exceed MaxBuckets
+func (h *HistogramResult) checkBuckets() error {
+	if h.MinDocCount > 0 || len(h.keys) == 0 {
+		return nil
+	}
+	count := 1
+	last := h.keys[len(h.keys)-1]
+	for key := h.keys[0]; key < last; key = h.agg.next(key) {
+		if count++; count > MaxBuckets {
+			return fmt.Errorf("[histogram] too many buckets: more than %d between %v and %v; use a larger interval", MaxBuckets, h.keys[0], last)
+		}
+	}
+	return nil
+}
+
+// sort orders the buckets by key
+func (h *HistogramResult) sort() {
+	order := make([]int, len(h.keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return h.keys[order[i]] < h.keys[order[j]]
+	})
+
+	buckets := make([]Bucket, len(order))
+	keys := make([]float64, len(order))
+	for i, j := range order {
+		buckets[i], keys[i] = h.Buckets[j], h.keys[j]
+	}
+	h.Buckets, h.keys = buckets, keys
+}
+
+// Merge implements AggregationResult
+func (h *HistogramResult) Merge(other AggregationResult) (AggregationResult, error) {
+	o, ok := other.(*HistogramResult)
+	if !ok {
+		return nil, fmt.Errorf("cannot merge histogram result with %T", other)
+	}
+
+	merged := &HistogramResult{MinDocCount: h.MinDocCount, agg: h.agg, dates: h.dates || o.dates, empty: h.empty}
+	index := make(map[float64]int, len(h.keys))
+	for _, src := range []*HistogramResult{h, o} {
+		for i, key := range src.keys {
+			if j, ok := index[key]; ok {
+				b, err := merged.Buckets[j].merge(src.Buckets[i])
+				if err != nil {
+					return nil, err
+				}
+				merged.Buckets[j] = b
+				continue
+			}
+			index[key] = len(merged.Buckets)
+			merged.Buckets = append(merged.Buckets, src.Buckets[i])
+			merged.keys = append(merged.keys, key)
+		}
+	}
+	merged.sort()
+	if err := merged.checkBuckets(); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// MarshalJSON encodes the reported buckets
+func (h *HistogramResult) MarshalJSON() ([]byte, error) {
+	buckets := h.All()
+	if buckets == nil {
+		buckets = []Bucket{}
+	}
+	return json.Marshal(map[string]interface{}{"buckets": buckets})
+}
+
+// parseHistogramDSL decodes {"field": "price", "interval": 50, "offset": 0, "min_doc_count": 0}
+func parseHistogramDSL(body json.RawMessage, subs map[string]Aggregation) (Aggregation, error) {
+	var params struct {
+		Field       string  `json:"field"`
+		Interval    float64 `json:"interval"`
+		Offset      float64 `json:"offset"`
+		MinDocCount int     `json:"min_doc_count"`
+	}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, fmt.Errorf("[histogram] invalid parameters: %w", err)
+	}
+	if params.Field == "" {
+		return nil, fmt.Errorf("[histogram] requires [field]")
+	}
+	if !(params.Interval > 0) {
+		return nil, fmt.Errorf("[histogram] [interval] must be > 0")
+	}
+	if params.MinDocCount < 0 {
+		return nil, fmt.Errorf("[histogram] [min_doc_count] must be >= 0, got %d", params.MinDocCount)
+	}
+	return &HistogramAggregation{
+		Field:        params.Field,
+		Interval:     params.Interval,
+		Offset:       params.Offset,
+		MinDocCount:  params.MinDocCount,
+		Aggregations: subs,
+	}, nil
+}
+
+// parseDateHistogramDSL decodes
+//
+//	{"field": "published", "calendar_interval": "month", "offset": "+6h", "min_doc_count": 0}
+//
+// fixed_interval takes a duration such as "90m" or "7d" instead
+func parseDateHistogramDSL(body json.RawMessage, subs map[string]Aggregation) (Aggregation, error) {
+	var params struct {
+		Field            string `json:"field"`
+		CalendarInterval string `json:"calendar_interval"`
+		FixedInterval    string `json:"fixed_interval"`
+		Offset           string `json:"offset"`
+		MinDocCount      int    `json:"min_doc_count"`
+	}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, fmt.Errorf("[date_histogram] invalid parameters: %w", err)
+	}
+	if params.Field == "" {
+		return nil, fmt.Errorf("[date_histogram] requires [field]")
+	}
+	if params.MinDocCount < 0 {
+		return nil, fmt.Errorf("[date_histogram] [min_doc_count] must be >= 0, got %d", params.MinDocCount)
+	}
+
+	agg := &HistogramAggregation{Field: params.Field, MinDocCount: params.MinDocCount, Aggregations: subs}
+	switch {
+	case params.CalendarInterval != "" && params.FixedInterval != "":
+		return nil, fmt.Errorf("[date_histogram] takes [calendar_interval] or [fixed_interval], not both")
+	case params.CalendarInterval != "":
+		interval, ok := calendarIntervals[params.CalendarInterval]
+		if !ok {
+			return nil, fmt.Errorf("[date_histogram] unknown [calendar_interval] %q", params.CalendarInterval)
+		}
+		agg.CalendarInterval = interval
+	case params.FixedInterval != "":
+		ms, err := parseDurationMillis(params.FixedInterval)
+		if err != nil || ms <= 0 {
+			return nil, fmt.Errorf("[date_histogram] invalid [fixed_interval] %q", params.FixedInterval)
+		}
+		agg.Interval = ms
+	default:
+		return nil, fmt.Errorf("[date_histogram] requires [calendar_interval] or [fixed_interval]")
+	}
+
+	if params.Offset != "" {
+		offset := strings.TrimPrefix(params.Offset, "+")
+		sign := 1.0
+		if strings.HasPrefix(offset, "-") {
+			sign, offset = -1, offset[1:]
+		}
+		ms, err := parseDurationMillis(offset)
+		if err != nil {
+			return nil, fmt.Errorf("[date_histogram] invalid [offset] %q", params.Offset)
+		}
+		agg.Offset = sign * ms
+	}
+	return agg, nil
+}
+
+// durationUnits maps duration suffixes to milliseconds, longest suffixes first
+var durationUnits = []struct {
+	suffix string
+	millis float64
+}{
+	{"ms", 1},
+	{"s", 1000},
+	{"m", 60 * 1000},
+	{"h", 60 * 60 * 1000},
+	{"d", 24 * 60 * 60 * 1000},
+}
+
+// parseDurationMillis parses a fixed duration such as "30s", "90m" or "7d"
+func parseDurationMillis(input string) (float64, error) {
+	for _, unit := range durationUnits {
+		if strings.HasSuffix(input, unit.suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(input, unit.suffix), 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid duration %q", input)
+			}
+			return n * unit.millis, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid duration %q", input)
+}
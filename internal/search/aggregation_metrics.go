@@ -0,0 +1,237 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"nano-elastic/internal/index/docvalues"
+	"nano-elastic/internal/types"
+)
+
+// MetricType is a statistic computed by a MetricAggregation
+type MetricType string
+
+const (
+	MetricMin        MetricType = "min"
+	MetricMax        MetricType = "max"
+	MetricAvg        MetricType = "avg"
+	MetricSum        MetricType = "sum"
+	MetricValueCount MetricType = "value_count" // Documents with a value; works on any field with doc values
+	MetricStats      MetricType = "stats"       // count, min, max, avg and sum together
+)
+
+// MetricAggregation computes a statistic over a numeric or date field of the
+// matching documents; documents without a value are skipped
+type MetricAggregation struct {
+	Field  string
+	Metric MetricType
+}
+
+// Aggregate implements Aggregation
+func (a *MetricAggregation) Aggregate(r *Reader, docIDs []string) (AggregationResult, error) {
+	switch a.Metric {
+	case MetricMin, MetricMax, MetricAvg, MetricSum, MetricValueCount, MetricStats:
+	default:
+		return nil, fmt.Errorf("unknown metric %q", a.Metric)
+	}
+	if fieldType, ok := r.fieldType(a.Field); ok {
+		numeric := fieldType == types.FieldTypeNumeric || fieldType == types.FieldTypeDate
+		if fieldType == types.FieldTypeText || (!numeric && a.Metric != MetricValueCount) {
+			return nil, fmt.Errorf("[%s] field %s is %s, not numeric", a.Metric, a.Field, fieldType)
+		}
+	}
+
+	result := &StatsResult{Metric: a.Metric}
+	column := r.DocValues.Column(a.Field)
+	for _, id := range docIDs {
+		value, ok := column[id]
+		if !ok {
+			continue
+		}
+		if a.Metric != MetricValueCount && value.Kind != docvalues.KindNumeric && value.Kind != docvalues.KindDate {
+			return nil, fmt.Errorf("[%s] field %s is not numeric", a.Metric, a.Field)
+		}
+		result.add(value)
+	}
+	return result, nil
+}
+
+// StatsResult is the result of a MetricAggregation
+// It keeps every statistic, so results merge exactly, and encodes the requested one
+type StatsResult struct {
+	Metric MetricType
+	Count  int
+	Sum    float64
+	Min    float64 // +Inf when Count is 0
+	Max    float64 // -Inf when Count is 0
+	Dates  bool    // Whether the values are dates (epoch millis)
+}
+
+// add accumulates one value
+func (s *StatsResult) add(value docvalues.Value) {
+	if s.Count == 0 {
+		s.Min, s.Max = math.Inf(1), math.Inf(-1)
+	}
+	s.Count++
+	s.Sum += value.Num
+	s.Min = math.Min(s.Min, value.Num)
+	s.Max = math.Max(s.Max, value.Num)
+	s.Dates = value.Kind == docvalues.KindDate
+}
+
+// Avg returns the mean value, or NaN when there are no values
+func (s *StatsResult) Avg() float64 {
+	if s.Count == 0 {
+		return math.NaN()
+	}
+	return s.Sum / float64(s.Count)
+}
+
+// Merge implements AggregationResult
+func (s *StatsResult) Merge(other AggregationResult) (AggregationResult, error) {
+	o, ok := other.(*StatsResult)
+	if !ok {
+		return nil, fmt.Errorf("cannot merge %s result with %T", s.Metric, other)
+	}
+	if o.Count == 0 {
+		return s, nil
+	}
+	if s.Count == 0 {
+		return o, nil
+	}
+	return &StatsResult{
+		Metric: s.Metric,
+		Count:  s.Count + o.Count,
+		Sum:    s.Sum + o.Sum,
+		Min:    math.Min(s.Min, o.Min),
+		Max:    math.Max(s.Max, o.Max),
+		Dates:  s.Dates || o.Dates,
+	}, nil
+}
+
+// MarshalJSON encodes the metric like Elasticsearch: {"value": ...} for a
+// single statistic, with null when no document has a value
+func (s *StatsResult) MarshalJSON() ([]byte, error) {
+	switch s.Metric {
+	case MetricValueCount:
+		return json.Marshal(map[string]interface{}{"value": s.Count})
+	case MetricSum:
+		return json.Marshal(map[string]interface{}{"value": s.Sum})
+	case MetricStats:
+		out := map[string]interface{}{"count": s.Count, "sum": s.Sum}
+		for name, v := range map[string]float64{"min": s.Min, "max": s.Max, "avg": s.Avg()} {
+			s.putValue(out, name, v)
+		}
+		return json.Marshal(out)
+	}
+
+	v := s.Avg()
+	switch s.Metric {
+	case MetricMin:
+		v = s.Min
+	case MetricMax:
+		v = s.Max
+	}
+	out := make(map[string]interface{}, 2)
+	s.putValue(out, "value", v)
+	return json.Marshal(out)
+}
+
+// putValue adds a statistic, null when there are no values, with its date
+// form for date fields
+func (s *StatsResult) putValue(out map[string]interface{}, name string, v float64) {
+	if s.Count == 0 {
+		out[name] = nil
+		return
+	}
+	out[name] = v
+	if s.Dates {
+		out[name+"_as_string"] = time.UnixMilli(int64(v)).UTC().Format(time.RFC3339Nano)
+	}
+}
+
+// FilterAggregation narrows the documents to those also matching a query,
+// e.g. to compute a metric over part of the results
+type FilterAggregation struct {
+	Filter       Query
+	Aggregations map[string]Aggregation
+}
+
+// Aggregate implements Aggregation
+func (a *FilterAggregation) Aggregate(r *Reader, docIDs []string) (AggregationResult, error) {
+	matches, err := FilterDocs(r, a.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("[filter] %w", err)
+	}
+	var ids []string
+	for _, id := range docIDs {
+		if ord, ok := r.Ordinals.Ordinal(id); ok && matches.Contains(ord) {
+			ids = append(ids, id)
+		}
+	}
+
+	subs, err := aggregateAll(r, a.Aggregations, ids)
+	if err != nil {
+		return nil, err
+	}
+	return &FilterResult{DocCount: len(ids), Aggregations: subs}, nil
+}
+
+// FilterResult is the result of a FilterAggregation
+type FilterResult struct {
+	DocCount     int
+	Aggregations map[string]AggregationResult
+}
+
+// Merge implements AggregationResult
+func (f *FilterResult) Merge(other AggregationResult) (AggregationResult, error) {
+	o, ok := other.(*FilterResult)
+	if !ok {
+		return nil, fmt.Errorf("cannot merge filter result with %T", other)
+	}
+	subs, err := mergeAggregations(f.Aggregations, o.Aggregations)
+	if err != nil {
+		return nil, err
+	}
+	return &FilterResult{DocCount: f.DocCount + o.DocCount, Aggregations: subs}, nil
+}
+
+// MarshalJSON encodes the document count with the sub-aggregations inline
+func (f *FilterResult) MarshalJSON() ([]byte, error) {
+	out := make(map[string]interface{}, len(f.Aggregations)+1)
+	for name, result := range f.Aggregations {
+		out[name] = result
+	}
+	out["doc_count"] = f.DocCount
+	return json.Marshal(out)
+}
+
+// metricAggregationParser returns the parser for {"field": "rating"} of one metric
+func metricAggregationParser(metric MetricType) func(body json.RawMessage, subs map[string]Aggregation) (Aggregation, error) {
+	return func(body json.RawMessage, subs map[string]Aggregation) (Aggregation, error) {
+		if len(subs) > 0 {
+			return nil, fmt.Errorf("[%s] aggregations cannot have sub-aggregations", metric)
+		}
+		var params struct {
+			Field string `json:"field"`
+		}
+		if err := json.Unmarshal(body, &params); err != nil {
+			return nil, fmt.Errorf("[%s] invalid parameters: %w", metric, err)
+		}
+		if params.Field == "" {
+			return nil, fmt.Errorf("[%s] requires [field]", metric)
+		}
+		return &MetricAggregation{Field: params.Field, Metric: metric}, nil
+	}
+}
+
+// parseFilterAggregationDSL decodes a filter aggregation, whose body is a query
+func parseFilterAggregationDSL(body json.RawMessage, subs map[string]Aggregation) (Aggregation, error) {
+	q, err := ParseQueryDSL(body)
+	if err != nil {
+		return nil, fmt.Errorf("[filter] %w", err)
+	}
+	return &FilterAggregation{Filter: q, Aggregations: subs}, nil
+}
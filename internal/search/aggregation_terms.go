@@ -0,0 +1,250 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"nano-elastic/internal/index/docvalues"
+	"nano-elastic/internal/types"
+)
+
+// DefaultTermsSize is the number of buckets a terms aggregation returns by default
+const DefaultTermsSize = 10
+
+// TermsOrder is the order of terms buckets
+type TermsOrder string
+
+const (
+	TermsOrderCountDesc TermsOrder = "_count:desc" // Most documents first (the default)
+	TermsOrderCountAsc  TermsOrder = "_count:asc"
+	TermsOrderKeyAsc    TermsOrder = "_key:asc"
+	TermsOrderKeyDesc   TermsOrder = "_key:desc"
+)
+
+// TermsAggregation groups the matching documents by the values of a field and
+// returns the top Size values with their document counts
+// Keyword fields are the usual target; numeric, date and boolean fields work
+// too. Text fields have no doc values and are rejected
+type TermsAggregation struct {
+	Field        string
+	Size         int        // Buckets to return (default DefaultTermsSize)
+	MinDocCount  int        // Minimum documents per bucket (values below 1 use 1)
+	Order        TermsOrder // Default TermsOrderCountDesc; ties are broken by key
+	Aggregations map[string]Aggregation
+}
+
+// Aggregate implements Aggregation
+func (a *TermsAggregation) Aggregate(r *Reader, docIDs []string) (AggregationResult, error) {
+	if fieldType, ok := r.fieldType(a.Field); ok && fieldType == types.FieldTypeText {
+		return nil, fmt.Errorf("[terms] text field %s has no doc values; aggregate a keyword field instead", a.Field)
+	}
+	if a.Size < 0 {
+		return nil, fmt.Errorf("[terms] size must be >= 0, got %d", a.Size)
+	}
+	if _, err := termsLess(a.Order); err != nil {
+		return nil, err
+	}
+
+	// Group the documents by value
+	column := r.DocValues.Column(a.Field)
+	groups := make(map[docvalues.Value][]string)
+	for _, id := range docIDs {
+		if value, ok := column[id]; ok {
+			groups[value] = append(groups[value], id)
+		}
+	}
+
+	result := &TermsResult{Size: a.Size, MinDocCount: a.MinDocCount, Order: a.Order}
+	for value, ids := range groups {
+		bucket := termsBucket(value)
+		bucket.DocCount = len(ids)
+		subs, err := aggregateAll(r, a.Aggregations, ids)
+		if err != nil {
+			return nil, err
+		}
+		bucket.Aggregations = subs
+		result.Buckets = append(result.Buckets, bucket)
+		result.values = append(result.values, value)
+	}
+	result.sort()
+	return result, nil
+}
+
+// termsBucket creates an empty bucket keyed by a doc value
+func termsBucket(value docvalues.Value) Bucket {
+	switch value.Kind {
+	case docvalues.KindDate:
+		ms := int64(value.Num)
+		return Bucket{Key: ms, KeyAsString: time.UnixMilli(ms).UTC().Format(time.RFC3339Nano)}
+	case docvalues.KindBoolean:
+		return Bucket{Key: int64(value.Num), KeyAsString: strconv.FormatBool(value.Num != 0)}
+	}
+	return Bucket{Key: value.Interface()}
+}
+
+// TermsResult is the result of a TermsAggregation
+// It holds every bucket, so results from several indexes merge exactly; only
+// the top Size buckets are encoded
+type TermsResult struct {
+	Buckets     []Bucket // Every bucket, in order
+	Size        int
+	MinDocCount int
+	Order       TermsOrder
+
+	values []docvalues.Value // Key of each bucket, for ordering and merging
+}
+
+// Top returns the buckets that are reported, and the number of documents in the rest
+func (t *TermsResult) Top() ([]Bucket, int) {
+	minDocCount := t.MinDocCount
+	if minDocCount <= 0 {
+		minDocCount = 1
+	}
+	size := t.Size
+	if size == 0 {
+		size = DefaultTermsSize
+	}
+
+	var top []Bucket
+	other := 0
+	for _, b := range t.Buckets {
+		if b.DocCount < minDocCount {
+			continue
+		}
+		if len(top) < size {
+			top = append(top, b)
+		} else {
+			other += b.DocCount
+		}
+	}
+	return top, other
+}
+
+// MarshalJSON encodes the top buckets
+func (t *TermsResult) MarshalJSON() ([]byte, error) {
+	top, other := t.Top()
+	if top == nil {
+		top = []Bucket{}
+	}
+	return json.Marshal(map[string]interface{}{
+		"doc_count_error_upper_bound": 0,
+		"sum_other_doc_count":         other,
+		"buckets":                     top,
+	})
+}
+
+// Merge implements AggregationResult
+func (t *TermsResult) Merge(other AggregationResult) (AggregationResult, error) {
+	o, ok := other.(*TermsResult)
+	if !ok {
+		return nil, fmt.Errorf("cannot merge terms result with %T", other)
+	}
+
+	merged := &TermsResult{Size: t.Size, MinDocCount: t.MinDocCount, Order: t.Order}
+	index := make(map[docvalues.Value]int, len(t.values))
+	for _, src := range []*TermsResult{t, o} {
+		for i, value := range src.values {
+			if j, ok := index[value]; ok {
+				b, err := merged.Buckets[j].merge(src.Buckets[i])
+				if err != nil {
+					return nil, err
+				}
+				merged.Buckets[j] = b
+				continue
+			}
+			index[value] = len(merged.Buckets)
+			merged.Buckets = append(merged.Buckets, src.Buckets[i])
+			merged.values = append(merged.values, value)
+		}
+	}
+	merged.sort()
+	return merged, nil
+}
+
+// sort orders the buckets by the result's order
+func (t *TermsResult) sort() {
+	less, _ := termsLess(t.Order)
+	order := make([]int, len(t.Buckets))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		return less(t.Buckets[a].DocCount, t.values[a], t.Buckets[b].DocCount, t.values[b])
+	})
+
+	buckets := make([]Bucket, len(order))
+	values := make([]docvalues.Value, len(order))
+	for i, j := range order {
+		buckets[i], values[i] = t.Buckets[j], t.values[j]
+	}
+	t.Buckets, t.values = buckets, values
+}
+
+// termsLess returns the comparison for a bucket order
+func termsLess(order TermsOrder) (func(countA int, keyA docvalues.Value, countB int, keyB docvalues.Value) bool, error) {
+	switch order {
+	case "", TermsOrderCountDesc, TermsOrderCountAsc:
+		desc := order != TermsOrderCountAsc
+		return func(countA int, keyA docvalues.Value, countB int, keyB docvalues.Value) bool {
+			if countA != countB {
+				return (countA > countB) == desc
+			}
+			return keyA.Compare(keyB) < 0
+		}, nil
+	case TermsOrderKeyAsc, TermsOrderKeyDesc:
+		desc := order == TermsOrderKeyDesc
+		return func(countA int, keyA docvalues.Value, countB int, keyB docvalues.Value) bool {
+			if desc {
+				return keyA.Compare(keyB) > 0
+			}
+			return keyA.Compare(keyB) < 0
+		}, nil
+	}
+	return nil, fmt.Errorf("[terms] unknown order %q", order)
+}
+
+// parseTermsAggregationDSL decodes
+//
+//	{"field": "author", "size": 10, "min_doc_count": 1, "order": {"_count": "desc"}}
+func parseTermsAggregationDSL(body json.RawMessage, subs map[string]Aggregation) (Aggregation, error) {
+	var params struct {
+		Field       string            `json:"field"`
+		Size        *int              `json:"size"`
+		MinDocCount *int              `json:"min_doc_count"`
+		Order       map[string]string `json:"order"`
+	}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, fmt.Errorf("[terms] invalid parameters: %w", err)
+	}
+	if params.Field == "" {
+		return nil, fmt.Errorf("[terms] requires [field]")
+	}
+
+	agg := &TermsAggregation{Field: params.Field, MinDocCount: 1, Aggregations: subs}
+	if params.Size != nil {
+		if *params.Size <= 0 {
+			return nil, fmt.Errorf("[terms] [size] must be > 0, got %d", *params.Size)
+		}
+		agg.Size = *params.Size
+	}
+	if params.MinDocCount != nil {
+		if *params.MinDocCount < 1 {
+			return nil, fmt.Errorf("[terms] [min_doc_count] must be >= 1, got %d", *params.MinDocCount)
+		}
+		agg.MinDocCount = *params.MinDocCount
+	}
+	if len(params.Order) > 1 {
+		return nil, fmt.Errorf("[terms] [order] must have one key")
+	}
+	for key, dir := range params.Order {
+		agg.Order = TermsOrder(key + ":" + dir)
+		if _, err := termsLess(agg.Order); err != nil {
+			return nil, err
+		}
+	}
+	return agg, nil
+}
@@ -0,0 +1,109 @@
+package search
+
+import (
+	"math"
+	"time"
+
+	"nano-elastic/internal/index/inverted"
+)
+
+// BM25 parameters, using the usual Lucene/Elasticsearch defaults
+const (
+	BM25K1 = 1.2  // Term frequency saturation
+	BM25B  = 0.75 // Strength of field length normalization
+)
+
+// BM25Similarity is Okapi BM25, the default similarity
+type BM25Similarity struct {
+	K1 float64 // Term frequency saturation
+	B  float64 // Strength of field length normalization, 0..1
+}
+
+// IDF implements Similarity
+func (s BM25Similarity) IDF(docFreq int, docCount int) float64 {
+	return bm25IDF(docFreq, docCount)
+}
+
+// Score implements Similarity
+func (s BM25Similarity) Score(termFreq int, idf float64, fieldLength int, avgFieldLength float64) float64 {
+	tf := float64(termFreq)
+	norm := 1.0
+	if avgFieldLength > 0 {
+		norm = 1 - s.B + s.B*float64(fieldLength)/avgFieldLength
+	}
+	return idf * (tf * (s.K1 + 1)) / (tf + s.K1*norm)
+}
+
+// bm25IDF is the inverse document frequency of a term
+// docCount is the number of documents that have the field at all
+func bm25IDF(docFreq int, docCount int) float64 {
+	return math.Log(1 + (float64(docCount)-float64(docFreq)+0.5)/(float64(docFreq)+0.5))
+}
+
+// scorePostings scores every document in a term's posting list with the
+// field's similarity, skipping documents rejected by the reader's filter
+// Scores are multiplied by the field's index-time boost
+func scorePostings(r *Reader, fieldName string, term string, pl *inverted.PostingList) Matches {
+	matches := make(Matches, pl.Size())
+	if pl.Size() == 0 {
+		return matches
+	}
+
+	scorer := newTermScorer(r, fieldName, term, pl)
+	start := r.profiler.start()
+	defer r.profiler.finish(start, scorer.timer)
+	postings := r.postings(pl).Postings
+	scorer.timer.read(len(postings))
+	for i := range postings {
+		posting := &postings[i]
+		if !r.allowsDoc(posting.Doc) {
+			continue
+		}
+		matches[r.Inverted.DocID(posting.Doc)] = scorer.score(posting)
+	}
+	return matches
+}
+
+// termScorer scores a term's postings in a field, with the statistics
+// looked up once per term
+type termScorer struct {
+	r         *Reader
+	field     string
+	sim       Similarity
+	idf       float64
+	avgLength float64
+	boost     float64
+	timer     *termTimer // Times the scoring when the reader is profiling
+}
+
+// newTermScorer prepares the scoring of a term's posting list
+func newTermScorer(r *Reader, fieldName string, term string, pl *inverted.PostingList) *termScorer {
+	sim := r.similarity(fieldName)
+	docCount, avgLength := r.Inverted.FieldStats(fieldName)
+	return &termScorer{
+		r:         r,
+		field:     fieldName,
+		sim:       sim,
+		idf:       sim.IDF(pl.DocFreq, docCount),
+		avgLength: avgLength,
+		boost:     r.fieldBoost(fieldName),
+		timer:     r.profileTerm(fieldName, term),
+	}
+}
+
+// score scores one posting, including the field's index-time boost
+func (s *termScorer) score(posting *inverted.Posting) float64 {
+	if s.timer == nil {
+		return s.compute(posting)
+	}
+	start := time.Now()
+	score := s.compute(posting)
+	s.timer.scoring += time.Since(start)
+	return score
+}
+
+// compute is score without profiling
+func (s *termScorer) compute(posting *inverted.Posting) float64 {
+	fieldLength := s.r.Inverted.DocFieldLength(s.field, posting.Doc)
+	return s.sim.Score(posting.TermFreq, s.idf, fieldLength, s.avgLength) * s.boost
+}
@@ -0,0 +1,281 @@
+package search
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"nano-elastic/internal/index/bitmap"
+)
+
+// BoolQuery combines other queries
+//   - Must: every clause must match; scores add up
+//   - Filter: every clause must match; scores are ignored
+//   - Should: optional when Must or Filter is set (matching clauses add to the score),
+//     otherwise at least MinimumShouldMatch (default 1) clauses must match
+//   - MustNot: documents matching any clause are excluded
+type BoolQuery struct {
+	Must               []Query
+	Should             []Query
+	MustNot            []Query
+	Filter             []Query
+	MinimumShouldMatch int
+}
+
+// Execute implements Query
+// Filter and must_not clauses run first in filter context, as bitmaps without
+// scores; the documents they reject are skipped by the scoring clauses rather
+// than scored and thrown away
+func (q *BoolQuery) Execute(r *Reader) (Matches, error) {
+	filter, err := q.docFilter(r)
+	if err != nil {
+		return nil, err
+	}
+	if filter != nil {
+		r = r.WithFilter(filter)
+	}
+
+	var candidates Matches
+	required := false
+	for _, clause := range q.Must {
+		m, err := clause.Execute(r)
+		if err != nil {
+			return nil, err
+		}
+		candidates = intersect(candidates, m, required, true)
+		required = true
+	}
+	if !required && len(q.Filter) > 0 {
+		candidates = r.matchesOf(filter.Allowed, 0)
+		required = true
+	}
+
+	// Count should matches per document and accumulate their scores
+	shouldCounts := make(map[string]int)
+	shouldScores := make(Matches)
+	for _, clause := range q.Should {
+		m, err := clause.Execute(r)
+		if err != nil {
+			return nil, err
+		}
+		for id, score := range m {
+			shouldCounts[id]++
+			shouldScores[id] += score
+		}
+	}
+
+	minShould := q.minShould(required)
+	switch {
+	case required:
+		// candidates already holds the must/filter matches
+	case len(q.Should) > 0:
+		candidates = make(Matches, len(shouldScores))
+		for id := range shouldScores {
+			candidates[id] = 0
+		}
+	default:
+		// Only must_not clauses: start from every document the filter allows
+		candidates, _ = (&MatchAllQuery{}).Execute(r)
+	}
+
+	for id := range candidates {
+		if shouldCounts[id] < minShould || !r.allows(id) {
+			delete(candidates, id)
+			continue
+		}
+		candidates[id] += shouldScores[id]
+	}
+	return candidates, nil
+}
+
+// MatchDocs implements DocMatcher: every clause runs in filter context and the
+// clauses are combined with bitmap AND, OR and AND NOT
+func (q *BoolQuery) MatchDocs(r *Reader) (*bitmap.Bitmap, error) {
+	filter, err := q.docFilter(r)
+	if err != nil {
+		return nil, err
+	}
+	if filter != nil {
+		r = r.WithFilter(filter)
+	}
+
+	docs := r.allowed()
+	for _, clause := range q.Must {
+		if docs.IsEmpty() {
+			return docs, nil
+		}
+		m, err := FilterDocs(r, clause)
+		if err != nil {
+			return nil, err
+		}
+		docs = docs.And(m)
+	}
+
+	minShould := q.minShould(len(q.Must) > 0 || len(q.Filter) > 0)
+	if minShould == 0 || docs.IsEmpty() {
+		return docs, nil
+	}
+	if minShould == 1 {
+		should := bitmap.New()
+		for _, clause := range q.Should {
+			m, err := FilterDocs(r, clause)
+			if err != nil {
+				return nil, err
+			}
+			should = should.Or(m)
+		}
+		return docs.And(should), nil
+	}
+
+	counts := make(map[uint32]int)
+	for _, clause := range q.Should {
+		m, err := FilterDocs(r, clause)
+		if err != nil {
+			return nil, err
+		}
+		m.ForEach(func(ord uint32) bool {
+			counts[ord]++
+			return true
+		})
+	}
+	should := bitmap.New()
+	for ord, n := range counts {
+		if n >= minShould {
+			should.Add(ord)
+		}
+	}
+	return docs.And(should), nil
+}
+
+// minShould returns the number of should clauses a document must match
+// Should clauses are optional next to must or filter clauses unless
+// MinimumShouldMatch is set; on their own at least one must match
+func (q *BoolQuery) minShould(required bool) int {
+	if !required && len(q.Should) > 0 && q.MinimumShouldMatch < 1 {
+		return 1
+	}
+	return q.MinimumShouldMatch
+}
+
+// MinimumShouldMatch resolves an Elasticsearch minimum_should_match spec to
+// the number of optional clauses (or query terms) that must match:
+//   - "3": at least 3; "-1": all but 1
+//   - "75%": 75% of them, rounded down; "-25%": all but 25%, rounded down
+//   - "3<90%": all of them up to 3, 90% above; several conditions are
+//     separated by spaces, e.g. "2<-25% 9<-3"
+//
+// The result is never negative, but may exceed optional, in which case
+// nothing can match
+func MinimumShouldMatch(spec string, optional int) (int, error) {
+	spec = strings.TrimSpace(spec)
+	if !strings.Contains(spec, "<") {
+		n, err := minimumShouldMatchValue(spec, optional)
+		if err != nil {
+			return 0, err
+		}
+		return max(n, 0), nil
+	}
+
+	// Conditions are in increasing order of their bound; the last one below
+	// the clause count applies
+	result := optional
+	for _, condition := range strings.Fields(strings.ReplaceAll(spec, " <", "<")) {
+		bound, value, ok := strings.Cut(condition, "<")
+		if !ok {
+			return 0, fmt.Errorf("invalid minimum_should_match condition %q: expected <bound><<value>", condition)
+		}
+		upper, err := strconv.Atoi(strings.TrimSpace(bound))
+		if err != nil {
+			return 0, fmt.Errorf("invalid minimum_should_match bound %q", bound)
+		}
+		if optional <= upper {
+			break
+		}
+		if result, err = minimumShouldMatchValue(strings.TrimSpace(value), optional); err != nil {
+			return 0, err
+		}
+	}
+	return max(result, 0), nil
+}
+
+// minimumShouldMatchValue resolves an absolute or percentage value, negative
+// ones counting the clauses that may be missing
+func minimumShouldMatchValue(value string, optional int) (int, error) {
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		p, err := strconv.Atoi(percent)
+		if err != nil {
+			return 0, fmt.Errorf("invalid minimum_should_match percentage %q", value)
+		}
+		n := optional * p / 100 // Truncated toward zero, so both forms round down
+		if n < 0 {
+			return optional + n, nil
+		}
+		return n, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid minimum_should_match %q: must be an integer or a percentage", value)
+	}
+	if n < 0 {
+		return optional + n, nil
+	}
+	return n, nil
+}
+
+// docFilter runs the filter and must_not clauses in filter context, returning
+// nil when there are none
+func (q *BoolQuery) docFilter(r *Reader) (*DocFilter, error) {
+	if len(q.Filter) == 0 && len(q.MustNot) == 0 {
+		return nil, nil
+	}
+
+	allowed := r.allowed()
+	for _, clause := range q.Filter {
+		docs, err := FilterDocs(r, clause)
+		if err != nil {
+			return nil, err
+		}
+		allowed = allowed.And(docs)
+	}
+	for _, clause := range q.MustNot {
+		if allowed.IsEmpty() {
+			break // Nothing can match, so the remaining clauses don't matter
+		}
+		docs, err := FilterDocs(r, clause)
+		if err != nil {
+			return nil, err
+		}
+		allowed = allowed.AndNot(docs)
+	}
+	return &DocFilter{Allowed: allowed}, nil
+}
+
+// intersect keeps the documents present in both acc and m
+// When acc is not yet initialized (first required clause), m seeds it
+// Scores from m are added only for scoring clauses
+func intersect(acc Matches, m Matches, initialized bool, scoring bool) Matches {
+	if !initialized {
+		acc = make(Matches, len(m))
+		for id, score := range m {
+			if scoring {
+				acc[id] = score
+			} else {
+				acc[id] = 0
+			}
+		}
+		return acc
+	}
+
+	for id := range acc {
+		score, ok := m[id]
+		if !ok {
+			delete(acc, id)
+			continue
+		}
+		if scoring {
+			acc[id] += score
+		}
+	}
+	return acc
+}
@@ -0,0 +1,84 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"nano-elastic/internal/index/docvalues"
+	"nano-elastic/internal/types"
+)
+
+// Collapse keeps only the best hit of each value of a field, e.g. one result
+// per author, like Elasticsearch's field collapsing
+// The best hit is the first in the request's sort order (by score unless the
+// request sorts); documents without a value form one group of their own.
+// Keyword fields are the usual target; numeric, date and boolean fields work
+// too. Text fields have no doc values and are rejected
+type Collapse struct {
+	Field string
+}
+
+// validate checks the collapse field and its use with a search_after cursor,
+// which only pages correctly when the primary sort is on the collapse field
+func (c *Collapse) validate(sort []SortField, searchAfter []interface{}) error {
+	if c.Field == "" {
+		return fmt.Errorf("collapse requires a field")
+	}
+	if searchAfter != nil && (len(sort) == 0 || sort[0].Field != c.Field) {
+		return fmt.Errorf("collapse with search_after requires the primary sort to be on the collapse field [%s]", c.Field)
+	}
+	return nil
+}
+
+// collapseHits keeps the first hit of each value of the collapse field, in
+// the hits' order, and returns them with the number of groups
+// Each kept hit reports its value in Hit.Fields
+func collapseHits(r *Reader, c *Collapse, hits []Hit) ([]Hit, int, error) {
+	if fieldType, ok := r.fieldType(c.Field); ok && fieldType == types.FieldTypeText {
+		return nil, 0, fmt.Errorf("[collapse] text field %s has no doc values; collapse on a keyword field instead", c.Field)
+	}
+
+	column := r.DocValues.Column(c.Field)
+	seen := make(map[docvalues.Value]struct{})
+	kept := hits[:0]
+	for _, hit := range hits {
+		value, ok := column[hit.ID]
+		if !ok {
+			value = docvalues.Value{} // The group of documents without a value
+		}
+		if _, dup := seen[value]; dup {
+			continue
+		}
+		seen[value] = struct{}{}
+		var key interface{}
+		if ok {
+			key = value.Interface()
+		}
+		hit.Fields = map[string][]interface{}{c.Field: {key}}
+		kept = append(kept, hit)
+	}
+	return kept, len(seen), nil
+}
+
+// collapseKey returns the collapse value a hit reported from its shard (see
+// collapseHits)
+func collapseKey(c *Collapse, hit Hit) interface{} {
+	if values := hit.Fields[c.Field]; len(values) > 0 {
+		return values[0]
+	}
+	return nil
+}
+
+// parseCollapseDSL decodes {"field": "author"}
+func parseCollapseDSL(raw json.RawMessage) (*Collapse, error) {
+	var params struct {
+		Field string `json:"field"`
+	}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, fmt.Errorf("[collapse] invalid parameters: %w", err)
+	}
+	if params.Field == "" {
+		return nil, fmt.Errorf("[collapse] requires [field]")
+	}
+	return &Collapse{Field: params.Field}, nil
+}
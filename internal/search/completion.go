@@ -0,0 +1,60 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"nano-elastic/internal/types"
+)
+
+// CompletionSuggester returns the heaviest inputs of a completion field that
+// start with Prefix, for autocomplete boxes
+// Inputs and the prefix are compared lowercased, so "nir" completes "Nirvana"
+type CompletionSuggester struct {
+	Field          string
+	Prefix         string
+	Size           int  // Completions to return (default DefaultSuggestSize)
+	SkipDuplicates bool // Return each input text once, from its heaviest document
+}
+
+// Suggest implements Suggester
+func (s *CompletionSuggester) Suggest(r *Reader) ([]SuggestEntry, error) {
+	if s.Field == "" {
+		return nil, fmt.Errorf("[completion] suggester requires a field")
+	}
+	if fieldType, ok := r.fieldType(s.Field); ok && fieldType != types.FieldTypeCompletion {
+		return nil, fmt.Errorf("[completion] suggester field %s is %s, not completion", s.Field, fieldType)
+	}
+	size := s.Size
+	if size <= 0 {
+		size = DefaultSuggestSize
+	}
+
+	entry := SuggestEntry{Text: s.Prefix, Length: len(s.Prefix), Options: []SuggestOption{}, size: size}
+	if r.Completions != nil {
+		for _, c := range r.Completions.Suggest(s.Field, s.Prefix, size, s.SkipDuplicates, nil) {
+			entry.Options = append(entry.Options, SuggestOption{Text: c.Text, Score: float64(c.Weight), ID: c.DocID})
+		}
+	}
+	return []SuggestEntry{entry}, nil
+}
+
+// parseCompletionSuggesterDSL decodes {"field": "suggest", "size": 5,
+// "skip_duplicates": true}
+func parseCompletionSuggesterDSL(body json.RawMessage, prefix string) (Suggester, error) {
+	var params struct {
+		Field          string `json:"field"`
+		Size           int    `json:"size"`
+		SkipDuplicates bool   `json:"skip_duplicates"`
+	}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, fmt.Errorf("[completion] invalid parameters: %w", err)
+	}
+	if params.Field == "" {
+		return nil, fmt.Errorf("[completion] requires [field]")
+	}
+	if params.Size < 0 {
+		return nil, fmt.Errorf("[completion] size must be >= 0, got %d", params.Size)
+	}
+	return &CompletionSuggester{Field: params.Field, Prefix: prefix, Size: params.Size, SkipDuplicates: params.SkipDuplicates}, nil
+}
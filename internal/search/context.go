@@ -0,0 +1,59 @@
+package search
+
+import (
+	"context"
+	"errors"
+)
+
+// contextCheckInterval is how many documents long loops handle between checks
+// of the reader's context
+const contextCheckInterval = 1024
+
+// WithContext returns a copy of the reader whose queries stop with the
+// context's error once it is cancelled or its deadline passes
+func (r *Reader) WithContext(ctx context.Context) *Reader {
+	withCtx := *r
+	withCtx.ctx = ctx
+	return &withCtx
+}
+
+// Context returns the reader's context, context.Background() if it has none
+func (r *Reader) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// checkContext returns the context's error once it is done
+func (r *Reader) checkContext() error {
+	if r.ctx == nil {
+		return nil
+	}
+	return r.ctx.Err()
+}
+
+// checkContextEvery is checkContext for loops, checking every
+// contextCheckInterval iterations
+func (r *Reader) checkContextEvery(i int) error {
+	if i%contextCheckInterval != 0 {
+		return nil
+	}
+	return r.checkContext()
+}
+
+// withTimeout applies the request's timeout, if any, to the reader's context
+// The caller must call the returned function once the search is done
+func (req *Request) withTimeout(r *Reader) (*Reader, context.CancelFunc) {
+	if req.Timeout <= 0 {
+		return r, func() {}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), req.Timeout)
+	return r.WithContext(ctx), cancel
+}
+
+// timedOut reports whether a search failed because its deadline passed, in
+// which case it returns what it found in time instead of the error
+func timedOut(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,618 @@
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"nano-elastic/internal/index/inverted"
+	"nano-elastic/internal/types"
+)
+
+// ParseQueryDSL decodes an Elasticsearch-style JSON query such as
+//
+//	{"bool": {"must": [{"match": {"title": "gatsby"}}], "filter": {"range": {"year": {"gte": 1900}}}}}
+//
+// Supported queries: match_all, match, match_phrase, multi_match, term, terms,
+// range, exists, prefix, wildcard, fuzzy, bool, query_string, function_score, geo_distance,
+// geo_bounding_box, knn and hybrid. Queries with parameters, bool, multi_match and query_string
+// accept a "boost" multiplying their scores
+func ParseQueryDSL(data []byte) (Query, error) {
+	name, body, err := singleKey(data, "query")
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "match_all":
+		return &MatchAllQuery{}, nil
+	case "match":
+		return parseMatchDSL(body)
+	case "match_phrase":
+		return parseMatchPhraseDSL(body)
+	case "multi_match":
+		return parseMultiMatchDSL(body)
+	case "term":
+		return parseTermDSL(body)
+	case "terms":
+		return parseTermsDSL(body)
+	case "range":
+		return parseRangeDSL(body)
+	case "exists":
+		return parseExistsDSL(body)
+	case "prefix":
+		return parseValueDSL("prefix", body, func(field, value string) Query {
+			return &PrefixQuery{Field: field, Prefix: value}
+		})
+	case "wildcard":
+		return parseValueDSL("wildcard", body, func(field, value string) Query {
+			return &WildcardQuery{Field: field, Pattern: value}
+		})
+	case "fuzzy":
+		return parseFuzzyDSL(body)
+	case "bool":
+		return parseBoolDSL(body)
+	case "query_string":
+		return parseQueryStringDSL(body)
+	case "function_score":
+		return parseFunctionScoreDSL(body)
+	case "geo_distance":
+		return parseGeoDistanceDSL(body)
+	case "geo_bounding_box":
+		return parseGeoBoundingBoxDSL(body)
+	case "knn":
+		return parseKnnDSL(body)
+	case "hybrid":
+		return parseHybridDSL(body)
+	}
+	return nil, fmt.Errorf("unknown query [%s]", name)
+}
+
+// ParseSearchRequest decodes a search request body:
+//
+//	{"query": {...}, "from": 0, "size": 10, "sort": [...], "search_after": [...],
+//	 "highlight": {...}, "aggs": {...}, "track_total_hits": 10000, "timeout": "500ms",
+//	 "_source": {"includes": [...], "excludes": [...]}, "profile": true,
+//	 "collapse": {"field": "author"}, "suggest": {...}}
+//
+// Sort entries may be "field", {"field": "desc"} or {"field": {"order": "desc"}}
+func ParseSearchRequest(data []byte) (*Request, error) {
+	req := &Request{}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return req, nil
+	}
+
+	var body struct {
+		Query        json.RawMessage   `json:"query"`
+		From         int               `json:"from"`
+		Size         *int              `json:"size"`
+		Sort         []json.RawMessage `json:"sort"`
+		SearchAfter  []interface{}     `json:"search_after"`
+		Highlight    json.RawMessage   `json:"highlight"`
+		Aggs         json.RawMessage   `json:"aggs"`
+		Aggregations json.RawMessage   `json:"aggregations"`
+		TrackTotal   json.RawMessage   `json:"track_total_hits"`
+		Timeout      string            `json:"timeout"`
+		Source       json.RawMessage   `json:"_source"`
+		Profile      bool              `json:"profile"`
+		Collapse     json.RawMessage   `json:"collapse"`
+		Suggest      json.RawMessage   `json:"suggest"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("invalid search request: %w", err)
+	}
+
+	if len(body.Query) > 0 {
+		q, err := ParseQueryDSL(body.Query)
+		if err != nil {
+			return nil, err
+		}
+		req.Query = q
+	}
+	req.From = body.From
+	req.Profile = body.Profile
+	req.Size = body.Size
+	req.SearchAfter = body.SearchAfter
+	if len(body.TrackTotal) > 0 {
+		n, err := parseTrackTotalHits(body.TrackTotal)
+		if err != nil {
+			return nil, err
+		}
+		req.TrackTotalHits = n
+	}
+	if body.Timeout != "" {
+		millis, err := parseDurationMillis(body.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+		req.Timeout = time.Duration(millis * float64(time.Millisecond))
+	}
+	if len(body.Source) > 0 {
+		filter, err := types.ParseSourceFilter(body.Source)
+		if err != nil {
+			return nil, err
+		}
+		req.Source = filter
+	}
+	if len(body.Highlight) > 0 {
+		h, err := parseHighlightDSL(body.Highlight)
+		if err != nil {
+			return nil, err
+		}
+		req.Highlight = h
+	}
+	if len(body.Collapse) > 0 {
+		c, err := parseCollapseDSL(body.Collapse)
+		if err != nil {
+			return nil, err
+		}
+		req.Collapse = c
+	}
+	if len(body.Suggest) > 0 {
+		suggesters, err := parseSuggestDSL(body.Suggest)
+		if err != nil {
+			return nil, err
+		}
+		req.Suggest = suggesters
+	}
+	aggs := body.Aggs
+	if len(aggs) == 0 {
+		aggs = body.Aggregations
+	}
+	if len(aggs) > 0 {
+		parsed, err := parseAggregationsDSL(aggs)
+		if err != nil {
+			return nil, err
+		}
+		req.Aggregations = parsed
+	}
+
+	for _, raw := range body.Sort {
+		sf, err := parseSortDSL(raw)
+		if err != nil {
+			return nil, err
+		}
+		req.Sort = append(req.Sort, sf)
+	}
+	return req, nil
+}
+
+// parseTrackTotalHits decodes "track_total_hits": true counts every match,
+// false as few as possible, and a number that many
+func parseTrackTotalHits(raw json.RawMessage) (int, error) {
+	var track bool
+	if err := json.Unmarshal(raw, &track); err == nil {
+		if track {
+			return TrackTotalHitsAll, nil
+		}
+		return 1, nil
+	}
+	var n int
+	if err := json.Unmarshal(raw, &n); err != nil || n < 0 {
+		return 0, fmt.Errorf("track_total_hits must be a boolean or a non-negative integer, got %s", raw)
+	}
+	if n == 0 {
+		return 1, nil // 0 would mean the default
+	}
+	return n, nil
+}
+
+// parseSortDSL decodes one sort entry
+func parseSortDSL(raw json.RawMessage) (SortField, error) {
+	var field string
+	if err := json.Unmarshal(raw, &field); err == nil {
+		return SortField{Field: field}, nil
+	}
+
+	field, body, err := singleKey(raw, "sort")
+	if err != nil {
+		return SortField{}, err
+	}
+	var order string
+	if err := json.Unmarshal(body, &order); err == nil {
+		return SortField{Field: field, Order: SortOrder(order)}, nil
+	}
+	var opts struct {
+		Order string `json:"order"`
+	}
+	if err := json.Unmarshal(body, &opts); err != nil {
+		return SortField{}, fmt.Errorf("invalid sort for field %s: %w", field, err)
+	}
+	return SortField{Field: field, Order: SortOrder(opts.Order)}, nil
+}
+
+// singleKey decodes an object with exactly one key, e.g. {"match": {...}}
+func singleKey(data []byte, context string) (string, json.RawMessage, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return "", nil, fmt.Errorf("[%s] must be an object: %w", context, err)
+	}
+	if len(obj) != 1 {
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return "", nil, fmt.Errorf("[%s] must have exactly one key, got %d: %v", context, len(obj), keys)
+	}
+	for k, v := range obj {
+		return k, v, nil
+	}
+	return "", nil, nil
+}
+
+// scalarString converts a JSON string, number or boolean to its string form
+func scalarString(raw json.RawMessage) (string, bool) {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", false
+	}
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(x), true
+	}
+	return "", false
+}
+
+// fieldParams decodes {"field": value} or {"field": {key: value, ...}}
+// The short form's value is returned under shortKey
+func fieldParams(query string, body json.RawMessage, shortKey string) (string, map[string]json.RawMessage, error) {
+	field, raw, err := singleKey(body, query)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, ok := scalarString(raw); ok {
+		return field, map[string]json.RawMessage{shortKey: raw}, nil
+	}
+
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return "", nil, fmt.Errorf("[%s] field %s must be a value or an object", query, field)
+	}
+	return field, params, nil
+}
+
+// requiredString reads a required scalar parameter
+func requiredString(query string, params map[string]json.RawMessage, key string) (string, error) {
+	raw, ok := params[key]
+	if !ok {
+		return "", fmt.Errorf("[%s] requires [%s]", query, key)
+	}
+	value, ok := scalarString(raw)
+	if !ok {
+		return "", fmt.Errorf("[%s] [%s] must be a string, number or boolean", query, key)
+	}
+	return value, nil
+}
+
+// parseOperator reads an optional "operator" parameter
+func parseOperator(query string, params map[string]json.RawMessage, key string) (Operator, error) {
+	raw, ok := params[key]
+	if !ok {
+		return OperatorOr, nil
+	}
+	value, _ := scalarString(raw)
+	switch strings.ToUpper(value) {
+	case string(OperatorOr):
+		return OperatorOr, nil
+	case string(OperatorAnd):
+		return OperatorAnd, nil
+	}
+	return "", fmt.Errorf("[%s] invalid %s %q: must be and or or", query, key, value)
+}
+
+// parseMinimumShouldMatch reads an optional "minimum_should_match" spec, a
+// number or a string such as "75%", checking its syntax
+func parseMinimumShouldMatch(query string, params map[string]json.RawMessage) (string, error) {
+	raw, ok := params["minimum_should_match"]
+	if !ok {
+		return "", nil
+	}
+	spec, ok := scalarString(raw)
+	if !ok {
+		return "", fmt.Errorf("[%s] minimum_should_match must be a number or a string", query)
+	}
+	if _, err := MinimumShouldMatch(spec, 1); err != nil {
+		return "", fmt.Errorf("[%s] %w", query, err)
+	}
+	return spec, nil
+}
+
+// withBoost wraps a query in a BoostQuery if its parameters have a "boost"
+func withBoost(query string, q Query, params map[string]json.RawMessage) (Query, error) {
+	raw, ok := params["boost"]
+	if !ok {
+		return q, nil
+	}
+	boost, err := parseBoost(query, raw)
+	if err != nil {
+		return nil, err
+	}
+	return Boost(q, boost), nil
+}
+
+// parseBoost reads a query boost, a non-negative number
+func parseBoost(query string, raw json.RawMessage) (float64, error) {
+	s, _ := scalarString(raw)
+	boost, err := strconv.ParseFloat(s, 64)
+	if err != nil || boost < 0 {
+		return 0, fmt.Errorf("[%s] boost must be a non-negative number, got %s", query, raw)
+	}
+	return boost, nil
+}
+
+func parseMatchDSL(body json.RawMessage) (Query, error) {
+	field, params, err := fieldParams("match", body, "query")
+	if err != nil {
+		return nil, err
+	}
+	text, err := requiredString("match", params, "query")
+	if err != nil {
+		return nil, err
+	}
+	op, err := parseOperator("match", params, "operator")
+	if err != nil {
+		return nil, err
+	}
+	msm, err := parseMinimumShouldMatch("match", params)
+	if err != nil {
+		return nil, err
+	}
+	return withBoost("match", &MatchQuery{Field: field, Query: text, Operator: op, MinimumShouldMatch: msm}, params)
+}
+
+func parseMatchPhraseDSL(body json.RawMessage) (Query, error) {
+	field, params, err := fieldParams("match_phrase", body, "query")
+	if err != nil {
+		return nil, err
+	}
+	text, err := requiredString("match_phrase", params, "query")
+	if err != nil {
+		return nil, err
+	}
+	return withBoost("match_phrase", &PhraseQuery{Field: field, Phrase: text}, params)
+}
+
+// parseMultiMatchDSL decodes
+//
+//	{"query": "jazz", "fields": ["title^2", "body"], "type": "best_fields", "tie_breaker": 0.3}
+func parseMultiMatchDSL(body json.RawMessage) (Query, error) {
+	var params struct {
+		Query              string          `json:"query"`
+		Fields             []string        `json:"fields"`
+		Operator           string          `json:"operator"`
+		Type               string          `json:"type"`
+		TieBreaker         *float64        `json:"tie_breaker"`
+		MinimumShouldMatch json.RawMessage `json:"minimum_should_match"`
+		Boost              json.RawMessage `json:"boost"`
+	}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, fmt.Errorf("[multi_match] %w", err)
+	}
+	if len(params.Fields) == 0 {
+		return nil, fmt.Errorf("[multi_match] requires [fields]")
+	}
+
+	q := &MultiMatchQuery{Query: params.Query, Operator: OperatorOr, Type: MultiMatchBestFields}
+	if strings.EqualFold(params.Operator, string(OperatorAnd)) {
+		q.Operator = OperatorAnd
+	}
+	for _, field := range params.Fields {
+		wf, err := ParseWeightedField(field)
+		if err != nil {
+			return nil, fmt.Errorf("[multi_match] %w", err)
+		}
+		q.Fields = append(q.Fields, wf)
+	}
+	switch MultiMatchType(params.Type) {
+	case "", MultiMatchBestFields:
+	case MultiMatchMostFields:
+		q.Type = MultiMatchMostFields
+	default:
+		return nil, fmt.Errorf("[multi_match] unsupported type [%s]: must be best_fields or most_fields", params.Type)
+	}
+	if params.TieBreaker != nil {
+		if *params.TieBreaker < 0 || *params.TieBreaker > 1 {
+			return nil, fmt.Errorf("[multi_match] tie_breaker must be between 0 and 1, got %g", *params.TieBreaker)
+		}
+		q.TieBreaker = *params.TieBreaker
+	}
+	if len(params.MinimumShouldMatch) > 0 {
+		msm, err := parseMinimumShouldMatch("multi_match", map[string]json.RawMessage{"minimum_should_match": params.MinimumShouldMatch})
+		if err != nil {
+			return nil, err
+		}
+		q.MinimumShouldMatch = msm
+	}
+	if len(params.Boost) > 0 {
+		boost, err := parseBoost("multi_match", params.Boost)
+		if err != nil {
+			return nil, err
+		}
+		return Boost(q, boost), nil
+	}
+	return q, nil
+}
+
+func parseTermDSL(body json.RawMessage) (Query, error) {
+	return parseValueDSL("term", body, func(field, value string) Query {
+		return &TermQuery{Field: field, Value: value}
+	})
+}
+
+// parseValueDSL handles queries of the form {"field": "v"} or {"field": {"value": "v"}}
+func parseValueDSL(query string, body json.RawMessage, build func(field, value string) Query) (Query, error) {
+	field, params, err := fieldParams(query, body, "value")
+	if err != nil {
+		return nil, err
+	}
+	value, err := requiredString(query, params, "value")
+	if err != nil {
+		return nil, err
+	}
+	return withBoost(query, build(field, value), params)
+}
+
+func parseTermsDSL(body json.RawMessage) (Query, error) {
+	field, raw, err := singleKey(body, "terms")
+	if err != nil {
+		return nil, err
+	}
+	var values []json.RawMessage
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, fmt.Errorf("[terms] field %s must be an array of values", field)
+	}
+
+	bq := &BoolQuery{}
+	for _, v := range values {
+		value, ok := scalarString(v)
+		if !ok {
+			return nil, fmt.Errorf("[terms] values for field %s must be strings, numbers or booleans", field)
+		}
+		bq.Should = append(bq.Should, &TermQuery{Field: field, Value: value})
+	}
+	return bq, nil
+}
+
+func parseRangeDSL(body json.RawMessage) (Query, error) {
+	field, raw, err := singleKey(body, "range")
+	if err != nil {
+		return nil, err
+	}
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, fmt.Errorf("[range] field %s must be an object", field)
+	}
+
+	rq := &FieldRangeQuery{Field: field}
+	bounds := map[string]*string{"gt": &rq.GT, "gte": &rq.GTE, "lt": &rq.LT, "lte": &rq.LTE}
+	for key, value := range params {
+		dst, ok := bounds[key]
+		if !ok {
+			return nil, fmt.Errorf("[range] unknown parameter [%s]", key)
+		}
+		s, ok := scalarString(value)
+		if !ok {
+			return nil, fmt.Errorf("[range] [%s] must be a string or number", key)
+		}
+		*dst = s
+	}
+	return rq, nil
+}
+
+func parseFuzzyDSL(body json.RawMessage) (Query, error) {
+	field, params, err := fieldParams("fuzzy", body, "value")
+	if err != nil {
+		return nil, err
+	}
+	value, err := requiredString("fuzzy", params, "value")
+	if err != nil {
+		return nil, err
+	}
+
+	maxEdits := -1 // AUTO
+	if raw, ok := params["fuzziness"]; ok {
+		s, _ := scalarString(raw)
+		if !strings.EqualFold(s, "AUTO") {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 || n > inverted.MaxFuzzyEdits {
+				return nil, fmt.Errorf("[fuzzy] fuzziness must be AUTO or 0..%d, got %q", inverted.MaxFuzzyEdits, s)
+			}
+			maxEdits = n
+		}
+	}
+	return withBoost("fuzzy", &FuzzyQuery{Field: field, Term: value, MaxEdits: maxEdits}, params)
+}
+
+func parseBoolDSL(body json.RawMessage) (Query, error) {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, fmt.Errorf("[bool] must be an object: %w", err)
+	}
+
+	bq := &BoolQuery{}
+	boost := 1.0
+	msm, err := parseMinimumShouldMatch("bool", params)
+	if err != nil {
+		return nil, err
+	}
+	clauses := map[string]*[]Query{
+		"must":     &bq.Must,
+		"should":   &bq.Should,
+		"must_not": &bq.MustNot,
+		"filter":   &bq.Filter,
+	}
+	for key, raw := range params {
+		if key == "minimum_should_match" {
+			continue
+		}
+		if key == "boost" {
+			if boost, err = parseBoost("bool", raw); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		dst, ok := clauses[key]
+		if !ok {
+			return nil, fmt.Errorf("[bool] unknown clause [%s]", key)
+		}
+
+		// A clause is a single query or an array of queries
+		var list []json.RawMessage
+		if err := json.Unmarshal(raw, &list); err != nil {
+			list = []json.RawMessage{raw}
+		}
+		for _, item := range list {
+			q, err := ParseQueryDSL(item)
+			if err != nil {
+				return nil, err
+			}
+			*dst = append(*dst, q)
+		}
+	}
+	if msm != "" {
+		// Percentages and negative counts are relative to the should clauses
+		if bq.MinimumShouldMatch, err = MinimumShouldMatch(msm, len(bq.Should)); err != nil {
+			return nil, fmt.Errorf("[bool] %w", err)
+		}
+	}
+	if boost != 1.0 {
+		return Boost(bq, boost), nil
+	}
+	return bq, nil
+}
+
+func parseQueryStringDSL(body json.RawMessage) (Query, error) {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, fmt.Errorf("[query_string] must be an object: %w", err)
+	}
+	text, err := requiredString("query_string", params, "query")
+	if err != nil {
+		return nil, err
+	}
+
+	q := &QueryStringQuery{Query: text}
+	if raw, ok := params["default_field"]; ok {
+		field, _ := scalarString(raw)
+		q.DefaultFields = []string{field}
+	}
+	if raw, ok := params["fields"]; ok {
+		if err := json.Unmarshal(raw, &q.DefaultFields); err != nil {
+			return nil, fmt.Errorf("[query_string] fields must be an array of strings")
+		}
+	}
+	if q.DefaultOperator, err = parseOperator("query_string", params, "default_operator"); err != nil {
+		return nil, err
+	}
+	return withBoost("query_string", q, params)
+}
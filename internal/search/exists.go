@@ -0,0 +1,84 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"nano-elastic/internal/index/bitmap"
+)
+
+// ExistsQuery matches documents with a value for a field, or for any subfield
+// of an object field, with a constant score of 1
+// Values are found in the search structures and doc values, so documents are
+// never loaded
+type ExistsQuery struct {
+	Field string
+}
+
+// Execute implements Query
+func (q *ExistsQuery) Execute(r *Reader) (Matches, error) {
+	docs, err := q.MatchDocs(r)
+	if err != nil {
+		return nil, err
+	}
+	return r.matchesOf(docs, 1.0), nil
+}
+
+// MatchDocs implements DocMatcher
+func (q *ExistsQuery) MatchDocs(r *Reader) (*bitmap.Bitmap, error) {
+	docs := bitmap.New()
+	add := func(id string) {
+		if ord, ok := r.Ordinals.Ordinal(id); ok {
+			docs.Add(ord)
+		}
+	}
+	for _, field := range q.fields(r) {
+		for _, doc := range r.Inverted.FieldDocs(field) {
+			docs.Add(doc)
+		}
+		for id := range r.DocValues.Column(field) {
+			add(id)
+		}
+		for _, id := range r.Geo.DocIDs(field) {
+			add(id)
+		}
+		for _, id := range r.Vectors.DocIDs(field) {
+			add(id)
+		}
+	}
+	return docs, nil
+}
+
+// fields returns the field and, from the schema, the subfields under it
+func (q *ExistsQuery) fields(r *Reader) []string {
+	fields := []string{q.Field}
+	if r.Schema == nil {
+		return fields
+	}
+	prefix := q.Field + "."
+	for name := range r.Schema.Fields {
+		if strings.HasPrefix(name, prefix) {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// filterKey implements cacheableFilter
+func (q *ExistsQuery) filterKey() (string, bool) {
+	return fmt.Sprintf("exists %q", q.Field), true
+}
+
+// parseExistsDSL decodes {"field": "title"}
+func parseExistsDSL(body json.RawMessage) (Query, error) {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, fmt.Errorf("[exists] must be an object: %w", err)
+	}
+	field, err := requiredString("exists", params, "field")
+	if err != nil {
+		return nil, err
+	}
+	return withBoost("exists", &ExistsQuery{Field: field}, params)
+}
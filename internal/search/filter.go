@@ -0,0 +1,113 @@
+package search
+
+import (
+	"nano-elastic/internal/index/bitmap"
+	"nano-elastic/internal/index/inverted"
+)
+
+// DocMatcher is implemented by queries that can find their matching documents
+// without scoring them, e.g. a term query on a text field skips BM25
+type DocMatcher interface {
+	// MatchDocs returns the ordinals (see Reader.Ordinals) of the matching documents
+	MatchDocs(r *Reader) (*bitmap.Bitmap, error)
+}
+
+// FilterDocs runs a query in filter context: only whether a document matches
+// counts, so queries that implement DocMatcher skip scoring
+// The result is a bitmap of document ordinals; callers must not modify it
+// Results of cacheable queries are kept in the reader's FilterCache
+func FilterDocs(r *Reader, q Query) (*bitmap.Bitmap, error) {
+	if err := r.checkContext(); err != nil {
+		return nil, err
+	}
+	if cf, ok := q.(cacheableFilter); ok && r.FilterCache != nil && r.Segments != nil {
+		if key, ok := cf.filterKey(); ok {
+			return r.FilterCache.cachedFilterDocs(r, key, func() (*bitmap.Bitmap, error) {
+				return filterDocs(r, q)
+			})
+		}
+	}
+	return filterDocs(r, q)
+}
+
+// filterDocs is FilterDocs without the cache
+func filterDocs(r *Reader, q Query) (*bitmap.Bitmap, error) {
+	if dm, ok := q.(DocMatcher); ok {
+		return dm.MatchDocs(r)
+	}
+	m, err := q.Execute(r)
+	if err != nil {
+		return nil, err
+	}
+	return r.docSet(m), nil
+}
+
+// DocFilter limits the documents a query needs to consider, from the filter and
+// must_not clauses of enclosing bool queries
+// Scoring queries skip rejected documents before computing their scores; callers
+// that own the filter must still drop rejected documents from the results
+type DocFilter struct {
+	Allowed *bitmap.Bitmap // Ordinals of the documents passing this filter and every enclosing one
+}
+
+// WithFilter returns a copy of the reader whose queries also apply filter
+func (r *Reader) WithFilter(filter *DocFilter) *Reader {
+	filtered := *r
+	filtered.Filter = filter
+	return &filtered
+}
+
+// allows reports whether a document passes the reader's filter
+func (r *Reader) allows(id string) bool {
+	if r.Filter == nil {
+		return true
+	}
+	ord, ok := r.Ordinals.Ordinal(id)
+	return ok && r.allowsDoc(ord)
+}
+
+// allowsDoc is allows by document ordinal, e.g. from a posting
+func (r *Reader) allowsDoc(ord uint32) bool {
+	return r.Filter == nil || r.Filter.Allowed.Contains(ord)
+}
+
+// postings returns the part of a posting list that can hold documents passing
+// the reader's filter: the postings between its lowest and highest allowed
+// ordinals. Lists are sorted by ordinal, so a segment's search (see
+// collectTopSegments) walks its segment's range of each list, not all of it
+func (r *Reader) postings(pl *inverted.PostingList) *inverted.PostingList {
+	if r.Filter == nil {
+		return pl
+	}
+	lo, ok := r.Filter.Allowed.Min()
+	if !ok {
+		return pl.Between(1, 0)
+	}
+	hi, _ := r.Filter.Allowed.Max()
+	return pl.Between(lo, hi)
+}
+
+// allowed returns the ordinals of the documents passing the reader's filter,
+// every live document when there is none
+func (r *Reader) allowed() *bitmap.Bitmap {
+	if r.Filter == nil {
+		return r.Ordinals.Live()
+	}
+	return r.Filter.Allowed.Clone()
+}
+
+// docSet returns the ordinals of the matching documents
+func (r *Reader) docSet(m Matches) *bitmap.Bitmap {
+	docs := bitmap.New()
+	for id := range m {
+		if ord, ok := r.Ordinals.Ordinal(id); ok {
+			docs.Add(ord)
+		}
+	}
+	return docs
+}
+
+// matchesOf returns the documents of a bitmap of ordinals, all with the same score
+func (r *Reader) matchesOf(docs *bitmap.Bitmap, score float64) Matches {
+	return constantScore(r.Ordinals.IDs(docs), score)
+}
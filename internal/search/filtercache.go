@@ -0,0 +1,252 @@
+package search
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+
+	"nano-elastic/internal/index/bitmap"
+	"nano-elastic/internal/index/hll"
+)
+
+// DefaultFilterCacheSize is the memory, in bytes, a FilterCache may hold by default
+const DefaultFilterCacheSize = 64 << 20
+
+// SegmentDocs is the part of a reader's documents stored in one segment
+// A new SegmentDocs is created whenever the segment's searchable documents
+// change, so cached filter results computed for an older one are never reused
+type SegmentDocs struct {
+	Key  string         // Identifies the segment in a FilterCache, e.g. index name and segment ID
+	Docs *bitmap.Bitmap // Ordinals of the segment's searchable documents
+
+	// Sketches of every document's value of each field, stored with the
+	// segment; nil unless Docs is every document the segment holds
+	Sketches map[string]*hll.Sketch
+
+	// Cardinality sketches of every document's value of a field, kept for
+	// aggregations matching the whole segment (see CardinalityAggregation)
+	sketches   map[string]*hll.Sketch
+	sketchesMu sync.Mutex
+}
+
+// FilterCache keeps the results of frequently used filters (e.g. a term query
+// on status:published) as bitmaps, one per segment and filter, so they aren't
+// recomputed by every search
+// Bitmaps are kept for the SegmentDocs they were computed for, dropped least
+// recently used first once they exceed the memory limit, and dropped with their
+// segment when it is merged away (see RemoveSegment)
+// A FilterCache may be shared by several indexes and used concurrently
+type FilterCache struct {
+	maxBytes int
+	bytes    int
+	segments map[string]map[string]*list.Element // Segment key -> filter key -> entry
+	lru      *list.List                          // Entries, most recently used first
+
+	// Counts reported by Stats
+	hits      int64
+	misses    int64
+	evictions int64
+
+	mu sync.Mutex
+}
+
+// FilterCacheStats counts a FilterCache's lookups and evictions
+type FilterCacheStats struct {
+	Hits      int64 `json:"hits"`      // Filters answered from the cache
+	Misses    int64 `json:"misses"`    // Filters computed because a segment's result was missing
+	Evictions int64 `json:"evictions"` // Results dropped for the memory limit
+	Entries   int   `json:"entries"`
+	Bytes     int   `json:"bytes"`
+}
+
+// filterCacheEntry is one filter's result within one segment
+type filterCacheEntry struct {
+	segment *SegmentDocs
+	filter  string
+	docs    *bitmap.Bitmap
+	size    int
+}
+
+// NewFilterCache creates a cache holding at most maxBytes of bitmaps
+// A limit <= 0 caches nothing
+func NewFilterCache(maxBytes int) *FilterCache {
+	return &FilterCache{
+		maxBytes: maxBytes,
+		segments: make(map[string]map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// cacheableFilter is implemented by queries whose filter context result only
+// depends on the indexed documents, so it can be kept in a FilterCache
+type cacheableFilter interface {
+	// filterKey identifies the query's result; ok is false when it can't be
+	// cached, e.g. because it depends on the current time
+	filterKey() (key string, ok bool)
+}
+
+// cachedFilterDocs returns a filter's result from the reader's cache, or
+// computes it and caches it per segment
+// The search structures span every segment, so unless every segment's result is
+// cached the filter is computed in full, and cached for the segments missing it
+func (c *FilterCache) cachedFilterDocs(r *Reader, filter string, compute func() (*bitmap.Bitmap, error)) (*bitmap.Bitmap, error) {
+	if docs, ok := c.lookup(r.Segments, filter); ok {
+		return docs, nil
+	}
+	docs, err := compute()
+	if err != nil {
+		return nil, err
+	}
+	c.store(r.Segments, filter, docs)
+	return docs, nil
+}
+
+// lookup joins the cached results of a filter in every segment
+// ok is false if any segment's result is missing or stale
+func (c *FilterCache) lookup(segments []*SegmentDocs, filter string) (*bitmap.Bitmap, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]*list.Element, 0, len(segments))
+	for _, seg := range segments {
+		elem, ok := c.segments[seg.Key][filter]
+		if !ok || elem.Value.(*filterCacheEntry).segment != seg {
+			c.misses++
+			return nil, false
+		}
+		entries = append(entries, elem)
+	}
+
+	c.hits++
+	docs := bitmap.New()
+	for _, elem := range entries {
+		c.lru.MoveToFront(elem)
+		docs = docs.Or(elem.Value.(*filterCacheEntry).docs)
+	}
+	return docs, true
+}
+
+// store caches a filter's result, split by segment, for the segments that
+// don't have it yet, then evicts entries beyond the memory limit
+func (c *FilterCache) store(segments []*SegmentDocs, filter string, docs *bitmap.Bitmap) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, seg := range segments {
+		if elem, ok := c.segments[seg.Key][filter]; ok {
+			if elem.Value.(*filterCacheEntry).segment == seg {
+				c.lru.MoveToFront(elem)
+				continue
+			}
+			c.removeLocked(elem) // Computed for the segment's older documents
+		}
+
+		segDocs := docs.And(seg.Docs)
+		entry := &filterCacheEntry{segment: seg, filter: filter, docs: segDocs}
+		entry.size = segDocs.SizeInBytes() + len(filter)
+		if entry.size > c.maxBytes {
+			continue
+		}
+		filters, ok := c.segments[seg.Key]
+		if !ok {
+			filters = make(map[string]*list.Element)
+			c.segments[seg.Key] = filters
+		}
+		filters[filter] = c.lru.PushFront(entry)
+		c.bytes += entry.size
+	}
+
+	for c.bytes > c.maxBytes {
+		c.removeLocked(c.lru.Back())
+		c.evictions++
+	}
+}
+
+// RemoveSegment drops every cached result of a segment, e.g. once it is merged away
+func (c *FilterCache) RemoveSegment(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, elem := range c.segments[key] {
+		c.removeLocked(elem)
+	}
+}
+
+// Size returns the memory held by the cached bitmaps, in bytes
+func (c *FilterCache) Size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.bytes
+}
+
+// Stats returns the cache's lookup and eviction counts and current contents
+func (c *FilterCache) Stats() FilterCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return FilterCacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   c.lru.Len(),
+		Bytes:     c.bytes,
+	}
+}
+
+// removeLocked drops one entry
+// Caller must hold c.mu
+func (c *FilterCache) removeLocked(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*filterCacheEntry)
+	c.bytes -= entry.size
+
+	filters := c.segments[entry.segment.Key]
+	delete(filters, entry.filter)
+	if len(filters) == 0 {
+		delete(c.segments, entry.segment.Key)
+	}
+}
+
+// filterKey implements cacheableFilter
+func (q *TermQuery) filterKey() (string, bool) {
+	return fmt.Sprintf("term %q %q", q.Field, q.Value), true
+}
+
+// filterKey implements cacheableFilter
+func (q *RangeQuery) filterKey() (string, bool) {
+	return fmt.Sprintf("range %q %s", q.Field, rangeBoundsKey(q.Range.GT, q.Range.GTE, q.Range.LT, q.Range.LTE)), true
+}
+
+// filterKey implements cacheableFilter: ranges relative to now aren't cached,
+// as their result changes over time
+func (q *DateRangeQuery) filterKey() (string, bool) {
+	for _, bound := range []string{q.Range.GT, q.Range.GTE, q.Range.LT, q.Range.LTE} {
+		if strings.Contains(bound, "now") {
+			return "", false
+		}
+	}
+	return fmt.Sprintf("date_range %q %q %q %q %q", q.Field, q.Range.GT, q.Range.GTE, q.Range.LT, q.Range.LTE), true
+}
+
+// filterKey implements cacheableFilter
+func (q *GeoDistanceQuery) filterKey() (string, bool) {
+	return fmt.Sprintf("geo_distance %q %v %v %v", q.Field, q.Lat, q.Lon, q.Distance), true
+}
+
+// filterKey implements cacheableFilter
+func (q *GeoBoundingBoxQuery) filterKey() (string, bool) {
+	return fmt.Sprintf("geo_bounding_box %q %v %v %v %v", q.Field, q.Box.Top, q.Box.Left, q.Box.Bottom, q.Box.Right), true
+}
+
+// rangeBoundsKey formats the bounds of a numeric range, "-" for open ones
+func rangeBoundsKey(bounds ...*float64) string {
+	parts := make([]string, len(bounds))
+	for i, bound := range bounds {
+		parts[i] = "-"
+		if bound != nil {
+			parts[i] = fmt.Sprint(*bound)
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,120 @@
+package search
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// formatMaxItems is how many elements of a long slice FormatQuery writes
+const formatMaxItems = 8
+
+// FormatQuery renders a parsed query compactly for logs, e.g.
+// bool(must=[match(field="title", query="quick fox")], filter=[term(field="tag", value="a")])
+// Each query is written as its type and exported, non-zero fields; long
+// slices (e.g. kNN vectors) are cut short
+func FormatQuery(q Query) string {
+	if q == nil {
+		return "match_all()"
+	}
+	var b strings.Builder
+	formatValue(&b, reflect.ValueOf(q))
+	return b.String()
+}
+
+// formatValue writes one value of a query
+func formatValue(b *strings.Builder, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			b.WriteString("nil")
+			return
+		}
+		formatValue(b, v.Elem())
+	case reflect.Struct:
+		formatStruct(b, v)
+	case reflect.Slice, reflect.Array:
+		b.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if i == formatMaxItems {
+				fmt.Fprintf(b, "... %d more", v.Len()-i)
+				break
+			}
+			formatValue(b, v.Index(i))
+		}
+		b.WriteByte(']')
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		b.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			formatValue(b, key)
+			b.WriteByte(':')
+			formatValue(b, v.MapIndex(key))
+		}
+		b.WriteByte('}')
+	case reflect.String:
+		b.WriteString(strconv.Quote(v.String()))
+	default:
+		if v.CanInterface() {
+			fmt.Fprint(b, v.Interface())
+		}
+	}
+}
+
+// formatStruct writes a struct as name(field=value, ...), named after its type
+// with any Query suffix removed
+func formatStruct(b *strings.Builder, v reflect.Value) {
+	t := v.Type()
+	if t.PkgPath() != "nano-elastic/internal/search" && v.CanInterface() {
+		if stringer, ok := v.Interface().(fmt.Stringer); ok {
+			b.WriteString(stringer.String())
+			return
+		}
+	}
+	b.WriteString(snakeCase(strings.TrimSuffix(t.Name(), "Query")))
+	b.WriteByte('(')
+	first := true
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || v.Field(i).IsZero() {
+			continue
+		}
+		if !first {
+			b.WriteString(", ")
+		}
+		first = false
+		b.WriteString(snakeCase(field.Name) + "=")
+		formatValue(b, v.Field(i))
+	}
+	b.WriteByte(')')
+}
+
+// snakeCase converts a Go identifier such as MinimumShouldMatch to minimum_should_match
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// A new word starts at an upper case letter after a lower case
+			// one, or at the last letter of an acronym (e.g. "KNNQuery")
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
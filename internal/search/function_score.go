@@ -0,0 +1,613 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"time"
+
+	"nano-elastic/internal/index/bitmap"
+	"nano-elastic/internal/index/numeric"
+	"nano-elastic/internal/types"
+)
+
+// ScoreFunction computes a score factor for a document of a FunctionScoreQuery
+type ScoreFunction interface {
+	// Prepare returns the function's scorer for the documents of a reader
+	Prepare(r *Reader) (func(id string) (float64, error), error)
+}
+
+// ScoreFunc adapts a Go function to a ScoreFunction, for custom scoring
+type ScoreFunc func(r *Reader, id string) (float64, error)
+
+// Prepare implements ScoreFunction
+func (f ScoreFunc) Prepare(r *Reader) (func(id string) (float64, error), error) {
+	return func(id string) (float64, error) {
+		return f(r, id)
+	}, nil
+}
+
+// FunctionClause is one function of a FunctionScoreQuery
+type FunctionClause struct {
+	Filter   Query         // Documents the function applies to; nil for all of them
+	Function ScoreFunction // nil scores just Weight
+	Weight   float64       // Multiplies the function's score; 0 means 1
+}
+
+// FunctionScoreMode combines the scores of the functions applying to a document
+type FunctionScoreMode string
+
+const (
+	ScoreModeMultiply FunctionScoreMode = "multiply" // Default
+	ScoreModeSum      FunctionScoreMode = "sum"
+	ScoreModeAvg      FunctionScoreMode = "avg"
+	ScoreModeFirst    FunctionScoreMode = "first" // Score of the first function whose filter matches
+	ScoreModeMax      FunctionScoreMode = "max"
+	ScoreModeMin      FunctionScoreMode = "min"
+)
+
+// BoostMode combines the query score with the combined function score
+type BoostMode string
+
+const (
+	BoostModeMultiply BoostMode = "multiply" // Default
+	BoostModeReplace  BoostMode = "replace"  // Only the function score counts
+	BoostModeSum      BoostMode = "sum"
+	BoostModeAvg      BoostMode = "avg"
+	BoostModeMax      BoostMode = "max"
+	BoostModeMin      BoostMode = "min"
+)
+
+// FunctionScoreQuery rescores the matches of Query with functions of their
+// field values, e.g. to favor highly rated or recent documents
+// Documents no function applies to get a function score of 1
+type FunctionScoreQuery struct {
+	Query     Query // nil matches every document
+	Functions []FunctionClause
+	ScoreMode FunctionScoreMode // ScoreModeMultiply if empty
+	BoostMode BoostMode         // BoostModeMultiply if empty
+	MaxBoost  float64           // Cap on the combined function score; 0 for none
+	MinScore  float64           // Documents scoring less are dropped
+}
+
+// Execute implements Query
+func (q *FunctionScoreQuery) Execute(r *Reader) (Matches, error) {
+	query := q.Query
+	if query == nil {
+		query = &MatchAllQuery{}
+	}
+	matches, err := query.Execute(r)
+	if err != nil {
+		return nil, err
+	}
+
+	type preparedFunction struct {
+		filter *bitmap.Bitmap
+		score  func(id string) (float64, error)
+		weight float64
+	}
+	functions := make([]preparedFunction, 0, len(q.Functions))
+	for _, fc := range q.Functions {
+		pf := preparedFunction{weight: fc.Weight}
+		if pf.weight == 0 {
+			pf.weight = 1.0
+		}
+		if fc.Filter != nil {
+			if pf.filter, err = FilterDocs(r, fc.Filter); err != nil {
+				return nil, err
+			}
+		}
+		if fc.Function != nil {
+			if pf.score, err = fc.Function.Prepare(r); err != nil {
+				return nil, err
+			}
+		}
+		functions = append(functions, pf)
+	}
+
+	for id, score := range matches {
+		var scores []float64
+		for _, pf := range functions {
+			if pf.filter != nil {
+				ord, ok := r.Ordinals.Ordinal(id)
+				if !ok || !pf.filter.Contains(ord) {
+					continue
+				}
+			}
+			s := pf.weight
+			if pf.score != nil {
+				factor, err := pf.score(id)
+				if err != nil {
+					return nil, err
+				}
+				s *= factor
+			}
+			scores = append(scores, s)
+			if q.ScoreMode == ScoreModeFirst {
+				break
+			}
+		}
+
+		functionScore := combineFunctionScores(q.ScoreMode, scores)
+		if q.MaxBoost > 0 && functionScore > q.MaxBoost {
+			functionScore = q.MaxBoost
+		}
+		score = applyBoostMode(q.BoostMode, score, functionScore)
+		if score < q.MinScore {
+			delete(matches, id)
+			continue
+		}
+		matches[id] = score
+	}
+	return matches, nil
+}
+
+// MatchDocs implements DocMatcher: without a minimum score, the functions don't
+// change which documents match
+func (q *FunctionScoreQuery) MatchDocs(r *Reader) (*bitmap.Bitmap, error) {
+	if q.MinScore > 0 {
+		m, err := q.Execute(r)
+		if err != nil {
+			return nil, err
+		}
+		return r.docSet(m), nil
+	}
+	if q.Query == nil {
+		return r.allowed(), nil
+	}
+	return FilterDocs(r, q.Query)
+}
+
+// combineFunctionScores combines the scores of the functions applying to a
+// document, 1 if none does
+func combineFunctionScores(mode FunctionScoreMode, scores []float64) float64 {
+	if len(scores) == 0 {
+		return 1.0
+	}
+	combined := scores[0]
+	for _, s := range scores[1:] {
+		switch mode {
+		case ScoreModeSum, ScoreModeAvg:
+			combined += s
+		case ScoreModeMax:
+			combined = math.Max(combined, s)
+		case ScoreModeMin:
+			combined = math.Min(combined, s)
+		default:
+			combined *= s
+		}
+	}
+	if mode == ScoreModeAvg {
+		combined /= float64(len(scores))
+	}
+	return combined
+}
+
+// applyBoostMode combines a query score with a function score
+func applyBoostMode(mode BoostMode, queryScore, functionScore float64) float64 {
+	switch mode {
+	case BoostModeReplace:
+		return functionScore
+	case BoostModeSum:
+		return queryScore + functionScore
+	case BoostModeAvg:
+		return (queryScore + functionScore) / 2
+	case BoostModeMax:
+		return math.Max(queryScore, functionScore)
+	case BoostModeMin:
+		return math.Min(queryScore, functionScore)
+	}
+	return queryScore * functionScore
+}
+
+// FieldValueModifier transforms a field value in FieldValueFactor
+type FieldValueModifier string
+
+const (
+	ModifierNone       FieldValueModifier = "none"
+	ModifierLog        FieldValueModifier = "log"   // log10(v)
+	ModifierLog1p      FieldValueModifier = "log1p" // log10(1 + v)
+	ModifierLog2p      FieldValueModifier = "log2p" // log10(2 + v)
+	ModifierLn         FieldValueModifier = "ln"
+	ModifierLn1p       FieldValueModifier = "ln1p"
+	ModifierLn2p       FieldValueModifier = "ln2p"
+	ModifierSquare     FieldValueModifier = "square"
+	ModifierSqrt       FieldValueModifier = "sqrt"
+	ModifierReciprocal FieldValueModifier = "reciprocal" // 1 / v
+)
+
+// modifiers maps each modifier to its function
+var modifiers = map[FieldValueModifier]func(float64) float64{
+	ModifierNone:       func(v float64) float64 { return v },
+	ModifierLog:        math.Log10,
+	ModifierLog1p:      func(v float64) float64 { return math.Log10(1 + v) },
+	ModifierLog2p:      func(v float64) float64 { return math.Log10(2 + v) },
+	ModifierLn:         math.Log,
+	ModifierLn1p:       math.Log1p,
+	ModifierLn2p:       func(v float64) float64 { return math.Log(2 + v) },
+	ModifierSquare:     func(v float64) float64 { return v * v },
+	ModifierSqrt:       math.Sqrt,
+	ModifierReciprocal: func(v float64) float64 { return 1 / v },
+}
+
+// FieldValueFactor scores documents by a numeric field: modifier(factor * value)
+// e.g. log1p of a rating, so higher rated documents rank higher
+type FieldValueFactor struct {
+	Field    string
+	Factor   float64            // 0 means 1
+	Modifier FieldValueModifier // ModifierNone if empty
+	Missing  *float64           // Value of documents without the field; they score 1 if nil
+}
+
+// Prepare implements ScoreFunction
+func (f *FieldValueFactor) Prepare(r *Reader) (func(id string) (float64, error), error) {
+	modifier := ModifierNone
+	if f.Modifier != "" {
+		modifier = f.Modifier
+	}
+	apply, ok := modifiers[modifier]
+	if !ok {
+		return nil, fmt.Errorf("unknown field_value_factor modifier [%s]", f.Modifier)
+	}
+	factor := f.Factor
+	if factor == 0 {
+		factor = 1.0
+	}
+
+	return func(id string) (float64, error) {
+		value, ok := r.DocValues.Get(f.Field, id)
+		var v float64
+		switch {
+		case ok:
+			v = value.Num
+		case f.Missing != nil:
+			v = *f.Missing
+		default:
+			return 1.0, nil
+		}
+
+		score := apply(factor * v)
+		if math.IsNaN(score) || math.IsInf(score, 0) || score < 0 {
+			return 0, fmt.Errorf("field_value_factor on field %s gave an invalid score for document %s: %s(%g)", f.Field, id, modifier, factor*v)
+		}
+		return score, nil
+	}, nil
+}
+
+// DecayType is the shape of a DecayFunction's curve
+type DecayType string
+
+const (
+	DecayGauss  DecayType = "gauss"
+	DecayLinear DecayType = "linear"
+	DecayExp    DecayType = "exp"
+)
+
+// DecayFunction scores documents by the distance of a numeric or date field
+// from an origin: 1 within Offset of it, falling to Decay at Offset + Scale
+// Dates are in epoch milliseconds, so Scale and Offset of date fields are too
+type DecayFunction struct {
+	Type   DecayType
+	Field  string
+	Origin string  // A number, or for date fields a date or date math such as "now-1d" (default "now")
+	Scale  float64 // Distance past Offset at which the score is Decay; must be > 0
+	Offset float64
+	Decay  float64 // Between 0 and 1 exclusive; 0 means 0.5
+}
+
+// Prepare implements ScoreFunction
+func (f *DecayFunction) Prepare(r *Reader) (func(id string) (float64, error), error) {
+	if f.Scale <= 0 {
+		return nil, fmt.Errorf("%s decay on field %s requires a positive scale", f.Type, f.Field)
+	}
+	decay := f.Decay
+	if decay == 0 {
+		decay = 0.5
+	}
+	if decay <= 0 || decay >= 1 {
+		return nil, fmt.Errorf("%s decay on field %s must be between 0 and 1, got %g", f.Type, f.Field, decay)
+	}
+	origin, err := f.origin(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var curve func(distance float64) float64
+	switch f.Type {
+	case DecayGauss:
+		sigmaSquared := -f.Scale * f.Scale / (2 * math.Log(decay))
+		curve = func(distance float64) float64 { return math.Exp(-distance * distance / (2 * sigmaSquared)) }
+	case DecayExp:
+		lambda := math.Log(decay) / f.Scale
+		curve = func(distance float64) float64 { return math.Exp(lambda * distance) }
+	case DecayLinear:
+		s := f.Scale / (1 - decay)
+		curve = func(distance float64) float64 { return math.Max(0, (s-distance)/s) }
+	default:
+		return nil, fmt.Errorf("unknown decay function [%s]", f.Type)
+	}
+
+	return func(id string) (float64, error) {
+		value, ok := r.DocValues.Get(f.Field, id)
+		if !ok {
+			return 1.0, nil
+		}
+		distance := math.Max(0, math.Abs(value.Num-origin)-f.Offset)
+		return curve(distance), nil
+	}, nil
+}
+
+// origin resolves the origin by the field's type
+func (f *DecayFunction) origin(r *Reader) (float64, error) {
+	if fieldType, _ := r.fieldType(f.Field); fieldType == types.FieldTypeDate {
+		expr := f.Origin
+		if expr == "" {
+			expr = "now"
+		}
+		def := r.Schema.Fields[f.Field]
+		t, err := numeric.ParseDateMath(expr, time.Now(), def.DateFormatsOrDefault(), false)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s decay origin %q for field %s: %w", f.Type, f.Origin, f.Field, err)
+		}
+		return numeric.DateToMillis(t), nil
+	}
+
+	origin, err := strconv.ParseFloat(f.Origin, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s decay origin %q for field %s: must be a number", f.Type, f.Origin, f.Field)
+	}
+	return origin, nil
+}
+
+// RandomScore scores documents uniformly in [0, 1), the same for a document
+// and Seed every time, e.g. to shuffle results consistently across pages
+type RandomScore struct {
+	Seed int64
+}
+
+// Prepare implements ScoreFunction
+func (f *RandomScore) Prepare(r *Reader) (func(id string) (float64, error), error) {
+	return func(id string) (float64, error) {
+		h := fnv.New64a()
+		h.Write([]byte(id))
+		// FNV barely changes its high bits between similar IDs, so they are
+		// mixed (splitmix64's finalizer) before scaling to [0, 1)
+		x := h.Sum64() ^ uint64(f.Seed)
+		x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+		x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+		x ^= x >> 31
+		return float64(x>>11) / (1 << 53), nil
+	}, nil
+}
+
+// parseFunctionScoreDSL decodes
+//
+//	{"query": {...}, "functions": [
+//	   {"filter": {...}, "weight": 2},
+//	   {"field_value_factor": {"field": "rating", "factor": 1.2, "modifier": "log1p", "missing": 1}},
+//	   {"gauss": {"published": {"origin": "now", "scale": "30d", "offset": "1d", "decay": 0.5}}},
+//	   {"random_score": {"seed": 42}}],
+//	 "score_mode": "sum", "boost_mode": "multiply", "max_boost": 10, "min_score": 1}
+//
+// A single function may also be given at the top level, without "functions"
+func parseFunctionScoreDSL(body json.RawMessage) (Query, error) {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, fmt.Errorf("[function_score] must be an object: %w", err)
+	}
+
+	q := &FunctionScoreQuery{}
+	topLevel := make(map[string]json.RawMessage)
+	for key, raw := range params {
+		var err error
+		switch key {
+		case "query":
+			q.Query, err = ParseQueryDSL(raw)
+		case "functions":
+			var list []json.RawMessage
+			if err := json.Unmarshal(raw, &list); err != nil {
+				return nil, fmt.Errorf("[function_score] functions must be an array")
+			}
+			for _, item := range list {
+				fc, err := parseFunctionClauseDSL(item)
+				if err != nil {
+					return nil, err
+				}
+				q.Functions = append(q.Functions, fc)
+			}
+		case "score_mode":
+			var mode string
+			json.Unmarshal(raw, &mode)
+			q.ScoreMode = FunctionScoreMode(mode)
+			switch q.ScoreMode {
+			case ScoreModeMultiply, ScoreModeSum, ScoreModeAvg, ScoreModeFirst, ScoreModeMax, ScoreModeMin:
+			default:
+				err = fmt.Errorf("[function_score] unknown score_mode %s", raw)
+			}
+		case "boost_mode":
+			var mode string
+			json.Unmarshal(raw, &mode)
+			q.BoostMode = BoostMode(mode)
+			switch q.BoostMode {
+			case BoostModeMultiply, BoostModeReplace, BoostModeSum, BoostModeAvg, BoostModeMax, BoostModeMin:
+			default:
+				err = fmt.Errorf("[function_score] unknown boost_mode %s", raw)
+			}
+		case "max_boost":
+			if err = json.Unmarshal(raw, &q.MaxBoost); err != nil || q.MaxBoost < 0 {
+				err = fmt.Errorf("[function_score] max_boost must be a non-negative number, got %s", raw)
+			}
+		case "min_score":
+			if err = json.Unmarshal(raw, &q.MinScore); err != nil {
+				err = fmt.Errorf("[function_score] min_score must be a number, got %s", raw)
+			}
+		case "boost":
+			// Applied below
+		default:
+			topLevel[key] = raw
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(topLevel) > 0 {
+		if len(q.Functions) > 0 {
+			return nil, fmt.Errorf("[function_score] takes [functions] or a single function, not both")
+		}
+		data, _ := json.Marshal(topLevel)
+		fc, err := parseFunctionClauseDSL(data)
+		if err != nil {
+			return nil, err
+		}
+		q.Functions = []FunctionClause{fc}
+	}
+	return withBoost("function_score", q, params)
+}
+
+// parseFunctionClauseDSL decodes one function: an optional filter and weight,
+// and at most one of field_value_factor, gauss, linear, exp and random_score
+func parseFunctionClauseDSL(data []byte) (FunctionClause, error) {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(data, &params); err != nil {
+		return FunctionClause{}, fmt.Errorf("[function_score] functions must be objects: %w", err)
+	}
+
+	var fc FunctionClause
+	for key, raw := range params {
+		var fn ScoreFunction
+		var err error
+		switch key {
+		case "filter":
+			fc.Filter, err = ParseQueryDSL(raw)
+		case "weight":
+			if err = json.Unmarshal(raw, &fc.Weight); err != nil || fc.Weight <= 0 {
+				err = fmt.Errorf("[function_score] weight must be a positive number, got %s", raw)
+			}
+		case "field_value_factor":
+			fn, err = parseFieldValueFactorDSL(raw)
+		case string(DecayGauss), string(DecayLinear), string(DecayExp):
+			fn, err = parseDecayDSL(DecayType(key), raw)
+		case "random_score":
+			var rs struct {
+				Seed *json.Number `json:"seed"`
+			}
+			if err = json.Unmarshal(raw, &rs); err != nil {
+				err = fmt.Errorf("[random_score] %w", err)
+				break
+			}
+			f := &RandomScore{Seed: time.Now().UnixNano()}
+			if rs.Seed != nil {
+				if f.Seed, err = rs.Seed.Int64(); err != nil {
+					err = fmt.Errorf("[random_score] seed must be an integer, got %s", *rs.Seed)
+				}
+			}
+			fn = f
+		default:
+			err = fmt.Errorf("[function_score] unknown function [%s]", key)
+		}
+		if err != nil {
+			return FunctionClause{}, err
+		}
+		if fn != nil {
+			if fc.Function != nil {
+				return FunctionClause{}, fmt.Errorf("[function_score] a function takes only one of field_value_factor, gauss, linear, exp and random_score")
+			}
+			fc.Function = fn
+		}
+	}
+	if fc.Function == nil && fc.Weight == 0 {
+		return FunctionClause{}, fmt.Errorf("[function_score] a function requires a weight or a score function")
+	}
+	return fc, nil
+}
+
+func parseFieldValueFactorDSL(raw json.RawMessage) (ScoreFunction, error) {
+	var params struct {
+		Field    string   `json:"field"`
+		Factor   *float64 `json:"factor"`
+		Modifier string   `json:"modifier"`
+		Missing  *float64 `json:"missing"`
+	}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, fmt.Errorf("[field_value_factor] %w", err)
+	}
+	if params.Field == "" {
+		return nil, fmt.Errorf("[field_value_factor] requires [field]")
+	}
+	if _, ok := modifiers[FieldValueModifier(params.Modifier)]; params.Modifier != "" && !ok {
+		return nil, fmt.Errorf("[field_value_factor] unknown modifier [%s]", params.Modifier)
+	}
+
+	f := &FieldValueFactor{Field: params.Field, Modifier: FieldValueModifier(params.Modifier), Missing: params.Missing}
+	if params.Factor != nil {
+		if *params.Factor == 0 {
+			return nil, fmt.Errorf("[field_value_factor] factor must not be 0")
+		}
+		f.Factor = *params.Factor
+	}
+	return f, nil
+}
+
+// parseDecayDSL decodes {"field": {"origin": ..., "scale": ..., "offset": ..., "decay": 0.5}}
+// Scale and offset are numbers, or durations such as "7d" for date fields
+func parseDecayDSL(decayType DecayType, body json.RawMessage) (ScoreFunction, error) {
+	field, raw, err := singleKey(body, string(decayType))
+	if err != nil {
+		return nil, err
+	}
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, fmt.Errorf("[%s] field %s must be an object", decayType, field)
+	}
+
+	f := &DecayFunction{Type: decayType, Field: field}
+	for key, value := range params {
+		switch key {
+		case "origin":
+			origin, ok := scalarString(value)
+			if !ok {
+				return nil, fmt.Errorf("[%s] origin must be a number or a date", decayType)
+			}
+			f.Origin = origin
+		case "scale", "offset":
+			distance, err := parseDistance(value)
+			if err != nil {
+				return nil, fmt.Errorf("[%s] invalid %s %s: must be a number or a duration such as 7d", decayType, key, value)
+			}
+			if key == "scale" {
+				f.Scale = distance
+			} else {
+				f.Offset = distance
+			}
+		case "decay":
+			if err := json.Unmarshal(value, &f.Decay); err != nil || f.Decay <= 0 || f.Decay >= 1 {
+				return nil, fmt.Errorf("[%s] decay must be between 0 and 1 exclusive, got %s", decayType, value)
+			}
+		default:
+			return nil, fmt.Errorf("[%s] unknown parameter [%s]", decayType, key)
+		}
+	}
+	if f.Scale <= 0 {
+		return nil, fmt.Errorf("[%s] requires a positive [scale]", decayType)
+	}
+	return f, nil
+}
+
+// parseDistance reads a decay distance: a number, or a duration in milliseconds
+func parseDistance(raw json.RawMessage) (float64, error) {
+	var n float64
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return n, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, err
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return n, nil
+	}
+	return parseDurationMillis(s)
+}
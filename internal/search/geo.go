@@ -0,0 +1,120 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"nano-elastic/internal/index/geo"
+	"nano-elastic/internal/types"
+)
+
+// GeoDistanceQuery matches documents whose geo-point field lies within a
+// distance of a center point. All matches score 1
+type GeoDistanceQuery struct {
+	Field    string
+	Lat      float64
+	Lon      float64
+	Distance float64 // Meters
+}
+
+// Execute implements Query
+func (q *GeoDistanceQuery) Execute(r *Reader) (Matches, error) {
+	if err := (types.GeoPointValue{Lat: q.Lat, Lon: q.Lon}).Validate(); err != nil {
+		return nil, fmt.Errorf("invalid geo_distance center: %w", err)
+	}
+	if q.Distance < 0 {
+		return nil, fmt.Errorf("geo_distance distance must be >= 0, got %v", q.Distance)
+	}
+	return constantScore(r.Geo.SearchDistance(q.Field, q.Lat, q.Lon, q.Distance), 1.0), nil
+}
+
+// GeoBoundingBoxQuery matches documents whose geo-point field lies inside a box
+// All matches score 1
+type GeoBoundingBoxQuery struct {
+	Field string
+	Box   geo.BoundingBox
+}
+
+// Execute implements Query
+func (q *GeoBoundingBoxQuery) Execute(r *Reader) (Matches, error) {
+	if err := q.Box.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid geo_bounding_box on field %s: %w", q.Field, err)
+	}
+	return constantScore(r.Geo.SearchBoundingBox(q.Field, q.Box), 1.0), nil
+}
+
+// parseGeoDistanceDSL decodes {"distance": "10km", "location": {"lat": 52.37, "lon": 4.89}}
+func parseGeoDistanceDSL(body json.RawMessage) (Query, error) {
+	var params map[string]interface{}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, fmt.Errorf("[geo_distance] must be an object: %w", err)
+	}
+
+	q := &GeoDistanceQuery{}
+	haveDistance := false
+	for key, value := range params {
+		if key == "distance" {
+			s, ok := value.(string)
+			if n, isNum := value.(float64); isNum {
+				s, ok = fmt.Sprint(n), true
+			}
+			if !ok {
+				return nil, fmt.Errorf("[geo_distance] [distance] must be a string or number")
+			}
+			meters, err := geo.ParseDistance(s)
+			if err != nil {
+				return nil, fmt.Errorf("[geo_distance] %w", err)
+			}
+			q.Distance, haveDistance = meters, true
+			continue
+		}
+
+		if q.Field != "" {
+			return nil, fmt.Errorf("[geo_distance] expects one field, got %s and %s", q.Field, key)
+		}
+		p, err := types.ParseGeoPoint(value)
+		if err != nil {
+			return nil, fmt.Errorf("[geo_distance] field %s: %w", key, err)
+		}
+		q.Field, q.Lat, q.Lon = key, p.Lat, p.Lon
+	}
+
+	if !haveDistance {
+		return nil, fmt.Errorf("[geo_distance] requires [distance]")
+	}
+	if q.Field == "" {
+		return nil, fmt.Errorf("[geo_distance] requires a field with a center point")
+	}
+	return q, nil
+}
+
+// parseGeoBoundingBoxDSL decodes {"location": {"top_left": point, "bottom_right": point}}
+func parseGeoBoundingBoxDSL(body json.RawMessage) (Query, error) {
+	field, raw, err := singleKey(body, "geo_bounding_box")
+	if err != nil {
+		return nil, err
+	}
+	var corners map[string]interface{}
+	if err := json.Unmarshal(raw, &corners); err != nil {
+		return nil, fmt.Errorf("[geo_bounding_box] field %s must be an object", field)
+	}
+
+	topLeft, err := types.ParseGeoPoint(corners["top_left"])
+	if err != nil {
+		return nil, fmt.Errorf("[geo_bounding_box] [top_left]: %w", err)
+	}
+	bottomRight, err := types.ParseGeoPoint(corners["bottom_right"])
+	if err != nil {
+		return nil, fmt.Errorf("[geo_bounding_box] [bottom_right]: %w", err)
+	}
+	if len(corners) != 2 {
+		return nil, fmt.Errorf("[geo_bounding_box] expects only [top_left] and [bottom_right]")
+	}
+
+	return &GeoBoundingBoxQuery{Field: field, Box: geo.BoundingBox{
+		Top:    topLeft.Lat,
+		Left:   topLeft.Lon,
+		Bottom: bottomRight.Lat,
+		Right:  bottomRight.Lon,
+	}}, nil
+}
@@ -0,0 +1,426 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"nano-elastic/internal/analyzer"
+	"nano-elastic/internal/index/inverted"
+	"nano-elastic/internal/types"
+)
+
+// Highlighting defaults, as in Elasticsearch
+const (
+	DefaultPreTag            = "<em>"
+	DefaultPostTag           = "</em>"
+	DefaultFragmentSize      = 100
+	DefaultNumberOfFragments = 5
+)
+
+// Highlight asks for snippets of the text fields of each returned hit, with
+// the terms matched by the query wrapped in tags
+type Highlight struct {
+	Fields  []HighlightField // Empty highlights every text field
+	PreTag  string           // Default DefaultPreTag
+	PostTag string           // Default DefaultPostTag
+
+	FragmentSize      int // Approximate fragment length in bytes (default DefaultFragmentSize)
+	NumberOfFragments int // Maximum fragments per field (default DefaultNumberOfFragments); < 0 returns the whole value as one fragment
+}
+
+// HighlightField selects fields to highlight by name or '*' pattern
+// Zero options use the Highlight's
+type HighlightField struct {
+	Field             string
+	FragmentSize      int
+	NumberOfFragments int
+}
+
+// Validate checks the highlight options
+func (h *Highlight) Validate() error {
+	if h.FragmentSize < 0 {
+		return fmt.Errorf("highlight fragment_size must be >= 0, got %d", h.FragmentSize)
+	}
+	for _, f := range h.Fields {
+		if f.Field == "" {
+			return fmt.Errorf("highlight field name is empty")
+		}
+		if f.FragmentSize < 0 {
+			return fmt.Errorf("highlight fragment_size for %s must be >= 0, got %d", f.Field, f.FragmentSize)
+		}
+	}
+	return nil
+}
+
+// options returns the fragment size and count for a field, and whether it's highlighted
+func (h *Highlight) options(fieldName string) (size int, count int, ok bool) {
+	size, count = h.FragmentSize, h.NumberOfFragments
+	if len(h.Fields) > 0 {
+		for _, f := range h.Fields {
+			if f.Field == fieldName || inverted.WildcardMatch(f.Field, fieldName) {
+				if f.FragmentSize != 0 {
+					size = f.FragmentSize
+				}
+				if f.NumberOfFragments != 0 {
+					count = f.NumberOfFragments
+				}
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return 0, 0, false
+		}
+	}
+	if size == 0 {
+		size = DefaultFragmentSize
+	}
+	if count == 0 {
+		count = DefaultNumberOfFragments
+	}
+	return size, count, true
+}
+
+// tags returns the pre and post tags, applying the defaults
+func (h *Highlight) tags() (string, string) {
+	pre, post := h.PreTag, h.PostTag
+	if pre == "" {
+		pre = DefaultPreTag
+	}
+	if post == "" {
+		post = DefaultPostTag
+	}
+	return pre, post
+}
+
+// termMatcher reports whether an indexed term was matched by the query
+type termMatcher struct {
+	terms    map[string]bool
+	patterns []func(term string) bool
+}
+
+// matches reports whether the term is matched
+func (m *termMatcher) matches(term string) bool {
+	if m.terms[term] {
+		return true
+	}
+	for _, match := range m.patterns {
+		if match(term) {
+			return true
+		}
+	}
+	return false
+}
+
+// highlightTerms collects, per field, the terms a query looks for
+// Terms of must_not clauses are left out, since they never appear in a hit
+type highlightTerms map[string]*termMatcher
+
+// field returns the matcher of a field, creating it if needed
+func (ht highlightTerms) field(fieldName string) *termMatcher {
+	m, ok := ht[fieldName]
+	if !ok {
+		m = &termMatcher{terms: make(map[string]bool)}
+		ht[fieldName] = m
+	}
+	return m
+}
+
+// addTokens adds analyzed query tokens as terms of a field
+func (ht highlightTerms) addTokens(fieldName string, tokens []analyzer.Token) {
+	m := ht.field(fieldName)
+	for _, token := range tokens {
+		m.terms[token.Term] = true
+	}
+}
+
+// collect walks a query tree and adds the terms of its text queries
+func (ht highlightTerms) collect(r *Reader, q Query) {
+	switch q := q.(type) {
+	case *TermQuery:
+		ht.field(q.Field).terms[q.Value] = true
+	case *MatchQuery:
+		ht.addTokens(q.Field, r.Inverted.AnalyzeQuery(q.Field, q.Query))
+	case *MultiMatchQuery:
+		for _, wf := range q.Fields {
+			ht.addTokens(wf.Field, r.Inverted.AnalyzeQuery(wf.Field, q.Query))
+		}
+	case *PhraseQuery:
+		ht.addTokens(q.Field, r.Inverted.AnalyzeQuery(q.Field, q.Phrase))
+	case *PrefixQuery:
+		prefix := q.Prefix
+		ht.field(q.Field).patterns = append(ht.field(q.Field).patterns, func(term string) bool {
+			return strings.HasPrefix(term, prefix)
+		})
+	case *WildcardQuery:
+		pattern := q.Pattern
+		ht.field(q.Field).patterns = append(ht.field(q.Field).patterns, func(term string) bool {
+			return inverted.WildcardMatch(pattern, term)
+		})
+	case *FuzzyQuery:
+		maxEdits := q.MaxEdits
+		if maxEdits < 0 {
+			maxEdits = inverted.AutoFuzziness(q.Term)
+		}
+		m := ht.field(q.Field)
+		for _, fm := range r.Inverted.FuzzyTerms(q.Field, q.Term, maxEdits) {
+			m.terms[fm.Term] = true
+		}
+	case *BoolQuery:
+		for _, clauses := range [][]Query{q.Must, q.Should, q.Filter} {
+			for _, clause := range clauses {
+				ht.collect(r, clause)
+			}
+		}
+	case *BoostQuery:
+		ht.collect(r, q.Query)
+	case *FunctionScoreQuery:
+		if q.Query != nil {
+			ht.collect(r, q.Query)
+		}
+	case *HybridQuery:
+		for _, sub := range q.Queries {
+			ht.collect(r, sub)
+		}
+	case *QueryStringQuery:
+		qp := NewQueryParser(q.DefaultFields...)
+		qp.Schema = r.Schema
+		if parsed, err := qp.Parse(q.Query); err == nil {
+			ht.collect(r, parsed)
+		}
+	}
+}
+
+// HighlightDocument returns highlighted fragments of a document's text fields,
+// by field (dot paths for object subfields)
+// Fields without a matched term are left out; nil means nothing was highlighted
+func HighlightDocument(r *Reader, q Query, h *Highlight, doc *types.Document) map[string][]string {
+	if q == nil {
+		return nil
+	}
+	terms := make(highlightTerms)
+	terms.collect(r, q)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	pre, post := h.tags()
+	var result map[string][]string
+	for name, value := range doc.Flatten().Fields {
+		text, ok := value.(types.TextValue)
+		matcher := terms[name]
+		if !ok || matcher == nil {
+			continue
+		}
+		size, count, ok := h.options(name)
+		if !ok {
+			continue
+		}
+
+		var spans [][2]int
+		for _, token := range r.Inverted.AnalyzeText(name, text.Value) {
+			if matcher.matches(token.Term) {
+				spans = append(spans, [2]int{token.StartOffset, token.EndOffset})
+			}
+		}
+		if len(spans) == 0 {
+			continue
+		}
+
+		fragments := highlightFragments(text.Value, mergeSpans(spans), size, count, pre, post)
+		if result == nil {
+			result = make(map[string][]string)
+		}
+		result[name] = fragments
+	}
+	return result
+}
+
+// mergeSpans sorts byte ranges and merges overlapping ones, e.g. a term and
+// its synonym at the same offsets
+func mergeSpans(spans [][2]int) [][2]int {
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i][0] < spans[j][0]
+	})
+	merged := spans[:1]
+	for _, s := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if s[0] <= last[1] {
+			if s[1] > last[1] {
+				last[1] = s[1]
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// highlightFragments cuts up to count fragments of about size bytes around the
+// matched spans, in text order, and wraps each span in the tags
+// A negative count returns the whole text as one fragment
+func highlightFragments(text string, spans [][2]int, size int, count int, pre string, post string) []string {
+	if count < 0 {
+		return []string{wrapSpans(text, 0, len(text), spans, pre, post)}
+	}
+
+	var fragments []string
+	prevEnd := 0
+	for i := 0; i < len(spans) && len(fragments) < count; {
+		// Start a little before the first span, so it has some leading context,
+		// but not inside the previous fragment
+		start := wordStart(text, spans[i][0]-size/4)
+		if start < prevEnd {
+			start = prevEnd
+		}
+		end := spans[i][1]
+		if start+size > end {
+			end = wordEnd(text, start+size)
+		}
+
+		// Take every span starting in the fragment, extending it to their ends
+		j := i
+		for j < len(spans) && spans[j][0] < end {
+			j++
+		}
+		if spans[j-1][1] > end {
+			end = spans[j-1][1]
+		}
+		prevEnd = end
+
+		fragment := strings.TrimSpace(wrapSpans(text, start, end, spans[i:j], pre, post))
+		fragments = append(fragments, fragment)
+		i = j
+	}
+	return fragments
+}
+
+// wrapSpans returns text[start:end] with each span wrapped in the tags
+func wrapSpans(text string, start int, end int, spans [][2]int, pre string, post string) string {
+	var b strings.Builder
+	pos := start
+	for _, s := range spans {
+		b.WriteString(text[pos:s[0]])
+		b.WriteString(pre)
+		b.WriteString(text[s[0]:s[1]])
+		b.WriteString(post)
+		pos = s[1]
+	}
+	b.WriteString(text[pos:end])
+	return b.String()
+}
+
+// wordStart moves an offset back to the start of the word it falls in
+func wordStart(text string, offset int) int {
+	if offset <= 0 {
+		return 0
+	}
+	for offset > 0 && !isBreak(text[offset-1]) {
+		offset--
+	}
+	return offset
+}
+
+// wordEnd moves an offset forward to the end of the word it falls in
+func wordEnd(text string, offset int) int {
+	if offset >= len(text) {
+		return len(text)
+	}
+	for offset < len(text) && !isBreak(text[offset]) {
+		offset++
+	}
+	return offset
+}
+
+// isBreak reports whether a byte separates words
+// Bytes of multi-byte UTF-8 characters never do, so fragments stay valid UTF-8
+func isBreak(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// parseHighlightDSL decodes
+//
+//	{"pre_tags": ["<b>"], "post_tags": ["</b>"], "fragment_size": 150,
+//	 "number_of_fragments": 3, "fields": {"title": {}, "body": {"number_of_fragments": 0}}}
+//
+// number_of_fragments 0 returns the whole value; fields may also be a list of
+// single-field objects to keep their order
+func parseHighlightDSL(body json.RawMessage) (*Highlight, error) {
+	var params struct {
+		PreTags           []string        `json:"pre_tags"`
+		PostTags          []string        `json:"post_tags"`
+		FragmentSize      int             `json:"fragment_size"`
+		NumberOfFragments *int            `json:"number_of_fragments"`
+		Fields            json.RawMessage `json:"fields"`
+	}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, fmt.Errorf("[highlight] invalid parameters: %w", err)
+	}
+
+	count, err := fragmentCount(params.NumberOfFragments)
+	if err != nil {
+		return nil, err
+	}
+	h := &Highlight{FragmentSize: params.FragmentSize, NumberOfFragments: count}
+	if len(params.PreTags) > 0 {
+		h.PreTag = params.PreTags[0]
+	}
+	if len(params.PostTags) > 0 {
+		h.PostTag = params.PostTags[0]
+	}
+
+	type fieldOptions struct {
+		FragmentSize      int  `json:"fragment_size"`
+		NumberOfFragments *int `json:"number_of_fragments"`
+	}
+	var byName map[string]fieldOptions
+	var list []map[string]fieldOptions
+	if len(params.Fields) > 0 {
+		if err := json.Unmarshal(params.Fields, &list); err != nil {
+			if err := json.Unmarshal(params.Fields, &byName); err != nil {
+				return nil, fmt.Errorf("[highlight] [fields] must be an object or an array of objects")
+			}
+			names := make([]string, 0, len(byName))
+			for name := range byName {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				list = append(list, map[string]fieldOptions{name: byName[name]})
+			}
+		}
+	}
+	for _, entry := range list {
+		for name, opts := range entry {
+			count, err := fragmentCount(opts.NumberOfFragments)
+			if err != nil {
+				return nil, err
+			}
+			h.Fields = append(h.Fields, HighlightField{
+				Field:             name,
+				FragmentSize:      opts.FragmentSize,
+				NumberOfFragments: count,
+			})
+		}
+	}
+
+	if err := h.Validate(); err != nil {
+		return nil, fmt.Errorf("[highlight] %w", err)
+	}
+	return h, nil
+}
+
+// fragmentCount converts number_of_fragments, where 0 means the whole value
+func fragmentCount(n *int) (int, error) {
+	switch {
+	case n == nil:
+		return 0, nil
+	case *n == 0:
+		return -1, nil
+	case *n < 0:
+		return 0, fmt.Errorf("[highlight] number_of_fragments must be >= 0, got %d", *n)
+	}
+	return *n, nil
+}
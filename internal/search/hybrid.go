@@ -0,0 +1,155 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Fusion is a method for combining the ranked results of several queries
+type Fusion string
+
+const (
+	// FusionRRF scores a document by reciprocal rank fusion: the sum over the
+	// queries that matched it of 1 / (RankConstant + rank), with 1-based ranks
+	FusionRRF Fusion = "rrf"
+
+	// FusionLinear scores a document by the weighted sum of its scores, each
+	// min-max normalized to [0, 1] within its query's results
+	FusionLinear Fusion = "linear"
+)
+
+// DefaultRankConstant is the RRF rank constant used when RankConstant is 0
+const DefaultRankConstant = 60
+
+// HybridQuery runs several queries, typically a text query and a knn query,
+// and fuses their results into a single ranking. A document matches if any
+// query matches it
+type HybridQuery struct {
+	Queries      []Query
+	Fusion       Fusion    // Empty uses FusionRRF
+	RankConstant int       // RRF only (0 uses DefaultRankConstant)
+	Weights      []float64 // One per query (nil weighs every query 1)
+}
+
+// Execute implements Query
+func (q *HybridQuery) Execute(r *Reader) (Matches, error) {
+	if len(q.Queries) == 0 {
+		return nil, fmt.Errorf("[hybrid] requires at least one query")
+	}
+	if q.Weights != nil && len(q.Weights) != len(q.Queries) {
+		return nil, fmt.Errorf("[hybrid] has %d weights for %d queries", len(q.Weights), len(q.Queries))
+	}
+	rankConstant := q.RankConstant
+	if rankConstant == 0 {
+		rankConstant = DefaultRankConstant
+	}
+	if rankConstant < 1 {
+		return nil, fmt.Errorf("[hybrid] rank_constant must be >= 1, got %d", rankConstant)
+	}
+
+	fused := make(Matches)
+	for i, sub := range q.Queries {
+		m, err := sub.Execute(r)
+		if err != nil {
+			return nil, err
+		}
+		weight := 1.0
+		if q.Weights != nil {
+			weight = q.Weights[i]
+		}
+
+		switch q.Fusion {
+		case "", FusionRRF:
+			for rank, id := range rankMatches(m) {
+				fused[id] += weight / float64(rankConstant+rank+1)
+			}
+		case FusionLinear:
+			for id, score := range normalizeScores(m) {
+				fused[id] += weight * score
+			}
+		default:
+			return nil, fmt.Errorf("[hybrid] unknown fusion [%s]", q.Fusion)
+		}
+	}
+	return fused, nil
+}
+
+// rankMatches returns the matching IDs from best to worst score (ties by ID)
+func rankMatches(m Matches) []string {
+	ids := make([]string, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if m[ids[i]] != m[ids[j]] {
+			return m[ids[i]] > m[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// normalizeScores min-max scales scores to [0, 1]
+// When every score is the same, each becomes 1
+func normalizeScores(m Matches) Matches {
+	first := true
+	var lo, hi float64
+	for _, score := range m {
+		if first || score < lo {
+			lo = score
+		}
+		if first || score > hi {
+			hi = score
+		}
+		first = false
+	}
+
+	normalized := make(Matches, len(m))
+	for id, score := range m {
+		if hi == lo {
+			normalized[id] = 1
+		} else {
+			normalized[id] = (score - lo) / (hi - lo)
+		}
+	}
+	return normalized
+}
+
+// parseHybridDSL decodes
+//
+//	{"queries": [{"match": {...}}, {"knn": {...}}], "fusion": "rrf", "rank_constant": 60, "weights": [1, 1]}
+func parseHybridDSL(body json.RawMessage) (Query, error) {
+	var params struct {
+		Queries      []json.RawMessage `json:"queries"`
+		Fusion       string            `json:"fusion"`
+		RankConstant int               `json:"rank_constant"`
+		Weights      []float64         `json:"weights"`
+	}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, fmt.Errorf("[hybrid] invalid parameters: %w", err)
+	}
+	if len(params.Queries) == 0 {
+		return nil, fmt.Errorf("[hybrid] requires [queries]")
+	}
+	if params.Weights != nil && len(params.Weights) != len(params.Queries) {
+		return nil, fmt.Errorf("[hybrid] has %d weights for %d queries", len(params.Weights), len(params.Queries))
+	}
+	fusion := Fusion(params.Fusion)
+	if fusion != "" && fusion != FusionRRF && fusion != FusionLinear {
+		return nil, fmt.Errorf("[hybrid] unknown fusion [%s]", params.Fusion)
+	}
+	if params.RankConstant < 0 {
+		return nil, fmt.Errorf("[hybrid] rank_constant must be >= 1, got %d", params.RankConstant)
+	}
+
+	hq := &HybridQuery{Fusion: fusion, RankConstant: params.RankConstant, Weights: params.Weights}
+	for _, raw := range params.Queries {
+		sub, err := ParseQueryDSL(raw)
+		if err != nil {
+			return nil, err
+		}
+		hq.Queries = append(hq.Queries, sub)
+	}
+	return hq, nil
+}
@@ -0,0 +1,77 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"nano-elastic/internal/index/vector"
+	"nano-elastic/internal/types"
+)
+
+// KnnQuery matches the K documents whose vector field is most similar to a
+// query vector, scored by the metric's similarity
+type KnnQuery struct {
+	Field  string
+	Vector []float32
+	K      int
+	Metric vector.Metric // Empty uses cosine
+}
+
+// Execute implements Query
+func (q *KnnQuery) Execute(r *Reader) (Matches, error) {
+	if r.Schema != nil {
+		def, ok := r.Schema.Fields[q.Field]
+		if !ok || def.Type != types.FieldTypeVector {
+			return nil, fmt.Errorf("[knn] field %s is not a vector field", q.Field)
+		}
+		if def.VectorDim != 0 && def.VectorDim != len(q.Vector) {
+			return nil, fmt.Errorf("[knn] query vector has dimension %d, field %s has %d", len(q.Vector), q.Field, def.VectorDim)
+		}
+	}
+
+	metric := q.Metric
+	if metric == "" {
+		metric = vector.MetricCosine
+	}
+	hits, err := r.Vectors.Search(q.Field, q.Vector, q.K, metric)
+	if err != nil {
+		return nil, fmt.Errorf("[knn] %w", err)
+	}
+	matches := make(Matches, len(hits))
+	for _, hit := range hits {
+		matches[hit.DocID] = hit.Score
+	}
+	return matches, nil
+}
+
+// parseKnnDSL decodes {"field": "embedding", "query_vector": [0.1, 0.2], "k": 10, "similarity": "cosine"}
+func parseKnnDSL(body json.RawMessage) (Query, error) {
+	var params struct {
+		Field       string    `json:"field"`
+		QueryVector []float32 `json:"query_vector"`
+		K           *int      `json:"k"`
+		Similarity  string    `json:"similarity"`
+	}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, fmt.Errorf("[knn] invalid parameters: %w", err)
+	}
+	if params.Field == "" {
+		return nil, fmt.Errorf("[knn] requires [field]")
+	}
+	if len(params.QueryVector) == 0 {
+		return nil, fmt.Errorf("[knn] requires [query_vector]")
+	}
+	k := DefaultSize
+	if params.K != nil {
+		k = *params.K
+	}
+	if k <= 0 {
+		return nil, fmt.Errorf("[knn] [k] must be > 0, got %d", k)
+	}
+	metric, err := vector.ParseMetric(params.Similarity)
+	if err != nil {
+		return nil, fmt.Errorf("[knn] %w", err)
+	}
+
+	return &KnnQuery{Field: params.Field, Vector: params.QueryVector, K: k, Metric: metric}, nil
+}
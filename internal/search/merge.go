@@ -0,0 +1,119 @@
+package search
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CollectShard runs a request against one of several readers searched together
+// (e.g. the indexes behind an alias) and returns every hit in sort order
+// Hits always carry their sort values, including the tiebreakers, so Merge can
+// order hits from different readers
+// A deadline (see Execute) returns an empty timed out response
+func CollectShard(r *Reader, req *Request) (*Response, error) {
+	r, cancel := req.withTimeout(r)
+	defer cancel()
+	if req.Profile {
+		r = r.withProfiler()
+	}
+
+	resp, err := collect(r, req, true)
+	if timedOut(err) {
+		resp, err = timedOutResponse(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.Suggest, err = suggestAll(r, req.Suggest); err != nil {
+		return nil, err
+	}
+	resp.Timings.Parse = req.ParseTime
+	if p := r.profiler.profile(resp.Timings); p != nil {
+		resp.Profile = []*Profile{p}
+	}
+	return resp, nil
+}
+
+// Merge combines the CollectShard responses of one request into the requested page
+// Hits are ordered by their sort values; ties across shards keep shard order
+// The request should already be validated
+func Merge(req *Request, shards []*Response) (*Response, error) {
+	fields := effectiveSort(req.Sort)
+
+	merged := &Response{TotalRelation: TotalEqual}
+	var hits []Hit
+	var keys []sortKey
+	for _, shard := range shards {
+		merged.Total += shard.Total
+		if shard.TotalRelation == TotalAtLeast {
+			merged.TotalRelation = TotalAtLeast
+		}
+		merged.TimedOut = merged.TimedOut || shard.TimedOut
+		if shard.MaxScore > merged.MaxScore {
+			merged.MaxScore = shard.MaxScore
+		}
+		aggs, err := mergeAggregations(merged.Aggregations, shard.Aggregations)
+		if err != nil {
+			return nil, err
+		}
+		merged.Aggregations = aggs
+		merged.Suggest = mergeSuggestions(merged.Suggest, shard.Suggest)
+		merged.Profile = append(merged.Profile, shard.Profile...)
+		for _, hit := range shard.Hits {
+			key, err := cursorKey(fields, hit.Sort)
+			if err != nil {
+				return nil, fmt.Errorf("failed to merge hit %s: %w", hit.ID, err)
+			}
+			hits = append(hits, hit)
+			keys = append(keys, key)
+		}
+	}
+
+	order := make([]int, len(hits))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return compareKeys(fields, keys[order[a]], keys[order[b]]) < 0
+	})
+
+	if req.Collapse != nil {
+		order, merged.TotalGroups = collapseOrder(req.Collapse, hits, order)
+	}
+
+	start := req.From
+	if start > len(order) {
+		start = len(order)
+	}
+	end := start + req.PageSize()
+	if end > len(order) {
+		end = len(order)
+	}
+
+	merged.Hits = make([]Hit, 0, end-start)
+	for _, i := range order[start:end] {
+		hit := hits[i]
+		if len(req.Sort) == 0 {
+			hit.Sort = nil // Only requested sorts report values, as in Execute
+		}
+		merged.Hits = append(merged.Hits, hit)
+	}
+	return merged, nil
+}
+
+// collapseOrder keeps the first of the ordered hits of each collapse value,
+// the shards having kept the best hit of each value of their own, and returns
+// them with the number of groups across every shard
+func collapseOrder(c *Collapse, hits []Hit, order []int) ([]int, int) {
+	seen := make(map[interface{}]struct{})
+	kept := order[:0]
+	for _, i := range order {
+		key := collapseKey(c, hits[i])
+		if _, dup := seen[key]; dup {
+			continue
+		}
+		seen[key] = struct{}{}
+		kept = append(kept, i)
+	}
+	return kept, len(seen)
+}
@@ -0,0 +1,77 @@
+package search
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MultiMatchType selects how a MultiMatchQuery combines its per-field scores
+type MultiMatchType string
+
+const (
+	MultiMatchBestFields MultiMatchType = "best_fields" // Best field's score, plus TieBreaker times the others (default)
+	MultiMatchMostFields MultiMatchType = "most_fields" // Sum of the field scores
+)
+
+// WeightedField is a field searched by a MultiMatchQuery
+type WeightedField struct {
+	Field  string
+	Weight float64 // Query-time boost, on top of the field's FieldDef.Boost; 0 means 1
+}
+
+// ParseWeightedField parses a field with an optional boost, e.g. "title^2"
+func ParseWeightedField(s string) (WeightedField, error) {
+	field, weight, ok := strings.Cut(s, "^")
+	if !ok {
+		return WeightedField{Field: s}, nil
+	}
+	w, err := strconv.ParseFloat(weight, 64)
+	if err != nil || w <= 0 {
+		return WeightedField{}, fmt.Errorf("invalid boost %q for field %s: must be a positive number", weight, field)
+	}
+	return WeightedField{Field: field, Weight: w}, nil
+}
+
+// MultiMatchQuery runs a MatchQuery for Query on each field, scaling each
+// field's scores by its weight, and combines them as Type says
+type MultiMatchQuery struct {
+	Fields             []WeightedField
+	Query              string
+	Operator           Operator       // Per field, as in MatchQuery
+	MinimumShouldMatch string         // Per field, as in MatchQuery
+	Type               MultiMatchType // MultiMatchBestFields (default) or MultiMatchMostFields
+	TieBreaker         float64        // Share of the non-best field scores added with MultiMatchBestFields, 0..1
+}
+
+// Execute implements Query
+func (q *MultiMatchQuery) Execute(r *Reader) (Matches, error) {
+	matches := make(Matches)
+	best := make(Matches) // Best weighted field score per document
+	for _, wf := range q.Fields {
+		weight := wf.Weight
+		if weight == 0 {
+			weight = 1.0
+		}
+
+		m, err := (&MatchQuery{Field: wf.Field, Query: q.Query, Operator: q.Operator, MinimumShouldMatch: q.MinimumShouldMatch}).Execute(r)
+		if err != nil {
+			return nil, err
+		}
+		for id, score := range m {
+			score *= weight
+			matches[id] += score
+			if previous, ok := best[id]; !ok || score > previous {
+				best[id] = score
+			}
+		}
+	}
+
+	if q.Type == MultiMatchMostFields {
+		return matches, nil
+	}
+	for id, sum := range matches {
+		matches[id] = best[id] + q.TieBreaker*(sum-best[id])
+	}
+	return matches, nil
+}
@@ -0,0 +1,129 @@
+package search
+
+import "sync"
+
+// SearchPool bounds the goroutines that search segments in parallel, across
+// every search sharing the pool
+// A segment that finds no free goroutine is searched by the calling goroutine,
+// so a search never waits for another one to finish
+type SearchPool struct {
+	slots chan struct{}
+}
+
+// NewSearchPool creates a pool running at most concurrency segment searches
+// in goroutines of their own
+func NewSearchPool(concurrency int) *SearchPool {
+	return &SearchPool{slots: make(chan struct{}, max(concurrency, 0))}
+}
+
+// run calls task for 0..n-1, in the pool's goroutines while it has free ones
+// and on the calling goroutine otherwise, and returns the first error by index
+func (p *SearchPool) run(n int, task func(i int) error) error {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		if i == n-1 {
+			errs[i] = task(i) // The caller would only wait otherwise
+			break
+		}
+		select {
+		case p.slots <- struct{}{}:
+			wg.Add(1)
+			go func(i int) {
+				defer func() {
+					<-p.slots
+					wg.Done()
+				}()
+				errs[i] = task(i)
+			}(i)
+		default:
+			errs[i] = task(i)
+		}
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectTopSegments is collectTop run on every segment of the reader in
+// parallel, merging the segments' top k hits
+// Each segment's search is the query under a filter of the segment's
+// documents; queries whose matches depend on the other documents (see
+// segmentable) run on the whole reader
+// Segments whose search passes the context's deadline are left out, and the
+// response marked as timed out
+func collectTopSegments(r *Reader, query Query, k int, trackTotalHits int) (*Response, error) {
+	if r.Pool == nil || len(r.Segments) < 2 || r.Filter != nil || !segmentable(query) {
+		return collectTop(r, query, k, trackTotalHits)
+	}
+
+	responses := make([]*Response, len(r.Segments))
+	err := r.Pool.run(len(r.Segments), func(i int) error {
+		segment := r.WithFilter(&DocFilter{Allowed: r.Segments[i].Docs})
+		resp, err := collectTop(segment, query, k, trackTotalHits)
+		if timedOut(err) {
+			return nil
+		}
+		responses[i] = resp
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	merged := &Response{TotalRelation: TotalEqual}
+	top := newTopHits(k)
+	for _, resp := range responses {
+		if resp == nil {
+			merged.TimedOut = true
+			merged.TotalRelation = TotalAtLeast
+			continue
+		}
+		merged.Total += resp.Total
+		if resp.TotalRelation == TotalAtLeast {
+			merged.TotalRelation = TotalAtLeast
+		}
+		if resp.MaxScore > merged.MaxScore {
+			merged.MaxScore = resp.MaxScore
+		}
+		for _, hit := range resp.Hits {
+			top.add(hit)
+		}
+	}
+	merged.Hits = top.sorted()
+	return merged, nil
+}
+
+// segmentable reports whether a query can run segment by segment: whether
+// each document matches, and its score, doesn't depend on the other matches
+// kNN queries keep the k nearest documents overall, and hybrid queries fuse
+// ranks, so neither can
+func segmentable(q Query) bool {
+	switch q := q.(type) {
+	case *KnnQuery, *HybridQuery:
+		return false
+	case *BoolQuery:
+		for _, clauses := range [][]Query{q.Must, q.Should, q.MustNot, q.Filter} {
+			for _, clause := range clauses {
+				if !segmentable(clause) {
+					return false
+				}
+			}
+		}
+	case *BoostQuery:
+		return segmentable(q.Query)
+	case *FunctionScoreQuery:
+		for _, f := range q.Functions {
+			if f.Filter != nil && !segmentable(f.Filter) {
+				return false
+			}
+		}
+		return q.Query == nil || segmentable(q.Query)
+	}
+	return true
+}
@@ -0,0 +1,308 @@
+package search
+
+import (
+	"nano-elastic/internal/analyzer"
+	"nano-elastic/internal/index/inverted"
+	"nano-elastic/internal/types"
+)
+
+// PhraseQuery matches documents containing the analyzed terms of Phrase at
+// consecutive positions, e.g. "jazz age" but not "age of jazz"
+// Gaps left by removed stop words are kept, so "catcher in the rye" matches the
+// original text even though "in" and "the" aren't indexed
+// Documents are scored with the field's similarity (see Similarity), using the
+// phrase frequency and the summed term IDFs, times the field's index-time boost
+// Phrases analyzed into a token graph (multi-word synonyms or shingles, see
+// analyzer.Token.PositionLength) match documents containing any path through it
+type PhraseQuery struct {
+	Field  string
+	Phrase string
+}
+
+// phraseTerm is one position of the phrase: a posting list per alternative
+// term (more than one when synonyms share the position) and its offset from the
+// first position
+type phraseTerm struct {
+	offset int
+	lists  []*inverted.PostingList
+}
+
+// Execute implements Query
+func (q *PhraseQuery) Execute(r *Reader) (Matches, error) {
+	if fieldType, ok := r.fieldType(q.Field); ok && fieldType != types.FieldTypeText {
+		return (&TermQuery{Field: q.Field, Value: q.Phrase}).Execute(r)
+	}
+
+	tokens := r.Inverted.AnalyzeQuery(q.Field, q.Phrase)
+	if graph, ok := newPhraseGraph(r, q.Field, q.Phrase, tokens); ok {
+		return q.executeGraph(r, graph)
+	}
+	terms, ok := phraseTerms(r, q.Field, tokens)
+	if !ok {
+		return Matches{}, nil
+	}
+	if len(terms) == 1 {
+		return (&MatchQuery{Field: q.Field, Query: q.Phrase}).Execute(r)
+	}
+
+	sim := r.similarity(q.Field)
+	boost := r.fieldBoost(q.Field)
+	docCount, avgLength := r.Inverted.FieldStats(q.Field)
+	idf := 0.0
+	for _, term := range terms {
+		df := 0
+		for _, pl := range term.lists {
+			df += pl.DocFreq
+		}
+		idf += sim.IDF(df, docCount)
+	}
+
+	matches := make(Matches)
+	seen := make(map[uint32]bool)
+	for _, candidate := range terms[0].lists {
+		for i, posting := range r.postings(candidate).Postings {
+			if err := r.checkContextEvery(i + 1); err != nil {
+				return nil, err
+			}
+			if seen[posting.Doc] || !r.allowsDoc(posting.Doc) {
+				continue
+			}
+			seen[posting.Doc] = true
+			freq := phraseFreq(posting.Doc, terms)
+			if freq == 0 {
+				continue
+			}
+			fieldLength := r.Inverted.DocFieldLength(q.Field, posting.Doc)
+			matches[r.Inverted.DocID(posting.Doc)] = sim.Score(freq, idf, fieldLength, avgLength) * boost
+		}
+	}
+	return matches, nil
+}
+
+// phraseTerms groups the phrase tokens by position and looks up their postings
+// Returns false if the phrase is empty or any position has no matching term
+func phraseTerms(r *Reader, fieldName string, tokens []analyzer.Token) ([]phraseTerm, bool) {
+	if len(tokens) == 0 {
+		return nil, false
+	}
+
+	var terms []phraseTerm
+	for _, token := range tokens {
+		offset := token.Position - tokens[0].Position
+		if len(terms) == 0 || terms[len(terms)-1].offset != offset {
+			terms = append(terms, phraseTerm{offset: offset})
+		}
+		if pl := r.Inverted.TermPostings(fieldName, token.Term); pl != nil {
+			terms[len(terms)-1].lists = append(terms[len(terms)-1].lists, pl)
+		}
+	}
+
+	for _, term := range terms {
+		if len(term.lists) == 0 {
+			return nil, false
+		}
+	}
+	return terms, true
+}
+
+// phraseFreq counts how many times the phrase occurs in a document
+func phraseFreq(doc uint32, terms []phraseTerm) int {
+	// Positions of each phrase term in the document
+	positions := make([]map[int]bool, len(terms))
+	for i, term := range terms {
+		positions[i] = make(map[int]bool)
+		for _, pl := range term.lists {
+			if posting, ok := pl.GetPosting(doc); ok {
+				for _, pos := range posting.Positions {
+					positions[i][pos] = true
+				}
+			}
+		}
+		if len(positions[i]) == 0 {
+			return 0
+		}
+	}
+
+	freq := 0
+	for start := range positions[0] {
+		matched := true
+		for i := 1; i < len(terms); i++ {
+			if !positions[i][start+terms[i].offset] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			freq++
+		}
+	}
+	return freq
+}
+
+// phraseGraph is a phrase whose tokens form a graph, e.g. "ny" expanded to
+// "new york" by a multi-word synonym, or shingles stacked over their words
+// Each token is an edge from its position to the position after its span; a
+// document matches when the terms of some path from the first position to the
+// last occur at successive positions
+type phraseGraph struct {
+	edges [][]phraseEdge // By start position, relative to the phrase's first position
+	end   int            // The position every path ends at
+}
+
+// phraseEdge is one token of a phrase graph
+type phraseEdge struct {
+	to   int                   // The position after the token's span
+	step int                   // Document positions the token advances
+	list *inverted.PostingList // nil if the term isn't indexed
+}
+
+// newPhraseGraph builds the graph of a phrase's tokens, or returns false if
+// they don't form one
+// Documents were indexed through the field's index analyzer, so when it turns
+// the phrase into a graph its tokens are matched, advancing as many document
+// positions as they span. Otherwise a graph from the search analyzer (e.g.
+// query-time synonyms) is matched against plain text, each token advancing one
+// position. Tokens no path reaches, like shingles output without their words,
+// aren't a graph and are matched position by position
+func newPhraseGraph(r *Reader, fieldName, phrase string, queryTokens []analyzer.Token) (*phraseGraph, bool) {
+	tokens, spansIndexed := queryTokens, false
+	if indexTokens := r.Inverted.AnalyzeText(fieldName, phrase); hasPositionSpans(indexTokens) {
+		tokens, spansIndexed = indexTokens, true
+	} else if !hasPositionSpans(queryTokens) {
+		return nil, false
+	}
+
+	first, end := tokens[0].Position, 0
+	for _, token := range tokens {
+		first = min(first, token.Position)
+	}
+	for _, token := range tokens {
+		end = max(end, token.Position-first+token.Span())
+	}
+
+	g := &phraseGraph{edges: make([][]phraseEdge, end), end: end}
+	for _, token := range tokens {
+		from := token.Position - first
+		edge := phraseEdge{to: from + token.Span(), step: 1, list: r.Inverted.TermPostings(fieldName, token.Term)}
+		if spansIndexed {
+			edge.step = token.Span()
+		}
+		g.edges[from] = append(g.edges[from], edge)
+	}
+
+	// Every token must be on a path; a position without tokens is a gap left by
+	// a removed stop word and leads to the next one
+	reached := make([]bool, end+1)
+	reached[0] = true
+	for pos := 0; pos < end; pos++ {
+		if !reached[pos] {
+			if len(g.edges[pos]) > 0 {
+				return nil, false
+			}
+			continue
+		}
+		if len(g.edges[pos]) == 0 {
+			reached[pos+1] = true
+		}
+		for _, edge := range g.edges[pos] {
+			reached[edge.to] = true
+		}
+	}
+	return g, true
+}
+
+// hasPositionSpans reports whether any token spans several positions
+func hasPositionSpans(tokens []analyzer.Token) bool {
+	for _, token := range tokens {
+		if token.Span() > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// executeGraph scores the documents matching a phrase graph
+// The IDF sums, for each position, the document frequencies of the terms
+// starting there
+func (q *PhraseQuery) executeGraph(r *Reader, g *phraseGraph) (Matches, error) {
+	sim := r.similarity(q.Field)
+	boost := r.fieldBoost(q.Field)
+	docCount, avgLength := r.Inverted.FieldStats(q.Field)
+	idf := 0.0
+	for _, edges := range g.edges {
+		df := 0
+		for _, edge := range edges {
+			if edge.list != nil {
+				df += edge.list.DocFreq
+			}
+		}
+		if df > 0 {
+			idf += sim.IDF(df, docCount)
+		}
+	}
+
+	matches := make(Matches)
+	seen := make(map[uint32]bool)
+	for _, edge := range g.edges[0] {
+		if edge.list == nil {
+			continue
+		}
+		for i, posting := range r.postings(edge.list).Postings {
+			if err := r.checkContextEvery(i + 1); err != nil {
+				return nil, err
+			}
+			if seen[posting.Doc] || !r.allowsDoc(posting.Doc) {
+				continue
+			}
+			seen[posting.Doc] = true
+			freq := g.freq(posting.Doc)
+			if freq == 0 {
+				continue
+			}
+			fieldLength := r.Inverted.DocFieldLength(q.Field, posting.Doc)
+			matches[r.Inverted.DocID(posting.Doc)] = sim.Score(freq, idf, fieldLength, avgLength) * boost
+		}
+	}
+	return matches, nil
+}
+
+// freq counts the document positions some path of the graph starts at
+// Working back from the last position, finish[pos] holds the document
+// positions from which the rest of a path, starting at graph position pos, occurs
+func (g *phraseGraph) freq(doc uint32) int {
+	type positions struct {
+		any bool // Every document position, at the end of the graph
+		set map[int]bool
+	}
+	finish := make([]positions, g.end+1)
+	finish[g.end].any = true
+	for pos := g.end - 1; pos >= 0; pos-- {
+		set := make(map[int]bool)
+		if len(g.edges[pos]) == 0 { // A removed stop word matches any term
+			if finish[pos+1].any {
+				finish[pos].any = true
+				continue
+			}
+			for p := range finish[pos+1].set {
+				set[p-1] = true
+			}
+		}
+		for _, edge := range g.edges[pos] {
+			if edge.list == nil {
+				continue
+			}
+			posting, ok := edge.list.GetPosting(doc)
+			if !ok {
+				continue
+			}
+			next := finish[edge.to]
+			for _, p := range posting.Positions {
+				if next.any || next.set[p+edge.step] {
+					set[p] = true
+				}
+			}
+		}
+		finish[pos].set = set
+	}
+	return len(finish[0].set)
+}
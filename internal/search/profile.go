@@ -0,0 +1,146 @@
+package search
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Profile is the breakdown of where a search with Request.Profile set spent
+// its time in one index
+// Durations are reported in nanoseconds
+type Profile struct {
+	Index     string        `json:"index,omitempty"`
+	Parse     time.Duration `json:"parse_time_in_nanos"`
+	Query     time.Duration `json:"query_time_in_nanos"`   // Matching and scoring, including Scoring and every term's Iteration
+	Scoring   time.Duration `json:"scoring_time_in_nanos"` // Part of Query spent scoring the terms' postings
+	Aggregate time.Duration `json:"aggregate_time_in_nanos"`
+	Sort      time.Duration `json:"sort_time_in_nanos"`
+	Fetch     time.Duration `json:"fetch_time_in_nanos"`
+
+	Terms []TermProfile `json:"terms"` // By field, then term
+}
+
+// TermProfile is the time a profiled search spent on one term's posting list
+// Terms read together through a disjunction or WAND share the time spent
+// moving between documents in proportion to the postings each read
+type TermProfile struct {
+	Field     string        `json:"field"`
+	Term      string        `json:"term"`
+	Postings  int           `json:"postings"` // Postings read, over every segment
+	Iteration time.Duration `json:"iteration_time_in_nanos"`
+	Scoring   time.Duration `json:"scoring_time_in_nanos"`
+}
+
+// profiler collects the term profiles of a search, from every segment
+// searched in parallel
+type profiler struct {
+	mu    sync.Mutex
+	terms map[[2]string]*TermProfile
+}
+
+// withProfiler returns a copy of the reader that profiles its queries
+func (r *Reader) withProfiler() *Reader {
+	profiled := *r
+	profiled.profiler = &profiler{terms: make(map[[2]string]*TermProfile)}
+	return &profiled
+}
+
+// profileTerm starts timing a term's postings, nil if the reader isn't profiling
+func (r *Reader) profileTerm(fieldName string, term string) *termTimer {
+	if r.profiler == nil {
+		return nil
+	}
+	return &termTimer{field: fieldName, term: term}
+}
+
+// start returns the current time when profiling, the zero time otherwise
+func (p *profiler) start() time.Time {
+	if p == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// finish records terms read together since start: the time not spent
+// scoring is shared among them by the postings each read
+func (p *profiler) finish(start time.Time, timers ...*termTimer) {
+	if p == nil {
+		return
+	}
+	iteration := time.Since(start)
+	postings := 0
+	for _, t := range timers {
+		if t != nil {
+			iteration -= t.scoring
+			postings += t.postings
+		}
+	}
+	iteration = max(iteration, 0)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, t := range timers {
+		if t == nil {
+			continue
+		}
+		key := [2]string{t.field, t.term}
+		tp, ok := p.terms[key]
+		if !ok {
+			tp = &TermProfile{Field: t.field, Term: t.term}
+			p.terms[key] = tp
+		}
+		tp.Postings += t.postings
+		tp.Scoring += t.scoring
+		if postings > 0 {
+			tp.Iteration += time.Duration(float64(iteration) * float64(t.postings) / float64(postings))
+		}
+	}
+}
+
+// profile builds the search's profile from its timings, nil if the reader
+// isn't profiling
+func (p *profiler) profile(timings Timings) *Profile {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	profile := &Profile{
+		Parse:     timings.Parse,
+		Query:     timings.Query,
+		Aggregate: timings.Aggregate,
+		Sort:      timings.Sort,
+		Fetch:     timings.Fetch,
+		Terms:     make([]TermProfile, 0, len(p.terms)),
+	}
+	for _, tp := range p.terms {
+		profile.Scoring += tp.Scoring
+		profile.Terms = append(profile.Terms, *tp)
+	}
+	sort.Slice(profile.Terms, func(i, j int) bool {
+		a, b := profile.Terms[i], profile.Terms[j]
+		if a.Field != b.Field {
+			return a.Field < b.Field
+		}
+		return a.Term < b.Term
+	})
+	return profile
+}
+
+// termTimer accumulates one term's postings and scoring time within a query
+// A nil timer records nothing
+type termTimer struct {
+	field    string
+	term     string
+	postings int
+	scoring  time.Duration
+}
+
+// read counts n postings read
+func (t *termTimer) read(n int) {
+	if t != nil {
+		t.postings += n
+	}
+}
@@ -0,0 +1,139 @@
+package search
+
+import (
+	"context"
+
+	"nano-elastic/internal/index/bitmap"
+	"nano-elastic/internal/index/completion"
+	"nano-elastic/internal/index/docid"
+	"nano-elastic/internal/index/docvalues"
+	"nano-elastic/internal/index/geo"
+	"nano-elastic/internal/index/inverted"
+	"nano-elastic/internal/index/keyword"
+	"nano-elastic/internal/index/numeric"
+	"nano-elastic/internal/index/vector"
+	"nano-elastic/internal/types"
+)
+
+// Reader bundles the per-index structures that queries are evaluated against
+// The caller must keep the structures stable (e.g. hold a read lock) while a query runs
+type Reader struct {
+	Schema      *types.Schema
+	Inverted    *inverted.InvertedIndex
+	Numeric     *numeric.NumericIndex
+	Keywords    *keyword.KeywordIndex
+	DocValues   *docvalues.Store
+	Geo         *geo.GeoIndex
+	Vectors     *vector.VectorIndex
+	Completions *completion.CompletionIndex
+	AllDocs     map[string]struct{} // IDs of every live document
+	Ordinals    *docid.Ordinals     // Ordinals of the documents, shared with Inverted's posting lists
+	Filter      *DocFilter          // Set while evaluating the scoring clauses of a filtered bool query
+
+	FilterCache *FilterCache   // Shared cache of filter results; nil disables caching
+	Pool        *SearchPool    // Searches segments in parallel; nil searches the whole reader at once
+	Segments    []*SegmentDocs // The live documents partitioned by segment, for FilterCache and Pool
+
+	ctx      context.Context // Stops long queries (see WithContext); nil never does
+	profiler *profiler       // Collects term profiles for requests with Profile set; nil doesn't
+
+	MaxResultWindow int // Cap on From+Size (0 uses DefaultMaxResultWindow)
+}
+
+// fieldType returns the schema type of a field, if declared
+func (r *Reader) fieldType(fieldName string) (types.FieldType, bool) {
+	if r.Schema == nil {
+		return "", false
+	}
+	def, ok := r.Schema.Fields[fieldName]
+	if !ok {
+		return "", false
+	}
+	return def.Type, true
+}
+
+// fieldBoost returns the index-time boost of a field (FieldDef.Boost), 1 if it has none
+func (r *Reader) fieldBoost(fieldName string) float64 {
+	if r.Schema == nil {
+		return 1.0
+	}
+	def, ok := r.Schema.Fields[fieldName]
+	if !ok || def.Boost <= 0 {
+		return 1.0
+	}
+	return def.Boost
+}
+
+// isKeywordField reports whether a field is declared as a keyword field
+func (r *Reader) isKeywordField(fieldName string) bool {
+	fieldType, ok := r.fieldType(fieldName)
+	return ok && fieldType == types.FieldTypeKeyword
+}
+
+// Matches maps the IDs of matching documents to their scores
+type Matches map[string]float64
+
+// Query is a node in the query tree
+// Leaf queries match against one field; compound queries combine other queries
+type Query interface {
+	// Execute returns every matching document with its relevance score
+	Execute(r *Reader) (Matches, error)
+}
+
+// MatchAllQuery matches every document with a constant score of 1
+type MatchAllQuery struct{}
+
+// Execute implements Query
+func (q *MatchAllQuery) Execute(r *Reader) (Matches, error) {
+	if r.Filter != nil {
+		return r.matchesOf(r.Filter.Allowed, 1.0), nil
+	}
+	matches := make(Matches, len(r.AllDocs))
+	for id := range r.AllDocs {
+		matches[id] = 1.0
+	}
+	return matches, nil
+}
+
+// MatchDocs implements DocMatcher
+func (q *MatchAllQuery) MatchDocs(r *Reader) (*bitmap.Bitmap, error) {
+	return r.allowed(), nil
+}
+
+// BoostQuery multiplies the scores of Query by Boost, e.g. to weigh one clause
+// of a bool query above the others
+type BoostQuery struct {
+	Query Query
+	Boost float64
+}
+
+// Boost wraps a query so its scores are multiplied by boost
+func Boost(q Query, boost float64) Query {
+	return &BoostQuery{Query: q, Boost: boost}
+}
+
+// Execute implements Query
+func (q *BoostQuery) Execute(r *Reader) (Matches, error) {
+	matches, err := q.Query.Execute(r)
+	if err != nil {
+		return nil, err
+	}
+	for id, score := range matches {
+		matches[id] = score * q.Boost
+	}
+	return matches, nil
+}
+
+// MatchDocs implements DocMatcher: the boost doesn't change which documents match
+func (q *BoostQuery) MatchDocs(r *Reader) (*bitmap.Bitmap, error) {
+	return FilterDocs(r, q.Query)
+}
+
+// constantScore builds matches for a list of IDs, all with the same score
+func constantScore(docIDs []string, score float64) Matches {
+	matches := make(Matches, len(docIDs))
+	for _, id := range docIDs {
+		matches[id] = score
+	}
+	return matches
+}
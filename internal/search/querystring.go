@@ -0,0 +1,682 @@
+package search
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"nano-elastic/internal/types"
+)
+
+// Operator is the boolean operator applied between clauses with no explicit AND/OR
+type Operator string
+
+const (
+	OperatorOr  Operator = "OR"
+	OperatorAnd Operator = "AND"
+)
+
+// QueryParser turns Lucene-style query strings into query trees:
+//
+//	title:gatsby AND (novel OR "jazz age") -author:orwell year:[1900 TO 1960]
+//
+// Supported syntax:
+//   - field:term, field:"phrase", field:(group), and bare terms on the default fields
+//   - AND / &&, OR / ||, NOT / !, and +required / -prohibited prefixes; AND binds tighter than OR
+//   - ranges field:[a TO b] (inclusive), field:{a TO b} (exclusive), '*' for an open bound,
+//     and comparisons field:>=a, field:>a, field:<=a, field:<a
+//   - prefix (gat*), wildcard (g?t*y) and fuzzy (gatsbi~, gatsbi~1) terms
+//   - boosts on terms, phrases, ranges and groups: gatsby^2, "jazz age"^1.5, (a OR b)^3
+//   - *:* to match every document, and backslash escapes for special characters
+type QueryParser struct {
+	DefaultFields   []string      // Fields searched by terms without a field, optionally boosted as in "title^2" (default: every text field in Schema)
+	DefaultOperator Operator      // Operator between clauses without AND/OR (default OperatorOr)
+	Schema          *types.Schema // Optional; keyword fields keep the case of prefix, wildcard and fuzzy terms
+}
+
+// NewQueryParser creates a parser that searches bare terms in the given fields
+func NewQueryParser(defaultFields ...string) *QueryParser {
+	return &QueryParser{
+		DefaultFields:   defaultFields,
+		DefaultOperator: OperatorOr,
+	}
+}
+
+// ParseQueryString parses a query string with the default OR operator
+func ParseQueryString(input string, defaultFields ...string) (Query, error) {
+	return NewQueryParser(defaultFields...).Parse(input)
+}
+
+// QueryParseError reports a syntax error in a query string
+type QueryParseError struct {
+	Pos     int // Byte offset in the input
+	Message string
+}
+
+func (e *QueryParseError) Error() string {
+	return fmt.Sprintf("query string parse error at position %d: %s", e.Pos, e.Message)
+}
+
+// Parse parses a query string into a query tree
+// An empty (or all-whitespace) query matches every document
+func (qp *QueryParser) Parse(input string) (Query, error) {
+	tokens, err := lexQueryString(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &qsParser{qp: qp, tokens: tokens}
+	if p.peek().kind == qsEOF {
+		return &MatchAllQuery{}, nil
+	}
+
+	c, err := p.parseOr(nil)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != qsEOF {
+		return nil, &QueryParseError{Pos: tok.pos, Message: fmt.Sprintf("unexpected %s", tok)}
+	}
+	return c.toQuery(), nil
+}
+
+// defaultFields returns the fields searched by bare terms
+func (qp *QueryParser) defaultFields() []string {
+	if len(qp.DefaultFields) > 0 || qp.Schema == nil {
+		return qp.DefaultFields
+	}
+
+	var fields []string
+	for name, def := range qp.Schema.Fields {
+		if def.Type == types.FieldTypeText {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// isKeyword reports whether the schema declares a field as keyword
+func (qp *QueryParser) isKeyword(fieldName string) bool {
+	if qp.Schema == nil {
+		return false
+	}
+	def, ok := qp.Schema.Fields[fieldName]
+	return ok && def.Type == types.FieldTypeKeyword
+}
+
+// Lexer
+
+type qsTokenKind int
+
+const (
+	qsEOF qsTokenKind = iota
+	qsTerm
+	qsPhrase
+	qsRange
+	qsColon
+	qsLParen
+	qsRParen
+	qsAnd
+	qsOr
+	qsNot
+	qsPlus
+	qsMinus
+)
+
+// qsToken is one lexical token of a query string
+type qsToken struct {
+	kind qsTokenKind
+	text string // Raw term (escapes kept), unquoted phrase, or range body
+	pos  int
+
+	// Range tokens only: whether each bound is inclusive ('[' / ']')
+	includeLower, includeUpper bool
+
+	boost float64 // Term, phrase, range and ')' tokens: the '^' boost that follows, 0 if none
+}
+
+func (t qsToken) String() string {
+	switch t.kind {
+	case qsEOF:
+		return "end of query"
+	case qsPhrase:
+		return fmt.Sprintf("%q", t.text)
+	case qsRange:
+		return "range"
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+// lexQueryString splits a query string into tokens
+func lexQueryString(input string) ([]qsToken, error) {
+	var tokens []qsToken
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, qsToken{kind: qsLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tok := qsToken{kind: qsRParen, text: ")", pos: i}
+			i++
+			if err := lexBoost(input, &i, &tok); err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, tok)
+		case c == ':':
+			tokens = append(tokens, qsToken{kind: qsColon, text: ":", pos: i})
+			i++
+		case (c == '+' || c == '-' || c == '!') && isPrefixOperator(input, i):
+			kind := map[byte]qsTokenKind{'+': qsPlus, '-': qsMinus, '!': qsNot}[c]
+			tokens = append(tokens, qsToken{kind: kind, text: string(c), pos: i})
+			i++
+		case c == '"':
+			end := i + 1
+			var sb strings.Builder
+			for end < len(input) && input[end] != '"' {
+				if input[end] == '\\' && end+1 < len(input) {
+					end++
+				}
+				sb.WriteByte(input[end])
+				end++
+			}
+			if end >= len(input) {
+				return nil, &QueryParseError{Pos: i, Message: "unterminated phrase"}
+			}
+			tok := qsToken{kind: qsPhrase, text: sb.String(), pos: i}
+			i = end + 1
+			if err := lexBoost(input, &i, &tok); err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, tok)
+		case c == '[' || c == '{':
+			end := strings.IndexAny(input[i+1:], "]}")
+			if end < 0 {
+				return nil, &QueryParseError{Pos: i, Message: "unterminated range"}
+			}
+			end += i + 1
+			tok := qsToken{
+				kind:         qsRange,
+				text:         input[i+1 : end],
+				pos:          i,
+				includeLower: c == '[',
+				includeUpper: input[end] == ']',
+			}
+			i = end + 1
+			if err := lexBoost(input, &i, &tok); err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, tok)
+		case c == ']' || c == '}':
+			return nil, &QueryParseError{Pos: i, Message: fmt.Sprintf("unexpected '%c'", c)}
+		default:
+			start := i
+			for i < len(input) && !isTermBoundary(input[i]) {
+				if input[i] == '\\' && i+1 < len(input) {
+					i++
+				}
+				i++
+			}
+			text := input[start:i]
+			tok := qsToken{kind: qsTerm, text: text, pos: start}
+			if j := indexUnescaped(text, "^"); j >= 0 {
+				if j == 0 {
+					return nil, &QueryParseError{Pos: start, Message: "boost without a term"}
+				}
+				boost, err := parseQueryBoost(text[j+1:], start+j)
+				if err != nil {
+					return nil, err
+				}
+				text = text[:j]
+				tok.text, tok.boost = text, boost
+			}
+			switch text {
+			case "AND", "&&":
+				tok.kind = qsAnd
+			case "OR", "||":
+				tok.kind = qsOr
+			case "NOT":
+				tok.kind = qsNot
+			}
+			tokens = append(tokens, tok)
+		}
+	}
+	return append(tokens, qsToken{kind: qsEOF, pos: len(input)}), nil
+}
+
+// lexBoost reads the '^' boost, if any, following a phrase, range or ')' at i
+func lexBoost(input string, i *int, tok *qsToken) error {
+	if *i >= len(input) || input[*i] != '^' {
+		return nil
+	}
+	start := *i
+	end := start + 1
+	for end < len(input) && !isTermBoundary(input[end]) {
+		end++
+	}
+	boost, err := parseQueryBoost(input[start+1:end], start)
+	if err != nil {
+		return err
+	}
+	tok.boost = boost
+	*i = end
+	return nil
+}
+
+// parseQueryBoost parses the number after a '^' at pos
+func parseQueryBoost(s string, pos int) (float64, error) {
+	boost, err := strconv.ParseFloat(s, 64)
+	if err != nil || boost <= 0 {
+		return 0, &QueryParseError{Pos: pos, Message: fmt.Sprintf("invalid boost %q: must be a positive number", s)}
+	}
+	return boost, nil
+}
+
+// isPrefixOperator reports whether a '+', '-' or '!' at i is a prefix operator rather
+// than part of a term such as "1984-01-01", or a negative value such as "year:-5"
+func isPrefixOperator(input string, i int) bool {
+	if i > 0 && (!isTermBoundary(input[i-1]) || input[i-1] == ':') {
+		return false
+	}
+	if i+1 >= len(input) {
+		return false
+	}
+	next := input[i+1]
+	return !isTermBoundary(next) || next == '(' || next == '"'
+}
+
+// isTermBoundary reports whether c ends a bare term
+func isTermBoundary(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '(', ')', ':', '"', '[', ']', '{', '}':
+		return true
+	}
+	return false
+}
+
+// Parser
+
+// occur is how a clause contributes to its enclosing boolean query
+type occur int
+
+const (
+	occurDefault occur = iota // Decided by the operator (AND: must, OR: should)
+	occurMust                 // '+'
+	occurMustNot              // '-', '!' or NOT
+)
+
+// qsClause is a parsed query with its prefix operator
+type qsClause struct {
+	query Query
+	occur occur
+}
+
+// toQuery converts a top-level clause to a query; a lone prohibited clause
+// matches every document except its matches
+func (c qsClause) toQuery() Query {
+	if c.occur == occurMustNot {
+		return &BoolQuery{MustNot: []Query{c.query}}
+	}
+	return c.query
+}
+
+type qsParser struct {
+	qp     *QueryParser
+	tokens []qsToken
+	pos    int
+}
+
+func (p *qsParser) peek() qsToken {
+	return p.tokens[p.pos]
+}
+
+func (p *qsParser) next() qsToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != qsEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// startsClause reports whether the next token can begin a clause
+func (p *qsParser) startsClause() bool {
+	switch p.peek().kind {
+	case qsTerm, qsPhrase, qsRange, qsLParen, qsPlus, qsMinus, qsNot:
+		return true
+	}
+	return false
+}
+
+// parseOr parses clauses joined by OR (and by adjacency when the default operator is OR)
+func (p *qsParser) parseOr(fields []string) (qsClause, error) {
+	first, err := p.parseAnd(fields)
+	if err != nil {
+		return qsClause{}, err
+	}
+	clauses := []qsClause{first}
+
+	for {
+		if p.peek().kind == qsOr {
+			p.next()
+		} else if !(p.startsClause() && p.qp.DefaultOperator != OperatorAnd) {
+			break
+		}
+		c, err := p.parseAnd(fields)
+		if err != nil {
+			return qsClause{}, err
+		}
+		clauses = append(clauses, c)
+	}
+
+	if len(clauses) == 1 {
+		return clauses[0], nil
+	}
+	return qsClause{query: combineClauses(clauses, false)}, nil
+}
+
+// parseAnd parses clauses joined by AND (and by adjacency when the default operator is AND)
+func (p *qsParser) parseAnd(fields []string) (qsClause, error) {
+	first, err := p.parseUnary(fields)
+	if err != nil {
+		return qsClause{}, err
+	}
+	clauses := []qsClause{first}
+
+	for {
+		if p.peek().kind == qsAnd {
+			p.next()
+		} else if !(p.startsClause() && p.qp.DefaultOperator == OperatorAnd) {
+			break
+		}
+		c, err := p.parseUnary(fields)
+		if err != nil {
+			return qsClause{}, err
+		}
+		clauses = append(clauses, c)
+	}
+
+	if len(clauses) == 1 {
+		return clauses[0], nil
+	}
+	return qsClause{query: combineClauses(clauses, true)}, nil
+}
+
+// combineClauses builds a boolean query; default clauses are required when
+// conjunctive and optional otherwise
+func combineClauses(clauses []qsClause, conjunctive bool) Query {
+	bq := &BoolQuery{}
+	for _, c := range clauses {
+		switch {
+		case c.occur == occurMustNot:
+			bq.MustNot = append(bq.MustNot, c.query)
+		case c.occur == occurMust || conjunctive:
+			bq.Must = append(bq.Must, c.query)
+		default:
+			bq.Should = append(bq.Should, c.query)
+		}
+	}
+	return bq
+}
+
+// parseUnary parses an optional +, - or NOT prefix and a primary
+func (p *qsParser) parseUnary(fields []string) (qsClause, error) {
+	o := occurDefault
+	switch p.peek().kind {
+	case qsPlus:
+		p.next()
+		o = occurMust
+	case qsMinus, qsNot:
+		p.next()
+		o = occurMustNot
+	}
+
+	q, err := p.parsePrimary(fields)
+	if err != nil {
+		return qsClause{}, err
+	}
+	return qsClause{query: q, occur: o}, nil
+}
+
+// parsePrimary parses a group, a field-qualified value, or a bare value
+func (p *qsParser) parsePrimary(fields []string) (Query, error) {
+	tok := p.peek()
+
+	switch tok.kind {
+	case qsLParen:
+		p.next()
+		c, err := p.parseOr(fields)
+		if err != nil {
+			return nil, err
+		}
+		closing := p.next()
+		if closing.kind != qsRParen {
+			return nil, &QueryParseError{Pos: closing.pos, Message: fmt.Sprintf("expected ')' but found %s", closing)}
+		}
+		return withQueryBoost(c.toQuery(), closing.boost), nil
+
+	case qsTerm:
+		if p.tokens[p.pos+1].kind == qsColon {
+			p.next()
+			p.next()
+			field := unescapeTerm(tok.text)
+			if field == "*" && p.peek().kind == qsTerm && p.peek().text == "*" {
+				p.next()
+				return &MatchAllQuery{}, nil
+			}
+			return p.parseValue([]string{field})
+		}
+	}
+
+	if fields == nil {
+		fields = p.qp.defaultFields()
+		if len(fields) == 0 {
+			return nil, &QueryParseError{Pos: tok.pos, Message: fmt.Sprintf("no field given for %s and no default fields configured", tok)}
+		}
+	}
+	return p.parseValue(fields)
+}
+
+// parseValue parses the value of a clause (group, phrase, range or term) for the given fields
+func (p *qsParser) parseValue(fields []string) (Query, error) {
+	tok := p.peek()
+
+	var build func(field string) (Query, error)
+	switch tok.kind {
+	case qsLParen:
+		// Field group: title:(gatsby OR novel)
+		return p.parsePrimary(fields)
+	case qsPhrase:
+		build = func(field string) (Query, error) {
+			return &PhraseQuery{Field: field, Phrase: tok.text}, nil
+		}
+	case qsRange:
+		build = func(field string) (Query, error) {
+			return parseRange(field, tok)
+		}
+	case qsTerm:
+		build = func(field string) (Query, error) {
+			return p.termQuery(field, tok)
+		}
+	default:
+		return nil, &QueryParseError{Pos: tok.pos, Message: fmt.Sprintf("expected a term, phrase, range or group but found %s", tok)}
+	}
+
+	p.next()
+	q, err := forFields(fields, build)
+	if err != nil {
+		return nil, err
+	}
+	return withQueryBoost(q, tok.boost), nil
+}
+
+// forFields builds a query per field, combining several with OR
+// Fields may carry a boost, as in "title^2"
+func forFields(fields []string, build func(field string) (Query, error)) (Query, error) {
+	queries := make([]Query, 0, len(fields))
+	for _, field := range fields {
+		wf, err := ParseWeightedField(field)
+		if err != nil {
+			return nil, err
+		}
+		q, err := build(wf.Field)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, withQueryBoost(q, wf.Weight))
+	}
+
+	if len(queries) == 1 {
+		return queries[0], nil
+	}
+	return &BoolQuery{Should: queries}, nil
+}
+
+// withQueryBoost wraps a query in a BoostQuery unless boost is 0 (none)
+func withQueryBoost(q Query, boost float64) Query {
+	if boost == 0 {
+		return q
+	}
+	return Boost(q, boost)
+}
+
+// termQuery builds the query for a bare term: comparison, prefix, wildcard, fuzzy or match
+func (p *qsParser) termQuery(field string, tok qsToken) (Query, error) {
+	raw := tok.text
+
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		if strings.HasPrefix(raw, op) {
+			bound := unescapeTerm(raw[len(op):])
+			if bound == "" {
+				return nil, &QueryParseError{Pos: tok.pos, Message: fmt.Sprintf("missing value after '%s'", op)}
+			}
+			rq := &FieldRangeQuery{Field: field}
+			switch op {
+			case ">=":
+				rq.GTE = bound
+			case "<=":
+				rq.LTE = bound
+			case ">":
+				rq.GT = bound
+			case "<":
+				rq.LT = bound
+			}
+			return rq, nil
+		}
+	}
+
+	normalize := func(s string) string {
+		if p.qp.isKeyword(field) {
+			return s
+		}
+		return strings.ToLower(s)
+	}
+
+	if i := indexUnescaped(raw, "~"); i >= 0 {
+		maxEdits := -1 // AUTO
+		if suffix := raw[i+1:]; suffix != "" {
+			n, err := strconv.Atoi(suffix)
+			if err != nil || n < 0 {
+				return nil, &QueryParseError{Pos: tok.pos + i, Message: fmt.Sprintf("invalid fuzziness %q", suffix)}
+			}
+			maxEdits = n
+		}
+		return &FuzzyQuery{Field: field, Term: normalize(unescapeTerm(raw[:i])), MaxEdits: maxEdits}, nil
+	}
+
+	if indexUnescaped(raw, "*?") >= 0 {
+		if raw == "*" {
+			return &WildcardQuery{Field: field, Pattern: "*"}, nil
+		}
+		// A single trailing '*' is a prefix query, which avoids pattern matching
+		stem := raw[:len(raw)-1]
+		if strings.HasSuffix(raw, "*") && indexUnescaped(stem, "*?") < 0 {
+			return &PrefixQuery{Field: field, Prefix: normalize(unescapeTerm(stem))}, nil
+		}
+		return &WildcardQuery{Field: field, Pattern: normalize(unescapeTerm(raw))}, nil
+	}
+
+	return &MatchQuery{Field: field, Query: unescapeTerm(raw)}, nil
+}
+
+// parseRange parses the body of a [a TO b] / {a TO b} range
+func parseRange(field string, tok qsToken) (Query, error) {
+	parts := strings.Fields(tok.text)
+	if len(parts) != 3 || parts[1] != "TO" {
+		return nil, &QueryParseError{Pos: tok.pos, Message: "range must have the form [lower TO upper]"}
+	}
+
+	lower, upper := strings.Trim(parts[0], `"`), strings.Trim(parts[2], `"`)
+	rq := &FieldRangeQuery{Field: field}
+	if lower != "*" {
+		if tok.includeLower {
+			rq.GTE = lower
+		} else {
+			rq.GT = lower
+		}
+	}
+	if upper != "*" {
+		if tok.includeUpper {
+			rq.LTE = upper
+		} else {
+			rq.LT = upper
+		}
+	}
+	return rq, nil
+}
+
+// indexUnescaped returns the index of the first unescaped character from chars, or -1
+func indexUnescaped(raw string, chars string) int {
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.IndexByte(chars, raw[i]) >= 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// unescapeTerm removes backslash escapes
+func unescapeTerm(raw string) string {
+	if !strings.Contains(raw, `\`) {
+		return raw
+	}
+	var sb strings.Builder
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '\\' && i+1 < len(raw) {
+			i++
+		}
+		sb.WriteByte(raw[i])
+	}
+	return sb.String()
+}
+
+// QueryStringQuery parses a query string when executed, against the reader's schema
+// It lets callers embed query strings in query trees (e.g. the JSON DSL's query_string)
+type QueryStringQuery struct {
+	Query           string
+	DefaultFields   []string
+	DefaultOperator Operator
+}
+
+// Execute implements Query
+func (q *QueryStringQuery) Execute(r *Reader) (Matches, error) {
+	qp := NewQueryParser(q.DefaultFields...)
+	qp.Schema = r.Schema
+	if q.DefaultOperator != "" {
+		qp.DefaultOperator = q.DefaultOperator
+	}
+
+	parsed, err := qp.Parse(q.Query)
+	if err != nil {
+		return nil, err
+	}
+	return parsed.Execute(r)
+}
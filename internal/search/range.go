@@ -0,0 +1,86 @@
+package search
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"nano-elastic/internal/index/numeric"
+	"nano-elastic/internal/types"
+)
+
+// RangeQuery matches documents whose numeric field falls within the range
+// All matches score 1
+type RangeQuery struct {
+	Field string
+	Range numeric.RangeQuery
+}
+
+// Execute implements Query
+func (q *RangeQuery) Execute(r *Reader) (Matches, error) {
+	if err := q.Range.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid range on field %s: %w", q.Field, err)
+	}
+	return constantScore(r.Numeric.Search(q.Field, q.Range), 1.0), nil
+}
+
+// DateRangeQuery matches documents whose date field falls within the range
+// Bounds may use date math ("now-7d/d") and any of the field's date formats
+// All matches score 1
+type DateRangeQuery struct {
+	Field string
+	Range numeric.DateRangeQuery
+}
+
+// Execute implements Query
+func (q *DateRangeQuery) Execute(r *Reader) (Matches, error) {
+	formats := types.DefaultDateFormats
+	if r.Schema != nil {
+		if def, ok := r.Schema.Fields[q.Field]; ok {
+			formats = def.DateFormatsOrDefault()
+		}
+	}
+
+	rq, err := q.Range.Resolve(time.Now(), formats)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date range on field %s: %w", q.Field, err)
+	}
+	return constantScore(r.Numeric.Search(q.Field, rq), 1.0), nil
+}
+
+// FieldRangeQuery is a range whose bounds are strings, interpreted by the field's
+// type when the query runs: date math and date formats for date fields, numbers
+// otherwise. Empty bounds are open. Used by the query string parser, which
+// doesn't know field types
+type FieldRangeQuery struct {
+	Field string
+	GT    string
+	GTE   string
+	LT    string
+	LTE   string
+}
+
+// Execute implements Query
+func (q *FieldRangeQuery) Execute(r *Reader) (Matches, error) {
+	if fieldType, ok := r.fieldType(q.Field); ok && fieldType == types.FieldTypeDate {
+		dq := &DateRangeQuery{Field: q.Field, Range: numeric.DateRangeQuery{GT: q.GT, GTE: q.GTE, LT: q.LT, LTE: q.LTE}}
+		return dq.Execute(r)
+	}
+
+	var rq numeric.RangeQuery
+	bounds := []struct {
+		value string
+		dst   **float64
+	}{{q.GT, &rq.GT}, {q.GTE, &rq.GTE}, {q.LT, &rq.LT}, {q.LTE, &rq.LTE}}
+	for _, b := range bounds {
+		if b.value == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(b.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid numeric range bound %q for field %s: %w", b.value, q.Field, err)
+		}
+		*b.dst = &v
+	}
+	return (&RangeQuery{Field: q.Field, Range: rq}).Execute(r)
+}
@@ -0,0 +1,308 @@
+package search
+
+import (
+	"fmt"
+	"time"
+
+	"nano-elastic/internal/types"
+)
+
+const (
+	// DefaultSize is the number of hits returned when a request doesn't set Size
+	DefaultSize = 10
+
+	// DefaultMaxResultWindow caps From+Size, like Elasticsearch's index.max_result_window
+	// Deep pages are expensive: every earlier hit must be collected and sorted too
+	DefaultMaxResultWindow = 10000
+)
+
+// Request describes a search
+type Request struct {
+	Query Query       // nil matches every document
+	From  int         // Number of hits to skip
+	Size  *int        // Maximum number of hits to return; nil for DefaultSize, 0 for none (e.g. aggregations only)
+	Sort  []SortField // Empty sorts by score
+
+	// SearchAfter resumes after the hit with these sort values, taken from the
+	// last hit of the previous page. Requires Sort, and From must be 0
+	SearchAfter []interface{}
+
+	Highlight *Highlight // Optional snippets of matched text for each returned hit
+
+	Source *types.SourceFilter // Fields of the hits' documents to return; nil returns them whole
+
+	Aggregations map[string]Aggregation // Computed over every matching document, not just the page
+
+	Collapse *Collapse // Keeps only the best hit of each value of a field; nil keeps every hit
+
+	Suggest map[string]Suggester // Corrections of possibly misspelled text, returned by name
+
+	// TrackTotalHits is how many matches are counted exactly before documents
+	// that can't make the page may be skipped, leaving Total a lower bound
+	// 0 uses DefaultTrackTotalHits; TrackTotalHitsAll counts every match
+	TrackTotalHits int
+
+	// Timeout bounds the search; once it passes, the hits of the segments
+	// searched in time are returned with Response.TimedOut set. 0 for none
+	Timeout time.Duration
+
+	// ParseTime is how long the caller took to decode the request, reported
+	// as Timings.Parse
+	ParseTime time.Duration
+
+	// Profile returns where the search spent its time in Response.Profile,
+	// down to each term's posting list
+	Profile bool
+}
+
+// ResultWindowError is returned when From+Size exceeds the index's max result window
+type ResultWindowError struct {
+	From            int
+	Size            int
+	MaxResultWindow int
+}
+
+func (e *ResultWindowError) Error() string {
+	return fmt.Sprintf("result window is too large: from + size must be <= %d but was %d", e.MaxResultWindow, e.From+e.Size)
+}
+
+// Validate checks paging and sort parameters
+// maxResultWindow <= 0 uses DefaultMaxResultWindow
+func (req *Request) Validate(maxResultWindow int) error {
+	if req.From < 0 {
+		return fmt.Errorf("from must be >= 0, got %d", req.From)
+	}
+	if req.Size != nil && *req.Size < 0 {
+		return fmt.Errorf("size must be >= 0, got %d", *req.Size)
+	}
+	if maxResultWindow <= 0 {
+		maxResultWindow = DefaultMaxResultWindow
+	}
+	if req.From+req.PageSize() > maxResultWindow {
+		return &ResultWindowError{From: req.From, Size: req.PageSize(), MaxResultWindow: maxResultWindow}
+	}
+	if req.Highlight != nil {
+		if err := req.Highlight.Validate(); err != nil {
+			return err
+		}
+	}
+	return req.validateSort()
+}
+
+// validateSort checks the sort fields and search_after cursor
+func (req *Request) validateSort() error {
+	for _, sf := range req.Sort {
+		if err := sf.Validate(); err != nil {
+			return err
+		}
+	}
+	if req.SearchAfter != nil {
+		if len(req.Sort) == 0 {
+			return fmt.Errorf("search_after requires a sort")
+		}
+		if req.From != 0 {
+			return fmt.Errorf("from must be 0 when using search_after")
+		}
+	}
+	if req.Collapse != nil {
+		return req.Collapse.validate(req.Sort, req.SearchAfter)
+	}
+	return nil
+}
+
+// PageSize returns the number of hits to return, applying the default
+func (req *Request) PageSize() int {
+	if req.Size == nil {
+		return DefaultSize
+	}
+	return *req.Size
+}
+
+// Hit is one matching document
+type Hit struct {
+	Index    string          `json:"index,omitempty"` // Set when several indexes are searched together
+	ID       string          `json:"id"`
+	Score    float64         `json:"score"`
+	Sort     []interface{}   `json:"sort,omitempty"` // Sort values (nil when missing), then the _score and _id tiebreakers
+	Document *types.Document `json:"document,omitempty"`
+
+	Highlight map[string][]string `json:"highlight,omitempty"` // Fragments by field, when the request asks for them
+
+	Fields map[string][]interface{} `json:"fields,omitempty"` // The collapse field's value, when the request collapses (nil when missing)
+}
+
+// Response is the result of a search
+type Response struct {
+	Total         int           `json:"total"`          // Number of matching documents, before paging
+	TotalRelation TotalRelation `json:"total_relation"` // Whether Total is exact (see Request.TrackTotalHits)
+	MaxScore      float64       `json:"max_score"`
+	Hits          []Hit         `json:"hits"`
+	TimedOut      bool          `json:"timed_out"`              // The deadline passed; hits are from the segments searched in time
+	TotalGroups   int           `json:"total_groups,omitempty"` // Distinct values of the collapse field among the matches, when the request collapses
+
+	Aggregations map[string]AggregationResult `json:"aggregations,omitempty"`
+
+	Suggest map[string][]SuggestEntry `json:"suggest,omitempty"`
+
+	Timings Timings `json:"-"` // Time spent in each phase of the search
+
+	Profile []*Profile `json:"profile,omitempty"` // One per index searched, when the request asks for it
+}
+
+// Timings is the time a search spent in each phase
+// Documents are scored as they match, so matching and scoring are one phase;
+// a profile (see Request.Profile) tells the scoring apart
+type Timings struct {
+	Parse     time.Duration // Decoding the request, measured by the caller (see Request.ParseTime)
+	Query     time.Duration // Matching and scoring documents; with top-k collection, also keeping the best hits
+	Aggregate time.Duration
+	Sort      time.Duration // Sorting the hits of requests with a sort or aggregations
+	Fetch     time.Duration // Loading and highlighting the page's stored documents, by the caller
+}
+
+// Execute runs a request against a reader and returns the requested page of hits
+// Hits carry IDs and scores only; the caller loads stored documents as needed
+// Requests sorted by score alone, without aggregations or collapsing, keep
+// only their best From+Size hits, and may skip documents that can't make them
+// (see Request.TrackTotalHits)
+// The search stops with the reader's context error once it is cancelled; a
+// deadline, the context's or Request.Timeout, returns the hits found in time
+func Execute(r *Reader, req *Request) (*Response, error) {
+	if err := req.Validate(r.MaxResultWindow); err != nil {
+		return nil, err
+	}
+	r, cancel := req.withTimeout(r)
+	defer cancel()
+	if req.Profile {
+		r = r.withProfiler()
+	}
+
+	var resp *Response
+	var err error
+	if len(req.Sort) == 0 && len(req.Aggregations) == 0 && req.Collapse == nil {
+		query := req.Query
+		if query == nil {
+			query = &MatchAllQuery{}
+		}
+		queryStart := time.Now()
+		resp, err = collectTopSegments(r, query, req.From+req.PageSize(), req.trackTotalHits())
+		if err != nil {
+			err = fmt.Errorf("failed to execute query: %w", err)
+		} else {
+			resp.Timings.Query = time.Since(queryStart)
+		}
+	} else {
+		resp, err = Collect(r, req)
+	}
+	if timedOut(err) {
+		resp, err = timedOutResponse(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.Suggest, err = suggestAll(r, req.Suggest); err != nil {
+		return nil, err
+	}
+	resp.Timings.Parse = req.ParseTime
+	if p := r.profiler.profile(resp.Timings); p != nil {
+		resp.Profile = []*Profile{p}
+	}
+
+	start := req.From
+	if start > len(resp.Hits) {
+		start = len(resp.Hits)
+	}
+	end := start + req.PageSize()
+	if end > len(resp.Hits) {
+		end = len(resp.Hits)
+	}
+	resp.Hits = resp.Hits[start:end]
+	return resp, nil
+}
+
+// Count returns the number of documents matching a query; nil counts every document
+// The query runs in filter context (see FilterDocs), so queries implementing
+// DocMatcher aren't scored, and nothing is sorted or loaded
+func Count(r *Reader, query Query) (int, error) {
+	if query == nil {
+		return r.allowed().Cardinality(), nil
+	}
+	docs, err := FilterDocs(r, query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute query: %w", err)
+	}
+	return docs.And(r.allowed()).Cardinality(), nil
+}
+
+// Collect runs a request and returns every matching hit in sort order,
+// ignoring From, Size and the max result window
+func Collect(r *Reader, req *Request) (*Response, error) {
+	return collect(r, req, len(req.Sort) > 0)
+}
+
+// collect runs a request and sorts every hit; withValues keeps each hit's sort values
+func collect(r *Reader, req *Request, withValues bool) (*Response, error) {
+	if err := req.validateSort(); err != nil {
+		return nil, err
+	}
+
+	query := req.Query
+	if query == nil {
+		query = &MatchAllQuery{}
+	}
+	queryStart := time.Now()
+	matches, err := query.Execute(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	if err := r.checkContext(); err != nil {
+		return nil, err
+	}
+
+	resp := &Response{Total: len(matches), TotalRelation: TotalEqual}
+	resp.Timings.Query = time.Since(queryStart)
+	hits := make([]Hit, 0, len(matches))
+	for id, score := range matches {
+		hits = append(hits, Hit{ID: id, Score: score})
+		if score > resp.MaxScore {
+			resp.MaxScore = score
+		}
+	}
+
+	if len(req.Aggregations) > 0 {
+		aggStart := time.Now()
+		docIDs := make([]string, 0, len(matches))
+		for id := range matches {
+			docIDs = append(docIDs, id)
+		}
+		if resp.Aggregations, err = aggregateAll(r, req.Aggregations, docIDs); err != nil {
+			return nil, err
+		}
+		resp.Timings.Aggregate = time.Since(aggStart)
+	}
+
+	fields := effectiveSort(req.Sort)
+	var after *sortKey
+	if req.SearchAfter != nil {
+		key, err := cursorKey(fields, req.SearchAfter)
+		if err != nil {
+			return nil, err
+		}
+		after = &key
+	}
+	sortStart := time.Now()
+	resp.Hits = sortHits(r, hits, fields, after, withValues)
+	if req.Collapse != nil {
+		if resp.Hits, resp.TotalGroups, err = collapseHits(r, req.Collapse, resp.Hits); err != nil {
+			return nil, err
+		}
+	}
+	resp.Timings.Sort = time.Since(sortStart)
+	return resp, nil
+}
+
+// timedOutResponse is the response of a search whose deadline passed before it
+// found any hits
+func timedOutResponse() *Response {
+	return &Response{TotalRelation: TotalAtLeast, Hits: []Hit{}, TimedOut: true}
+}
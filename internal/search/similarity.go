@@ -0,0 +1,78 @@
+package search
+
+import (
+	"math"
+
+	"nano-elastic/internal/types"
+)
+
+// Similarity is the formula scoring a term's (or phrase's) matches in a text field
+// Fields pick theirs in the schema (see types.Schema.FieldSimilarity)
+type Similarity interface {
+	// IDF weighs a term by the number of documents containing it, out of the
+	// docCount documents that have the field; phrases sum their terms' IDFs
+	IDF(docFreq int, docCount int) float64
+
+	// Score scores termFreq occurrences of a term of weight idf in a field of
+	// fieldLength tokens
+	// It must not decrease as termFreq grows or as fieldLength shrinks: top-k
+	// collection bounds a term's scores with its posting list's impact bounds
+	Score(termFreq int, idf float64, fieldLength int, avgFieldLength float64) float64
+}
+
+// ClassicSimilarity is Lucene's classic TF-IDF: sqrt(tf) * idf / sqrt(fieldLength)
+type ClassicSimilarity struct{}
+
+// IDF implements Similarity
+func (ClassicSimilarity) IDF(docFreq int, docCount int) float64 {
+	return 1 + math.Log(float64(docCount+1)/float64(docFreq+1))
+}
+
+// Score implements Similarity
+func (ClassicSimilarity) Score(termFreq int, idf float64, fieldLength int, avgFieldLength float64) float64 {
+	norm := 1.0
+	if fieldLength > 0 {
+		norm = 1 / math.Sqrt(float64(fieldLength))
+	}
+	return math.Sqrt(float64(termFreq)) * idf * norm
+}
+
+// BooleanSimilarity scores each matching term (or phrase) 1, e.g. for fields
+// where only whether a term appears matters, like tags or names
+type BooleanSimilarity struct{}
+
+// IDF implements Similarity
+func (BooleanSimilarity) IDF(docFreq int, docCount int) float64 {
+	return 1.0
+}
+
+// Score implements Similarity
+func (BooleanSimilarity) Score(termFreq int, idf float64, fieldLength int, avgFieldLength float64) float64 {
+	return 1.0
+}
+
+// NewSimilarity returns the similarity a schema definition describes
+func NewSimilarity(def types.SimilarityDef) Similarity {
+	switch def.Type {
+	case types.SimilarityClassic:
+		return ClassicSimilarity{}
+	case types.SimilarityBoolean:
+		return BooleanSimilarity{}
+	}
+	s := BM25Similarity{K1: BM25K1, B: BM25B}
+	if def.K1 != nil {
+		s.K1 = *def.K1
+	}
+	if def.B != nil {
+		s.B = *def.B
+	}
+	return s
+}
+
+// similarity returns the similarity a field is scored with
+func (r *Reader) similarity(fieldName string) Similarity {
+	if r.Schema == nil {
+		return NewSimilarity(types.SimilarityDef{Type: types.SimilarityBM25})
+	}
+	return NewSimilarity(r.Schema.FieldSimilarity(fieldName))
+}
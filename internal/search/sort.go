@@ -0,0 +1,228 @@
+package search
+
+import (
+	"fmt"
+	"sort"
+
+	"nano-elastic/internal/index/docvalues"
+)
+
+// Pseudo-fields that can be sorted on besides document fields
+const (
+	ScoreField = "_score" // Relevance score
+	IDField    = "_id"    // Document ID
+)
+
+// SortOrder is the direction of a sort
+type SortOrder string
+
+const (
+	SortAsc  SortOrder = "asc"
+	SortDesc SortOrder = "desc"
+)
+
+// SortField orders hits by one field
+// Field may be a numeric, date, keyword or boolean field, ScoreField or IDField
+// Order defaults to ascending for fields and descending for the score
+type SortField struct {
+	Field string    `json:"field"`
+	Order SortOrder `json:"order,omitempty"`
+}
+
+// descending reports whether the sort runs high to low
+func (sf SortField) descending() bool {
+	if sf.Order == "" {
+		return sf.Field == ScoreField
+	}
+	return sf.Order == SortDesc
+}
+
+// Validate checks the sort order
+func (sf SortField) Validate() error {
+	if sf.Field == "" {
+		return fmt.Errorf("sort field is required")
+	}
+	if sf.Order != "" && sf.Order != SortAsc && sf.Order != SortDesc {
+		return fmt.Errorf("invalid sort order %q for field %s: must be asc or desc", sf.Order, sf.Field)
+	}
+	return nil
+}
+
+// effectiveSort appends the implicit tiebreakers (score descending, then ID) to
+// the requested sort, so every hit has a unique position that search_after can resume from
+func effectiveSort(fields []SortField) []SortField {
+	hasScore, hasID := false, false
+	for _, sf := range fields {
+		hasScore = hasScore || sf.Field == ScoreField
+		hasID = hasID || sf.Field == IDField
+	}
+
+	effective := append([]SortField(nil), fields...)
+	if !hasScore {
+		effective = append(effective, SortField{Field: ScoreField, Order: SortDesc})
+	}
+	if !hasID {
+		effective = append(effective, SortField{Field: IDField, Order: SortAsc})
+	}
+	return effective
+}
+
+// sortKey is one hit's values for every sort field, looked up once before sorting
+type sortKey struct {
+	values  []docvalues.Value
+	present []bool
+}
+
+// newSortKey resolves a hit's sort values from doc values
+func newSortKey(r *Reader, hit Hit, fields []SortField) sortKey {
+	key := sortKey{
+		values:  make([]docvalues.Value, len(fields)),
+		present: make([]bool, len(fields)),
+	}
+	for j, sf := range fields {
+		switch sf.Field {
+		case ScoreField:
+			key.values[j] = docvalues.Value{Kind: docvalues.KindNumeric, Num: hit.Score}
+			key.present[j] = true
+		case IDField:
+			key.values[j] = docvalues.Value{Kind: docvalues.KindKeyword, Str: hit.ID}
+			key.present[j] = true
+		default:
+			key.values[j], key.present[j] = r.DocValues.Get(sf.Field, hit.ID)
+		}
+	}
+	return key
+}
+
+// interfaces returns the key's values for the API (nil for missing values)
+func (k sortKey) interfaces() []interface{} {
+	out := make([]interface{}, len(k.values))
+	for j := range k.values {
+		if k.present[j] {
+			out[j] = k.values[j].Interface()
+		}
+	}
+	return out
+}
+
+// compareKeys orders two keys: -1 if a sorts first, 1 if b does, 0 if equal
+// Missing values sort last regardless of order
+func compareKeys(fields []SortField, a, b sortKey) int {
+	for j, sf := range fields {
+		if a.present[j] != b.present[j] {
+			if a.present[j] {
+				return -1
+			}
+			return 1
+		}
+		if !a.present[j] {
+			continue
+		}
+		c := compareValues(a.values[j], b.values[j])
+		if c == 0 {
+			continue
+		}
+		if sf.descending() {
+			return -c
+		}
+		return c
+	}
+	return 0
+}
+
+// compareValues compares values that may differ in kind, e.g. a cursor value
+// decoded from JSON against a doc value
+func compareValues(a, b docvalues.Value) int {
+	aKeyword, bKeyword := a.Kind == docvalues.KindKeyword, b.Kind == docvalues.KindKeyword
+	switch {
+	case aKeyword && bKeyword:
+		return a.Compare(b)
+	case aKeyword:
+		return 1 // Strings after numbers, so mixed dynamic fields still have a total order
+	case bKeyword:
+		return -1
+	}
+	return a.Compare(b)
+}
+
+// cursorKey converts search_after values into a key comparable with hit keys
+func cursorKey(fields []SortField, after []interface{}) (sortKey, error) {
+	if len(after) != len(fields) {
+		return sortKey{}, fmt.Errorf("search_after has %d values but the sort has %d (including the _score and _id tiebreakers)", len(after), len(fields))
+	}
+
+	key := sortKey{
+		values:  make([]docvalues.Value, len(fields)),
+		present: make([]bool, len(fields)),
+	}
+	for j, raw := range after {
+		if raw == nil {
+			continue // Missing value
+		}
+		value, err := cursorValue(raw)
+		if err != nil {
+			return sortKey{}, fmt.Errorf("invalid search_after value for %s: %w", fields[j].Field, err)
+		}
+		key.values[j] = value
+		key.present[j] = true
+	}
+	return key, nil
+}
+
+// cursorValue converts one search_after value (as returned in Hit.Sort, or
+// decoded from JSON) to a doc value
+func cursorValue(raw interface{}) (docvalues.Value, error) {
+	switch v := raw.(type) {
+	case string:
+		return docvalues.Value{Kind: docvalues.KindKeyword, Str: v}, nil
+	case float64:
+		return docvalues.Value{Kind: docvalues.KindNumeric, Num: v}, nil
+	case float32:
+		return docvalues.Value{Kind: docvalues.KindNumeric, Num: float64(v)}, nil
+	case int:
+		return docvalues.Value{Kind: docvalues.KindNumeric, Num: float64(v)}, nil
+	case int64:
+		return docvalues.Value{Kind: docvalues.KindNumeric, Num: float64(v)}, nil
+	case bool:
+		if v {
+			return docvalues.Value{Kind: docvalues.KindBoolean, Num: 1}, nil
+		}
+		return docvalues.Value{Kind: docvalues.KindBoolean, Num: 0}, nil
+	}
+	return docvalues.Value{}, fmt.Errorf("unsupported type %T", raw)
+}
+
+// sortHits orders hits by the effective sort fields and returns them
+// When after is set, only hits sorting strictly after the cursor are kept,
+// so skipped hits are never sorted
+// When withValues is set, each hit carries its sort values
+func sortHits(r *Reader, hits []Hit, fields []SortField, after *sortKey, withValues bool) []Hit {
+	keys := make([]sortKey, 0, len(hits))
+	kept := hits[:0]
+	for _, hit := range hits {
+		key := newSortKey(r, hit, fields)
+		if after != nil && compareKeys(fields, key, *after) <= 0 {
+			continue
+		}
+		keys = append(keys, key)
+		kept = append(kept, hit)
+	}
+
+	// Sort an index permutation so keys stay aligned with hits
+	order := make([]int, len(kept))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return compareKeys(fields, keys[order[a]], keys[order[b]]) < 0
+	})
+
+	sorted := make([]Hit, len(kept))
+	for i, idx := range order {
+		sorted[i] = kept[idx]
+		if withValues {
+			sorted[i].Sort = keys[idx].interfaces()
+		}
+	}
+	return sorted
+}
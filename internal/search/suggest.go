@@ -0,0 +1,655 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"nano-elastic/internal/analyzer"
+	"nano-elastic/internal/index/inverted"
+	"nano-elastic/internal/types"
+)
+
+// Suggester defaults, as in Elasticsearch
+const (
+	DefaultSuggestSize          = 5
+	DefaultSuggestPrefixLength  = 1 // Leading characters a correction must share with the term
+	DefaultSuggestMinWordLength = 4 // Shorter terms get no corrections
+	DefaultPhraseMaxErrors      = 1 // Terms a phrase suggestion may correct
+	DefaultPhraseConfidence     = 1.0
+
+	// realWordErrorLikelihood is the probability a term found in the index is
+	// what the user meant, weighing keeping it against correcting it; a term
+	// not in the index is as likely kept as corrected
+	realWordErrorLikelihood = 0.95
+
+	// backoffDiscount scales a term's own probability when it never follows
+	// the previous term in the index ("stupid backoff")
+	backoffDiscount = 0.4
+)
+
+// SuggestMode selects which terms of the text a TermSuggester corrects
+type SuggestMode string
+
+const (
+	SuggestMissing SuggestMode = "missing" // Only terms not in the index (the default)
+	SuggestPopular SuggestMode = "popular" // Also terms, with corrections in more documents
+	SuggestAlways  SuggestMode = "always"  // Every term, with any correction
+)
+
+// Suggester proposes text from the indexed documents, like Elasticsearch's
+// suggesters: corrections of possibly misspelled text, or completions
+type Suggester interface {
+	// Suggest returns the suggestions found in the reader's documents
+	Suggest(r *Reader) ([]SuggestEntry, error)
+}
+
+// SuggestEntry holds the suggestions for one part of the suggested text: a
+// term for a TermSuggester, the whole text for a PhraseSuggester and the
+// prefix for a CompletionSuggester
+type SuggestEntry struct {
+	Text    string          `json:"text"`
+	Offset  int             `json:"offset"` // Byte offset of Text in the suggested text
+	Length  int             `json:"length"` // Byte length of Text
+	Options []SuggestOption `json:"options"`
+
+	size int // Options kept when merging entries
+}
+
+// SuggestOption is one suggestion, best first within its entry
+type SuggestOption struct {
+	Text        string  `json:"text"`
+	Score       float64 `json:"score"`
+	Freq        int     `json:"freq,omitempty"`        // Documents containing the term, for term suggestions
+	Highlighted string  `json:"highlighted,omitempty"` // Text with the corrected terms tagged, for phrase suggestions asking for it
+	ID          string  `json:"_id,omitempty"`         // Document the text came from, for completion suggestions
+}
+
+// TermSuggester suggests, for each term of Text, dictionary terms of Field
+// within MaxEdits, ranked by edit distance and then document frequency
+type TermSuggester struct {
+	Field         string
+	Text          string
+	Size          int         // Corrections per term (default DefaultSuggestSize)
+	Mode          SuggestMode // Default SuggestMissing
+	MaxEdits      int         // Default, and capped at, inverted.MaxFuzzyEdits
+	PrefixLength  int         // Default DefaultSuggestPrefixLength; < 0 for none
+	MinWordLength int         // Default DefaultSuggestMinWordLength
+}
+
+// Suggest implements Suggester
+func (s *TermSuggester) Suggest(r *Reader) ([]SuggestEntry, error) {
+	if err := checkSuggestField(r, "term", s.Field); err != nil {
+		return nil, err
+	}
+	mode := s.Mode
+	if mode == "" {
+		mode = SuggestMissing
+	}
+	switch mode {
+	case SuggestMissing, SuggestPopular, SuggestAlways:
+	default:
+		return nil, fmt.Errorf("[term] unknown suggest_mode %q", mode)
+	}
+	size := s.Size
+	if size <= 0 {
+		size = DefaultSuggestSize
+	}
+
+	entries := []SuggestEntry{}
+	for _, token := range suggestTokens(r, s.Field, s.Text) {
+		entry := SuggestEntry{
+			Text:    s.Text[token.StartOffset:token.EndOffset],
+			Offset:  token.StartOffset,
+			Length:  token.EndOffset - token.StartOffset,
+			Options: []SuggestOption{},
+			size:    size,
+		}
+		freq := termDocFreq(r, s.Field, token.Term)
+		if mode != SuggestMissing || freq == 0 {
+			for _, c := range s.candidates(r, token, entry.Text) {
+				if c.Term == token.Term || (mode == SuggestPopular && c.DocFreq <= freq) {
+					continue
+				}
+				entry.Options = append(entry.Options, SuggestOption{Text: c.Term, Score: c.score, Freq: c.DocFreq})
+				if len(entry.Options) == size {
+					break
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// suggestCandidate is a dictionary term close to a term of the text
+type suggestCandidate struct {
+	inverted.FuzzyMatch
+	score float64 // 1 for the term itself, lower the more edits it takes
+}
+
+// candidates returns the dictionary terms within the suggester's edit distance
+// of a token, including the token itself if indexed, best first
+// source is the token's text before analysis
+func (s *TermSuggester) candidates(r *Reader, token analyzer.Token, source string) []suggestCandidate {
+	minWordLength := s.MinWordLength
+	if minWordLength <= 0 {
+		minWordLength = DefaultSuggestMinWordLength
+	}
+	prefixLength := s.PrefixLength
+	if prefixLength == 0 {
+		prefixLength = DefaultSuggestPrefixLength
+	}
+	maxEdits := s.MaxEdits
+	if maxEdits <= 0 || maxEdits > inverted.MaxFuzzyEdits {
+		maxEdits = inverted.MaxFuzzyEdits
+	}
+
+	length := utf8.RuneCountInString(token.Term)
+	if length < minWordLength {
+		return nil
+	}
+	var candidates []suggestCandidate
+	for _, m := range r.Inverted.FuzzyTerms(s.Field, source, maxEdits) {
+		if prefixLength > 0 && !sharePrefix(m.Term, token.Term, prefixLength) {
+			continue
+		}
+		longest := max(length, utf8.RuneCountInString(m.Term))
+		score := 1 - float64(m.Distance)/float64(longest)
+		candidates = append(candidates, suggestCandidate{FuzzyMatch: m, score: score})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	return candidates
+}
+
+// sharePrefix reports whether two terms share their first n characters
+func sharePrefix(a, b string, n int) bool {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) < n || len(rb) < n {
+		return string(ra) == string(rb)
+	}
+	return string(ra[:n]) == string(rb[:n])
+}
+
+// PhraseSuggester suggests corrections of the whole of Text: the term
+// suggestions of each term are recombined, and each combination scored by how
+// likely a bigram language model of Field's documents finds it, times how
+// likely each term was misspelled
+// Only combinations scoring above Confidence times the text's own score are
+// suggested
+type PhraseSuggester struct {
+	Field      string
+	Text       string
+	Size       int     // Suggestions to return (default DefaultSuggestSize)
+	MaxErrors  int     // Terms one suggestion may correct (default DefaultPhraseMaxErrors)
+	Confidence float64 // Default DefaultPhraseConfidence; < 0 returns every combination found
+
+	// PreTag and PostTag wrap the corrected terms in SuggestOption.Highlighted
+	// when set
+	PreTag  string
+	PostTag string
+}
+
+// phraseCandidates is the number of corrections considered for each term
+const phraseCandidates = 5
+
+// phraseOption is one of the candidates for a term of the phrase
+type phraseOption struct {
+	term    string
+	channel float64 // Likelihood the user meant this term when typing the original
+	edited  bool
+}
+
+// phrasePath is a partial combination of candidates, one per term so far
+type phrasePath struct {
+	options []int // Chosen option of each term
+	score   float64
+	errors  int
+}
+
+// Suggest implements Suggester
+func (s *PhraseSuggester) Suggest(r *Reader) ([]SuggestEntry, error) {
+	if err := checkSuggestField(r, "phrase", s.Field); err != nil {
+		return nil, err
+	}
+	size := s.Size
+	if size <= 0 {
+		size = DefaultSuggestSize
+	}
+	maxErrors := s.MaxErrors
+	if maxErrors <= 0 {
+		maxErrors = DefaultPhraseMaxErrors
+	}
+	confidence := s.Confidence
+	if confidence == 0 {
+		confidence = DefaultPhraseConfidence
+	}
+
+	entry := SuggestEntry{Text: s.Text, Length: len(s.Text), Options: []SuggestOption{}, size: size}
+	tokens := suggestTokens(r, s.Field, s.Text)
+	if len(tokens) == 0 {
+		return []SuggestEntry{entry}, nil
+	}
+
+	// Candidates of each term: the term itself first, then its corrections
+	generator := &TermSuggester{Field: s.Field, Mode: SuggestAlways}
+	options := make([][]phraseOption, len(tokens))
+	for i, token := range tokens {
+		keep := realWordErrorLikelihood
+		if termDocFreq(r, s.Field, token.Term) == 0 {
+			keep = 1 - realWordErrorLikelihood
+		}
+		options[i] = []phraseOption{{term: token.Term, channel: keep}}
+		added := 0
+		for _, c := range generator.candidates(r, token, s.Text[token.StartOffset:token.EndOffset]) {
+			if c.Term == token.Term {
+				continue
+			}
+			channel := (1 - realWordErrorLikelihood) * c.score
+			options[i] = append(options[i], phraseOption{term: c.Term, channel: channel, edited: true})
+			if added++; added == phraseCandidates {
+				break
+			}
+		}
+	}
+
+	lm := newBigramModel(r, s.Field)
+	original := 0.0
+	for i := range tokens {
+		original += lm.logProb(options, i, 0, 0) + math.Log(options[i][0].channel)
+	}
+
+	// Beam search over the combinations, keeping the best partial ones
+	beam := max(size*4, 20)
+	paths := []phrasePath{{}}
+	for i := range tokens {
+		var next []phrasePath
+		for _, path := range paths {
+			for j, option := range options[i] {
+				errors := path.errors
+				if option.edited {
+					errors++
+				}
+				if errors > maxErrors {
+					continue
+				}
+				prev := 0
+				if i > 0 {
+					prev = path.options[i-1]
+				}
+				score := path.score + lm.logProb(options, i, prev, j) + math.Log(option.channel)
+				chosen := append(append([]int(nil), path.options...), j)
+				next = append(next, phrasePath{options: chosen, score: score, errors: errors})
+			}
+		}
+		sort.SliceStable(next, func(a, b int) bool { return next[a].score > next[b].score })
+		if len(next) > beam {
+			next = next[:beam]
+		}
+		paths = next
+	}
+
+	threshold := original + math.Log(confidence)
+	for _, path := range paths {
+		if path.errors == 0 || (confidence > 0 && path.score <= threshold) {
+			continue
+		}
+		text, highlighted := s.rewrite(tokens, options, path.options)
+		option := SuggestOption{Text: text, Score: math.Exp(path.score)}
+		if s.PreTag != "" || s.PostTag != "" {
+			option.Highlighted = highlighted
+		}
+		entry.Options = append(entry.Options, option)
+		if len(entry.Options) == size {
+			break
+		}
+	}
+	return []SuggestEntry{entry}, nil
+}
+
+// rewrite replaces the corrected terms in the text, returning it plain and
+// with the corrections tagged
+func (s *PhraseSuggester) rewrite(tokens []analyzer.Token, options [][]phraseOption, chosen []int) (string, string) {
+	var plain, tagged strings.Builder
+	last := 0
+	for i, token := range tokens {
+		option := options[i][chosen[i]]
+		if !option.edited {
+			continue
+		}
+		plain.WriteString(s.Text[last:token.StartOffset])
+		tagged.WriteString(s.Text[last:token.StartOffset])
+		plain.WriteString(option.term)
+		tagged.WriteString(s.PreTag + option.term + s.PostTag)
+		last = token.EndOffset
+	}
+	plain.WriteString(s.Text[last:])
+	tagged.WriteString(s.Text[last:])
+	return plain.String(), tagged.String()
+}
+
+// bigramModel estimates how likely sequences of a field's terms are from the
+// document frequencies of single terms and of adjacent pairs
+type bigramModel struct {
+	r       *Reader
+	field   string
+	docs    float64
+	unigram map[string]float64
+	bigram  map[[2]string]float64
+}
+
+// newBigramModel creates a model of a field's documents
+func newBigramModel(r *Reader, field string) *bigramModel {
+	docCount, _ := r.Inverted.FieldStats(field)
+	return &bigramModel{
+		r:       r,
+		field:   field,
+		docs:    float64(docCount),
+		unigram: make(map[string]float64),
+		bigram:  make(map[[2]string]float64),
+	}
+}
+
+// logProb returns the log probability of option j of term i following option
+// prev of term i-1
+func (m *bigramModel) logProb(options [][]phraseOption, i, prev, j int) float64 {
+	term := options[i][j].term
+	if i == 0 {
+		return math.Log(m.unigramProb(term))
+	}
+	before := options[i-1][prev].term
+	if n := m.bigramFreq(before, term); n > 0 {
+		return math.Log(n / m.docFreq(before))
+	}
+	return math.Log(backoffDiscount * m.unigramProb(term))
+}
+
+// unigramProb is a term's add-one smoothed document frequency, so terms not in
+// the index keep a small probability
+func (m *bigramModel) unigramProb(term string) float64 {
+	return (m.docFreq(term) + 1) / (m.docs + 1)
+}
+
+// docFreq returns the documents containing a term
+func (m *bigramModel) docFreq(term string) float64 {
+	if n, ok := m.unigram[term]; ok {
+		return n
+	}
+	n := float64(termDocFreq(m.r, m.field, term))
+	m.unigram[term] = n
+	return n
+}
+
+// bigramFreq returns the documents containing two terms next to each other
+func (m *bigramModel) bigramFreq(a, b string) float64 {
+	key := [2]string{a, b}
+	if n, ok := m.bigram[key]; ok {
+		return n
+	}
+	n := 0.0
+	first := m.r.Inverted.TermPostings(m.field, a)
+	second := m.r.Inverted.TermPostings(m.field, b)
+	if first != nil && second != nil {
+		terms := []phraseTerm{{offset: 0, lists: []*inverted.PostingList{first}}, {offset: 1, lists: []*inverted.PostingList{second}}}
+		for _, posting := range first.Postings {
+			if m.r.allowsDoc(posting.Doc) && phraseFreq(posting.Doc, terms) > 0 {
+				n++
+			}
+		}
+	}
+	m.bigram[key] = n
+	return n
+}
+
+// suggestTokens analyzes suggested text with the field's search analyzer,
+// keeping one token per position
+func suggestTokens(r *Reader, field, text string) []analyzer.Token {
+	var tokens []analyzer.Token
+	for _, token := range r.Inverted.AnalyzeQuery(field, text) {
+		if len(tokens) > 0 && tokens[len(tokens)-1].Position == token.Position {
+			continue // A synonym of the previous term
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// termDocFreq returns the documents of a field containing an analyzed term
+func termDocFreq(r *Reader, field, term string) int {
+	if pl := r.Inverted.TermPostings(field, term); pl != nil {
+		return pl.DocFreq
+	}
+	return 0
+}
+
+// checkSuggestField rejects suggesters on fields without a term dictionary
+func checkSuggestField(r *Reader, suggester, field string) error {
+	if field == "" {
+		return fmt.Errorf("[%s] suggester requires a field", suggester)
+	}
+	if fieldType, ok := r.fieldType(field); ok && fieldType != types.FieldTypeText {
+		return fmt.Errorf("[%s] suggester field %s is %s, not text", suggester, field, fieldType)
+	}
+	return nil
+}
+
+// suggestAll runs named suggesters against the same reader
+func suggestAll(r *Reader, suggesters map[string]Suggester) (map[string][]SuggestEntry, error) {
+	if len(suggesters) == 0 {
+		return nil, nil
+	}
+	results := make(map[string][]SuggestEntry, len(suggesters))
+	for name, s := range suggesters {
+		entries, err := s.Suggest(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute suggestion [%s]: %w", name, err)
+		}
+		results[name] = entries
+	}
+	return results, nil
+}
+
+// mergeSuggestions merges the named suggestions of the same suggesters from
+// several readers: options with the same text are combined, adding their
+// frequencies and keeping their best score
+func mergeSuggestions(a, b map[string][]SuggestEntry) map[string][]SuggestEntry {
+	if a == nil {
+		return b
+	}
+	merged := make(map[string][]SuggestEntry, len(a))
+	for name, entries := range a {
+		others := b[name]
+		out := make([]SuggestEntry, len(entries))
+		for i, entry := range entries {
+			out[i] = entry
+			if i < len(others) {
+				out[i] = entry.merge(others[i])
+			}
+		}
+		merged[name] = out
+	}
+	return merged
+}
+
+// merge combines the options of the same entry from another reader
+// Completions of different documents stay apart
+func (e SuggestEntry) merge(other SuggestEntry) SuggestEntry {
+	type optionKey struct{ text, id string }
+	byKey := make(map[optionKey]int, len(e.Options))
+	options := make([]SuggestOption, 0, len(e.Options)+len(other.Options))
+	for _, option := range append(append([]SuggestOption(nil), e.Options...), other.Options...) {
+		key := optionKey{option.Text, option.ID}
+		if i, ok := byKey[key]; ok {
+			options[i].Freq += option.Freq
+			options[i].Score = math.Max(options[i].Score, option.Score)
+			continue
+		}
+		byKey[key] = len(options)
+		options = append(options, option)
+	}
+	sort.SliceStable(options, func(i, j int) bool {
+		if options[i].Score != options[j].Score {
+			return options[i].Score > options[j].Score
+		}
+		return options[i].Freq > options[j].Freq
+	})
+	if size := max(e.size, other.size); size > 0 && len(options) > size {
+		options = options[:size]
+	}
+	e.Options = options
+	e.size = max(e.size, other.size)
+	return e
+}
+
+// parseSuggestDSL decodes named suggesters, with a "text" shared by those
+// that don't set their own; completion suggesters take a "prefix" instead:
+//
+//	{"text": "noble prize",
+//	 "fix_terms": {"term": {"field": "title", "suggest_mode": "always"}},
+//	 "fix_phrase": {"phrase": {"field": "title", "max_errors": 2}},
+//	 "autocomplete": {"prefix": "nir", "completion": {"field": "suggest"}}}
+func parseSuggestDSL(data []byte) (map[string]Suggester, error) {
+	var named map[string]json.RawMessage
+	if err := json.Unmarshal(data, &named); err != nil {
+		return nil, fmt.Errorf("[suggest] must be an object of named suggesters: %w", err)
+	}
+	var globalText string
+	if raw, ok := named["text"]; ok {
+		if err := json.Unmarshal(raw, &globalText); err != nil {
+			return nil, fmt.Errorf("[suggest] text must be a string: %w", err)
+		}
+		delete(named, "text")
+	}
+
+	suggesters := make(map[string]Suggester, len(named))
+	for name, raw := range named {
+		var def map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &def); err != nil {
+			return nil, fmt.Errorf("[suggest] [%s] must be an object: %w", name, err)
+		}
+		text := globalText
+		if rawText, ok := def["text"]; ok {
+			if err := json.Unmarshal(rawText, &text); err != nil {
+				return nil, fmt.Errorf("[suggest] [%s] text must be a string: %w", name, err)
+			}
+			delete(def, "text")
+		}
+		if rawPrefix, ok := def["prefix"]; ok {
+			if err := json.Unmarshal(rawPrefix, &text); err != nil {
+				return nil, fmt.Errorf("[suggest] [%s] prefix must be a string: %w", name, err)
+			}
+			delete(def, "prefix")
+		}
+		if len(def) != 1 {
+			return nil, fmt.Errorf("[suggest] [%s] must have exactly one suggester type", name)
+		}
+
+		var s Suggester
+		var err error
+		for kind, body := range def {
+			switch kind {
+			case "term":
+				s, err = parseTermSuggesterDSL(body, text)
+			case "phrase":
+				s, err = parsePhraseSuggesterDSL(body, text)
+			case "completion":
+				s, err = parseCompletionSuggesterDSL(body, text)
+			default:
+				err = fmt.Errorf("unknown suggester type [%s]", kind)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("[suggest] [%s]: %w", name, err)
+		}
+		suggesters[name] = s
+	}
+	return suggesters, nil
+}
+
+// parseTermSuggesterDSL decodes {"field": "title", "size": 5, "suggest_mode":
+// "missing", "max_edits": 2, "prefix_length": 1, "min_word_length": 4}
+func parseTermSuggesterDSL(body json.RawMessage, text string) (Suggester, error) {
+	var params struct {
+		Field         string `json:"field"`
+		Size          int    `json:"size"`
+		SuggestMode   string `json:"suggest_mode"`
+		MaxEdits      int    `json:"max_edits"`
+		PrefixLength  *int   `json:"prefix_length"`
+		MinWordLength int    `json:"min_word_length"`
+	}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, fmt.Errorf("[term] invalid parameters: %w", err)
+	}
+	if params.Field == "" {
+		return nil, fmt.Errorf("[term] requires [field]")
+	}
+	if params.MaxEdits < 0 || params.MaxEdits > inverted.MaxFuzzyEdits {
+		return nil, fmt.Errorf("[term] max_edits must be between 1 and %d, got %d", inverted.MaxFuzzyEdits, params.MaxEdits)
+	}
+	s := &TermSuggester{
+		Field:         params.Field,
+		Text:          text,
+		Size:          params.Size,
+		Mode:          SuggestMode(params.SuggestMode),
+		MaxEdits:      params.MaxEdits,
+		MinWordLength: params.MinWordLength,
+	}
+	switch s.Mode {
+	case "", SuggestMissing, SuggestPopular, SuggestAlways:
+	default:
+		return nil, fmt.Errorf("[term] unknown suggest_mode [%s]", params.SuggestMode)
+	}
+	if params.PrefixLength != nil {
+		s.PrefixLength = *params.PrefixLength
+		if s.PrefixLength == 0 {
+			s.PrefixLength = -1 // Explicitly none
+		}
+	}
+	return s, nil
+}
+
+// parsePhraseSuggesterDSL decodes {"field": "title", "size": 5, "max_errors":
+// 1, "confidence": 1.0, "highlight": {"pre_tag": "<em>", "post_tag": "</em>"}}
+func parsePhraseSuggesterDSL(body json.RawMessage, text string) (Suggester, error) {
+	var params struct {
+		Field      string   `json:"field"`
+		Size       int      `json:"size"`
+		MaxErrors  int      `json:"max_errors"`
+		Confidence *float64 `json:"confidence"`
+		Highlight  *struct {
+			PreTag  string `json:"pre_tag"`
+			PostTag string `json:"post_tag"`
+		} `json:"highlight"`
+	}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, fmt.Errorf("[phrase] invalid parameters: %w", err)
+	}
+	if params.Field == "" {
+		return nil, fmt.Errorf("[phrase] requires [field]")
+	}
+	if params.MaxErrors < 0 {
+		return nil, fmt.Errorf("[phrase] max_errors must be >= 1, got %d", params.MaxErrors)
+	}
+	s := &PhraseSuggester{Field: params.Field, Text: text, Size: params.Size, MaxErrors: params.MaxErrors}
+	if params.Confidence != nil {
+		if *params.Confidence < 0 {
+			return nil, fmt.Errorf("[phrase] confidence must be >= 0, got %g", *params.Confidence)
+		}
+		s.Confidence = *params.Confidence
+		if s.Confidence == 0 {
+			s.Confidence = -1 // Explicitly every combination
+		}
+	}
+	if h := params.Highlight; h != nil {
+		s.PreTag, s.PostTag = h.PreTag, h.PostTag
+		if s.PreTag == "" && s.PostTag == "" {
+			s.PreTag, s.PostTag = DefaultPreTag, DefaultPostTag
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,191 @@
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Template is a saved search request with $name placeholders, rendered with
+// parameters into a request:
+//
+//	{"description": "...", "source": {"query": {"match": {"author": "$author"}}, "size": "$size"},
+//	 "params": {"size": 10}}
+//
+// A string that is only a placeholder becomes the parameter's value, of any
+// JSON type; placeholders within longer strings, and in object keys, are
+// replaced by the value as text. "$$" is a literal "$"
+// Params holds defaults for parameters the caller may leave out
+type Template struct {
+	Description string                 `json:"description,omitempty"`
+	Source      json.RawMessage        `json:"source"`
+	Params      map[string]interface{} `json:"params,omitempty"`
+}
+
+// templatePlaceholder matches $$ and $name
+var templatePlaceholder = regexp.MustCompile(`\$(\$|[A-Za-z_][A-Za-z0-9_]*)`)
+
+// MissingParamError is returned when rendering a template without a value for
+// one of its placeholders
+type MissingParamError struct {
+	Param string
+}
+
+func (e *MissingParamError) Error() string {
+	return fmt.Sprintf("missing template parameter [%s]", e.Param)
+}
+
+// ParseTemplate decodes a template definition
+func ParseTemplate(data []byte) (*Template, error) {
+	var t Template
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("invalid search template: %w", err)
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Validate checks that the template's source is a JSON object
+func (t *Template) Validate() error {
+	if len(bytes.TrimSpace(t.Source)) == 0 {
+		return fmt.Errorf("invalid search template: [source] is required")
+	}
+	_, err := decodeTemplateSource(t.Source)
+	return err
+}
+
+// Placeholders returns the names of the template's parameters, sorted
+func (t *Template) Placeholders() []string {
+	names := make(map[string]struct{})
+	for _, match := range templatePlaceholder.FindAllStringSubmatch(string(t.Source), -1) {
+		if match[1] != "$" {
+			names[match[1]] = struct{}{}
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// Render substitutes the parameters, over the template's defaults, into its
+// source and returns the search request body
+func (t *Template) Render(params map[string]interface{}) ([]byte, error) {
+	source, err := decodeTemplateSource(t.Source)
+	if err != nil {
+		return nil, err
+	}
+	lookup := func(name string) (interface{}, error) {
+		if value, ok := params[name]; ok {
+			return value, nil
+		}
+		if value, ok := t.Params[name]; ok {
+			return value, nil
+		}
+		return nil, &MissingParamError{Param: name}
+	}
+	rendered, err := renderTemplateValue(source, lookup)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(rendered)
+}
+
+// Request renders the template and parses the result as a search request
+func (t *Template) Request(params map[string]interface{}) (*Request, error) {
+	body, err := t.Render(params)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSearchRequest(body)
+}
+
+// decodeTemplateSource decodes a template's source, keeping numbers as written
+func decodeTemplateSource(data json.RawMessage) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var source interface{}
+	if err := decoder.Decode(&source); err != nil {
+		return nil, fmt.Errorf("invalid search template source: %w", err)
+	}
+	if _, ok := source.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("invalid search template: [source] must be an object")
+	}
+	return source, nil
+}
+
+func renderTemplateValue(value interface{}, lookup func(string) (interface{}, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		// A whole-string placeholder keeps the parameter's type
+		if match := templatePlaceholder.FindStringSubmatch(v); match != nil && match[0] == v && match[1] != "$" {
+			return lookup(match[1])
+		}
+		return renderTemplateString(v, lookup)
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			r, err := renderTemplateValue(item, lookup)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			k, err := renderTemplateString(key, lookup)
+			if err != nil {
+				return nil, err
+			}
+			r, err := renderTemplateValue(item, lookup)
+			if err != nil {
+				return nil, err
+			}
+			rendered[k] = r
+		}
+		return rendered, nil
+	}
+	return value, nil
+}
+
+// renderTemplateString replaces the placeholders within a string by their
+// values as text
+func renderTemplateString(s string, lookup func(string) (interface{}, error)) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+	var renderErr error
+	rendered := templatePlaceholder.ReplaceAllStringFunc(s, func(match string) string {
+		name := match[1:]
+		if renderErr != nil {
+			return ""
+		}
+		if name == "$" {
+			return "$"
+		}
+		value, err := lookup(name)
+		if err != nil {
+			renderErr = err
+			return ""
+		}
+		if text, ok := value.(string); ok {
+			return text
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			renderErr = fmt.Errorf("template parameter [%s]: %w", name, err)
+			return ""
+		}
+		return string(encoded)
+	})
+	return rendered, renderErr
+}
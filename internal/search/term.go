@@ -0,0 +1,278 @@
+package search
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"nano-elastic/internal/analyzer"
+	"nano-elastic/internal/index/bitmap"
+	"nano-elastic/internal/index/inverted"
+	"nano-elastic/internal/index/numeric"
+	"nano-elastic/internal/types"
+)
+
+// TermQuery matches documents whose field contains the exact, unanalyzed value
+// Keyword, numeric and boolean fields match with a constant score;
+// text fields are scored with their similarity (BM25 by default) against the indexed term
+type TermQuery struct {
+	Field string
+	Value string
+}
+
+// Execute implements Query
+func (q *TermQuery) Execute(r *Reader) (Matches, error) {
+	fieldType, declared := r.fieldType(q.Field)
+	switch {
+	case fieldType == types.FieldTypeKeyword:
+		return constantScore(r.Keywords.SearchTerm(q.Field, q.Value), 1.0), nil
+	case fieldType == types.FieldTypeNumeric:
+		v, err := strconv.ParseFloat(q.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid numeric term %q for field %s: %w", q.Value, q.Field, err)
+		}
+		return constantScore(r.Numeric.Search(q.Field, numeric.Range().Gte(v).Lte(v)), 1.0), nil
+	case fieldType == types.FieldTypeBoolean:
+		b, err := strconv.ParseBool(q.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean term %q for field %s: %w", q.Value, q.Field, err)
+		}
+		return constantScore(booleanMatches(r, q.Field, b), 1.0), nil
+	case !declared && r.Keywords.DocFreq(q.Field, q.Value) > 0:
+		// Dynamic keyword field
+		return constantScore(r.Keywords.SearchTerm(q.Field, q.Value), 1.0), nil
+	}
+
+	pl := r.Inverted.TermPostings(q.Field, q.Value)
+	if pl == nil {
+		return Matches{}, nil
+	}
+	return scorePostings(r, q.Field, q.Value, pl), nil
+}
+
+// MatchDocs implements DocMatcher: text terms are read from the posting list
+// without computing BM25 scores
+func (q *TermQuery) MatchDocs(r *Reader) (*bitmap.Bitmap, error) {
+	fieldType, declared := r.fieldType(q.Field)
+	switch {
+	case fieldType == types.FieldTypeKeyword, fieldType == types.FieldTypeNumeric, fieldType == types.FieldTypeBoolean,
+		!declared && r.Keywords.DocFreq(q.Field, q.Value) > 0:
+		m, err := q.Execute(r)
+		if err != nil {
+			return nil, err
+		}
+		return r.docSet(m), nil
+	}
+
+	docs := bitmap.New()
+	if pl := r.Inverted.TermPostings(q.Field, q.Value); pl != nil {
+		timer := r.profileTerm(q.Field, q.Value)
+		start := r.profiler.start()
+		for _, posting := range pl.Postings {
+			docs.Add(posting.Doc)
+		}
+		timer.read(len(pl.Postings))
+		r.profiler.finish(start, timer)
+	}
+	return docs, nil
+}
+
+// booleanMatches returns documents whose boolean field equals b, from doc values
+func booleanMatches(r *Reader, fieldName string, b bool) []string {
+	want := 0.0
+	if b {
+		want = 1.0
+	}
+	var docIDs []string
+	for id := range r.AllDocs {
+		if v, ok := r.DocValues.Get(fieldName, id); ok && v.Num == want {
+			docIDs = append(docIDs, id)
+		}
+	}
+	return docIDs
+}
+
+// MatchQuery analyzes the query text with the field's search analyzer and
+// matches documents containing any of the resulting terms (all of them with
+// OperatorAnd, or as many as MinimumShouldMatch says), summing their scores
+// Non-text fields fall back to a TermQuery on the raw text
+type MatchQuery struct {
+	Field              string
+	Query              string
+	Operator           Operator // OperatorOr (default) or OperatorAnd
+	MinimumShouldMatch string   // With OperatorOr, terms that must match, e.g. "2", "-1" or "75%" (see MinimumShouldMatch)
+}
+
+// Execute implements Query
+func (q *MatchQuery) Execute(r *Reader) (Matches, error) {
+	if fieldType, ok := r.fieldType(q.Field); ok && fieldType != types.FieldTypeText {
+		return (&TermQuery{Field: q.Field, Value: q.Query}).Execute(r)
+	}
+
+	tokens, required, err := q.analyze(r)
+	if err != nil {
+		return nil, err
+	}
+	lists := make([]*inverted.PostingList, len(tokens))
+	scorers := make([]*termScorer, len(tokens))
+	for i, token := range tokens {
+		if pl := r.Inverted.TermPostings(q.Field, token.Term); pl != nil {
+			lists[i] = r.postings(pl)
+			scorers[i] = newTermScorer(r, q.Field, token.Term, pl)
+		}
+	}
+
+	// Document-at-a-time: each document's terms are scored together, and the
+	// document is kept only if enough query positions matched
+	matches := make(Matches)
+	if r.profiler != nil {
+		timers := make([]*termTimer, 0, len(scorers))
+		for i, scorer := range scorers {
+			if scorer != nil {
+				scorer.timer.read(len(lists[i].Postings))
+				timers = append(timers, scorer.timer)
+			}
+		}
+		defer r.profiler.finish(r.profiler.start(), timers...)
+	}
+	union := inverted.NewDisjunction(lists)
+	matched := make(map[int]bool, required)
+	docs := 0
+	for doc, ok := union.Next(); ok; doc, ok = union.Next() {
+		docs++
+		if err := r.checkContextEvery(docs); err != nil {
+			return nil, err
+		}
+		if !r.allowsDoc(doc) {
+			continue
+		}
+		clear(matched)
+		score := 0.0
+		for _, m := range union.Matches() {
+			score += scorers[m.List].score(m.Posting)
+			matched[tokens[m.List].Position] = true
+		}
+		if len(matched) >= required {
+			matches[r.Inverted.DocID(doc)] = score
+		}
+	}
+	return matches, nil
+}
+
+// collectTop implements topScorer with WAND when a single term is enough to match
+func (q *MatchQuery) collectTop(r *Reader, top *topHits, trackTotalHits int) (int, bool, bool, error) {
+	if fieldType, ok := r.fieldType(q.Field); ok && fieldType != types.FieldTypeText {
+		return 0, false, false, nil
+	}
+	tokens, required, err := q.analyze(r)
+	if err != nil || required > 1 {
+		return 0, false, false, err
+	}
+
+	terms := make([]*wandTerm, 0, len(tokens))
+	for _, token := range tokens {
+		if pl := r.Inverted.TermPostings(q.Field, token.Term); pl != nil {
+			scorer := newTermScorer(r, q.Field, token.Term, pl)
+			terms = append(terms, &wandTerm{it: r.postings(pl).Iterator(), scorer: scorer, maxScore: scorer.maxScore(pl)})
+		}
+	}
+	total, exact, err := collectWAND(r, terms, top, trackTotalHits)
+	return total, exact, true, err
+}
+
+// analyze analyzes the query text with the field's search analyzer and returns
+// the tokens and how many of their positions a document must match
+// Terms are counted by query position; synonyms at a position are alternatives
+func (q *MatchQuery) analyze(r *Reader) ([]analyzer.Token, int, error) {
+	tokens := r.Inverted.AnalyzeQuery(q.Field, q.Query)
+	positions := make(map[int]bool)
+	for _, token := range tokens {
+		positions[token.Position] = true
+	}
+	if q.Operator == OperatorAnd {
+		return tokens, len(positions), nil
+	}
+	if q.MinimumShouldMatch == "" {
+		return tokens, 1, nil
+	}
+	n, err := MinimumShouldMatch(q.MinimumShouldMatch, len(positions))
+	if err != nil {
+		return nil, 0, err
+	}
+	return tokens, max(n, 1), nil
+}
+
+// PrefixQuery matches documents with a term starting with Prefix
+// On keyword fields the whole value must start with Prefix
+// All matches score 1, like Elasticsearch's constant-score rewrite
+type PrefixQuery struct {
+	Field  string
+	Prefix string
+}
+
+// Execute implements Query
+func (q *PrefixQuery) Execute(r *Reader) (Matches, error) {
+	if r.isKeywordField(q.Field) {
+		return constantScore(r.Keywords.SearchMatching(q.Field, func(value string) bool {
+			return strings.HasPrefix(value, q.Prefix)
+		}), 1.0), nil
+	}
+	return postingsConstantScore(r, r.Inverted.SearchPrefix(q.Field, q.Prefix)), nil
+}
+
+// WildcardQuery matches documents with a term matching a '*' / '?' pattern
+// On keyword fields the whole value must match
+// All matches score 1, like Elasticsearch's constant-score rewrite
+type WildcardQuery struct {
+	Field   string
+	Pattern string
+}
+
+// Execute implements Query
+func (q *WildcardQuery) Execute(r *Reader) (Matches, error) {
+	if r.isKeywordField(q.Field) {
+		return constantScore(r.Keywords.SearchMatching(q.Field, func(value string) bool {
+			return inverted.WildcardMatch(q.Pattern, value)
+		}), 1.0), nil
+	}
+	return postingsConstantScore(r, r.Inverted.SearchWildcard(q.Field, q.Pattern)), nil
+}
+
+// postingsConstantScore scores every document of a (possibly nil) posting list as 1
+func postingsConstantScore(r *Reader, pl *inverted.PostingList) Matches {
+	if pl == nil {
+		return Matches{}
+	}
+	return constantScore(r.Inverted.DocIDs(pl), 1.0)
+}
+
+// FuzzyQuery matches documents with a term within MaxEdits of Term
+// MaxEdits < 0 picks the edit distance from the term length (see inverted.AutoFuzziness)
+// Each document scores the score of its best matching term
+type FuzzyQuery struct {
+	Field    string
+	Term     string
+	MaxEdits int
+}
+
+// Execute implements Query
+func (q *FuzzyQuery) Execute(r *Reader) (Matches, error) {
+	maxEdits := q.MaxEdits
+	if maxEdits < 0 {
+		maxEdits = inverted.AutoFuzziness(q.Term)
+	}
+
+	matches := make(Matches)
+	for _, fm := range r.Inverted.FuzzyTerms(q.Field, q.Term, maxEdits) {
+		pl := r.Inverted.TermPostings(q.Field, fm.Term)
+		if pl == nil {
+			continue
+		}
+		for id, score := range scorePostings(r, q.Field, fm.Term, pl) {
+			if score > matches[id] {
+				matches[id] = score
+			}
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,270 @@
+package search
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+
+	"nano-elastic/internal/index/inverted"
+)
+
+const (
+	// DefaultTrackTotalHits is how many matches are counted exactly when a
+	// request doesn't set TrackTotalHits, like Elasticsearch's default
+	DefaultTrackTotalHits = 10000
+
+	// TrackTotalHitsAll counts every match, so no document can be skipped
+	TrackTotalHitsAll = -1
+)
+
+// TotalRelation tells whether Response.Total is exact
+type TotalRelation string
+
+const (
+	TotalEqual   TotalRelation = "eq"  // Total is the number of matching documents
+	TotalAtLeast TotalRelation = "gte" // Total is a lower bound: documents that couldn't make the page were skipped
+)
+
+// trackTotalHits returns the number of matches to count exactly, applying the default
+// Negative means all of them
+func (req *Request) trackTotalHits() int {
+	if req.TrackTotalHits == 0 {
+		return DefaultTrackTotalHits
+	}
+	return req.TrackTotalHits
+}
+
+// topScorer is implemented by queries that can collect their k best hits
+// without scoring every matching document
+// ok is false when the query can't, in its current configuration
+type topScorer interface {
+	collectTop(r *Reader, top *topHits, trackTotalHits int) (total int, exact bool, ok bool, err error)
+}
+
+// collectTop runs a request sorted by score and returns its best k hits
+// Queries implementing topScorer may skip documents once trackTotalHits have
+// been counted; every other query is scored in full and only the heap is bounded
+func collectTop(r *Reader, query Query, k int, trackTotalHits int) (*Response, error) {
+	if err := r.checkContext(); err != nil {
+		return nil, err
+	}
+	top := newTopHits(k)
+	if ts, ok := query.(topScorer); ok {
+		total, exact, ok, err := ts.collectTop(r, top, trackTotalHits)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			resp := &Response{Total: total, TotalRelation: TotalEqual, Hits: top.sorted()}
+			if !exact {
+				resp.TotalRelation = TotalAtLeast
+			}
+			if len(resp.Hits) > 0 {
+				resp.MaxScore = resp.Hits[0].Score
+			}
+			return resp, nil
+		}
+	}
+
+	matches, err := query.Execute(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkContext(); err != nil {
+		return nil, err
+	}
+	resp := &Response{TotalRelation: TotalEqual}
+	for id, score := range matches {
+		if !r.allows(id) {
+			continue // Not every query applies the reader's filter, e.g. a segment's (see collectTopSegments)
+		}
+		resp.Total++
+		top.add(Hit{ID: id, Score: score})
+		if score > resp.MaxScore {
+			resp.MaxScore = score
+		}
+	}
+	resp.Hits = top.sorted()
+	return resp, nil
+}
+
+// topHits keeps the best hits seen in a min-heap, so the worst kept hit is
+// replaced first
+// Hits are ordered as the default sort orders them: by score, then by ID
+type topHits struct {
+	hits hitHeap
+	k    int
+}
+
+// newTopHits creates a collector for the best k hits
+func newTopHits(k int) *topHits {
+	return &topHits{hits: make(hitHeap, 0, k), k: k}
+}
+
+// add offers a hit
+func (t *topHits) add(hit Hit) {
+	if len(t.hits) < t.k {
+		heap.Push(&t.hits, hit)
+	} else if t.k > 0 && t.hits.less(t.hits[0], hit) {
+		t.hits[0] = hit
+		heap.Fix(&t.hits, 0)
+	}
+}
+
+// threshold returns the score a document must reach to be kept: the worst
+// kept score once the collector is full, -Inf before
+// A document scoring exactly the threshold may still beat the worst hit on its ID
+func (t *topHits) threshold() float64 {
+	if len(t.hits) < t.k || t.k == 0 {
+		return math.Inf(-1)
+	}
+	return t.hits[0].Score
+}
+
+// sorted returns the kept hits, best first
+func (t *topHits) sorted() []Hit {
+	hits := []Hit(t.hits)
+	sort.Slice(hits, func(i, j int) bool {
+		return t.hits.less(hits[j], hits[i])
+	})
+	return hits
+}
+
+// hitHeap is a min-heap of hits by score
+type hitHeap []Hit
+
+// less orders hits from worst to best: lower scores first, then higher IDs
+func (h hitHeap) less(a, b Hit) bool {
+	if a.Score != b.Score {
+		return a.Score < b.Score
+	}
+	return a.ID > b.ID
+}
+
+func (h hitHeap) Len() int            { return len(h) }
+func (h hitHeap) Less(i, j int) bool  { return h.less(h[i], h[j]) }
+func (h hitHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *hitHeap) Push(x interface{}) { *h = append(*h, x.(Hit)) }
+func (h *hitHeap) Pop() interface{} {
+	old := *h
+	hit := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return hit
+}
+
+// wandTerm is one query term in a WAND evaluation
+type wandTerm struct {
+	it       *inverted.PostingIterator
+	scorer   *termScorer
+	maxScore float64 // Upper bound of the term's score in any document
+}
+
+// read counts the posting the term's iterator moved to, when profiling
+func (t *wandTerm) read() {
+	if !t.it.Done() {
+		t.scorer.timer.read(1)
+	}
+}
+
+// collectWAND scores the union of the terms' posting lists into top with WAND
+// (weak AND): the terms are kept ordered by their current document, and the
+// first document whose preceding terms' score bounds add up to the collector's
+// threshold is the pivot. Terms behind the pivot skip straight to it, so
+// documents that can't make the top hits are never scored
+// Skipping starts once trackTotalHits documents have been counted (never if
+// it is negative); total is exact if no document was skipped
+func collectWAND(r *Reader, terms []*wandTerm, top *topHits, trackTotalHits int) (total int, exact bool, err error) {
+	live := make([]*wandTerm, 0, len(terms))
+	timers := make([]*termTimer, 0, len(terms))
+	for _, t := range terms {
+		if !t.it.Done() {
+			live = append(live, t)
+			t.read()
+		}
+		timers = append(timers, t.scorer.timer)
+	}
+	defer r.profiler.finish(r.profiler.start(), timers...)
+
+	exact = true
+	for i := 1; len(live) > 0; i++ {
+		if err := r.checkContextEvery(i); err != nil {
+			return 0, false, err
+		}
+		sort.Slice(live, func(i, j int) bool {
+			return live[i].it.Posting().Doc < live[j].it.Posting().Doc
+		})
+
+		threshold := math.Inf(-1)
+		if trackTotalHits >= 0 && total >= trackTotalHits {
+			threshold = top.threshold()
+		}
+
+		// Find the pivot: the first term at which the bounds reach the threshold
+		pivot := -1
+		bound := 0.0
+		for i, t := range live {
+			bound += t.maxScore
+			if bound >= threshold {
+				pivot = i
+				break
+			}
+		}
+		if pivot < 0 {
+			exact = false // No remaining document can make the top hits
+			break
+		}
+		pivotDoc := live[pivot].it.Posting().Doc
+
+		if live[0].it.Posting().Doc != pivotDoc {
+			// Terms before the pivot are on documents too low to score; move
+			// them to the pivot
+			for _, t := range live[:pivot] {
+				if t.it.Posting().Doc < pivotDoc {
+					t.it.Advance(pivotDoc)
+					t.read()
+					exact = false
+				}
+			}
+		} else {
+			// Every term up to the pivot is on it; score it with all its terms,
+			// in query order like MatchQuery.Execute
+			if r.allowsDoc(pivotDoc) {
+				score := 0.0
+				for _, t := range terms {
+					if !t.it.Done() && t.it.Posting().Doc == pivotDoc {
+						score += t.scorer.score(t.it.Posting())
+					}
+				}
+				top.add(Hit{ID: r.Inverted.DocID(pivotDoc), Score: score})
+				total++
+			}
+			for _, t := range live {
+				if t.it.Posting().Doc == pivotDoc {
+					t.it.Next()
+					t.read()
+				}
+			}
+		}
+
+		kept := live[:0]
+		for _, t := range live {
+			if !t.it.Done() {
+				kept = append(kept, t)
+			}
+		}
+		live = kept
+	}
+	return total, exact, nil
+}
+
+// maxScore returns an upper bound of the scorer's score for any posting of
+// pl, from the list's impact bounds and Similarity's monotonicity, with a
+// little headroom for rounding
+func (s *termScorer) maxScore(pl *inverted.PostingList) float64 {
+	minLength := pl.MinFieldLength
+	if docCount, _ := s.r.Inverted.FieldStats(s.field); docCount == 0 {
+		minLength = 0 // The index has no field lengths; every document scores as length 0
+	}
+	bound := s.sim.Score(pl.MaxTermFreq, s.idf, minLength, s.avgLength) * s.boost
+	return bound * (1 + 1e-9)
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"nano-elastic/internal/engine"
+)
+
+// handleGetAliases handles GET /_aliases
+func (s *Server) handleGetAliases(w http.ResponseWriter, r *http.Request) {
+	names := s.engine.ListIndexes()
+	aliases := indexAliases(s.engine.Aliases(), names)
+	body := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		body[name] = map[string]interface{}{"aliases": aliases[name]}
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// handleUpdateAliases handles POST /_aliases, applying every action atomically:
+//
+//	{"actions": [{"remove": {"index": "products-v1", "alias": "products"}},
+//	             {"add": {"index": "products-v2", "alias": "products"}}]}
+func (s *Server) handleUpdateAliases(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Actions []map[string]struct {
+			Index string `json:"index"`
+			Alias string `json:"alias"`
+		} `json:"actions"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeError(w, http.StatusBadRequest, "parsing_exception", "invalid aliases request: "+err.Error())
+		return
+	}
+
+	var actions []engine.AliasAction
+	for _, raw := range request.Actions {
+		if len(raw) != 1 {
+			writeError(w, http.StatusBadRequest, "parsing_exception", "each alias action must have exactly one of add or remove")
+			return
+		}
+		for actionType, params := range raw {
+			actions = append(actions, engine.AliasAction{
+				Type:  engine.AliasActionType(actionType),
+				Alias: params.Alias,
+				Index: params.Index,
+			})
+		}
+	}
+
+	s.updateAliases(w, actions)
+}
+
+// handlePutAlias handles PUT /{index}/_alias/{alias}
+func (s *Server) handlePutAlias(w http.ResponseWriter, r *http.Request) {
+	s.updateAliases(w, []engine.AliasAction{{
+		Type:  engine.AliasAdd,
+		Alias: r.PathValue("alias"),
+		Index: r.PathValue("index"),
+	}})
+}
+
+// handleDeleteAlias handles DELETE /{index}/_alias/{alias}
+func (s *Server) handleDeleteAlias(w http.ResponseWriter, r *http.Request) {
+	s.updateAliases(w, []engine.AliasAction{{
+		Type:  engine.AliasRemove,
+		Alias: r.PathValue("alias"),
+		Index: r.PathValue("index"),
+	}})
+}
+
+// updateAliases applies alias actions and writes the response
+func (s *Server) updateAliases(w http.ResponseWriter, actions []engine.AliasAction) {
+	if err := s.engine.UpdateAliases(actions); err != nil {
+		writeIndexError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
+
+// indexAliases inverts an alias table into the aliases of each of the given
+// indexes, in the Elasticsearch response shape {"alias": {}}
+func indexAliases(aliases map[string][]string, indexes []string) map[string]map[string]interface{} {
+	byIndex := make(map[string]map[string]interface{}, len(indexes))
+	for _, name := range indexes {
+		byIndex[name] = make(map[string]interface{})
+	}
+	for alias, names := range aliases {
+		for _, name := range names {
+			if byIndex[name] != nil {
+				byIndex[name][alias] = struct{}{}
+			}
+		}
+	}
+	return byIndex
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"nano-elastic/internal/engine"
+	"nano-elastic/internal/errdefs"
+	"nano-elastic/internal/ingest"
+)
+
+// bulkLine is one action of an NDJSON bulk body with its document source
+type bulkLine struct {
+	action   engine.BulkAction
+	index    string
+	id       string
+	pipeline string                 // Ingest pipeline, if the action or request names one
+	source   map[string]interface{} // Nil for deletes
+}
+
+// bulkItemResponse is the per-item entry of a bulk response
+type bulkItemResponse struct {
+	Index  string      `json:"_index"`
+	ID     string      `json:"_id"`
+	Status int         `json:"status"`
+	Result string      `json:"result,omitempty"`
+	Error  interface{} `json:"error,omitempty"`
+}
+
+// handleBulk handles POST /_bulk and POST /{index}/_bulk
+// The body is NDJSON: an action line ({"index": {...}}, {"create": {...}} or
+// {"delete": {...}}) followed, for index and create, by the document source
+// Documents go through the ingest pipeline their action or the pipeline
+// parameter names, or else their index's default one
+func (s *Server) handleBulk(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	refresh, err := parseRefresh(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		return
+	}
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	lines, err := parseBulkBody(body, r.PathValue("index"), r.URL.Query().Get("pipeline"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		return
+	}
+
+	// Group items per index, keeping their order within each index
+	items := make([]bulkItemResponse, len(lines))
+	var order []string
+	byIndex := make(map[string][]int)
+	for i, line := range lines {
+		items[i] = bulkItemResponse{Index: line.index, ID: line.id}
+		if _, ok := byIndex[line.index]; !ok {
+			order = append(order, line.index)
+		}
+		byIndex[line.index] = append(byIndex[line.index], i)
+	}
+
+	for _, name := range order {
+		positions := byIndex[name]
+		idx, err := s.engine.GetOrCreateIndex(name, nil)
+		if err != nil {
+			var invalid *engine.InvalidIndexNameError
+			if !errors.As(err, &invalid) {
+				writeError(w, http.StatusInternalServerError, "exception", err.Error())
+				return
+			}
+			for _, i := range positions {
+				items[i].Status = http.StatusBadRequest
+				items[i].Error = errorBody("invalid_index_name_exception", err.Error())
+			}
+			continue
+		}
+
+		var batch []engine.BulkItem
+		var batchPositions []int
+		for _, i := range positions {
+			items[i].Index = idx.Name // Resolved through an alias
+			item := engine.BulkItem{Action: lines[i].action, ID: lines[i].id}
+			if lines[i].source != nil {
+				ingested := &ingest.Document{ID: lines[i].id, Source: lines[i].source}
+				if err := s.engine.Ingest(idx, lines[i].pipeline, ingested); err != nil {
+					items[i].Status, items[i].Error = http.StatusBadRequest, errorBody("illegal_argument_exception", err.Error())
+					continue
+				}
+				item.ID, items[i].ID = ingested.ID, ingested.ID
+				doc, err := idx.DocumentFromSource(ingested.ID, ingested.Source)
+				if err != nil {
+					items[i].Status, items[i].Error = bulkError(err)
+					continue
+				}
+				item.Document = doc
+			}
+			batch = append(batch, item)
+			batchPositions = append(batchPositions, i)
+		}
+
+		results, err := idx.BulkContext(r.Context(), batch)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				writeCancelled(w, err) // Earlier indexes' batches stay applied
+				return
+			}
+			writeError(w, http.StatusInternalServerError, "exception", err.Error())
+			return
+		}
+		if err := refresh.apply(idx); err != nil {
+			writeError(w, http.StatusInternalServerError, "exception", err.Error())
+			return
+		}
+		for k, result := range results {
+			i := batchPositions[k]
+			if result.Err != nil {
+				items[i].Status, items[i].Error = bulkError(result.Err)
+				continue
+			}
+			items[i].Result = result.Result
+			items[i].Status = bulkStatus(result.Result)
+		}
+	}
+
+	hasErrors := false
+	response := make([]map[string]bulkItemResponse, len(items))
+	for i, item := range items {
+		if item.Error != nil {
+			hasErrors = true
+		}
+		response[i] = map[string]bulkItemResponse{string(lines[i].action): item}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"took":   time.Since(start).Milliseconds(),
+		"errors": hasErrors,
+		"items":  response,
+	})
+}
+
+// parseBulkBody splits an NDJSON bulk body into actions
+// defaultIndex is used for actions that don't name an index, and
+// defaultPipeline for those that don't name a pipeline
+func parseBulkBody(body []byte, defaultIndex string, defaultPipeline string) ([]bulkLine, error) {
+	var raw [][]byte
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			raw = append(raw, line)
+		}
+	}
+
+	var lines []bulkLine
+	for n := 0; n < len(raw); n++ {
+		var action map[string]struct {
+			Index    string `json:"_index"`
+			ID       string `json:"_id"`
+			Pipeline string `json:"pipeline"`
+		}
+		if err := json.Unmarshal(raw[n], &action); err != nil || len(action) != 1 {
+			return nil, fmt.Errorf("malformed action on line %d, expected {\"index\"|\"create\"|\"delete\": {...}}", n+1)
+		}
+
+		var line bulkLine
+		for name, meta := range action {
+			line = bulkLine{action: engine.BulkAction(name), index: meta.Index, id: meta.ID, pipeline: meta.Pipeline}
+		}
+		if line.pipeline == "" {
+			line.pipeline = defaultPipeline
+		}
+		if line.action != engine.BulkIndex && line.action != engine.BulkCreate && line.action != engine.BulkDelete {
+			return nil, fmt.Errorf("unsupported bulk action %q on line %d", line.action, n+1)
+		}
+		if line.index == "" {
+			line.index = defaultIndex
+		}
+		if line.index == "" {
+			return nil, fmt.Errorf("action on line %d is missing an _index", n+1)
+		}
+
+		switch line.action {
+		case engine.BulkIndex, engine.BulkCreate:
+			if n+1 >= len(raw) {
+				return nil, fmt.Errorf("%s action on line %d has no document", line.action, n+1)
+			}
+			n++
+			if err := json.Unmarshal(raw[n], &line.source); err != nil || line.source == nil {
+				return nil, fmt.Errorf("document on line %d must be a JSON object", n+1)
+			}
+			if line.id == "" {
+				line.id = newDocumentID()
+			}
+		case engine.BulkDelete:
+			if line.id == "" {
+				return nil, fmt.Errorf("delete action on line %d is missing an _id", n+1)
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// bulkStatus returns the HTTP status reported for a successful item
+func bulkStatus(result string) int {
+	switch result {
+	case engine.ResultCreated:
+		return http.StatusCreated
+	case engine.ResultNotFound:
+		return http.StatusNotFound
+	}
+	return http.StatusOK
+}
+
+// bulkError returns the status and error body reported for a failed item
+func bulkError(err error) (int, interface{}) {
+	var migrationErr *engine.MigrationError
+	var hookErr *engine.HookError
+	switch {
+	case errors.Is(err, errdefs.ErrSchemaValidation), errors.As(err, &migrationErr):
+		return http.StatusBadRequest, errorBody("mapper_parsing_exception", err.Error())
+	case errors.As(err, &hookErr):
+		return http.StatusBadRequest, errorBody("illegal_argument_exception", err.Error())
+	case errors.Is(err, errdefs.ErrVersionConflict):
+		return http.StatusConflict, errorBody("version_conflict_engine_exception", err.Error())
+	case errors.Is(err, errdefs.ErrDocNotFound):
+		return http.StatusNotFound, errorBody("document_missing_exception", err.Error())
+	}
+	return http.StatusInternalServerError, errorBody("exception", err.Error())
+}
+
+// newDocumentID generates a random URL-safe document ID
+func newDocumentID() string {
+	var b [15]byte
+	rand.Read(b[:])
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
@@ -0,0 +1,493 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"nano-elastic/internal/engine"
+	"nano-elastic/internal/errdefs"
+	"nano-elastic/internal/ingest"
+	"nano-elastic/internal/search"
+	"nano-elastic/internal/types"
+)
+
+// maxBodyBytes caps request bodies
+const maxBodyBytes = 16 << 20
+
+// handleListIndexes handles GET /_cat/indices
+func (s *Server) handleListIndexes(w http.ResponseWriter, r *http.Request) {
+	names := s.engine.ListIndexes()
+	indices := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		idx, err := s.engine.GetIndex(name)
+		if err != nil {
+			continue // Deleted concurrently
+		}
+		indices = append(indices, map[string]interface{}{
+			"index":      name,
+			"docs.count": idx.DocCount(),
+		})
+	}
+	writeJSON(w, http.StatusOK, indices)
+}
+
+// handleCreateIndex handles PUT /{index} with an optional
+// {"settings": {...}, "mappings": {...}} body
+func (s *Server) handleCreateIndex(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("index")
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	schema, err := types.SchemaFromMappings(name, body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "mapper_parsing_exception", err.Error())
+		return
+	}
+	var create struct {
+		Settings json.RawMessage `json:"settings"`
+	}
+	json.Unmarshal(body, &create) // Already checked by SchemaFromMappings
+	settings, err := parseSettings(create.Settings, true)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		return
+	}
+	if settings != (types.Settings{}) {
+		schema.Settings = &settings
+	}
+
+	if _, err := s.engine.CreateIndex(name, schema); err != nil {
+		writeIndexError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"acknowledged": true,
+		"index":        name,
+	})
+}
+
+// handleGetIndex handles GET /{index}, returning the mappings and aliases of
+// the index, or of every index behind an alias
+func (s *Server) handleGetIndex(w http.ResponseWriter, r *http.Request) {
+	indexes, err := s.engine.ResolveIndexes(r.PathValue("index"))
+	if err != nil {
+		writeIndexError(w, err)
+		return
+	}
+
+	names := make([]string, len(indexes))
+	for i, idx := range indexes {
+		names[i] = idx.Name
+	}
+	aliases := indexAliases(s.engine.Aliases(), names)
+	body := make(map[string]interface{}, len(indexes))
+	for _, idx := range indexes {
+		entry := map[string]interface{}{
+			"aliases":  aliases[idx.Name],
+			"mappings": idx.Schema.Mappings(),
+		}
+		if settings := indexSettings(idx.Schema, idx.Settings()); len(settings) > 0 {
+			entry["settings"] = map[string]interface{}{"index": settings}
+		}
+		body[idx.Name] = entry
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// handleDeleteIndex handles DELETE /{index}
+func (s *Server) handleDeleteIndex(w http.ResponseWriter, r *http.Request) {
+	if err := s.engine.DeleteIndex(r.PathValue("index")); err != nil {
+		writeIndexError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
+
+// handlePutDocument handles PUT /{index}/_doc/{id} with the document source as the body
+// The index is created with a dynamic schema if it doesn't exist
+// The pipeline parameter names the ingest pipeline run on the document
+// instead of the index's default one ("_none" for none)
+func (s *Server) handlePutDocument(w http.ResponseWriter, r *http.Request) {
+	name, id := r.PathValue("index"), r.PathValue("id")
+	refresh, err := parseRefresh(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		return
+	}
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	var source map[string]interface{}
+	if err := json.Unmarshal(body, &source); err != nil {
+		writeError(w, http.StatusBadRequest, "mapper_parsing_exception", "document must be a JSON object: "+err.Error())
+		return
+	}
+
+	idx, err := s.engine.GetOrCreateIndex(name, nil)
+	if err != nil {
+		writeIndexError(w, err)
+		return
+	}
+	ingested := &ingest.Document{ID: id, Source: source}
+	if err := s.engine.Ingest(idx, r.URL.Query().Get("pipeline"), ingested); err != nil {
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		return
+	}
+	id, source = ingested.ID, ingested.Source
+
+	_, getErr := idx.GetDocument(id)
+	if getErr != nil && !errors.Is(getErr, errdefs.ErrDocNotFound) {
+		writeError(w, http.StatusInternalServerError, "exception", getErr.Error())
+		return
+	}
+	created := getErr != nil
+	if _, err := idx.IndexSource(id, source); err != nil {
+		var migrationErr *engine.MigrationError
+		var hookErr *engine.HookError
+		if errors.Is(err, errdefs.ErrSchemaValidation) || errors.As(err, &migrationErr) {
+			writeError(w, http.StatusBadRequest, "mapper_parsing_exception", err.Error())
+			return
+		}
+		if errors.As(err, &hookErr) {
+			writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "exception", err.Error())
+		return
+	}
+	if err := refresh.apply(idx); err != nil {
+		writeError(w, http.StatusInternalServerError, "exception", err.Error())
+		return
+	}
+
+	status, result := http.StatusOK, "updated"
+	if created {
+		status, result = http.StatusCreated, "created"
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"_index": idx.Name,
+		"_id":    id,
+		"result": result,
+	})
+}
+
+// handleGetDocument handles GET /{index}/_doc/{id}
+// The _source, _source_includes and _source_excludes parameters filter the source
+func (s *Server) handleGetDocument(w http.ResponseWriter, r *http.Request) {
+	name, id := r.PathValue("index"), r.PathValue("id")
+	idx, ok := s.getIndex(w, name)
+	if !ok {
+		return
+	}
+
+	doc, err := idx.GetDocument(id)
+	if err != nil {
+		if !errors.Is(err, errdefs.ErrDocNotFound) {
+			writeError(w, http.StatusInternalServerError, "exception", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			"_index": idx.Name,
+			"_id":    id,
+			"found":  false,
+		})
+		return
+	}
+
+	result := map[string]interface{}{
+		"_index":   idx.Name,
+		"_id":      id,
+		"_version": doc.Version,
+		"found":    true,
+	}
+	if filter := parseSourceParams(r, nil); filter == nil || !filter.Disabled {
+		result["_source"] = filter.Apply(doc).Source()
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleDeleteDocument handles DELETE /{index}/_doc/{id}
+func (s *Server) handleDeleteDocument(w http.ResponseWriter, r *http.Request) {
+	name, id := r.PathValue("index"), r.PathValue("id")
+	refresh, err := parseRefresh(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		return
+	}
+	idx, ok := s.getIndex(w, name)
+	if !ok {
+		return
+	}
+
+	if err := idx.DeleteDocument(id); err != nil {
+		if !errors.Is(err, errdefs.ErrDocNotFound) {
+			writeError(w, http.StatusInternalServerError, "exception", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			"_index": idx.Name,
+			"_id":    id,
+			"result": "not_found",
+		})
+		return
+	}
+	if err := refresh.apply(idx); err != nil {
+		writeError(w, http.StatusInternalServerError, "exception", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"_index": idx.Name,
+		"_id":    id,
+		"result": "deleted",
+	})
+}
+
+// handleSearch handles GET/POST /{index}/_search; an alias searches all of its indexes
+// The body is a JSON search request; the q (with df), from, size, timeout,
+// profile and _source (with _source_includes and _source_excludes) URL
+// parameters are also accepted
+// The search stops once the client disconnects
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	name := r.PathValue("index")
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	req, err := search.ParseSearchRequest(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+		return
+	}
+	s.search(w, r, name, req, start)
+}
+
+// search applies a search request's URL parameters, runs it against an index
+// or alias, and writes the response
+func (s *Server) search(w http.ResponseWriter, r *http.Request, name string, req *search.Request, start time.Time) {
+	params := r.URL.Query()
+	if q := params.Get("q"); q != "" {
+		qs := &search.QueryStringQuery{Query: q}
+		if df := params.Get("df"); df != "" {
+			qs.DefaultFields = strings.Split(df, ",")
+		}
+		req.Query = qs
+	}
+	if params.Get("size") != "" {
+		req.Size = new(int) // An explicit size=0 returns no hits
+	}
+	for key, dst := range map[string]*int{"from": &req.From, "size": req.Size} {
+		if v := params.Get(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "illegal_argument_exception", "invalid "+key+": "+v)
+				return
+			}
+			*dst = n
+		}
+	}
+	if v := params.Get("timeout"); v != "" {
+		timeout, err := parseTimeValue(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "illegal_argument_exception", "invalid timeout: "+err.Error())
+			return
+		}
+		req.Timeout = timeout
+	}
+	if v := params.Get("profile"); v != "" {
+		profile, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "illegal_argument_exception", "invalid profile: "+v)
+			return
+		}
+		req.Profile = profile
+	}
+	req.Source = parseSourceParams(r, req.Source)
+	req.ParseTime = time.Since(start)
+
+	resp, err := s.engine.SearchContext(r.Context(), name, req)
+	if err != nil {
+		var notFound *engine.IndexNotFoundError
+		var aliasErr *engine.AliasError
+		if errors.As(err, &notFound) || errors.As(err, &aliasErr) {
+			writeIndexError(w, err)
+			return
+		}
+		if errors.Is(err, context.Canceled) {
+			writeCancelled(w, err)
+			return
+		}
+		writeError(w, http.StatusBadRequest, "search_phase_execution_exception", err.Error())
+		return
+	}
+
+	hits := make([]map[string]interface{}, 0, len(resp.Hits))
+	for _, hit := range resp.Hits {
+		h := map[string]interface{}{
+			"_index": hit.Index,
+			"_id":    hit.ID,
+			"_score": hit.Score,
+		}
+		if hit.Document != nil {
+			h["_source"] = hit.Document.Source()
+		}
+		if hit.Sort != nil {
+			h["sort"] = hit.Sort
+		}
+		if hit.Highlight != nil {
+			h["highlight"] = hit.Highlight
+		}
+		if hit.Fields != nil {
+			h["fields"] = hit.Fields
+		}
+		hits = append(hits, h)
+	}
+
+	hitsSection := map[string]interface{}{
+		"total":     map[string]interface{}{"value": resp.Total, "relation": resp.TotalRelation},
+		"max_score": resp.MaxScore,
+		"hits":      hits,
+	}
+	if req.Collapse != nil {
+		hitsSection["total_groups"] = resp.TotalGroups
+	}
+	result := map[string]interface{}{
+		"took":      time.Since(start).Milliseconds(),
+		"timed_out": resp.TimedOut,
+		"hits":      hitsSection,
+	}
+	if resp.Aggregations != nil {
+		result["aggregations"] = resp.Aggregations
+	}
+	if resp.Suggest != nil {
+		result["suggest"] = resp.Suggest
+	}
+	if resp.Profile != nil {
+		result["profile"] = map[string]interface{}{"shards": resp.Profile}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleCount handles GET/POST /{index}/_count; an alias counts all of its indexes
+// The body is {"query": {...}}; the q (with df) URL parameters are also accepted
+func (s *Server) handleCount(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("index")
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	var request struct {
+		Query json.RawMessage `json:"query"`
+	}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &request); err != nil {
+			writeError(w, http.StatusBadRequest, "parsing_exception", "invalid count request: "+err.Error())
+			return
+		}
+	}
+
+	var query search.Query
+	if len(request.Query) > 0 {
+		q, err := search.ParseQueryDSL(request.Query)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+			return
+		}
+		query = q
+	}
+	if q := r.URL.Query().Get("q"); q != "" {
+		qs := &search.QueryStringQuery{Query: q}
+		if df := r.URL.Query().Get("df"); df != "" {
+			qs.DefaultFields = strings.Split(df, ",")
+		}
+		query = qs
+	}
+
+	count, err := s.engine.CountContext(r.Context(), name, query)
+	if err != nil {
+		var notFound *engine.IndexNotFoundError
+		var aliasErr *engine.AliasError
+		if errors.As(err, &notFound) || errors.As(err, &aliasErr) {
+			writeIndexError(w, err)
+			return
+		}
+		if errors.Is(err, context.Canceled) {
+			writeCancelled(w, err)
+			return
+		}
+		writeError(w, http.StatusBadRequest, "search_phase_execution_exception", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"count": count})
+}
+
+// readBody reads the request body, writing an error response on failure
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "illegal_argument_exception", "failed to read body: "+err.Error())
+		return nil, false
+	}
+	return body, true
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeError writes an Elasticsearch-style error response
+func writeError(w http.ResponseWriter, status int, errType string, reason string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error":  errorBody(errType, reason),
+		"status": status,
+	})
+}
+
+// writeCancelled writes the response for a request stopped by its context,
+// e.g. once the client disconnected
+func writeCancelled(w http.ResponseWriter, err error) {
+	writeError(w, http.StatusBadRequest, "task_cancelled_exception", err.Error())
+}
+
+// errorBody builds the "error" object of an error response
+func errorBody(errType string, reason string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":   errType,
+		"reason": strings.TrimSpace(reason),
+	}
+}
+
+// writeIndexError writes the response for an engine index error
+func writeIndexError(w http.ResponseWriter, err error) {
+	var notFound *engine.IndexNotFoundError
+	var exists *engine.IndexExistsError
+	var invalid *engine.InvalidIndexNameError
+	var aliasErr *engine.AliasError
+	var migrationErr *engine.MigrationError
+	var settingsErr *types.SettingsError
+	switch {
+	case errors.As(err, &notFound):
+		writeError(w, http.StatusNotFound, "index_not_found_exception", err.Error())
+	case errors.As(err, &exists):
+		writeError(w, http.StatusBadRequest, "resource_already_exists_exception", err.Error())
+	case errors.As(err, &invalid):
+		writeError(w, http.StatusBadRequest, "invalid_index_name_exception", err.Error())
+	case errors.As(err, &aliasErr), errors.As(err, &migrationErr), errors.As(err, &settingsErr):
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "exception", err.Error())
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"nano-elastic/internal/engine"
+	"nano-elastic/internal/ingest"
+)
+
+// handleGetPipelines handles GET /_ingest/pipeline and /_ingest/pipeline/{id}
+func (s *Server) handleGetPipelines(w http.ResponseWriter, r *http.Request) {
+	pipelines := s.engine.Pipelines()
+	if name := r.PathValue("id"); name != "" {
+		pipeline, ok := pipelines[name]
+		if !ok {
+			writePipelineError(w, &engine.PipelineNotFoundError{Name: name})
+			return
+		}
+		pipelines = map[string]*ingest.Pipeline{name: pipeline}
+	}
+	writeJSON(w, http.StatusOK, pipelines)
+}
+
+// handlePutPipeline handles PUT /_ingest/pipeline/{id} with a
+// {"description": "...", "processors": [...]} body
+func (s *Server) handlePutPipeline(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	pipeline, err := ingest.ParsePipeline(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "parse_exception", err.Error())
+		return
+	}
+	if err := s.engine.PutPipeline(r.PathValue("id"), pipeline); err != nil {
+		writePipelineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
+
+// handleDeletePipeline handles DELETE /_ingest/pipeline/{id}
+func (s *Server) handleDeletePipeline(w http.ResponseWriter, r *http.Request) {
+	if err := s.engine.DeletePipeline(r.PathValue("id")); err != nil {
+		writePipelineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
+
+// handleSimulatePipeline handles POST /_ingest/pipeline/{id}/_simulate and
+// POST /_ingest/pipeline/_simulate, running documents through a stored
+// pipeline, or one given in the body, without indexing them:
+//
+//	{"pipeline": {...}, "docs": [{"_id": "1", "_source": {...}}]}
+//
+// Each document's result is its processed source or the error it failed with
+func (s *Server) handleSimulatePipeline(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	var request struct {
+		Pipeline json.RawMessage `json:"pipeline"`
+		Docs     []struct {
+			ID     string                 `json:"_id"`
+			Source map[string]interface{} `json:"_source"`
+		} `json:"docs"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeError(w, http.StatusBadRequest, "parse_exception", "invalid simulate request: "+err.Error())
+		return
+	}
+
+	var pipeline *ingest.Pipeline
+	var err error
+	if name := r.PathValue("id"); name != "" {
+		pipeline, err = s.engine.Pipeline(name)
+		if err != nil {
+			writePipelineError(w, err)
+			return
+		}
+	} else {
+		if len(request.Pipeline) == 0 {
+			writeError(w, http.StatusBadRequest, "parse_exception", "a pipeline is required")
+			return
+		}
+		if pipeline, err = ingest.ParsePipeline(request.Pipeline); err != nil {
+			writeError(w, http.StatusBadRequest, "parse_exception", err.Error())
+			return
+		}
+	}
+
+	docs := make([]interface{}, len(request.Docs))
+	for i, d := range request.Docs {
+		doc := &ingest.Document{ID: d.ID, Source: d.Source}
+		if err := pipeline.Run(doc); err != nil {
+			docs[i] = map[string]interface{}{"error": errorBody("illegal_argument_exception", err.Error())}
+			continue
+		}
+		docs[i] = map[string]interface{}{"doc": map[string]interface{}{"_id": doc.ID, "_source": doc.Source}}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"docs": docs})
+}
+
+// writePipelineError maps ingest pipeline errors to Elasticsearch error responses
+func writePipelineError(w http.ResponseWriter, err error) {
+	var notFound *engine.PipelineNotFoundError
+	var invalid *engine.PipelineError
+	switch {
+	case errors.As(err, &notFound):
+		writeError(w, http.StatusNotFound, "resource_not_found_exception", err.Error())
+	case errors.As(err, &invalid):
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+	default:
+		writeIndexError(w, err)
+	}
+}
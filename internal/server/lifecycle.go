@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"nano-elastic/internal/engine"
+)
+
+// lifecyclePolicyBody is an Elasticsearch-style lifecycle policy; only the
+// delete phase is supported:
+//
+//	{"policy": {"index_patterns": ["logs-*"], "phases": {"delete": {"min_age": "30d", "actions": {"delete": {}}}}}}
+type lifecyclePolicyBody struct {
+	Policy struct {
+		IndexPatterns []string `json:"index_patterns"`
+		Phases        map[string]struct {
+			MinAge string `json:"min_age"`
+		} `json:"phases"`
+	} `json:"policy"`
+}
+
+// handleGetLifecyclePolicies handles GET /_ilm/policy and /_ilm/policy/{policy}
+func (s *Server) handleGetLifecyclePolicies(w http.ResponseWriter, r *http.Request) {
+	policies := s.engine.LifecyclePolicies()
+	if name := r.PathValue("policy"); name != "" {
+		policy, ok := policies[name]
+		if !ok {
+			writeLifecycleError(w, &engine.LifecyclePolicyNotFoundError{Name: name})
+			return
+		}
+		policies = map[string]engine.LifecyclePolicy{name: policy}
+	}
+
+	body := make(map[string]interface{}, len(policies))
+	for name, policy := range policies {
+		body[name] = map[string]interface{}{
+			"policy": map[string]interface{}{
+				"index_patterns": policy.IndexPatterns,
+				"phases": map[string]interface{}{
+					"delete": map[string]interface{}{
+						"min_age": formatTimeValue(policy.DeleteAfter),
+						"actions": map[string]interface{}{"delete": map[string]interface{}{}},
+					},
+				},
+			},
+		}
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// handlePutLifecyclePolicy handles PUT /_ilm/policy/{policy}
+func (s *Server) handlePutLifecyclePolicy(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	var request lifecyclePolicyBody
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeError(w, http.StatusBadRequest, "parsing_exception", "invalid lifecycle policy: "+err.Error())
+		return
+	}
+	for phase := range request.Policy.Phases {
+		if phase != "delete" {
+			writeError(w, http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("unsupported lifecycle phase [%s], only delete is supported", phase))
+			return
+		}
+	}
+	deletePhase, ok := request.Policy.Phases["delete"]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", "lifecycle policy requires a delete phase")
+		return
+	}
+	deleteAfter, err := parseTimeValue(deletePhase.MinAge)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", "invalid min_age: "+err.Error())
+		return
+	}
+
+	policy := engine.LifecyclePolicy{IndexPatterns: request.Policy.IndexPatterns, DeleteAfter: deleteAfter}
+	if err := s.engine.PutLifecyclePolicy(r.PathValue("policy"), policy); err != nil {
+		writeLifecycleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
+
+// handleDeleteLifecyclePolicy handles DELETE /_ilm/policy/{policy}
+func (s *Server) handleDeleteLifecyclePolicy(w http.ResponseWriter, r *http.Request) {
+	if err := s.engine.DeleteLifecyclePolicy(r.PathValue("policy")); err != nil {
+		writeLifecycleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
+
+// timeUnits are the Elasticsearch time units, largest first
+var timeUnits = []struct {
+	suffix string
+	unit   time.Duration
+}{
+	{"d", 24 * time.Hour},
+	{"h", time.Hour},
+	{"m", time.Minute},
+	{"s", time.Second},
+	{"ms", time.Millisecond},
+}
+
+// parseTimeValue parses an Elasticsearch time value such as "30d", "12h" or "90s"
+func parseTimeValue(input string) (time.Duration, error) {
+	for _, u := range timeUnits {
+		// "500ms" also ends with "s"; the number then fails to parse and "ms" matches later
+		number, ok := strings.CutSuffix(input, u.suffix)
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseInt(number, 10, 64); err == nil && n >= 0 {
+			return time.Duration(n) * u.unit, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid time value %q, expected a number with a unit (d, h, m, s or ms)", input)
+}
+
+// formatTimeValue formats a duration in its largest whole time unit
+func formatTimeValue(d time.Duration) string {
+	for _, u := range timeUnits {
+		if d%u.unit == 0 {
+			return strconv.FormatInt(int64(d/u.unit), 10) + u.suffix
+		}
+	}
+	return d.String()
+}
+
+// writeLifecycleError maps lifecycle policy errors to Elasticsearch error responses
+func writeLifecycleError(w http.ResponseWriter, err error) {
+	var notFound *engine.LifecyclePolicyNotFoundError
+	var invalid *engine.LifecyclePolicyError
+	switch {
+	case errors.As(err, &notFound):
+		writeError(w, http.StatusNotFound, "resource_not_found_exception", err.Error())
+	case errors.As(err, &invalid):
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+	default:
+		writeIndexError(w, err)
+	}
+}
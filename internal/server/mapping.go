@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"nano-elastic/internal/types"
+)
+
+// handlePutMapping handles PUT /{index}/_mapping with a {"properties": {...}} body
+// New fields are added and text fields may switch analyzer, as one schema
+// migration; changing a field's type or similarity needs a reindex into a new index
+// New fields may use the similarities named when the index was created
+func (s *Server) handlePutMapping(w http.ResponseWriter, r *http.Request) {
+	idx, ok := s.getIndex(w, r.PathValue("index"))
+	if !ok {
+		return
+	}
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	wrapped, err := json.Marshal(map[string]interface{}{
+		"settings": map[string]interface{}{"similarity": idx.Schema.Similarities},
+		"mappings": json.RawMessage(body),
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "mapper_parsing_exception", "invalid mappings: "+err.Error())
+		return
+	}
+	update, err := types.SchemaFromMappings(idx.Name, wrapped)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "mapper_parsing_exception", err.Error())
+		return
+	}
+
+	var changes []types.SchemaChange
+	for name, def := range update.Fields {
+		current, exists := idx.Schema.Fields[name]
+		switch {
+		case !exists:
+			d := def
+			changes = append(changes, types.SchemaChange{Op: types.ChangeAddField, Field: name, Def: &d})
+		case current.Type != def.Type:
+			writeError(w, http.StatusBadRequest, "illegal_argument_exception",
+				"cannot change field ["+name+"] from type "+string(current.Type)+" to "+string(def.Type)+"; reindex into a new index instead")
+			return
+		case def.Similarity != current.Similarity:
+			writeError(w, http.StatusBadRequest, "illegal_argument_exception",
+				"cannot change the similarity of field ["+name+"]; reindex into a new index instead")
+			return
+		case def.Type == types.FieldTypeText && idx.Schema.FieldAnalyzerName(def) != idx.Schema.FieldAnalyzerName(current):
+			changes = append(changes, types.ChangeAnalyzerChange(name, idx.Schema.FieldAnalyzerName(def)))
+		}
+	}
+
+	if len(changes) > 0 {
+		if err := idx.Migrate(changes...); err != nil {
+			writeIndexError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"nano-elastic/internal/types"
+)
+
+// mgetDoc is one requested document of a multi-get body
+type mgetDoc struct {
+	Index  string          `json:"_index"`
+	ID     string          `json:"_id"`
+	Source json.RawMessage `json:"_source"`
+
+	filter *types.SourceFilter
+}
+
+// handleMGet handles GET/POST /_mget and /{index}/_mget
+// The body is {"docs": [{"_index": ..., "_id": ..., "_source": ...}]}, or
+// {"ids": [...]} with an index in the path; the _source URL parameters apply
+// to documents without their own _source
+// Each index's documents are read with a single MGet
+func (s *Server) handleMGet(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	docs, err := parseMGetBody(body, r.PathValue("index"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "action_request_validation_exception", err.Error())
+		return
+	}
+	filter := parseSourceParams(r, nil)
+
+	// Group documents per index, keeping their order within each index
+	results := make([]map[string]interface{}, len(docs))
+	var order []string
+	byIndex := make(map[string][]int)
+	for i, doc := range docs {
+		if _, ok := byIndex[doc.Index]; !ok {
+			order = append(order, doc.Index)
+		}
+		byIndex[doc.Index] = append(byIndex[doc.Index], i)
+		if doc.filter == nil {
+			docs[i].filter = filter
+		}
+	}
+
+	for _, name := range order {
+		positions := byIndex[name]
+		idx, err := s.engine.ResolveIndex(name)
+		if err != nil {
+			for _, i := range positions {
+				results[i] = map[string]interface{}{
+					"_index": name,
+					"_id":    docs[i].ID,
+					"error":  errorBody("index_not_found_exception", err.Error()),
+				}
+			}
+			continue
+		}
+
+		ids := make([]string, len(positions))
+		for j, i := range positions {
+			ids[j] = docs[i].ID
+		}
+		found, err := idx.MGet(ids)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "exception", err.Error())
+			return
+		}
+		for j, i := range positions {
+			result := map[string]interface{}{
+				"_index": idx.Name,
+				"_id":    ids[j],
+				"found":  found[j].Found,
+			}
+			if found[j].Found {
+				result["_version"] = found[j].Document.Version
+				if docs[i].filter == nil || !docs[i].filter.Disabled {
+					result["_source"] = docs[i].filter.Apply(found[j].Document).Source()
+				}
+			}
+			results[i] = result
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"docs": results})
+}
+
+// parseMGetBody decodes a multi-get body, applying the path's index to
+// documents without one
+func parseMGetBody(body []byte, defaultIndex string) ([]mgetDoc, error) {
+	var request struct {
+		Docs []mgetDoc `json:"docs"`
+		IDs  []string  `json:"ids"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("invalid mget body: %w", err)
+	}
+	if len(request.IDs) > 0 {
+		if defaultIndex == "" {
+			return nil, fmt.Errorf("ids require an index in the path")
+		}
+		for _, id := range request.IDs {
+			request.Docs = append(request.Docs, mgetDoc{ID: id})
+		}
+	}
+	if len(request.Docs) == 0 {
+		return nil, fmt.Errorf("no documents to get")
+	}
+
+	for i := range request.Docs {
+		doc := &request.Docs[i]
+		if doc.Index == "" {
+			doc.Index = defaultIndex
+		}
+		if doc.Index == "" {
+			return nil, fmt.Errorf("index is missing for doc %d", i)
+		}
+		if doc.ID == "" {
+			return nil, fmt.Errorf("id is missing for doc %d", i)
+		}
+		if len(doc.Source) > 0 {
+			filter, err := types.ParseSourceFilter(doc.Source)
+			if err != nil {
+				return nil, err
+			}
+			doc.filter = filter
+		}
+	}
+	return request.Docs, nil
+}
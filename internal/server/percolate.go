@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"nano-elastic/internal/engine"
+)
+
+// handleGetRegisteredQueries handles GET /{index}/_percolator and
+// /{index}/_percolator/{id}, returning registered queries by ID
+func (s *Server) handleGetRegisteredQueries(w http.ResponseWriter, r *http.Request) {
+	idx, err := s.engine.ResolveIndex(r.PathValue("index"))
+	if err != nil {
+		writeIndexError(w, err)
+		return
+	}
+
+	queries := idx.RegisteredQueries()
+	if id := r.PathValue("id"); id != "" {
+		query, err := idx.RegisteredQuery(id)
+		if err != nil {
+			writePercolatorError(w, err)
+			return
+		}
+		queries = map[string]json.RawMessage{id: query}
+	}
+	body := make(map[string]interface{}, len(queries))
+	for id, query := range queries {
+		body[id] = map[string]interface{}{"query": query}
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// handlePutRegisteredQuery handles PUT /{index}/_percolator/{id} with a
+// {"query": {...}} body, registering the query for percolation
+func (s *Server) handlePutRegisteredQuery(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	var request struct {
+		Query json.RawMessage `json:"query"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeError(w, http.StatusBadRequest, "parsing_exception", "invalid percolator query: "+err.Error())
+		return
+	}
+	if len(request.Query) == 0 {
+		writeError(w, http.StatusBadRequest, "parsing_exception", "a query is required")
+		return
+	}
+
+	idx, err := s.engine.ResolveIndex(r.PathValue("index"))
+	if err != nil {
+		writeIndexError(w, err)
+		return
+	}
+	if err := idx.RegisterQuery(r.PathValue("id"), request.Query); err != nil {
+		writePercolatorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
+
+// handleDeleteRegisteredQuery handles DELETE /{index}/_percolator/{id}
+func (s *Server) handleDeleteRegisteredQuery(w http.ResponseWriter, r *http.Request) {
+	idx, err := s.engine.ResolveIndex(r.PathValue("index"))
+	if err != nil {
+		writeIndexError(w, err)
+		return
+	}
+	if err := idx.UnregisterQuery(r.PathValue("id")); err != nil {
+		writePercolatorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
+
+// handlePercolate handles POST /{index}/_percolate with a {"document": {...}}
+// or {"documents": [...]} body, returning the registered queries that match:
+//
+//	{"took": 1, "total": 1, "matches": [{"_index": "logs", "_id": "errors",
+//	 "_score": 1.2, "_percolator_document_slot": [0]}]}
+//
+// The documents are not indexed
+func (s *Server) handlePercolate(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	var request struct {
+		Document  map[string]interface{}   `json:"document"`
+		Documents []map[string]interface{} `json:"documents"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeError(w, http.StatusBadRequest, "parsing_exception", "invalid percolate request: "+err.Error())
+		return
+	}
+	docs := request.Documents
+	if request.Document != nil {
+		docs = append([]map[string]interface{}{request.Document}, docs...)
+	}
+	if len(docs) == 0 {
+		writeError(w, http.StatusBadRequest, "parsing_exception", "[document] or [documents] is required")
+		return
+	}
+
+	idx, err := s.engine.ResolveIndex(r.PathValue("index"))
+	if err != nil {
+		writeIndexError(w, err)
+		return
+	}
+	matches, err := idx.PercolateSource(docs...)
+	if err != nil {
+		writePercolatorError(w, err)
+		return
+	}
+
+	hits := make([]map[string]interface{}, len(matches))
+	for i, m := range matches {
+		hits[i] = map[string]interface{}{
+			"_index":                    idx.Name,
+			"_id":                       m.ID,
+			"_score":                    m.Score,
+			"_percolator_document_slot": m.Slots,
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"took":    time.Since(start).Milliseconds(),
+		"total":   len(matches),
+		"matches": hits,
+	})
+}
+
+// writePercolatorError maps percolator errors to Elasticsearch error responses
+func writePercolatorError(w http.ResponseWriter, err error) {
+	var notFound *engine.PercolatorQueryNotFoundError
+	var invalid *engine.PercolatorQueryError
+	var docErr *engine.PercolateDocumentError
+	switch {
+	case errors.As(err, &notFound):
+		writeError(w, http.StatusNotFound, "resource_not_found_exception", err.Error())
+	case errors.As(err, &invalid):
+		writeError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+	case errors.As(err, &docErr):
+		writeError(w, http.StatusBadRequest, "mapper_parsing_exception", err.Error())
+	default:
+		writeIndexError(w, err)
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"nano-elastic/internal/engine"
+)
+
+// refreshPolicy is the refresh URL parameter of a write request
+type refreshPolicy string
+
+const (
+	refreshNone    refreshPolicy = ""         // Searchable on the next scheduled refresh
+	refreshNow     refreshPolicy = "true"     // Refresh before responding
+	refreshWaitFor refreshPolicy = "wait_for" // Wait for a scheduled refresh before responding
+)
+
+// parseRefresh reads the refresh URL parameter; a bare ?refresh means true
+func parseRefresh(r *http.Request) (refreshPolicy, error) {
+	params := r.URL.Query()
+	if !params.Has("refresh") {
+		return refreshNone, nil
+	}
+	switch value := params.Get("refresh"); value {
+	case "", "true":
+		return refreshNow, nil
+	case "false":
+		return refreshNone, nil
+	case "wait_for":
+		return refreshWaitFor, nil
+	default:
+		return refreshNone, fmt.Errorf("invalid refresh %q, expected true, false or wait_for", value)
+	}
+}
+
+// apply makes an index's writes searchable as the policy requires
+func (p refreshPolicy) apply(idx *engine.Index) error {
+	switch p {
+	case refreshNow:
+		return idx.Refresh()
+	case refreshWaitFor:
+		return idx.WaitForRefresh()
+	}
+	return nil
+}
+
+// handleRefresh handles POST/GET /_refresh and /{index}/_refresh
+// An alias refreshes all of its indexes
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	refreshed, err := s.engine.Refresh(r.PathValue("index"))
+	if err != nil {
+		writeIndexError(w, err)
+		return
+	}
+
+	// Every index is a single shard
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"_shards": map[string]interface{}{
+			"total":      refreshed,
+			"successful": refreshed,
+			"failed":     0,
+		},
+	})
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"nano-elastic/internal/engine"
+	"nano-elastic/internal/search"
+)
+
+// handleReindex handles POST /_reindex:
+//
+//	{"source": {"index": "products-v1", "query": {...}}, "dest": {"index": "products-v2"}}
+//
+// A missing destination index is created with the source's mappings
+func (s *Server) handleReindex(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	refresh, err := parseRefresh(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		return
+	}
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Source struct {
+			Index string          `json:"index"`
+			Query json.RawMessage `json:"query"`
+		} `json:"source"`
+		Dest struct {
+			Index string `json:"index"`
+		} `json:"dest"`
+		Size int `json:"size"` // Batch size
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeError(w, http.StatusBadRequest, "parsing_exception", "invalid reindex request: "+err.Error())
+		return
+	}
+	if request.Source.Index == "" || request.Dest.Index == "" {
+		writeError(w, http.StatusBadRequest, "action_request_validation_exception", "source.index and dest.index are required")
+		return
+	}
+	if request.Source.Index == request.Dest.Index {
+		writeError(w, http.StatusBadRequest, "action_request_validation_exception", "reindex cannot write into the index it reads from")
+		return
+	}
+
+	var options []engine.ReindexOption
+	if len(request.Source.Query) > 0 {
+		query, err := search.ParseQueryDSL(request.Source.Query)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+			return
+		}
+		options = append(options, engine.WithReindexQuery(query))
+	}
+	if request.Size > 0 {
+		options = append(options, engine.WithReindexBatchSize(request.Size))
+	}
+
+	result, err := s.engine.ReindexContext(r.Context(), request.Source.Index, request.Dest.Index, nil, options...)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			writeCancelled(w, err)
+			return
+		}
+		writeIndexError(w, err)
+		return
+	}
+	if refresh != refreshNone {
+		if _, err := s.engine.Refresh(request.Dest.Index); err != nil {
+			writeIndexError(w, err)
+			return
+		}
+	}
+
+	failures := make([]map[string]interface{}, 0, len(result.Failures))
+	for _, f := range result.Failures {
+		status, cause := bulkError(f.Err)
+		failures = append(failures, map[string]interface{}{
+			"index":  request.Dest.Index,
+			"id":     f.ID,
+			"status": status,
+			"cause":  cause,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"took":     time.Since(start).Milliseconds(),
+		"total":    result.Total,
+		"created":  result.Created,
+		"updated":  result.Updated,
+		"noops":    result.Skipped,
+		"failures": failures,
+	})
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"nano-elastic/internal/engine"
+	"nano-elastic/internal/metrics"
+)
+
+// Server exposes an engine's indexes over an Elasticsearch-style REST API
+type Server struct {
+	engine  *engine.Engine
+	metrics *metrics.Registry // Served at /metrics, if set
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithMetrics serves a registry's metrics at GET /metrics, in the Prometheus
+// text exposition format
+func WithMetrics(registry *metrics.Registry) Option {
+	return func(s *Server) {
+		s.metrics = registry
+	}
+}
+
+// NewServer creates a server for the given engine
+func NewServer(eng *engine.Engine, options ...Option) *Server {
+	s := &Server{engine: eng}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// Handler returns the HTTP handler for the REST API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /_cat/indices", s.handleListIndexes)
+	mux.HandleFunc("GET /_aliases", s.handleGetAliases)
+	mux.HandleFunc("POST /_aliases", s.handleUpdateAliases)
+	mux.HandleFunc("PUT /{index}/_alias/{alias}", s.handlePutAlias)
+	mux.HandleFunc("DELETE /{index}/_alias/{alias}", s.handleDeleteAlias)
+	mux.HandleFunc("PUT /{index}", s.handleCreateIndex)
+	mux.HandleFunc("GET /{index}", s.handleGetIndex)
+	mux.HandleFunc("DELETE /{index}", s.handleDeleteIndex)
+	mux.HandleFunc("PUT /{index}/_mapping", s.handlePutMapping)
+	mux.HandleFunc("GET /{index}/_settings", s.handleGetSettings)
+	mux.HandleFunc("PUT /{index}/_settings", s.handlePutSettings)
+	mux.HandleFunc("PUT /{index}/_doc/{id}", s.handlePutDocument)
+	mux.HandleFunc("POST /{index}/_doc/{id}", s.handlePutDocument)
+	mux.HandleFunc("GET /{index}/_doc/{id}", s.handleGetDocument)
+	mux.HandleFunc("DELETE /{index}/_doc/{id}", s.handleDeleteDocument)
+	mux.HandleFunc("GET /_mget", s.handleMGet)
+	mux.HandleFunc("POST /_mget", s.handleMGet)
+	mux.HandleFunc("GET /{index}/_mget", s.handleMGet)
+	mux.HandleFunc("POST /{index}/_mget", s.handleMGet)
+	mux.HandleFunc("POST /{index}/_search", s.handleSearch)
+	mux.HandleFunc("GET /{index}/_search", s.handleSearch)
+	mux.HandleFunc("POST /{index}/_search/template", s.handleSearchTemplate)
+	mux.HandleFunc("GET /{index}/_search/template", s.handleSearchTemplate)
+	mux.HandleFunc("GET /_search/template", s.handleGetTemplates)
+	mux.HandleFunc("GET /_search/template/{id}", s.handleGetTemplates)
+	mux.HandleFunc("PUT /_search/template/{id}", s.handlePutTemplate)
+	mux.HandleFunc("DELETE /_search/template/{id}", s.handleDeleteTemplate)
+	mux.HandleFunc("POST /_render/template", s.handleRenderTemplate)
+	mux.HandleFunc("POST /_render/template/{id}", s.handleRenderTemplate)
+	mux.HandleFunc("POST /{index}/_count", s.handleCount)
+	mux.HandleFunc("GET /{index}/_count", s.handleCount)
+	mux.HandleFunc("POST /_bulk", s.handleBulk)
+	mux.HandleFunc("POST /_reindex", s.handleReindex)
+	mux.HandleFunc("POST /{index}/_bulk", s.handleBulk)
+	mux.HandleFunc("POST /_refresh", s.handleRefresh)
+	mux.HandleFunc("GET /_refresh", s.handleRefresh)
+	mux.HandleFunc("POST /{index}/_refresh", s.handleRefresh)
+	mux.HandleFunc("GET /{index}/_refresh", s.handleRefresh)
+	mux.HandleFunc("GET /{index}/_stats", s.handleIndexStats)
+	mux.HandleFunc("GET /{index}/_percolator", s.handleGetRegisteredQueries)
+	mux.HandleFunc("GET /{index}/_percolator/{id}", s.handleGetRegisteredQueries)
+	mux.HandleFunc("PUT /{index}/_percolator/{id}", s.handlePutRegisteredQuery)
+	mux.HandleFunc("DELETE /{index}/_percolator/{id}", s.handleDeleteRegisteredQuery)
+	mux.HandleFunc("POST /{index}/_percolate", s.handlePercolate)
+	mux.HandleFunc("GET /{index}/_percolate", s.handlePercolate)
+	mux.HandleFunc("GET /_ingest/pipeline", s.handleGetPipelines)
+	mux.HandleFunc("GET /_ingest/pipeline/{id}", s.handleGetPipelines)
+	mux.HandleFunc("PUT /_ingest/pipeline/{id}", s.handlePutPipeline)
+	mux.HandleFunc("DELETE /_ingest/pipeline/{id}", s.handleDeletePipeline)
+	mux.HandleFunc("POST /_ingest/pipeline/_simulate", s.handleSimulatePipeline)
+	mux.HandleFunc("POST /_ingest/pipeline/{id}/_simulate", s.handleSimulatePipeline)
+	mux.HandleFunc("GET /_ilm/policy", s.handleGetLifecyclePolicies)
+	mux.HandleFunc("GET /_ilm/policy/{policy}", s.handleGetLifecyclePolicies)
+	mux.HandleFunc("PUT /_ilm/policy/{policy}", s.handlePutLifecyclePolicy)
+	mux.HandleFunc("DELETE /_ilm/policy/{policy}", s.handleDeleteLifecyclePolicy)
+	if s.metrics != nil {
+		mux.Handle("GET /metrics", s.metrics)
+	}
+
+	snapshots := s.snapshotRoutes()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_snapshot" || strings.HasPrefix(r.URL.Path, "/_snapshot/") {
+			snapshots.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// getIndex resolves an index or single-index alias, writing an error response on failure
+func (s *Server) getIndex(w http.ResponseWriter, name string) (*engine.Index, bool) {
+	idx, err := s.engine.ResolveIndex(name)
+	if err != nil {
+		writeIndexError(w, err)
+		return nil, false
+	}
+	return idx, true
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"nano-elastic/internal/types"
+)
+
+// handleGetSettings handles GET /{index}/_settings, returning the settings of
+// the index, or of every index behind an alias
+func (s *Server) handleGetSettings(w http.ResponseWriter, r *http.Request) {
+	indexes, err := s.engine.ResolveIndexes(r.PathValue("index"))
+	if err != nil {
+		writeIndexError(w, err)
+		return
+	}
+
+	body := make(map[string]interface{}, len(indexes))
+	for _, idx := range indexes {
+		body[idx.Name] = map[string]interface{}{
+			"settings": map[string]interface{}{"index": indexSettings(idx.Schema, idx.Settings())},
+		}
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// handlePutSettings handles PUT /{index}/_settings with a
+// {"index": {"refresh_interval": "5s", ...}} body; dotted keys such as
+// "index.refresh_interval" and a "settings" wrapper are accepted too
+// Only dynamic settings can be changed
+func (s *Server) handlePutSettings(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	settings, err := parseSettings(body, false)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		return
+	}
+
+	indexes, err := s.engine.ResolveIndexes(r.PathValue("index"))
+	if err != nil {
+		writeIndexError(w, err)
+		return
+	}
+	for _, idx := range indexes {
+		if err := idx.UpdateSettings(settings); err != nil {
+			writeIndexError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
+
+// indexSettings returns an index's settings as reported under "settings.index"
+// Settings left to the server's options are omitted
+func indexSettings(schema *types.Schema, settings types.Settings) map[string]interface{} {
+	result := make(map[string]interface{})
+	if schema.Created != 0 {
+		result["creation_date"] = strconv.FormatInt(schema.Created*1000, 10) // Epoch millis
+	}
+	if len(schema.Similarities) > 0 {
+		result["similarity"] = schema.Similarities
+	}
+	switch {
+	case settings.RefreshInterval < 0:
+		result["refresh_interval"] = "-1"
+	case settings.RefreshInterval > 0:
+		result["refresh_interval"] = formatTimeValue(settings.RefreshInterval)
+	}
+	if settings.MaxResultWindow > 0 {
+		result["max_result_window"] = strconv.Itoa(settings.MaxResultWindow)
+	}
+	if settings.MaxSegmentDocs > 0 {
+		result["max_segment_docs"] = strconv.Itoa(settings.MaxSegmentDocs)
+	}
+	if settings.MaxSegmentBytes > 0 {
+		result["max_segment_bytes"] = strconv.FormatInt(settings.MaxSegmentBytes, 10)
+	}
+	if settings.MaxWALFileBytes > 0 {
+		result["max_wal_file_bytes"] = strconv.FormatInt(settings.MaxWALFileBytes, 10)
+	}
+	if settings.DefaultPipeline != "" {
+		result["default_pipeline"] = settings.DefaultPipeline
+	}
+	if settings.Durability != "" {
+		result["durability"] = settings.Durability
+	}
+	if settings.SyncInterval > 0 {
+		result["sync_interval"] = formatTimeValue(settings.SyncInterval)
+	}
+	if settings.DefaultAnalyzer != "" {
+		result["default_analyzer"] = settings.DefaultAnalyzer
+	}
+	return result
+}
+
+// ignoredSettings are accepted when an index is created but have no effect:
+// similarities are read with the mappings, and a single node has no shards
+var ignoredSettings = map[string]bool{
+	"number_of_shards":   true,
+	"number_of_replicas": true,
+	"creation_date":      true,
+}
+
+// parseSettings reads index settings from a request body, nested or dotted,
+// with or without "settings" and "index" wrappers
+// Values may be JSON numbers or strings, as Elasticsearch reports them
+// creating allows the settings only read when an index is created
+func parseSettings(data []byte, creating bool) (types.Settings, error) {
+	var settings types.Settings
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return settings, nil
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return settings, fmt.Errorf("invalid settings: %w", err)
+	}
+	if wrapped, ok := body["settings"].(map[string]interface{}); ok {
+		body = wrapped
+	}
+
+	flat := make(map[string]interface{})
+	flattenSettings("", body, flat)
+	keys := make([]string, 0, len(flat))
+	for key := range flat {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // Report the first bad setting in a stable order
+
+	for _, key := range keys {
+		value := flat[key]
+		name := strings.TrimPrefix(key, "index.")
+		var err error
+		switch name {
+		case "refresh_interval":
+			settings.RefreshInterval, err = settingDuration(value, true)
+		case "sync_interval":
+			settings.SyncInterval, err = settingDuration(value, false)
+		case "max_result_window":
+			var n int64
+			n, err = settingInt(value)
+			settings.MaxResultWindow = int(n)
+		case "max_segment_docs":
+			var n int64
+			n, err = settingInt(value)
+			settings.MaxSegmentDocs = int(n)
+		case "max_segment_bytes":
+			settings.MaxSegmentBytes, err = settingInt(value)
+		case "max_wal_file_bytes":
+			settings.MaxWALFileBytes, err = settingInt(value)
+		case "default_pipeline":
+			settings.DefaultPipeline, err = settingString(value)
+		case "durability":
+			settings.Durability, err = settingString(value)
+		case "default_analyzer":
+			settings.DefaultAnalyzer, err = settingString(value)
+		default:
+			if creating && (ignoredSettings[name] || strings.HasPrefix(name, "similarity.")) {
+				continue
+			}
+			return settings, fmt.Errorf("unknown setting [%s]", key)
+		}
+		if err != nil {
+			return settings, fmt.Errorf("invalid setting [%s]: %w", key, err)
+		}
+	}
+	return settings, nil
+}
+
+// flattenSettings collects nested settings objects under dotted keys
+func flattenSettings(prefix string, settings map[string]interface{}, flat map[string]interface{}) {
+	for key, value := range settings {
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenSettings(prefix+key+".", nested, flat)
+			continue
+		}
+		flat[prefix+key] = value
+	}
+}
+
+// settingDuration reads a time value such as "30s"; disable accepts -1, which
+// turns the setting off
+func settingDuration(value interface{}, disable bool) (time.Duration, error) {
+	s := fmt.Sprint(value)
+	if disable && s == "-1" {
+		return -1, nil
+	}
+	if s == "0" {
+		return 0, fmt.Errorf("expected a positive time value")
+	}
+	return parseTimeValue(s)
+}
+
+// settingInt reads a positive integer given as a number or a string
+func settingInt(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case float64:
+		if v != float64(int64(v)) || v < 1 {
+			return 0, fmt.Errorf("expected a positive integer, got %v", v)
+		}
+		return int64(v), nil
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("expected a positive integer, got %q", v)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("expected a positive integer, got %v", value)
+}
+
+// settingString reads a string value
+func settingString(value interface{}) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("expected a string, got %v", value)
+	}
+	return s, nil
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"nano-elastic/internal/engine"
+	"nano-elastic/internal/snapshot"
+)
+
+// snapshotRoutes returns the handler of the /_snapshot API
+// Its paths would clash with the /{index}/... patterns of the main mux, e.g.
+// /_snapshot/{repository} with /{index}/_mapping, so it gets a mux of its own
+func (s *Server) snapshotRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /_snapshot", s.handleGetRepositories)
+	mux.HandleFunc("GET /_snapshot/{repository}", s.handleGetRepositories)
+	mux.HandleFunc("PUT /_snapshot/{repository}", s.handlePutRepository)
+	mux.HandleFunc("POST /_snapshot/{repository}", s.handlePutRepository)
+	mux.HandleFunc("DELETE /_snapshot/{repository}", s.handleDeleteRepository)
+	mux.HandleFunc("PUT /_snapshot/{repository}/{snapshot}", s.handleCreateSnapshot)
+	mux.HandleFunc("POST /_snapshot/{repository}/{snapshot}", s.handleCreateSnapshot)
+	mux.HandleFunc("GET /_snapshot/{repository}/{snapshot}", s.handleGetSnapshots)
+	mux.HandleFunc("DELETE /_snapshot/{repository}/{snapshot}", s.handleDeleteSnapshot)
+	mux.HandleFunc("POST /_snapshot/{repository}/{snapshot}/_restore", s.handleRestoreSnapshot)
+	return mux
+}
+
+// hiddenRepositorySettings are not returned by GET /_snapshot
+var hiddenRepositorySettings = map[string]bool{"access_key": true, "secret_key": true}
+
+// handleGetRepositories handles GET /_snapshot and /_snapshot/{repository}
+func (s *Server) handleGetRepositories(w http.ResponseWriter, r *http.Request) {
+	repositories := s.engine.Repositories()
+	name := r.PathValue("repository")
+	if name != "" && name != "_all" {
+		config, ok := repositories[name]
+		if !ok {
+			writeSnapshotError(w, &engine.RepositoryNotFoundError{Name: name})
+			return
+		}
+		repositories = map[string]engine.RepositoryConfig{name: config}
+	}
+
+	body := make(map[string]interface{}, len(repositories))
+	for name, config := range repositories {
+		settings := make(map[string]string, len(config.Settings))
+		for key, value := range config.Settings {
+			if !hiddenRepositorySettings[key] {
+				settings[key] = value
+			}
+		}
+		body[name] = map[string]interface{}{"type": config.Type, "settings": settings}
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// handlePutRepository handles PUT /_snapshot/{repository}:
+//
+//	{"type": "fs", "settings": {"location": "/mnt/backups"}}
+//	{"type": "s3", "settings": {"bucket": "backups", "endpoint": "http://localhost:9000"}}
+func (s *Server) handlePutRepository(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	var config engine.RepositoryConfig
+	if err := json.Unmarshal(body, &config); err != nil {
+		writeError(w, http.StatusBadRequest, "parsing_exception", "invalid repository: "+err.Error())
+		return
+	}
+	if err := s.engine.PutRepository(r.PathValue("repository"), config); err != nil {
+		writeSnapshotError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
+
+// handleDeleteRepository handles DELETE /_snapshot/{repository}
+// The repository's contents are left in place
+func (s *Server) handleDeleteRepository(w http.ResponseWriter, r *http.Request) {
+	if err := s.engine.DeleteRepository(r.PathValue("repository")); err != nil {
+		writeSnapshotError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
+
+// handleCreateSnapshot handles PUT /_snapshot/{repository}/{snapshot}, with an
+// optional body naming the indexes (default all):
+//
+//	{"indices": "products,orders"}
+//
+// The snapshot completes before the response, as with wait_for_completion=true
+func (s *Server) handleCreateSnapshot(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Indices json.RawMessage `json:"indices"`
+	}
+	if !readOptionalJSON(w, r, &request) {
+		return
+	}
+	indexes, err := parseIndexList(request.Indices)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		return
+	}
+
+	info, err := s.engine.CreateSnapshot(r.PathValue("repository"), r.PathValue("snapshot"), indexes)
+	if err != nil {
+		writeSnapshotError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"snapshot": snapshotInfoBody(*info)})
+}
+
+// handleGetSnapshots handles GET /_snapshot/{repository}/{snapshot}, where
+// snapshot is a name, a comma-separated list of names or _all
+func (s *Server) handleGetSnapshots(w http.ResponseWriter, r *http.Request) {
+	infos, err := s.engine.Snapshots(r.PathValue("repository"))
+	if err != nil {
+		writeSnapshotError(w, err)
+		return
+	}
+
+	byName := make(map[string]snapshot.Info, len(infos))
+	for _, info := range infos {
+		byName[info.Name] = info
+	}
+	bodies := make([]interface{}, 0, len(infos))
+	if names := r.PathValue("snapshot"); names == "_all" || names == "*" {
+		for _, info := range infos {
+			bodies = append(bodies, snapshotInfoBody(info))
+		}
+	} else {
+		for _, name := range strings.Split(names, ",") {
+			info, ok := byName[name]
+			if !ok {
+				writeSnapshotError(w, &snapshot.SnapshotNotFoundError{Name: name})
+				return
+			}
+			bodies = append(bodies, snapshotInfoBody(info))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"snapshots": bodies})
+}
+
+// handleDeleteSnapshot handles DELETE /_snapshot/{repository}/{snapshot}
+func (s *Server) handleDeleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	if err := s.engine.DeleteSnapshot(r.PathValue("repository"), r.PathValue("snapshot")); err != nil {
+		writeSnapshotError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
+
+// handleRestoreSnapshot handles POST /_snapshot/{repository}/{snapshot}/_restore
+// Restored indexes must not exist; rename_pattern and rename_replacement restore
+// them under other names, e.g. next to the live ones:
+//
+//	{"indices": "products", "rename_pattern": "(.+)", "rename_replacement": "restored-$1"}
+func (s *Server) handleRestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Indices           json.RawMessage `json:"indices"`
+		RenamePattern     string          `json:"rename_pattern"`
+		RenameReplacement string          `json:"rename_replacement"`
+	}
+	if !readOptionalJSON(w, r, &request) {
+		return
+	}
+	indexes, err := parseIndexList(request.Indices)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		return
+	}
+
+	var rename func(string) string
+	if request.RenamePattern != "" {
+		pattern, err := regexp.Compile(request.RenamePattern)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "illegal_argument_exception", "invalid rename_pattern: "+err.Error())
+			return
+		}
+		rename = func(name string) string {
+			return pattern.ReplaceAllString(name, request.RenameReplacement)
+		}
+	}
+
+	restored, err := s.engine.RestoreSnapshot(r.PathValue("repository"), r.PathValue("snapshot"), indexes, rename)
+	if err != nil {
+		writeSnapshotError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"snapshot": map[string]interface{}{
+			"snapshot": r.PathValue("snapshot"),
+			"indices":  restored,
+			"shards": map[string]interface{}{
+				"total":      len(restored),
+				"successful": len(restored),
+				"failed":     0,
+			},
+		},
+	})
+}
+
+// snapshotInfoBody renders a snapshot the way Elasticsearch describes one
+func snapshotInfoBody(info snapshot.Info) map[string]interface{} {
+	indices := info.Indexes
+	if indices == nil {
+		indices = []string{}
+	}
+	return map[string]interface{}{
+		"snapshot":             info.Name,
+		"generation":           info.Generation,
+		"indices":              indices,
+		"state":                "SUCCESS",
+		"start_time_in_millis": info.StartTime,
+		"end_time_in_millis":   info.EndTime,
+		"duration_in_millis":   info.EndTime - info.StartTime,
+		"stats":                info.Stats,
+	}
+}
+
+// readOptionalJSON decodes a request body into v, leaving v alone if there is none
+func readOptionalJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body, ok := readBody(w, r)
+	if !ok {
+		return false
+	}
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return true
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		writeError(w, http.StatusBadRequest, "parsing_exception", "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// parseIndexList reads an "indices" value: a comma-separated string or an array
+// of names; none, "_all" and "*" mean every index
+func parseIndexList(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var names []string
+	var list string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		names = strings.Split(list, ",")
+	} else if err := json.Unmarshal(raw, &names); err != nil {
+		return nil, fmt.Errorf("indices must be a string or an array of strings")
+	}
+
+	var indexes []string
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "_all" || name == "*" {
+			return nil, nil
+		}
+		if name != "" {
+			indexes = append(indexes, name)
+		}
+	}
+	return indexes, nil
+}
+
+// writeSnapshotError maps snapshot and repository errors to Elasticsearch error responses
+func writeSnapshotError(w http.ResponseWriter, err error) {
+	var repoMissing *engine.RepositoryNotFoundError
+	var repoErr *engine.RepositoryError
+	var notFound *snapshot.SnapshotNotFoundError
+	var exists *snapshot.SnapshotExistsError
+	var invalid *snapshot.InvalidSnapshotNameError
+	switch {
+	case errors.As(err, &repoMissing):
+		writeError(w, http.StatusNotFound, "repository_missing_exception", err.Error())
+	case errors.As(err, &repoErr):
+		writeError(w, http.StatusBadRequest, "repository_exception", err.Error())
+	case errors.As(err, &notFound):
+		writeError(w, http.StatusNotFound, "snapshot_missing_exception", err.Error())
+	case errors.As(err, &exists), errors.As(err, &invalid):
+		writeError(w, http.StatusBadRequest, "invalid_snapshot_name_exception", err.Error())
+	default:
+		writeIndexError(w, err)
+	}
+}
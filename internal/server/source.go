@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"nano-elastic/internal/types"
+)
+
+// parseSourceParams reads the _source, _source_includes and _source_excludes
+// URL parameters: ?_source=false returns no source, ?_source=title,author only
+// those fields
+// Returns base when none is set
+func parseSourceParams(r *http.Request, base *types.SourceFilter) *types.SourceFilter {
+	params := r.URL.Query()
+	if !params.Has("_source") && !params.Has("_source_includes") && !params.Has("_source_excludes") {
+		return base
+	}
+
+	filter := &types.SourceFilter{}
+	switch value := params.Get("_source"); value {
+	case "", "true":
+	case "false":
+		filter.Disabled = true
+	default:
+		filter.Includes = strings.Split(value, ",")
+	}
+	if value := params.Get("_source_includes"); value != "" {
+		filter.Includes = strings.Split(value, ",")
+	}
+	if value := params.Get("_source_excludes"); value != "" {
+		filter.Excludes = strings.Split(value, ",")
+	}
+	return filter
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net/http"
+
+	"nano-elastic/internal/engine"
+)
+
+// handleIndexStats handles GET /{index}/_stats
+// An alias reports each of its indexes
+func (s *Server) handleIndexStats(w http.ResponseWriter, r *http.Request) {
+	indexes, err := s.engine.ResolveIndexes(r.PathValue("index"))
+	if err != nil {
+		writeIndexError(w, err)
+		return
+	}
+
+	indices := make(map[string]engine.Stats, len(indexes))
+	for _, idx := range indexes {
+		stats, err := idx.Stats()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "exception", err.Error())
+			return
+		}
+		indices[idx.Name] = stats
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"indices": indices})
+}
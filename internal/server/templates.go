@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"nano-elastic/internal/engine"
+	"nano-elastic/internal/search"
+)
+
+// templateRequest is the body of template searches and renders: a saved
+// template by id, or an inline one, with its parameters
+//
+//	{"id": "books_by_author", "params": {"author": "tolkien"}}
+//	{"source": {"query": {"match": {"author": "$author"}}}, "params": {...}}
+type templateRequest struct {
+	ID     string                 `json:"id"`
+	Source json.RawMessage        `json:"source"`
+	Params map[string]interface{} `json:"params"`
+}
+
+// handleGetTemplates handles GET /_search/template and /_search/template/{id}
+func (s *Server) handleGetTemplates(w http.ResponseWriter, r *http.Request) {
+	templates := s.engine.Templates()
+	if name := r.PathValue("id"); name != "" {
+		template, err := s.engine.Template(name)
+		if err != nil {
+			writeTemplateError(w, err)
+			return
+		}
+		templates = map[string]*search.Template{name: template}
+	}
+	writeJSON(w, http.StatusOK, templates)
+}
+
+// handlePutTemplate handles PUT /_search/template/{id} with a
+// {"description": "...", "source": {...}, "params": {...}} body (see search.Template)
+func (s *Server) handlePutTemplate(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	template, err := search.ParseTemplate(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "parse_exception", err.Error())
+		return
+	}
+	if err := s.engine.PutTemplate(r.PathValue("id"), template); err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
+
+// handleDeleteTemplate handles DELETE /_search/template/{id}
+func (s *Server) handleDeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	if err := s.engine.DeleteTemplate(r.PathValue("id")); err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
+
+// handleSearchTemplate handles GET/POST /{index}/_search/template, running a
+// rendered template like a search body; the _search URL parameters apply too
+func (s *Server) handleSearchTemplate(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	rendered, ok := s.renderTemplate(w, body, "")
+	if !ok {
+		return
+	}
+	req, err := search.ParseSearchRequest(rendered)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+		return
+	}
+	s.search(w, r, r.PathValue("index"), req, start)
+}
+
+// handleRenderTemplate handles POST /_render/template and
+// /_render/template/{id}, returning the search body a template renders to
+func (s *Server) handleRenderTemplate(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	rendered, ok := s.renderTemplate(w, body, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"template_output": json.RawMessage(rendered)})
+}
+
+// renderTemplate renders the template a request body names or holds; an id
+// from the path takes the place of the body's
+// Writes an error response and returns false if it can't
+func (s *Server) renderTemplate(w http.ResponseWriter, body []byte, id string) ([]byte, bool) {
+	var request templateRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeError(w, http.StatusBadRequest, "parsing_exception", "invalid template request: "+err.Error())
+		return nil, false
+	}
+	if id != "" {
+		request.ID = id
+	}
+
+	var template *search.Template
+	switch {
+	case request.ID != "":
+		t, err := s.engine.Template(request.ID)
+		if err != nil {
+			writeTemplateError(w, err)
+			return nil, false
+		}
+		template = t
+	case len(request.Source) > 0:
+		template = &search.Template{Source: request.Source}
+		if err := template.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, "parse_exception", err.Error())
+			return nil, false
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "parsing_exception", "[id] or [source] is required")
+		return nil, false
+	}
+
+	rendered, err := template.Render(request.Params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		return nil, false
+	}
+	return rendered, true
+}
+
+// writeTemplateError maps search template errors to Elasticsearch error responses
+func writeTemplateError(w http.ResponseWriter, err error) {
+	var notFound *engine.TemplateNotFoundError
+	var invalid *engine.TemplateError
+	switch {
+	case errors.As(err, &notFound):
+		writeError(w, http.StatusNotFound, "resource_not_found_exception", err.Error())
+	case errors.As(err, &invalid):
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "exception", err.Error())
+	}
+}
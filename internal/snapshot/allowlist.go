@@ -0,0 +1,115 @@
+package snapshot
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// validRegion matches region names that are safe to put in an AWS endpoint host
+var validRegion = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Allowlist limits where repositories registered by clients keep their blobs,
+// like Elasticsearch's path.repo: fs locations must be inside one of Paths,
+// and s3 repositories must use one of Endpoints or AWS in a region
+// The zero value allows no fs repository and only AWS endpoints
+type Allowlist struct {
+	Paths     []string // Directories fs repositories may be in; relative locations are in the first
+	Endpoints []string // S3 endpoints, e.g. "http://localhost:9000"; only scheme and host are compared
+}
+
+// NewRepository creates a repository like NewRepository, if the allowlist
+// allows its settings
+func (a Allowlist) NewRepository(repoType string, settings map[string]string) (Repository, error) {
+	switch repoType {
+	case TypeFS:
+		location, err := a.checkLocation(settings["location"])
+		if err != nil {
+			return nil, err
+		}
+		return NewFSRepository(location)
+	case TypeS3:
+		if err := a.checkEndpoint(settings["endpoint"], settings["region"]); err != nil {
+			return nil, err
+		}
+	}
+	return NewRepository(repoType, settings)
+}
+
+// checkLocation returns the resolved location of an fs repository, or an
+// error if it's outside every allowed path once symlinks are followed
+func (a Allowlist) checkLocation(location string) (string, error) {
+	if location == "" {
+		return "", fmt.Errorf("fs repository requires a location")
+	}
+	if len(a.Paths) == 0 {
+		return "", fmt.Errorf("location [%s] isn't allowed: no repository paths are configured", location)
+	}
+	if !filepath.IsAbs(location) {
+		location = filepath.Join(a.Paths[0], location)
+	}
+	resolved, err := resolvePath(location)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve location [%s]: %w", location, err)
+	}
+	for _, root := range a.Paths {
+		root, err := resolvePath(root)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(root, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("location [%s] doesn't match any of the repository paths %v", location, a.Paths)
+}
+
+// resolvePath makes a path absolute and clean, and follows the symlinks of
+// the longest part of it that exists
+func resolvePath(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	existing, missing := path, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			return filepath.Join(resolved, missing), nil
+		}
+		parent := filepath.Dir(existing)
+		if !os.IsNotExist(err) || parent == existing {
+			return "", err
+		}
+		missing = filepath.Join(filepath.Base(existing), missing)
+		existing = parent
+	}
+}
+
+// checkEndpoint returns an error unless an s3 repository's endpoint is
+// allowed, or it has none and goes to AWS in a valid region
+func (a Allowlist) checkEndpoint(endpoint string, region string) error {
+	if endpoint == "" {
+		if region != "" && !validRegion.MatchString(region) {
+			return fmt.Errorf("invalid s3 region [%s]", region)
+		}
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid s3 endpoint [%s]: must be http(s)://host[:port]", endpoint)
+	}
+	for _, allowed := range a.Endpoints {
+		allowedURL, err := url.Parse(allowed)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(allowedURL.Scheme, u.Scheme) && strings.EqualFold(allowedURL.Host, u.Host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("endpoint [%s] isn't one of the allowed s3 endpoints %v", endpoint, a.Endpoints)
+}
@@ -0,0 +1,160 @@
+package snapshot
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FSRepository keeps blobs as files under a directory, e.g. a mounted network share
+type FSRepository struct {
+	root string
+}
+
+// NewFSRepository opens a repository in a directory, creating it if missing
+func NewFSRepository(location string) (*FSRepository, error) {
+	if location == "" {
+		return nil, fmt.Errorf("fs repository requires a location")
+	}
+	if err := os.MkdirAll(location, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create repository directory: %w", err)
+	}
+	return &FSRepository{root: location}, nil
+}
+
+// Type implements Repository
+func (r *FSRepository) Type() string {
+	return TypeFS
+}
+
+// path returns the file of a blob
+func (r *FSRepository) path(name string) (string, error) {
+	if !validBlobName(name) {
+		return "", fmt.Errorf("invalid blob name %q", name)
+	}
+	return filepath.Join(r.root, filepath.FromSlash(name)), nil
+}
+
+// Put implements Repository, writing a temp file and renaming it into place
+func (r *FSRepository) Put(name string, src io.Reader, size int64) error {
+	path, err := r.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create blob %s: %w", name, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, src)
+	if err == nil && n != size {
+		err = fmt.Errorf("wrote %d bytes, expected %d", n, size)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write blob %s: %w", name, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to commit blob %s: %w", name, err)
+	}
+	return nil
+}
+
+// Get implements Repository
+func (r *FSRepository) Get(name string) (io.ReadCloser, error) {
+	path, err := r.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, name)
+		}
+		return nil, fmt.Errorf("failed to open blob %s: %w", name, err)
+	}
+	return f, nil
+}
+
+// GetRange reads length bytes of a blob from offset, for storage.RangeObjectStore
+func (r *FSRepository) GetRange(name string, offset int64, length int64) (io.ReadCloser, error) {
+	f, err := r.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	file := f.(*os.File)
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(file, offset, length), file}, nil
+}
+
+// Size returns the size of a blob, for storage.RangeObjectStore
+func (r *FSRepository) Size(name string) (int64, error) {
+	path, err := r.path(name)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, fmt.Errorf("%w: %s", ErrBlobNotFound, name)
+		}
+		return 0, fmt.Errorf("failed to stat blob %s: %w", name, err)
+	}
+	return info.Size(), nil
+}
+
+// Delete implements Repository
+func (r *FSRepository) Delete(name string) error {
+	path, err := r.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete blob %s: %w", name, err)
+	}
+	return nil
+}
+
+// List implements Repository
+func (r *FSRepository) List(prefix string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(r.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(r.root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		// Skip uploads in progress
+		if strings.HasPrefix(name, prefix) && !strings.Contains(filepath.Base(name), ".tmp") {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
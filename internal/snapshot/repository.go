@@ -0,0 +1,82 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ErrBlobNotFound is returned by Repository.Get for a blob that doesn't exist
+var ErrBlobNotFound = errors.New("blob not found")
+
+// Repository stores the blobs of snapshots, e.g. in a local directory or an
+// S3-compatible object store
+// Blob names are slash-separated paths like "blobs/<sha256>"; implementations
+// must make Put atomic, so a failed upload never leaves a partial blob behind
+type Repository interface {
+	// Type is the repository's type in configuration, e.g. "fs"
+	Type() string
+	// Put stores a blob of the given size, replacing any blob with the same name
+	Put(name string, r io.Reader, size int64) error
+	// Get opens a blob; the error satisfies errors.Is(err, ErrBlobNotFound) if it doesn't exist
+	Get(name string) (io.ReadCloser, error)
+	// Delete removes a blob; deleting a missing blob is not an error
+	Delete(name string) error
+	// List returns the names of the blobs starting with prefix, sorted
+	List(prefix string) ([]string, error)
+}
+
+// Repository types
+const (
+	TypeFS = "fs"
+	TypeS3 = "s3"
+)
+
+// NewRepository creates a repository from its type and settings:
+//
+//	fs: location (directory of the repository, created if missing)
+//	s3: bucket, endpoint, region, base_path, access_key, secret_key
+//
+// S3 credentials default to the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+// environment variables, and the endpoint to AWS in the region
+func NewRepository(repoType string, settings map[string]string) (Repository, error) {
+	switch repoType {
+	case TypeFS:
+		return NewFSRepository(settings["location"])
+	case TypeS3:
+		config := S3Config{
+			Endpoint:  settings["endpoint"],
+			Bucket:    settings["bucket"],
+			Region:    settings["region"],
+			BasePath:  settings["base_path"],
+			AccessKey: settings["access_key"],
+			SecretKey: settings["secret_key"],
+		}
+		if config.AccessKey == "" && config.SecretKey == "" {
+			config.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+			config.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+		return NewS3Repository(config)
+	default:
+		types := []string{TypeFS, TypeS3}
+		sort.Strings(types)
+		return nil, fmt.Errorf("unknown repository type %q (supported: %s)", repoType, strings.Join(types, ", "))
+	}
+}
+
+// validBlobName reports whether a blob name is a relative slash-separated
+// path without empty, "." or ".." elements
+func validBlobName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,359 @@
+package snapshot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config locates an S3-compatible bucket (AWS S3, MinIO, Ceph, ...)
+type S3Config struct {
+	Endpoint  string // e.g. "http://localhost:9000"; defaults to AWS S3 in the region
+	Bucket    string
+	Region    string // Defaults to us-east-1
+	BasePath  string // Key prefix of the repository within the bucket
+	AccessKey string
+	SecretKey string
+}
+
+// S3Repository keeps blobs as objects in a bucket, addressed path-style
+// (endpoint/bucket/key) so it also works with self-hosted stores
+// Requests are signed with AWS Signature Version 4
+type S3Repository struct {
+	config S3Config
+	client *http.Client
+}
+
+// NewS3Repository creates a repository in a bucket
+func NewS3Repository(config S3Config) (*S3Repository, error) {
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("s3 repository requires a bucket")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://s3." + config.Region + ".amazonaws.com"
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	if _, err := url.Parse(config.Endpoint); err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	config.BasePath = strings.Trim(config.BasePath, "/")
+	return &S3Repository{config: config, client: &http.Client{Timeout: 10 * time.Minute}}, nil
+}
+
+// Type implements Repository
+func (r *S3Repository) Type() string {
+	return TypeS3
+}
+
+// key returns the object key of a blob
+func (r *S3Repository) key(name string) string {
+	if r.config.BasePath == "" {
+		return name
+	}
+	return r.config.BasePath + "/" + name
+}
+
+// newRequest creates a request for an object key ("" for the bucket itself)
+func (r *S3Repository) newRequest(method string, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	rawURL := r.config.Endpoint + "/" + r.config.Bucket
+	if key != "" {
+		rawURL += "/" + uriEncode(key, false)
+	}
+	if len(query) > 0 {
+		rawURL += "?" + canonicalQuery(query)
+	}
+	req, err := http.NewRequest(method, rawURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 request: %w", err)
+	}
+	return req, nil
+}
+
+// do signs and sends a request, turning unexpected statuses into errors
+// A 404 is returned as ErrBlobNotFound
+func (r *S3Repository) do(req *http.Request, name string) (*http.Response, error) {
+	req.Header.Set("x-amz-content-sha256", unsignedPayload)
+	signV4(req, r.config.AccessKey, r.config.SecretKey, r.config.Region, "s3", time.Now())
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s %s failed: %w", req.Method, name, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, name)
+	}
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(body, &s3Err) == nil && s3Err.Code != "" {
+		return nil, fmt.Errorf("s3 %s %s failed: %s: %s", req.Method, name, s3Err.Code, s3Err.Message)
+	}
+	return nil, fmt.Errorf("s3 %s %s failed: %s", req.Method, name, resp.Status)
+}
+
+// Put implements Repository; a single PUT creates the object atomically
+func (r *S3Repository) Put(name string, body io.Reader, size int64) error {
+	if !validBlobName(name) {
+		return fmt.Errorf("invalid blob name %q", name)
+	}
+	req, err := r.newRequest(http.MethodPut, r.key(name), nil, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	resp, err := r.do(req, name)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get implements Repository
+func (r *S3Repository) Get(name string) (io.ReadCloser, error) {
+	if !validBlobName(name) {
+		return nil, fmt.Errorf("invalid blob name %q", name)
+	}
+	req, err := r.newRequest(http.MethodGet, r.key(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.do(req, name)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// GetRange reads length bytes of a blob from offset with a ranged GET, for
+// storage.RangeObjectStore
+func (r *S3Repository) GetRange(name string, offset int64, length int64) (io.ReadCloser, error) {
+	if !validBlobName(name) {
+		return nil, fmt.Errorf("invalid blob name %q", name)
+	}
+	req, err := r.newRequest(http.MethodGet, r.key(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := r.do(req, name)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Size returns the size of a blob with a HEAD request, for storage.RangeObjectStore
+func (r *S3Repository) Size(name string) (int64, error) {
+	if !validBlobName(name) {
+		return 0, fmt.Errorf("invalid blob name %q", name)
+	}
+	req, err := r.newRequest(http.MethodHead, r.key(name), nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := r.do(req, name)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+// Delete implements Repository; S3 doesn't fail deletes of missing objects
+func (r *S3Repository) Delete(name string) error {
+	if !validBlobName(name) {
+		return fmt.Errorf("invalid blob name %q", name)
+	}
+	req, err := r.newRequest(http.MethodDelete, r.key(name), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.do(req, name)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List implements Repository with ListObjectsV2, following continuation tokens
+func (r *S3Repository) List(prefix string) ([]string, error) {
+	keyPrefix := r.key(prefix)
+	if r.config.BasePath != "" && prefix == "" {
+		keyPrefix = r.config.BasePath + "/"
+	}
+
+	var names []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {keyPrefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := r.newRequest(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := r.do(req, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode s3 listing: %w", err)
+		}
+
+		for _, obj := range result.Contents {
+			name := obj.Key
+			if r.config.BasePath != "" {
+				name = strings.TrimPrefix(name, r.config.BasePath+"/")
+			}
+			names = append(names, name)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// unsignedPayload is the payload hash of requests whose body isn't signed,
+// so uploads can stream without hashing the body first
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// signV4 adds the AWS Signature Version 4 headers to a request
+// Host and every header already set on the request are signed
+func signV4(req *http.Request, accessKey, secretKey, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+
+	payloadHash := req.Header.Get("x-amz-content-sha256")
+	if payloadHash == "" {
+		payloadHash = unsignedPayload
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// canonicalQuery encodes a query string with sorted keys, as signing requires
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters, and slashes
+// unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
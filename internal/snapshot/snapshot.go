@@ -0,0 +1,373 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"nano-elastic/internal/storage"
+)
+
+// Layout of a repository:
+//
+//	index.json             repository manifest: generation and list of snapshots
+//	snapshots/<name>.json  snapshot manifest: the files of each index
+//	blobs/<sha256>         file contents, shared by every snapshot containing them
+//
+// Blobs are content-addressed, so a snapshot only uploads the files that no
+// earlier snapshot has: sealed segments never change, and an index that only
+// took a few writes since the last snapshot uploads its new segments and
+// tombstones. Deleting a snapshot removes the blobs no other snapshot uses
+const (
+	repositoryIndexBlob = "index.json"
+	manifestPrefix      = "snapshots/"
+	blobPrefix          = "blobs/"
+)
+
+// validSnapshotName matches snapshot names that are safe to use as blob names
+var validSnapshotName = regexp.MustCompile(`^[a-z0-9][a-z0-9_.\-]*$`)
+
+// Info describes a completed snapshot
+type Info struct {
+	Name       string   `json:"name"`
+	Generation int64    `json:"generation"` // Repository generation that added the snapshot
+	Indexes    []string `json:"indexes"`
+	StartTime  int64    `json:"start_time_ms"`
+	EndTime    int64    `json:"end_time_ms"`
+	Stats      Stats    `json:"stats"`
+}
+
+// Stats counts the files of a snapshot, and those it had to upload
+type Stats struct {
+	Total       FileStats `json:"total"`
+	Incremental FileStats `json:"incremental"` // Files not already in the repository
+}
+
+// FileStats is a number of files and their total size
+type FileStats struct {
+	FileCount   int   `json:"file_count"`
+	SizeInBytes int64 `json:"size_in_bytes"`
+}
+
+func (s *FileStats) add(size int64) {
+	s.FileCount++
+	s.SizeInBytes += size
+}
+
+// Manifest lists the files of every index in a snapshot
+type Manifest struct {
+	Info
+	Files map[string][]File `json:"files"` // Index name -> files of its directory
+}
+
+// File is one file of an index directory and the blob holding its contents
+type File struct {
+	Name string `json:"name"`
+	Blob string `json:"blob"` // SHA-256 of the contents
+	Size int64  `json:"size"`
+}
+
+// repositoryIndex is the repository manifest
+// Its generation increases with every snapshot created or deleted
+type repositoryIndex struct {
+	Generation int64  `json:"generation"`
+	Snapshots  []Info `json:"snapshots"`
+}
+
+// SnapshotNotFoundError is returned for operations on a snapshot that doesn't exist
+type SnapshotNotFoundError struct {
+	Name string
+}
+
+func (e *SnapshotNotFoundError) Error() string {
+	return fmt.Sprintf("snapshot %s not found", e.Name)
+}
+
+// SnapshotExistsError is returned when creating a snapshot whose name is taken
+type SnapshotExistsError struct {
+	Name string
+}
+
+func (e *SnapshotExistsError) Error() string {
+	return fmt.Sprintf("snapshot %s already exists", e.Name)
+}
+
+// InvalidSnapshotNameError is returned for names that aren't lowercase letters, digits, '_', '-' or '.'
+type InvalidSnapshotNameError struct {
+	Name string
+}
+
+func (e *InvalidSnapshotNameError) Error() string {
+	return fmt.Sprintf("invalid snapshot name [%s]: must be lowercase letters, digits, '_', '-' or '.'", e.Name)
+}
+
+// Source is an index to include in a snapshot, with its pinned files
+type Source struct {
+	Index string
+	Files *storage.IndexFiles
+}
+
+// List returns the snapshots in a repository, oldest first
+func List(repo Repository) ([]Info, error) {
+	index, err := readRepositoryIndex(repo)
+	if err != nil {
+		return nil, err
+	}
+	return index.Snapshots, nil
+}
+
+// Load reads the manifest of a snapshot
+func Load(repo Repository, name string) (*Manifest, error) {
+	var manifest Manifest
+	if err := readJSON(repo, manifestPrefix+name+".json", &manifest); err != nil {
+		if errors.Is(err, ErrBlobNotFound) {
+			return nil, &SnapshotNotFoundError{Name: name}
+		}
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// Create uploads a snapshot of the given indexes
+// Files already stored by an earlier snapshot are referenced rather than
+// uploaded again. The snapshot becomes visible once its manifest and the
+// repository manifest are written; a failed snapshot leaves at most some
+// unreferenced blobs, removed by the next Delete
+// Callers must not run Create and Delete concurrently on the same repository
+func Create(repo Repository, name string, sources []Source) (*Info, error) {
+	if !validSnapshotName.MatchString(name) {
+		return nil, &InvalidSnapshotNameError{Name: name}
+	}
+	start := time.Now()
+
+	index, err := readRepositoryIndex(repo)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range index.Snapshots {
+		if info.Name == name {
+			return nil, &SnapshotExistsError{Name: name}
+		}
+	}
+	stored, err := referencedBlobs(repo, index)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{
+		Info:  Info{Name: name, Generation: index.Generation + 1, StartTime: start.UnixMilli()},
+		Files: make(map[string][]File, len(sources)),
+	}
+	for _, source := range sources {
+		files := make([]File, 0, len(source.Files.Files))
+		for _, f := range source.Files.Files {
+			file, uploaded, err := storeFile(repo, f, stored)
+			if err != nil {
+				return nil, fmt.Errorf("failed to snapshot %s of index %s: %w", f.Name, source.Index, err)
+			}
+			manifest.Stats.Total.add(file.Size)
+			if uploaded {
+				manifest.Stats.Incremental.add(file.Size)
+			}
+			files = append(files, file)
+		}
+		manifest.Files[source.Index] = files
+		manifest.Indexes = append(manifest.Indexes, source.Index)
+	}
+	sort.Strings(manifest.Indexes)
+	manifest.EndTime = time.Now().UnixMilli()
+
+	if err := writeJSON(repo, manifestPrefix+name+".json", manifest); err != nil {
+		return nil, err
+	}
+	index.Generation++
+	index.Snapshots = append(index.Snapshots, manifest.Info)
+	if err := writeJSON(repo, repositoryIndexBlob, index); err != nil {
+		return nil, err
+	}
+	return &manifest.Info, nil
+}
+
+// Delete removes a snapshot and the blobs no remaining snapshot references
+func Delete(repo Repository, name string) error {
+	index, err := readRepositoryIndex(repo)
+	if err != nil {
+		return err
+	}
+	kept := index.Snapshots[:0:0]
+	for _, info := range index.Snapshots {
+		if info.Name != name {
+			kept = append(kept, info)
+		}
+	}
+	if len(kept) == len(index.Snapshots) {
+		return &SnapshotNotFoundError{Name: name}
+	}
+
+	// Unlist the snapshot first, so a failure below never leaves a listed
+	// snapshot with missing blobs
+	index.Generation++
+	index.Snapshots = kept
+	if err := writeJSON(repo, repositoryIndexBlob, index); err != nil {
+		return err
+	}
+	if err := repo.Delete(manifestPrefix + name + ".json"); err != nil {
+		return err
+	}
+
+	referenced, err := referencedBlobs(repo, index)
+	if err != nil {
+		return err
+	}
+	blobs, err := repo.List(blobPrefix)
+	if err != nil {
+		return err
+	}
+	for _, blob := range blobs {
+		if !referenced[strings.TrimPrefix(blob, blobPrefix)] {
+			if err := repo.Delete(blob); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RestoreIndex writes the files of one index of a snapshot into a directory of
+// fs, replacing anything in it
+// The directory is removed if the restore fails
+func RestoreIndex(repo Repository, manifest *Manifest, index string, fs storage.FS, dir string) error {
+	files, ok := manifest.Files[index]
+	if !ok {
+		return fmt.Errorf("snapshot %s doesn't contain index %s", manifest.Name, index)
+	}
+
+	if err := fs.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to clear restore directory: %w", err)
+	}
+	if err := fs.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create restore directory: %w", err)
+	}
+	for _, file := range files {
+		if err := restoreFile(repo, file, fs, dir); err != nil {
+			fs.RemoveAll(dir)
+			return fmt.Errorf("failed to restore %s of index %s: %w", file.Name, index, err)
+		}
+	}
+	return nil
+}
+
+// storeFile uploads a file unless a blob with the same contents is stored,
+// adding it to stored
+func storeFile(repo Repository, f storage.IndexFile, stored map[string]bool) (File, bool, error) {
+	r, err := f.Open()
+	if err != nil {
+		return File{}, false, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, r)
+	r.Close()
+	if err != nil {
+		return File{}, false, err
+	}
+	file := File{Name: f.Name, Blob: hex.EncodeToString(hash.Sum(nil)), Size: size}
+	if stored[file.Blob] {
+		return file, false, nil
+	}
+
+	r, err = f.Open()
+	if err != nil {
+		return File{}, false, err
+	}
+	defer r.Close()
+	if err := repo.Put(blobPrefix+file.Blob, r, size); err != nil {
+		return File{}, false, err
+	}
+	stored[file.Blob] = true
+	return file, true, nil
+}
+
+// restoreFile downloads a file's blob into dir, verifying its contents
+func restoreFile(repo Repository, file File, fs storage.FS, dir string) error {
+	if file.Name != filepath.Base(file.Name) || file.Name == "." || file.Name == ".." {
+		return fmt.Errorf("invalid file name %q", file.Name)
+	}
+	r, err := repo.Get(blobPrefix + file.Blob)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	out, err := fs.OpenFile(filepath.Join(dir, file.Name), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if size != file.Size || hex.EncodeToString(hash.Sum(nil)) != file.Blob {
+		return fmt.Errorf("blob %s is corrupted", file.Blob)
+	}
+	return nil
+}
+
+// referencedBlobs returns the blobs used by the snapshots of a repository manifest
+func referencedBlobs(repo Repository, index *repositoryIndex) (map[string]bool, error) {
+	blobs := make(map[string]bool)
+	for _, info := range index.Snapshots {
+		manifest, err := Load(repo, info.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, files := range manifest.Files {
+			for _, file := range files {
+				blobs[file.Blob] = true
+			}
+		}
+	}
+	return blobs, nil
+}
+
+// readRepositoryIndex reads the repository manifest, empty for a new repository
+func readRepositoryIndex(repo Repository) (*repositoryIndex, error) {
+	var index repositoryIndex
+	if err := readJSON(repo, repositoryIndexBlob, &index); err != nil && !errors.Is(err, ErrBlobNotFound) {
+		return nil, err
+	}
+	return &index, nil
+}
+
+func readJSON(repo Repository, name string, v interface{}) error {
+	r, err := repo.Get(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", name, err)
+	}
+	return nil
+}
+
+func writeJSON(repo Repository, name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	return repo.Put(name, bytes.NewReader(data), int64(len(data)))
+}
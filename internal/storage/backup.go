@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// IndexFiles is a point-in-time copy of an index's on-disk state, e.g. for a backup
+// Sealed segment files are never modified, so they are copied from disk while
+// the index keeps taking writes; the segments stay on disk, like a Snapshot's,
+// until Release. Files that do change (schema, tombstones) are captured in memory
+type IndexFiles struct {
+	Files    []IndexFile
+	segments []*SegmentReader
+	released bool
+}
+
+// IndexFile is one file of an index directory
+// Exactly one of Path and Data is set
+type IndexFile struct {
+	Name string // File name within the index directory
+	Path string // Pinned file to copy
+	Data []byte // Contents captured when the files were acquired
+	fs   FS     // File system of Path
+}
+
+// Open returns the file's contents
+func (f IndexFile) Open() (io.ReadCloser, error) {
+	if f.Path == "" {
+		return io.NopCloser(bytes.NewReader(f.Data)), nil
+	}
+	return f.fs.Open(f.Path)
+}
+
+// AcquireFiles flushes the memtables and captures the files making up the index
+// The caller must Release the files so merged segments can be deleted
+func (im *IndexManager) AcquireFiles() (*IndexFiles, error) {
+	if err := im.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to flush memtables: %w", err)
+	}
+
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	schema, err := json.MarshalIndent(im.Schema, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode schema: %w", err)
+	}
+
+	manifest, err := json.MarshalIndent(im.manifest(), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	files := &IndexFiles{Files: []IndexFile{{Name: SchemaFile, Data: schema}}}
+	for _, seg := range im.segments {
+		seg.acquire()
+		files.segments = append(files.segments, seg)
+
+		segFiles, err := seg.files()
+		if err != nil {
+			files.Release()
+			return nil, err
+		}
+		files.Files = append(files.Files, segFiles...)
+	}
+	files.Files = append(files.Files, IndexFile{Name: manifestName(im.manifestGen + 1), Data: manifest})
+	return files, nil
+}
+
+// Release unpins the segments, completing merges that removed them meanwhile
+func (f *IndexFiles) Release() error {
+	if f.released {
+		return nil
+	}
+	f.released = true
+
+	var firstErr error
+	for _, seg := range f.segments {
+		if err := seg.release(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// files returns the segment's data, doc values, norms, sketch and postings files, and its current doc index and tombstones
+func (s *SegmentReader) files() ([]IndexFile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	index, err := s.sealSidecar(s.indexPath(), s.encodeIndex())
+	if err != nil {
+		return nil, err
+	}
+	files := []IndexFile{
+		{Name: filepath.Base(s.Path), Path: s.Path, fs: s.fs},
+		{Name: filepath.Base(s.indexPath()), Data: index},
+	}
+	for _, path := range []string{s.docValuesPath(), s.normsPath(), s.sketchesPath(), s.postingsPath()} {
+		if _, err := s.fs.Stat(path); err == nil {
+			files = append(files, IndexFile{Name: filepath.Base(path), Path: path, fs: s.fs})
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to stat segment file: %w", err)
+		}
+	}
+	if len(s.deleted) > 0 {
+		data, err := s.encodeDeletes()
+		if err == nil {
+			data, err = s.sealSidecar(s.deletesPath(), data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, IndexFile{Name: filepath.Base(s.deletesPath()), Data: data})
+	}
+	return files, nil
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"nano-elastic/internal/errdefs"
+	"nano-elastic/internal/types"
+)
+
+// BatchOp is one write in a batch: a document to store, or an ID to delete
+type BatchOp struct {
+	Delete   bool
+	ID       string          // Document ID to delete
+	Document *types.Document // Document to store (nil for deletes)
+}
+
+// WriteBatch applies ops in order, syncing the WAL once for the whole batch
+// The first return value holds a per-op error (nil on success) for schema
+// validation failures and deletes of missing documents; the second is a
+// storage failure, after which the batch may be partially applied
+func (im *IndexManager) WriteBatch(ops []BatchOp) ([]error, error) {
+	return im.WriteBatchContext(context.Background(), ops)
+}
+
+// WriteBatchContext is WriteBatch, stopped with the context's error if it is
+// done before the batch is logged; nothing is applied then. A logged batch is
+// always applied in full
+func (im *IndexManager) WriteBatchContext(ctx context.Context, ops []BatchOp) ([]error, error) {
+	start := time.Now()
+	errs := make([]error, len(ops))
+	data := make([][]byte, len(ops))
+	for i, op := range ops {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if op.Delete {
+			continue
+		}
+		if err := im.Schema.ValidateDocument(op.Document); err != nil {
+			errs[i] = fmt.Errorf("schema validation failed: %w", err)
+			continue
+		}
+		encoded, err := op.Document.MarshalBinary()
+		if err != nil {
+			errs[i] = fmt.Errorf("failed to encode document: %w", err)
+			continue
+		}
+		data[i] = encoded
+	}
+
+	im.mu.RLock()
+
+	// Deletes of documents that don't exist, counting earlier ops in the batch,
+	// are rejected before anything is logged
+	pending := make(map[string]bool) // ID -> live after the ops so far
+	entries := make([]WALEntry, 0, len(ops))
+	for i, op := range ops {
+		if errs[i] != nil {
+			continue
+		}
+		if op.Delete {
+			live, ok := pending[op.ID]
+			if !ok {
+				live = im.exists(op.ID)
+			}
+			if !live {
+				errs[i] = &errdefs.DocNotFoundError{ID: op.ID}
+				continue
+			}
+			pending[op.ID] = false
+			entries = append(entries, WALEntry{Type: WALEntryDelete, Index: im.Name, DocID: op.ID})
+			continue
+		}
+		pending[op.Document.ID] = true
+		entries = append(entries, WALEntry{Type: WALEntryWrite, Index: im.Name, DocID: op.Document.ID, Document: op.Document})
+	}
+	if len(entries) == 0 {
+		im.mu.RUnlock()
+		return errs, nil
+	}
+
+	// Write to WAL first (for durability)
+	if err := im.wal.WriteEntriesContext(ctx, entries); err != nil {
+		im.mu.RUnlock()
+		return errs, fmt.Errorf("failed to write to WAL: %w", err)
+	}
+
+	next, deletes := 0, 0
+	for i, op := range ops {
+		if errs[i] != nil {
+			continue
+		}
+		seq := entries[next].Sequence
+		next++
+		if op.Delete {
+			im.memtable.put(op.ID, nil, seq)
+			deletes++
+		} else {
+			im.memtable.put(op.Document.ID, data[i], seq)
+		}
+	}
+	im.counters.recordWrites(len(entries)-deletes, deletes, start)
+	if im.logger.DebugEnabled() {
+		im.logger.Debug("batch written", "writes", len(entries)-deletes, "deletes", deletes, "rejected", len(ops)-len(entries), "sequence", entries[len(entries)-1].Sequence)
+	}
+
+	full := im.memtableFull(im.memtable)
+	im.mu.RUnlock()
+
+	if full {
+		return errs, im.rotateIfFull()
+	}
+	return errs, nil
+}
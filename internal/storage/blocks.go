@@ -0,0 +1,461 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"nano-elastic/internal/types"
+)
+
+// StoredBlockSize is the uncompressed size at which a block of document records is closed
+// Larger blocks compress better; a single-document read decompresses one block
+const StoredBlockSize = 16 * 1024
+
+// Block format: [codec:u8][rawLen:u32][storedLen:u32][crc:u32][stored bytes]
+// The CRC covers the stored bytes
+const blockHeaderSize = 13
+
+// blockEncrypted is set in the codec byte of an encrypted block, whose stored
+// bytes are its compressed records sealed by the index's encryptor
+const blockEncrypted byte = 0x80
+
+// blockAAD returns the additional data an encrypted block is sealed with: the
+// segment ID and the offset of the block's header, so a block can't be moved
+// within its segment or into another one
+func blockAAD(segmentID string, offset int64) []byte {
+	return binary.LittleEndian.AppendUint64([]byte(segmentID), uint64(offset))
+}
+
+// segmentHeaderSize is where a segment's records start
+var segmentHeaderSize = int64(binary.Size(SegmentHeader{}))
+
+// storedBlock is a block index entry of a compressed segment
+// The decompressed blocks concatenate to the records of the uncompressed
+// segment, so records keep their offsets and the doc index is unchanged
+type storedBlock struct {
+	rawOffset  int64 // Offset of the block's first record in the uncompressed segment
+	rawLen     int
+	fileOffset int64 // Offset of the stored bytes, after the block header
+	storedLen  int
+	codec      byte
+	crc        uint32
+	keyID      uint32 // Key an encrypted block is sealed with
+}
+
+// blockCache holds the most recently decompressed block, so sequential reads
+// (e.g. merges) and hot documents don't decompress the same block repeatedly
+type blockCache struct {
+	mu    sync.Mutex
+	block int // Index of the cached block, -1 if none
+	raw   []byte
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func (c *blockCache) get(block int) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.raw == nil || c.block != block {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return c.raw, true
+}
+
+func (c *blockCache) put(block int, raw []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.block, c.raw = block, raw
+}
+
+// size returns the bytes held by the cached block
+func (c *blockCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.raw)
+}
+
+func (c *blockCache) reset() {
+	c.put(-1, nil)
+}
+
+// readBlockIndex rebuilds the block index by walking the block headers
+// Caller must hold s.mu for writing
+func (s *SegmentReader) readBlockIndex() error {
+	s.blocks = nil
+	s.blockCache.reset()
+
+	rawOffset := segmentHeaderSize
+	var header [blockHeaderSize]byte
+	for offset := segmentHeaderSize; offset < s.Size; {
+		if _, err := s.file.ReadAt(header[:], offset); err != nil {
+			return &CorruptionError{Path: s.Path, Offset: offset, Reason: "truncated block header"}
+		}
+		block := storedBlock{
+			rawOffset:  rawOffset,
+			rawLen:     int(binary.LittleEndian.Uint32(header[1:5])),
+			fileOffset: offset + blockHeaderSize,
+			storedLen:  int(binary.LittleEndian.Uint32(header[5:9])),
+			codec:      header[0],
+			crc:        binary.LittleEndian.Uint32(header[9:13]),
+		}
+		if block.fileOffset+int64(block.storedLen) > s.Size {
+			return &CorruptionError{Path: s.Path, Offset: offset, Reason: "truncated block"}
+		}
+		if block.codec&blockEncrypted != 0 {
+			var keyID [sealedKeyIDSize]byte
+			if block.storedLen < sealedOverhead {
+				return &CorruptionError{Path: s.Path, Offset: offset, Reason: "truncated encrypted block"}
+			}
+			if _, err := s.file.ReadAt(keyID[:], block.fileOffset); err != nil {
+				return &CorruptionError{Path: s.Path, Offset: offset, Reason: "truncated block"}
+			}
+			block.keyID = sealedKeyID(keyID[:])
+		}
+		s.blocks = append(s.blocks, block)
+		rawOffset += int64(block.rawLen)
+		offset = block.fileOffset + int64(block.storedLen)
+	}
+	return nil
+}
+
+// readBlock returns the decompressed records of a block
+// Caller must hold s.mu
+func (s *SegmentReader) readBlock(i int) ([]byte, error) {
+	if raw, ok := s.blockCache.get(i); ok {
+		return raw, nil
+	}
+
+	block := s.blocks[i]
+	stored, err := s.storedBlock(i)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.codec&blockEncrypted != 0 {
+		if s.encryptor == nil {
+			return nil, fmt.Errorf("block at offset %d of %s is encrypted and no encryption keys are configured", block.fileOffset-blockHeaderSize, s.Path)
+		}
+		if stored, err = s.encryptor.open(stored, blockAAD(s.ID, block.fileOffset-blockHeaderSize)); err != nil {
+			return nil, fmt.Errorf("block at offset %d of %s: %w", block.fileOffset-blockHeaderSize, s.Path, err)
+		}
+	}
+
+	codec, ok := codecByID(block.codec &^ blockEncrypted)
+	if !ok {
+		return nil, fmt.Errorf("block at offset %d of %s uses unknown codec %d", block.fileOffset-blockHeaderSize, s.Path, block.codec)
+	}
+	raw, err := codec.Decompress(stored, block.rawLen)
+	if err != nil {
+		return nil, &CorruptionError{Path: s.Path, Offset: block.fileOffset - blockHeaderSize, Reason: err.Error()}
+	}
+	if block.codec == codecIDNone {
+		raw = append([]byte(nil), raw...) // Don't cache bytes aliasing the mapping
+	}
+
+	s.blockCache.put(i, raw)
+	return raw, nil
+}
+
+// storedBlock returns the stored bytes of a block, checked against its checksum
+// Caller must hold s.mu
+func (s *SegmentReader) storedBlock(i int) ([]byte, error) {
+	block := s.blocks[i]
+	var stored []byte
+	if s.mapped != nil {
+		stored = s.mapped[block.fileOffset : block.fileOffset+int64(block.storedLen)]
+	} else {
+		stored = make([]byte, block.storedLen)
+		if _, err := s.file.ReadAt(stored, block.fileOffset); err != nil {
+			return nil, &CorruptionError{Path: s.Path, Offset: block.fileOffset - blockHeaderSize, Reason: "truncated block"}
+		}
+	}
+	if err := verifyChecksum(s.Path, block.fileOffset-blockHeaderSize, stored, block.crc); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// blockRecord reads the document record at an uncompressed offset
+// Caller must hold s.mu
+func (s *SegmentReader) blockRecord(offset int64) (*types.Document, error) {
+	i := sort.Search(len(s.blocks), func(i int) bool {
+		return s.blocks[i].rawOffset+int64(s.blocks[i].rawLen) > offset
+	})
+	if i == len(s.blocks) || offset < s.blocks[i].rawOffset {
+		return nil, &CorruptionError{Path: s.Path, Offset: offset, Reason: "record outside stored blocks"}
+	}
+
+	raw, err := s.readBlock(i)
+	if err != nil {
+		return nil, err
+	}
+	record, err := recordAt(raw, offset-s.blocks[i].rawOffset)
+	if err != nil {
+		return nil, &CorruptionError{Path: s.Path, Offset: offset, Reason: err.Error()}
+	}
+	return decodeRecord(s.Path, offset, record[8:], binary.LittleEndian.Uint32(record[4:8]))
+}
+
+// recordAt returns the record, prefix included, starting at pos in buf
+func recordAt(buf []byte, pos int64) ([]byte, error) {
+	if pos < 0 || pos+8 > int64(len(buf)) {
+		return nil, fmt.Errorf("truncated record header")
+	}
+	end := pos + 8 + int64(binary.LittleEndian.Uint32(buf[pos:pos+4]))
+	if end > int64(len(buf)) {
+		return nil, fmt.Errorf("truncated record data")
+	}
+	return buf[pos:end], nil
+}
+
+// forEachRecord calls fn with every record, prefix included, and its offset in
+// the uncompressed layout, in file order
+// The record bytes are only valid during the call
+// Caller must hold s.mu
+func (s *SegmentReader) forEachRecord(fn func(offset int64, record []byte) error) error {
+	if s.blocks != nil {
+		for i := range s.blocks {
+			raw, err := s.readBlock(i)
+			if err != nil {
+				return err
+			}
+			for pos := int64(0); pos < int64(len(raw)); {
+				record, err := recordAt(raw, pos)
+				if err != nil {
+					return &CorruptionError{Path: s.Path, Offset: s.blocks[i].rawOffset + pos, Reason: err.Error()}
+				}
+				if err := fn(s.blocks[i].rawOffset+pos, record); err != nil {
+					return err
+				}
+				pos += int64(len(record))
+			}
+		}
+		return nil
+	}
+
+	var prefix [8]byte
+	var record []byte
+	for offset := segmentHeaderSize; offset < s.Size; {
+		if _, err := s.file.ReadAt(prefix[:], offset); err != nil {
+			return &CorruptionError{Path: s.Path, Offset: offset, Reason: "truncated record header"}
+		}
+		n := 8 + int(binary.LittleEndian.Uint32(prefix[0:4]))
+		if cap(record) < n {
+			record = make([]byte, n)
+		}
+		record = record[:n]
+		if _, err := s.file.ReadAt(record, offset); err != nil {
+			return &CorruptionError{Path: s.Path, Offset: offset, Reason: "truncated record data"}
+		}
+		if err := fn(offset, record); err != nil {
+			return err
+		}
+		offset += int64(n)
+	}
+	return nil
+}
+
+// hasEncryptedBlocks reports whether any of the segment's blocks is encrypted
+// Caller must hold s.mu
+func (s *SegmentReader) hasEncryptedBlocks() bool {
+	for _, block := range s.blocks {
+		if block.codec&blockEncrypted != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptedWith reports whether all of the segment's records are in blocks
+// encrypted with the given key
+// Caller must hold s.mu
+func (s *SegmentReader) encryptedWith(keyID uint32) bool {
+	if s.Size <= segmentHeaderSize {
+		return true // Nothing to encrypt
+	}
+	if s.blocks == nil {
+		return false
+	}
+	for _, block := range s.blocks {
+		if block.codec&blockEncrypted == 0 || block.keyID != keyID {
+			return false
+		}
+	}
+	return true
+}
+
+// blockWriter packs records into blocks of about StoredBlockSize, compressed by
+// codec and, if encryptor is set, encrypted
+type blockWriter struct {
+	w         io.Writer
+	codec     Codec
+	encryptor *encryptor
+	segmentID string // Bound to encrypted blocks (see blockAAD)
+	block     []byte // Records of the block being filled
+	stored    []byte
+	size      int64 // Bytes written, block headers included
+}
+
+// add appends a record, prefix included, writing the block out once it is full
+// A record given in parts is kept whole within the block
+func (b *blockWriter) add(record ...[]byte) error {
+	for _, part := range record {
+		b.block = append(b.block, part...)
+	}
+	if len(b.block) >= StoredBlockSize {
+		return b.flush()
+	}
+	return nil
+}
+
+// flush writes out the block being filled, if it holds any records
+func (b *blockWriter) flush() error {
+	if len(b.block) == 0 {
+		return nil
+	}
+	compressed, err := b.codec.Compress(b.stored[:0], b.block)
+	if err != nil {
+		return fmt.Errorf("failed to compress block: %w", err)
+	}
+	b.stored = compressed
+	id := b.codec.ID()
+	if len(b.stored) >= len(b.block) {
+		b.stored, id = append(b.stored[:0], b.block...), codecIDNone // Incompressible
+	}
+	stored := b.stored
+	if b.encryptor != nil {
+		if stored, err = b.encryptor.seal(nil, stored, blockAAD(b.segmentID, segmentHeaderSize+b.size)); err != nil {
+			return fmt.Errorf("failed to encrypt block: %w", err)
+		}
+		id |= blockEncrypted
+	}
+
+	var header [blockHeaderSize]byte
+	header[0] = id
+	binary.LittleEndian.PutUint32(header[1:5], uint32(len(b.block)))
+	binary.LittleEndian.PutUint32(header[5:9], uint32(len(stored)))
+	binary.LittleEndian.PutUint32(header[9:13], checksum(stored))
+	if _, err := b.w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := b.w.Write(stored); err != nil {
+		return err
+	}
+	b.size += blockHeaderSize + int64(len(stored))
+	b.block = b.block[:0]
+	return nil
+}
+
+// rewriteRecords rewrites the segment with its records compressed into blocks
+// by codec, or back into plain records when codec is CodecNone
+// With encryption the blocks are encrypted with the current key, whatever the
+// codec, e.g. to re-encrypt the segment after a key rotation
+// The new file replaces the old one whole, so the records on disk are never
+// modified in place; it is reopened read-only like any sealed segment
+// Records keep their offsets, so the doc index and open snapshots stay valid
+// Caller must hold s.mu for writing
+func (s *SegmentReader) rewriteRecords(codec Codec) error {
+	if err := s.unmap(); err != nil {
+		return err
+	}
+
+	tmpPath := s.Path + ".tmp"
+	tmp, err := s.fs.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create segment rewrite file: %w", err)
+	}
+	abort := func(err error) error {
+		tmp.Close()
+		s.fs.Remove(tmpPath)
+		return err
+	}
+
+	version := SegmentVersion
+	if codec != CodecNone || s.encryptor != nil {
+		version = SegmentVersionCompressed
+	}
+	header := SegmentHeader{
+		Version:  uint16(version),
+		DocCount: uint32(s.DocCount),
+		Created:  s.Created,
+		Encoding: uint8(s.Encoding),
+	}
+	copy(header.Magic[:], SegmentMagic)
+
+	w := bufio.NewWriter(tmp)
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return abort(fmt.Errorf("failed to write segment header: %w", err))
+	}
+
+	size := segmentHeaderSize
+	blocks := &blockWriter{w: w, codec: codec, encryptor: s.encryptor, segmentID: s.ID}
+	err = s.forEachRecord(func(_ int64, record []byte) error {
+		if version != SegmentVersionCompressed {
+			size += int64(len(record))
+			_, err := w.Write(record)
+			return err
+		}
+		return blocks.add(record)
+	})
+	if err == nil {
+		err = blocks.flush()
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return abort(fmt.Errorf("failed to rewrite segment %s: %w", s.ID, err))
+	}
+	size += blocks.size
+
+	// Point the segment at the new file, then swap it in
+	oldFile, oldSize, oldVersion := s.file, s.Size, s.Version
+	s.file, s.Size, s.Version = tmp, size, version
+	restore := func(err error) error {
+		s.file, s.Size, s.Version = oldFile, oldSize, oldVersion
+		return abort(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return restore(fmt.Errorf("failed to sync rewritten segment: %w", err))
+	}
+	if err := s.fs.Rename(tmpPath, s.Path); err != nil {
+		return restore(fmt.Errorf("failed to replace segment file: %w", err))
+	}
+	oldFile.Close()
+	tmp.Close()
+	if s.file, err = s.fs.Open(s.Path); err != nil {
+		return fmt.Errorf("failed to reopen segment file: %w", err)
+	}
+
+	// Offsets are unchanged; the sidecar records the new data size and version,
+	// and until it does it no longer matches the file, which rebuilds the index
+	s.idxDirty = true
+	if err := s.writeIndex(); err != nil {
+		return err
+	}
+	// The other sidecars are sealed again too, so they don't keep an old key in use
+	if s.encryptor != nil {
+		s.dvDirty, s.nrmDirty, s.hllDirty = true, true, true
+		s.delDirty = s.delDirty || len(s.deleted) > 0
+		if err := errors.Join(s.writeDeletes(), s.writeDocValues(), s.writeNorms(), s.writeSketches(), s.resealPostings()); err != nil {
+			return err
+		}
+	}
+
+	if version == SegmentVersionCompressed {
+		return s.readBlockIndex()
+	}
+	s.blocks = nil
+	s.blockCache.reset()
+	return nil
+}
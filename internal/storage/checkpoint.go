@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Checkpoint file: [magic "NCKP"][sequence:uint64][crc:uint32]
+// The sequence is the WAL sequence up to which every write is in the segments,
+// so only later entries are replayed when the index is opened
+const CheckpointMagic = "NCKP"
+
+// checkpointSize is the size of the checkpoint file
+const checkpointSize = 4 + 8 + 4
+
+// checkpointPath returns the path of an index's checkpoint file
+func checkpointPath(basePath string) string {
+	return filepath.Join(basePath, "wal.ckp")
+}
+
+// readCheckpoint returns the sequence recorded by writeCheckpoint, 0 if the
+// index has none yet
+func readCheckpoint(fs FS, basePath string) (uint64, error) {
+	path := checkpointPath(basePath)
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	if len(data) != checkpointSize || string(data[0:4]) != CheckpointMagic {
+		return 0, &CorruptionError{Path: path, Offset: 0, Reason: "invalid checkpoint"}
+	}
+	if err := verifyChecksum(path, 0, data[4:12], binary.LittleEndian.Uint32(data[12:16])); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(data[4:12]), nil
+}
+
+// writeCheckpoint durably records that every WAL entry up to sequence is in
+// the segments (write temp file, sync, then rename)
+func writeCheckpoint(fs FS, basePath string, sequence uint64) error {
+	data := make([]byte, checkpointSize)
+	copy(data[0:4], CheckpointMagic)
+	binary.LittleEndian.PutUint64(data[4:12], sequence)
+	binary.LittleEndian.PutUint32(data[12:16], checksum(data[4:12]))
+
+	path := checkpointPath(basePath)
+	tmpPath := path + ".tmp"
+	tmp, err := fs.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := fs.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to commit checkpoint: %w", err)
+	}
+	return nil
+}
+
+// flushedSequence returns the WAL sequence up to which every write is in the
+// segments: just below the oldest write still buffered, or the WAL's last
+// sequence when nothing is
+// A buffered write replacing an older one hides it, but replaying the newer
+// write restores the same state
+// Caller must hold im.mu for writing, so no write is between the WAL and a memtable
+func (im *IndexManager) flushedSequence() uint64 {
+	flushed := im.wal.Sequence()
+	for _, mt := range im.memtables() {
+		for _, entry := range mt.snapshot() {
+			if entry.seq <= flushed {
+				flushed = entry.seq - 1
+			}
+		}
+	}
+	return flushed
+}
+
+// appliedSequence returns the highest WAL sequence written to a segment
+// Memtables are flushed in order, so every earlier write is in a segment too
+// Caller must hold im.mu
+func (im *IndexManager) appliedSequence() uint64 {
+	var applied uint64
+	for _, seg := range im.segments {
+		applied = max(applied, seg.Sequence)
+	}
+	return applied
+}
+
+// replayWAL applies the WAL entries after the checkpoint to the memtables, so
+// writes acknowledged but not yet flushed when the index last stopped (e.g. in
+// a crash) are recovered before it serves traffic
+// Entries a segment already holds are skipped, in case the index stopped
+// between a flush and its checkpoint, so replaying is idempotent
+// Returns the number of entries replayed
+func (im *IndexManager) replayWAL() (int, error) {
+	checkpoint, err := readCheckpoint(im.fs, im.BasePath)
+	if err != nil {
+		return 0, err
+	}
+	applied := im.appliedSequence()
+
+	replayed := 0
+	dirty := make(map[*SegmentReader]bool)
+	err = im.wal.Replay(func(entry *WALEntry) error {
+		if entry.Sequence <= checkpoint {
+			return nil
+		}
+		if entry.Sequence <= applied {
+			// The flush may have stopped before tombstoning older copies
+			if entry.Type == WALEntryDelete {
+				im.deleteOlder(entry.DocID, entry.Sequence, dirty)
+			}
+			return nil
+		}
+		var data []byte
+		if entry.Type != WALEntryDelete {
+			if entry.Document == nil {
+				return fmt.Errorf("WAL entry %d has no document", entry.Sequence)
+			}
+			var err error
+			if data, err = entry.Document.MarshalBinary(); err != nil {
+				return fmt.Errorf("failed to encode document %s: %w", entry.DocID, err)
+			}
+		}
+		im.memtable.put(entry.DocID, data, entry.Sequence)
+		if im.memtableFull(im.memtable) {
+			im.rotateMemtable()
+		}
+		replayed++
+		return nil
+	})
+	if err != nil {
+		return replayed, fmt.Errorf("failed to replay WAL: %w", err)
+	}
+
+	for seg := range dirty {
+		if err := seg.Flush(); err != nil {
+			return replayed, fmt.Errorf("failed to flush tombstones: %w", err)
+		}
+	}
+	return replayed, nil
+}
+
+// deleteOlder tombstones the copies of a document in segments flushed before
+// the delete at sequence, adding them to dirty
+// Copies of later writes are in segments with a later sequence, and stay
+func (im *IndexManager) deleteOlder(id string, sequence uint64, dirty map[*SegmentReader]bool) {
+	for _, seg := range im.segments {
+		if seg.Sequence < sequence && seg.Delete(id) {
+			dirty[seg] = true
+		}
+	}
+	if seg, ok := im.docs[id]; ok && seg.Sequence < sequence {
+		delete(im.docs, id)
+	}
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"fmt"
+	"hash/crc32"
+
+	"nano-elastic/internal/errdefs"
+)
+
+// crcTable is the Castagnoli polynomial table used for all on-disk checksums
+// It has hardware support on most CPUs and better error detection than IEEE
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksum computes the CRC32 of a record payload
+func checksum(data []byte) uint32 {
+	return crc32.Checksum(data, crcTable)
+}
+
+// CorruptionError is returned when a record fails checksum or framing validation
+// Offset is the position of the record's length prefix in the file
+type CorruptionError struct {
+	Path   string
+	Offset int64
+	Reason string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("corrupted record in %s at offset %d: %s", e.Path, e.Offset, e.Reason)
+}
+
+// Is matches errdefs.ErrCorruptSegment
+func (e *CorruptionError) Is(target error) bool {
+	return target == errdefs.ErrCorruptSegment
+}
+
+// verifyChecksum checks a payload against its stored checksum
+func verifyChecksum(path string, offset int64, data []byte, expected uint32) error {
+	if actual := checksum(data); actual != expected {
+		return &CorruptionError{
+			Path:   path,
+			Offset: offset,
+			Reason: fmt.Sprintf("checksum mismatch (expected %08x, got %08x)", expected, actual),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Codec compresses the blocks of document records in sealed segments
+// Each block records the ID of the codec that wrote it, so changing an index's
+// codec only affects segments sealed afterwards
+type Codec interface {
+	// Name is the codec's name in configuration, e.g. "lz4"
+	Name() string
+	// ID identifies the codec in block headers
+	ID() byte
+	// Compress appends the compressed form of src to dst
+	Compress(dst, src []byte) ([]byte, error)
+	// Decompress decodes a block that was rawLen bytes before compression
+	Decompress(src []byte, rawLen int) ([]byte, error)
+}
+
+// Codec IDs stored in block headers
+const (
+	codecIDNone    byte = 0
+	codecIDLZ4     byte = 1
+	codecIDDeflate byte = 2
+	codecIDZstd    byte = 3
+)
+
+var (
+	// CodecNone leaves stored documents uncompressed
+	CodecNone Codec = noneCodec{}
+	// CodecLZ4 uses the LZ4 block format: fast to decompress, moderate ratio
+	CodecLZ4 Codec = lz4Codec{}
+	// CodecDeflate uses DEFLATE for a better ratio at a higher CPU cost
+	CodecDeflate Codec = deflateCodec{}
+	// CodecZstd uses Zstandard frames: a better ratio than LZ4, still fast to decompress
+	CodecZstd Codec = zstdCodec{}
+)
+
+// DefaultCodec is the codec used when an index doesn't configure one
+var DefaultCodec = CodecLZ4
+
+var codecs = []Codec{CodecNone, CodecLZ4, CodecDeflate, CodecZstd}
+
+// CodecByName returns the codec with the given configuration name
+func CodecByName(name string) (Codec, error) {
+	names := make([]string, len(codecs))
+	for i, codec := range codecs {
+		if codec.Name() == name {
+			return codec, nil
+		}
+		names[i] = codec.Name()
+	}
+	return nil, fmt.Errorf("unknown codec %q (supported: %s)", name, strings.Join(names, ", "))
+}
+
+// codecByID returns the codec that wrote a block
+func codecByID(id byte) (Codec, bool) {
+	for _, codec := range codecs {
+		if codec.ID() == id {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+type noneCodec struct{}
+
+func (noneCodec) Name() string { return "none" }
+func (noneCodec) ID() byte     { return codecIDNone }
+
+func (noneCodec) Compress(dst, src []byte) ([]byte, error) {
+	return append(dst, src...), nil
+}
+
+func (noneCodec) Decompress(src []byte, rawLen int) ([]byte, error) {
+	if len(src) != rawLen {
+		return nil, fmt.Errorf("stored block is %d bytes, expected %d", len(src), rawLen)
+	}
+	return src, nil
+}
+
+type lz4Codec struct{}
+
+func (lz4Codec) Name() string { return "lz4" }
+func (lz4Codec) ID() byte     { return codecIDLZ4 }
+
+func (lz4Codec) Compress(dst, src []byte) ([]byte, error) {
+	return lz4Compress(dst, src), nil
+}
+
+func (lz4Codec) Decompress(src []byte, rawLen int) ([]byte, error) {
+	return lz4Decompress(src, rawLen)
+}
+
+type deflateCodec struct{}
+
+func (deflateCodec) Name() string { return "deflate" }
+func (deflateCodec) ID() byte     { return codecIDDeflate }
+
+func (deflateCodec) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w, err := flate.NewWriter(buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (deflateCodec) Decompress(src []byte, rawLen int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+
+	raw := make([]byte, rawLen)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, fmt.Errorf("failed to inflate block: %w", err)
+	}
+	return raw, nil
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string { return "zstd" }
+func (zstdCodec) ID() byte     { return codecIDZstd }
+
+func (zstdCodec) Compress(dst, src []byte) ([]byte, error) {
+	return zstdCompress(dst, src), nil
+}
+
+func (zstdCodec) Decompress(src []byte, rawLen int) ([]byte, error) {
+	return zstdDecompress(src, rawLen)
+}
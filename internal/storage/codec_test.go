@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+type codecTestInput struct {
+	name string
+	data []byte
+}
+
+// codecTestInputs returns inputs covering the edge cases of every codec
+func codecTestInputs() []codecTestInput {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 64<<10)
+	r.Read(random)
+
+	var text bytes.Buffer
+	for i := 0; text.Len() < 3*zstdMaxBlockSize; i++ {
+		fmt.Fprintf(&text, `{"id":"doc-%d","title":"the quick brown fox %d","price":%d}`+"\n", i, r.Intn(100), r.Intn(100000))
+	}
+
+	// Matches that overlap themselves and reach back across blocks
+	var mixed []byte
+	for len(mixed) < 2*zstdMaxBlockSize {
+		mixed = append(mixed, random[:r.Intn(300)]...)
+		mixed = append(mixed, bytes.Repeat([]byte{byte(r.Intn(256))}, r.Intn(40))...)
+		mixed = append(mixed, text.Bytes()[:r.Intn(2000)]...)
+	}
+
+	return []codecTestInput{
+		{"empty", nil},
+		{"one byte", []byte{42}},
+		{"short", []byte("hello, hello, hello")},
+		{"incompressible", random},
+		{"repetitive", bytes.Repeat([]byte{'a'}, 200<<10)},
+		{"repeated pattern", bytes.Repeat([]byte("abcdefgh12345678"), 10<<10)},
+		{"high bytes", bytes.Repeat([]byte{0xF0, 0xF1, 0xF2, 0x80, 0xFF, 0xF0}, 4<<10)},
+		{"larger than a block", text.Bytes()},
+		{"mixed", mixed},
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, codec := range codecs {
+		for _, input := range codecTestInputs() {
+			t.Run(codec.Name()+"/"+input.name, func(t *testing.T) {
+				prefix := []byte("prefix")
+				compressed, err := codec.Compress(append([]byte(nil), prefix...), input.data)
+				if err != nil {
+					t.Fatalf("compress: %v", err)
+				}
+				if !bytes.HasPrefix(compressed, prefix) {
+					t.Fatal("compress didn't append to dst")
+				}
+
+				out, err := codec.Decompress(compressed[len(prefix):], len(input.data))
+				if err != nil {
+					t.Fatalf("decompress: %v", err)
+				}
+				if !bytes.Equal(out, input.data) {
+					t.Fatal("round trip changed the data")
+				}
+
+				if _, err := codec.Decompress(compressed[len(prefix):], len(input.data)+1); err == nil {
+					t.Error("decompressing to a longer length succeeded")
+				}
+			})
+		}
+	}
+}
+
+func TestCodecByName(t *testing.T) {
+	for _, codec := range codecs {
+		got, err := CodecByName(codec.Name())
+		if err != nil || got != codec {
+			t.Errorf("CodecByName(%q) = %v, %v", codec.Name(), got, err)
+		}
+		if got, ok := codecByID(codec.ID()); !ok || got != codec {
+			t.Errorf("codecByID(%d) = %v, %t", codec.ID(), got, ok)
+		}
+	}
+	if _, err := CodecByName("snappy"); err == nil {
+		t.Error("CodecByName accepted an unknown codec")
+	}
+}
@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"nano-elastic/internal/types"
+)
+
+// Doc index sidecar file: [magic "NSIX"][version:uint16][crc:uint32][index]
+// The index payload is [segment version:uint16][data size:int64][doc count:uint32]
+// [sequence:uint64][count:uint32], then per document [len:uint16][id][offset:int64]
+// in offset order
+// Keeping the index out of the data file means records are only ever appended,
+// so a crash can't take the index and the records it points to down together
+const (
+	DocIndexMagic   = "NSIX"
+	DocIndexVersion = 1
+)
+
+// docIndexHeaderSize is the size of the magic, version and checksum prefix
+const docIndexHeaderSize = 4 + 2 + 4
+
+// indexPath returns the path of the doc index sidecar file
+func (s *SegmentReader) indexPath() string {
+	return strings.TrimSuffix(s.Path, ".dat") + ".idx"
+}
+
+// loadIndex reads the doc index and sets s.Size to the end of the records
+// The index comes from the sidecar, plus any plain records appended after it
+// was written; segments from before the sidecar keep it at the end of the data
+// file, and without either it is rebuilt by scanning the records
+// An index that didn't come from the sidecar alone is written to it by the
+// next Flush
+// Caller must hold s.mu
+func (s *SegmentReader) loadIndex(header *SegmentHeader, fileSize int64) error {
+	inFile := header.IndexOffset > 0 && header.IndexOffset < fileSize
+
+	found, err := s.readIndexFile(fileSize)
+	if err != nil {
+		return err
+	}
+	switch {
+	case found && (inFile || s.blocks != nil):
+		return nil
+	case found:
+		count := s.DocCount
+		err := s.scanRecords(s.Size, fileSize)
+		s.idxDirty = s.idxDirty || s.DocCount > count
+		return err
+	case inFile:
+		s.Size = header.IndexOffset
+		s.idxDirty = true
+		return s.readIndexAt(header.IndexOffset)
+	}
+
+	// No usable index: rebuild it from the records
+	s.idxDirty = true
+	s.docIndex = make(map[string]int64)
+	s.DocCount = 0
+	if s.blocks == nil {
+		return s.scanRecords(segmentHeaderSize, fileSize)
+	}
+	return s.forEachRecord(func(offset int64, record []byte) error {
+		doc, err := decodeRecord(s.Path, offset, record[8:], binary.LittleEndian.Uint32(record[4:8]))
+		if err != nil {
+			return err
+		}
+		s.docIndex[doc.ID] = offset
+		s.DocCount++
+		return nil
+	})
+}
+
+// readIndexFile loads the doc index sidecar
+// Returns false, to rebuild the index, if it is missing, damaged or describes
+// another version of the data file (e.g. one replaced by a crash mid-rewrite)
+// Caller must hold s.mu
+func (s *SegmentReader) readIndexFile(fileSize int64) (bool, error) {
+	data, stale, err := s.readSidecar(s.indexPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to read doc index: %w", err)
+	}
+
+	if len(data) < docIndexHeaderSize || string(data[0:4]) != DocIndexMagic {
+		return false, nil
+	}
+	if version := binary.LittleEndian.Uint16(data[4:6]); version != DocIndexVersion {
+		return false, fmt.Errorf("unsupported doc index version %d (expected %d)", version, DocIndexVersion)
+	}
+	payload := data[docIndexHeaderSize:]
+	if checksum(payload) != binary.LittleEndian.Uint32(data[6:10]) {
+		return false, nil
+	}
+
+	r := bytes.NewReader(payload)
+	var fixed struct {
+		SegmentVersion uint16
+		DataSize       int64
+		DocCount       uint32
+		Sequence       uint64
+		Count          uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &fixed); err != nil {
+		return false, nil
+	}
+	if int(fixed.SegmentVersion) != s.Version || fixed.DataSize > fileSize || (s.blocks != nil && fixed.DataSize != s.Size) {
+		return false, nil
+	}
+
+	docIndex := make(map[string]int64, fixed.Count)
+	for i := uint32(0); i < fixed.Count; i++ {
+		var idLen uint16
+		if err := binary.Read(r, binary.LittleEndian, &idLen); err != nil {
+			return false, nil
+		}
+		id := make([]byte, idLen)
+		if _, err := io.ReadFull(r, id); err != nil {
+			return false, nil
+		}
+		var offset int64
+		if err := binary.Read(r, binary.LittleEndian, &offset); err != nil {
+			return false, nil
+		}
+		docIndex[string(id)] = offset
+	}
+
+	s.docIndex = docIndex
+	s.Size = fixed.DataSize
+	s.DocCount = int(fixed.DocCount)
+	s.Sequence = fixed.Sequence
+	s.idxDirty = stale
+	return true, nil
+}
+
+// scanRecords indexes the plain records from offset to the end of the file
+// A torn last record (see isTornTail) ends the scan, leaving it past s.Size;
+// any other unreadable record is corruption
+// Caller must hold s.mu
+func (s *SegmentReader) scanRecords(offset int64, fileSize int64) error {
+	for offset < fileSize {
+		doc, n, err := s.scanRecord(offset)
+		if err != nil {
+			var corruption *CorruptionError
+			if !errors.As(err, &corruption) {
+				return err
+			}
+			torn, tornErr := isTornTail(s.file, offset)
+			if tornErr != nil {
+				return tornErr
+			}
+			if !torn {
+				return err
+			}
+			break
+		}
+		s.docIndex[doc.ID] = offset
+		s.DocCount++
+		offset += n
+	}
+	s.Size = offset
+	return nil
+}
+
+// scanRecord reads the plain record at offset, returning its document and size
+// Caller must hold s.mu
+func (s *SegmentReader) scanRecord(offset int64) (*types.Document, int64, error) {
+	var prefix [8]byte
+	if _, err := s.file.ReadAt(prefix[:], offset); err != nil {
+		return nil, 0, &CorruptionError{Path: s.Path, Offset: offset, Reason: "truncated record header"}
+	}
+	docBytes := make([]byte, binary.LittleEndian.Uint32(prefix[0:4]))
+	if _, err := s.file.ReadAt(docBytes, offset+int64(len(prefix))); err != nil {
+		return nil, 0, &CorruptionError{Path: s.Path, Offset: offset, Reason: "truncated record data"}
+	}
+	doc, err := decodeRecord(s.Path, offset, docBytes, binary.LittleEndian.Uint32(prefix[4:8]))
+	if err != nil {
+		return nil, 0, err
+	}
+	return doc, int64(len(prefix) + len(docBytes)), nil
+}
+
+// writeIndex persists the doc index atomically (write temp file, then rename)
+// The data file is left alone: bytes after the records (a doc index from
+// before the sidecar, or a torn record) are outside s.Size and never read
+// Caller must hold s.mu
+func (s *SegmentReader) writeIndex() error {
+	if !s.idxDirty {
+		return nil
+	}
+
+	data, err := s.sealSidecar(s.indexPath(), s.encodeIndex())
+	if err != nil {
+		return err
+	}
+	tmpPath := s.indexPath() + ".tmp"
+	if err := s.fs.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write doc index: %w", err)
+	}
+	if err := s.fs.Rename(tmpPath, s.indexPath()); err != nil {
+		return fmt.Errorf("failed to commit doc index: %w", err)
+	}
+
+	s.idxDirty = false
+	return nil
+}
+
+// encodeIndex returns the contents of the doc index sidecar file
+// Caller must hold s.mu
+func (s *SegmentReader) encodeIndex() []byte {
+	ids := make([]string, 0, len(s.docIndex))
+	for id := range s.docIndex {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return s.docIndex[ids[i]] < s.docIndex[ids[j]]
+	})
+
+	buf := binary.LittleEndian.AppendUint16(make([]byte, docIndexHeaderSize), uint16(s.Version))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(s.Size))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(s.DocCount))
+	buf = binary.LittleEndian.AppendUint64(buf, s.Sequence)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(ids)))
+	for _, id := range ids {
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(id)))
+		buf = append(buf, id...)
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(s.docIndex[id]))
+	}
+	copy(buf[0:4], DocIndexMagic)
+	binary.LittleEndian.PutUint16(buf[4:6], DocIndexVersion)
+	binary.LittleEndian.PutUint32(buf[6:10], checksum(buf[docIndexHeaderSize:]))
+	return buf
+}
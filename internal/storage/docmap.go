@@ -0,0 +1,51 @@
+package storage
+
+// docMap locates the segment holding each live document, so point lookups
+// don't probe every segment and misses cost a single map lookup
+// A document in several segments (left by a crash between a flush and its
+// tombstones) maps to the one with the later sequence (see addLoaded)
+type docMap map[string]*SegmentReader
+
+// addSegment maps the segment's live documents to it
+func (m docMap) addSegment(seg *SegmentReader) {
+	for _, id := range seg.GetAllDocIDs() {
+		m[id] = seg
+	}
+}
+
+// addLoaded maps the live documents of a segment loaded on open
+// Of two copies of a document, the one in the segment with the lower sequence
+// is superseded and tombstoned, and its segment added to dirty; with unknown
+// sequences the document maps to the segment added last
+func (m docMap) addLoaded(seg *SegmentReader, dirty map[*SegmentReader]bool) {
+	for _, id := range seg.GetAllDocIDs() {
+		old, ok := m[id]
+		switch {
+		case ok && seg.Sequence < old.Sequence:
+			if seg.Delete(id) {
+				dirty[seg] = true
+			}
+			continue
+		case ok && old.Sequence < seg.Sequence:
+			if old.Delete(id) {
+				dirty[old] = true
+			}
+		}
+		m[id] = seg
+	}
+}
+
+// remap moves documents mapped to one of the sources to target, e.g. after a
+// merge; a nil target unmaps them
+func (m docMap) remap(ids []string, sources map[*SegmentReader]bool, target *SegmentReader) {
+	for _, id := range ids {
+		if !sources[m[id]] {
+			continue
+		}
+		if target == nil {
+			delete(m, id)
+		} else {
+			m[id] = target
+		}
+	}
+}
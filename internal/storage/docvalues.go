@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+
+	"nano-elastic/internal/index/docvalues"
+	"nano-elastic/internal/types"
+)
+
+// Doc values sidecar file: [magic "NSDV"][version:uint16][crc:uint32][columns]
+// The columns payload is encoded by docvalues.Columns.Encode
+const (
+	DocValuesMagic   = "NSDV"
+	DocValuesVersion = 1
+)
+
+// docValuesHeaderSize is the size of the magic, version and checksum prefix
+const docValuesHeaderSize = 4 + 2 + 4
+
+// docValuesPath returns the path of the doc values sidecar file
+func (s *SegmentReader) docValuesPath() string {
+	return strings.TrimSuffix(s.Path, ".dat") + ".dv"
+}
+
+// setDocValues replaces a document's doc values in this segment
+// Caller must hold s.mu
+func (s *SegmentReader) setDocValues(doc *types.Document) {
+	if s.docValues == nil {
+		s.docValues = make(docvalues.Columns)
+	}
+	s.docValues.RemoveDocument(doc.ID)
+	for name, fieldValue := range doc.Flatten().Fields { // Object subfields by dot path
+		if value, ok := docvalues.FromFieldValue(fieldValue); ok {
+			s.docValues.Set(doc.ID, name, value)
+		}
+	}
+	s.dvDirty = true
+}
+
+// restoreUnstored sets a document's unstored fields (see types.WithStored)
+// from the segment's doc values, e.g. before it is copied to a merged segment
+func (s *SegmentReader) restoreUnstored(doc *types.Document) {
+	if s.schema == nil {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	values := make(map[string]types.FieldValue)
+	for name, column := range s.docValues {
+		if value, ok := column[doc.ID]; ok {
+			values[name] = value.FieldValue()
+		}
+	}
+	s.schema.RestoreUnstored(doc, values)
+}
+
+// DocValues returns the doc values of the segment's live documents
+func (s *SegmentReader) DocValues() docvalues.Columns {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	live := make(docvalues.Columns, len(s.docValues))
+	for name, column := range s.docValues {
+		for id, value := range column {
+			if _, ok := s.docIndex[id]; ok && !s.deleted[id] {
+				live.Set(id, name, value)
+			}
+		}
+	}
+	return live
+}
+
+// readDocValues loads the doc values sidecar
+// Segments written before doc values existed (or whose sidecar was lost in a crash)
+// are rebuilt from their document records and rewritten on the next flush
+// Caller must hold s.mu
+func (s *SegmentReader) readDocValues() error {
+	s.docValues = make(docvalues.Columns)
+
+	data, stale, err := s.readSidecar(s.docValuesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s.rebuildDocValues()
+		}
+		return fmt.Errorf("failed to read doc values: %w", err)
+	}
+
+	if len(data) < docValuesHeaderSize || string(data[0:4]) != DocValuesMagic {
+		return &CorruptionError{Path: s.docValuesPath(), Offset: 0, Reason: "invalid doc values header"}
+	}
+	if version := binary.LittleEndian.Uint16(data[4:6]); version != DocValuesVersion {
+		return fmt.Errorf("unsupported doc values version %d (expected %d)", version, DocValuesVersion)
+	}
+	payload := data[docValuesHeaderSize:]
+	if err := verifyChecksum(s.docValuesPath(), 0, payload, binary.LittleEndian.Uint32(data[6:10])); err != nil {
+		return err
+	}
+
+	columns, err := docvalues.DecodeColumns(payload)
+	if err != nil {
+		return &CorruptionError{Path: s.docValuesPath(), Offset: docValuesHeaderSize, Reason: err.Error()}
+	}
+	s.docValues = columns
+	s.dvDirty = stale
+	return nil
+}
+
+// rebuildDocValues recomputes doc values by reading every document record
+// Caller must hold s.mu
+func (s *SegmentReader) rebuildDocValues() error {
+	for _, offset := range s.docIndex {
+		doc, err := s.readRecord(offset)
+		if err != nil {
+			return fmt.Errorf("failed to rebuild doc values: %w", err)
+		}
+		s.setDocValues(doc)
+	}
+	return nil
+}
+
+// writeDocValues persists doc values atomically (write temp file, then rename)
+// Caller must hold s.mu
+func (s *SegmentReader) writeDocValues() error {
+	if !s.dvDirty {
+		return nil
+	}
+
+	payload := s.docValues.Encode()
+	data := make([]byte, docValuesHeaderSize, docValuesHeaderSize+len(payload))
+	copy(data[0:4], DocValuesMagic)
+	binary.LittleEndian.PutUint16(data[4:6], DocValuesVersion)
+	binary.LittleEndian.PutUint32(data[6:10], checksum(payload))
+	data, err := s.sealSidecar(s.docValuesPath(), append(data, payload...))
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.docValuesPath() + ".tmp"
+	if err := s.fs.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write doc values: %w", err)
+	}
+	if err := s.fs.Rename(tmpPath, s.docValuesPath()); err != nil {
+		return fmt.Errorf("failed to commit doc values: %w", err)
+	}
+
+	s.dvDirty = false
+	return nil
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"nano-elastic/internal/logging"
+)
+
+// Durability decides when WAL entries are synced to disk, trading the writes
+// a crash can lose for write throughput
+type Durability int
+
+const (
+	// DurabilityWrite syncs before each write returns; concurrent writers
+	// waiting for a sync share a single fsync (group commit)
+	DurabilityWrite Durability = iota
+	// DurabilityInterval syncs in the background every sync interval, so a
+	// crash loses at most one interval of acknowledged writes
+	DurabilityInterval
+	// DurabilityFlush only syncs when memtables are flushed and on close, so a
+	// crash loses the acknowledged writes since the last flush
+	DurabilityFlush
+)
+
+// DefaultDurability is the durability used when an index doesn't configure one
+const DefaultDurability = DurabilityWrite
+
+// DefaultSyncInterval is how often DurabilityInterval syncs the WAL
+const DefaultSyncInterval = time.Second
+
+var durabilityNames = []string{
+	DurabilityWrite:    "write",
+	DurabilityInterval: "interval",
+	DurabilityFlush:    "flush",
+}
+
+// String returns the durability's configuration name
+func (d Durability) String() string {
+	if d < 0 || int(d) >= len(durabilityNames) {
+		return fmt.Sprintf("Durability(%d)", int(d))
+	}
+	return durabilityNames[d]
+}
+
+// DurabilityByName returns the durability with the given configuration name
+func DurabilityByName(name string) (Durability, error) {
+	for d, durabilityName := range durabilityNames {
+		if durabilityName == name {
+			return Durability(d), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown durability %q (supported: %s)", name, strings.Join(durabilityNames, ", "))
+}
+
+// WALOption is a function that configures a WAL
+type WALOption func(*WAL)
+
+// WithWALDurability sets when the WAL syncs its entries to disk
+func WithWALDurability(durability Durability, syncInterval time.Duration) WALOption {
+	return func(w *WAL) {
+		w.durability = durability
+		w.syncInterval = syncInterval
+	}
+}
+
+// WithWALLogger sets the logger recording recoveries of the log, such as torn
+// entries discarded on open
+func WithWALLogger(logger logging.Logger) WALOption {
+	return func(w *WAL) {
+		w.logger = logger
+	}
+}
+
+// syncTo waits until the entries up to seq are on disk
+// Writers that arrive while a sync runs queue on syncMu; the first of them
+// syncs everything appended so far, and the rest find their entries covered
+func (w *WAL) syncTo(seq uint64) error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
+	if w.synced >= seq {
+		return nil
+	}
+
+	w.mu.Lock()
+	file, target := w.file, w.sequence
+	w.mu.Unlock()
+	if file == nil {
+		return fmt.Errorf("WAL is closed")
+	}
+
+	if err := w.syncFile(file); err != nil {
+		return fmt.Errorf("failed to sync WAL: %w", err)
+	}
+	w.synced = target
+	return nil
+}
+
+// syncFile fsyncs a WAL file, counting the sync and its duration
+func (w *WAL) syncFile(file File) error {
+	start := time.Now()
+	err := file.Sync()
+	w.syncs.Add(1)
+	w.syncNanos.Add(int64(time.Since(start)))
+	return err
+}
+
+// syncer syncs a WAL in the background for DurabilityInterval
+type syncer struct {
+	wal      *WAL
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newSyncer(wal *WAL, interval time.Duration) *syncer {
+	return &syncer{
+		wal:      wal,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (s *syncer) start() {
+	go s.run()
+}
+
+// close stops the syncer and waits for an in-flight sync to finish
+func (s *syncer) close() {
+	s.once.Do(func() {
+		close(s.stop)
+		<-s.done
+	})
+}
+
+func (s *syncer) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			// A failed sync is retried on the next tick, and reported by Flush and Close
+			s.wal.Flush()
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// KeyProvider supplies the AES keys encrypting an index's segment blocks and
+// WAL entries (see WithEncryption)
+// Every key has an ID, stored with what it encrypts; new data is encrypted
+// with the current key, and the others are kept to read data written before a
+// rotation until it is re-encrypted (see IndexManager.Reencrypt)
+// Keys are 16, 24 or 32 bytes long, for AES-128, AES-192 or AES-256
+type KeyProvider interface {
+	CurrentKey() (id uint32, key []byte, err error)
+	Key(id uint32) ([]byte, error)
+}
+
+// ErrKeyNotFound is returned by a KeyProvider without the key data was
+// encrypted with
+var ErrKeyNotFound = errors.New("encryption key not found")
+
+// KeyRing is a KeyProvider holding its keys in memory
+type KeyRing struct {
+	mu      sync.RWMutex
+	keys    map[uint32][]byte
+	current uint32
+}
+
+// NewKeyRing creates an empty key ring; the first key added becomes current
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: make(map[uint32][]byte)}
+}
+
+// Add adds a key for reading data encrypted with it
+// An ID can't be reused for a different key, which would make the data
+// encrypted with the first one unreadable
+func (r *KeyRing) Add(id uint32, key []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return fmt.Errorf("invalid encryption key %d: %w", id, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.keys[id]; ok && !bytes.Equal(existing, key) {
+		return fmt.Errorf("encryption key %d is already in use for a different key", id)
+	}
+	if len(r.keys) == 0 {
+		r.current = id
+	}
+	r.keys[id] = append([]byte(nil), key...)
+	return nil
+}
+
+// Rotate adds a key and makes it the current one, so data written from now on
+// is encrypted with it
+func (r *KeyRing) Rotate(id uint32, key []byte) error {
+	if err := r.Add(id, key); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.current = id
+	r.mu.Unlock()
+	return nil
+}
+
+// CurrentKey returns the key new data is encrypted with
+func (r *KeyRing) CurrentKey() (uint32, []byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, ok := r.keys[r.current]
+	if !ok {
+		return 0, nil, fmt.Errorf("%w: the key ring is empty", ErrKeyNotFound)
+	}
+	return r.current, key, nil
+}
+
+// Key returns the key with the given ID
+func (r *KeyRing) Key(id uint32) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrKeyNotFound, id)
+	}
+	return key, nil
+}
+
+// ReadKeyFile reads a key ring from a file with one key per line: its ID and
+// the key in hex, e.g. "2 000102...1f"; blank lines and lines starting with #
+// are skipped
+// The key with the highest ID is current, so a key is rotated by appending a
+// line with a higher ID
+func ReadKeyFile(path string) (*KeyRing, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	ring := NewKeyRing()
+	var current uint32
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a key ID and a hex key", path, n)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid key ID %q", path, n, fields[0])
+		}
+		key, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid hex key: %w", path, n, err)
+		}
+		if err := ring.Add(uint32(id), key); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		current = max(current, uint32(id))
+	}
+	if len(ring.keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", path)
+	}
+	ring.current = current
+	return ring, nil
+}
+
+// WithEncryption encrypts the index's segment blocks, segment sidecars and
+// WAL entries with AES-GCM using keys
+// The manifest and the schema are not encrypted
+// Segments written before encryption was enabled or under an older key are
+// re-encrypted by background merges, or by Reencrypt
+func WithEncryption(keys KeyProvider) IndexOption {
+	return func(im *IndexManager) {
+		im.encryptor = newEncryptor(keys)
+	}
+}
+
+// WithWALEncryption encrypts the WAL's entries with AES-GCM using keys
+func WithWALEncryption(keys KeyProvider) WALOption {
+	return func(w *WAL) {
+		w.encryptor = newEncryptor(keys)
+	}
+}
+
+// Sealed format: [keyID:u32][nonce:12][ciphertext][tag:16]
+const (
+	sealedKeyIDSize = 4
+	sealedNonceSize = 12
+	sealedOverhead  = sealedKeyIDSize + sealedNonceSize + 16
+)
+
+// encryptor seals and opens data with AES-GCM under a KeyProvider's keys
+type encryptor struct {
+	keys  KeyProvider
+	mu    sync.Mutex
+	aeads map[uint32]cipher.AEAD // By key ID; an ID always names the same key
+}
+
+func newEncryptor(keys KeyProvider) *encryptor {
+	return &encryptor{keys: keys, aeads: make(map[uint32]cipher.AEAD)}
+}
+
+// aead returns the cipher of a key, looking the key up if key is nil
+func (e *encryptor) aead(id uint32, key []byte) (cipher.AEAD, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if aead, ok := e.aeads[id]; ok {
+		return aead, nil
+	}
+
+	if key == nil {
+		var err error
+		if key, err = e.keys.Key(id); err != nil {
+			return nil, err
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key %d: %w", id, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	e.aeads[id] = aead
+	return aead, nil
+}
+
+// currentKeyID returns the ID of the key new data is sealed with
+func (e *encryptor) currentKeyID() (uint32, error) {
+	id, _, err := e.keys.CurrentKey()
+	return id, err
+}
+
+// seal appends plaintext, encrypted with the current key, to dst
+// additional is authenticated but not encrypted, and must be given to open
+func (e *encryptor) seal(dst, plaintext, additional []byte) ([]byte, error) {
+	id, key, err := e.keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := e.aead(id, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var prefix [sealedKeyIDSize + sealedNonceSize]byte
+	binary.LittleEndian.PutUint32(prefix[:sealedKeyIDSize], id)
+	if _, err := rand.Read(prefix[sealedKeyIDSize:]); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	dst = append(dst, prefix[:]...)
+	return aead.Seal(dst, prefix[sealedKeyIDSize:], plaintext, additional), nil
+}
+
+// open decrypts sealed data, with whichever key it names
+func (e *encryptor) open(sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < sealedOverhead {
+		return nil, fmt.Errorf("encrypted data too short (%d bytes)", len(sealed))
+	}
+	aead, err := e.aead(sealedKeyID(sealed), nil)
+	if err != nil {
+		return nil, err
+	}
+	nonce := sealed[sealedKeyIDSize : sealedKeyIDSize+sealedNonceSize]
+	plaintext, err := aead.Open(nil, nonce, sealed[sealedKeyIDSize+sealedNonceSize:], additional)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt with key %d: %w", sealedKeyID(sealed), err)
+	}
+	return plaintext, nil
+}
+
+// sealedKeyID returns the ID of the key sealed data was encrypted with
+func sealedKeyID(sealed []byte) uint32 {
+	return binary.LittleEndian.Uint32(sealed[:sealedKeyIDSize])
+}
+
+// Encrypted sidecar file: [magic "NSEC"][sealed contents]
+// With encryption a segment's sidecars (doc index, doc values, norms,
+// sketches, postings and tombstones) are sealed whole, bound to the segment
+// ID and the sidecar's extension so one can't be swapped for another;
+// sidecars written before encryption was enabled stay readable, and are
+// sealed when next written
+const EncryptedSidecarMagic = "NSEC"
+
+// sidecarAAD returns the additional data a segment's sidecar is sealed with
+func (s *SegmentReader) sidecarAAD(path string) []byte {
+	return []byte(s.ID + filepath.Ext(path))
+}
+
+// sealSidecar returns the contents of a sidecar file: data sealed with the
+// current key if the segment is encrypted, data itself otherwise
+func (s *SegmentReader) sealSidecar(path string, data []byte) ([]byte, error) {
+	if s.encryptor == nil {
+		return data, nil
+	}
+	sealed, err := s.encryptor.seal([]byte(EncryptedSidecarMagic), data, s.sidecarAAD(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt %s: %w", filepath.Base(path), err)
+	}
+	return sealed, nil
+}
+
+// readSidecar reads a sidecar file, decrypting it if it is sealed
+// stale reports a sidecar that isn't sealed with the current key although the
+// segment is encrypted, so it should be written again; errors from reading
+// the file are returned as is, for os.IsNotExist
+func (s *SegmentReader) readSidecar(path string) (data []byte, stale bool, err error) {
+	data, err = s.fs.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if !isSealedSidecar(data) {
+		return data, s.encryptor != nil, nil
+	}
+	if s.encryptor == nil {
+		return nil, false, fmt.Errorf("%s is encrypted and no encryption keys are configured", path)
+	}
+	sealed := data[len(EncryptedSidecarMagic):]
+	plain, err := s.encryptor.open(sealed, s.sidecarAAD(path))
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", path, err)
+	}
+	current, err := s.encryptor.currentKeyID()
+	if err != nil {
+		return nil, false, err
+	}
+	return plain, sealedKeyID(sealed) != current, nil
+}
+
+// isSealedSidecar reports whether a sidecar file's contents are encrypted
+func isSealedSidecar(data []byte) bool {
+	return len(data) >= len(EncryptedSidecarMagic) && string(data[:len(EncryptedSidecarMagic)]) == EncryptedSidecarMagic
+}
@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"nano-elastic/internal/types"
+)
+
+// flusher writes full memtables to segments in a background goroutine
+type flusher struct {
+	im      *IndexManager
+	trigger chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newFlusher(im *IndexManager) *flusher {
+	return &flusher{
+		im:      im,
+		trigger: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (f *flusher) start() {
+	go f.run()
+}
+
+// wake asks the flusher to flush the immutable memtables
+func (f *flusher) wake() {
+	select {
+	case f.trigger <- struct{}{}:
+	default:
+		// A flush is already pending
+	}
+}
+
+// close stops the flusher and waits for an in-flight flush to finish
+func (f *flusher) close() {
+	f.once.Do(func() {
+		close(f.stop)
+		<-f.done
+	})
+}
+
+func (f *flusher) run() {
+	defer close(f.done)
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-f.trigger:
+		}
+
+		// Errors leave the memtable queued and readable; the next wake or a
+		// stalled writer retries, and writers stalling report the error
+		for {
+			flushed, err := f.im.flushOldest()
+			if err != nil {
+				f.im.logger.Error("background flush failed", "error", err)
+			}
+			if err != nil || !flushed {
+				break
+			}
+		}
+	}
+}
+
+// Flush writes every buffered write to segments, waiting for the flush to finish
+func (im *IndexManager) Flush() error {
+	im.mu.Lock()
+	im.rotateMemtable()
+	im.mu.Unlock()
+
+	for {
+		flushed, err := im.flushOldest()
+		if err != nil {
+			return err
+		}
+		if !flushed {
+			return nil
+		}
+	}
+}
+
+// memtableFull reports whether a memtable has reached a segment threshold
+func (im *IndexManager) memtableFull(mt *memtable) bool {
+	count, size := mt.stats()
+	if im.maxSegmentDocs > 0 && count >= im.maxSegmentDocs {
+		return true
+	}
+	if im.maxSegmentBytes > 0 && size >= im.maxSegmentBytes {
+		return true
+	}
+	return false
+}
+
+// rotateMemtable queues the active memtable for flushing and starts a new one
+// Caller must hold im.mu for writing
+func (im *IndexManager) rotateMemtable() {
+	if count, _ := im.memtable.stats(); count == 0 {
+		return
+	}
+	im.immutable = append(im.immutable, im.memtable)
+	im.memtable = newMemtable()
+}
+
+// rotateIfFull rotates a full memtable and hands it to the flusher
+// Writers stall, flushing a memtable themselves, while the flusher is behind
+func (im *IndexManager) rotateIfFull() error {
+	im.mu.Lock()
+	if im.memtableFull(im.memtable) {
+		im.rotateMemtable()
+	}
+	stalled := len(im.immutable) > DefaultMaxImmutableMemtables
+	im.mu.Unlock()
+
+	im.flusher.wake()
+	if stalled {
+		if _, err := im.flushOldest(); err != nil {
+			return fmt.Errorf("failed to flush memtable: %w", err)
+		}
+	}
+	return nil
+}
+
+// flushOldest writes the oldest immutable memtable to a new sealed segment
+// Memtables are flushed in order, so a flushed write only ever supersedes
+// copies in segments that are already in the list
+// Returns false if there was nothing to flush
+func (im *IndexManager) flushOldest() (bool, error) {
+	im.flushMu.Lock()
+	defer im.flushMu.Unlock()
+
+	im.mu.RLock()
+	if len(im.immutable) == 0 {
+		im.mu.RUnlock()
+		return false, nil
+	}
+	mt := im.immutable[0]
+	im.mu.RUnlock()
+	start := time.Now()
+
+	// The segment is written without im.mu; readers keep finding the
+	// documents in the memtable until it is swapped for the segment
+	docs, err := mt.documents()
+	if err != nil {
+		return false, fmt.Errorf("failed to decode memtable: %w", err)
+	}
+	var seg *SegmentReader
+	if len(docs) > 0 {
+		im.mu.Lock()
+		w, err := im.createSegment()
+		im.mu.Unlock()
+		if err != nil {
+			return false, fmt.Errorf("failed to create segment: %w", err)
+		}
+		w.MinSequence, w.Sequence = mt.sequenceRange()
+		if seg, err = im.writeSegment(w, docs); err != nil {
+			w.Remove()
+			return false, err
+		}
+	}
+
+	// With DurabilityFlush this is when acknowledged writes reach the disk;
+	// with the other policies little or nothing is left to sync
+	if err := im.wal.Flush(); err != nil {
+		return false, err
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	// The flushed writes and deletes supersede copies in older segments
+	dirty := make(map[*SegmentReader]bool)
+	for id := range mt.snapshot() {
+		for _, old := range im.segments {
+			if old.Delete(id) {
+				dirty[old] = true
+			}
+		}
+		delete(im.docs, id)
+	}
+	if seg != nil {
+		im.segments = append(im.segments, seg)
+		im.docs.addSegment(seg)
+	}
+	im.immutable = im.immutable[1:]
+	im.counters.flushTotal.Add(1)
+	im.counters.flushNanos.Add(int64(time.Since(start)))
+	if seg != nil {
+		im.logger.Info("flushed memtable", "segment", seg.ID, "docs", len(docs), "bytes", seg.Size, "sequence", seg.Sequence, "took", time.Since(start))
+	} else {
+		deletes, _ := mt.stats()
+		im.logger.Debug("flushed memtable of deletes", "deletes", deletes, "took", time.Since(start))
+	}
+
+	for old := range dirty {
+		if err := old.Flush(); err != nil {
+			return true, fmt.Errorf("failed to flush tombstones: %w", err)
+		}
+	}
+
+	// The manifest lists the segment before the checkpoint skips its writes
+	if err := im.writeManifest(); err != nil {
+		return true, err
+	}
+
+	// Later opens only replay the WAL entries still buffered, so the files
+	// before them can go
+	flushed := im.flushedSequence()
+	if err := writeCheckpoint(im.fs, im.BasePath, flushed); err != nil {
+		return true, err
+	}
+	if err := im.wal.RemoveThrough(flushed); err != nil {
+		return true, err
+	}
+
+	// A new segment may have completed a merge tier
+	if seg != nil && im.merger != nil {
+		im.merger.Trigger()
+	}
+	return true, nil
+}
+
+// writeSegment writes documents to a new segment and seals it
+func (im *IndexManager) writeSegment(w *SegmentWriter, docs []*types.Document) (*SegmentReader, error) {
+	if err := w.WriteDocuments(docs); err != nil {
+		return nil, fmt.Errorf("failed to write segment %s: %w", w.ID, err)
+	}
+	return w.Seal(im.codec)
+}
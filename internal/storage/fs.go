@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"io"
+	"os"
+)
+
+// FS is the file system an index's files live on: segments, sidecars, the
+// WAL, manifests, the checkpoint and the schema
+// OSFS keeps them on disk; a MemFS (see NewMemFS) keeps them in memory, e.g.
+// for tests and ephemeral indexes
+// Errors follow package os, so os.IsNotExist and os.IsExist apply to them
+type FS interface {
+	Open(name string) (File, error) // Read-only
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	Rename(oldpath string, newpath string) error
+	Remove(name string) error
+	RemoveAll(path string) error
+	MkdirAll(path string, perm os.FileMode) error
+	ReadDir(name string) ([]os.DirEntry, error) // Sorted by name
+	Stat(name string) (os.FileInfo, error)
+}
+
+// File is an open file of an FS
+// Like an *os.File, it stays readable after it is renamed or removed
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Seeker
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// OSFS is the operating system's file system, the default
+var OSFS FS = osFS{}
+
+// osFS implements FS with package os
+type osFS struct{}
+
+func (osFS) Open(name string) (File, error) {
+	return openOSFile(os.Open(name))
+}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return openOSFile(os.OpenFile(name, flag, perm))
+}
+
+// openOSFile returns an opened *os.File as a File, keeping a failed open's
+// result a nil interface
+func openOSFile(file *os.File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (osFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (osFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+func (osFS) Rename(oldpath string, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (osFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFS) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// WithFS sets the file system the index's files live on (default OSFS)
+func WithFS(fs FS) IndexOption {
+	return func(im *IndexManager) {
+		im.fs = fs
+	}
+}
+
+// WithWALFS sets the file system the WAL's files live on (default OSFS)
+func WithWALFS(fs FS) WALOption {
+	return func(w *WAL) {
+		w.fs = fs
+	}
+}
@@ -1,12 +1,16 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
 
+	"nano-elastic/internal/errdefs"
+	"nano-elastic/internal/index/docvalues"
+	"nano-elastic/internal/logging"
 	"nano-elastic/internal/types"
 )
 
@@ -15,23 +19,182 @@ type IndexManager struct {
 	Name      string
 	BasePath  string
 	Schema    *types.Schema
-	segments  []*Segment
+	fs        FS // Where the index's files live (see WithFS)
+	segments  []*SegmentReader
+	manifestGen uint64 // Generation of the manifest listing the segments (see writeManifest)
+	docs      docMap // Live document ID -> segment holding it
 	wal       *WAL
 	mu        sync.RWMutex
 	nextSegID int
+
+	// Recent writes, buffered until they are flushed to a new segment
+	memtable  *memtable
+	immutable []*memtable // Full memtables waiting for the flusher, oldest first
+	flusher   *flusher
+	flushMu   sync.Mutex // Serializes flushes so memtables reach segments in order
+
+	// Flush thresholds for the active memtable (0 disables the check)
+	maxSegmentDocs  int
+	maxSegmentBytes int64
+
+	// Compression of sealed segments' stored documents
+	codec Codec
+	
+	// Encryption of segment blocks and WAL entries, nil if disabled (see WithEncryption)
+	encryptor *encryptor
+	
+	// When WAL entries are synced to disk
+	durability   Durability
+	syncInterval time.Duration
+	
+	// Size after which the WAL rotates to a new file
+	maxWALFileBytes int64
+	
+	// Merging of sealed segments
+	mergePolicy   MergePolicy
+	mergeInterval time.Duration
+	merger        *MergeScheduler
+	
+	// Documents merges dropped as expired, if tracked (see WithExpiredTracking)
+	trackExpired bool
+	expired      []string
+	expiredMu    sync.Mutex
+	
+	// Indexing, flush and merge counters reported by Stats
+	counters indexingCounters
+	
+	// Records writes, flushes, merges, recoveries and failures of background work
+	logger logging.Logger
+}
+
+const (
+	DefaultMaxSegmentDocs  = 100000
+	DefaultMaxSegmentBytes = 64 * 1024 * 1024 // 64MB
+)
+
+// IndexOption is a function that configures an IndexManager
+type IndexOption func(*IndexManager)
+
+// WithMaxSegmentDocs sets the document count after which the memtable is flushed to a new segment
+func WithMaxSegmentDocs(n int) IndexOption {
+	return func(im *IndexManager) {
+		im.maxSegmentDocs = n
+	}
+}
+
+// WithMaxSegmentBytes sets the data size after which the memtable is flushed to a new segment
+func WithMaxSegmentBytes(n int64) IndexOption {
+	return func(im *IndexManager) {
+		im.maxSegmentBytes = n
+	}
+}
+
+// WithCodec sets the codec compressing the stored documents of sealed segments
+func WithCodec(codec Codec) IndexOption {
+	return func(im *IndexManager) {
+		im.codec = codec
+	}
+}
+
+// WithDurability sets when WAL entries are synced to disk
+func WithDurability(durability Durability) IndexOption {
+	return func(im *IndexManager) {
+		im.durability = durability
+	}
+}
+
+// WithSyncInterval sets how often the WAL is synced with DurabilityInterval
+func WithSyncInterval(interval time.Duration) IndexOption {
+	return func(im *IndexManager) {
+		im.syncInterval = interval
+	}
+}
+
+// WithMaxWALFileBytes sets the size after which the WAL rotates to a new file
+func WithMaxWALFileBytes(n int64) IndexOption {
+	return func(im *IndexManager) {
+		im.maxWALFileBytes = n
+	}
+}
+
+// WithMergePolicy sets the policy used to pick segments for background merges
+func WithMergePolicy(policy MergePolicy) IndexOption {
+	return func(im *IndexManager) {
+		im.mergePolicy = policy
+	}
+}
+
+// WithMergeInterval sets how often the background merger checks for work
+// An interval of 0 disables background merging; ForceMerge still works
+func WithMergeInterval(interval time.Duration) IndexOption {
+	return func(im *IndexManager) {
+		im.mergeInterval = interval
+	}
+}
+
+// WithLogger sets the logger of the index and its WAL
+// Records carry the index name; the default logger is logging.Default
+func WithLogger(logger logging.Logger) IndexOption {
+	return func(im *IndexManager) {
+		im.logger = logger
+	}
 }
 
 // NewIndexManager creates a new index manager
-func NewIndexManager(name string, basePath string, schema *types.Schema) (*IndexManager, error) {
+func NewIndexManager(name string, basePath string, schema *types.Schema, options ...IndexOption) (*IndexManager, error) {
 	indexPath := filepath.Join(basePath, name)
 	
+	im := &IndexManager{
+		Name:     name,
+		BasePath: indexPath,
+		Schema:   schema,
+		fs:       OSFS,
+		segments: make([]*SegmentReader, 0),
+		docs:     make(docMap),
+		memtable: newMemtable(),
+		maxSegmentDocs:  DefaultMaxSegmentDocs,
+		maxSegmentBytes: DefaultMaxSegmentBytes,
+		codec:           DefaultCodec,
+		durability:      DefaultDurability,
+		syncInterval:    DefaultSyncInterval,
+		maxWALFileBytes: DefaultMaxWALFileBytes,
+		mergePolicy:     NewTieredMergePolicy(),
+		mergeInterval:   DefaultMergeInterval,
+		logger:          logging.Default(),
+	}
+	
+	// Apply options
+	for _, opt := range options {
+		opt(im)
+	}
+	im.logger = im.logger.With("index", name)
+	
 	// Create index directory if it doesn't exist
-	if err := os.MkdirAll(indexPath, 0755); err != nil {
+	if err := im.fs.MkdirAll(indexPath, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create index directory: %w", err)
 	}
 	
+	// Check the schema against the one the index was created with
+	if err := im.syncSchema(); err != nil {
+		return nil, err
+	}
+	
+	// Settings saved with the index override the options
+	if err := im.applySettings(im.Schema.IndexSettings()); err != nil {
+		return nil, err
+	}
+	
 	// Create WAL
-	wal, err := NewWAL(indexPath)
+	walOptions := []WALOption{
+		WithWALFS(im.fs),
+		WithWALDurability(im.durability, im.syncInterval),
+		WithWALMaxFileBytes(im.maxWALFileBytes),
+		WithWALLogger(im.logger),
+	}
+	if im.encryptor != nil {
+		walOptions = append(walOptions, WithWALEncryption(im.encryptor.keys))
+	}
+	wal, err := NewWAL(indexPath, walOptions...)
 	if err != nil {
 		return nil, err
 	}
@@ -39,39 +202,101 @@ func NewIndexManager(name string, basePath string, schema *types.Schema) (*Index
 	if err := wal.Open(); err != nil {
 		return nil, err
 	}
-	
-	im := &IndexManager{
-		Name:     name,
-		BasePath: indexPath,
-		Schema:   schema,
-		segments: make([]*Segment, 0),
-		wal:      wal,
-	}
+	im.wal = wal
 	
 	// Load existing segments
 	if err := im.loadSegments(); err != nil {
 		return nil, err
 	}
 	
-	// If no segments exist, create the first one
-	if len(im.segments) == 0 {
-		seg, err := im.createSegment()
-		if err != nil {
+	// New segments are numbered after the loaded ones, so IDs are never reused
+	for _, seg := range im.segments {
+		if number, ok := segmentNumber(seg.ID); ok {
+			im.nextSegID = max(im.nextSegID, number)
+		}
+	}
+	
+	// Segments are sealed, so reads can be served from their mappings
+	for _, seg := range im.segments {
+		if err := seg.mapRecords(im.codec); err != nil {
 			return nil, err
 		}
-		im.segments = append(im.segments, seg)
+	}
+	
+	// Recover writes that hadn't reached a segment when the index last stopped
+	replayed, err := im.replayWAL()
+	if err != nil {
+		return nil, err
+	}
+	if replayed > 0 {
+		im.logger.Info("recovered unflushed writes from WAL", "entries", replayed, "sequence", wal.Sequence())
+	}
+	
+	// Record the segments as loaded, with any tombstones added since
+	if err := im.writeManifest(); err != nil {
+		return nil, err
+	}
+	
+	// Start background flushing and merging
+	im.flusher = newFlusher(im)
+	im.flusher.start()
+	if len(im.immutable) > 0 {
+		im.flusher.wake()
+	}
+	if im.mergeInterval > 0 {
+		im.merger = NewMergeScheduler(im, im.mergeInterval)
+		im.merger.Start()
 	}
 	
 	return im, nil
 }
 
-// loadSegments loads existing segments from disk
+// loadSegments loads the segments listed by the manifest, removing the files
+// of any others
+// Indexes from before the manifest load every segment file in the directory
 func (im *IndexManager) loadSegments() error {
-	entries, err := os.ReadDir(im.BasePath)
+	manifest, err := readManifest(im.fs, im.BasePath)
 	if err != nil {
 		return err
 	}
+	if manifest == nil {
+		return im.loadSegmentFiles()
+	}
 	
+	im.manifestGen = manifest.Generation
+	dirty := make(map[*SegmentReader]bool)
+	for _, info := range manifest.Segments {
+		seg, err := im.openSegment(info.ID)
+		if err != nil {
+			return fmt.Errorf("failed to open segment %s: %w", info.ID, err)
+		}
+		seg.MinSequence = info.MinSequence
+		
+		im.segments = append(im.segments, seg)
+		im.docs.addLoaded(seg, dirty)
+	}
+	if err := im.removeStaleFiles(manifest); err != nil {
+		return err
+	}
+	
+	for seg := range dirty {
+		if err := seg.Flush(); err != nil {
+			return fmt.Errorf("failed to flush tombstones: %w", err)
+		}
+	}
+	
+	return nil
+}
+
+// loadSegmentFiles loads every segment file in the directory, skipping those
+// that can't be opened
+func (im *IndexManager) loadSegmentFiles() error {
+	entries, err := im.fs.ReadDir(im.BasePath)
+	if err != nil {
+		return err
+	}
+	
+	dirty := make(map[*SegmentReader]bool)
 	for _, entry := range entries {
 		filename := entry.Name()
 		if !entry.IsDir() && filepath.Ext(filename) == ".dat" && len(filename) > 8 && filename[:8] == "segment_" {
@@ -79,72 +304,150 @@ func (im *IndexManager) loadSegments() error {
 			// Extract segment ID from filename
 			segID := filename[8 : len(filename)-4] // Remove "segment_" prefix and ".dat" suffix
 			
-			seg, err := NewSegment(segID, im.BasePath)
+			seg, err := im.openSegment(segID)
 			if err != nil {
-				continue
-			}
-			
-			if err := seg.Open(); err != nil {
+				im.logger.Warn("skipped unreadable segment", "segment", segID, "error", err)
 				continue
 			}
 			
 			im.segments = append(im.segments, seg)
+			im.docs.addLoaded(seg, dirty)
+		}
+	}
+	
+	for seg := range dirty {
+		if err := seg.Flush(); err != nil {
+			return fmt.Errorf("failed to flush tombstones: %w", err)
 		}
 	}
 	
 	return nil
 }
 
-// createSegment creates a new segment
-func (im *IndexManager) createSegment() (*Segment, error) {
-	im.nextSegID++
-	segID := fmt.Sprintf("seg%d", im.nextSegID)
-	
-	seg, err := NewSegment(segID, im.BasePath)
-	if err != nil {
-		return nil, err
+// openSegment opens one of the index's segments, decrypting with its keys
+func (im *IndexManager) openSegment(id string) (*SegmentReader, error) {
+	seg := &SegmentReader{
+		ID:        id,
+		Path:      segmentPath(im.BasePath, id),
+		fs:        im.fs,
+		schema:    im.Schema,
+		encryptor: im.encryptor,
 	}
-	
-	seg.Created = time.Now().Unix()
-	
 	if err := seg.Open(); err != nil {
 		return nil, err
 	}
-	
 	return seg, nil
 }
 
+// segmentNumber returns the number of a segment ID created by createSegment
+func segmentNumber(id string) (int, bool) {
+	var number int
+	if _, err := fmt.Sscanf(id, "seg%d", &number); err != nil || id != segmentID(number) {
+		return 0, false
+	}
+	return number, true
+}
+
+// segmentID returns the ID of the segment with the given number
+func segmentID(number int) string {
+	return fmt.Sprintf("seg%d", number)
+}
+
+// createSegment starts a new segment for a flush or merge to write
+func (im *IndexManager) createSegment() (*SegmentWriter, error) {
+	// Skip IDs still taken on disk, e.g. by a segment that failed to load
+	var segID string
+	for {
+		im.nextSegID++
+		segID = segmentID(im.nextSegID)
+		if _, err := im.fs.Stat(segmentPath(im.BasePath, segID)); os.IsNotExist(err) {
+			break
+		}
+	}
+	
+	w, err := NewSegmentWriter(im.fs, segID, im.BasePath, im.Schema)
+	if err != nil {
+		return nil, err
+	}
+	if im.encryptor != nil {
+		w.encryptBlocks(im.codec, im.encryptor)
+	}
+	return w, nil
+}
+
 // WriteDocument writes a document to the index
+// Writers run concurrently: the document is in the WAL before it is added to
+// the memtable, and the memtable is flushed to a segment in the background
 func (im *IndexManager) WriteDocument(doc *types.Document) error {
-	im.mu.Lock()
-	defer im.mu.Unlock()
+	start := time.Now()
 	
 	// Validate document against schema
 	if err := im.Schema.ValidateDocument(doc); err != nil {
 		return fmt.Errorf("schema validation failed: %w", err)
 	}
 	
+	data, err := doc.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed to encode document: %w", err)
+	}
+	
+	im.mu.RLock()
+	
 	// Write to WAL first (for durability)
-	if err := im.wal.WriteEntry(WALEntryWrite, im.Name, doc.ID, doc); err != nil {
+	entries := []WALEntry{{Type: WALEntryWrite, Index: im.Name, DocID: doc.ID, Document: doc}}
+	if err := im.wal.WriteEntries(entries); err != nil {
+		im.mu.RUnlock()
 		return fmt.Errorf("failed to write to WAL: %w", err)
 	}
+	im.memtable.put(doc.ID, data, entries[0].Sequence)
+	im.counters.recordWrites(1, 0, start)
+	if im.logger.DebugEnabled() {
+		im.logger.Debug("document written", "id", doc.ID, "sequence", entries[0].Sequence)
+	}
+	
+	full := im.memtableFull(im.memtable)
+	im.mu.RUnlock()
 	
-	// Write to current segment
-	if len(im.segments) == 0 {
-		return fmt.Errorf("no segments available")
+	if full {
+		return im.rotateIfFull()
 	}
-	currentSeg := im.segments[len(im.segments)-1]
-	if err := currentSeg.WriteDocument(doc); err != nil {
-		return fmt.Errorf("failed to write to segment: %w", err)
+	return nil
+}
+
+// UpgradeEncoding converts data written before the binary document encoding:
+// each segment created with JSON records is rewritten like a single-segment
+// merge, and the WAL is rewritten in place
+// Returns the number of segments converted
+func (im *IndexManager) UpgradeEncoding() (int, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	
+	var legacy []*SegmentReader
+	for _, seg := range im.segments {
+		if seg.GetEncoding() == RecordEncodingJSON {
+			legacy = append(legacy, seg)
+		}
 	}
 	
-	// Flush index periodically (for now, flush after each write for Phase 1)
-	// In production, we'd batch this
-	if err := currentSeg.Flush(); err != nil {
-		return fmt.Errorf("failed to flush segment: %w", err)
+	converted := 0
+	for _, seg := range legacy {
+		if err := im.mergeSegments(context.Background(), []*SegmentReader{seg}); err != nil {
+			return converted, fmt.Errorf("failed to convert segment %s: %w", seg.ID, err)
+		}
+		converted++
 	}
 	
-	return nil
+	if err := im.wal.Upgrade(); err != nil {
+		return converted, err
+	}
+	return converted, nil
+}
+
+// GetSegmentCount returns the number of segments in the index
+func (im *IndexManager) GetSegmentCount() int {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	return len(im.segments)
 }
 
 // ReadDocument reads a document from the index by ID
@@ -152,49 +455,194 @@ func (im *IndexManager) ReadDocument(id string) (*types.Document, error) {
 	im.mu.RLock()
 	defer im.mu.RUnlock()
 	
-	// Search through segments (newest first)
-	for i := len(im.segments) - 1; i >= 0; i-- {
-		seg := im.segments[i]
-		doc, err := seg.ReadDocument(id)
-		if err == nil {
-			return doc, nil
+	// Buffered writes are newer than anything in the segments
+	if entry, ok := im.lookupMemtables(id); ok {
+		if entry.data == nil {
+			return nil, &errdefs.DocNotFoundError{ID: id}
+		}
+		doc, err := types.DecodeDocument(entry.data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode document %s: %w", id, err)
 		}
-		// Continue to next segment if document not found in this one
+		im.Schema.UpgradeDocument(doc)
+		return im.Schema.StoredDocument(doc), nil // As it reads once flushed
+	}
+	
+	seg, ok := im.docs[id]
+	if !ok {
+		return nil, &errdefs.DocNotFoundError{ID: id}
+	}
+	doc, err := seg.ReadDocument(id)
+	if err != nil {
+		return nil, err
+	}
+	im.Schema.UpgradeDocument(doc)
+	return doc, nil
+}
+
+// DeleteDocument removes a document from the index by ID
+// The delete is buffered in the memtable; flushing it tombstones the document's
+// copies in the segments, which are physically dropped on the next merge
+func (im *IndexManager) DeleteDocument(id string) error {
+	start := time.Now()
+	im.mu.RLock()
+	
+	if !im.exists(id) {
+		im.mu.RUnlock()
+		return &errdefs.DocNotFoundError{ID: id}
 	}
 	
-	return nil, fmt.Errorf("document not found: %s", id)
+	// Write to WAL first (for durability)
+	entries := []WALEntry{{Type: WALEntryDelete, Index: im.Name, DocID: id}}
+	if err := im.wal.WriteEntries(entries); err != nil {
+		im.mu.RUnlock()
+		return fmt.Errorf("failed to write to WAL: %w", err)
+	}
+	im.memtable.put(id, nil, entries[0].Sequence)
+	im.counters.recordWrites(0, 1, start)
+	if im.logger.DebugEnabled() {
+		im.logger.Debug("document deleted", "id", id, "sequence", entries[0].Sequence)
+	}
+	
+	full := im.memtableFull(im.memtable)
+	im.mu.RUnlock()
+	
+	if full {
+		return im.rotateIfFull()
+	}
+	return nil
 }
 
-// GetDocumentCount returns the total number of documents in the index
+// GetDocumentCount returns the total number of live documents in the index
 func (im *IndexManager) GetDocumentCount() int {
 	im.mu.RLock()
 	defer im.mu.RUnlock()
 	
+	// Each live document maps to one segment, even if a crash left copies in several
+	total := len(im.docs)
+	
+	// Buffered writes add new documents and buffered deletes remove flushed ones
+	for id, entry := range im.memView() {
+		if inSegments := im.inSegments(id); entry.data != nil && !inSegments {
+			total++
+		} else if entry.data == nil && inSegments {
+			total--
+		}
+	}
+	
+	return total
+}
+
+// inSegments reports whether a document is live in a segment
+// Caller must hold im.mu
+func (im *IndexManager) inSegments(id string) bool {
+	_, ok := im.docs[id]
+	return ok
+}
+
+// GetDeletedCount returns the number of tombstoned documents awaiting merge
+func (im *IndexManager) GetDeletedCount() int {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	
 	total := 0
 	for _, seg := range im.segments {
-		total += seg.GetDocCount()
+		total += seg.GetDeletedCount()
 	}
 	
 	return total
 }
 
-// Close closes the index manager and all its resources
+// GetAllDocIDs returns the IDs of all live documents in the index
+func (im *IndexManager) GetAllDocIDs() []string {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	
+	// Buffered writes shadow the segments' copies
+	view := im.memView()
+	seen := make(map[string]bool, len(view))
+	var ids []string
+	for id, entry := range view {
+		seen[id] = true
+		if entry.data != nil {
+			ids = append(ids, id)
+		}
+	}
+	for _, seg := range im.segments {
+		for _, id := range seg.GetAllDocIDs() {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	
+	return ids
+}
+
+// LoadDocValues returns the doc values of all live documents, read from the
+// segments' doc values files rather than by decoding stored documents
+// Only the memtables' documents are decoded
+func (im *IndexManager) LoadDocValues() (docvalues.Columns, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	
+	view := im.memView()
+	columns, err := view.docValues()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load memtable doc values: %w", err)
+	}
+	for _, seg := range im.segments {
+		for name, column := range seg.DocValues() {
+			for id, value := range column {
+				if _, buffered := view[id]; !buffered {
+					columns.Set(id, name, value)
+				}
+			}
+		}
+	}
+	
+	return columns, nil
+}
+
+// Close flushes buffered writes and closes the index manager and all its resources
 func (im *IndexManager) Close() error {
+	// Stop the flusher and merger before taking the lock; running work needs it to finish
+	// The flusher goes first, since a flush triggers the merger
+	if im.flusher != nil {
+		im.flusher.close()
+	}
+	if im.merger != nil {
+		im.merger.Stop()
+		im.merger = nil
+	}
+	
+	if err := im.Flush(); err != nil {
+		return fmt.Errorf("failed to flush memtable: %w", err)
+	}
+	
 	im.mu.Lock()
 	defer im.mu.Unlock()
 	
-	// Close all segments
+	// Close every segment and the WAL even if one fails, reporting the first failure
+	var firstErr error
 	for _, seg := range im.segments {
 		if err := seg.Close(); err != nil {
-			return err
+			im.logger.Error("failed to close segment", "segment", seg.ID, "error", err)
+			if firstErr == nil {
+				firstErr = err
+			}
 		}
 	}
 	
 	// Close WAL
 	if err := im.wal.Close(); err != nil {
-		return err
+		im.logger.Error("failed to close WAL", "error", err)
+		if firstErr == nil {
+			firstErr = err
+		}
 	}
 	
-	return nil
+	return firstErr
 }
 
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"nano-elastic/internal/logging"
+	"nano-elastic/internal/types"
+)
+
+func TestSegmentNumber(t *testing.T) {
+	tests := []struct {
+		id     string
+		number int
+		ok     bool
+	}{
+		{"seg1", 1, true},
+		{"seg42", 42, true},
+		{"seg042", 0, false}, // Not an ID segmentID writes
+		{"seg", 0, false},
+		{"seg7a", 0, false},
+		{"segment", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.id, func(t *testing.T) {
+			number, ok := segmentNumber(tc.id)
+			if number != tc.number || ok != tc.ok {
+				t.Errorf("segmentNumber(%q) = %d, %t, want %d, %t", tc.id, number, ok, tc.number, tc.ok)
+			}
+		})
+	}
+}
+
+// segmentNumbers returns the numbers of the index's segments, by ID
+func segmentNumbers(t *testing.T, im *IndexManager) map[string]int {
+	t.Helper()
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	numbers := make(map[string]int, len(im.segments))
+	for _, seg := range im.segments {
+		number, ok := segmentNumber(seg.ID)
+		if !ok {
+			t.Fatalf("segment %s has no number", seg.ID)
+		}
+		numbers[seg.ID] = number
+	}
+	return numbers
+}
+
+func TestSegmentIDsAcrossReopens(t *testing.T) {
+	fs := NewMemFS()
+	open := func() *IndexManager {
+		t.Helper()
+		im, err := NewIndexManager("test", "data", types.NewSchema("test"),
+			WithFS(fs), WithMaxSegmentDocs(2), WithMergeInterval(0), WithLogger(logging.Discard()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return im
+	}
+
+	cycles := []struct {
+		name   string
+		writes int
+		delete bool // Delete the oldest live document
+		merge  bool
+	}{
+		{name: "first writes", writes: 5},
+		{name: "more writes", writes: 3},
+		{name: "writes and a delete", writes: 4, delete: true},
+		{name: "writes and a merge", writes: 3, merge: true},
+		{name: "writes after a merge", writes: 2},
+		{name: "delete only", delete: true},
+		{name: "writes after a delete", writes: 6},
+	}
+
+	var live []string
+	for i, cycle := range cycles {
+		t.Run(cycle.name, func(t *testing.T) {
+			im := open()
+			defer func() {
+				if err := im.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}()
+
+			for _, id := range live {
+				if _, err := im.ReadDocument(id); err != nil {
+					t.Fatalf("document %s lost after reopen: %v", id, err)
+				}
+			}
+			loaded := segmentNumbers(t, im)
+			highest := 0
+			for _, number := range loaded {
+				highest = max(highest, number)
+			}
+
+			for n := 0; n < cycle.writes; n++ {
+				doc := types.NewDocument(fmt.Sprintf("c%d-%d", i, n))
+				doc.SetField("n", types.NumericValue{Value: float64(n)})
+				if err := im.WriteDocument(doc); err != nil {
+					t.Fatal(err)
+				}
+				live = append(live, doc.ID)
+			}
+			if cycle.delete {
+				if err := im.DeleteDocument(live[0]); err != nil {
+					t.Fatal(err)
+				}
+				live = live[1:]
+			}
+			if err := im.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if cycle.merge {
+				if err := im.ForceMerge(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// New segments are numbered after every loaded one
+			created := 0
+			for id, number := range segmentNumbers(t, im) {
+				if _, ok := loaded[id]; ok {
+					continue
+				}
+				created++
+				if number <= highest {
+					t.Errorf("new segment %s is numbered at or below loaded segment seg%d", id, highest)
+				}
+			}
+			if cycle.writes > 0 && created == 0 {
+				t.Error("writes created no segment")
+			}
+		})
+	}
+
+	im := open()
+	defer im.Close()
+	for _, id := range live {
+		if _, err := im.ReadDocument(id); err != nil {
+			t.Errorf("document %s lost after the last reopen: %v", id, err)
+		}
+	}
+	if count := im.GetDocumentCount(); count != len(live) {
+		t.Errorf("document count = %d, want %d", count, len(live))
+	}
+}
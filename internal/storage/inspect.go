@@ -0,0 +1,328 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Inspection reads an index directory's files without opening the index, for
+// tools such as nanoctl. Nothing here writes to the directory, so it is safe
+// on the files of a stopped index; on a running one, files may change or
+// disappear while they are read
+
+// ReadManifest returns the manifest of an index directory, nil for an index
+// from before manifests
+func ReadManifest(indexPath string) (*Manifest, error) {
+	return readManifest(OSFS, indexPath)
+}
+
+// ReadCheckpoint returns the WAL sequence up to which every write of an index
+// directory is in its segments, 0 if it has no checkpoint yet
+func ReadCheckpoint(indexPath string) (uint64, error) {
+	return readCheckpoint(OSFS, indexPath)
+}
+
+// SegmentIDs returns the segments of an index directory, in lookup order:
+// those of its manifest, or every segment file for an index from before manifests
+func SegmentIDs(indexPath string) ([]string, error) {
+	manifest, err := readManifest(OSFS, indexPath)
+	if err != nil {
+		return nil, err
+	}
+	if manifest != nil {
+		ids := make([]string, len(manifest.Segments))
+		for i, info := range manifest.Segments {
+			ids[i] = info.ID
+		}
+		return ids, nil
+	}
+
+	entries, err := os.ReadDir(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, "segment_") && strings.HasSuffix(name, ".dat") {
+			ids = append(ids, strings.TrimSuffix(strings.TrimPrefix(name, "segment_"), ".dat"))
+		}
+	}
+	return ids, nil
+}
+
+// SegmentDump is a segment's header and doc index, as read from its files
+type SegmentDump struct {
+	ID          string
+	Path        string
+	Header      SegmentHeader
+	FileSize    int64 // Bytes in the data file
+	DataSize    int64 // Header and records, without a trailing doc index
+	Blocks      int   // Compressed blocks, 0 for plain records
+	Sequence    uint64
+	IndexLoaded bool            // Whether the doc index came whole from its sidecar, rather than being rebuilt
+	Docs        []DocIndexEntry // By offset
+}
+
+// DocIndexEntry is one document of a segment's doc index
+type DocIndexEntry struct {
+	ID      string
+	Offset  int64 // Record offset, in the uncompressed layout
+	Deleted bool
+}
+
+// InspectSegment reads the header and doc index of a segment in an index directory
+func InspectSegment(indexPath string, id string) (*SegmentDump, error) {
+	s := &SegmentReader{ID: id, Path: segmentPath(indexPath, id), fs: OSFS}
+	if err := s.Open(); err != nil {
+		return nil, err
+	}
+	defer s.discard()
+
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open segment file: %w", err)
+	}
+	defer file.Close()
+	dump := &SegmentDump{ID: id, Path: s.Path}
+	if err := binary.Read(file, binary.LittleEndian, &dump.Header); err != nil {
+		return nil, fmt.Errorf("failed to read segment header: %w", err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat segment file: %w", err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dump.FileSize = stat.Size()
+	dump.DataSize = s.Size
+	dump.Blocks = len(s.blocks)
+	dump.Sequence = s.Sequence
+	dump.IndexLoaded = !s.idxDirty
+	dump.Docs = make([]DocIndexEntry, 0, len(s.docIndex))
+	for docID, offset := range s.docIndex {
+		dump.Docs = append(dump.Docs, DocIndexEntry{ID: docID, Offset: offset, Deleted: s.deleted[docID]})
+	}
+	sort.Slice(dump.Docs, func(i, j int) bool {
+		return dump.Docs[i].Offset < dump.Docs[j].Offset
+	})
+	return dump, nil
+}
+
+// discard closes a segment opened for inspection without writing the
+// sidecars it rebuilt in memory
+func (s *SegmentReader) discard() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.unmap(); err != nil {
+		return err
+	}
+	s.initialized = false
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// WALFileInfo describes one file of an index's WAL
+type WALFileInfo struct {
+	Path    string
+	Number  int // 0 for the single file of a log from before rotation
+	Version int
+	Base    uint64 // Last sequence before the file's first entry
+	Size    int64
+}
+
+// ListWAL returns the WAL files of an index directory, oldest first
+func ListWAL(indexPath string) ([]WALFileInfo, error) {
+	files, err := listWALFiles(OSFS, indexPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		legacy := filepath.Join(indexPath, legacyWALName)
+		if _, err := os.Stat(legacy); err == nil {
+			files = append(files, walFile{path: legacy})
+		}
+	}
+
+	infos := make([]WALFileInfo, 0, len(files))
+	for _, f := range files {
+		stat, err := os.Stat(f.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat WAL file: %w", err)
+		}
+		info := WALFileInfo{Path: f.path, Number: f.number, Size: stat.Size()}
+		if header, err := readHeaderFile(OSFS, f.path); err == nil {
+			info.Version = int(header.Version)
+			info.Base = header.Sequence
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// ReadWALFile calls fn with every entry of a WAL file, and its offset, in order
+// A damaged entry, including a torn last one the next open would discard,
+// stops the read with a *CorruptionError
+// Entries are read without the index's encryption keys, so encrypted ones
+// only have their type, sequence and timestamp (see WALEntry.Encrypted)
+func ReadWALFile(path string, fn func(offset int64, entry *WALEntry) error) error {
+	w := &WAL{dir: filepath.Dir(path), fs: OSFS}
+	return w.forEachEntry(path, fn)
+}
+
+// forEachEntry is ReadWALFile for one of the WAL's files, decrypting its
+// entries with the WAL's keys, if it has any
+func (w *WAL) forEachEntry(path string, fn func(offset int64, entry *WALEntry) error) error {
+	file, err := w.fs.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open WAL file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := readHeader(file); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for {
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		entry, err := w.readEntry(file, path)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(offset, entry); err != nil {
+			return err
+		}
+	}
+}
+
+// ChecksumReport is what VerifyChecksums found in an index directory
+type ChecksumReport struct {
+	Segments   int
+	Blocks     int // Compressed blocks of the segments
+	Records    int // Document records of the segments, including deleted ones, except in encrypted blocks
+	WALFiles   int
+	WALEntries int
+	Problems   []error // At most one per file; *CorruptionError for failed checksums and framing
+}
+
+// OK reports whether no problem was found
+func (r *ChecksumReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// VerifyChecksums reads every checksummed file of an index directory: the
+// segments' records, blocks and sidecars, the WAL and the checkpoint
+// Damaged files are reported in the report; the error is for a directory
+// that can't be read at all
+func VerifyChecksums(indexPath string) (*ChecksumReport, error) {
+	report := &ChecksumReport{}
+	if _, err := readCheckpoint(OSFS, indexPath); err != nil {
+		report.Problems = append(report.Problems, err)
+	}
+
+	ids, err := SegmentIDs(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		report.Segments++
+		report.verifySegment(indexPath, id)
+	}
+
+	files, err := ListWAL(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		report.WALFiles++
+		err := ReadWALFile(f.Path, func(offset int64, entry *WALEntry) error {
+			report.WALEntries++
+			return nil
+		})
+		if err != nil {
+			report.Problems = append(report.Problems, err)
+		}
+	}
+	return report, nil
+}
+
+// verifySegment checks the checksums of a segment's sidecars, blocks and
+// records, adding a problem for each damaged file
+func (r *ChecksumReport) verifySegment(indexPath string, id string) {
+	problem := func(err error) {
+		r.Problems = append(r.Problems, fmt.Errorf("segment %s: %w", id, err))
+	}
+	s := &SegmentReader{ID: id, Path: segmentPath(indexPath, id), fs: OSFS}
+	if err := s.Open(); err != nil { // Checks the doc values and norms
+		problem(err)
+		return
+	}
+	defer s.discard()
+	if err := verifyDocIndexFile(s); err != nil {
+		problem(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.Blocks += len(s.blocks) // Checked as forEachRecord reads them
+	if s.hasEncryptedBlocks() {
+		// Without the keys only the stored blocks' checksums can be checked
+		for i := range s.blocks {
+			if _, err := s.storedBlock(i); err != nil {
+				problem(err)
+			}
+		}
+		return
+	}
+	err := s.forEachRecord(func(offset int64, record []byte) error {
+		r.Records++
+		return verifyChecksum(s.Path, offset, record[8:], binary.LittleEndian.Uint32(record[4:8]))
+	})
+	if err != nil {
+		problem(err)
+	}
+}
+
+// verifyDocIndexFile checks the checksum of a segment's doc index sidecar, if
+// there is one
+// Opening a segment rebuilds a damaged doc index without a word, so it is
+// checked on its own; an encrypted one is checked by decrypting it, which
+// needs the segment's keys
+func verifyDocIndexFile(s *SegmentReader) error {
+	path := s.indexPath()
+	data, err := s.fs.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read doc index: %w", err)
+	}
+	if isSealedSidecar(data) {
+		if s.encryptor == nil {
+			return nil
+		}
+		if data, _, err = s.readSidecar(path); err != nil {
+			return err
+		}
+	}
+	if len(data) < docIndexHeaderSize || string(data[0:4]) != DocIndexMagic {
+		return &CorruptionError{Path: path, Offset: 0, Reason: "invalid doc index header"}
+	}
+	return verifyChecksum(path, 0, data[docIndexHeaderSize:], binary.LittleEndian.Uint32(data[6:10]))
+}
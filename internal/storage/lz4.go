@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// LZ4 block format (https://github.com/lz4/lz4/blob/dev/doc/lz4_Block_format.md)
+// A block is a series of sequences, each a token byte (literal length in the
+// high nibble, match length - 4 in the low one; 15 means more length bytes
+// follow), the literals, and a 2-byte little-endian offset back to the match.
+// The last sequence has literals only
+const (
+	lz4MinMatch     = 4
+	lz4LastLiterals = 5  // The last 5 bytes are always literals
+	lz4MatchLimit   = 12 // The last match must start 12 bytes before the end
+	lz4MaxOffset    = 65535
+	lz4HashLog      = 14
+)
+
+var errLZ4Corrupt = errors.New("corrupt lz4 block")
+
+// lz4Compress appends the LZ4 block encoding of src to dst
+// It is a greedy single-pass compressor with a hash table of 4-byte sequences
+func lz4Compress(dst, src []byte) []byte {
+	n := len(src)
+	if n <= lz4MatchLimit {
+		return lz4AppendSequence(dst, src, 0, 0)
+	}
+
+	table := make([]int32, 1<<lz4HashLog) // Position+1 of the last occurrence of each hash
+	anchor := 0
+	for i := 0; i < n-lz4MatchLimit; {
+		seq := binary.LittleEndian.Uint32(src[i:])
+		h := (seq * 2654435761) >> (32 - lz4HashLog)
+		ref := int(table[h]) - 1
+		table[h] = int32(i + 1)
+
+		if ref < 0 || i-ref > lz4MaxOffset || binary.LittleEndian.Uint32(src[ref:]) != seq {
+			i++
+			continue
+		}
+
+		end := i + lz4MinMatch
+		for end < n-lz4LastLiterals && src[end] == src[ref+end-i] {
+			end++
+		}
+		dst = lz4AppendSequence(dst, src[anchor:i], i-ref, end-i)
+		i, anchor = end, end
+	}
+	return lz4AppendSequence(dst, src[anchor:], 0, 0)
+}
+
+// lz4AppendSequence appends one sequence; a matchLen of 0 writes the final,
+// literals-only sequence
+func lz4AppendSequence(dst, literals []byte, offset, matchLen int) []byte {
+	litLen := len(literals)
+	token := byte(min(litLen, 15)) << 4
+	if matchLen > 0 {
+		token |= byte(min(matchLen-lz4MinMatch, 15))
+	}
+	dst = append(dst, token)
+	if litLen >= 15 {
+		dst = lz4AppendLength(dst, litLen-15)
+	}
+	dst = append(dst, literals...)
+
+	if matchLen == 0 {
+		return dst
+	}
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if matchLen-lz4MinMatch >= 15 {
+		dst = lz4AppendLength(dst, matchLen-lz4MinMatch-15)
+	}
+	return dst
+}
+
+// lz4AppendLength appends the extra bytes of a length that didn't fit its nibble
+func lz4AppendLength(dst []byte, n int) []byte {
+	for n >= 255 {
+		dst = append(dst, 255)
+		n -= 255
+	}
+	return append(dst, byte(n))
+}
+
+// lz4Decompress decodes an LZ4 block that decompresses to exactly rawLen bytes
+func lz4Decompress(src []byte, rawLen int) ([]byte, error) {
+	dst := make([]byte, 0, rawLen)
+	i := 0
+	for i < len(src) {
+		token := src[i]
+		i++
+
+		litLen, next, ok := lz4ReadLength(src, i, int(token>>4))
+		if !ok || litLen > len(src)-next || len(dst)+litLen > rawLen {
+			return nil, errLZ4Corrupt
+		}
+		i = next
+		dst = append(dst, src[i:i+litLen]...)
+		i += litLen
+		if i == len(src) {
+			break // Final literals-only sequence
+		}
+
+		if i+2 > len(src) {
+			return nil, errLZ4Corrupt
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		matchLen, next, ok := lz4ReadLength(src, i, int(token&15))
+		if !ok || offset == 0 || offset > len(dst) {
+			return nil, errLZ4Corrupt
+		}
+		i = next
+		matchLen += lz4MinMatch
+		if len(dst)+matchLen > rawLen {
+			return nil, errLZ4Corrupt
+		}
+
+		// Byte by byte, since a match may overlap the bytes it produces
+		start := len(dst) - offset
+		for k := 0; k < matchLen; k++ {
+			dst = append(dst, dst[start+k])
+		}
+	}
+
+	if len(dst) != rawLen {
+		return nil, errLZ4Corrupt
+	}
+	return dst, nil
+}
+
+// lz4ReadLength reads the extra length bytes following a nibble of 15
+func lz4ReadLength(src []byte, i, n int) (int, int, bool) {
+	if n != 15 {
+		return n, i, true
+	}
+	for {
+		if i >= len(src) {
+			return 0, i, false
+		}
+		b := src[i]
+		i++
+		n += int(b)
+		if b != 255 {
+			return n, i, true
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// manifestPrefix starts the name of the manifest files, segments_<generation>
+// Each change to the segment list writes the next generation, and the highest
+// one on disk describes the index
+const manifestPrefix = "segments_"
+
+// Manifest lists an index's segments, in lookup order, with their metadata
+// Files of segments it doesn't list are left by a flush or merge that didn't
+// complete, or by a merge that stopped before removing its sources, and are
+// deleted when the index is opened
+type Manifest struct {
+	Generation uint64            `json:"generation"`
+	Segments   []ManifestSegment `json:"segments"`
+}
+
+// ManifestSegment describes one segment of a manifest
+type ManifestSegment struct {
+	ID          string                `json:"id"`
+	DocCount    int                   `json:"doc_count"` // Records, including deleted documents
+	Deleted     int                   `json:"deleted"`
+	Size        int64                 `json:"size"`
+	MinSequence uint64                `json:"min_sequence"` // WAL sequences of the writes in the segment, 0 if unknown
+	MaxSequence uint64                `json:"max_sequence"`
+	Fields      map[string]FieldStats `json:"fields,omitempty"` // Text field lengths of the live documents
+}
+
+// manifestName returns the name of the manifest file of a generation
+func manifestName(generation uint64) string {
+	return fmt.Sprintf("%s%d", manifestPrefix, generation)
+}
+
+// parseManifestName returns the generation of a manifest file name
+func parseManifestName(name string) (uint64, bool) {
+	var generation uint64
+	if _, err := fmt.Sscanf(name, manifestPrefix+"%d", &generation); err != nil || name != manifestName(generation) {
+		return 0, false
+	}
+	return generation, true
+}
+
+// readManifest reads the manifest with the highest generation, nil for an
+// index from before manifests
+func readManifest(fs FS, basePath string) (*Manifest, error) {
+	entries, err := fs.ReadDir(basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest string
+	var latestGen uint64
+	for _, entry := range entries {
+		if generation, ok := parseManifestName(entry.Name()); ok && (latest == "" || generation > latestGen) {
+			latest, latestGen = entry.Name(), generation
+		}
+	}
+	if latest == "" {
+		return nil, nil
+	}
+
+	data, err := fs.ReadFile(filepath.Join(basePath, latest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest %s: %w", latest, err)
+	}
+	if manifest.Generation != latestGen {
+		return nil, fmt.Errorf("manifest %s has generation %d", latest, manifest.Generation)
+	}
+	return &manifest, nil
+}
+
+// manifest returns the manifest of the current segments at the next generation
+// Caller must hold im.mu
+func (im *IndexManager) manifest() *Manifest {
+	manifest := &Manifest{Generation: im.manifestGen + 1}
+	for _, seg := range im.segments {
+		manifest.Segments = append(manifest.Segments, seg.info())
+	}
+	return manifest
+}
+
+// writeManifest durably records the current segments as the next manifest
+// generation (write temp file, sync, then rename), then removes older ones
+// Caller must hold im.mu for writing
+func (im *IndexManager) writeManifest() error {
+	manifest := im.manifest()
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	path := filepath.Join(im.BasePath, manifestName(manifest.Generation))
+	tmpPath := path + ".tmp"
+	tmp, err := im.fs.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync manifest: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := im.fs.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to commit manifest: %w", err)
+	}
+
+	previous := filepath.Join(im.BasePath, manifestName(im.manifestGen))
+	im.manifestGen = manifest.Generation
+	if err := im.fs.Remove(previous); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove old manifest: %w", err)
+	}
+	return nil
+}
+
+// removeStaleFiles deletes segment files the manifest doesn't list, and older
+// or unfinished manifests
+func (im *IndexManager) removeStaleFiles(manifest *Manifest) error {
+	listed := make(map[string]bool, len(manifest.Segments))
+	for _, info := range manifest.Segments {
+		listed[info.ID] = true
+	}
+
+	entries, err := im.fs.ReadDir(im.BasePath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		stale := false
+		if id, ok := strings.CutPrefix(name, "segment_"); ok {
+			id, _, _ = strings.Cut(id, ".")
+			stale = !listed[id] || strings.HasSuffix(name, ".tmp")
+		} else if strings.HasPrefix(name, manifestPrefix) {
+			stale = name != manifestName(manifest.Generation)
+		}
+		if !stale || entry.IsDir() {
+			continue
+		}
+		if err := im.fs.Remove(filepath.Join(im.BasePath, name)); err != nil {
+			return fmt.Errorf("failed to remove stale file: %w", err)
+		}
+		im.logger.Info("removed stale file", "file", name, "generation", manifest.Generation)
+	}
+	return nil
+}
+
+// info returns the segment's manifest entry, cached until its contents change
+func (s *SegmentReader) info() ManifestSegment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.manifestInfo != nil && s.infoGeneration == s.generation {
+		return *s.manifestInfo
+	}
+
+	fields := make(map[string]FieldStats, len(s.norms))
+	for field, lengths := range s.norms {
+		var stats FieldStats
+		for id, length := range lengths {
+			if !s.deleted[id] {
+				stats.DocCount++
+				stats.TotalLength += int64(length)
+			}
+		}
+		if stats.DocCount > 0 {
+			fields[field] = stats
+		}
+	}
+	s.manifestInfo = &ManifestSegment{
+		ID:          s.ID,
+		DocCount:    s.DocCount,
+		Deleted:     len(s.deleted),
+		Size:        s.Size,
+		MinSequence: s.MinSequence,
+		MaxSequence: s.Sequence,
+		Fields:      fields,
+	}
+	s.infoGeneration = s.generation
+	return *s.manifestInfo
+}
@@ -0,0 +1,531 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemFS is an FS held in memory, for tests and for indexes that don't need to
+// outlive the process
+// Files behave like those on disk: an open file keeps its contents when it is
+// renamed over or removed, and renames replace their target atomically
+// Syncs do nothing, so every write is as durable as the process
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memNode // By cleaned path
+	dirs  map[string]time.Time
+}
+
+// memNode is the contents of a file, shared by its open handles
+type memNode struct {
+	mu      sync.RWMutex
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemFS returns an empty in-memory file system
+// The current and root directories exist; others are created by MkdirAll
+func NewMemFS() *MemFS {
+	now := time.Now()
+	return &MemFS{
+		files: make(map[string]*memNode),
+		dirs:  map[string]time.Time{".": now, string(filepath.Separator): now},
+	}
+}
+
+// errNotEmpty is returned by Remove for a directory with entries
+var errNotEmpty = errors.New("directory not empty")
+
+// parent returns the directory holding a cleaned path
+func parent(name string) string {
+	return filepath.Dir(name)
+}
+
+// checkParent returns an error unless the directory holding name exists
+// Caller must hold m.mu
+func (m *MemFS) checkParent(op string, name string) error {
+	if _, ok := m.dirs[parent(name)]; !ok {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return nil
+}
+
+// Open opens a file for reading
+func (m *MemFS) Open(name string) (File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens a file with the flags of os.OpenFile; perm is ignored
+func (m *MemFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.dirs[name]; ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+	node, ok := m.files[name]
+	switch {
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case !ok:
+		if err := m.checkParent("open", name); err != nil {
+			return nil, err
+		}
+		node = &memNode{modTime: time.Now()}
+		m.files[name] = node
+	}
+
+	file := &memFile{
+		name:     name,
+		node:     node,
+		readable: flag&os.O_WRONLY == 0,
+		writable: flag&(os.O_WRONLY|os.O_RDWR) != 0,
+		append:   flag&os.O_APPEND != 0,
+	}
+	if flag&os.O_TRUNC != 0 && file.writable {
+		node.mu.Lock()
+		node.data = nil
+		node.modTime = time.Now()
+		node.mu.Unlock()
+	}
+	return file, nil
+}
+
+// ReadFile returns a copy of a file's contents
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	file, err := m.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// WriteFile replaces a file's contents, creating it if needed
+func (m *MemFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	file, err := m.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Rename moves a file, replacing any file at newpath, or a directory with
+// everything in it, to a path that isn't taken
+func (m *MemFS) Rename(oldpath string, newpath string) error {
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkParent("rename", newpath); err != nil {
+		return err
+	}
+	if node, ok := m.files[oldpath]; ok {
+		if _, ok := m.dirs[newpath]; ok {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrExist}
+		}
+		delete(m.files, oldpath)
+		m.files[newpath] = node
+		return nil
+	}
+	modTime, ok := m.dirs[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	if _, ok := m.files[newpath]; ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrExist}
+	}
+	if _, ok := m.dirs[newpath]; ok {
+		if m.hasEntries(newpath) {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errNotEmpty}
+		}
+	}
+
+	// Collected first, so the loops don't see the entries they add
+	prefix := oldpath + string(filepath.Separator)
+	files := make(map[string]*memNode)
+	for name, node := range m.files {
+		if strings.HasPrefix(name, prefix) {
+			files[name] = node
+		}
+	}
+	dirs := map[string]time.Time{oldpath: modTime}
+	for name, dirTime := range m.dirs {
+		if strings.HasPrefix(name, prefix) {
+			dirs[name] = dirTime
+		}
+	}
+	for name, node := range files {
+		delete(m.files, name)
+		m.files[newpath+name[len(oldpath):]] = node
+	}
+	for name, dirTime := range dirs {
+		delete(m.dirs, name)
+		m.dirs[newpath+name[len(oldpath):]] = dirTime
+	}
+	return nil
+}
+
+// hasEntries reports whether a directory holds any file or directory
+// Caller must hold m.mu
+func (m *MemFS) hasEntries(dir string) bool {
+	for name := range m.files {
+		if parent(name) == dir {
+			return true
+		}
+	}
+	for name := range m.dirs {
+		if name != dir && parent(name) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove removes a file or an empty directory
+func (m *MemFS) Remove(name string) error {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.files[name]; ok {
+		delete(m.files, name)
+		return nil
+	}
+	if _, ok := m.dirs[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	if m.hasEntries(name) {
+		return &os.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+	}
+	delete(m.dirs, name)
+	return nil
+}
+
+// RemoveAll removes a file, or a directory and everything in it
+// A path that doesn't exist is not an error
+func (m *MemFS) RemoveAll(path string) error {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prefix := path + string(filepath.Separator)
+	for name := range m.files {
+		if name == path || strings.HasPrefix(name, prefix) {
+			delete(m.files, name)
+		}
+	}
+	for name := range m.dirs {
+		if name == path || strings.HasPrefix(name, prefix) {
+			delete(m.dirs, name)
+		}
+	}
+	return nil
+}
+
+// MkdirAll creates a directory and any missing parents
+func (m *MemFS) MkdirAll(path string, perm os.FileMode) error {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for dir := path; ; dir = parent(dir) {
+		if _, ok := m.files[dir]; ok {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: errors.New("not a directory")}
+		}
+		if _, ok := m.dirs[dir]; ok {
+			return nil
+		}
+		m.dirs[dir] = time.Now()
+	}
+}
+
+// ReadDir returns the entries of a directory, sorted by name
+func (m *MemFS) ReadDir(name string) ([]os.DirEntry, error) {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.dirs[name]; !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	var entries []os.DirEntry
+	for path, node := range m.files {
+		if parent(path) == name {
+			entries = append(entries, iofs.FileInfoToDirEntry(node.info(path)))
+		}
+	}
+	for path, modTime := range m.dirs {
+		if path != name && parent(path) == name {
+			entries = append(entries, iofs.FileInfoToDirEntry(memInfo{name: filepath.Base(path), modTime: modTime, dir: true}))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// Stat describes a file or directory
+func (m *MemFS) Stat(name string) (os.FileInfo, error) {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if node, ok := m.files[name]; ok {
+		return node.info(name), nil
+	}
+	if modTime, ok := m.dirs[name]; ok {
+		return memInfo{name: filepath.Base(name), modTime: modTime, dir: true}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+// info describes the file's current contents
+func (n *memNode) info(name string) os.FileInfo {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return memInfo{name: filepath.Base(name), size: int64(len(n.data)), modTime: n.modTime}
+}
+
+// memInfo is the os.FileInfo of a MemFS file or directory
+type memInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return i.dir }
+func (i memInfo) Sys() interface{}   { return nil }
+
+func (i memInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// memFile is an open MemFS file
+type memFile struct {
+	name     string
+	node     *memNode
+	mu       sync.Mutex // Guards offset and closed
+	offset   int64
+	readable bool
+	writable bool
+	append   bool
+	closed   bool
+}
+
+// pathError returns an error for an operation on the file
+func (f *memFile) pathError(op string, err error) error {
+	return &os.PathError{Op: op, Path: f.name, Err: err}
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, f.pathError("read", os.ErrClosed)
+	}
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *memFile) ReadAt(p []byte, offset int64) (int, error) {
+	f.mu.Lock()
+	closed := f.closed
+	f.mu.Unlock()
+
+	if closed {
+		return 0, f.pathError("read", os.ErrClosed)
+	}
+	if offset < 0 {
+		return 0, f.pathError("readat", errors.New("negative offset"))
+	}
+	n, err := f.readAt(p, offset)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// readAt copies the contents at offset into p, returning io.EOF at the end
+func (f *memFile) readAt(p []byte, offset int64) (int, error) {
+	if !f.readable {
+		return 0, f.pathError("read", os.ErrPermission)
+	}
+	f.node.mu.RLock()
+	defer f.node.mu.RUnlock()
+
+	if offset >= int64(len(f.node.data)) {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	return copy(p, f.node.data[offset:]), nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, f.pathError("write", os.ErrClosed)
+	}
+	if !f.writable {
+		return 0, f.pathError("write", os.ErrPermission)
+	}
+
+	offset := f.offset
+	if f.append {
+		offset = -1
+	}
+	f.offset = f.writeAt(p, offset)
+	return len(p), nil
+}
+
+func (f *memFile) WriteAt(p []byte, offset int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case f.closed:
+		return 0, f.pathError("write", os.ErrClosed)
+	case !f.writable:
+		return 0, f.pathError("write", os.ErrPermission)
+	case f.append:
+		return 0, f.pathError("writeat", errors.New("file opened with O_APPEND"))
+	case offset < 0:
+		return 0, f.pathError("writeat", errors.New("negative offset"))
+	}
+	f.writeAt(p, offset)
+	return len(p), nil
+}
+
+// writeAt copies p into the contents at offset, or at the end if offset is
+// negative, growing them as needed; returns the offset after p
+func (f *memFile) writeAt(p []byte, offset int64) int64 {
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+
+	size := int64(len(f.node.data))
+	if offset < 0 {
+		offset = size
+	}
+	end := offset + int64(len(p))
+	if end > size {
+		if end > int64(cap(f.node.data)) {
+			grown := make([]byte, end, max(end, 2*int64(cap(f.node.data))))
+			copy(grown, f.node.data)
+			f.node.data = grown
+		} else {
+			f.node.data = f.node.data[:end]
+			clear(f.node.data[size:]) // Bytes left by a truncate, or a gap past the end
+		}
+	}
+	copy(f.node.data[offset:], p)
+	f.node.modTime = time.Now()
+	return end
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, f.pathError("seek", os.ErrClosed)
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		f.node.mu.RLock()
+		offset += int64(len(f.node.data))
+		f.node.mu.RUnlock()
+	default:
+		return 0, f.pathError("seek", errors.New("invalid whence"))
+	}
+	if offset < 0 {
+		return 0, f.pathError("seek", errors.New("negative offset"))
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return f.pathError("close", os.ErrClosed)
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	return f.node.info(f.name), nil
+}
+
+// Sync does nothing: the contents are already where they will stay
+func (f *memFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return f.pathError("sync", os.ErrClosed)
+	}
+	return nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return f.pathError("truncate", os.ErrClosed)
+	}
+	if !f.writable {
+		return f.pathError("truncate", os.ErrPermission)
+	}
+	if size < 0 {
+		return f.pathError("truncate", errors.New("negative size"))
+	}
+
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+	if size <= int64(len(f.node.data)) {
+		f.node.data = f.node.data[:size]
+	} else {
+		f.node.data = append(f.node.data, make([]byte, size-int64(len(f.node.data)))...)
+	}
+	f.node.modTime = time.Now()
+	return nil
+}
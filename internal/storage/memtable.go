@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+
+	"nano-elastic/internal/index/docvalues"
+	"nano-elastic/internal/types"
+)
+
+// DefaultMaxImmutableMemtables is how many full memtables may wait for the
+// flusher before writers stall and flush them themselves
+const DefaultMaxImmutableMemtables = 2
+
+// memtable buffers recent writes in memory until the flusher writes them to a segment
+// Writes are accepted concurrently once they are in the WAL; documents are kept
+// in the binary encoding, so readers decode their own copy
+type memtable struct {
+	entries map[string]memEntry
+	size    int64 // Encoded bytes of the buffered documents
+	mu      sync.RWMutex
+}
+
+// memEntry is the latest write of one document
+type memEntry struct {
+	data []byte // Encoded document, nil for a delete
+	seq  uint64 // WAL sequence of the write; a write never replaces a newer one
+}
+
+func newMemtable() *memtable {
+	return &memtable{entries: make(map[string]memEntry)}
+}
+
+// put records a write, or a delete when data is nil
+func (m *memtable) put(id string, data []byte, seq uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.entries[id]
+	if ok && old.seq > seq {
+		return // A concurrent writer got a later WAL sequence and already landed
+	}
+	m.size += int64(len(data) - len(old.data))
+	m.entries[id] = memEntry{data: data, seq: seq}
+}
+
+// get returns the latest write of a document
+func (m *memtable) get(id string) (memEntry, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, ok := m.entries[id]
+	return entry, ok
+}
+
+// stats returns the number of buffered writes and their encoded size
+func (m *memtable) stats() (int, int64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.entries), m.size
+}
+
+// sequenceRange returns the WAL sequences of the earliest and latest buffered writes
+func (m *memtable) sequenceRange() (uint64, uint64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var first, last uint64
+	for _, entry := range m.entries {
+		if first == 0 || entry.seq < first {
+			first = entry.seq
+		}
+		last = max(last, entry.seq)
+	}
+	return first, last
+}
+
+// snapshot copies the entries; the encoded documents are shared, they are never modified
+func (m *memtable) snapshot() map[string]memEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := make(map[string]memEntry, len(m.entries))
+	for id, entry := range m.entries {
+		entries[id] = entry
+	}
+	return entries
+}
+
+// documents decodes the buffered documents in write order
+func (m *memtable) documents() ([]*types.Document, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]string, 0, len(m.entries))
+	for id, entry := range m.entries {
+		if entry.data != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return m.entries[ids[i]].seq < m.entries[ids[j]].seq
+	})
+
+	docs := make([]*types.Document, len(ids))
+	for i, id := range ids {
+		doc, err := types.DecodeDocument(m.entries[id].data)
+		if err != nil {
+			return nil, err
+		}
+		docs[i] = doc
+	}
+	return docs, nil
+}
+
+// memView is the merged state of the memtables, newest write winning
+type memView map[string]memEntry
+
+// memView merges the active and immutable memtables
+// Caller must hold im.mu
+func (im *IndexManager) memView() memView {
+	view := make(memView)
+	for _, mt := range im.memtables() {
+		for id, entry := range mt.snapshot() {
+			if _, ok := view[id]; !ok {
+				view[id] = entry
+			}
+		}
+	}
+	return view
+}
+
+// memtables returns the active memtable and the ones waiting to be flushed, newest first
+// Caller must hold im.mu
+func (im *IndexManager) memtables() []*memtable {
+	tables := make([]*memtable, 0, len(im.immutable)+1)
+	tables = append(tables, im.memtable)
+	for i := len(im.immutable) - 1; i >= 0; i-- {
+		tables = append(tables, im.immutable[i])
+	}
+	return tables
+}
+
+// lookupMemtables returns the newest buffered write of a document
+// Caller must hold im.mu
+func (im *IndexManager) lookupMemtables(id string) (memEntry, bool) {
+	for _, mt := range im.memtables() {
+		if entry, ok := mt.get(id); ok {
+			return entry, true
+		}
+	}
+	return memEntry{}, false
+}
+
+// exists reports whether a document is live, in a memtable or a segment
+// Caller must hold im.mu
+func (im *IndexManager) exists(id string) bool {
+	if entry, ok := im.lookupMemtables(id); ok {
+		return entry.data != nil
+	}
+	return im.inSegments(id)
+}
+
+// docValues returns the doc values of the view's live documents
+func (view memView) docValues() (docvalues.Columns, error) {
+	columns := make(docvalues.Columns)
+	for id, entry := range view {
+		if entry.data == nil {
+			continue
+		}
+		doc, err := types.DecodeDocument(entry.data)
+		if err != nil {
+			return nil, err
+		}
+		for name, fieldValue := range doc.Flatten().Fields {
+			if value, ok := docvalues.FromFieldValue(fieldValue); ok {
+				columns.Set(id, name, value)
+			}
+		}
+	}
+	return columns, nil
+}
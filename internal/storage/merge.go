@@ -0,0 +1,409 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultMergeInterval is how often the background merger looks for work
+const DefaultMergeInterval = 30 * time.Second
+
+// SegmentInfo is a snapshot of the segment statistics a merge policy needs
+type SegmentInfo struct {
+	ID          string
+	Size        int64
+	LiveDocs    int
+	DeletedDocs int
+}
+
+// liveSize estimates the bytes held by live documents
+func (si SegmentInfo) liveSize() int64 {
+	total := si.LiveDocs + si.DeletedDocs
+	if total == 0 {
+		return 0
+	}
+	return si.Size * int64(si.LiveDocs) / int64(total)
+}
+
+// deletedRatio returns the fraction of documents that are tombstoned
+func (si SegmentInfo) deletedRatio() float64 {
+	total := si.LiveDocs + si.DeletedDocs
+	if total == 0 {
+		return 0
+	}
+	return float64(si.DeletedDocs) / float64(total)
+}
+
+// MergePolicy decides which segments should be merged together
+// Each returned group of segment IDs is merged into a single new segment
+type MergePolicy interface {
+	FindMerges(segments []SegmentInfo) [][]string
+}
+
+// TieredMergePolicy groups segments into size tiers and merges a tier
+// once it accumulates enough segments, similar to Lucene's policy of the same name
+type TieredMergePolicy struct {
+	SegmentsPerTier       int     // Merge a tier once it holds this many segments
+	MaxMergeAtOnce        int     // Maximum number of segments merged in one go
+	FloorSegmentBytes     int64   // Smaller segments are treated as this size when tiering
+	MaxMergedSegmentBytes int64   // Skip merges that would produce a larger segment
+	DeletesPctAllowed     float64 // Rewrite a segment alone once this fraction is deleted
+}
+
+// NewTieredMergePolicy creates a tiered merge policy with default settings
+func NewTieredMergePolicy() *TieredMergePolicy {
+	return &TieredMergePolicy{
+		SegmentsPerTier:       10,
+		MaxMergeAtOnce:        10,
+		FloorSegmentBytes:     2 * 1024 * 1024,        // 2MB
+		MaxMergedSegmentBytes: 5 * 1024 * 1024 * 1024, // 5GB
+		DeletesPctAllowed:     0.33,
+	}
+}
+
+// FindMerges implements MergePolicy
+func (p *TieredMergePolicy) FindMerges(segments []SegmentInfo) [][]string {
+	var merges [][]string
+	merging := make(map[string]bool)
+
+	// Bucket segments into tiers by live size: tier n holds segments
+	// between floor*perTier^n and floor*perTier^(n+1)
+	tiers := make(map[int][]SegmentInfo)
+	for _, seg := range segments {
+		size := seg.liveSize()
+		if size < p.FloorSegmentBytes {
+			size = p.FloorSegmentBytes
+		}
+		tier := 0
+		if p.FloorSegmentBytes > 0 && p.SegmentsPerTier > 1 {
+			ratio := float64(size) / float64(p.FloorSegmentBytes)
+			tier = int(math.Log(ratio) / math.Log(float64(p.SegmentsPerTier)))
+		}
+		tiers[tier] = append(tiers[tier], seg)
+	}
+
+	for _, tierSegs := range tiers {
+		if len(tierSegs) < p.SegmentsPerTier {
+			continue
+		}
+
+		// Merge the smallest segments of the tier first
+		sort.Slice(tierSegs, func(i, j int) bool {
+			return tierSegs[i].liveSize() < tierSegs[j].liveSize()
+		})
+
+		var group []string
+		var groupSize int64
+		for _, seg := range tierSegs {
+			if len(group) >= p.MaxMergeAtOnce {
+				break
+			}
+			if p.MaxMergedSegmentBytes > 0 && groupSize+seg.liveSize() > p.MaxMergedSegmentBytes {
+				break
+			}
+			group = append(group, seg.ID)
+			groupSize += seg.liveSize()
+		}
+
+		if len(group) > 1 {
+			merges = append(merges, group)
+			for _, id := range group {
+				merging[id] = true
+			}
+		}
+	}
+
+	// Rewrite heavily deleted segments on their own to reclaim space
+	for _, seg := range segments {
+		if !merging[seg.ID] && seg.DeletedDocs > 0 && seg.deletedRatio() >= p.DeletesPctAllowed {
+			merges = append(merges, []string{seg.ID})
+		}
+	}
+
+	return merges
+}
+
+// MergeScheduler runs merges for an IndexManager in a background goroutine
+// It wakes up periodically and whenever Trigger is called (e.g. after a flush)
+type MergeScheduler struct {
+	im       *IndexManager
+	interval time.Duration
+	trigger  chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewMergeScheduler creates a merge scheduler for the given index manager
+func NewMergeScheduler(im *IndexManager, interval time.Duration) *MergeScheduler {
+	return &MergeScheduler{
+		im:       im,
+		interval: interval,
+		trigger:  make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start launches the background merge loop
+func (ms *MergeScheduler) Start() {
+	go ms.run()
+}
+
+// Trigger requests a merge check without waiting for the next tick
+func (ms *MergeScheduler) Trigger() {
+	select {
+	case ms.trigger <- struct{}{}:
+	default:
+		// A check is already pending
+	}
+}
+
+// Stop stops the merge loop and waits for any in-flight merge to finish
+func (ms *MergeScheduler) Stop() {
+	ms.once.Do(func() {
+		close(ms.stop)
+		<-ms.done
+	})
+}
+
+// run is the merge loop
+func (ms *MergeScheduler) run() {
+	defer close(ms.done)
+
+	ticker := time.NewTicker(ms.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ms.stop:
+			return
+		case <-ticker.C:
+		case <-ms.trigger:
+		}
+
+		// Errors leave the source segments intact; the next tick retries
+		if err := ms.im.MaybeMerge(); err != nil {
+			ms.im.logger.Error("background merge failed", "error", err)
+		}
+		if err := ms.im.Reencrypt(); err != nil {
+			ms.im.logger.Error("background re-encryption failed", "error", err)
+		}
+	}
+}
+
+// MaybeMerge asks the merge policy for merges among the segments and runs them
+func (im *IndexManager) MaybeMerge() error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if im.mergePolicy == nil || len(im.segments) == 0 {
+		return nil
+	}
+
+	// Expired documents count as deleted, so segments of old data get
+	// rewritten without them like heavily deleted ones
+	expired := im.expiredCounts(time.Now())
+	infos := make([]SegmentInfo, 0, len(im.segments))
+	byID := make(map[string]*SegmentReader, len(im.segments))
+	for _, seg := range im.segments {
+		infos = append(infos, SegmentInfo{
+			ID:          seg.ID,
+			Size:        seg.GetSize(),
+			LiveDocs:    seg.GetLiveDocCount() - expired[seg],
+			DeletedDocs: seg.GetDeletedCount() + expired[seg],
+		})
+		byID[seg.ID] = seg
+	}
+
+	for _, group := range im.mergePolicy.FindMerges(infos) {
+		segs := make([]*SegmentReader, 0, len(group))
+		for _, id := range group {
+			if seg, ok := byID[id]; ok {
+				segs = append(segs, seg)
+			}
+		}
+		if len(segs) == 0 {
+			continue
+		}
+		if err := im.mergeSegments(context.Background(), segs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reencrypt rewrites the segments holding records not encrypted with the
+// current key, e.g. after a key rotation or once encryption is enabled, so
+// older keys are no longer needed to read them
+// It also rotates the WAL off a file with such entries; the older files are
+// deleted once their entries are flushed
+// Merges do it in the background; segments keep their IDs and offsets
+func (im *IndexManager) Reencrypt() error {
+	if im.encryptor == nil {
+		return nil
+	}
+	keyID, err := im.encryptor.currentKeyID()
+	if err != nil {
+		return err
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if err := im.wal.rotateToKey(keyID); err != nil {
+		return fmt.Errorf("failed to rotate WAL: %w", err)
+	}
+	for _, seg := range im.segments {
+		start := time.Now()
+		rewritten, err := seg.reencrypt(im.codec, keyID)
+		if err != nil {
+			return fmt.Errorf("failed to re-encrypt segment %s: %w", seg.ID, err)
+		}
+		if rewritten {
+			im.logger.Info("re-encrypted segment", "segment", seg.ID, "key", keyID, "took", time.Since(start))
+		}
+	}
+	return nil
+}
+
+// ForceMerge flushes the memtables and merges every segment into a single
+// segment, dropping all tombstoned and expired documents
+func (im *IndexManager) ForceMerge() error {
+	return im.ForceMergeContext(context.Background())
+}
+
+// ForceMergeContext is ForceMerge, abandoned with the context's error if it is
+// done before the merged segment is complete; the segments are left as they were
+func (im *IndexManager) ForceMergeContext(ctx context.Context) error {
+	if err := im.Flush(); err != nil {
+		return fmt.Errorf("failed to flush memtable: %w", err)
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if len(im.segments) == 0 {
+		return nil
+	}
+	if len(im.segments) == 1 && im.segments[0].GetDeletedCount() == 0 && len(im.expiredCounts(time.Now())) == 0 {
+		return nil // Already fully merged
+	}
+
+	segs := make([]*SegmentReader, len(im.segments))
+	copy(segs, im.segments)
+	return im.mergeSegments(ctx, segs)
+}
+
+// mergeSegments copies live documents from the given segments into a new
+// segment, swaps it into the segment list, and removes the old segment files
+// Expired documents are dropped (see WithExpiredTracking)
+// Once ctx is done the merge target is removed and the sources kept
+// Caller must hold im.mu
+func (im *IndexManager) mergeSegments(ctx context.Context, segs []*SegmentReader) error {
+	w, err := im.createSegment()
+	if err != nil {
+		return fmt.Errorf("failed to create merge target: %w", err)
+	}
+	w.MinSequence = segs[0].MinSequence
+	for _, seg := range segs {
+		w.MinSequence = min(w.MinSequence, seg.MinSequence)
+		w.Sequence = max(w.Sequence, seg.Sequence)
+	}
+
+	// Copy live documents in on-disk order
+	now := time.Now()
+	var copied, expired []string
+	for _, seg := range segs {
+		seg.readahead()
+		for _, id := range seg.liveDocIDsByOffset() {
+			if err := ctx.Err(); err != nil {
+				w.Remove()
+				return fmt.Errorf("merge cancelled: %w", err)
+			}
+			doc, err := seg.ReadDocument(id)
+			if err != nil {
+				w.Remove()
+				return fmt.Errorf("failed to read %s from segment %s: %w", id, seg.ID, err)
+			}
+			if im.Schema.IsExpired(doc, now) {
+				expired = append(expired, id)
+				continue
+			}
+			im.Schema.UpgradeDocument(doc) // Merges physically drop removed fields
+			seg.restoreUnstored(doc)       // So the merged segment keeps their doc values
+			if err := w.WriteDocument(doc); err != nil {
+				w.Remove()
+				return fmt.Errorf("failed to write %s to merged segment: %w", id, err)
+			}
+			copied = append(copied, id)
+		}
+	}
+
+	// Nothing is left of sources whose documents all expired
+	var merged *SegmentReader
+	keepMerged := w.GetLiveDocCount() > 0
+	if keepMerged {
+		if merged, err = w.Seal(im.codec); err != nil {
+			w.Remove()
+			return fmt.Errorf("failed to seal merged segment: %w", err)
+		}
+	} else if err := w.Remove(); err != nil {
+		return fmt.Errorf("failed to remove empty merged segment: %w", err)
+	}
+
+	// Replace the source segments; the merged segment takes the position
+	// of the first source so newest-first lookups keep their order
+	mergedSet := make(map[*SegmentReader]bool, len(segs))
+	for _, seg := range segs {
+		mergedSet[seg] = true
+	}
+
+	newSegments := make([]*SegmentReader, 0, len(im.segments)-len(segs)+1)
+	inserted := false
+	for _, seg := range im.segments {
+		if mergedSet[seg] {
+			if !inserted && keepMerged {
+				newSegments = append(newSegments, merged)
+				inserted = true
+			}
+			continue
+		}
+		newSegments = append(newSegments, seg)
+	}
+	im.segments = newSegments
+	im.docs.remap(copied, mergedSet, merged)
+	im.docs.remap(expired, mergedSet, nil)
+	im.recordExpired(expired)
+	im.counters.mergeTotal.Add(1)
+	im.counters.mergeNanos.Add(int64(time.Since(now)))
+	im.counters.mergedDocs.Add(int64(len(copied)))
+	sources := make([]string, len(segs))
+	for i, seg := range segs {
+		sources[i] = seg.ID
+	}
+	mergedID := ""
+	if merged != nil {
+		mergedID = merged.ID
+	}
+	im.logger.Info("merged segments", "sources", sources, "segment", mergedID, "docs", len(copied), "expired", len(expired), "took", time.Since(now))
+
+	// Until the manifest lists the merged segment instead, the sources are kept
+	if err := im.writeManifest(); err != nil {
+		return err
+	}
+
+	for _, seg := range segs {
+		im.counters.retireSegment(seg)
+		if err := seg.Remove(); err != nil {
+			return fmt.Errorf("failed to remove merged segment %s: %w", seg.ID, err)
+		}
+	}
+
+	return nil
+}
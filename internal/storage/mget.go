@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+
+	"nano-elastic/internal/types"
+)
+
+// MGetResult is the outcome of one ID of a multi-get
+type MGetResult struct {
+	ID       string
+	Found    bool
+	Document *types.Document // nil unless Found
+}
+
+// MGet reads many documents in one call, returning a result per ID in order
+// Buffered writes are served from the memtables; the other documents are
+// grouped by the segment holding them and read in offset order, so each
+// segment is read front to back and its compressed blocks decoded once
+func (im *IndexManager) MGet(ids []string) ([]MGetResult, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	results := make([]MGetResult, len(ids))
+	bySegment := make(map[*SegmentReader][]int) // Segment -> indexes into ids
+	for i, id := range ids {
+		results[i].ID = id
+		if entry, ok := im.lookupMemtables(id); ok {
+			if entry.data == nil {
+				continue
+			}
+			doc, err := types.DecodeDocument(entry.data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode document %s: %w", id, err)
+			}
+			im.Schema.UpgradeDocument(doc)
+			results[i].Found, results[i].Document = true, im.Schema.StoredDocument(doc)
+			continue
+		}
+		if seg, ok := im.docs[id]; ok {
+			bySegment[seg] = append(bySegment[seg], i)
+		}
+	}
+
+	for seg, indexes := range bySegment {
+		segIDs := make([]string, len(indexes))
+		for j, i := range indexes {
+			segIDs[j] = ids[i]
+		}
+		docs, err := seg.readDocuments(segIDs)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range indexes {
+			if docs[j] != nil {
+				im.Schema.UpgradeDocument(docs[j])
+				results[i].Found, results[i].Document = true, docs[j]
+			}
+		}
+	}
+	return results, nil
+}
+
+// readDocuments reads several documents of the segment in offset order
+// The result is aligned with ids; missing and deleted documents are nil
+func (s *SegmentReader) readDocuments(ids []string) ([]*types.Document, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.initialized {
+		return nil, fmt.Errorf("segment %s is not open", s.ID)
+	}
+
+	order := make([]int, 0, len(ids))
+	for i, id := range ids {
+		if _, ok := s.docIndex[id]; ok && !s.deleted[id] {
+			order = append(order, i)
+		}
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return s.docIndex[ids[order[a]]] < s.docIndex[ids[order[b]]]
+	})
+
+	docs := make([]*types.Document, len(ids))
+	for _, i := range order {
+		doc, err := s.readRecord(s.docIndex[ids[i]])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from segment %s: %w", ids[i], s.ID, err)
+		}
+		docs[i] = doc
+	}
+	return docs, nil
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// mapRecords compresses the segment's records with codec, unless they already
+// are, and memory-maps them
+// Reads are then served from the mapping instead of a read syscall per
+// document, so concurrent readers share the page cache directly; the records
+// never change under it, since sealed segments are only ever replaced whole
+func (s *SegmentReader) mapRecords(codec Codec) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized || s.file == nil || s.mapped != nil {
+		return nil
+	}
+
+	if codec != CodecNone && s.blocks == nil && s.Size > segmentHeaderSize {
+		if err := s.rewriteRecords(codec); err != nil {
+			return err
+		}
+	}
+
+	// Only the header and records (or blocks) are mapped, not a doc index or
+	// torn record after them
+	mapped, err := mapFile(s.file, s.Size)
+	if err != nil {
+		return fmt.Errorf("failed to map segment %s: %w", s.ID, err)
+	}
+	s.mapped = mapped
+	return nil
+}
+
+// reencrypt rewrites the segment's records into blocks encrypted with the
+// current key, unless they all are already, mapping the new file if the old
+// one was
+// Returns whether the segment was rewritten
+func (s *SegmentReader) reencrypt(codec Codec, keyID uint32) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized || s.file == nil || s.encryptedWith(keyID) {
+		return false, nil
+	}
+	mapped := s.mapped != nil
+	if err := s.rewriteRecords(codec); err != nil {
+		return false, err
+	}
+	if mapped {
+		data, err := mapFile(s.file, s.Size)
+		if err != nil {
+			return false, fmt.Errorf("failed to map segment %s: %w", s.ID, err)
+		}
+		s.mapped = data
+	}
+	return true, nil
+}
+
+// unmap drops the segment's memory mapping, if any
+// Caller must hold s.mu for writing
+func (s *SegmentReader) unmap() error {
+	if s.mapped == nil {
+		return nil
+	}
+	data := s.mapped
+	s.mapped = nil
+	if err := unmapFile(data); err != nil {
+		return fmt.Errorf("failed to unmap segment %s: %w", s.ID, err)
+	}
+	return nil
+}
+
+// readahead prefetches the segment's mapping before a pass over all of its
+// records (a merge or a Scan), so they are read from disk in large sequential
+// requests rather than a page fault at a time; a segment in an object store
+// (see TieredFS) is downloaded instead of read a block at a time
+// Failures are ignored, as the advice is only a hint
+func (s *SegmentReader) readahead() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.mapped != nil {
+		adviseWillNeed(s.mapped)
+	} else if file, ok := s.file.(prefetcher); ok {
+		file.Prefetch()
+	}
+}
+
+// mappedRecord returns the document bytes and checksum of the record at offset
+// from the segment's mapping
+// The bytes alias the mapping and are only valid while s.mu is held
+func (s *SegmentReader) mappedRecord(offset int64) ([]byte, uint32, error) {
+	if offset < 0 || offset+8 > int64(len(s.mapped)) {
+		return nil, 0, &CorruptionError{Path: s.Path, Offset: offset, Reason: "truncated record header"}
+	}
+	docLen := int64(binary.LittleEndian.Uint32(s.mapped[offset : offset+4]))
+	docCRC := binary.LittleEndian.Uint32(s.mapped[offset+4 : offset+8])
+
+	start := offset + 8
+	if start+docLen > int64(len(s.mapped)) {
+		return nil, 0, &CorruptionError{Path: s.Path, Offset: offset, Reason: "truncated record data"}
+	}
+	return s.mapped[start : start+docLen], docCRC, nil
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package storage
+
+// mapFile is unsupported on this platform; segments keep using positional reads
+func mapFile(f File, size int64) ([]byte, error) {
+	return nil, nil
+}
+
+// unmapFile releases a mapping returned by mapFile
+func unmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps the first size bytes of a file read-only
+// Only files on disk can be mapped; for others it returns nil, and segments
+// keep using positional reads
+func mapFile(f File, size int64) ([]byte, error) {
+	osFile, ok := f.(*os.File)
+	if !ok {
+		return nil, nil
+	}
+	return syscall.Mmap(int(osFile.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// unmapFile releases a mapping returned by mapFile
+func unmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
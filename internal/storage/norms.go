@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"nano-elastic/internal/analyzer"
+	"nano-elastic/internal/types"
+)
+
+// Norms sidecar file: [magic "NSNR"][version:uint16][crc:uint32][norms]
+// The norms payload is encoded by FieldNorms.encode
+const (
+	NormsMagic   = "NSNR"
+	NormsVersion = 1
+)
+
+// normsHeaderSize is the size of the magic, version and checksum prefix
+const normsHeaderSize = 4 + 2 + 4
+
+// FieldNorms holds the indexed length, in tokens, of each document's text fields
+// Field -> document ID -> token count
+type FieldNorms map[string]map[string]uint32
+
+// set records a document's length for a field
+func (n FieldNorms) set(field string, id string, length uint32) {
+	lengths, ok := n[field]
+	if !ok {
+		lengths = make(map[string]uint32)
+		n[field] = lengths
+	}
+	lengths[id] = length
+}
+
+// removeDocument drops a document's lengths from every field
+func (n FieldNorms) removeDocument(id string) {
+	for _, lengths := range n {
+		delete(lengths, id)
+	}
+}
+
+// FieldStats summarizes the lengths of a text field across documents
+type FieldStats struct {
+	DocCount    int   `json:"doc_count"`    // Documents with the field
+	TotalLength int64 `json:"total_length"` // Sum of their lengths, in tokens
+}
+
+// AvgLength returns the average length of the field, 0 if no document has it
+func (fs FieldStats) AvgLength() float64 {
+	if fs.DocCount == 0 {
+		return 0
+	}
+	return float64(fs.TotalLength) / float64(fs.DocCount)
+}
+
+// documentNorms analyzes a document's text fields (object subfields by dot path,
+// and copy_to targets) with their index analyzers and returns their token counts
+// Fields missing from the schema use the standard analyzer, as in the inverted index
+func documentNorms(schema *types.Schema, doc *types.Document) map[string]uint32 {
+	norms := make(map[string]uint32)
+	for name, texts := range schema.TextValues(doc) {
+		analyzerName := analyzer.StandardAnalyzer
+		if def, ok := schema.GetField(name); ok && def.Type == types.FieldTypeText {
+			analyzerName = schema.FieldAnalyzerName(*def)
+		}
+		a, err := analyzer.Lookup(analyzerName)
+		if err != nil {
+			continue
+		}
+		for _, text := range texts {
+			norms[name] += uint32(len(a.Analyze(text)))
+		}
+	}
+	return norms
+}
+
+// normsPath returns the path of the norms sidecar file
+func (s *SegmentReader) normsPath() string {
+	return strings.TrimSuffix(s.Path, ".dat") + ".nrm"
+}
+
+// setNorms replaces a document's norms in this segment
+// Segments without a schema (see IndexManager) keep no norms
+// Caller must hold s.mu
+func (s *SegmentReader) setNorms(doc *types.Document) {
+	if s.schema == nil {
+		return
+	}
+	if s.norms == nil {
+		s.norms = make(FieldNorms)
+	}
+	s.norms.removeDocument(doc.ID)
+	for field, length := range documentNorms(s.schema, doc) {
+		s.norms.set(field, doc.ID, length)
+	}
+	s.nrmDirty = true
+}
+
+// FieldNorms returns a field's lengths for the segment's live documents
+func (s *SegmentReader) FieldNorms(field string) map[string]uint32 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	live := make(map[string]uint32, len(s.norms[field]))
+	for id, length := range s.norms[field] {
+		if _, ok := s.docIndex[id]; ok && !s.deleted[id] {
+			live[id] = length
+		}
+	}
+	return live
+}
+
+// readNorms loads the norms sidecar
+// Segments written before norms existed (or whose sidecar was lost in a crash)
+// are rebuilt from their document records and rewritten on the next flush
+// Caller must hold s.mu
+func (s *SegmentReader) readNorms() error {
+	s.norms = make(FieldNorms)
+
+	data, stale, err := s.readSidecar(s.normsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s.rebuildNorms()
+		}
+		return fmt.Errorf("failed to read norms: %w", err)
+	}
+
+	if len(data) < normsHeaderSize || string(data[0:4]) != NormsMagic {
+		return &CorruptionError{Path: s.normsPath(), Offset: 0, Reason: "invalid norms header"}
+	}
+	if version := binary.LittleEndian.Uint16(data[4:6]); version != NormsVersion {
+		return fmt.Errorf("unsupported norms version %d (expected %d)", version, NormsVersion)
+	}
+	payload := data[normsHeaderSize:]
+	if err := verifyChecksum(s.normsPath(), 0, payload, binary.LittleEndian.Uint32(data[6:10])); err != nil {
+		return err
+	}
+
+	norms, err := decodeNorms(payload)
+	if err != nil {
+		return &CorruptionError{Path: s.normsPath(), Offset: normsHeaderSize, Reason: err.Error()}
+	}
+	s.norms = norms
+	s.nrmDirty = stale
+	return nil
+}
+
+// rebuildNorms recomputes norms by reading and analyzing every document record
+// Caller must hold s.mu
+func (s *SegmentReader) rebuildNorms() error {
+	if s.schema == nil {
+		return nil
+	}
+	for _, offset := range s.docIndex {
+		doc, err := s.readRecord(offset)
+		if err != nil {
+			return fmt.Errorf("failed to rebuild norms: %w", err)
+		}
+		s.setNorms(doc)
+	}
+	return nil
+}
+
+// writeNorms persists norms atomically (write temp file, then rename)
+// Caller must hold s.mu
+func (s *SegmentReader) writeNorms() error {
+	if !s.nrmDirty {
+		return nil
+	}
+
+	payload := s.norms.encode()
+	data := make([]byte, normsHeaderSize, normsHeaderSize+len(payload))
+	copy(data[0:4], NormsMagic)
+	binary.LittleEndian.PutUint16(data[4:6], NormsVersion)
+	binary.LittleEndian.PutUint32(data[6:10], checksum(payload))
+	data, err := s.sealSidecar(s.normsPath(), append(data, payload...))
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.normsPath() + ".tmp"
+	if err := s.fs.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write norms: %w", err)
+	}
+	if err := s.fs.Rename(tmpPath, s.normsPath()); err != nil {
+		return fmt.Errorf("failed to commit norms: %w", err)
+	}
+
+	s.nrmDirty = false
+	return nil
+}
+
+// encode serializes the norms, fields and documents in sorted order
+// Layout: [fields:uint32] then per field [len:uint16][name][docs:uint32],
+// then per document [len:uint16][id][length:uvarint]
+func (n FieldNorms) encode() []byte {
+	fields := make([]string, 0, len(n))
+	for name := range n {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(len(fields)))
+	for _, name := range fields {
+		lengths := n[name]
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(name)))
+		buf = append(buf, name...)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(lengths)))
+
+		ids := make([]string, 0, len(lengths))
+		for id := range lengths {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			buf = binary.LittleEndian.AppendUint16(buf, uint16(len(id)))
+			buf = append(buf, id...)
+			buf = binary.AppendUvarint(buf, uint64(lengths[id]))
+		}
+	}
+	return buf
+}
+
+// decodeNorms parses norms written by FieldNorms.encode
+func decodeNorms(data []byte) (FieldNorms, error) {
+	pos := 0
+	next := func(n int) ([]byte, error) {
+		if pos+n > len(data) {
+			return nil, fmt.Errorf("truncated norms at byte %d", pos)
+		}
+		b := data[pos : pos+n]
+		pos += n
+		return b, nil
+	}
+	readString := func() (string, error) {
+		b, err := next(2)
+		if err != nil {
+			return "", err
+		}
+		s, err := next(int(binary.LittleEndian.Uint16(b)))
+		return string(s), err
+	}
+
+	b, err := next(4)
+	if err != nil {
+		return nil, err
+	}
+	fieldCount := binary.LittleEndian.Uint32(b)
+	norms := make(FieldNorms, fieldCount)
+	for i := uint32(0); i < fieldCount; i++ {
+		name, err := readString()
+		if err != nil {
+			return nil, err
+		}
+		b, err := next(4)
+		if err != nil {
+			return nil, err
+		}
+		docCount := binary.LittleEndian.Uint32(b)
+		lengths := make(map[string]uint32, docCount)
+		for j := uint32(0); j < docCount; j++ {
+			id, err := readString()
+			if err != nil {
+				return nil, err
+			}
+			length, n := binary.Uvarint(data[pos:])
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid length at byte %d", pos)
+			}
+			pos += n
+			lengths[id] = uint32(length)
+		}
+		norms[name] = lengths
+	}
+	if pos != len(data) {
+		return nil, fmt.Errorf("%d trailing bytes after norms", len(data)-pos)
+	}
+	return norms, nil
+}
+
+// FieldStats returns the number of live documents with a text field and their
+// total length, from the norms stored with the segments
+// Only the memtables' documents are analyzed
+func (im *IndexManager) FieldStats(field string) (FieldStats, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	var stats FieldStats
+	view := im.memView()
+	for _, entry := range view {
+		if entry.data == nil {
+			continue
+		}
+		doc, err := types.DecodeDocument(entry.data)
+		if err != nil {
+			return FieldStats{}, fmt.Errorf("failed to decode memtable document: %w", err)
+		}
+		if length, ok := documentNorms(im.Schema, doc)[field]; ok {
+			stats.DocCount++
+			stats.TotalLength += int64(length)
+		}
+	}
+	for _, seg := range im.segments {
+		for id, length := range seg.FieldNorms(field) {
+			if _, buffered := view[id]; !buffered {
+				stats.DocCount++
+				stats.TotalLength += int64(length)
+			}
+		}
+	}
+	return stats, nil
+}
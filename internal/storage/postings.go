@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"nano-elastic/internal/index/inverted"
+	"nano-elastic/internal/types"
+)
+
+// Postings sidecar file: [magic "NSIV"][version:uint16][crc:uint32][postings]
+// The postings payload is [len:uint16][analysis key] and then the segment's
+// text postings in the inverted index segment format (see inverted.WriteSegment):
+// varint, gap-encoded postings under a sorted, prefix-compressed dictionary
+// The sidecar is written once, when the segment is sealed; deletes leave it
+// alone, since readers skip the documents that aren't live
+const (
+	PostingsMagic   = "NSIV"
+	PostingsVersion = 1
+)
+
+// postingsHeaderSize is the size of the magic, version and checksum prefix
+const postingsHeaderSize = 4 + 2 + 4
+
+// postingsPath returns the path of the postings sidecar file
+func (s *SegmentReader) postingsPath() string {
+	return strings.TrimSuffix(s.Path, ".dat") + ".inv"
+}
+
+// analysisKey describes everything in a schema that decides how documents'
+// text is indexed: text fields and their analyzers, copy_to sources and
+// unindexed fields
+// Postings stored under another key are not used, since analyzing the
+// documents again would give different ones
+func analysisKey(schema *types.Schema) string {
+	names := make([]string, 0, len(schema.Fields))
+	for name := range schema.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var key strings.Builder
+	for _, name := range names {
+		def := schema.Fields[name]
+		if def.Type != types.FieldTypeText && len(def.CopyTo) == 0 && def.Indexed {
+			continue
+		}
+		fmt.Fprintf(&key, "%s:%s:%t", name, def.Type, def.Indexed)
+		if def.Type == types.FieldTypeText {
+			key.WriteString(":" + schema.FieldAnalyzerName(def))
+		}
+		if len(def.CopyTo) > 0 {
+			key.WriteString(">" + strings.Join(def.CopyTo, ","))
+		}
+		key.WriteString(";")
+	}
+	return key.String()
+}
+
+// newSegmentPostings creates the inverted index a segment writer adds its
+// documents' text to, or nil for writers without a schema
+func newSegmentPostings(schema *types.Schema) (*inverted.InvertedIndex, error) {
+	if schema == nil {
+		return nil, nil
+	}
+	postings, err := inverted.NewInvertedIndexForSchema(schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create segment postings: %w", err)
+	}
+	return postings, nil
+}
+
+// writePostings persists the text postings of the segment's documents,
+// analyzed under schema, atomically (write temp file, then rename)
+// Caller must hold s.mu
+func (s *SegmentReader) writePostings(postings *inverted.InvertedIndex, schema *types.Schema) error {
+	key := analysisKey(schema)
+	var payload bytes.Buffer
+	payload.Write(binary.LittleEndian.AppendUint16(nil, uint16(len(key))))
+	payload.WriteString(key)
+	if err := inverted.WriteSegment(&payload, postings); err != nil {
+		return fmt.Errorf("failed to encode postings: %w", err)
+	}
+
+	data := make([]byte, postingsHeaderSize, postingsHeaderSize+payload.Len())
+	copy(data[0:4], PostingsMagic)
+	binary.LittleEndian.PutUint16(data[4:6], PostingsVersion)
+	binary.LittleEndian.PutUint32(data[6:10], checksum(payload.Bytes()))
+	return s.writePostingsFile(append(data, payload.Bytes()...))
+}
+
+// writePostingsFile seals and writes the postings sidecar's contents
+// Caller must hold s.mu
+func (s *SegmentReader) writePostingsFile(data []byte) error {
+	data, err := s.sealSidecar(s.postingsPath(), data)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.postingsPath() + ".tmp"
+	if err := s.fs.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write postings: %w", err)
+	}
+	if err := s.fs.Rename(tmpPath, s.postingsPath()); err != nil {
+		return fmt.Errorf("failed to commit postings: %w", err)
+	}
+	return nil
+}
+
+// resealPostings writes the postings sidecar again under the current key
+// Caller must hold s.mu
+func (s *SegmentReader) resealPostings() error {
+	data, _, err := s.readSidecar(s.postingsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read postings: %w", err)
+	}
+	return s.writePostingsFile(data)
+}
+
+// Postings returns the segment's stored text postings, in the inverted index
+// segment format, if they were analyzed the way schema analyzes text
+// nil means the documents' text must be analyzed again: the segment predates
+// stored postings, lost them in a crash, or was written under another schema
+func (s *SegmentReader) Postings(schema *types.Schema) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, _, err := s.readSidecar(s.postingsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read postings: %w", err)
+	}
+
+	if len(data) < postingsHeaderSize || string(data[0:4]) != PostingsMagic {
+		return nil, &CorruptionError{Path: s.postingsPath(), Offset: 0, Reason: "invalid postings header"}
+	}
+	if version := binary.LittleEndian.Uint16(data[4:6]); version != PostingsVersion {
+		return nil, fmt.Errorf("unsupported postings version %d (expected %d)", version, PostingsVersion)
+	}
+	payload := data[postingsHeaderSize:]
+	if err := verifyChecksum(s.postingsPath(), 0, payload, binary.LittleEndian.Uint32(data[6:10])); err != nil {
+		return nil, err
+	}
+
+	if len(payload) < 2 || len(payload) < 2+int(binary.LittleEndian.Uint16(payload)) {
+		return nil, &CorruptionError{Path: s.postingsPath(), Offset: postingsHeaderSize, Reason: "truncated analysis key"}
+	}
+	keyLen := int(binary.LittleEndian.Uint16(payload))
+	if string(payload[2:2+keyLen]) != analysisKey(schema) {
+		return nil, nil
+	}
+	return payload[2+keyLen:], nil
+}
+
+// LoadPostings adds the text postings stored with the segments to index, for
+// the documents whose live copy each segment holds, and returns their IDs
+// The caller indexes the text of every other document itself: those in the
+// memtables and in segments without usable postings (see SegmentReader.Postings)
+func (im *IndexManager) LoadPostings(index *inverted.InvertedIndex) (map[string]bool, error) {
+	sn := im.AcquireSnapshot()
+	defer sn.Release()
+
+	loaded := make(map[string]bool)
+	for _, ss := range sn.segments {
+		data, err := ss.seg.Postings(sn.schema)
+		if err != nil {
+			return nil, fmt.Errorf("segment %s: %w", ss.seg.ID, err)
+		}
+		if data == nil {
+			continue
+		}
+		live := func(id string) bool {
+			_, ok := ss.offsets[id]
+			return ok
+		}
+		if err := index.LoadSegment(bytes.NewReader(data), live); err != nil {
+			return nil, &CorruptionError{Path: ss.seg.postingsPath(), Offset: postingsHeaderSize, Reason: err.Error()}
+		}
+		for id := range ss.offsets {
+			loaded[id] = true
+		}
+	}
+	return loaded, nil
+}
@@ -0,0 +1,11 @@
+//go:build linux
+
+package storage
+
+import "syscall"
+
+// adviseWillNeed asks the kernel to start reading a mapping's pages ahead of
+// a sequential pass over them
+func adviseWillNeed(data []byte) error {
+	return syscall.Madvise(data, syscall.MADV_WILLNEED)
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package storage
+
+// adviseWillNeed is unsupported on this platform; pages are read on demand
+func adviseWillNeed(data []byte) error {
+	return nil
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"nano-elastic/internal/types"
+)
+
+// SchemaFile is the name of the schema file kept in each index directory
+const SchemaFile = "schema.json"
+
+// SchemaMismatchError is returned when an index is opened with a schema that
+// isn't the stored one or a declared migration of it
+type SchemaMismatchError struct {
+	Index  string
+	Reason error
+}
+
+func (e *SchemaMismatchError) Error() string {
+	return fmt.Sprintf("schema mismatch for index %s: %v", e.Index, e.Reason)
+}
+
+func (e *SchemaMismatchError) Unwrap() error {
+	return e.Reason
+}
+
+// LoadSchema reads the schema saved in an index directory
+// The error satisfies errors.Is(err, os.ErrNotExist) if the index has no schema file
+func LoadSchema(fs FS, basePath string, name string) (*types.Schema, error) {
+	return readSchemaFile(fs, filepath.Join(basePath, name, SchemaFile))
+}
+
+// syncSchema checks the index's schema against the stored one and saves it
+// A newer schema must be reachable from the stored one through its migrations;
+// an older one is replaced by the stored schema if that only adds dynamic fields
+// Caller must hold im.mu (or be opening the index)
+func (im *IndexManager) syncSchema() error {
+	path := filepath.Join(im.BasePath, SchemaFile)
+	stored, err := readSchemaFile(im.fs, path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if im.Schema == nil {
+		im.Schema = stored
+		if im.Schema == nil {
+			im.Schema = types.NewSchema(im.Name)
+		}
+	}
+
+	if stored != nil {
+		// Settings are changed through UpdateSettings, so a schema declared
+		// without them keeps the stored ones
+		if im.Schema.Settings == nil && stored.Settings != nil {
+			im.Schema = im.Schema.WithSettings(*stored.Settings)
+		}
+		// Fields mapped dynamically since the given schema was declared are kept
+		if stored.DynamicallyExtends(im.Schema) {
+			im.Schema = stored
+			return nil
+		}
+		if err := im.Schema.CheckCompatible(stored); err != nil {
+			return &SchemaMismatchError{Index: im.Name, Reason: err}
+		}
+		if stored.Version == im.Schema.Version {
+			return nil
+		}
+	}
+	return writeSchemaFile(im.fs, path, im.Schema)
+}
+
+// UpdateSchema replaces the index's schema with a migration of it and saves it
+func (im *IndexManager) UpdateSchema(schema *types.Schema) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if err := schema.CheckCompatible(im.Schema); err != nil {
+		return &SchemaMismatchError{Index: im.Name, Reason: err}
+	}
+	if err := writeSchemaFile(im.fs, filepath.Join(im.BasePath, SchemaFile), schema); err != nil {
+		return err
+	}
+	im.Schema = schema
+	return nil
+}
+
+// readSchemaFile decodes a schema file
+func readSchemaFile(fs FS, path string) (*types.Schema, error) {
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var schema types.Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("failed to decode schema %s: %w", path, err)
+	}
+	if schema.Fields == nil {
+		schema.Fields = make(map[string]types.FieldDef)
+	}
+	return &schema, nil
+}
+
+// writeSchemaFile saves a schema atomically (temp file and rename)
+func writeSchemaFile(fs FS, path string, schema *types.Schema) error {
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode schema: %w", err)
+	}
+	if err := fs.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write schema: %w", err)
+	}
+	if err := fs.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to commit schema: %w", err)
+	}
+	return nil
+}
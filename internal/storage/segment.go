@@ -3,27 +3,58 @@ package storage
 import (
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
-	"time"
 
+	"nano-elastic/internal/errdefs"
+	"nano-elastic/internal/index/docvalues"
+	"nano-elastic/internal/index/hll"
 	"nano-elastic/internal/types"
 )
 
-// Segment represents a storage segment containing documents
-type Segment struct {
+// SegmentReader is a sealed segment: its records are immutable, read from a
+// read-only file (mapped once mapRecords is called), and only its sidecars
+// (tombstones, doc values, norms) change afterwards
+// New segments are built by a SegmentWriter
+type SegmentReader struct {
 	ID          string
 	Path        string
 	DocCount    int
 	Size        int64
 	Created     int64
 	Version     int
+	Encoding    int              // RecordEncodingJSON or RecordEncodingBinary
+	Sequence    uint64           // Highest WAL sequence of the writes in the segment, 0 if unknown
+	MinSequence uint64           // Lowest WAL sequence of the writes in the segment, kept in the manifest, 0 if unknown
+	fs          FS               // Where the segment's files live
 	mu          sync.RWMutex
-	file        *os.File
-	docIndex    map[string]int64 // Document ID -> file offset
+	file        File
+	mapped      []byte           // Header and records, mapped read-only by mapRecords
+	blocks      []storedBlock    // Block index of a compressed segment, nil for plain records
+	blockCache  blockCache
+	encryptor   *encryptor       // Encrypts and decrypts blocks, nil without encryption (see WithEncryption)
+	docIndex    map[string]int64 // Document ID -> record offset (in the uncompressed layout), persisted in the .idx sidecar
+	idxDirty    bool             // Whether docIndex has changes not yet persisted
+	deleted     map[string]bool  // Tombstoned document IDs, persisted in the .del sidecar
+	delDirty    bool             // Whether deleted has changes not yet persisted
+	docValues   docvalues.Columns // Per-field values, persisted in the .dv sidecar
+	dvDirty     bool             // Whether docValues has changes not yet persisted
+	norms       FieldNorms       // Text field lengths, persisted in the .nrm sidecar
+	nrmDirty    bool             // Whether norms has changes not yet persisted
+	sketches    map[string]*hll.Sketch // Cardinality sketches of every record's doc values, persisted in the .hll sidecar
+	hllDirty    bool             // Whether sketches has changes not yet persisted
+	schema      *types.Schema    // Analyzers for norms, set by the IndexManager before Open
+	generation  uint64           // Bumped by every delete, so snapshots can tell unchanged contents
+	refs        int              // Open snapshots reading this segment
+	removePending bool           // Remove was called while snapshots held the segment
+	manifestInfo  *ManifestSegment // Manifest entry, cached while generation is infoGeneration (see info)
+	infoGeneration uint64
 	initialized bool
 }
 
@@ -33,33 +64,47 @@ type SegmentHeader struct {
 	Version      uint16
 	DocCount     uint32
 	Created      int64
-	IndexOffset  int64  // Offset where the index starts (at end of file)
-	Reserved     [8]byte // Reserved for future use
+	IndexOffset  int64  // Offset of the doc index in segments from before the .idx sidecar, 0 otherwise
+	Encoding     uint8   // Document encoding of the records (RecordEncodingJSON or RecordEncodingBinary)
+	Reserved     [7]byte // Reserved for future use
 }
 
 const (
 	SegmentMagic = "NSEG"
-	SegmentVersion = 1
+	SegmentVersion = 2 // Version 2 adds a CRC32 checksum to every document record
+	SegmentVersionCompressed = 3 // Version 3 stores the records in compressed blocks (see blocks.go)
 )
 
-// NewSegment creates a new segment
-func NewSegment(id string, basePath string) (*Segment, error) {
-	segmentPath := filepath.Join(basePath, fmt.Sprintf("segment_%s.dat", id))
-	
-	seg := &Segment{
-		ID:       id,
-		Path:     segmentPath,
-		DocCount: 0,
-		Version:  SegmentVersion,
-		docIndex: make(map[string]int64),
-		Created:  time.Now().Unix(),
+const (
+	// RecordEncodingJSON marks segments created before the binary encoding
+	// Their records are JSON documents, plus binary ones if they were appended to since
+	RecordEncodingJSON = 0
+	// RecordEncodingBinary marks segments whose records all use the binary document encoding
+	RecordEncodingBinary = 1
+)
+
+// segmentPath returns the path of a segment's data file
+func segmentPath(basePath string, id string) string {
+	return filepath.Join(basePath, fmt.Sprintf("segment_%s.dat", id))
+}
+
+// OpenSegment opens an existing segment for reading
+// schema supplies the analyzers for norms missing from older segments
+func OpenSegment(fs FS, id string, basePath string, schema *types.Schema) (*SegmentReader, error) {
+	seg := &SegmentReader{
+		ID:     id,
+		Path:   segmentPath(basePath, id),
+		fs:     fs,
+		schema: schema,
+	}
+	if err := seg.Open(); err != nil {
+		return nil, err
 	}
-	
 	return seg, nil
 }
 
-// Open opens an existing segment file
-func (s *Segment) Open() error {
+// Open opens the segment file read-only and loads its doc index and sidecars
+func (s *SegmentReader) Open() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	
@@ -68,61 +113,72 @@ func (s *Segment) Open() error {
 	}
 	
 	var err error
-	s.file, err = os.OpenFile(s.Path, os.O_RDWR|os.O_CREATE, 0644)
+	s.file, err = s.fs.Open(s.Path)
 	if err != nil {
 		return fmt.Errorf("failed to open segment file: %w", err)
 	}
+	defer func() {
+		if !s.initialized {
+			s.file.Close()
+			s.file = nil
+		}
+	}()
 	
-	// Check if file is empty (new segment)
 	stat, err := s.file.Stat()
 	if err != nil {
 		return fmt.Errorf("failed to stat segment file: %w", err)
 	}
 	
-	if stat.Size() == 0 {
-		// Write header for new segment
-		if err := s.writeHeader(); err != nil {
-			return err
-		}
-		s.initialized = true
-		return nil
-	}
-	
 	// Read header from existing segment
 	header, err := s.readHeader()
 	if err != nil {
 		return err
 	}
 	
-	// Read document index (it's at the end, position stored in header)
-	if header.IndexOffset > 0 {
-		if err := s.readIndexAt(header.IndexOffset); err != nil {
+	// Size covers header and document records, not a trailing doc index
+	s.Size = stat.Size()
+	if header.IndexOffset > 0 && header.IndexOffset < stat.Size() {
+		s.Size = header.IndexOffset
+	}
+	
+	// Compressed segments are read through their block index
+	if s.Version == SegmentVersionCompressed {
+		if err := s.readBlockIndex(); err != nil {
 			return err
 		}
 	}
 	
-	s.initialized = true
-	return nil
-}
-
-// writeHeader writes the segment header
-func (s *Segment) writeHeader() error {
-	header := SegmentHeader{
-		Version:  SegmentVersion,
-		DocCount: uint32(s.DocCount),
-		Created:  s.Created,
+	// Read document index (see loadIndex)
+	if err := s.loadIndex(header, stat.Size()); err != nil {
+		return err
+	}
+	
+	// Read tombstones
+	if err := s.readDeletes(); err != nil {
+		return err
 	}
-	copy(header.Magic[:], SegmentMagic)
 	
-	if err := binary.Write(s.file, binary.LittleEndian, header); err != nil {
-		return fmt.Errorf("failed to write segment header: %w", err)
+	// Read doc values
+	if err := s.readDocValues(); err != nil {
+		return err
+	}
+	
+	// Read norms
+	if err := s.readNorms(); err != nil {
+		return err
+	}
+	
+	// Read cardinality sketches, after the doc values they are built from
+	if err := s.readSketches(); err != nil {
+		return err
 	}
 	
+	s.initialized = true
 	return nil
 }
 
 // readHeader reads the segment header
-func (s *Segment) readHeader() (*SegmentHeader, error) {
+func (s *SegmentReader) readHeader() (*SegmentHeader, error) {
 	var header SegmentHeader
 	if err := binary.Read(s.file, binary.LittleEndian, &header); err != nil {
 		return nil, fmt.Errorf("failed to read segment header: %w", err)
@@ -133,15 +189,24 @@ func (s *Segment) readHeader() (*SegmentHeader, error) {
 		return nil, fmt.Errorf("invalid segment magic number")
 	}
 	
+	if header.Version != SegmentVersion && header.Version != SegmentVersionCompressed {
+		return nil, fmt.Errorf("unsupported segment version %d (expected %d or %d)", header.Version, SegmentVersion, SegmentVersionCompressed)
+	}
+	
+	if header.Encoding > RecordEncodingBinary {
+		return nil, fmt.Errorf("unsupported record encoding %d (expected at most %d)", header.Encoding, RecordEncodingBinary)
+	}
+	
 	s.Version = int(header.Version)
+	s.Encoding = int(header.Encoding)
 	s.DocCount = int(header.DocCount)
 	s.Created = header.Created
 	
 	return &header, nil
 }
 
-// readIndexAt reads the index from a specific offset
-func (s *Segment) readIndexAt(offset int64) error {
+// readIndexAt reads the index written at the end of segments from before the sidecar
+func (s *SegmentReader) readIndexAt(offset int64) error {
 	if offset <= 0 {
 		// No index yet
 		s.docIndex = make(map[string]int64)
@@ -185,314 +250,375 @@ func (s *Segment) readIndexAt(offset int64) error {
 		s.docIndex[string(idBytes)] = docOffset
 	}
 	
+	// Segments written before sequences were recorded end with the entries
+	if err := binary.Read(s.file, binary.LittleEndian, &s.Sequence); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read segment sequence: %w", err)
+	}
+	
 	return nil
 }
 
 
-// writeIndex writes the document index to the end of the segment file
-func (s *Segment) writeIndex() error {
-	// Seek to end of file (where index will be written)
-	indexOffset, err := s.file.Seek(0, io.SeekEnd)
-	if err != nil {
-		return fmt.Errorf("failed to seek to end: %w", err)
-	}
+
+// ReadDocument reads a document from the segment by ID
+func (s *SegmentReader) ReadDocument(id string) (*types.Document, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	
-	// Write index count
-	count := uint32(len(s.docIndex))
-	if err := binary.Write(s.file, binary.LittleEndian, count); err != nil {
-		return fmt.Errorf("failed to write index count: %w", err)
+	if !s.initialized {
+		if err := s.Open(); err != nil {
+			return nil, err
+		}
 	}
 	
-	// Write index entries
-	for id, offset := range s.docIndex {
-		idBytes := []byte(id)
-		idLen := uint16(len(idBytes))
-		
-		// Write ID length
-		if err := binary.Write(s.file, binary.LittleEndian, idLen); err != nil {
-			return fmt.Errorf("failed to write ID length: %w", err)
-		}
-		
-		// Write ID
-		if _, err := s.file.Write(idBytes); err != nil {
-			return fmt.Errorf("failed to write ID: %w", err)
-		}
-		
-		// Write offset
-		if err := binary.Write(s.file, binary.LittleEndian, offset); err != nil {
-			return fmt.Errorf("failed to write offset: %w", err)
-		}
+	// Ensure index is initialized
+	if s.docIndex == nil {
+		s.docIndex = make(map[string]int64)
 	}
 	
-	// Update header with index offset
-	currentPos, _ := s.file.Seek(0, io.SeekCurrent)
-	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
-		return err
+	offset, ok := s.docIndex[id]
+	if !ok || s.deleted[id] {
+		return nil, &errdefs.DocNotFoundError{ID: id}
 	}
 	
-	var header SegmentHeader
-	if err := binary.Read(s.file, binary.LittleEndian, &header); err != nil {
-		return err
+	return s.readRecord(offset)
+}
+
+// readRecord reads and verifies the document record at offset
+// Compressed segments decompress the record's block and other sealed segments
+// read from their mapping; the rest use positional reads, so concurrent readers
+// don't race on the file offset
+// Caller must hold s.mu
+func (s *SegmentReader) readRecord(offset int64) (*types.Document, error) {
+	if s.blocks != nil {
+		return s.blockRecord(offset)
+	}
+	if s.mapped != nil {
+		docBytes, docCRC, err := s.mappedRecord(offset)
+		if err != nil {
+			return nil, err
+		}
+		return decodeRecord(s.Path, offset, docBytes, docCRC)
 	}
 	
-	header.IndexOffset = indexOffset
-	header.DocCount = uint32(s.DocCount)
+	// Read document length and checksum
+	var prefix [8]byte
+	if _, err := s.file.ReadAt(prefix[:], offset); err != nil {
+		return nil, &CorruptionError{Path: s.Path, Offset: offset, Reason: "truncated record header"}
+	}
+	docLen := binary.LittleEndian.Uint32(prefix[0:4])
+	docCRC := binary.LittleEndian.Uint32(prefix[4:8])
 	
-	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
-		return err
+	// Read document data
+	docBytes := make([]byte, docLen)
+	if _, err := s.file.ReadAt(docBytes, offset+int64(len(prefix))); err != nil {
+		return nil, &CorruptionError{Path: s.Path, Offset: offset, Reason: "truncated record data"}
 	}
 	
-	if err := binary.Write(s.file, binary.LittleEndian, header); err != nil {
-		return err
+	return decodeRecord(s.Path, offset, docBytes, docCRC)
+}
+
+// decodeRecord verifies and decodes the document bytes of a record
+func decodeRecord(path string, offset int64, docBytes []byte, docCRC uint32) (*types.Document, error) {
+	if err := verifyChecksum(path, offset, docBytes, docCRC); err != nil {
+		return nil, err
 	}
 	
-	// Restore position
-	if _, err := s.file.Seek(currentPos, io.SeekStart); err != nil {
-		return err
+	// Deserialize document, binary or from a JSON segment
+	doc, err := types.DecodeDocument(docBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode document: %w", err)
 	}
 	
-	return nil
+	return doc, nil
 }
 
-// WriteDocument writes a document to the segment
-func (s *Segment) WriteDocument(doc *types.Document) error {
+// Flush writes the index to disk
+func (s *SegmentReader) Flush() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	
-	if !s.initialized {
-		if err := s.Open(); err != nil {
-			return err
-		}
-	}
-	
-	// Serialize document to JSON
-	docBytes, err := json.Marshal(doc)
-	if err != nil {
-		return fmt.Errorf("failed to marshal document: %w", err)
+	if !s.initialized || s.file == nil {
+		return nil
 	}
 	
-	// Get current file size to determine where to write
-	stat, err := s.file.Stat()
-	if err != nil {
-		return fmt.Errorf("failed to stat file: %w", err)
+	// Write the doc index sidecar
+	if err := s.writeIndex(); err != nil {
+		return err
 	}
 	
-	headerSize := int64(binary.Size(SegmentHeader{}))
-	
-	// If file only has header, start writing documents after header
-	// Otherwise, if there's an index at the end, we need to remove it first
-	// For simplicity, we'll append documents and rewrite index at end
-	var writeOffset int64
-	
-	if stat.Size() == headerSize {
-		// New segment, write after header
-		writeOffset = headerSize
-		if _, err := s.file.Seek(writeOffset, io.SeekStart); err != nil {
-			return fmt.Errorf("failed to seek: %w", err)
-		}
-	} else {
-		// Existing segment, check if there's an index at the end
-		// Read header to get index offset
-		if _, err := s.file.Seek(0, io.SeekStart); err != nil {
-			return err
-		}
-		var header SegmentHeader
-		if err := binary.Read(s.file, binary.LittleEndian, &header); err != nil {
-			return err
-		}
-		
-		if header.IndexOffset > 0 {
-			// Truncate file to remove old index
-			if err := s.file.Truncate(header.IndexOffset); err != nil {
-				return err
-			}
-			writeOffset = header.IndexOffset
-		} else {
-			// No index yet, append
-			writeOffset = stat.Size()
-		}
-		
-		if _, err := s.file.Seek(writeOffset, io.SeekStart); err != nil {
-			return fmt.Errorf("failed to seek: %w", err)
-		}
+	if err := s.writeDeletes(); err != nil {
+		return err
 	}
 	
-	// Write document length
-	docLen := uint32(len(docBytes))
-	if err := binary.Write(s.file, binary.LittleEndian, docLen); err != nil {
-		return fmt.Errorf("failed to write document length: %w", err)
+	if err := s.writeDocValues(); err != nil {
+		return err
 	}
 	
-	// Write document data
-	if _, err := s.file.Write(docBytes); err != nil {
-		return fmt.Errorf("failed to write document: %w", err)
+	if err := s.writeNorms(); err != nil {
+		return err
 	}
 	
-	// Update index in memory FIRST (before writing to disk)
-	if s.docIndex == nil {
-		s.docIndex = make(map[string]int64)
-	}
-	s.docIndex[doc.ID] = writeOffset
+	return s.writeSketches()
+}
+
+// Close closes the segment file
+func (s *SegmentReader) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	
-	// Debug: verify index was updated
-	if _, exists := s.docIndex[doc.ID]; !exists {
-		return fmt.Errorf("failed to update index for document %s", doc.ID)
+	// Write the sidecars before closing; a failure doesn't stop the close,
+	// and is returned once the file is closed
+	var sidecarErr error
+	if s.initialized && s.file != nil {
+		sidecarErr = errors.Join(s.writeIndex(), s.writeDeletes(), s.writeDocValues(), s.writeNorms(), s.writeSketches())
 	}
 	
-	// Update document count
-	s.DocCount++
-	
-	// Update header (but don't write index yet - keep it in memory for now)
-	if err := s.updateHeader(); err != nil {
+	if err := s.unmap(); err != nil {
 		return err
 	}
-	
-	// Sync to disk (document is written, index stays in memory)
-	if err := s.file.Sync(); err != nil {
-		return fmt.Errorf("failed to sync segment: %w", err)
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			return err
+		}
+		s.file = nil
 	}
 	
+	s.initialized = false
+	if sidecarErr != nil {
+		return fmt.Errorf("failed to write segment %s metadata: %w", s.ID, sidecarErr)
+	}
 	return nil
 }
 
+// GetDocCount returns the number of documents in the segment
+func (s *SegmentReader) GetDocCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.DocCount
+}
 
-// ReadDocument reads a document from the segment by ID
-func (s *Segment) ReadDocument(id string) (*types.Document, error) {
+// GetEncoding returns the document encoding of the segment's records
+func (s *SegmentReader) GetEncoding() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Encoding
+}
+
+// GetSize returns the size in bytes of the segment's header and document records
+func (s *SegmentReader) GetSize() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Size
+}
+
+// GetAllDocIDs returns all live (non-deleted) document IDs in the segment
+func (s *SegmentReader) GetAllDocIDs() []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	
-	if !s.initialized {
-		if err := s.Open(); err != nil {
-			return nil, err
+	ids := make([]string, 0, len(s.docIndex))
+	for id := range s.docIndex {
+		if !s.deleted[id] {
+			ids = append(ids, id)
 		}
 	}
+	return ids
+}
+
+
+// GetLiveDocCount returns the number of distinct, non-deleted documents in the segment
+func (s *SegmentReader) GetLiveDocCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.docIndex) - len(s.deleted)
+}
+
+// GetDeletedCount returns the number of tombstoned documents in the segment
+func (s *SegmentReader) GetDeletedCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.deleted)
+}
+
+// Contains reports whether the segment holds a live copy of the document
+func (s *SegmentReader) Contains(id string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.docIndex[id]
+	return ok && !s.deleted[id]
+}
+
+// Delete tombstones a document in this segment
+// The record stays on disk until the segment is merged away
+// Returns false if the segment has no live copy of the document
+func (s *SegmentReader) Delete(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	
-	// Ensure index is initialized
-	if s.docIndex == nil {
-		s.docIndex = make(map[string]int64)
+	if _, ok := s.docIndex[id]; !ok || s.deleted[id] {
+		return false
 	}
 	
-	offset, ok := s.docIndex[id]
-	if !ok {
-		// Debug: show what IDs we have
-		ids := make([]string, 0, len(s.docIndex))
-		for k := range s.docIndex {
-			ids = append(ids, k)
-		}
-		if len(ids) == 0 {
-			return nil, fmt.Errorf("document not found: %s (index is empty, segment: %s, initialized: %v)", id, s.ID, s.initialized)
-		}
-		return nil, fmt.Errorf("document not found: %s (available in segment %s: %v)", id, s.ID, ids)
-	}
+	s.deleted[id] = true
+	s.delDirty = true
+	s.generation++
+	return true
+}
+
+// liveDocIDsByOffset returns live document IDs in on-disk order
+// Reading in this order keeps merges sequential
+func (s *SegmentReader) liveDocIDsByOffset() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	
-	// Seek to document position
-	if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
-		return nil, fmt.Errorf("failed to seek to document: %w", err)
+	ids := make([]string, 0, len(s.docIndex))
+	for id := range s.docIndex {
+		if !s.deleted[id] {
+			ids = append(ids, id)
+		}
 	}
+	sort.Slice(ids, func(i, j int) bool {
+		return s.docIndex[ids[i]] < s.docIndex[ids[j]]
+	})
+	return ids
+}
+
+// deletesPath returns the path of the tombstone sidecar file
+func (s *SegmentReader) deletesPath() string {
+	return strings.TrimSuffix(s.Path, ".dat") + ".del"
+}
+
+// readDeletes loads tombstones from the sidecar file, if present
+func (s *SegmentReader) readDeletes() error {
+	s.deleted = make(map[string]bool)
 	
-	// Read document length
-	var docLen uint32
-	if err := binary.Read(s.file, binary.LittleEndian, &docLen); err != nil {
-		return nil, fmt.Errorf("failed to read document length: %w", err)
+	data, stale, err := s.readSidecar(s.deletesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read tombstones: %w", err)
 	}
 	
-	// Read document data
-	docBytes := make([]byte, docLen)
-	if _, err := io.ReadFull(s.file, docBytes); err != nil {
-		return nil, fmt.Errorf("failed to read document: %w", err)
+	var ids []string
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return fmt.Errorf("failed to decode tombstones: %w", err)
 	}
-	
-	// Deserialize document
-	var doc types.Document
-	if err := json.Unmarshal(docBytes, &doc); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal document: %w", err)
+	for _, id := range ids {
+		s.deleted[id] = true
 	}
+	s.delDirty = stale
 	
-	return &doc, nil
+	return nil
 }
 
-// updateHeader updates the segment header with current values
-func (s *Segment) updateHeader() error {
-	currentPos, err := s.file.Seek(0, io.SeekCurrent)
-	if err != nil {
-		return err
+// writeDeletes persists tombstones atomically (write temp file, then rename)
+func (s *SegmentReader) writeDeletes() error {
+	if !s.delDirty {
+		return nil
 	}
 	
-	// Seek to beginning
-	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+	data, err := s.encodeDeletes()
+	if err == nil {
+		data, err = s.sealSidecar(s.deletesPath(), data)
+	}
+	if err != nil {
 		return err
 	}
 	
-	// Update created time if not set
-	if s.Created == 0 {
-		s.Created = time.Now().Unix()
+	tmpPath := s.deletesPath() + ".tmp"
+	if err := s.fs.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write tombstones: %w", err)
 	}
-	
-	// Write updated header
-	if err := s.writeHeader(); err != nil {
-		return err
+	if err := s.fs.Rename(tmpPath, s.deletesPath()); err != nil {
+		return fmt.Errorf("failed to commit tombstones: %w", err)
 	}
 	
-	// Restore position
-	if _, err := s.file.Seek(currentPos, io.SeekStart); err != nil {
-		return err
+	s.delDirty = false
+	return nil
+}
+
+// encodeDeletes returns the contents of the tombstone sidecar file: the sorted deleted IDs
+// Caller must hold s.mu
+func (s *SegmentReader) encodeDeletes() ([]byte, error) {
+	ids := make([]string, 0, len(s.deleted))
+	for id := range s.deleted {
+		ids = append(ids, id)
 	}
+	sort.Strings(ids)
 	
-	return nil
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode tombstones: %w", err)
+	}
+	return data, nil
 }
 
-// Flush writes the index to disk
-func (s *Segment) Flush() error {
+// Remove closes the segment and deletes its files from disk
+// Used once a segment has been merged into a new one
+// If snapshots still hold the segment, removal is deferred until the last one is released
+func (s *SegmentReader) Remove() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	
-	if !s.initialized || s.file == nil {
+	if s.refs > 0 {
+		s.removePending = true
 		return nil
 	}
-	
-	// Write index at end
-	return s.writeIndex()
+	return s.remove()
 }
 
-// Close closes the segment file
-func (s *Segment) Close() error {
+// acquire pins the segment's files for a snapshot
+func (s *SegmentReader) acquire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs++
+}
+
+// release unpins the segment, completing a deferred Remove if this was the last reference
+func (s *SegmentReader) release() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	
-	// Flush index before closing
-	if s.initialized && s.file != nil {
-		if err := s.writeIndex(); err != nil {
-			// Log error but continue with close
-		}
+	s.refs--
+	if s.refs == 0 && s.removePending {
+		return s.remove()
 	}
-	
+	return nil
+}
+
+// remove closes and deletes the segment files
+// Caller must hold s.mu
+func (s *SegmentReader) remove() error {
+	s.unmap()
 	if s.file != nil {
-		if err := s.file.Close(); err != nil {
-			return err
-		}
+		s.file.Close()
 		s.file = nil
 	}
-	
 	s.initialized = false
-	return nil
-}
-
-// GetDocCount returns the number of documents in the segment
-func (s *Segment) GetDocCount() int {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.DocCount
-}
-
-// GetAllDocIDs returns all document IDs in the segment
-func (s *Segment) GetAllDocIDs() []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
 	
-	ids := make([]string, 0, len(s.docIndex))
-	for id := range s.docIndex {
-		ids = append(ids, id)
+	if err := s.fs.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove segment file: %w", err)
 	}
-	return ids
+	if err := s.fs.Remove(s.deletesPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove tombstone file: %w", err)
+	}
+	if err := s.fs.Remove(s.docValuesPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove doc values file: %w", err)
+	}
+	if err := s.fs.Remove(s.normsPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove norms file: %w", err)
+	}
+	if err := s.fs.Remove(s.indexPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove doc index file: %w", err)
+	}
+	if err := s.fs.Remove(s.sketchesPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove cardinality sketch file: %w", err)
+	}
+	if err := s.fs.Remove(s.postingsPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove postings file: %w", err)
+	}
+	
+	return nil
 }
-
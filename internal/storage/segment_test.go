@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"nano-elastic/internal/types"
+)
+
+// fieldTypeDocs returns one document per field type, each with the value in
+// field "v" and the ID of its type
+func fieldTypeDocs() []*types.Document {
+	values := []types.FieldValue{
+		types.TextValue{Value: "the quick brown fox"},
+		types.KeywordValue{Value: "SKU-123"},
+		types.NumericValue{Value: -12.375},
+		types.BooleanValue{Value: true},
+		types.DateValue{Value: time.Date(2024, 2, 29, 13, 4, 5, 123456789, time.UTC)},
+		types.VectorValue{Value: []float32{0.5, -1.25, 3}, Dim: 3},
+		types.GeoPointValue{Lat: 52.52, Lon: 13.405},
+		types.CompletionValue{Inputs: []types.CompletionInput{{Input: "nano", Weight: 3}}},
+		types.ObjectValue{Fields: map[string]types.FieldValue{
+			"since": types.DateValue{Value: time.Date(1969, 7, 20, 20, 17, 40, 0, time.UTC)},
+			"city":  types.KeywordValue{Value: "Berlin"},
+		}},
+	}
+
+	docs := make([]*types.Document, len(values))
+	for i, value := range values {
+		docs[i] = &types.Document{
+			ID:      string(value.Type()),
+			Fields:  map[string]types.FieldValue{"v": value},
+			Version: int64(i + 1),
+			Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			Updated: time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC),
+		}
+	}
+	return docs
+}
+
+func TestSegmentFieldTypeRoundTrip(t *testing.T) {
+	for _, codec := range codecs {
+		t.Run(codec.Name(), func(t *testing.T) {
+			fs := NewMemFS()
+			if err := fs.MkdirAll("data", 0755); err != nil {
+				t.Fatal(err)
+			}
+			w, err := NewSegmentWriter(fs, "1", "data", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			docs := fieldTypeDocs()
+			if err := w.WriteDocuments(docs); err != nil {
+				t.Fatal(err)
+			}
+			seg, err := w.Seal(codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			check := func(seg *SegmentReader) {
+				t.Helper()
+				for _, want := range docs {
+					got, err := seg.ReadDocument(want.ID)
+					if err != nil {
+						t.Fatalf("read %s: %v", want.ID, err)
+					}
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf("%s = %#v, want %#v", want.ID, got, want)
+					}
+				}
+			}
+			check(seg)
+
+			if err := seg.Close(); err != nil {
+				t.Fatal(err)
+			}
+			seg, err = OpenSegment(fs, "1", "data", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer seg.Close()
+			check(seg)
+		})
+	}
+}
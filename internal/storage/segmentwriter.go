@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+
+	"nano-elastic/internal/index/inverted"
+	"nano-elastic/internal/types"
+)
+
+// segmentWriteBufferSize is the size of the buffer records are appended through
+const segmentWriteBufferSize = 256 * 1024
+
+// SegmentWriter builds a new segment by appending documents to it
+// Nothing reads the segment until Seal hands it over as a SegmentReader, so a
+// writer is used by one goroutine and needs no locking
+type SegmentWriter struct {
+	ID          string
+	Sequence    uint64                  // Highest WAL sequence of the written documents, recorded by Seal
+	MinSequence uint64                  // Lowest WAL sequence of the written documents, recorded by Seal
+	seg         *SegmentReader          // Contents of the segment being built
+	file        File                    // Opened for appending until Seal
+	buf         *bufio.Writer           // Buffers appends to file, flushed by Seal
+	blocks      *blockWriter            // Packs the records into encrypted blocks, nil to append them plain
+	postings    *inverted.InvertedIndex // Text postings of the documents, written to the .inv sidecar by Seal; nil without a schema
+}
+
+// NewSegmentWriter creates the data file of a new segment
+// schema decides the stored fields and analyzes norms and postings
+func NewSegmentWriter(fs FS, id string, basePath string, schema *types.Schema) (*SegmentWriter, error) {
+	postings, err := newSegmentPostings(schema)
+	if err != nil {
+		return nil, err
+	}
+	seg := &SegmentReader{
+		ID:       id,
+		Path:     segmentPath(basePath, id),
+		fs:       fs,
+		Size:     segmentHeaderSize,
+		Created:  time.Now().Unix(),
+		Version:  SegmentVersion,
+		Encoding: RecordEncodingBinary,
+		docIndex: make(map[string]int64),
+		deleted:  make(map[string]bool),
+		schema:   schema,
+		idxDirty: true,
+	}
+
+	file, err := fs.OpenFile(seg.Path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create segment file: %w", err)
+	}
+	w := &SegmentWriter{ID: id, seg: seg, file: file, buf: bufio.NewWriterSize(file, segmentWriteBufferSize), postings: postings}
+	if _, err := w.buf.Write(w.encodeHeader()); err != nil {
+		w.Remove()
+		return nil, fmt.Errorf("failed to write segment header: %w", err)
+	}
+	return w, nil
+}
+
+// encryptBlocks makes the writer store the records in blocks compressed by
+// codec and encrypted, instead of plain records compressed by Seal, so they
+// never reach the disk unencrypted
+func (w *SegmentWriter) encryptBlocks(codec Codec, encryptor *encryptor) {
+	w.seg.Version = SegmentVersionCompressed
+	w.seg.encryptor = encryptor
+	w.blocks = &blockWriter{w: w.buf, codec: codec, encryptor: encryptor, segmentID: w.ID}
+}
+
+// encodeHeader returns the segment header with the current document count
+func (w *SegmentWriter) encodeHeader() []byte {
+	header := SegmentHeader{
+		Version:  uint16(w.seg.Version),
+		DocCount: uint32(w.seg.DocCount),
+		Created:  w.seg.Created,
+		Encoding: uint8(w.seg.Encoding),
+	}
+	copy(header.Magic[:], SegmentMagic)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, header)
+	return buf.Bytes()
+}
+
+// WriteDocument appends a document to the segment
+func (w *SegmentWriter) WriteDocument(doc *types.Document) error {
+	return w.WriteDocuments([]*types.Document{doc})
+}
+
+// WriteDocuments appends documents to the segment
+// They reach the disk when the segment is sealed
+func (w *SegmentWriter) WriteDocuments(docs []*types.Document) error {
+	if w.file == nil {
+		return fmt.Errorf("segment %s is sealed", w.ID)
+	}
+	for _, doc := range docs {
+		if err := w.appendDocument(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendDocument adds one document record to the end of the segment
+// Record format: [len:uint32][crc:uint32][doc], doc in the binary document
+// encoding, without the fields only kept as doc values
+func (w *SegmentWriter) appendDocument(doc *types.Document) error {
+	s := w.seg
+	docBytes, err := s.schema.StoredDocument(doc).MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed to marshal document: %w", err)
+	}
+
+	var prefix [8]byte
+	binary.LittleEndian.PutUint32(prefix[0:4], uint32(len(docBytes)))
+	binary.LittleEndian.PutUint32(prefix[4:8], checksum(docBytes))
+	if w.blocks != nil {
+		if err := w.blocks.add(prefix[:], docBytes); err != nil {
+			return fmt.Errorf("failed to write document: %w", err)
+		}
+	} else {
+		if _, err := w.buf.Write(prefix[:]); err != nil {
+			return fmt.Errorf("failed to write document: %w", err)
+		}
+		if _, err := w.buf.Write(docBytes); err != nil {
+			return fmt.Errorf("failed to write document: %w", err)
+		}
+	}
+
+	w.indexPostings(doc)
+	s.docIndex[doc.ID] = s.Size
+	s.setDocValues(doc)
+	s.setNorms(doc)
+	s.DocCount++
+	s.Size += int64(len(prefix) + len(docBytes))
+	return nil
+}
+
+// indexPostings adds a document's text to the segment's postings the way the
+// engine indexes it (see types.Schema.TextValues)
+func (w *SegmentWriter) indexPostings(doc *types.Document) {
+	if w.postings == nil {
+		return
+	}
+	if _, ok := w.seg.docIndex[doc.ID]; ok {
+		w.postings.RemoveDocument(doc.ID) // Replaces a copy earlier in the segment
+	}
+	for name, texts := range w.seg.schema.TextValues(doc) {
+		w.postings.IndexValues(doc.ID, name, texts)
+	}
+}
+
+// GetLiveDocCount returns the number of distinct documents written so far
+func (w *SegmentWriter) GetLiveDocCount() int {
+	return len(w.seg.docIndex)
+}
+
+// Seal finishes the segment: it writes out the buffered records, syncs them
+// with their header, writes the sidecars, closes the writable file and
+// reopens the segment read-only, compressed with codec and memory-mapped (see
+// mapRecords)
+// The writer can't be used afterwards
+func (w *SegmentWriter) Seal(codec Codec) (*SegmentReader, error) {
+	if w.file == nil {
+		return nil, fmt.Errorf("segment %s is already sealed", w.ID)
+	}
+	s := w.seg
+
+	if w.blocks != nil {
+		if err := w.blocks.flush(); err != nil {
+			return nil, fmt.Errorf("failed to write segment %s: %w", w.ID, err)
+		}
+	}
+	if err := w.buf.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write segment %s: %w", w.ID, err)
+	}
+	if _, err := w.file.WriteAt(w.encodeHeader(), 0); err != nil {
+		return nil, fmt.Errorf("failed to write segment header: %w", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync segment: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close segment file: %w", err)
+	}
+	w.file = nil
+
+	// The contents are already in memory, so only the file is reopened
+	s.Sequence = w.Sequence
+	s.MinSequence = w.MinSequence
+	s.mu.Lock()
+	file, err := s.fs.Open(s.Path)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("failed to open segment file: %w", err)
+	}
+	s.file = file
+	s.initialized = true
+	if w.blocks != nil {
+		// Records keep their offsets in the uncompressed layout; the data
+		// size is the blocks'
+		s.Size = segmentHeaderSize + w.blocks.size
+		if err := s.readBlockIndex(); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+	}
+	s.buildSketches()
+	if w.postings != nil {
+		if err := s.writePostings(w.postings, s.schema); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		w.postings = nil
+	}
+	s.mu.Unlock()
+
+	if err := s.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to flush segment %s: %w", s.ID, err)
+	}
+	if err := s.mapRecords(codec); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Remove closes the writer and deletes the segment's files, e.g. when the
+// flush or merge building it fails
+func (w *SegmentWriter) Remove() error {
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	return w.seg.Remove()
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"path/filepath"
+
+	"nano-elastic/internal/types"
+)
+
+// applySettings overrides the index's options with the settings it has saved
+// Static settings only take effect here, before the WAL is opened
+func (im *IndexManager) applySettings(settings types.Settings) error {
+	if settings.Durability != "" {
+		durability, err := DurabilityByName(settings.Durability)
+		if err != nil {
+			return err
+		}
+		im.durability = durability
+	}
+	if settings.SyncInterval > 0 {
+		im.syncInterval = settings.SyncInterval
+	}
+	im.applyDynamicSettings(settings)
+	return nil
+}
+
+// applyDynamicSettings overrides the options the dynamic settings replace
+// Caller must hold im.mu for writing (or be opening the index)
+func (im *IndexManager) applyDynamicSettings(settings types.Settings) {
+	if settings.MaxSegmentDocs > 0 {
+		im.maxSegmentDocs = settings.MaxSegmentDocs
+	}
+	if settings.MaxSegmentBytes > 0 {
+		im.maxSegmentBytes = settings.MaxSegmentBytes
+	}
+	if settings.MaxWALFileBytes > 0 {
+		im.maxWALFileBytes = settings.MaxWALFileBytes
+		if im.wal != nil {
+			im.wal.setMaxFileBytes(settings.MaxWALFileBytes)
+		}
+	}
+}
+
+// UpdateSettings saves new settings with the index's schema and applies the
+// dynamic ones: segment and WAL file thresholds take effect from the next write
+// Static settings must be unchanged (see types.Settings.StaticChanges)
+func (im *IndexManager) UpdateSettings(settings types.Settings) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if changed := im.Schema.IndexSettings().StaticChanges(settings); len(changed) > 0 {
+		return &types.SettingsError{Setting: changed[0], Reason: "is static and can only be set when the index is created"}
+	}
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	schema := im.Schema.WithSettings(settings)
+	if err := writeSchemaFile(im.fs, filepath.Join(im.BasePath, SchemaFile), schema); err != nil {
+		return err
+	}
+	im.Schema = schema
+	im.applyDynamicSettings(settings)
+	return nil
+}
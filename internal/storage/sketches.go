@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"nano-elastic/internal/index/hll"
+)
+
+// Cardinality sketch sidecar file: [magic "NSHL"][version:uint16][crc:uint32][sketches]
+// The sketches payload is [fields:uint32], then per field [len:uint16][name]
+// [len:uint32][sketch], the sketch encoded by hll.Sketch.Encode
+const (
+	SketchesMagic   = "NSHL"
+	SketchesVersion = 1
+)
+
+// sketchesHeaderSize is the size of the magic, version and checksum prefix
+const sketchesHeaderSize = 4 + 2 + 4
+
+// sketchesPath returns the path of the cardinality sketch sidecar file
+func (s *SegmentReader) sketchesPath() string {
+	return strings.TrimSuffix(s.Path, ".dat") + ".hll"
+}
+
+// buildSketches sketches every record's doc value of each field, deleted
+// records included, as the cardinality aggregation would (see
+// docvalues.Value.Hash)
+// Sketches count exactly up to hll.DefaultThreshold values, so they serve
+// aggregations with that precision threshold or lower, and any once exact
+// Caller must hold s.mu
+func (s *SegmentReader) buildSketches() {
+	s.sketches = make(map[string]*hll.Sketch, len(s.docValues))
+	for name, column := range s.docValues {
+		sketch := hll.New(hll.DefaultThreshold)
+		for _, value := range column {
+			sketch.AddHash(value.Hash())
+		}
+		s.sketches[name] = sketch
+	}
+	s.hllDirty = true
+}
+
+// Sketches returns the segment's cardinality sketches, by field, if live
+// documents are all the segment holds; nil once any is deleted or shadowed,
+// since the sketches still count their values
+// The sketches are shared, so callers must not modify them
+func (s *SegmentReader) Sketches(live int) map[string]*hll.Sketch {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if live != len(s.docIndex) {
+		return nil
+	}
+	return s.sketches
+}
+
+// readSketches loads the cardinality sketch sidecar
+// Segments written before sketches were stored (or whose sidecar was lost in
+// a crash) sketch their doc values again, and write them on the next flush
+// Caller must hold s.mu
+func (s *SegmentReader) readSketches() error {
+	data, stale, err := s.readSidecar(s.sketchesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.buildSketches()
+			return nil
+		}
+		return fmt.Errorf("failed to read cardinality sketches: %w", err)
+	}
+
+	if len(data) < sketchesHeaderSize || string(data[0:4]) != SketchesMagic {
+		return &CorruptionError{Path: s.sketchesPath(), Offset: 0, Reason: "invalid cardinality sketch header"}
+	}
+	if version := binary.LittleEndian.Uint16(data[4:6]); version != SketchesVersion {
+		return fmt.Errorf("unsupported cardinality sketch version %d (expected %d)", version, SketchesVersion)
+	}
+	payload := data[sketchesHeaderSize:]
+	if err := verifyChecksum(s.sketchesPath(), 0, payload, binary.LittleEndian.Uint32(data[6:10])); err != nil {
+		return err
+	}
+
+	sketches, err := decodeSketches(payload)
+	if err != nil {
+		return &CorruptionError{Path: s.sketchesPath(), Offset: sketchesHeaderSize, Reason: err.Error()}
+	}
+	s.sketches = sketches
+	s.hllDirty = stale
+	return nil
+}
+
+// writeSketches persists the sketches atomically (write temp file, then rename)
+// Caller must hold s.mu
+func (s *SegmentReader) writeSketches() error {
+	if !s.hllDirty {
+		return nil
+	}
+
+	payload := encodeSketches(s.sketches)
+	data := make([]byte, sketchesHeaderSize, sketchesHeaderSize+len(payload))
+	copy(data[0:4], SketchesMagic)
+	binary.LittleEndian.PutUint16(data[4:6], SketchesVersion)
+	binary.LittleEndian.PutUint32(data[6:10], checksum(payload))
+	data, err := s.sealSidecar(s.sketchesPath(), append(data, payload...))
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.sketchesPath() + ".tmp"
+	if err := s.fs.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write cardinality sketches: %w", err)
+	}
+	if err := s.fs.Rename(tmpPath, s.sketchesPath()); err != nil {
+		return fmt.Errorf("failed to commit cardinality sketches: %w", err)
+	}
+
+	s.hllDirty = false
+	return nil
+}
+
+// encodeSketches serializes sketches in field order
+func encodeSketches(sketches map[string]*hll.Sketch) []byte {
+	fields := make([]string, 0, len(sketches))
+	for name := range sketches {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(len(fields)))
+	for _, name := range fields {
+		sketch := sketches[name].Encode()
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(name)))
+		buf = append(buf, name...)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(sketch)))
+		buf = append(buf, sketch...)
+	}
+	return buf
+}
+
+// decodeSketches parses sketches written by encodeSketches
+func decodeSketches(data []byte) (map[string]*hll.Sketch, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("truncated field count")
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	sketches := make(map[string]*hll.Sketch, min(count, 1024))
+	for i := uint32(0); i < count; i++ {
+		if len(data) < 2 {
+			return nil, fmt.Errorf("truncated field name")
+		}
+		nameLen := int(binary.LittleEndian.Uint16(data))
+		if len(data) < 2+nameLen+4 {
+			return nil, fmt.Errorf("truncated field name")
+		}
+		name := string(data[2 : 2+nameLen])
+		data = data[2+nameLen:]
+		sketchLen := int(binary.LittleEndian.Uint32(data))
+		if len(data) < 4+sketchLen {
+			return nil, fmt.Errorf("truncated sketch of field %s", name)
+		}
+		sketch, err := hll.Decode(data[4 : 4+sketchLen])
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", name, err)
+		}
+		sketches[name] = sketch
+		data = data[4+sketchLen:]
+	}
+	if len(data) > 0 {
+		return nil, fmt.Errorf("%d bytes after the last sketch", len(data))
+	}
+	return sketches, nil
+}
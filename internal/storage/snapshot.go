@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+
+	"nano-elastic/internal/errdefs"
+	"nano-elastic/internal/index/hll"
+	"nano-elastic/internal/types"
+)
+
+// Snapshot is a point-in-time view of an index's live documents
+// Later writes, deletes and merges don't change what a snapshot sees:
+// it keeps its own copy of each segment's live record offsets and of the
+// memtables' entries, and merged-away segments stay on disk until every
+// snapshot holding them is released
+type Snapshot struct {
+	schema   *types.Schema // For upgrading documents on read
+	mem      memView       // Buffered writes, shadowing the segments
+	segments []snapshotSegment
+	released bool
+}
+
+// snapshotSegment is one segment as it was when the snapshot was taken
+type snapshotSegment struct {
+	seg        *SegmentReader
+	offsets    map[string]int64 // Live document ID -> record offset
+	generation uint64           // The segment's generation when offsets were taken
+}
+
+// SnapshotSegment describes the documents a snapshot reads from one segment
+type SnapshotSegment struct {
+	ID         string
+	Generation uint64   // Changes whenever the segment's records or deletes do
+	DocIDs     []string // Live documents not shadowed by a buffered write
+
+	// Cardinality sketches of the segment's doc values, by field, when DocIDs
+	// is every document it holds; nil otherwise (see SegmentReader.Sketches)
+	Sketches map[string]*hll.Sketch
+}
+
+// AcquireSnapshot captures the current set of live documents
+// The caller must Release the snapshot so merged segments can be deleted
+func (im *IndexManager) AcquireSnapshot() *Snapshot {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	return im.acquireSnapshot()
+}
+
+// acquireSnapshot is AcquireSnapshot for callers holding im.mu
+func (im *IndexManager) acquireSnapshot() *Snapshot {
+	sn := &Snapshot{schema: im.Schema, mem: im.memView(), segments: make([]snapshotSegment, 0, len(im.segments))}
+	for _, seg := range im.segments {
+		seg.acquire()
+		offsets, generation := seg.liveOffsets()
+		for id := range offsets {
+			if _, buffered := sn.mem[id]; buffered || im.docs[id] != seg {
+				delete(offsets, id) // Shadowed by a buffered write or a newer copy
+			}
+		}
+		sn.segments = append(sn.segments, snapshotSegment{seg: seg, offsets: offsets, generation: generation})
+	}
+	return sn
+}
+
+// Clone returns another handle on the same point in time, released separately
+func (sn *Snapshot) Clone() *Snapshot {
+	// The offsets and buffered writes are never modified, so they are shared
+	clone := &Snapshot{schema: sn.schema, mem: sn.mem, segments: sn.segments, released: sn.released}
+	if !sn.released {
+		for _, ss := range sn.segments {
+			ss.seg.acquire()
+		}
+	}
+	return clone
+}
+
+// DocIDs returns the IDs of the documents in the snapshot, sorted
+func (sn *Snapshot) DocIDs() []string {
+	var ids []string
+	for id, entry := range sn.mem {
+		if entry.data != nil {
+			ids = append(ids, id)
+		}
+	}
+	for _, ss := range sn.segments {
+		for id := range ss.offsets {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// DocCount returns the number of documents in the snapshot
+func (sn *Snapshot) DocCount() int {
+	total := 0
+	for _, entry := range sn.mem {
+		if entry.data != nil {
+			total++
+		}
+	}
+	for _, ss := range sn.segments {
+		total += len(ss.offsets)
+	}
+	return total
+}
+
+// Segments returns the documents the snapshot reads from each segment, oldest
+// segment first; documents only in the memtables aren't in any
+// Two snapshots reading the same IDs from a segment of the same generation
+// read the same records
+func (sn *Snapshot) Segments() []SnapshotSegment {
+	segments := make([]SnapshotSegment, 0, len(sn.segments))
+	for _, ss := range sn.segments {
+		ids := make([]string, 0, len(ss.offsets))
+		for id := range ss.offsets {
+			ids = append(ids, id)
+		}
+		segments = append(segments, SnapshotSegment{ID: ss.seg.ID, Generation: ss.generation, DocIDs: ids, Sketches: ss.seg.Sketches(len(ids))})
+	}
+	return segments
+}
+
+// SegmentIDs returns the IDs of the segments the snapshot reads, oldest first
+func (sn *Snapshot) SegmentIDs() []string {
+	ids := make([]string, len(sn.segments))
+	for i, ss := range sn.segments {
+		ids[i] = ss.seg.ID
+	}
+	return ids
+}
+
+// Contains reports whether a document is in the snapshot
+func (sn *Snapshot) Contains(id string) bool {
+	if entry, ok := sn.mem[id]; ok {
+		return entry.data != nil
+	}
+	for _, ss := range sn.segments {
+		if _, ok := ss.offsets[id]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadDocument reads a document as it was when the snapshot was taken
+func (sn *Snapshot) ReadDocument(id string) (*types.Document, error) {
+	if sn.released {
+		return nil, fmt.Errorf("snapshot already released")
+	}
+
+	if entry, ok := sn.mem[id]; ok {
+		if entry.data == nil {
+			return nil, &errdefs.DocNotFoundError{ID: id}
+		}
+		doc, err := types.DecodeDocument(entry.data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode document %s: %w", id, err)
+		}
+		sn.schema.UpgradeDocument(doc)
+		return sn.schema.StoredDocument(doc), nil
+	}
+
+	// Newest segment first, like IndexManager.ReadDocument
+	for i := len(sn.segments) - 1; i >= 0; i-- {
+		ss := sn.segments[i]
+		if offset, ok := ss.offsets[id]; ok {
+			doc, err := ss.seg.readRecordAt(offset)
+			if err != nil {
+				return nil, err
+			}
+			sn.schema.UpgradeDocument(doc)
+			return doc, nil
+		}
+	}
+	return nil, &errdefs.DocNotFoundError{ID: id}
+}
+
+// Scan calls fn with every document in the snapshot, segment by segment
+// (oldest first) in record order, then the buffered writes by ID
+// Only one segment's offsets are sorted at a time, so memory doesn't grow
+// with the index; an error from fn stops the scan and is returned
+func (sn *Snapshot) Scan(fn func(doc *types.Document) error) error {
+	if sn.released {
+		return fmt.Errorf("snapshot already released")
+	}
+
+	for _, ss := range sn.segments {
+		offsets := make([]int64, 0, len(ss.offsets))
+		for _, offset := range ss.offsets {
+			offsets = append(offsets, offset)
+		}
+		sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+		ss.seg.readahead()
+		for _, offset := range offsets {
+			doc, err := ss.seg.readRecordAt(offset)
+			if err != nil {
+				return err
+			}
+			sn.schema.UpgradeDocument(doc)
+			if err := fn(doc); err != nil {
+				return err
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(sn.mem))
+	for id, entry := range sn.mem {
+		if entry.data != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		doc, err := types.DecodeDocument(sn.mem[id].data)
+		if err != nil {
+			return fmt.Errorf("failed to decode document %s: %w", id, err)
+		}
+		sn.schema.UpgradeDocument(doc)
+		if err := fn(sn.schema.StoredDocument(doc)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scan streams every live document of the index to fn from a snapshot taken
+// when it starts (see Snapshot.Scan), so fn may write to the index meanwhile
+func (im *IndexManager) Scan(fn func(doc *types.Document) error) error {
+	sn := im.AcquireSnapshot()
+	defer sn.Release()
+
+	return sn.Scan(fn)
+}
+
+// Release unpins the snapshot's segments
+// Segments merged away while the snapshot was open are deleted now
+func (sn *Snapshot) Release() error {
+	if sn.released {
+		return nil
+	}
+	sn.released = true
+
+	var firstErr error
+	for _, ss := range sn.segments {
+		if err := ss.seg.release(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to release segment %s: %w", ss.seg.ID, err)
+		}
+	}
+	return firstErr
+}
+
+// liveOffsets returns a copy of the live documents' record offsets, and the
+// segment's generation they belong to
+func (s *SegmentReader) liveOffsets() (map[string]int64, uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	offsets := make(map[string]int64, len(s.docIndex))
+	for id, offset := range s.docIndex {
+		if !s.deleted[id] {
+			offsets[id] = offset
+		}
+	}
+	return offsets, s.generation
+}
+
+// readRecordAt reads the document record at offset, ignoring tombstones
+func (s *SegmentReader) readRecordAt(offset int64) (*types.Document, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.file == nil {
+		return nil, fmt.Errorf("segment %s is closed", s.ID)
+	}
+	return s.readRecord(offset)
+}
@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// IndexStats is a point-in-time summary of an index's storage
+type IndexStats struct {
+	DocCount     int            `json:"doc_count"`     // Live documents, including buffered writes
+	DeletedCount int            `json:"deleted_count"` // Tombstoned documents not yet merged away
+	BufferedOps  int            `json:"buffered_ops"`  // Writes and deletes in memtables, not yet in a segment
+	SegmentCount int            `json:"segment_count"`
+	SegmentBytes int64          `json:"segment_bytes"` // Header and records of every segment
+	Segments     []SegmentStats `json:"segments"`
+	WAL          WALStats       `json:"wal"`
+	MemoryBytes  int64          `json:"memory_bytes"` // Estimated heap held by memtables and segment metadata (see memoryBytes)
+	Indexing     IndexingStats  `json:"indexing"`
+	BlockCache   CacheStats     `json:"block_cache"` // Decompressed block cache of compressed segments
+}
+
+// SegmentStats describes one segment of IndexStats
+type SegmentStats struct {
+	ID           string `json:"id"`
+	DocCount     int    `json:"doc_count"` // Live documents
+	DeletedCount int    `json:"deleted_count"`
+	Bytes        int64  `json:"bytes"`
+	Compressed   bool   `json:"compressed"`
+	MemoryBytes  int64  `json:"memory_bytes"`
+}
+
+// WALStats describes the write-ahead log
+type WALStats struct {
+	Files          int    `json:"files"`
+	Bytes          int64  `json:"bytes"`
+	Sequence       uint64 `json:"sequence"`        // Sequence of the last entry
+	SyncedSequence uint64 `json:"synced_sequence"` // Highest sequence known to be on disk
+	Syncs          int64  `json:"syncs"`           // fsyncs since the index was opened
+	SyncTimeMillis int64  `json:"sync_time_in_millis"`
+}
+
+// CacheStats counts the lookups of a cache since the index was opened
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// IndexingStats counts the work done since the index was opened
+// Rates come from comparing two snapshots of the counters
+type IndexingStats struct {
+	IndexTotal      int64 `json:"index_total"`
+	IndexTimeMillis int64 `json:"index_time_in_millis"`
+	DeleteTotal     int64 `json:"delete_total"`
+	FlushTotal      int64 `json:"flush_total"`
+	FlushTimeMillis int64 `json:"flush_time_in_millis"`
+	MergeTotal      int64 `json:"merge_total"`
+	MergeTimeMillis int64 `json:"merge_time_in_millis"`
+	MergedDocs      int64 `json:"merged_docs"`
+}
+
+// indexingCounters accumulates IndexingStats without locking
+type indexingCounters struct {
+	indexTotal  atomic.Int64
+	indexNanos  atomic.Int64
+	deleteTotal atomic.Int64
+	flushTotal  atomic.Int64
+	flushNanos  atomic.Int64
+	mergeTotal  atomic.Int64
+	mergeNanos  atomic.Int64
+	mergedDocs  atomic.Int64
+
+	// Block cache lookups of segments merged away, so the totals never go back
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+}
+
+// recordWrites counts writes and deletes applied together since start
+func (c *indexingCounters) recordWrites(writes int, deletes int, start time.Time) {
+	c.indexTotal.Add(int64(writes))
+	c.deleteTotal.Add(int64(deletes))
+	c.indexNanos.Add(int64(time.Since(start)))
+}
+
+// retireSegment keeps the block cache counts of a segment that is merged away
+func (c *indexingCounters) retireSegment(s *SegmentReader) {
+	c.cacheHits.Add(s.blockCache.hits.Load())
+	c.cacheMisses.Add(s.blockCache.misses.Load())
+}
+
+// snapshot returns the current counter values
+func (c *indexingCounters) snapshot() IndexingStats {
+	return IndexingStats{
+		IndexTotal:      c.indexTotal.Load(),
+		IndexTimeMillis: time.Duration(c.indexNanos.Load()).Milliseconds(),
+		DeleteTotal:     c.deleteTotal.Load(),
+		FlushTotal:      c.flushTotal.Load(),
+		FlushTimeMillis: time.Duration(c.flushNanos.Load()).Milliseconds(),
+		MergeTotal:      c.mergeTotal.Load(),
+		MergeTimeMillis: time.Duration(c.mergeNanos.Load()).Milliseconds(),
+		MergedDocs:      c.mergedDocs.Load(),
+	}
+}
+
+// Stats returns a snapshot of the index's document, segment, WAL and memory
+// statistics and its indexing counters
+func (im *IndexManager) Stats() (IndexStats, error) {
+	wal, err := im.wal.Stats()
+	if err != nil {
+		return IndexStats{}, err
+	}
+	stats := IndexStats{
+		DocCount: im.GetDocumentCount(),
+		WAL:      wal,
+		Indexing: im.counters.snapshot(),
+		BlockCache: CacheStats{
+			Hits:   im.counters.cacheHits.Load(),
+			Misses: im.counters.cacheMisses.Load(),
+		},
+	}
+
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	stats.Segments = make([]SegmentStats, 0, len(im.segments))
+	for _, mt := range im.memtables() {
+		count, size := mt.stats()
+		stats.BufferedOps += count
+		stats.MemoryBytes += size
+	}
+	for _, seg := range im.segments {
+		segStats := seg.stats()
+		stats.Segments = append(stats.Segments, segStats)
+		stats.SegmentCount++
+		stats.SegmentBytes += segStats.Bytes
+		stats.DeletedCount += segStats.DeletedCount
+		stats.MemoryBytes += segStats.MemoryBytes
+		stats.BlockCache.Hits += seg.blockCache.hits.Load()
+		stats.BlockCache.Misses += seg.blockCache.misses.Load()
+	}
+	return stats, nil
+}
+
+// stats returns the segment's SegmentStats
+func (s *SegmentReader) stats() SegmentStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return SegmentStats{
+		ID:           s.ID,
+		DocCount:     len(s.docIndex) - len(s.deleted),
+		DeletedCount: len(s.deleted),
+		Bytes:        s.Size,
+		Compressed:   s.blocks != nil,
+		MemoryBytes:  s.memoryBytes(),
+	}
+}
+
+// mapEntryBytes approximates the overhead of one map entry beyond its key and value
+const mapEntryBytes = 16
+
+// memoryBytes estimates the heap held by the segment's doc index, tombstones,
+// doc values, norms and cached block
+// Document IDs are counted once per structure holding them; the mapping is
+// page cache, not heap, and isn't counted
+// Caller must hold s.mu
+func (s *SegmentReader) memoryBytes() int64 {
+	var total int64
+	for id := range s.docIndex {
+		total += int64(len(id)) + 8 + mapEntryBytes
+	}
+	for id := range s.deleted {
+		total += int64(len(id)) + 1 + mapEntryBytes
+	}
+	for _, column := range s.docValues {
+		for id, value := range column {
+			total += int64(len(id)+len(value.Str)) + 32 + mapEntryBytes // Kind, number and string header
+		}
+	}
+	for _, lengths := range s.norms {
+		for id := range lengths {
+			total += int64(len(id)) + 4 + mapEntryBytes
+		}
+	}
+	total += int64(len(s.blocks)) * 40 // storedBlock
+	return total + int64(s.blockCache.size())
+}
+
+// Stats returns the size, sequences and sync counts of the log
+// It waits for a sync in progress, which guards the synced sequence
+func (w *WAL) Stats() (WALStats, error) {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	stats := WALStats{
+		Files:          len(w.files),
+		Sequence:       w.sequence,
+		SyncedSequence: w.synced,
+		Syncs:          w.syncs.Load(),
+		SyncTimeMillis: time.Duration(w.syncNanos.Load()).Milliseconds(),
+	}
+	for i, f := range w.files {
+		if i == len(w.files)-1 {
+			stats.Bytes += w.size
+			continue
+		}
+		info, err := w.fs.Stat(f.path)
+		if err != nil {
+			return WALStats{}, fmt.Errorf("failed to stat WAL file: %w", err)
+		}
+		stats.Bytes += info.Size()
+	}
+	return stats, nil
+}
@@ -0,0 +1,929 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nano-elastic/internal/logging"
+)
+
+// ObjectStore holds the files a TieredFS moves off local disk, e.g. an S3
+// bucket; the snapshot package's repositories implement it
+// Names are slash-separated paths relative to the TieredFS root
+type ObjectStore interface {
+	Put(name string, r io.Reader, size int64) error
+	Get(name string) (io.ReadCloser, error)
+	Delete(name string) error // Deleting a missing object is not an error
+	List(prefix string) ([]string, error)
+}
+
+// RangeObjectStore is an ObjectStore that can read part of an object, so a
+// TieredFS serves small reads of uncached files without downloading them whole
+type RangeObjectStore interface {
+	ObjectStore
+	GetRange(name string, offset int64, length int64) (io.ReadCloser, error)
+	Size(name string) (int64, error)
+}
+
+// DefaultTieredCacheSize is the local disk a TieredFS keeps copies of segment
+// data files in, by default
+const DefaultTieredCacheSize = 1 << 30
+
+// tieredRangeReadSize is the largest read of an uncached file served from a
+// RangeObjectStore; larger ones download the file
+const tieredRangeReadSize = 1 << 20
+
+// TieredFS keeps sealed segment data files in an object store, with copies of
+// the recently used ones cached on a local FS, and every other file (WAL,
+// manifests, sidecars, schema) only locally
+// Segment data files never change once sealed, so the local copy is a
+// write-back cache: a file is uploaded when it is evicted, least recently used
+// first, once the copies exceed the cache size. Reading an evicted file
+// downloads it again, except for small reads (a segment's header and block
+// index, or a block of documents) from a RangeObjectStore, and merges and scans
+// of a segment download it ahead of reading it all
+// Opening an index reads all of its documents to rebuild the search
+// structures, so it downloads each segment once
+// Uploads and downloads run one at a time; reads of cached files don't wait
+// for them
+type TieredFS struct {
+	local     FS
+	root      string
+	store     ObjectStore
+	cacheSize int64
+	logger    logging.Logger
+
+	mu     sync.Mutex
+	files  map[string]*tieredEntry // Segment data files under root, by cleaned path
+	cached int64                   // Bytes of their local copies
+	clock  atomic.Int64            // Orders uses of the files, for eviction
+
+	uploads, downloads, evictions int64
+}
+
+// tieredEntry is the state of one segment data file
+type tieredEntry struct {
+	size     int64 // -1 until known, for files only in the store
+	local    bool  // A local copy exists
+	remote   bool  // The store has an object under the file's name, maybe stale
+	uploaded bool  // The object is the current contents
+	writers  int   // Handles open for writing, which keep the file local
+	lastUsed atomic.Int64
+	handles  map[*tieredFile]struct{} // Open for reading, closed on eviction
+}
+
+// TieredOption configures a TieredFS
+type TieredOption func(*TieredFS)
+
+// WithCacheSize sets the bytes of segment data files kept on local disk
+// (default DefaultTieredCacheSize); files being written are kept regardless
+func WithCacheSize(bytes int64) TieredOption {
+	return func(t *TieredFS) {
+		t.cacheSize = bytes
+	}
+}
+
+// WithTieredLogger sets the logger failed uploads are reported to (default logging.Default())
+func WithTieredLogger(logger logging.Logger) TieredOption {
+	return func(t *TieredFS) {
+		t.logger = logger
+	}
+}
+
+// NewTieredFS tiers the segment data files under root between local and store
+// The store's objects are the files evicted earlier; local copies are taken as
+// newer, so they are uploaded again when evicted
+func NewTieredFS(local FS, root string, store ObjectStore, options ...TieredOption) (*TieredFS, error) {
+	t := &TieredFS{
+		local:     local,
+		root:      filepath.Clean(root),
+		store:     store,
+		cacheSize: DefaultTieredCacheSize,
+		logger:    logging.Default(),
+		files:     make(map[string]*tieredEntry),
+	}
+	for _, option := range options {
+		option(t)
+	}
+
+	if err := local.MkdirAll(t.root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	objects, err := store.List("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list object store: %w", err)
+	}
+	for _, object := range objects {
+		path := filepath.Join(t.root, filepath.FromSlash(object))
+		if t.tiered(path) {
+			e := t.newEntry()
+			e.size = -1
+			e.remote = true
+			e.uploaded = true
+			t.files[path] = e
+		}
+	}
+	if err := t.scan(t.root); err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.evict(nil)
+	t.mu.Unlock()
+	return t, nil
+}
+
+// scan adds the local copies under dir to the cache
+func (t *TieredFS) scan(dir string) error {
+	entries, err := t.local.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read data directory: %w", err)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if err := t.scan(path); err != nil {
+				return err
+			}
+			continue
+		}
+		if !t.tiered(path) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("failed to stat segment file: %w", err)
+		}
+		e, ok := t.files[path]
+		if !ok {
+			e = t.newEntry()
+			t.files[path] = e
+		}
+		e.local = true
+		e.size = info.Size()
+		e.uploaded = false
+		t.cached += e.size
+	}
+	return nil
+}
+
+// TieredStats describes a TieredFS's cache and its traffic with the store
+type TieredStats struct {
+	CachedFiles int   // Segment data files with a local copy
+	CachedBytes int64 // Their size
+	RemoteFiles int   // Segment data files only in the store
+	Uploads     int64
+	Downloads   int64
+	Evictions   int64
+}
+
+// Stats returns the cache's contents and counters
+func (t *TieredFS) Stats() TieredStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := TieredStats{CachedBytes: t.cached, Uploads: t.uploads, Downloads: t.downloads, Evictions: t.evictions}
+	for _, e := range t.files {
+		if e.local {
+			stats.CachedFiles++
+		} else {
+			stats.RemoteFiles++
+		}
+	}
+	return stats
+}
+
+// tiered reports whether a cleaned path is a segment data file under root
+func (t *TieredFS) tiered(path string) bool {
+	rel, err := filepath.Rel(t.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	base := filepath.Base(path)
+	return strings.HasPrefix(base, "segment_") && strings.HasSuffix(base, ".dat")
+}
+
+// objectName returns the store's name for a file under root
+func (t *TieredFS) objectName(path string) string {
+	rel, _ := filepath.Rel(t.root, path)
+	return filepath.ToSlash(rel)
+}
+
+// newEntry returns the state of a file just used
+func (t *TieredFS) newEntry() *tieredEntry {
+	e := &tieredEntry{handles: make(map[*tieredFile]struct{})}
+	e.lastUsed.Store(t.clock.Add(1))
+	return e
+}
+
+// touch marks a file as just used
+func (t *TieredFS) touch(e *tieredEntry) {
+	e.lastUsed.Store(t.clock.Add(1))
+}
+
+// lookup returns the state of a segment data file, or nil if it doesn't exist
+// A local file written around the TieredFS is taken into the cache
+// Caller must hold t.mu
+func (t *TieredFS) lookup(name string) *tieredEntry {
+	if e, ok := t.files[name]; ok {
+		return e
+	}
+	info, err := t.local.Stat(name)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+	e := t.newEntry()
+	e.local = true
+	e.size = info.Size()
+	t.files[name] = e
+	t.cached += e.size
+	return e
+}
+
+// under returns the segment data files at or below path
+// Caller must hold t.mu
+func (t *TieredFS) under(path string) map[string]*tieredEntry {
+	found := make(map[string]*tieredEntry)
+	prefix := path + string(filepath.Separator)
+	for name, e := range t.files {
+		if name == path || strings.HasPrefix(name, prefix) {
+			found[name] = e
+		}
+	}
+	return found
+}
+
+// put makes e the state of name, replacing the state of a file renamed over
+// Caller must hold t.mu
+func (t *TieredFS) put(name string, e *tieredEntry) {
+	if prev, ok := t.files[name]; ok && prev != e {
+		if prev.local {
+			t.cached -= prev.size
+		}
+		e.remote = e.remote || prev.remote
+	}
+	t.files[name] = e
+	for f := range e.handles {
+		f.name = name
+	}
+}
+
+// forget drops a file's state once it is removed
+// Caller must hold t.mu
+func (t *TieredFS) forget(name string, e *tieredEntry) {
+	if e.local {
+		t.cached -= e.size
+	}
+	delete(t.files, name)
+}
+
+// fetch downloads a file into its local copy
+// The copy is synced before it is renamed into place, since a local copy is
+// taken as the file's contents after a restart
+// Caller must hold t.mu
+func (t *TieredFS) fetch(name string, e *tieredEntry) error {
+	r, err := t.store.Get(t.objectName(name))
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", name, err)
+	}
+	defer r.Close()
+
+	tmpPath := name + ".download"
+	tmp, err := t.local.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+	size, err := io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = t.local.Rename(tmpPath, name)
+	}
+	if err != nil {
+		t.local.Remove(tmpPath)
+		return fmt.Errorf("failed to download %s: %w", name, err)
+	}
+
+	e.local = true
+	e.size = size
+	t.cached += size
+	t.downloads++
+	t.touch(e)
+	return nil
+}
+
+// upload stores a file's local copy in the store
+// Caller must hold t.mu
+func (t *TieredFS) upload(name string, e *tieredEntry) error {
+	file, err := t.local.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := t.store.Put(t.objectName(name), file, e.size); err != nil {
+		return err
+	}
+	e.remote = true
+	e.uploaded = true
+	t.uploads++
+	return nil
+}
+
+// evict uploads and drops the least recently used local copies, other than
+// keep's, until they fit the cache
+// A failed upload leaves the file local, to be retried by the next eviction
+// Caller must hold t.mu
+func (t *TieredFS) evict(keep *tieredEntry) {
+	for t.cached > t.cacheSize {
+		var victim string
+		var ve *tieredEntry
+		for name, e := range t.files {
+			if !e.local || e.writers > 0 || e == keep {
+				continue
+			}
+			if ve == nil || e.lastUsed.Load() < ve.lastUsed.Load() {
+				victim, ve = name, e
+			}
+		}
+		if ve == nil {
+			return
+		}
+		if err := t.drop(victim, ve); err != nil {
+			t.logger.Warn("failed to move segment file to the object store", "file", victim, "error", err)
+			return
+		}
+	}
+}
+
+// drop uploads a file if the store doesn't have it, and removes its local copy
+// Caller must hold t.mu
+func (t *TieredFS) drop(name string, e *tieredEntry) error {
+	if !e.uploaded {
+		if err := t.upload(name, e); err != nil {
+			return err
+		}
+	}
+	for f := range e.handles {
+		f.mu.Lock()
+		if f.local != nil {
+			f.local.Close()
+			f.local = nil
+		}
+		f.mu.Unlock()
+	}
+	if err := t.local.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	e.local = false
+	t.cached -= e.size
+	t.evictions++
+	return nil
+}
+
+// attach opens a handle's local copy, which must exist
+// Caller must hold t.mu
+func (t *TieredFS) attach(f *tieredFile) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.local != nil {
+		return nil
+	}
+	local, err := t.local.Open(f.name)
+	if err != nil {
+		return err
+	}
+	f.local = local
+	return nil
+}
+
+// size returns a file's size, asking the store or downloading the file if
+// it's only in the store
+// Caller must hold t.mu
+func (t *TieredFS) size(name string, e *tieredEntry) (int64, error) {
+	if e.size >= 0 {
+		return e.size, nil
+	}
+	if ranged, ok := t.store.(RangeObjectStore); ok {
+		size, err := ranged.Size(t.objectName(name))
+		if err != nil {
+			return 0, fmt.Errorf("failed to stat %s: %w", name, err)
+		}
+		e.size = size
+		return size, nil
+	}
+	if err := t.fetch(name, e); err != nil {
+		return 0, err
+	}
+	t.evict(e)
+	return e.size, nil
+}
+
+// Open opens a file for reading
+func (t *TieredFS) Open(name string) (File, error) {
+	return t.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens a file with the flags of os.OpenFile
+// A segment data file opened for writing stays local until it's closed
+func (t *TieredFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	name = filepath.Clean(name)
+	if !t.tiered(name) {
+		return t.local.OpenFile(name, flag, perm)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e := t.lookup(name)
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		if e == nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		f := &tieredFile{fs: t, name: name, entry: e}
+		if e.local {
+			if err := t.attach(f); err != nil {
+				return nil, err
+			}
+		}
+		e.handles[f] = struct{}{}
+		t.touch(e)
+		return f, nil
+	}
+
+	if e != nil && !e.local {
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+		}
+		// Kept contents must be local before they're written to
+		if flag&os.O_TRUNC == 0 || flag&os.O_CREATE == 0 {
+			if err := t.fetch(name, e); err != nil {
+				return nil, err
+			}
+		}
+	}
+	file, err := t.local.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		e = t.newEntry()
+		t.files[name] = e
+	}
+	if !e.local {
+		e.local = true
+		e.size = 0
+	}
+	e.uploaded = false
+	e.writers++
+	t.touch(e)
+	return &tieredWriter{File: file, fs: t, name: name, entry: e}, nil
+}
+
+// ReadFile returns a file's contents
+func (t *TieredFS) ReadFile(name string) ([]byte, error) {
+	name = filepath.Clean(name)
+	if !t.tiered(name) {
+		return t.local.ReadFile(name)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e := t.lookup(name)
+	if e == nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	if !e.local {
+		if err := t.fetch(name, e); err != nil {
+			return nil, err
+		}
+		defer t.evict(e)
+	}
+	t.touch(e)
+	return t.local.ReadFile(name)
+}
+
+// WriteFile replaces a file's contents, creating it if needed
+func (t *TieredFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	name = filepath.Clean(name)
+	if !t.tiered(name) {
+		return t.local.WriteFile(name, data, perm)
+	}
+	file, err := t.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Rename renames a file or directory
+// Segment data files only in the store are downloaded first, as objects
+// can't be renamed
+func (t *TieredFS) Rename(oldpath string, newpath string) error {
+	oldpath = filepath.Clean(oldpath)
+	newpath = filepath.Clean(newpath)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	moved := t.under(oldpath)
+	for name, e := range moved {
+		if !e.local {
+			if err := t.fetch(name, e); err != nil {
+				return err
+			}
+		}
+	}
+	if err := t.local.Rename(oldpath, newpath); err != nil {
+		return err
+	}
+
+	for name, e := range moved {
+		delete(t.files, name)
+		if e.remote {
+			if err := t.store.Delete(t.objectName(name)); err != nil {
+				t.logger.Warn("failed to delete renamed segment file from the object store", "file", name, "error", err)
+			}
+			e.remote = false
+		}
+		e.uploaded = false
+		target := newpath + strings.TrimPrefix(name, oldpath)
+		if t.tiered(target) {
+			t.put(target, e)
+		} else {
+			t.cached -= e.size
+		}
+	}
+	if len(moved) == 0 && t.tiered(newpath) {
+		// A file written under a temporary name replaces a segment's
+		info, err := t.local.Stat(newpath)
+		if err != nil {
+			return err
+		}
+		e := t.newEntry()
+		e.local = true
+		e.size = info.Size()
+		t.cached += e.size
+		t.put(newpath, e)
+	}
+	t.evict(nil)
+	return nil
+}
+
+// Remove removes a file, from the store too
+func (t *TieredFS) Remove(name string) error {
+	name = filepath.Clean(name)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.files[name]
+	if !ok {
+		return t.local.Remove(name)
+	}
+	if e.local {
+		if err := t.local.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if e.remote {
+		if err := t.store.Delete(t.objectName(name)); err != nil {
+			return fmt.Errorf("failed to delete %s from the object store: %w", name, err)
+		}
+	}
+	t.forget(name, e)
+	return nil
+}
+
+// RemoveAll removes a path and everything below it, from the store too
+func (t *TieredFS) RemoveAll(path string) error {
+	path = filepath.Clean(path)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for name, e := range t.under(path) {
+		if e.remote {
+			if err := t.store.Delete(t.objectName(name)); err != nil {
+				return fmt.Errorf("failed to delete %s from the object store: %w", name, err)
+			}
+		}
+		t.forget(name, e)
+	}
+	return t.local.RemoveAll(path)
+}
+
+// MkdirAll creates a local directory and its parents
+func (t *TieredFS) MkdirAll(path string, perm os.FileMode) error {
+	return t.local.MkdirAll(path, perm)
+}
+
+// ReadDir lists a directory's local entries and the segment data files in it
+// that are only in the store
+func (t *TieredFS) ReadDir(name string) ([]os.DirEntry, error) {
+	name = filepath.Clean(name)
+	entries, err := t.local.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	added := false
+	for path, e := range t.files {
+		if !e.local && filepath.Dir(path) == name {
+			entries = append(entries, iofs.FileInfoToDirEntry(&tieredInfo{name: filepath.Base(path), size: max(e.size, 0)}))
+			added = true
+		}
+	}
+	if added {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	}
+	return entries, nil
+}
+
+// Stat describes a file
+func (t *TieredFS) Stat(name string) (os.FileInfo, error) {
+	name = filepath.Clean(name)
+	if !t.tiered(name) {
+		return t.local.Stat(name)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.files[name]
+	if !ok || e.local {
+		return t.local.Stat(name)
+	}
+	return t.stat(name, e)
+}
+
+// stat describes a segment data file
+// Caller must hold t.mu
+func (t *TieredFS) stat(name string, e *tieredEntry) (os.FileInfo, error) {
+	if e.local {
+		return t.local.Stat(name)
+	}
+	size, err := t.size(name, e)
+	if err != nil {
+		return nil, err
+	}
+	if e.local { // Downloaded to learn its size
+		return t.local.Stat(name)
+	}
+	return &tieredInfo{name: filepath.Base(name), size: size}, nil
+}
+
+// readUncached serves a read of a handle without a local copy, from a ranged
+// read of the store or by downloading the file
+func (t *TieredFS) readUncached(f *tieredFile, p []byte, off int64) (int, error) {
+	t.mu.Lock()
+	if f.closed {
+		t.mu.Unlock()
+		return 0, os.ErrClosed
+	}
+	e := f.entry
+	if !e.local {
+		if ranged, ok := t.store.(RangeObjectStore); ok && len(p) <= tieredRangeReadSize {
+			size, err := t.size(f.name, e)
+			object := t.objectName(f.name)
+			t.mu.Unlock()
+			if err != nil {
+				return 0, err
+			}
+			return readRange(ranged, object, size, p, off)
+		}
+		if err := t.fetch(f.name, e); err != nil {
+			t.mu.Unlock()
+			return 0, err
+		}
+		t.evict(e)
+	}
+	err := t.attach(f)
+	t.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return f.ReadAt(p, off)
+}
+
+// readRange reads p at off of an object of the given size
+func readRange(store RangeObjectStore, name string, size int64, p []byte, off int64) (int, error) {
+	if off >= size {
+		return 0, io.EOF
+	}
+	length := min(int64(len(p)), size-off)
+	r, err := store.GetRange(name, off, length)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	defer r.Close()
+
+	n, err := io.ReadFull(r, p[:length])
+	if err != nil {
+		return n, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// tieredFile is a segment data file open for reading, through its local copy
+// while there is one
+type tieredFile struct {
+	fs     *TieredFS
+	name   string // Guarded by fs.mu, as renames change it
+	entry  *tieredEntry
+	closed bool // Guarded by fs.mu
+
+	mu    sync.RWMutex
+	local File // Nil while the file isn't cached
+
+	posMu  sync.Mutex
+	offset int64 // Of Read and Seek
+}
+
+// prefetcher is a File that can download its contents ahead of a pass over
+// all of them
+type prefetcher interface {
+	Prefetch() error
+}
+
+// Prefetch downloads the file into the cache, if it isn't there
+func (f *tieredFile) Prefetch() error {
+	t := f.fs
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if f.closed {
+		return os.ErrClosed
+	}
+	if !f.entry.local {
+		if err := t.fetch(f.name, f.entry); err != nil {
+			return err
+		}
+		t.evict(f.entry)
+	}
+	return t.attach(f)
+}
+
+func (f *tieredFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.RLock()
+	if f.local != nil {
+		n, err := f.local.ReadAt(p, off)
+		f.mu.RUnlock()
+		f.fs.touch(f.entry)
+		return n, err
+	}
+	f.mu.RUnlock()
+	return f.fs.readUncached(f, p, off)
+}
+
+func (f *tieredFile) Read(p []byte) (int, error) {
+	f.posMu.Lock()
+	defer f.posMu.Unlock()
+
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *tieredFile) Seek(offset int64, whence int) (int64, error) {
+	f.posMu.Lock()
+	defer f.posMu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		info, err := f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		offset += info.Size()
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *tieredFile) Name() string {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.name
+}
+
+func (f *tieredFile) Stat() (os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.fs.stat(f.name, f.entry)
+}
+
+func (f *tieredFile) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.Name(), Err: os.ErrPermission}
+}
+
+func (f *tieredFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.Name(), Err: os.ErrPermission}
+}
+
+func (f *tieredFile) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: f.Name(), Err: os.ErrPermission}
+}
+
+func (f *tieredFile) Sync() error {
+	return nil
+}
+
+func (f *tieredFile) Close() error {
+	t := f.fs
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	delete(f.entry.handles, f)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.local == nil {
+		return nil
+	}
+	err := f.local.Close()
+	f.local = nil
+	return err
+}
+
+// tieredWriter is a segment data file open for writing; closing it adds the
+// written file to the cache
+type tieredWriter struct {
+	File
+	fs    *TieredFS
+	name  string
+	entry *tieredEntry
+}
+
+func (w *tieredWriter) Close() error {
+	err := w.File.Close()
+
+	t := w.fs
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e := w.entry
+	e.writers--
+	if t.files[w.name] != e { // Removed while open
+		return err
+	}
+	if info, statErr := t.local.Stat(w.name); statErr == nil {
+		t.cached += info.Size() - e.size
+		e.size = info.Size()
+	}
+	t.touch(e)
+	t.evict(nil)
+	return err
+}
+
+// tieredInfo describes a segment data file only in the store
+type tieredInfo struct {
+	name string
+	size int64
+}
+
+func (i *tieredInfo) Name() string       { return i.name }
+func (i *tieredInfo) Size() int64        { return i.size }
+func (i *tieredInfo) Mode() os.FileMode  { return 0644 }
+func (i *tieredInfo) ModTime() time.Time { return time.Time{} }
+func (i *tieredInfo) IsDir() bool        { return false }
+func (i *tieredInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,446 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"nano-elastic/internal/logging"
+	"nano-elastic/internal/types"
+)
+
+// memObjectStore is an ObjectStore in memory; Put fails while putErr is set
+type memObjectStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	putErr  error
+	gets    int
+}
+
+func newMemObjectStore() *memObjectStore {
+	return &memObjectStore{objects: make(map[string][]byte)}
+}
+
+func (s *memObjectStore) Put(name string, r io.Reader, size int64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.putErr != nil {
+		return s.putErr
+	}
+	if int64(len(data)) != size {
+		return fmt.Errorf("put %s: read %d bytes, expected %d", name, len(data), size)
+	}
+	s.objects[name] = data
+	return nil
+}
+
+func (s *memObjectStore) Get(name string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[name]
+	if !ok {
+		return nil, fmt.Errorf("get %s: %w", name, os.ErrNotExist)
+	}
+	s.gets++
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memObjectStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, name)
+	return nil
+}
+
+func (s *memObjectStore) List(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// object returns a copy of an object, nil if there is none
+func (s *memObjectStore) object(name string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]byte(nil), s.objects[name]...)
+}
+
+func (s *memObjectStore) setPutErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putErr = err
+}
+
+// memRangeObjectStore adds ranged reads to a memObjectStore
+type memRangeObjectStore struct {
+	*memObjectStore
+	ranges int
+}
+
+func (s *memRangeObjectStore) GetRange(name string, offset int64, length int64) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[name]
+	if !ok {
+		return nil, fmt.Errorf("get %s: %w", name, os.ErrNotExist)
+	}
+	s.ranges++
+	return io.NopCloser(bytes.NewReader(data[offset : offset+length])), nil
+}
+
+func (s *memRangeObjectStore) Size(name string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[name]
+	if !ok {
+		return 0, fmt.Errorf("stat %s: %w", name, os.ErrNotExist)
+	}
+	return int64(len(data)), nil
+}
+
+// removeFailFS fails removing segment data files while fail is set, like a
+// crash right before the removal
+type removeFailFS struct {
+	FS
+	fail bool
+}
+
+func (f *removeFailFS) Remove(name string) error {
+	if f.fail && strings.HasSuffix(name, ".dat") {
+		return errors.New("crashed")
+	}
+	return f.FS.Remove(name)
+}
+
+func openTieredFS(t *testing.T, local FS, store ObjectStore, cacheSize int64) *TieredFS {
+	t.Helper()
+	tiered, err := NewTieredFS(local, "data", store, WithCacheSize(cacheSize), WithTieredLogger(logging.Discard()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tiered
+}
+
+// segmentData returns the contents of a test segment file
+func segmentData(n int, size int) []byte {
+	return bytes.Repeat([]byte{byte('a' + n)}, size)
+}
+
+func writeSegmentFile(t *testing.T, fs FS, name string, data []byte) {
+	t.Helper()
+	if err := fs.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func localExists(t *testing.T, fs FS, name string) bool {
+	t.Helper()
+	_, err := fs.Stat(name)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestTieredEvictAndDownload(t *testing.T) {
+	seg1 := filepath.Join("data", "idx", "segment_1.dat")
+	seg2 := filepath.Join("data", "idx", "segment_2.dat")
+
+	for _, ranged := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ranged %t", ranged), func(t *testing.T) {
+			local := NewMemFS()
+			base := newMemObjectStore()
+			var store ObjectStore = base
+			rangeStore := &memRangeObjectStore{memObjectStore: base}
+			if ranged {
+				store = rangeStore
+			}
+			tiered := openTieredFS(t, local, store, 100)
+
+			writeSegmentFile(t, tiered, seg1, segmentData(1, 80))
+			f, err := tiered.Open(seg1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			// The second file pushes the first, least recently used, out
+			writeSegmentFile(t, tiered, seg2, segmentData(2, 80))
+			if localExists(t, local, seg1) {
+				t.Error("evicted file is still on local disk")
+			}
+			if !bytes.Equal(base.object("idx/segment_1.dat"), segmentData(1, 80)) {
+				t.Error("evicted file wasn't uploaded")
+			}
+			if stats := tiered.Stats(); stats.CachedFiles != 1 || stats.RemoteFiles != 1 || stats.Uploads != 1 || stats.Evictions != 1 {
+				t.Errorf("stats after eviction = %+v", stats)
+			}
+			entries, err := tiered.ReadDir(filepath.Join("data", "idx"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 2 || entries[0].Name() != "segment_1.dat" || entries[1].Name() != "segment_2.dat" {
+				t.Errorf("ReadDir lists %v", entries)
+			}
+			info, err := tiered.Stat(seg1)
+			if err != nil || info.Size() != 80 {
+				t.Errorf("Stat of evicted file = %v, %v", info, err)
+			}
+
+			// A small read of the open handle is a ranged read if the store can,
+			// and a download otherwise
+			p := make([]byte, 10)
+			if _, err := f.ReadAt(p, 5); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(p, segmentData(1, 10)) {
+				t.Errorf("read %q from the evicted file", p)
+			}
+			if ranged {
+				if rangeStore.ranges != 1 || localExists(t, local, seg1) {
+					t.Errorf("small read of an evicted file downloaded it (%d ranged reads)", rangeStore.ranges)
+				}
+			} else if !localExists(t, local, seg1) {
+				t.Error("read of an evicted file didn't download it")
+			}
+
+			// Reading it whole downloads it, and evicts the other file to fit
+			data, err := tiered.ReadFile(seg1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, segmentData(1, 80)) {
+				t.Error("downloaded file has the wrong contents")
+			}
+			if !localExists(t, local, seg1) || localExists(t, local, seg2) {
+				t.Error("download didn't replace the other file in the cache")
+			}
+			stats := tiered.Stats()
+			if stats.Downloads != 1 || stats.CachedFiles != 1 || stats.CachedBytes != 80 {
+				t.Errorf("stats after download = %+v", stats)
+			}
+			if _, err := local.Stat(seg1 + ".download"); !os.IsNotExist(err) {
+				t.Error("download left its temporary file behind")
+			}
+
+			// Already uploaded, the downloaded copy is dropped without uploading again
+			if _, err := tiered.ReadFile(seg2); err != nil {
+				t.Fatal(err)
+			}
+			if stats := tiered.Stats(); stats.Uploads != 2 {
+				t.Errorf("uploads = %d, want 2: one per file", stats.Uploads)
+			}
+		})
+	}
+}
+
+func TestTieredUploadError(t *testing.T) {
+	seg1 := filepath.Join("data", "idx", "segment_1.dat")
+	seg2 := filepath.Join("data", "idx", "segment_2.dat")
+	local := NewMemFS()
+	store := newMemObjectStore()
+	tiered := openTieredFS(t, local, store, 100)
+
+	store.setPutErr(errors.New("store unavailable"))
+	writeSegmentFile(t, tiered, seg1, segmentData(1, 80))
+	writeSegmentFile(t, tiered, seg2, segmentData(2, 80))
+
+	// Both copies are kept, over the cache size, and nothing is in the store
+	for _, name := range []string{seg1, seg2} {
+		if !localExists(t, local, name) {
+			t.Errorf("%s was removed without being uploaded", name)
+		}
+		data, err := tiered.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 80 {
+			t.Errorf("%s has %d bytes", name, len(data))
+		}
+	}
+	if objects, _ := store.List(""); len(objects) != 0 {
+		t.Errorf("store has %v after failed uploads", objects)
+	}
+	if stats := tiered.Stats(); stats.CachedFiles != 2 || stats.CachedBytes != 160 || stats.Uploads != 0 || stats.Evictions != 0 {
+		t.Errorf("stats after failed uploads = %+v", stats)
+	}
+
+	// The next eviction retries the upload
+	store.setPutErr(nil)
+	writeSegmentFile(t, tiered, filepath.Join("data", "idx", "segment_3.dat"), segmentData(3, 10))
+	if localExists(t, local, seg1) {
+		t.Error("file wasn't evicted once the store was back")
+	}
+	if !bytes.Equal(store.object("idx/segment_1.dat"), segmentData(1, 80)) {
+		t.Error("file wasn't uploaded once the store was back")
+	}
+	if stats := tiered.Stats(); stats.CachedBytes > 100 {
+		t.Errorf("cache holds %d bytes, over its size", stats.CachedBytes)
+	}
+}
+
+func TestTieredCrashBeforeLocalDelete(t *testing.T) {
+	seg1 := filepath.Join("data", "idx", "segment_1.dat")
+	seg2 := filepath.Join("data", "idx", "segment_2.dat")
+	local := &removeFailFS{FS: NewMemFS()}
+	store := newMemObjectStore()
+	tiered := openTieredFS(t, local, store, 100)
+
+	// The upload of the evicted file succeeds, then the crash keeps its local copy
+	local.fail = true
+	writeSegmentFile(t, tiered, seg1, segmentData(1, 80))
+	writeSegmentFile(t, tiered, seg2, segmentData(2, 80))
+	if !bytes.Equal(store.object("idx/segment_1.dat"), segmentData(1, 80)) {
+		t.Fatal("file wasn't uploaded before the crash")
+	}
+	if !localExists(t, local, seg1) {
+		t.Fatal("local copy was removed despite the crash")
+	}
+
+	// Reopened, the file is both local and in the store; the local copy is
+	// used, and evicting it replaces the object
+	local.fail = false
+	tiered = openTieredFS(t, local, store, 1000)
+	stats := tiered.Stats()
+	if stats.CachedFiles != 2 || stats.RemoteFiles != 0 || stats.CachedBytes != 160 {
+		t.Errorf("stats after reopening = %+v", stats)
+	}
+	data, err := tiered.ReadFile(seg1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, segmentData(1, 80)) {
+		t.Error("file has the wrong contents after reopening")
+	}
+	if store.gets != 0 {
+		t.Error("file with a local copy was downloaded")
+	}
+
+	tiered = openTieredFS(t, local, store, 0)
+	for _, name := range []string{seg1, seg2} {
+		if localExists(t, local, name) {
+			t.Errorf("%s wasn't evicted", name)
+		}
+		data, err := tiered.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := segmentData(int(name[len(name)-5]-'0'), 80); !bytes.Equal(data, want) {
+			t.Errorf("%s has the wrong contents after eviction", name)
+		}
+	}
+
+	// Removing the file removes the object too
+	if err := tiered.Remove(seg1); err != nil {
+		t.Fatal(err)
+	}
+	if objects, _ := store.List("idx/segment_1"); len(objects) != 0 {
+		t.Errorf("store still has %v", objects)
+	}
+}
+
+func TestTieredReopenFromStore(t *testing.T) {
+	local := NewMemFS()
+	store := newMemObjectStore()
+	open := func(tiered *TieredFS) *IndexManager {
+		t.Helper()
+		im, err := NewIndexManager("test", "data", types.NewSchema("test"),
+			WithFS(tiered), WithMaxSegmentDocs(2), WithMergeInterval(0), WithLogger(logging.Discard()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return im
+	}
+
+	// A cache of nothing moves every sealed segment to the store
+	tiered := openTieredFS(t, local, store, 0)
+	im := open(tiered)
+	var ids []string
+	for i := 0; i < 7; i++ {
+		doc := types.NewDocument(fmt.Sprintf("doc-%d", i))
+		doc.SetField("n", types.NumericValue{Value: float64(i)})
+		if err := im.WriteDocument(doc); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, doc.ID)
+	}
+	if err := im.DeleteDocument(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	ids = ids[1:]
+	if err := im.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := im.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := local.ReadDir(filepath.Join("data", "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if tiered.tiered(filepath.Join("data", "test", entry.Name())) {
+			t.Fatalf("segment file %s is still local", entry.Name())
+		}
+	}
+	objects, _ := store.List("")
+	if len(objects) == 0 {
+		t.Fatal("no segment was moved to the store")
+	}
+
+	// Tiered to a cache of nothing, reads download a segment at a time
+	for _, cacheSize := range []int64{0, 1 << 20} {
+		t.Run(fmt.Sprintf("cache %d", cacheSize), func(t *testing.T) {
+			// The first reopen finds every segment only in the store; the
+			// second, the one the first downloaded last as well
+			tiered := openTieredFS(t, local, store, cacheSize)
+			opened := tiered.Stats()
+			if opened.RemoteFiles+opened.CachedFiles != len(objects) || opened.RemoteFiles == 0 {
+				t.Errorf("stats after reopening = %+v, want %d files, some remote", opened, len(objects))
+			}
+			im := open(tiered)
+			defer im.Close()
+
+			for _, id := range ids {
+				doc, err := im.ReadDocument(id)
+				if err != nil {
+					t.Fatalf("document %s lost: %v", id, err)
+				}
+				if doc.ID != id {
+					t.Errorf("read %s for %s", doc.ID, id)
+				}
+			}
+			if _, err := im.ReadDocument("doc-0"); err == nil {
+				t.Error("deleted document came back")
+			}
+			if count := im.GetDocumentCount(); count != len(ids) {
+				t.Errorf("document count = %d, want %d", count, len(ids))
+			}
+			if stats := tiered.Stats(); stats.Downloads == 0 {
+				t.Error("segments only in the store weren't downloaded")
+			}
+		})
+	}
+}
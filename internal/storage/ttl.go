@@ -0,0 +1,82 @@
+package storage
+
+import "time"
+
+// WithExpiredTracking makes merges record the documents they drop because
+// their TTL field (see types.Schema.TTLField) has passed, for readers keeping
+// their own structures in sync (see AcquireSnapshotAndExpired)
+func WithExpiredTracking() IndexOption {
+	return func(im *IndexManager) {
+		im.trackExpired = true
+	}
+}
+
+// AcquireSnapshotAndExpired acquires a snapshot like AcquireSnapshot, along
+// with the IDs of the documents merges dropped as expired since the last call
+// Every drop reported is visible in the snapshot, and every drop visible in
+// the snapshot is reported by this or an earlier call. A reported document may
+// have been written again since; the snapshot then holds the new write
+func (im *IndexManager) AcquireSnapshotAndExpired() (*Snapshot, []string) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	im.expiredMu.Lock()
+	expired := im.expired
+	im.expired = nil
+	im.expiredMu.Unlock()
+
+	return im.acquireSnapshot(), expired
+}
+
+// HasExpired reports whether merges dropped expired documents since the last
+// AcquireSnapshotAndExpired
+func (im *IndexManager) HasExpired() bool {
+	im.expiredMu.Lock()
+	defer im.expiredMu.Unlock()
+
+	return len(im.expired) > 0
+}
+
+// recordExpired remembers documents a merge dropped as expired
+// Caller must hold im.mu for writing
+func (im *IndexManager) recordExpired(ids []string) {
+	if !im.trackExpired || len(ids) == 0 {
+		return
+	}
+	im.expiredMu.Lock()
+	defer im.expiredMu.Unlock()
+
+	im.expired = append(im.expired, ids...)
+}
+
+// expiredCount returns the number of live documents of the segment whose TTL
+// field, a date doc value, is at or before now
+func (s *SegmentReader) expiredCount(field string, now time.Time) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nowMillis := float64(now.UnixMilli())
+	count := 0
+	for id, value := range s.docValues[field] {
+		if _, ok := s.docIndex[id]; ok && !s.deleted[id] && value.Num <= nowMillis {
+			count++
+		}
+	}
+	return count
+}
+
+// expiredCounts returns the number of expired documents in each segment, or
+// nil if the schema has no TTL field
+// Caller must hold im.mu
+func (im *IndexManager) expiredCounts(now time.Time) map[*SegmentReader]int {
+	if im.Schema.TTLField == "" {
+		return nil
+	}
+	counts := make(map[*SegmentReader]int, len(im.segments))
+	for _, seg := range im.segments {
+		if n := seg.expiredCount(im.Schema.TTLField, now); n > 0 {
+			counts[seg] = n
+		}
+	}
+	return counts
+}
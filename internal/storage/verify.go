@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// VerifyOption is a function that configures a Verify run
+type VerifyOption func(*verifyConfig)
+
+// verifyConfig holds the options of a Verify run
+type verifyConfig struct {
+	repair bool
+}
+
+// WithRepair makes Verify rebuild what it can from the stored documents: a
+// segment's doc index that is damaged or disagrees with its records
+func WithRepair() VerifyOption {
+	return func(c *verifyConfig) {
+		c.repair = true
+	}
+}
+
+// VerifyReport is what Verify found in an index, and what it repaired
+type VerifyReport struct {
+	Segments   int
+	Records    int // Document records of the segments, including deleted ones
+	WALFiles   int
+	WALEntries int
+	Problems   []error
+	Repaired   []string // What repair rebuilt, e.g. "segment seg3 doc index"
+}
+
+// OK reports whether no problem was found
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Verify checks an open index's files against each other: every segment's
+// header, that its doc index points at the records of the documents it names
+// and lists them all, and that the WAL's entries are readable and in sequence
+// order and the checkpoint within them
+// Writes, flushes and merges wait while it runs
+// Problems are reported in the report; the error is for a failed read or repair
+func (im *IndexManager) Verify(options ...VerifyOption) (*VerifyReport, error) {
+	cfg := &verifyConfig{}
+	for _, opt := range options {
+		opt(cfg)
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	report := &VerifyReport{}
+	repaired := false
+	for _, seg := range im.segments {
+		report.Segments++
+		rebuilt, err := report.verifySegment(seg, cfg.repair)
+		if err != nil {
+			return nil, fmt.Errorf("failed to repair segment %s: %w", seg.ID, err)
+		}
+		repaired = repaired || rebuilt
+	}
+
+	if repaired {
+		// Rebuilt doc indexes can map documents the old ones missed
+		dirty := make(map[*SegmentReader]bool)
+		im.docs = make(docMap)
+		for _, seg := range im.segments {
+			im.docs.addLoaded(seg, dirty)
+		}
+		for seg := range dirty {
+			if err := seg.Flush(); err != nil {
+				return nil, fmt.Errorf("failed to flush tombstones: %w", err)
+			}
+		}
+		if err := im.writeManifest(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := im.verifyWAL(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// verifySegment checks a segment's header and doc index against its records,
+// rebuilding the doc index from the records if repair is set and they disagree
+// Returns whether the doc index was rebuilt
+func (r *VerifyReport) verifySegment(s *SegmentReader, repair bool) (bool, error) {
+	problem := func(format string, args ...interface{}) {
+		r.Problems = append(r.Problems, fmt.Errorf("segment %s: "+format, append([]interface{}{s.ID}, args...)...))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.verifyHeader(); err != nil {
+		problem("%w", err)
+	}
+
+	// Every record's document ID, by offset; a record that can't be read
+	// leaves the doc index unrepairable, since its document is unknown
+	records := make(map[string]int64)
+	complete := true
+	err := s.forEachRecord(func(offset int64, record []byte) error {
+		r.Records++
+		doc, err := decodeRecord(s.Path, offset, record[8:], binary.LittleEndian.Uint32(record[4:8]))
+		if err != nil {
+			problem("%w", err)
+			complete = false
+			return nil // The length prefix still frames the next record
+		}
+		records[doc.ID] = offset // A later record of the same document replaces it
+		return nil
+	})
+	if err != nil {
+		problem("%w", err)
+		complete = false
+	}
+
+	stale := false
+	if err := verifyDocIndexFile(s); err != nil {
+		problem("%w", err)
+		stale = true
+	}
+	// A wrong index is usually wrong throughout, so each kind of mismatch is
+	// reported once, with a count and the first document
+	var misplaced, missing []string
+	for _, id := range sortedIDs(s.docIndex) {
+		if offset, ok := records[id]; !ok || offset != s.docIndex[id] {
+			misplaced = append(misplaced, id)
+		}
+	}
+	for _, id := range sortedIDs(records) {
+		if _, ok := s.docIndex[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	if len(misplaced) > 0 {
+		problem("doc index maps %d document(s) to offsets that are not their records, e.g. %s to %d",
+			len(misplaced), misplaced[0], s.docIndex[misplaced[0]])
+		stale = true
+	}
+	if len(missing) > 0 {
+		problem("doc index is missing %d recorded document(s), e.g. %s at offset %d",
+			len(missing), missing[0], records[missing[0]])
+		stale = true
+	}
+
+	if !stale || !repair || !complete {
+		return false, nil
+	}
+	s.docIndex = records
+	s.DocCount = len(records)
+	s.idxDirty = true
+	s.generation++ // Snapshots taken from here on read through the new index
+	if err := s.writeIndex(); err != nil {
+		return false, err
+	}
+	r.Repaired = append(r.Repaired, fmt.Sprintf("segment %s doc index", s.ID))
+	return true, nil
+}
+
+// verifyHeader re-reads the segment header and checks it against what Open loaded
+// Caller must hold s.mu
+func (s *SegmentReader) verifyHeader() error {
+	var header SegmentHeader
+	if err := binary.Read(io.NewSectionReader(s.file, 0, segmentHeaderSize), binary.LittleEndian, &header); err != nil {
+		return fmt.Errorf("failed to read segment header: %w", err)
+	}
+	switch {
+	case string(header.Magic[:]) != SegmentMagic:
+		return &CorruptionError{Path: s.Path, Offset: 0, Reason: "invalid segment magic number"}
+	case int(header.Version) != s.Version:
+		return &CorruptionError{Path: s.Path, Offset: 0, Reason: fmt.Sprintf("header version %d, opened as %d", header.Version, s.Version)}
+	case int(header.Encoding) != s.Encoding:
+		return &CorruptionError{Path: s.Path, Offset: 0, Reason: fmt.Sprintf("header encoding %d, opened as %d", header.Encoding, s.Encoding)}
+	}
+	return nil
+}
+
+// verifyWAL checks that every WAL entry is readable, that sequences increase
+// within and across files, and that the checkpoint isn't past the last one
+// Caller must hold im.mu for writing, so no entry is being appended
+func (im *IndexManager) verifyWAL(report *VerifyReport) error {
+	w := im.wal
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var last uint64
+	for _, f := range w.files {
+		report.WALFiles++
+		header, err := readHeaderFile(w.fs, f.path)
+		if err != nil {
+			report.Problems = append(report.Problems, err)
+			continue
+		}
+		if header.Sequence < last {
+			report.Problems = append(report.Problems, fmt.Errorf("%s: base sequence %d is before the previous file's last entry %d", f.path, header.Sequence, last))
+		}
+		previous := header.Sequence
+		err = w.forEachEntry(f.path, func(offset int64, entry *WALEntry) error {
+			report.WALEntries++
+			if entry.Sequence <= previous {
+				return &CorruptionError{Path: f.path, Offset: offset, Reason: fmt.Sprintf("sequence %d follows %d", entry.Sequence, previous)}
+			}
+			previous = entry.Sequence
+			return nil
+		})
+		if err != nil {
+			report.Problems = append(report.Problems, err)
+		}
+		last = max(last, previous)
+	}
+	if last > w.sequence {
+		report.Problems = append(report.Problems, fmt.Errorf("WAL holds sequence %d, past its last write %d", last, w.sequence))
+	}
+
+	checkpoint, err := readCheckpoint(im.fs, im.BasePath)
+	var corruption *CorruptionError
+	switch {
+	case errors.As(err, &corruption):
+		report.Problems = append(report.Problems, err)
+	case err != nil:
+		return err
+	case checkpoint > w.sequence:
+		report.Problems = append(report.Problems, fmt.Errorf("checkpoint %d is past the WAL's last write %d", checkpoint, w.sequence))
+	}
+	return nil
+}
+
+// sortedIDs returns the document IDs of an offset map, sorted, so problems are
+// reported in a stable order
+func sortedIDs(offsets map[string]int64) []string {
+	ids := make([]string, 0, len(offsets))
+	for id := range offsets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
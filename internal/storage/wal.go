@@ -1,15 +1,18 @@
 package storage
 
 import (
+	"bufio"
+	"context"
 	"encoding/binary"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"nano-elastic/internal/logging"
 	"nano-elastic/internal/types"
 )
 
@@ -30,117 +33,160 @@ type WALEntry struct {
 	Document  *types.Document
 	Timestamp int64
 	Sequence  uint64
+	Encrypted bool // Read without the WAL's keys: only Type, Timestamp and Sequence are set
 }
 
+// maxWALEntrySize bounds the length of one entry: its header, index name and
+// doc ID (64KB each at most) and the encoded document
+const maxWALEntrySize = 256 << 20
+
 // WAL (Write-Ahead Log) provides durability guarantees
+// The log is a series of numbered files; appends go to the last one, which is
+// rotated once it reaches the maximum file size
 type WAL struct {
-	Path       string
-	file       *os.File
+	Path       string // Current file
+	file       File
 	sequence   uint64
 	mu         sync.Mutex
 	initialized bool
+	
+	// Log files, oldest first
+	fs          FS
+	dir         string
+	files       []walFile
+	size        int64 // Bytes in the current file
+	maxFileSize int64
+	
+	// When entries are synced to disk
+	durability   Durability
+	syncInterval time.Duration
+	syncMu       sync.Mutex // Held for each fsync, ordered before mu
+	synced       uint64     // Highest sequence known to be on disk
+	syncer       *syncer
+	
+	// fsyncs of the log files, reported by Stats
+	syncs     atomic.Int64
+	syncNanos atomic.Int64
+	
+	// Encrypts entries, nil without encryption (see WithWALEncryption)
+	encryptor *encryptor
+	fileKeyID uint32 // Current key when the current file was started, if fileKeyed
+	fileKeyed bool   // Whether the current file was started with encryption on
+	
+	logger logging.Logger
 }
 
-// WALHeader is written at the beginning of the WAL file
+// WALHeader is written at the beginning of each WAL file
 type WALHeader struct {
 	Magic    [4]byte // "NWAL"
 	Version  uint16
-	Sequence uint64
+	Sequence uint64 // Last sequence before the file's first entry
 	Reserved [8]byte
 }
 
 const (
 	WALMagic   = "NWAL"
-	WALVersion = 1
+	WALVersion = 4 // Version 4 allows encrypted entries
+	walVersionBinary = 3 // Version 3 stores documents in the binary document encoding
+	walVersionJSON = 2 // Version 2 adds a CRC32 checksum to every entry; documents are JSON
 )
 
+// walEntryEncrypted is the first byte of an encrypted entry
+// Format: [0xE0][type:uint8][seq:uint64][ts:int64][sealed rest of the entry]
+// The type, sequence and timestamp stay readable without the keys, and are
+// authenticated with the rest
+const walEntryEncrypted byte = 0xE0
+
+// walEntryHeaderSize is the size of an entry's type, sequence and timestamp
+const walEntryHeaderSize = 1 + 8 + 8
+
 // NewWAL creates a new write-ahead log
-func NewWAL(basePath string) (*WAL, error) {
-	walPath := filepath.Join(basePath, "wal.dat")
-	
+func NewWAL(basePath string, options ...WALOption) (*WAL, error) {
 	wal := &WAL{
-		Path: walPath,
+		dir:          basePath,
+		fs:           OSFS,
+		maxFileSize:  DefaultMaxWALFileBytes,
+		durability:   DefaultDurability,
+		syncInterval: DefaultSyncInterval,
+		logger:       logging.Default(),
+	}
+	
+	// Apply options
+	for _, opt := range options {
+		opt(wal)
 	}
 	
 	return wal, nil
 }
 
-// Open opens the WAL file
+// Open opens the WAL files, creating the first one for a new log
 func (w *WAL) Open() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	
+	return w.openLocked()
+}
+
+// openLocked opens the WAL files; the caller holds w.mu
+func (w *WAL) openLocked() error {
 	if w.initialized {
 		return nil
 	}
 	
-	var err error
-	w.file, err = os.OpenFile(w.Path, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open WAL file: %w", err)
-	}
-	
-	// Check if file is empty
-	stat, err := w.file.Stat()
-	if err != nil {
-		return fmt.Errorf("failed to stat WAL file: %w", err)
-	}
-	
-	if stat.Size() == 0 {
-		// Write header for new WAL
-		if err := w.writeHeader(); err != nil {
-			return err
-		}
-		w.initialized = true
-		return nil
-	}
-	
-	// Read header from existing WAL
-	if err := w.readHeader(); err != nil {
+	if err := w.openFiles(); err != nil {
 		return err
 	}
 	
-	// Recover sequence number
-	if err := w.recoverSequence(); err != nil {
-		return err
+	// Entries already in the file survived whatever came before
+	w.synced = w.sequence
+	if w.durability == DurabilityInterval && w.syncInterval > 0 {
+		w.syncer = newSyncer(w, w.syncInterval)
+		w.syncer.start()
 	}
 	
 	w.initialized = true
 	return nil
 }
 
-// writeHeader writes the WAL header
-func (w *WAL) writeHeader() error {
+// writeHeader writes a WAL file header
+// base is the last sequence before the file's first entry
+func writeHeader(file io.Writer, base uint64) error {
 	header := WALHeader{
 		Version:  WALVersion,
-		Sequence: w.sequence,
+		Sequence: base,
 	}
 	copy(header.Magic[:], WALMagic)
 	
-	if err := binary.Write(w.file, binary.LittleEndian, header); err != nil {
+	if err := binary.Write(file, binary.LittleEndian, header); err != nil {
 		return fmt.Errorf("failed to write WAL header: %w", err)
 	}
 	
 	return nil
 }
 
-// readHeader reads the WAL header
-func (w *WAL) readHeader() error {
+// readHeader reads a WAL file header
+func readHeader(file io.Reader) (WALHeader, error) {
 	var header WALHeader
-	if err := binary.Read(w.file, binary.LittleEndian, &header); err != nil {
-		return fmt.Errorf("failed to read WAL header: %w", err)
+	if err := binary.Read(file, binary.LittleEndian, &header); err != nil {
+		return header, fmt.Errorf("failed to read WAL header: %w", err)
 	}
 	
 	// Validate magic number
 	if string(header.Magic[:]) != WALMagic {
-		return fmt.Errorf("invalid WAL magic number")
+		return header, fmt.Errorf("invalid WAL magic number")
 	}
 	
-	w.sequence = header.Sequence
-	return nil
+	// Version 2 and 3 files are still readable; entries are decoded by their own
+	// encoding either way
+	if header.Version != WALVersion && header.Version != walVersionBinary && header.Version != walVersionJSON {
+		return header, fmt.Errorf("unsupported WAL version %d (expected %d to %d)", header.Version, walVersionJSON, WALVersion)
+	}
+	
+	return header, nil
 }
 
-// recoverSequence reads through the WAL to find the highest sequence number
+// recoverSequence reads through the current file to find the highest sequence number
+// A last entry cut short by a crash (a torn write) is truncated away, since its
+// write was never acknowledged; any other unreadable entry fails the open
 func (w *WAL) recoverSequence() error {
 	// Seek to after header
 	if _, err := w.file.Seek(int64(binary.Size(WALHeader{})), io.SeekStart); err != nil {
@@ -150,12 +196,29 @@ func (w *WAL) recoverSequence() error {
 	maxSeq := w.sequence
 	
 	for {
-		entry, err := w.readEntry()
+		offset, err := w.file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		entry, err := w.readEntry(w.file, w.Path)
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			// If we hit a corrupted entry, stop here
+			var corruption *CorruptionError
+			if !errors.As(err, &corruption) {
+				return err
+			}
+			torn, tornErr := isTornTail(w.file, offset)
+			if tornErr != nil {
+				return tornErr
+			}
+			if !torn {
+				return fmt.Errorf("WAL is corrupted before its last entry: %w", err)
+			}
+			if err := w.truncateTail(offset, err); err != nil {
+				return err
+			}
 			break
 		}
 		
@@ -170,60 +233,111 @@ func (w *WAL) recoverSequence() error {
 
 // WriteEntry writes an entry to the WAL
 func (w *WAL) WriteEntry(entryType WALEntryType, index string, docID string, doc *types.Document) error {
+	entry := WALEntry{
+		Type:     entryType,
+		Index:    index,
+		DocID:    docID,
+		Document: doc,
+	}
+	return w.WriteEntries([]WALEntry{entry})
+}
+
+// WriteEntriesContext is WriteEntries unless the context is already done
+// Once appended, entries are always synced and replayed, so the context can only
+// stop a batch before it is written
+func (w *WAL) WriteEntriesContext(ctx context.Context, entries []WALEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return w.WriteEntries(entries)
+}
+
+// WriteEntries writes several entries with a single sync, so a batch costs one fsync
+// Sequence numbers and timestamps are assigned in order
+// With DurabilityWrite the entries are on disk when it returns; the sync is
+// shared with concurrent writers (see syncTo)
+func (w *WAL) WriteEntries(entries []WALEntry) error {
+	// A full file is rotated first, so the batch starts the next one
+	if err := w.rotateIfFull(); err != nil {
+		return err
+	}
+	
 	w.mu.Lock()
-	defer w.mu.Unlock()
 	
 	if !w.initialized {
-		if err := w.Open(); err != nil {
+		w.mu.Unlock()
+		return fmt.Errorf("WAL is closed")
+	}
+	
+	for i := range entries {
+		if err := w.appendEntry(&entries[i]); err != nil {
+			w.mu.Unlock()
 			return err
 		}
 	}
 	
-	// Increment sequence
-	w.sequence++
+	last := w.sequence
+	w.mu.Unlock()
 	
-	entry := WALEntry{
-		Type:      entryType,
-		Index:     index,
-		DocID:     docID,
-		Document:  doc,
-		Timestamp: time.Now().UnixNano(),
-		Sequence:  w.sequence,
+	if w.durability != DurabilityWrite {
+		return nil
 	}
+	return w.syncTo(last)
+}
+
+// appendEntry assigns the next sequence number and writes an entry without syncing
+func (w *WAL) appendEntry(entry *WALEntry) error {
+	// Increment sequence
+	w.sequence++
+	entry.Sequence = w.sequence
+	entry.Timestamp = time.Now().UnixNano()
 	
+	n, err := w.writeEntry(w.file, entry)
+	w.size += int64(n)
+	return err
+}
+
+// writeEntry writes an entry, keeping its sequence number and timestamp
+// Returns the number of bytes written
+func (w *WAL) writeEntry(file io.Writer, entry *WALEntry) (int, error) {
 	// Serialize entry
-	entryBytes, err := w.serializeEntry(&entry)
+	entryBytes, err := w.serializeEntry(entry)
 	if err != nil {
-		return fmt.Errorf("failed to serialize WAL entry: %w", err)
+		return 0, fmt.Errorf("failed to serialize WAL entry: %w", err)
 	}
-	
-	// Write entry length
-	entryLen := uint32(len(entryBytes))
-	if err := binary.Write(w.file, binary.LittleEndian, entryLen); err != nil {
-		return fmt.Errorf("failed to write entry length: %w", err)
+	if w.encryptor != nil {
+		header := entryBytes[:walEntryHeaderSize]
+		sealed := append([]byte{walEntryEncrypted}, header...)
+		if entryBytes, err = w.encryptor.seal(sealed, entryBytes[walEntryHeaderSize:], header); err != nil {
+			return 0, fmt.Errorf("failed to encrypt WAL entry: %w", err)
+		}
 	}
-	
-	// Write entry data
-	if _, err := w.file.Write(entryBytes); err != nil {
-		return fmt.Errorf("failed to write entry: %w", err)
+	if len(entryBytes) > maxWALEntrySize {
+		return 0, fmt.Errorf("WAL entry for document %s is %d bytes, over the maximum of %d", entry.DocID, len(entryBytes), maxWALEntrySize)
 	}
 	
-	// Sync to disk for durability
-	if err := w.file.Sync(); err != nil {
-		return fmt.Errorf("failed to sync WAL: %w", err)
+	// Write entry length and checksum
+	// Format: [len:uint32][crc:uint32][entry:bytes]
+	var prefix [8]byte
+	binary.LittleEndian.PutUint32(prefix[0:4], uint32(len(entryBytes)))
+	binary.LittleEndian.PutUint32(prefix[4:8], checksum(entryBytes))
+	n, err := file.Write(prefix[:])
+	if err != nil {
+		return n, fmt.Errorf("failed to write entry length: %w", err)
 	}
 	
-	// Update header with new sequence
-	if err := w.updateHeader(); err != nil {
-		return err
+	// Write entry data
+	m, err := file.Write(entryBytes)
+	if err != nil {
+		return n + m, fmt.Errorf("failed to write entry: %w", err)
 	}
 	
-	return nil
+	return n + m, nil
 }
 
 // serializeEntry serializes a WAL entry
 func (w *WAL) serializeEntry(entry *WALEntry) ([]byte, error) {
-	// Format: [type:uint8][seq:uint64][ts:int64][indexLen:uint16][index:bytes][docIDLen:uint16][docID:bytes][docLen:uint32][doc:json]
+	// Format: [type:uint8][seq:uint64][ts:int64][indexLen:uint16][index:bytes][docIDLen:uint16][docID:bytes][docLen:uint32][doc:binary]
 	
 	indexBytes := []byte(entry.Index)
 	docIDBytes := []byte(entry.DocID)
@@ -231,7 +345,7 @@ func (w *WAL) serializeEntry(entry *WALEntry) ([]byte, error) {
 	var docBytes []byte
 	var err error
 	if entry.Document != nil {
-		docBytes, err = json.Marshal(entry.Document)
+		docBytes, err = entry.Document.MarshalBinary()
 		if err != nil {
 			return nil, err
 		}
@@ -284,24 +398,73 @@ func (w *WAL) serializeEntry(entry *WALEntry) ([]byte, error) {
 	return result, nil
 }
 
-// readEntry reads a single entry from the WAL
-func (w *WAL) readEntry() (*WALEntry, error) {
-	// Read entry length
-	var entryLen uint32
-	if err := binary.Read(w.file, binary.LittleEndian, &entryLen); err != nil {
+// readEntry reads a single entry from a WAL file and verifies its checksum
+func (w *WAL) readEntry(file File, path string) (*WALEntry, error) {
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
 		return nil, err
 	}
 	
+	// Read entry length and checksum
+	var prefix [8]byte
+	if _, err := io.ReadFull(file, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, &CorruptionError{Path: path, Offset: offset, Reason: "truncated entry header"}
+		}
+		return nil, err
+	}
+	entryLen := binary.LittleEndian.Uint32(prefix[0:4])
+	entryCRC := binary.LittleEndian.Uint32(prefix[4:8])
+	
+	// The length isn't covered by the checksum, so check it against the file
+	// before allocating: a damaged length could otherwise ask for gigabytes
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat WAL file: %w", err)
+	}
+	if remaining := stat.Size() - offset - int64(len(prefix)); int64(entryLen) > remaining {
+		return nil, &CorruptionError{Path: path, Offset: offset, Reason: fmt.Sprintf("entry length %d exceeds the %d bytes left in the file", entryLen, remaining)}
+	}
+	if entryLen > maxWALEntrySize {
+		return nil, &CorruptionError{Path: path, Offset: offset, Reason: fmt.Sprintf("entry length %d exceeds the maximum of %d bytes", entryLen, maxWALEntrySize)}
+	}
+	
 	// Read entry data
 	entryBytes := make([]byte, entryLen)
-	if _, err := io.ReadFull(w.file, entryBytes); err != nil {
+	if _, err := io.ReadFull(file, entryBytes); err != nil {
+		return nil, &CorruptionError{Path: path, Offset: offset, Reason: "truncated entry data"}
+	}
+	
+	if err := verifyChecksum(path, offset, entryBytes, entryCRC); err != nil {
 		return nil, err
 	}
 	
+	if len(entryBytes) > 0 && entryBytes[0] == walEntryEncrypted {
+		if len(entryBytes) < 1+walEntryHeaderSize {
+			return nil, &CorruptionError{Path: path, Offset: offset, Reason: "encrypted entry too short"}
+		}
+		header := entryBytes[1 : 1+walEntryHeaderSize]
+		if w.encryptor == nil {
+			return &WALEntry{
+				Type:      WALEntryType(header[0]),
+				Sequence:  binary.LittleEndian.Uint64(header[1:9]),
+				Timestamp: int64(binary.LittleEndian.Uint64(header[9:17])),
+				Encrypted: true,
+			}, nil
+		}
+		// A failed decryption means a missing or wrong key, not a damaged
+		// entry, so it isn't a CorruptionError and can't pass for a torn write
+		plain, err := w.encryptor.open(entryBytes[1+walEntryHeaderSize:], header)
+		if err != nil {
+			return nil, fmt.Errorf("WAL entry at offset %d of %s: %w", offset, path, err)
+		}
+		entryBytes = append(append([]byte(nil), header...), plain...)
+	}
+	
 	// Deserialize entry
 	entry, err := w.deserializeEntry(entryBytes)
 	if err != nil {
-		return nil, err
+		return nil, &CorruptionError{Path: path, Offset: offset, Reason: err.Error()}
 	}
 	
 	return entry, nil
@@ -312,6 +475,11 @@ func (w *WAL) deserializeEntry(data []byte) (*WALEntry, error) {
 	entry := &WALEntry{}
 	offset := 0
 	
+	// Fixed-size prefix: type + sequence + timestamp + index length
+	if len(data) < 1+8+8+2 {
+		return nil, fmt.Errorf("entry too short (%d bytes)", len(data))
+	}
+	
 	// Read type
 	entry.Type = WALEntryType(data[offset])
 	offset++
@@ -327,89 +495,193 @@ func (w *WAL) deserializeEntry(data []byte) (*WALEntry, error) {
 	// Read index
 	indexLen := binary.LittleEndian.Uint16(data[offset:])
 	offset += 2
+	if len(data) < offset+int(indexLen)+2 {
+		return nil, fmt.Errorf("index name exceeds entry bounds")
+	}
 	entry.Index = string(data[offset : offset+int(indexLen)])
 	offset += int(indexLen)
 	
 	// Read docID
 	docIDLen := binary.LittleEndian.Uint16(data[offset:])
 	offset += 2
+	if len(data) < offset+int(docIDLen)+4 {
+		return nil, fmt.Errorf("document ID exceeds entry bounds")
+	}
 	entry.DocID = string(data[offset : offset+int(docIDLen)])
 	offset += int(docIDLen)
 	
 	// Read document
 	docLen := binary.LittleEndian.Uint32(data[offset:])
 	offset += 4
+	if len(data) < offset+int(docLen) {
+		return nil, fmt.Errorf("document exceeds entry bounds")
+	}
 	if docLen > 0 {
-		var doc types.Document
-		if err := json.Unmarshal(data[offset:offset+int(docLen)], &doc); err != nil {
+		doc, err := types.DecodeDocument(data[offset : offset+int(docLen)])
+		if err != nil {
 			return nil, err
 		}
-		entry.Document = &doc
+		entry.Document = doc
 	}
 	
 	return entry, nil
 }
 
-// updateHeader updates the WAL header
-func (w *WAL) updateHeader() error {
-	currentPos, err := w.file.Seek(0, io.SeekCurrent)
-	if err != nil {
-		return err
-	}
-	
-	// Seek to beginning
-	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
-		return err
-	}
-	
-	// Write updated header
-	if err := w.writeHeader(); err != nil {
-		return err
-	}
-	
-	// Restore position
-	if _, err := w.file.Seek(currentPos, io.SeekStart); err != nil {
-		return err
-	}
+// Sequence returns the sequence number of the last entry written
+func (w *WAL) Sequence() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	
-	return nil
+	return w.sequence
 }
 
 // Replay replays all entries from the WAL, calling the provided function for each entry
+// Files are read oldest first, so entries come in sequence order
 func (w *WAL) Replay(fn func(*WALEntry) error) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	
-	if !w.initialized {
-		if err := w.Open(); err != nil {
+	if err := w.openLocked(); err != nil {
+		return err
+	}
+	
+	for _, f := range w.files {
+		entries, err := w.readFile(f.path)
+		if err != nil {
 			return err
 		}
+		for _, entry := range entries {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
 	}
 	
+	return nil
+}
+
+// readFile reads all entries of a WAL file
+func (w *WAL) readFile(path string) ([]*WALEntry, error) {
+	file, err := w.fs.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL file: %w", err)
+	}
+	defer file.Close()
+	
 	// Seek to after header
-	if _, err := w.file.Seek(int64(binary.Size(WALHeader{})), io.SeekStart); err != nil {
-		return err
+	if _, err := file.Seek(int64(binary.Size(WALHeader{})), io.SeekStart); err != nil {
+		return nil, err
 	}
 	
+	var entries []*WALEntry
 	for {
-		entry, err := w.readEntry()
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := w.readEntry(file, path)
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			return err
+			return nil, err
 		}
-		
-		if err := fn(entry); err != nil {
+		if entry.Encrypted {
+			return nil, fmt.Errorf("WAL entry at offset %d of %s is encrypted and no encryption keys are configured", offset, path)
+		}
+		entries = append(entries, entry)
+	}
+	
+	return entries, nil
+}
+
+// Upgrade rewrites the log so every document uses the binary encoding, keeping
+// the entries' sequence numbers and timestamps
+// With encryption every entry is also encrypted with the current key
+// Each file is replaced atomically (write temp file, then rename); a corrupted
+// entry aborts the upgrade and leaves that file as it was
+func (w *WAL) Upgrade() error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	
+	if err := w.openLocked(); err != nil {
+		return err
+	}
+	
+	for i, f := range w.files {
+		if err := w.upgradeFile(f, i == len(w.files)-1); err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+// upgradeFile rewrites one WAL file with the binary document encoding
+// current is whether appends go to the file
+func (w *WAL) upgradeFile(f walFile, current bool) error {
+	entries, err := w.readFile(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read WAL for upgrade: %w", err)
+	}
+	
+	tmpPath := f.path + ".tmp"
+	tmp, err := w.fs.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create WAL upgrade file: %w", err)
+	}
+	abort := func(err error) error {
+		tmp.Close()
+		w.fs.Remove(tmpPath)
+		return err
+	}
+	
+	bw := bufio.NewWriter(tmp)
+	if err := writeHeader(bw, f.base); err != nil {
+		return abort(err)
+	}
+	size := int64(binary.Size(WALHeader{}))
+	for _, entry := range entries {
+		n, err := w.writeEntry(bw, entry)
+		if err != nil {
+			return abort(err)
+		}
+		size += int64(n)
+	}
+	if err := bw.Flush(); err != nil {
+		return abort(fmt.Errorf("failed to write upgraded WAL: %w", err))
+	}
+	if err := tmp.Sync(); err != nil {
+		return abort(fmt.Errorf("failed to sync upgraded WAL: %w", err))
+	}
+	if err := w.fs.Rename(tmpPath, f.path); err != nil {
+		return abort(fmt.Errorf("failed to replace WAL: %w", err))
+	}
 	
+	if !current {
+		return tmp.Close()
+	}
+	
+	// The new file is positioned at its end, ready for appends
+	w.file.Close()
+	w.file = tmp
+	w.size = size
 	return nil
 }
 
-// Close closes the WAL file
+// Close syncs and closes the WAL file
 func (w *WAL) Close() error {
+	if w.syncer != nil {
+		w.syncer.close()
+		w.syncer = nil
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	
@@ -427,12 +699,12 @@ func (w *WAL) Close() error {
 // Flush forces a sync to disk
 func (w *WAL) Flush() error {
 	w.mu.Lock()
-	defer w.mu.Unlock()
+	closed, seq := w.file == nil, w.sequence
+	w.mu.Unlock()
 	
-	if w.file != nil {
-		return w.file.Sync()
+	if closed {
+		return nil
 	}
-	
-	return nil
+	return w.syncTo(seq)
 }
 
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWALFieldTypeRoundTrip(t *testing.T) {
+	fs := NewMemFS()
+	if err := fs.MkdirAll("wal", 0755); err != nil {
+		t.Fatal(err)
+	}
+	wal, err := NewWAL("wal", WithWALFS(fs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Open(); err != nil {
+		t.Fatal(err)
+	}
+	docs := fieldTypeDocs()
+	for _, doc := range docs {
+		if err := wal.WriteEntry(WALEntryWrite, "test", doc.ID, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replayed from the files, by a new log
+	wal, err = NewWAL("wal", WithWALFS(fs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	var replayed []*WALEntry
+	err = wal.Replay(func(entry *WALEntry) error {
+		replayed = append(replayed, entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(replayed) != len(docs) {
+		t.Fatalf("replayed %d entries, want %d", len(replayed), len(docs))
+	}
+	for i, want := range docs {
+		t.Run(want.ID, func(t *testing.T) {
+			entry := replayed[i]
+			if entry.Type != WALEntryWrite || entry.DocID != want.ID {
+				t.Fatalf("entry %d is %v %q, want a write of %q", i, entry.Type, entry.DocID, want.ID)
+			}
+			if !reflect.DeepEqual(entry.Document, want) {
+				t.Errorf("document = %#v, want %#v", entry.Document, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DefaultMaxWALFileBytes is the size after which the WAL rotates to a new file
+const DefaultMaxWALFileBytes = 64 * 1024 * 1024 // 64MB
+
+// legacyWALName is the single file older versions kept the whole log in
+const legacyWALName = "wal.dat"
+
+// WithWALMaxFileBytes sets the size after which the WAL rotates to a new file
+// A size of 0 keeps appending to one file
+func WithWALMaxFileBytes(n int64) WALOption {
+	return func(w *WAL) {
+		w.maxFileSize = n
+	}
+}
+
+// walFile is one file of the log
+type walFile struct {
+	path   string
+	number int
+	base   uint64 // Last sequence before the file's first entry
+}
+
+// walFileName returns the name of the WAL file with the given number
+func walFileName(number int) string {
+	return fmt.Sprintf("wal_%06d.dat", number)
+}
+
+// listWALFiles returns the numbered WAL files in a directory, oldest first
+func listWALFiles(fs FS, dir string) ([]walFile, error) {
+	entries, err := fs.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list WAL files: %w", err)
+	}
+
+	var files []walFile
+	for _, entry := range entries {
+		var number int
+		if _, err := fmt.Sscanf(entry.Name(), "wal_%d.dat", &number); err != nil || entry.Name() != walFileName(number) {
+			continue
+		}
+		files = append(files, walFile{path: filepath.Join(dir, entry.Name()), number: number})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].number < files[j].number
+	})
+	return files, nil
+}
+
+// openFiles opens the log's files for appending to the last one, creating the
+// first file of a new log
+// Caller must hold w.mu
+func (w *WAL) openFiles() error {
+	files, err := listWALFiles(w.fs, w.dir)
+	if err != nil {
+		return err
+	}
+
+	// A log from before rotation is a single wal.dat, which becomes the first file
+	if len(files) == 0 {
+		legacy := filepath.Join(w.dir, legacyWALName)
+		if _, err := w.fs.Stat(legacy); err == nil {
+			path := filepath.Join(w.dir, walFileName(1))
+			if err := w.fs.Rename(legacy, path); err != nil {
+				return fmt.Errorf("failed to rename WAL file: %w", err)
+			}
+			files = append(files, walFile{path: path, number: 1})
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to stat WAL file: %w", err)
+		}
+	}
+
+	// A crash can leave the newest file created but without its full header
+	if len(files) > 0 {
+		last := files[len(files)-1]
+		if stat, err := w.fs.Stat(last.path); err != nil {
+			return fmt.Errorf("failed to stat WAL file: %w", err)
+		} else if stat.Size() < int64(binary.Size(WALHeader{})) {
+			if err := w.fs.Remove(last.path); err != nil {
+				return fmt.Errorf("failed to remove empty WAL file: %w", err)
+			}
+			files = files[:len(files)-1]
+		}
+	}
+
+	if len(files) == 0 {
+		return w.createFile(1)
+	}
+
+	for i := range files {
+		header, err := readHeaderFile(w.fs, files[i].path)
+		if err != nil {
+			return err
+		}
+		files[i].base = header.Sequence
+	}
+	w.files = files
+
+	last := files[len(files)-1]
+	w.file, err = w.fs.OpenFile(last.path, os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open WAL file: %w", err)
+	}
+	w.Path = last.path
+	header, err := readHeader(w.file)
+	if err != nil {
+		return err
+	}
+	w.sequence = header.Sequence
+	if err := w.recoverSequence(); err != nil {
+		return err
+	}
+	if w.size, err = w.file.Seek(0, io.SeekCurrent); err != nil {
+		return err
+	}
+
+	// New entries go to a file of the current version
+	if header.Version != WALVersion {
+		return w.rotate()
+	}
+	return nil
+}
+
+// readHeaderFile reads the header of a WAL file
+func readHeaderFile(fs FS, path string) (WALHeader, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return WALHeader{}, fmt.Errorf("failed to open WAL file: %w", err)
+	}
+	defer file.Close()
+
+	header, err := readHeader(file)
+	if err != nil {
+		return header, fmt.Errorf("%s: %w", path, err)
+	}
+	return header, nil
+}
+
+// createFile starts the WAL file with the given number, which takes appends
+// from now on
+// Caller must hold w.mu
+func (w *WAL) createFile(number int) error {
+	path := filepath.Join(w.dir, walFileName(number))
+	file, err := w.fs.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create WAL file: %w", err)
+	}
+	if err := writeHeader(file, w.sequence); err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file = file
+	w.Path = path
+	w.size = int64(binary.Size(WALHeader{}))
+	w.files = append(w.files, walFile{path: path, number: number, base: w.sequence})
+	w.fileKeyed = false
+	if w.encryptor != nil {
+		id, err := w.encryptor.currentKeyID()
+		w.fileKeyID, w.fileKeyed = id, err == nil
+	}
+	return nil
+}
+
+// rotate syncs and closes the current file and starts the next one
+// Syncs only cover the current file, so the old one is synced first
+// Caller must hold w.syncMu and w.mu
+func (w *WAL) rotate() error {
+	if err := w.syncFile(w.file); err != nil {
+		return fmt.Errorf("failed to sync WAL: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close WAL file: %w", err)
+	}
+	w.synced = w.sequence
+	return w.createFile(w.files[len(w.files)-1].number + 1)
+}
+
+// rotateIfFull rotates the current file once it has reached the maximum size
+func (w *WAL) rotateIfFull() error {
+	w.mu.Lock()
+	full := w.initialized && w.maxFileSize > 0 && w.size >= w.maxFileSize
+	w.mu.Unlock()
+	if !full {
+		return nil
+	}
+
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Another writer may have rotated it meanwhile
+	if !w.initialized || w.size < w.maxFileSize {
+		return nil
+	}
+	return w.rotate()
+}
+
+// rotateToKey rotates the current file unless all of its entries are encrypted
+// with the given key, so that, once the entries are flushed, RemoveThrough
+// deletes the last of those written in plaintext or under an older key
+func (w *WAL) rotateToKey(keyID uint32) error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.initialized || (w.fileKeyed && w.fileKeyID == keyID) {
+		return nil
+	}
+	return w.rotate()
+}
+
+// RemoveThrough deletes the files holding only entries up to sequence, once
+// they are no longer needed for replay (see writeCheckpoint)
+// The current file is always kept
+func (w *WAL) RemoveThrough(sequence uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	removed := 0
+	defer func() {
+		w.files = w.files[removed:]
+	}()
+	for removed < len(w.files)-1 && w.files[removed+1].base <= sequence {
+		if err := w.fs.Remove(w.files[removed].path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove WAL file: %w", err)
+		}
+		removed++
+	}
+	return nil
+}
+
+// isTornTail reports whether the unreadable entry at offset is the file's last
+// one, cut short by a crash: it runs up to or past the end of the file with no
+// whole entry after it, or the rest of the file is zeros (space allocated but
+// never written)
+// A damaged length also runs past the end, which is why the rest of the file
+// is searched for a valid entry before the tail is written off
+func isTornTail(file File, offset int64) (bool, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to stat WAL file: %w", err)
+	}
+	remaining := stat.Size() - offset
+	if remaining < 8 {
+		return true, nil
+	}
+
+	var prefix [8]byte
+	if _, err := file.ReadAt(prefix[:], offset); err != nil {
+		return false, fmt.Errorf("failed to read WAL entry: %w", err)
+	}
+	if 8+int64(binary.LittleEndian.Uint32(prefix[0:4])) >= remaining {
+		follows, err := entryFollows(file, offset+1, stat.Size())
+		if err != nil {
+			return false, err
+		}
+		return !follows, nil
+	}
+
+	buf := make([]byte, 64*1024)
+	for pos := offset; pos < stat.Size(); {
+		n, err := file.ReadAt(buf[:min(int64(len(buf)), stat.Size()-pos)], pos)
+		if err != nil {
+			return false, fmt.Errorf("failed to read WAL entry: %w", err)
+		}
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		pos += int64(n)
+	}
+	return true, nil
+}
+
+// entryFollows reports whether a whole, non-empty entry with a valid checksum
+// starts anywhere from offset to the end of the file
+// Entries aren't aligned, so every position is tried
+func entryFollows(file File, offset int64, size int64) (bool, error) {
+	buf := make([]byte, 64*1024)
+	var data []byte
+	// Windows overlap by 7 bytes so every 8-byte prefix is read whole once
+	for start := offset; start+8 <= size; start += int64(len(buf)) - 7 {
+		n, err := file.ReadAt(buf[:min(int64(len(buf)), size-start)], start)
+		if err != nil && err != io.EOF {
+			return false, fmt.Errorf("failed to read WAL entry: %w", err)
+		}
+		for i := 0; i+8 <= n; i++ {
+			length := int64(binary.LittleEndian.Uint32(buf[i : i+4]))
+			pos := start + int64(i)
+			if length == 0 || pos+8+length > size {
+				continue
+			}
+			if int64(cap(data)) < length {
+				data = make([]byte, length)
+			}
+			data = data[:length]
+			if _, err := file.ReadAt(data, pos+8); err != nil && err != io.EOF {
+				return false, fmt.Errorf("failed to read WAL entry: %w", err)
+			}
+			if checksum(data) == binary.LittleEndian.Uint32(buf[i+4:i+8]) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// truncateTail cuts the current file at offset, dropping a torn last entry
+// Caller must hold w.mu
+func (w *WAL) truncateTail(offset int64, cause error) error {
+	stat, err := w.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat WAL file: %w", err)
+	}
+	if err := w.file.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate torn WAL entry: %w", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync WAL: %w", err)
+	}
+	if _, err := w.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	w.logger.Warn("discarded torn WAL entry", "path", w.Path, "offset", offset, "bytes", stat.Size()-offset, "error", cause)
+	return nil
+}
+
+// setMaxFileBytes changes the size after which the log rotates to a new file
+func (w *WAL) setMaxFileBytes(n int64) {
+	w.mu.Lock()
+	w.maxFileSize = n
+	w.mu.Unlock()
+}
@@ -0,0 +1,1306 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+)
+
+// Zstandard frame format (RFC 8878, https://www.rfc-editor.org/rfc/rfc8878)
+// A frame is a header (magic, descriptor, content size) and a series of raw,
+// RLE or compressed blocks of at most 128KB. A compressed block holds its
+// literals, raw or Huffman-coded, and its sequences (literal length, match
+// length, offset) coded with FSE tables into one backwards bitstream
+// The compressor writes single-segment frames with greedy matches coded under
+// the predefined FSE tables; the decompressor reads any frame without a
+// dictionary, including those written by the reference implementation
+const (
+	zstdMagic          = 0xFD2FB528
+	zstdSkippableMagic = 0x184D2A50 // Low 4 bits are free
+	zstdMaxBlockSize   = 128 << 10
+	zstdMinMatch       = 4 // The format allows 3, but 4-byte hashing finds better matches
+	zstdMaxOffset      = 1 << 24
+	zstdHashLog        = 16
+	zstdMaxHuffmanBits = 11
+)
+
+// Literals block types
+const (
+	zstdLiteralsRaw = iota
+	zstdLiteralsRLE
+	zstdLiteralsCompressed
+	zstdLiteralsTreeless
+)
+
+// Sequence table modes
+const (
+	zstdModePredefined = iota
+	zstdModeRLE
+	zstdModeCompressed
+	zstdModeRepeat
+)
+
+var errZstdCorrupt = errors.New("corrupt zstd frame")
+
+// Literal length codes: baseline and number of extra bits
+var (
+	zstdLLBase = [36]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	zstdLLBits = [36]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+)
+
+// Match length codes: baseline and number of extra bits
+var (
+	zstdMLBase = [53]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	zstdMLBits = [53]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+// Predefined distributions of the sequence codes; -1 is a "less than 1" probability
+var (
+	zstdLLDefault = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	zstdMLDefault = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	zstdOFDefault = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+)
+
+// zstdSeqKind describes one of the three sequence code tables
+type zstdSeqKind struct {
+	maxSymbol int
+	maxLog    int
+	predef    *zstdFSETable
+}
+
+var (
+	zstdLLKind = zstdSeqKind{maxSymbol: 35, maxLog: 9, predef: mustBuildFSE(zstdLLDefault, 6)}
+	zstdOFKind = zstdSeqKind{maxSymbol: 31, maxLog: 8, predef: mustBuildFSE(zstdOFDefault, 5)}
+	zstdMLKind = zstdSeqKind{maxSymbol: 52, maxLog: 9, predef: mustBuildFSE(zstdMLDefault, 6)}
+
+	zstdLLEncoder = newFSEEncoder(zstdLLKind.predef, len(zstdLLDefault))
+	zstdOFEncoder = newFSEEncoder(zstdOFKind.predef, len(zstdOFDefault))
+	zstdMLEncoder = newFSEEncoder(zstdMLKind.predef, len(zstdMLDefault))
+)
+
+// zstdCompress appends a Zstandard frame holding src to dst
+func zstdCompress(dst, src []byte) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, zstdMagic)
+
+	// Single segment: the window is the whole content, whose size follows
+	n := len(src)
+	switch {
+	case n < 256:
+		dst = append(dst, 0x20, byte(n))
+	case n < 65536+256:
+		dst = binary.LittleEndian.AppendUint16(append(dst, 0x20|1<<6), uint16(n-256))
+	case uint64(n) < 1<<32:
+		dst = binary.LittleEndian.AppendUint32(append(dst, 0x20|2<<6), uint32(n))
+	default:
+		dst = binary.LittleEndian.AppendUint64(append(dst, 0x20|3<<6), uint64(n))
+	}
+
+	if n == 0 {
+		return zstdAppendBlockHeader(dst, true, 0, 0)
+	}
+	table := make([]int32, 1<<zstdHashLog) // Position+1 of the last occurrence of each hash
+	for start := 0; start < n; start += zstdMaxBlockSize {
+		end := min(start+zstdMaxBlockSize, n)
+		dst = zstdAppendBlock(dst, src, start, end, table)
+	}
+	return dst
+}
+
+// zstdAppendBlockHeader appends a 3-byte block header
+func zstdAppendBlockHeader(dst []byte, last bool, blockType, size int) []byte {
+	h := uint32(size)<<3 | uint32(blockType)<<1
+	if last {
+		h |= 1
+	}
+	return append(dst, byte(h), byte(h>>8), byte(h>>16))
+}
+
+// zstdSequence is a run of literals followed by a match
+type zstdSequence struct {
+	litLen   uint32
+	matchLen uint32
+	offset   uint32 // The offset value: a repeat code 1-3 or the distance + 3
+}
+
+// zstdAppendBlock appends the block holding src[start:end], compressed unless
+// that wouldn't make it smaller
+// Matches may reach back into earlier blocks; table carries their positions
+func zstdAppendBlock(dst, src []byte, start, end int, table []int32) []byte {
+	var seqs []zstdSequence
+	var literals []byte
+
+	anchor := start
+	for i := start; i+zstdMinMatch <= end; {
+		seq := binary.LittleEndian.Uint32(src[i:])
+		h := (seq * 2654435761) >> (32 - zstdHashLog)
+		ref := int(table[h]) - 1
+		table[h] = int32(i + 1)
+
+		if ref < 0 || i-ref > zstdMaxOffset || binary.LittleEndian.Uint32(src[ref:]) != seq {
+			i++
+			continue
+		}
+
+		matchLen := zstdMinMatch
+		for i+matchLen < end && src[ref+matchLen] == src[i+matchLen] {
+			matchLen++
+		}
+		literals = append(literals, src[anchor:i]...)
+		seqs = append(seqs, zstdSequence{
+			litLen:   uint32(i - anchor),
+			matchLen: uint32(matchLen),
+			offset:   uint32(i-ref) + 3,
+		})
+		i += matchLen
+		anchor = i
+	}
+	literals = append(literals, src[anchor:end]...)
+
+	body := zstdAppendLiterals(nil, literals)
+	body = zstdAppendSequences(body, seqs)
+
+	last := end == len(src)
+	if len(body) >= end-start {
+		dst = zstdAppendBlockHeader(dst, last, 0, end-start)
+		return append(dst, src[start:end]...)
+	}
+	dst = zstdAppendBlockHeader(dst, last, 2, len(body))
+	return append(dst, body...)
+}
+
+// zstdAppendLiterals appends a literals section, Huffman-coded if that is smaller
+func zstdAppendLiterals(dst, literals []byte) []byte {
+	if len(literals) > 1 && zstdAllSame(literals) {
+		return append(zstdAppendLiteralsHeader(dst, zstdLiteralsRLE, len(literals)), literals[0])
+	}
+	if compressed, ok := zstdHuffmanLiterals(literals); ok && len(compressed) < len(literals) {
+		return append(dst, compressed...)
+	}
+	return append(zstdAppendLiteralsHeader(dst, zstdLiteralsRaw, len(literals)), literals...)
+}
+
+// zstdAppendLiteralsHeader appends the header of a raw or RLE literals section
+func zstdAppendLiteralsHeader(dst []byte, blockType, size int) []byte {
+	switch {
+	case size < 32:
+		return append(dst, byte(blockType|size<<3))
+	case size < 4096:
+		return binary.LittleEndian.AppendUint16(dst, uint16(blockType|1<<2|size<<4))
+	default:
+		h := blockType | 3<<2 | size<<4
+		return append(dst, byte(h), byte(h>>8), byte(h>>16))
+	}
+}
+
+func zstdAllSame(b []byte) bool {
+	for _, c := range b[1:] {
+		if c != b[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// zstdHuffmanLiterals returns the Huffman-coded literals section of literals
+// It only codes literals below 128, whose weights fit the direct
+// representation of the tree description
+func zstdHuffmanLiterals(literals []byte) ([]byte, bool) {
+	if len(literals) < 32 {
+		return nil, false
+	}
+	var freq [256]int
+	for _, c := range literals {
+		freq[c]++
+	}
+	maxSymbol := 255
+	for freq[maxSymbol] == 0 {
+		maxSymbol--
+	}
+	if maxSymbol > 128 {
+		return nil, false
+	}
+
+	lengths := zstdHuffmanLengths(freq[:maxSymbol+1], zstdMaxHuffmanBits)
+	maxBits := 0
+	for _, l := range lengths {
+		maxBits = max(maxBits, int(l))
+	}
+
+	// Weights, and the canonical codes the decoder's table assigns them: the
+	// lowest weights (longest codes) first, then by symbol
+	weights := make([]uint8, maxSymbol+1)
+	var rankStart [zstdMaxHuffmanBits + 2]int
+	for s, l := range lengths {
+		if l > 0 {
+			weights[s] = uint8(maxBits + 1 - int(l))
+			rankStart[weights[s]+1] += 1 << (weights[s] - 1)
+		}
+	}
+	for w := 1; w <= maxBits; w++ {
+		rankStart[w+1] += rankStart[w]
+	}
+	codes := make([]uint16, maxSymbol+1)
+	for s, w := range weights {
+		if w > 0 {
+			codes[s] = uint16(rankStart[w] >> (w - 1))
+			rankStart[w] += 1 << (w - 1)
+		}
+	}
+
+	// Tree description: the weights of all symbols but the last, 4 bits each
+	tree := []byte{byte(127 + maxSymbol)}
+	for s := 0; s < maxSymbol; s += 2 {
+		b := weights[s] << 4
+		if s+1 < maxSymbol {
+			b |= weights[s+1]
+		}
+		tree = append(tree, b)
+	}
+
+	encode := func(dst, lits []byte) []byte {
+		var w zstdBitWriter
+		w.out = dst
+		for i := len(lits) - 1; i >= 0; i-- {
+			w.write(uint64(codes[lits[i]]), uint(lengths[lits[i]]))
+		}
+		return w.close()
+	}
+
+	n := len(literals)
+	var body []byte
+	sizeFormat := 0
+	if n < 1024 {
+		body = encode(append([]byte(nil), tree...), literals)
+	}
+	if body == nil || len(body) >= 1024 {
+		// Four streams, with a jump table of the first three streams' sizes
+		body = append(append([]byte(nil), tree...), make([]byte, 6)...)
+		segment := (n + 3) / 4
+		for i := 0; i < 4; i++ {
+			streamStart := len(body)
+			body = encode(body, literals[min(i*segment, n):min((i+1)*segment, n)])
+			if i < 3 {
+				binary.LittleEndian.PutUint16(body[len(tree)+2*i:], uint16(len(body)-streamStart))
+			}
+		}
+		switch {
+		case n < 1024 && len(body) < 1024:
+			sizeFormat = 1
+		case n < 16384 && len(body) < 16384:
+			sizeFormat = 2
+		default:
+			sizeFormat = 3
+		}
+	}
+
+	h := uint64(zstdLiteralsCompressed | sizeFormat<<2)
+	var out []byte
+	switch sizeFormat {
+	case 0, 1:
+		h |= uint64(n)<<4 | uint64(len(body))<<14
+		out = append(out, byte(h), byte(h>>8), byte(h>>16))
+	case 2:
+		h |= uint64(n)<<4 | uint64(len(body))<<18
+		out = binary.LittleEndian.AppendUint32(out, uint32(h))
+	default:
+		h |= uint64(n)<<4 | uint64(len(body))<<22
+		out = append(binary.LittleEndian.AppendUint32(out, uint32(h)), byte(h>>32))
+	}
+	return append(out, body...), true
+}
+
+// zstdHuffmanLengths returns Huffman code lengths of at most limit bits for
+// the symbols with a nonzero frequency; at least two must have one
+// Frequencies are flattened until the code fits the limit
+func zstdHuffmanLengths(freq []int, limit int) []uint8 {
+	freq = append([]int(nil), freq...)
+	for {
+		var syms []int
+		for s, f := range freq {
+			if f > 0 {
+				syms = append(syms, s)
+			}
+		}
+		sort.SliceStable(syms, func(a, b int) bool { return freq[syms[a]] < freq[syms[b]] })
+
+		// Leaves sorted by frequency and internal nodes in creation order are
+		// both ascending, so the two smallest are always at their fronts
+		n := len(syms)
+		weight := make([]int, 2*n-1)
+		parent := make([]int, 2*n-1)
+		for i, s := range syms {
+			weight[i] = freq[s]
+		}
+		leaf, internal := 0, n
+		pick := func(next int) int {
+			if leaf < n && (internal >= next || weight[leaf] <= weight[internal]) {
+				leaf++
+				return leaf - 1
+			}
+			internal++
+			return internal - 1
+		}
+		for next := n; next < 2*n-1; next++ {
+			a, b := pick(next), pick(next)
+			weight[next] = weight[a] + weight[b]
+			parent[a], parent[b] = next, next
+		}
+
+		depth := make([]int, 2*n-1)
+		lengths := make([]uint8, len(freq))
+		fits := true
+		for i := 2*n - 3; i >= 0; i-- {
+			depth[i] = depth[parent[i]] + 1
+			if i < n {
+				lengths[syms[i]] = uint8(depth[i])
+				fits = fits && depth[i] <= limit
+			}
+		}
+		if fits {
+			return lengths
+		}
+		for s := range freq {
+			if freq[s] > 0 {
+				freq[s] = (freq[s] + 1) / 2
+			}
+		}
+	}
+}
+
+// zstdAppendSequences appends a sequences section coded with the predefined tables
+func zstdAppendSequences(dst []byte, seqs []zstdSequence) []byte {
+	n := len(seqs)
+	switch {
+	case n < 128:
+		dst = append(dst, byte(n))
+	case n < 0x7F00:
+		dst = append(dst, byte(n>>8)+128, byte(n))
+	default:
+		dst = binary.LittleEndian.AppendUint16(append(dst, 255), uint16(n-0x7F00))
+	}
+	if n == 0 {
+		return dst
+	}
+	dst = append(dst, zstdModePredefined<<6|zstdModePredefined<<4|zstdModePredefined<<2)
+
+	llCodes := make([]uint8, n)
+	mlCodes := make([]uint8, n)
+	ofCodes := make([]uint8, n)
+	for i, seq := range seqs {
+		llCodes[i] = zstdCode(zstdLLBase[:], seq.litLen)
+		mlCodes[i] = zstdCode(zstdMLBase[:], seq.matchLen)
+		ofCodes[i] = uint8(bits.Len32(seq.offset) - 1)
+	}
+
+	// The decoder reads the bitstream backwards, so the last sequence comes
+	// first and each field is written in the reverse of its reading order
+	var w zstdBitWriter
+	w.out = dst
+	llState := zstdLLEncoder.initial(llCodes[n-1])
+	mlState := zstdMLEncoder.initial(mlCodes[n-1])
+	ofState := zstdOFEncoder.initial(ofCodes[n-1])
+	for i := n - 1; i >= 0; i-- {
+		if i < n-1 {
+			ofState = zstdOFEncoder.encode(&w, ofState, ofCodes[i])
+			mlState = zstdMLEncoder.encode(&w, mlState, mlCodes[i])
+			llState = zstdLLEncoder.encode(&w, llState, llCodes[i])
+		}
+		seq := seqs[i]
+		w.write(uint64(seq.litLen-zstdLLBase[llCodes[i]]), uint(zstdLLBits[llCodes[i]]))
+		w.write(uint64(seq.matchLen-zstdMLBase[mlCodes[i]]), uint(zstdMLBits[mlCodes[i]]))
+		w.write(uint64(seq.offset-1<<ofCodes[i]), uint(ofCodes[i]))
+	}
+	w.write(uint64(mlState), uint(zstdMLKind.predef.log))
+	w.write(uint64(ofState), uint(zstdOFKind.predef.log))
+	w.write(uint64(llState), uint(zstdLLKind.predef.log))
+	return w.close()
+}
+
+// zstdCode returns the largest code whose baseline is at most v
+func zstdCode(base []uint32, v uint32) uint8 {
+	return uint8(sort.Search(len(base), func(i int) bool { return base[i] > v }) - 1)
+}
+
+// zstdBitWriter appends bits to a bitstream, lowest first; decoders read it
+// backwards, starting at the end marker close writes
+type zstdBitWriter struct {
+	out []byte
+	acc uint64
+	n   uint // Bits in acc
+}
+
+func (w *zstdBitWriter) write(v uint64, n uint) {
+	w.acc |= (v & (1<<n - 1)) << w.n
+	w.n += n
+	for w.n >= 8 {
+		w.out = append(w.out, byte(w.acc))
+		w.acc >>= 8
+		w.n -= 8
+	}
+}
+
+func (w *zstdBitWriter) close() []byte {
+	w.write(1, 1)
+	if w.n > 0 {
+		w.out = append(w.out, byte(w.acc))
+		w.acc, w.n = 0, 0
+	}
+	return w.out
+}
+
+// zstdReverseBits reads a bitstream backwards, from the bit below its end marker
+type zstdReverseBits struct {
+	data []byte
+	pos  int // Bits left to read; negative once reads ran past the start
+}
+
+func (r *zstdReverseBits) init(data []byte) error {
+	if len(data) == 0 || data[len(data)-1] == 0 {
+		return errZstdCorrupt
+	}
+	r.data = data
+	r.pos = 8*(len(data)-1) + bits.Len8(data[len(data)-1]) - 1
+	return nil
+}
+
+// peek returns the next n bits (at most 56), reading zeros past the start
+func (r *zstdReverseBits) peek(n int) uint64 {
+	pos := r.pos - n
+	switch {
+	case n == 0 || pos <= -n:
+		return 0
+	case pos < 0:
+		return r.bitsAt(0, n+pos) << uint(-pos)
+	default:
+		return r.bitsAt(pos, n)
+	}
+}
+
+func (r *zstdReverseBits) bitsAt(pos, n int) uint64 {
+	i := pos >> 3
+	var v uint64
+	if i+8 <= len(r.data) {
+		v = binary.LittleEndian.Uint64(r.data[i:])
+	} else {
+		for j := len(r.data) - 1; j >= i; j-- {
+			v = v<<8 | uint64(r.data[j])
+		}
+	}
+	return v >> uint(pos&7) & (1<<uint(n) - 1)
+}
+
+func (r *zstdReverseBits) read(n int) uint64 {
+	v := r.peek(n)
+	r.pos -= n
+	return v
+}
+
+// zstdFSEEntry is a decoding table state: its symbol, and the next state's
+// baseline, to which nbBits bits read from the stream are added
+type zstdFSEEntry struct {
+	symbol uint8
+	nbBits uint8
+	base   uint16
+}
+
+type zstdFSETable struct {
+	log     int
+	entries []zstdFSEEntry
+}
+
+// zstdBuildFSE builds the decoding table of a normalized distribution
+func zstdBuildFSE(norm []int16, log int) (*zstdFSETable, error) {
+	size := 1 << log
+	entries := make([]zstdFSEEntry, size)
+	next := make([]uint16, len(norm))
+
+	// "Less than 1" symbols take the last states, the others are spread
+	high := size - 1
+	for s, c := range norm {
+		if c == -1 {
+			entries[high].symbol = uint8(s)
+			high--
+			next[s] = 1
+		} else {
+			next[s] = uint16(c)
+		}
+	}
+	step := size>>1 + size>>3 + 3
+	pos := 0
+	for s, c := range norm {
+		for i := 0; i < int(c); i++ {
+			entries[pos].symbol = uint8(s)
+			pos = (pos + step) & (size - 1)
+			for pos > high {
+				pos = (pos + step) & (size - 1)
+			}
+		}
+	}
+	if pos != 0 {
+		return nil, errZstdCorrupt
+	}
+
+	for u := range entries {
+		s := entries[u].symbol
+		state := next[s]
+		next[s]++
+		nbBits := log - (bits.Len16(state) - 1)
+		entries[u].nbBits = uint8(nbBits)
+		entries[u].base = uint16(int(state)<<nbBits - size)
+	}
+	return &zstdFSETable{log: log, entries: entries}, nil
+}
+
+func mustBuildFSE(norm []int16, log int) *zstdFSETable {
+	table, err := zstdBuildFSE(norm, log)
+	if err != nil {
+		panic(err)
+	}
+	return table
+}
+
+// zstdFSEEncoder codes symbols for a decoding table: coding a symbol picks
+// the state of that symbol from which the decoder reaches the current state
+type zstdFSEEncoder struct {
+	table  *zstdFSETable
+	states [][]uint16 // States of each symbol, by ascending baseline
+}
+
+func newFSEEncoder(table *zstdFSETable, symbols int) *zstdFSEEncoder {
+	e := &zstdFSEEncoder{table: table, states: make([][]uint16, symbols)}
+	for u, entry := range table.entries {
+		e.states[entry.symbol] = append(e.states[entry.symbol], uint16(u))
+	}
+	for _, states := range e.states {
+		sort.Slice(states, func(a, b int) bool {
+			return table.entries[states[a]].base < table.entries[states[b]].base
+		})
+	}
+	return e
+}
+
+// initial returns a state of symbol to start coding from
+func (e *zstdFSEEncoder) initial(symbol uint8) uint16 {
+	return e.states[symbol][0]
+}
+
+// encode writes the bits that take the decoder from the returned state of
+// symbol to state
+func (e *zstdFSEEncoder) encode(w *zstdBitWriter, state uint16, symbol uint8) uint16 {
+	states := e.states[symbol]
+	i := sort.Search(len(states), func(i int) bool {
+		return e.table.entries[states[i]].base > state
+	}) - 1
+	entry := e.table.entries[states[i]]
+	w.write(uint64(state-entry.base), uint(entry.nbBits))
+	return states[i]
+}
+
+// zstdReadFSEDistribution reads a normalized distribution of at most
+// maxSymbol+1 symbols (RFC 8878 section 4.1.1) and returns it with its
+// accuracy log and the number of bytes it took
+func zstdReadFSEDistribution(src []byte, maxSymbol, maxLog int) ([]int16, int, int, error) {
+	bitPos := 0
+	peek := func(n int) int {
+		var v uint64
+		for i := min(bitPos>>3+5, len(src)) - 1; i >= bitPos>>3; i-- {
+			v = v<<8 | uint64(src[i])
+		}
+		return int(v>>uint(bitPos&7)) & (1<<n - 1)
+	}
+
+	log := peek(4) + 5
+	bitPos += 4
+	if log > maxLog {
+		return nil, 0, 0, fmt.Errorf("%w: accuracy log %d exceeds %d", errZstdCorrupt, log, maxLog)
+	}
+
+	norm := make([]int16, 0, maxSymbol+1)
+	remaining := 1<<log + 1
+	threshold := 1 << log
+	nbBits := log + 1
+	previous0 := false
+	for remaining > 1 && len(norm) <= maxSymbol {
+		if previous0 {
+			// 2-bit flags repeat the zero probability; 3 means more flags follow
+			zeros := 0
+			for peek(2) == 3 {
+				zeros += 3
+				bitPos += 2
+			}
+			zeros += peek(2)
+			bitPos += 2
+			if len(norm)+zeros > maxSymbol {
+				return nil, 0, 0, errZstdCorrupt
+			}
+			for ; zeros > 0; zeros-- {
+				norm = append(norm, 0)
+			}
+		}
+
+		maxValue := 2*threshold - 1 - remaining
+		count := peek(nbBits)
+		if count&(threshold-1) < maxValue {
+			count &= threshold - 1
+			bitPos += nbBits - 1
+		} else {
+			count &= 2*threshold - 1
+			if count >= threshold {
+				count -= maxValue
+			}
+			bitPos += nbBits
+		}
+		count-- // -1 is the "less than 1" probability, which takes one state
+		if count < 0 {
+			remaining--
+		} else {
+			remaining -= count
+		}
+		if remaining < 1 {
+			return nil, 0, 0, errZstdCorrupt
+		}
+		norm = append(norm, int16(count))
+		previous0 = count == 0
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	n := (bitPos + 7) >> 3
+	if remaining != 1 || n > len(src) {
+		return nil, 0, 0, errZstdCorrupt
+	}
+	return norm, log, n, nil
+}
+
+// zstdHuffmanEntry is a Huffman decoding table slot: the symbol whose code
+// prefixes the slot's index, and the code's length
+type zstdHuffmanEntry struct {
+	symbol uint8
+	nbBits uint8
+}
+
+type zstdHuffmanTable struct {
+	maxBits int
+	entries []zstdHuffmanEntry
+}
+
+// zstdReadHuffmanTable reads a Huffman tree description and returns the
+// decoding table and the number of bytes it took
+func zstdReadHuffmanTable(src []byte) (*zstdHuffmanTable, int, error) {
+	if len(src) == 0 {
+		return nil, 0, errZstdCorrupt
+	}
+	var weights []uint8
+	n := 1
+	if header := int(src[0]); header >= 128 {
+		// Direct representation: 4 bits per weight
+		count := header - 127
+		n += (count + 1) / 2
+		if n > len(src) {
+			return nil, 0, errZstdCorrupt
+		}
+		for i := 0; i < count; i++ {
+			weights = append(weights, src[1+i/2]>>(4*(1-i%2))&0xF)
+		}
+	} else {
+		// FSE-compressed weights, decoded by two interleaved states
+		n += header
+		if n > len(src) {
+			return nil, 0, errZstdCorrupt
+		}
+		norm, log, used, err := zstdReadFSEDistribution(src[1:n], 255, 6)
+		if err != nil {
+			return nil, 0, err
+		}
+		table, err := zstdBuildFSE(norm, log)
+		if err != nil {
+			return nil, 0, err
+		}
+		var r zstdReverseBits
+		if err := r.init(src[1+used : n]); err != nil {
+			return nil, 0, err
+		}
+		states := [2]uint64{r.read(log), r.read(log)}
+		for i := 0; ; i ^= 1 {
+			if len(weights) > 254 {
+				return nil, 0, errZstdCorrupt
+			}
+			entry := table.entries[states[i]]
+			weights = append(weights, entry.symbol)
+			states[i] = uint64(entry.base) + r.read(int(entry.nbBits))
+			if r.pos < 0 {
+				weights = append(weights, table.entries[states[i^1]].symbol)
+				break
+			}
+		}
+	}
+
+	// The last symbol's weight completes the sum to a power of two
+	total := 0
+	for _, w := range weights {
+		if w > zstdMaxHuffmanBits {
+			return nil, 0, errZstdCorrupt
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 {
+		return nil, 0, errZstdCorrupt
+	}
+	maxBits := bits.Len(uint(total))
+	rest := 1<<maxBits - total
+	if maxBits > zstdMaxHuffmanBits || rest&(rest-1) != 0 {
+		return nil, 0, errZstdCorrupt
+	}
+	weights = append(weights, uint8(bits.Len(uint(rest))))
+
+	var rankStart [zstdMaxHuffmanBits + 2]int
+	for _, w := range weights {
+		if w > 0 {
+			rankStart[w+1] += 1 << (w - 1)
+		}
+	}
+	for w := 1; w <= maxBits; w++ {
+		rankStart[w+1] += rankStart[w]
+	}
+	entries := make([]zstdHuffmanEntry, 1<<maxBits)
+	for s, w := range weights {
+		if w == 0 {
+			continue
+		}
+		entry := zstdHuffmanEntry{symbol: uint8(s), nbBits: uint8(maxBits + 1 - int(w))}
+		for i := 0; i < 1<<(w-1); i++ {
+			entries[rankStart[w]+i] = entry
+		}
+		rankStart[w] += 1 << (w - 1)
+	}
+	return &zstdHuffmanTable{maxBits: maxBits, entries: entries}, n, nil
+}
+
+// decode appends the n symbols of one Huffman-coded stream to dst
+func (h *zstdHuffmanTable) decode(dst, stream []byte, n int) ([]byte, error) {
+	var r zstdReverseBits
+	if err := r.init(stream); err != nil {
+		return nil, err
+	}
+	for i := 0; i < n; i++ {
+		entry := h.entries[r.peek(h.maxBits)]
+		dst = append(dst, entry.symbol)
+		r.pos -= int(entry.nbBits)
+	}
+	if r.pos != 0 {
+		return nil, errZstdCorrupt
+	}
+	return dst, nil
+}
+
+// zstdDecoder holds the state a frame's blocks share: repeat offsets and the
+// tables later blocks may reuse
+type zstdDecoder struct {
+	rep     [3]uint32
+	huffman *zstdHuffmanTable
+	tables  [3]*zstdFSETable // Literal length, offset and match length
+}
+
+// zstdDecompress decodes the frames in src, which hold rawLen bytes
+func zstdDecompress(src []byte, rawLen int) ([]byte, error) {
+	out := make([]byte, 0, rawLen)
+	for len(src) > 0 {
+		if len(src) < 4 {
+			return nil, errZstdCorrupt
+		}
+		magic := binary.LittleEndian.Uint32(src)
+		if magic&^0xF == zstdSkippableMagic {
+			if len(src) < 8 || uint64(len(src)-8) < uint64(binary.LittleEndian.Uint32(src[4:])) {
+				return nil, errZstdCorrupt
+			}
+			src = src[8+int(binary.LittleEndian.Uint32(src[4:])):]
+			continue
+		}
+		if magic != zstdMagic {
+			return nil, fmt.Errorf("%w: bad magic %#x", errZstdCorrupt, magic)
+		}
+
+		var err error
+		if out, src, err = zstdDecodeFrame(out, src[4:], rawLen); err != nil {
+			return nil, err
+		}
+	}
+	if len(out) != rawLen {
+		return nil, fmt.Errorf("zstd block decoded to %d bytes, expected %d", len(out), rawLen)
+	}
+	return out, nil
+}
+
+// zstdDecodeFrame appends the content of the frame at the start of src,
+// after its magic, to out and returns the rest of src
+func zstdDecodeFrame(out, src []byte, rawLen int) ([]byte, []byte, error) {
+	if len(src) < 1 {
+		return nil, nil, errZstdCorrupt
+	}
+	descriptor := src[0]
+	src = src[1:]
+	if descriptor&0x08 != 0 {
+		return nil, nil, fmt.Errorf("%w: reserved header bit set", errZstdCorrupt)
+	}
+	singleSegment := descriptor&0x20 != 0
+	hasChecksum := descriptor&0x04 != 0
+
+	if !singleSegment {
+		// The window descriptor is skipped: rawLen bounds the output anyway
+		if len(src) < 1 {
+			return nil, nil, errZstdCorrupt
+		}
+		src = src[1:]
+	}
+	dictIDBytes := [4]int{0, 1, 2, 4}[descriptor&3]
+	contentSizeBytes := [4]int{0, 2, 4, 8}[descriptor>>6]
+	if singleSegment && contentSizeBytes == 0 {
+		contentSizeBytes = 1
+	}
+	if len(src) < dictIDBytes+contentSizeBytes {
+		return nil, nil, errZstdCorrupt
+	}
+	for _, b := range src[:dictIDBytes] {
+		if b != 0 {
+			return nil, nil, fmt.Errorf("%w: dictionaries are not supported", errZstdCorrupt)
+		}
+	}
+	src = src[dictIDBytes:]
+	contentSize := int64(-1)
+	switch contentSizeBytes {
+	case 1:
+		contentSize = int64(src[0])
+	case 2:
+		contentSize = int64(binary.LittleEndian.Uint16(src)) + 256
+	case 4:
+		contentSize = int64(binary.LittleEndian.Uint32(src))
+	case 8:
+		contentSize = int64(binary.LittleEndian.Uint64(src))
+	}
+	src = src[contentSizeBytes:]
+
+	frameStart := len(out)
+	d := zstdDecoder{rep: [3]uint32{1, 4, 8}}
+	for last := false; !last; {
+		if len(src) < 3 {
+			return nil, nil, errZstdCorrupt
+		}
+		h := uint32(src[0]) | uint32(src[1])<<8 | uint32(src[2])<<16
+		last = h&1 != 0
+		size := int(h >> 3)
+		src = src[3:]
+		if size > zstdMaxBlockSize {
+			return nil, nil, fmt.Errorf("%w: block of %d bytes", errZstdCorrupt, size)
+		}
+
+		var err error
+		switch h >> 1 & 3 {
+		case 0: // Raw
+			if size > len(src) {
+				return nil, nil, errZstdCorrupt
+			}
+			out = append(out, src[:size]...)
+		case 1: // RLE: one byte, repeated size times
+			if len(src) < 1 {
+				return nil, nil, errZstdCorrupt
+			}
+			for i := 0; i < size; i++ {
+				out = append(out, src[0])
+			}
+			size = 1
+		case 2:
+			if size > len(src) {
+				return nil, nil, errZstdCorrupt
+			}
+			if out, err = d.decodeBlock(out, frameStart, src[:size]); err != nil {
+				return nil, nil, err
+			}
+		default:
+			return nil, nil, fmt.Errorf("%w: reserved block type", errZstdCorrupt)
+		}
+		src = src[size:]
+		if len(out) > rawLen {
+			return nil, nil, fmt.Errorf("zstd frame decodes to more than %d bytes", rawLen)
+		}
+	}
+
+	if contentSize >= 0 && int64(len(out)-frameStart) != contentSize {
+		return nil, nil, fmt.Errorf("%w: frame content is %d bytes, header says %d", errZstdCorrupt, len(out)-frameStart, contentSize)
+	}
+	if hasChecksum {
+		if len(src) < 4 {
+			return nil, nil, errZstdCorrupt
+		}
+		if uint32(xxhash64(out[frameStart:])) != binary.LittleEndian.Uint32(src) {
+			return nil, nil, fmt.Errorf("%w: content checksum mismatch", errZstdCorrupt)
+		}
+		src = src[4:]
+	}
+	return out, src, nil
+}
+
+// decodeBlock appends the content of a compressed block to out
+// Matches may reach back to the start of the frame, at frameStart
+func (d *zstdDecoder) decodeBlock(out []byte, frameStart int, block []byte) ([]byte, error) {
+	literals, n, err := d.decodeLiterals(block)
+	if err != nil {
+		return nil, err
+	}
+	seqs, err := d.decodeSequences(block[n:])
+	if err != nil {
+		return nil, err
+	}
+
+	blockStart := len(out)
+	for _, seq := range seqs {
+		if int(seq.litLen) > len(literals) {
+			return nil, fmt.Errorf("%w: sequence overruns literals", errZstdCorrupt)
+		}
+		out = append(out, literals[:seq.litLen]...)
+		literals = literals[seq.litLen:]
+
+		offset := d.resolveOffset(seq.offset, seq.litLen == 0)
+		if offset == 0 || int(offset) > len(out)-frameStart {
+			return nil, fmt.Errorf("%w: match offset %d out of range", errZstdCorrupt, offset)
+		}
+		if len(out)-blockStart+int(seq.matchLen) > zstdMaxBlockSize {
+			return nil, errZstdCorrupt
+		}
+		from := len(out) - int(offset)
+		if int(offset) >= int(seq.matchLen) {
+			out = append(out, out[from:from+int(seq.matchLen)]...)
+		} else {
+			for i := 0; i < int(seq.matchLen); i++ { // Overlapping: repeats the last offset bytes
+				out = append(out, out[from+i])
+			}
+		}
+	}
+	return append(out, literals...), nil
+}
+
+// resolveOffset turns an offset value into a match distance, updating the
+// repeat offsets; without literals, repeat codes shift by one
+func (d *zstdDecoder) resolveOffset(value uint32, noLiterals bool) uint32 {
+	if value > 3 {
+		d.rep = [3]uint32{value - 3, d.rep[0], d.rep[1]}
+		return d.rep[0]
+	}
+	i := int(value) - 1
+	if noLiterals {
+		i++
+	}
+	var offset uint32
+	switch i {
+	case 0:
+		return d.rep[0]
+	case 1:
+		offset = d.rep[1]
+		d.rep[1] = d.rep[0]
+	case 2:
+		offset = d.rep[2]
+		d.rep[2], d.rep[1] = d.rep[1], d.rep[0]
+	default:
+		offset = d.rep[0] - 1
+		d.rep[2], d.rep[1] = d.rep[1], d.rep[0]
+	}
+	d.rep[0] = offset
+	return offset
+}
+
+// decodeLiterals decodes a block's literals section and returns the
+// literals and the section's size
+func (d *zstdDecoder) decodeLiterals(block []byte) ([]byte, int, error) {
+	if len(block) < 1 {
+		return nil, 0, errZstdCorrupt
+	}
+	blockType := int(block[0] & 3)
+	sizeFormat := int(block[0] >> 2 & 3)
+
+	if blockType == zstdLiteralsRaw || blockType == zstdLiteralsRLE {
+		var size, headerSize int
+		switch sizeFormat {
+		case 0, 2:
+			size, headerSize = int(block[0]>>3), 1
+		case 1:
+			if len(block) < 2 {
+				return nil, 0, errZstdCorrupt
+			}
+			size, headerSize = int(block[0]>>4)|int(block[1])<<4, 2
+		default:
+			if len(block) < 3 {
+				return nil, 0, errZstdCorrupt
+			}
+			size, headerSize = int(block[0]>>4)|int(block[1])<<4|int(block[2])<<12, 3
+		}
+		if size > zstdMaxBlockSize {
+			return nil, 0, errZstdCorrupt
+		}
+		if blockType == zstdLiteralsRaw {
+			if headerSize+size > len(block) {
+				return nil, 0, errZstdCorrupt
+			}
+			return block[headerSize : headerSize+size], headerSize + size, nil
+		}
+		if headerSize >= len(block) {
+			return nil, 0, errZstdCorrupt
+		}
+		literals := make([]byte, size)
+		for i := range literals {
+			literals[i] = block[headerSize]
+		}
+		return literals, headerSize + 1, nil
+	}
+
+	// Huffman-coded, in one stream or four
+	var h uint64
+	for i := min(5, len(block)) - 1; i >= 0; i-- {
+		h = h<<8 | uint64(block[i])
+	}
+	var size, compressedSize, headerSize int
+	switch sizeFormat {
+	case 0, 1:
+		size, compressedSize, headerSize = int(h>>4&0x3FF), int(h>>14&0x3FF), 3
+	case 2:
+		size, compressedSize, headerSize = int(h>>4&0x3FFF), int(h>>18&0x3FFF), 4
+	default:
+		size, compressedSize, headerSize = int(h>>4&0x3FFFF), int(h>>22&0x3FFFF), 5
+	}
+	if size > zstdMaxBlockSize || headerSize+compressedSize > len(block) {
+		return nil, 0, errZstdCorrupt
+	}
+	data := block[headerSize : headerSize+compressedSize]
+	if blockType == zstdLiteralsCompressed {
+		table, n, err := zstdReadHuffmanTable(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		d.huffman = table
+		data = data[n:]
+	} else if d.huffman == nil {
+		return nil, 0, fmt.Errorf("%w: treeless literals without a previous table", errZstdCorrupt)
+	}
+
+	literals := make([]byte, 0, size)
+	var err error
+	if sizeFormat == 0 {
+		literals, err = d.huffman.decode(literals, data, size)
+	} else {
+		if len(data) < 6 {
+			return nil, 0, errZstdCorrupt
+		}
+		segment := (size + 3) / 4
+		if 3*segment > size {
+			return nil, 0, errZstdCorrupt
+		}
+		streams := data[6:]
+		for i := 0; i < 4 && err == nil; i++ {
+			streamSize, n := len(streams), size-3*segment
+			if i < 3 {
+				streamSize, n = int(binary.LittleEndian.Uint16(data[2*i:])), segment
+			}
+			if streamSize > len(streams) {
+				return nil, 0, errZstdCorrupt
+			}
+			literals, err = d.huffman.decode(literals, streams[:streamSize], n)
+			streams = streams[streamSize:]
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return literals, headerSize + compressedSize, nil
+}
+
+// decodeSequences decodes a block's sequences section
+func (d *zstdDecoder) decodeSequences(src []byte) ([]zstdSequence, error) {
+	if len(src) < 1 {
+		return nil, errZstdCorrupt
+	}
+	n := int(src[0])
+	switch {
+	case n == 0:
+		return nil, nil
+	case n < 128:
+		src = src[1:]
+	case n < 255:
+		if len(src) < 2 {
+			return nil, errZstdCorrupt
+		}
+		n = (n-128)<<8 | int(src[1])
+		src = src[2:]
+	default:
+		if len(src) < 3 {
+			return nil, errZstdCorrupt
+		}
+		n = int(binary.LittleEndian.Uint16(src[1:])) + 0x7F00
+		src = src[3:]
+	}
+
+	if len(src) < 1 || src[0]&3 != 0 {
+		return nil, errZstdCorrupt
+	}
+	modes := src[0]
+	src = src[1:]
+	for i, kind := range []zstdSeqKind{zstdLLKind, zstdOFKind, zstdMLKind} {
+		table, used, err := d.readTable(kind, int(modes>>(6-2*i)&3), d.tables[i], src)
+		if err != nil {
+			return nil, err
+		}
+		d.tables[i] = table
+		src = src[used:]
+	}
+	ll, of, ml := d.tables[0], d.tables[1], d.tables[2]
+
+	var r zstdReverseBits
+	if err := r.init(src); err != nil {
+		return nil, err
+	}
+	llState, ofState, mlState := r.read(ll.log), r.read(of.log), r.read(ml.log)
+	seqs := make([]zstdSequence, n)
+	for i := range seqs {
+		llCode := ll.entries[llState].symbol
+		ofCode := of.entries[ofState].symbol
+		mlCode := ml.entries[mlState].symbol
+		if int(llCode) > zstdLLKind.maxSymbol || int(ofCode) > zstdOFKind.maxSymbol || int(mlCode) > zstdMLKind.maxSymbol {
+			return nil, errZstdCorrupt
+		}
+		seqs[i].offset = 1<<ofCode + uint32(r.read(int(ofCode)))
+		seqs[i].matchLen = zstdMLBase[mlCode] + uint32(r.read(int(zstdMLBits[mlCode])))
+		seqs[i].litLen = zstdLLBase[llCode] + uint32(r.read(int(zstdLLBits[llCode])))
+
+		if i < n-1 {
+			entry := ll.entries[llState]
+			llState = uint64(entry.base) + r.read(int(entry.nbBits))
+			entry = ml.entries[mlState]
+			mlState = uint64(entry.base) + r.read(int(entry.nbBits))
+			entry = of.entries[ofState]
+			ofState = uint64(entry.base) + r.read(int(entry.nbBits))
+		}
+	}
+	if r.pos != 0 {
+		return nil, fmt.Errorf("%w: sequences bitstream not fully consumed", errZstdCorrupt)
+	}
+	return seqs, nil
+}
+
+// readTable reads the decoding table of one sequence code for the given
+// mode and returns it with the number of bytes it took
+func (d *zstdDecoder) readTable(kind zstdSeqKind, mode int, previous *zstdFSETable, src []byte) (*zstdFSETable, int, error) {
+	switch mode {
+	case zstdModePredefined:
+		return kind.predef, 0, nil
+	case zstdModeRLE:
+		if len(src) < 1 || int(src[0]) > kind.maxSymbol {
+			return nil, 0, errZstdCorrupt
+		}
+		return &zstdFSETable{entries: []zstdFSEEntry{{symbol: src[0]}}}, 1, nil
+	case zstdModeCompressed:
+		norm, log, n, err := zstdReadFSEDistribution(src, kind.maxSymbol, kind.maxLog)
+		if err != nil {
+			return nil, 0, err
+		}
+		table, err := zstdBuildFSE(norm, log)
+		if err != nil {
+			return nil, 0, err
+		}
+		return table, n, nil
+	default:
+		if previous == nil {
+			return nil, 0, fmt.Errorf("%w: repeated table without a previous one", errZstdCorrupt)
+		}
+		return previous, 0, nil
+	}
+}
+
+// XXH64 (https://github.com/Cyan4973/xxHash/blob/dev/doc/xxhash_spec.md),
+// whose low 32 bits are a frame's content checksum
+const (
+	xxhPrime1 uint64 = 11400714785074694791
+	xxhPrime2 uint64 = 14029467366897019727
+	xxhPrime3 uint64 = 1609587929392839161
+	xxhPrime4 uint64 = 9650029242287828579
+	xxhPrime5 uint64 = 2870177450012600261
+)
+
+func xxhash64(b []byte) uint64 {
+	n := len(b)
+	var h uint64
+	if n >= 32 {
+		v1, v2, v3, v4 := xxhPrime1, xxhPrime2, uint64(0), uint64(0)
+		v1 += xxhPrime2
+		v4 -= xxhPrime1
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = xxhRound(v1, binary.LittleEndian.Uint64(b))
+			v2 = xxhRound(v2, binary.LittleEndian.Uint64(b[8:]))
+			v3 = xxhRound(v3, binary.LittleEndian.Uint64(b[16:]))
+			v4 = xxhRound(v4, binary.LittleEndian.Uint64(b[24:]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		for _, v := range []uint64{v1, v2, v3, v4} {
+			h = (h^xxhRound(0, v))*xxhPrime1 + xxhPrime4
+		}
+	} else {
+		h = xxhPrime5
+	}
+	h += uint64(n)
+
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxhRound(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*xxhPrime1 + xxhPrime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * xxhPrime1
+		h = bits.RotateLeft64(h, 23)*xxhPrime2 + xxhPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxhPrime5
+		h = bits.RotateLeft64(h, 11) * xxhPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxhPrime2
+	h ^= h >> 29
+	h *= xxhPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxhRound(acc, input uint64) uint64 {
+	return bits.RotateLeft64(acc+input*xxhPrime2, 31) * xxhPrime1
+}
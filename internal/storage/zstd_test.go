@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Golden frames in testdata/zstd were written by the reference CLI (v1.5.6):
+//
+//	zstd -1|-3|-19|--fast=5 -c NAME > NAME.LEVEL.zst
+//	zstd -3 --no-check -c < NAME > NAME.stream.zst
+//
+// The files read from a file carry the content size and a checksum; those
+// read from stdin have neither, and a window descriptor instead
+var zstdGoldenInputs = []struct {
+	name   string
+	size   int
+	sha256 string
+}{
+	{"empty", 0, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+	{"json", 53751, "2bf7933d1e559f31bd314261d1089063f0003aa6168a2fc5f9d77f0d134cb529"},   // JSON lines
+	{"large", 160044, "5dc239d7744af83c6b646e66775b73612df9e5d299d856050ac63af77a1dc6d6"}, // Text over several blocks
+	{"random", 8192, "ae260a4b51376c0c5e4b9f33e681de326b1f8416d0183f515b6484b8468c9743"},  // Incompressible
+	{"runs", 91230, "8101f959ec9660d8086ec18a6c230b14cb0aa7d7b247304c5628d930f3dafb7f"},   // Long runs of one byte
+}
+
+func TestZstdGoldenFrames(t *testing.T) {
+	for _, input := range zstdGoldenInputs {
+		paths, err := filepath.Glob(filepath.Join("testdata", "zstd", input.name+".*.zst"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(paths) == 0 {
+			t.Fatalf("no golden frames for %s", input.name)
+		}
+		for _, path := range paths {
+			t.Run(filepath.Base(path), func(t *testing.T) {
+				frame, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				out, err := zstdDecompress(frame, input.size)
+				if err != nil {
+					t.Fatalf("decompress: %v", err)
+				}
+				sum := sha256.Sum256(out)
+				if len(out) != input.size || hex.EncodeToString(sum[:]) != input.sha256 {
+					t.Errorf("decoded %d bytes with sha256 %x, want %d bytes with %s", len(out), sum, input.size, input.sha256)
+				}
+			})
+		}
+	}
+}
+
+func TestZstdCorruptFrames(t *testing.T) {
+	golden, err := os.ReadFile(filepath.Join("testdata", "zstd", "json.3.zst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 53751
+	corrupt := func(change func(frame []byte) []byte) []byte {
+		return change(append([]byte(nil), golden...))
+	}
+
+	tests := []struct {
+		name   string
+		frame  []byte
+		rawLen int
+	}{
+		{"empty input", nil, 1},
+		{"bad magic", corrupt(func(f []byte) []byte { f[0]++; return f }), size},
+		{"reserved header bit", corrupt(func(f []byte) []byte { f[4] |= 0x08; return f }), size},
+		{"truncated header", golden[:5], size},
+		{"truncated block", golden[:len(golden)/2], size},
+		{"missing checksum", golden[:len(golden)-4], size},
+		{"checksum mismatch", corrupt(func(f []byte) []byte { f[len(f)-1] ^= 1; return f }), size},
+		{"flipped literal bits", corrupt(func(f []byte) []byte { f[len(f)/2] ^= 0x10; return f }), size},
+		{"trailing garbage", append(append([]byte(nil), golden...), 1, 2, 3, 4, 5), size},
+		{"content larger than expected", golden, size - 1},
+		{"content smaller than expected", golden, size + 1},
+		{"reserved block type", binary.LittleEndian.AppendUint32(nil, zstdMagic), 0},
+	}
+	// A frame holding one block of the reserved type 3
+	tests[len(tests)-1].frame = append(tests[len(tests)-1].frame, 0x20, 0, 7, 0, 0)
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := zstdDecompress(tc.frame, tc.rawLen); err == nil {
+				t.Error("corrupt frame decoded without an error")
+			}
+		})
+	}
+}
+
+// zstdFuzzSeed is a valid frame and the size of its content
+type zstdFuzzSeed struct {
+	frame  []byte
+	rawLen int
+}
+
+// zstdFuzzSeeds returns frames from the reference CLI and from zstdCompress
+func zstdFuzzSeeds(tb testing.TB) []zstdFuzzSeed {
+	var seeds []zstdFuzzSeed
+	for _, input := range zstdGoldenInputs {
+		paths, err := filepath.Glob(filepath.Join("testdata", "zstd", input.name+".*.zst"))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		for _, path := range paths {
+			frame, err := os.ReadFile(path)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			if len(frame) <= 16<<10 {
+				seeds = append(seeds, zstdFuzzSeed{frame, input.size})
+			}
+		}
+	}
+	for _, input := range codecTestInputs() {
+		if len(input.data) <= 16<<10 {
+			seeds = append(seeds, zstdFuzzSeed{zstdCompress(nil, input.data), len(input.data)})
+		}
+	}
+	return seeds
+}
+
+func FuzzZstdDecode(f *testing.F) {
+	for _, seed := range zstdFuzzSeeds(f) {
+		f.Add(seed.frame, uint32(seed.rawLen))
+	}
+	f.Fuzz(func(t *testing.T, frame []byte, rawLen uint32) {
+		rawLen %= 1 << 20
+		out, err := zstdDecompress(frame, int(rawLen))
+		if err == nil && len(out) != int(rawLen) {
+			t.Fatalf("decoded %d bytes, expected %d", len(out), rawLen)
+		}
+	})
+}
+
+func FuzzZstdRoundTrip(f *testing.F) {
+	for _, input := range codecTestInputs() {
+		if len(input.data) <= 16<<10 {
+			f.Add(input.data)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		out, err := zstdDecompress(zstdCompress(nil, data), len(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatal("round trip changed the data")
+		}
+	})
+}
@@ -0,0 +1,313 @@
+package types
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Binary document encoding, used for documents stored in segments and the WAL
+//
+//	document: [version:u8][id:string][version:varint][created:time][updated:time][fields]
+//	fields:   [count:uvarint] then per field, sorted by name: [name:string][type:u8][value]
+//	string:   [len:uvarint][bytes]
+//	time:     [unix seconds:varint][nanoseconds:uvarint], in UTC
+//
+// Values are text and keyword as strings, numeric as float64 bits (u64), boolean
+// as a u8, date as a time, vector as [dim:uvarint] and float32 bits (u32) per
+// element, geo_point as lat and lon float64 bits, completion as [count:uvarint]
+// then [input:string][weight:uvarint] per input, and object as nested fields.
+// Fixed-width numbers are little-endian
+//
+// Unlike JSON, vectors keep their float32 values and dates their nanoseconds,
+// and decoding needs no reflection
+const DocumentEncodingVersion = 1
+
+// Field type tags of the binary encoding
+const (
+	tagText byte = iota + 1
+	tagKeyword
+	tagNumeric
+	tagBoolean
+	tagDate
+	tagVector
+	tagGeoPoint
+	tagObject
+	tagCompletion
+)
+
+var errTruncated = errors.New("truncated document")
+
+// MarshalBinary encodes the document in the binary document encoding
+func (d *Document) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, 256)
+	buf = append(buf, DocumentEncodingVersion)
+	buf = appendString(buf, d.ID)
+	buf = binary.AppendVarint(buf, d.Version)
+	buf = appendTime(buf, d.Created)
+	buf = appendTime(buf, d.Updated)
+	return appendFields(buf, d.Fields)
+}
+
+// UnmarshalBinary decodes a document written by MarshalBinary
+func (d *Document) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return errTruncated
+	}
+	if data[0] != DocumentEncodingVersion {
+		return fmt.Errorf("unsupported document encoding version %d (expected %d)", data[0], DocumentEncodingVersion)
+	}
+
+	r := &binaryReader{data: data[1:]}
+	id := r.str()
+	version := r.varint()
+	created := r.time()
+	updated := r.time()
+	fields := r.fields()
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.data) > 0 {
+		return fmt.Errorf("%d trailing bytes after document", len(r.data))
+	}
+
+	d.ID, d.Version, d.Created, d.Updated, d.Fields = id, version, created, updated, fields
+	return nil
+}
+
+// DecodeDocument decodes a stored document in either the binary encoding or
+// the JSON encoding used before it; JSON documents always start with '{'
+func DecodeDocument(data []byte) (*Document, error) {
+	var doc Document
+	if IsJSONDocument(data) {
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		return &doc, nil
+	}
+	if err := doc.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// IsJSONDocument reports whether stored document bytes use the legacy JSON encoding
+func IsJSONDocument(data []byte) bool {
+	return len(data) > 0 && data[0] == '{'
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendTime(buf []byte, t time.Time) []byte {
+	buf = binary.AppendVarint(buf, t.Unix())
+	return binary.AppendUvarint(buf, uint64(t.Nanosecond()))
+}
+
+func appendFields(buf []byte, fields map[string]FieldValue) ([]byte, error) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf = binary.AppendUvarint(buf, uint64(len(names)))
+	for _, name := range names {
+		buf = appendString(buf, name)
+		var err error
+		if buf, err = appendValue(buf, fields[name]); err != nil {
+			return nil, fmt.Errorf("field %s: %w", name, err)
+		}
+	}
+	return buf, nil
+}
+
+func appendValue(buf []byte, value FieldValue) ([]byte, error) {
+	switch v := value.(type) {
+	case TextValue:
+		return appendString(append(buf, tagText), v.Value), nil
+	case KeywordValue:
+		return appendString(append(buf, tagKeyword), v.Value), nil
+	case NumericValue:
+		return binary.LittleEndian.AppendUint64(append(buf, tagNumeric), math.Float64bits(v.Value)), nil
+	case BooleanValue:
+		b := byte(0)
+		if v.Value {
+			b = 1
+		}
+		return append(buf, tagBoolean, b), nil
+	case DateValue:
+		return appendTime(append(buf, tagDate), v.Value), nil
+	case VectorValue:
+		buf = binary.AppendUvarint(append(buf, tagVector), uint64(len(v.Value)))
+		for _, x := range v.Value {
+			buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(x))
+		}
+		return buf, nil
+	case GeoPointValue:
+		buf = binary.LittleEndian.AppendUint64(append(buf, tagGeoPoint), math.Float64bits(v.Lat))
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(v.Lon)), nil
+	case CompletionValue:
+		buf = binary.AppendUvarint(append(buf, tagCompletion), uint64(len(v.Inputs)))
+		for _, in := range v.Inputs {
+			buf = binary.AppendUvarint(appendString(buf, in.Input), uint64(in.Weight))
+		}
+		return buf, nil
+	case ObjectValue:
+		return appendFields(append(buf, tagObject), v.Fields)
+	}
+	return nil, fmt.Errorf("cannot encode %T", value)
+}
+
+// binaryReader decodes the binary encoding; the first error sticks and
+// later reads return zero values
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+func (r *binaryReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.data = nil
+}
+
+func (r *binaryReader) bytes(n uint64) []byte {
+	if r.err != nil || n > uint64(len(r.data)) {
+		r.fail(errTruncated)
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *binaryReader) uint8() byte {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.fail(errTruncated)
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *binaryReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.fail(errTruncated)
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *binaryReader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *binaryReader) float64() float64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+func (r *binaryReader) str() string {
+	return string(r.bytes(r.uvarint()))
+}
+
+func (r *binaryReader) time() time.Time {
+	sec := r.varint()
+	nsec := r.uvarint()
+	if r.err != nil {
+		return time.Time{}
+	}
+	return time.Unix(sec, int64(nsec)).UTC()
+}
+
+func (r *binaryReader) fields() map[string]FieldValue {
+	count := r.uvarint()
+	if count > uint64(len(r.data)) { // Every field takes at least one byte
+		r.fail(errTruncated)
+		return nil
+	}
+	fields := make(map[string]FieldValue, count)
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		name := r.str()
+		fields[name] = r.value()
+	}
+	return fields
+}
+
+func (r *binaryReader) value() FieldValue {
+	switch tag := r.uint8(); tag {
+	case tagText:
+		return TextValue{Value: r.str()}
+	case tagKeyword:
+		return KeywordValue{Value: r.str()}
+	case tagNumeric:
+		return NumericValue{Value: r.float64()}
+	case tagBoolean:
+		return BooleanValue{Value: r.uint8() != 0}
+	case tagDate:
+		return DateValue{Value: r.time()}
+	case tagVector:
+		dim := r.uvarint()
+		if dim > uint64(len(r.data))/4 {
+			r.fail(errTruncated)
+			return nil
+		}
+		vec := make([]float32, dim)
+		for i := range vec {
+			vec[i] = math.Float32frombits(r.uint32())
+		}
+		return VectorValue{Value: vec, Dim: len(vec)}
+	case tagGeoPoint:
+		return GeoPointValue{Lat: r.float64(), Lon: r.float64()}
+	case tagObject:
+		return ObjectValue{Fields: r.fields()}
+	case tagCompletion:
+		count := r.uvarint()
+		if count > uint64(len(r.data)) { // Every input takes at least two bytes
+			r.fail(errTruncated)
+			return nil
+		}
+		inputs := make([]CompletionInput, count)
+		for i := range inputs {
+			inputs[i] = CompletionInput{Input: r.str(), Weight: int(r.uvarint())}
+		}
+		return CompletionValue{Inputs: inputs}
+	default:
+		if r.err == nil {
+			r.fail(fmt.Errorf("unknown field type tag %d", tag))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,118 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// CompletionInput is one text a completion field suggests, with its weight
+// Higher weights rank first among the completions of a prefix
+type CompletionInput struct {
+	Input  string
+	Weight int
+}
+
+// CompletionValue represents a completion field value: the inputs suggested
+// for the document by the completion suggester
+type CompletionValue struct {
+	Inputs []CompletionInput
+}
+
+func (v CompletionValue) Type() FieldType { return FieldTypeCompletion }
+func (v CompletionValue) String() string {
+	inputs := make([]string, len(v.Inputs))
+	for i, in := range v.Inputs {
+		inputs[i] = in.Input
+	}
+	return strings.Join(inputs, ", ")
+}
+
+// ParseCompletion converts a decoded JSON value to a completion value,
+// accepting the Elasticsearch forms "Nirvana", ["Nevermind", "Nirvana"],
+// {"input": ["Nevermind", "Nirvana"], "weight": 34} and a list of such objects
+// Inputs without a weight weigh 1
+func ParseCompletion(raw interface{}) (CompletionValue, error) {
+	var v CompletionValue
+	switch r := raw.(type) {
+	case string:
+		v.Inputs = append(v.Inputs, CompletionInput{Input: r, Weight: 1})
+	case map[string]interface{}:
+		inputs, err := parseCompletionObject(r)
+		if err != nil {
+			return v, err
+		}
+		v.Inputs = inputs
+	case []interface{}:
+		for _, elem := range r {
+			switch e := elem.(type) {
+			case string:
+				v.Inputs = append(v.Inputs, CompletionInput{Input: e, Weight: 1})
+			case map[string]interface{}:
+				inputs, err := parseCompletionObject(e)
+				if err != nil {
+					return v, err
+				}
+				v.Inputs = append(v.Inputs, inputs...)
+			default:
+				return v, fmt.Errorf("completion inputs must be strings or objects, got %T", elem)
+			}
+		}
+	default:
+		return v, fmt.Errorf("cannot convert %T to a completion", raw)
+	}
+	if len(v.Inputs) == 0 {
+		return v, fmt.Errorf("completion must have at least one input")
+	}
+	for _, in := range v.Inputs {
+		if strings.TrimSpace(in.Input) == "" {
+			return v, fmt.Errorf("completion input must not be empty")
+		}
+	}
+	return v, nil
+}
+
+// parseCompletionObject decodes {"input": "Nirvana" or [...], "weight": 34}
+func parseCompletionObject(obj map[string]interface{}) ([]CompletionInput, error) {
+	weight := 1
+	for key, raw := range obj {
+		switch key {
+		case "input":
+		case "weight":
+			w, ok := raw.(float64)
+			if !ok || w < 0 || w != math.Trunc(w) || w > math.MaxInt32 {
+				return nil, fmt.Errorf("completion weight must be a non-negative integer, got %v", raw)
+			}
+			weight = int(w)
+		default:
+			return nil, fmt.Errorf("unknown completion parameter [%s]", key)
+		}
+	}
+
+	var inputs []CompletionInput
+	switch in := obj["input"].(type) {
+	case string:
+		inputs = append(inputs, CompletionInput{Input: in, Weight: weight})
+	case []interface{}:
+		for _, elem := range in {
+			s, ok := elem.(string)
+			if !ok {
+				return nil, fmt.Errorf("completion input must be a string, got %T", elem)
+			}
+			inputs = append(inputs, CompletionInput{Input: s, Weight: weight})
+		}
+	default:
+		return nil, fmt.Errorf("completion object requires [input]")
+	}
+	return inputs, nil
+}
+
+// completionSource returns the JSON form of a completion value, which
+// ParseCompletion reads back: [{"input": "Nirvana", "weight": 34}, ...]
+func completionSource(v CompletionValue) []interface{} {
+	out := make([]interface{}, len(v.Inputs))
+	for i, in := range v.Inputs {
+		out[i] = map[string]interface{}{"input": in.Input, "weight": in.Weight}
+	}
+	return out
+}
@@ -0,0 +1,73 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+)
+
+// WithCopyTo additionally indexes the field's values into other text fields,
+// e.g. title, description and author into one catch-all field searched at once
+// The targets hold no stored values; only text and keyword values are copied
+func WithCopyTo(fields ...string) FieldOption {
+	return func(f *FieldDef) {
+		f.CopyTo = fields
+	}
+}
+
+// ValidateCopyTo checks that copy_to targets are text fields, other than the
+// source, that don't copy further themselves
+// Undeclared targets are indexed like undeclared text fields
+func (s *Schema) ValidateCopyTo() error {
+	for name, def := range s.Fields {
+		for _, target := range def.CopyTo {
+			if target == name {
+				return fmt.Errorf("field %s: cannot copy_to itself", name)
+			}
+			targetDef, ok := s.Fields[target]
+			if !ok {
+				continue
+			}
+			if targetDef.Type != FieldTypeText {
+				return fmt.Errorf("field %s: copy_to target %s must be a text field, got %s", name, target, targetDef.Type)
+			}
+			if len(targetDef.CopyTo) > 0 {
+				return fmt.Errorf("field %s: copy_to target %s cannot copy_to other fields", name, target)
+			}
+		}
+	}
+	return nil
+}
+
+// TextValues returns the text to index for each field of a document (object
+// subfields by dot path): a text field's own value unless it isn't indexed,
+// then the values copied to it from other fields in field name order (see WithCopyTo)
+func (s *Schema) TextValues(doc *Document) map[string][]string {
+	flat := doc.Flatten().Fields
+	values := make(map[string][]string, len(flat))
+	var sources []string
+	for name, value := range flat {
+		if text, ok := value.(TextValue); ok && s.IsIndexed(name) {
+			values[name] = append(values[name], text.Value)
+		}
+		if def, ok := s.Fields[name]; ok && len(def.CopyTo) > 0 {
+			sources = append(sources, name)
+		}
+	}
+	sort.Strings(sources)
+
+	for _, name := range sources {
+		var text string
+		switch v := flat[name].(type) {
+		case TextValue:
+			text = v.Value
+		case KeywordValue:
+			text = v.Value
+		default:
+			continue
+		}
+		for _, target := range s.Fields[name].CopyTo {
+			values[target] = append(values[target], text)
+		}
+	}
+	return values
+}
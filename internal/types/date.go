@@ -0,0 +1,59 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Special date format names, in addition to Go time layouts
+const (
+	DateFormatEpochMillis = "epoch_millis" // Milliseconds since the Unix epoch
+	DateFormatEpochSecond = "epoch_second" // Seconds since the Unix epoch
+)
+
+// DefaultDateFormats are tried, in order, for date fields that don't declare formats
+var DefaultDateFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	DateFormatEpochMillis,
+}
+
+// ParseDate parses a date string using the first format that accepts it
+// Formats are Go time layouts or one of the DateFormatEpoch* names
+// Dates without a zone are interpreted as UTC
+func ParseDate(value string, formats []string) (time.Time, error) {
+	if len(formats) == 0 {
+		formats = DefaultDateFormats
+	}
+
+	value = strings.TrimSpace(value)
+	for _, format := range formats {
+		switch format {
+		case DateFormatEpochMillis:
+			if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+				return time.UnixMilli(ms).UTC(), nil
+			}
+		case DateFormatEpochSecond:
+			if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+				return time.Unix(sec, 0).UTC(), nil
+			}
+		default:
+			if t, err := time.ParseInLocation(format, value, time.UTC); err == nil {
+				return t, nil
+			}
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("cannot parse date %q with formats %v", value, formats)
+}
+
+// DateFormatsOrDefault returns the formats to use when parsing dates for the field
+func (f FieldDef) DateFormatsOrDefault() []string {
+	if len(f.DateFormats) > 0 {
+		return f.DateFormats
+	}
+	return DefaultDateFormats
+}
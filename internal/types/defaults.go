@@ -0,0 +1,74 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// WithRequired rejects documents without a value for the field (see ValidateDocument)
+func WithRequired() FieldOption {
+	return func(f *FieldDef) {
+		f.Required = true
+	}
+}
+
+// WithDefault sets the value given to documents without the field, as it would
+// appear in a JSON source (e.g. "draft", 3 or true; see ApplyDefaults)
+func WithDefault(value interface{}) FieldOption {
+	return func(f *FieldDef) {
+		f.Default = normalizeSource(value)
+	}
+}
+
+// normalizeSource converts a Go value to its decoded JSON form, so e.g. an int
+// default converts like the float64 a JSON number decodes to
+// Values that can't be encoded are kept, and fail in ValidateDefaults
+func normalizeSource(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return value
+	}
+	return decoded
+}
+
+// defaultValue converts a field's default to its typed value
+func (f FieldDef) defaultValue() (FieldValue, error) {
+	return fieldValueFromSource(f.Default, &f)
+}
+
+// ValidateDefaults checks that every default converts to its field's type
+func (s *Schema) ValidateDefaults() error {
+	for name, def := range s.Fields {
+		if def.Default == nil {
+			continue
+		}
+		if _, err := def.defaultValue(); err != nil {
+			return fmt.Errorf("field %s: invalid default: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ApplyDefaults sets the default of every field the document has no value for
+// Called before a document is validated and written, so stored documents
+// carry their defaults
+func (s *Schema) ApplyDefaults(doc *Document) error {
+	for name, def := range s.Fields {
+		if def.Default == nil {
+			continue
+		}
+		if _, ok := doc.GetField(name); ok {
+			continue
+		}
+		value, err := def.defaultValue()
+		if err != nil {
+			return &SchemaValidationError{Field: name, Expected: def.Type, Message: "invalid default: " + err.Error()}
+		}
+		setPath(doc.Fields, name, value)
+	}
+	return nil
+}
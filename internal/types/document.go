@@ -32,6 +32,9 @@ const (
 	FieldTypeVector  FieldType = "vector"   // Dense vector for similarity search
 	FieldTypeBoolean FieldType = "boolean"  // Boolean value
 	FieldTypeDate    FieldType = "date"     // Date/time
+	FieldTypeObject  FieldType = "object"   // Nested fields, addressed by dot paths
+	FieldTypeGeoPoint FieldType = "geo_point" // Latitude/longitude pair
+	FieldTypeCompletion FieldType = "completion" // Weighted inputs for prefix autocompletion
 )
 
 // TextValue represents a text field value
@@ -103,20 +106,21 @@ func NewDocument(id string) *Document {
 }
 
 // SetField sets a field value on the document
+// A dot path such as "author.name" sets a subfield, creating objects as needed
 func (d *Document) SetField(name string, value FieldValue) {
-	d.Fields[name] = value
+	setPath(d.Fields, name, value)
 	d.Updated = time.Now()
 }
 
 // GetField retrieves a field value from the document
+// A dot path such as "author.name" reads a subfield
 func (d *Document) GetField(name string) (FieldValue, bool) {
-	value, ok := d.Fields[name]
-	return value, ok
+	return getPath(d.Fields, name)
 }
 
 // GetFieldAsText retrieves a field as text, returns empty string if not found or wrong type
 func (d *Document) GetFieldAsText(name string) string {
-	value, ok := d.Fields[name]
+	value, ok := d.GetField(name)
 	if !ok {
 		return ""
 	}
@@ -131,18 +135,51 @@ func (d *Document) MarshalJSON() ([]byte, error) {
 		Fields map[string]interface{} `json:"fields"`
 	}{
 		Alias: (*Alias)(d),
-		Fields: make(map[string]interface{}),
+		Fields: marshalFields(d.Fields), // Convert FieldValue to a serializable format
 	}
 	
-	// Convert FieldValue to a serializable format
-	for k, v := range d.Fields {
-		aux.Fields[k] = map[string]interface{}{
+	return json.Marshal(aux)
+}
+
+// marshalFields tags each field value with its type so it can be decoded again
+// Values use a compact form: strings, numbers and booleans as themselves, dates
+// as RFC 3339 strings in UTC, vectors as number arrays, geo points as
+// {"lat", "lon"}, completions as [{"input", "weight"}, ...] and objects as
+// nested tagged fields
+func marshalFields(fields map[string]FieldValue) map[string]interface{} {
+	out := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		out[k] = map[string]interface{}{
 			"type":  v.Type(),
-			"value": v,
+			"value": encodeFieldValue(v),
 		}
 	}
-	
-	return json.Marshal(aux)
+	return out
+}
+
+// encodeFieldValue returns the compact JSON form of a value
+func encodeFieldValue(value FieldValue) interface{} {
+	switch v := value.(type) {
+	case TextValue:
+		return v.Value
+	case KeywordValue:
+		return v.Value
+	case NumericValue:
+		return v.Value
+	case BooleanValue:
+		return v.Value
+	case DateValue:
+		return v.Value.UTC().Format(time.RFC3339Nano)
+	case VectorValue:
+		return v.Value
+	case GeoPointValue:
+		return map[string]float64{"lat": v.Lat, "lon": v.Lon}
+	case CompletionValue:
+		return completionSource(v)
+	case ObjectValue:
+		return marshalFields(v.Fields)
+	}
+	return value
 }
 
 // UnmarshalJSON implements custom JSON unmarshaling for Document
@@ -160,54 +197,115 @@ func (d *Document) UnmarshalJSON(data []byte) error {
 	}
 	
 	// Convert back to FieldValue
-	d.Fields = make(map[string]FieldValue)
-	for k, v := range aux.Fields {
+	fields, err := unmarshalFields(aux.Fields)
+	if err != nil {
+		return err
+	}
+	d.Fields = fields
+	return nil
+}
+
+// unmarshalFields decodes fields written by marshalFields
+// Documents written before the compact form wrap each value as {"Value": ...}
+func unmarshalFields(raw map[string]map[string]interface{}) (map[string]FieldValue, error) {
+	fields := make(map[string]FieldValue, len(raw))
+	for k, v := range raw {
 		fieldType, ok := v["type"].(string)
 		if !ok {
-			return fmt.Errorf("invalid field type for %s", k)
+			return nil, fmt.Errorf("invalid field type for %s", k)
 		}
 		
-		var fieldValue FieldValue
-		switch FieldType(fieldType) {
-		case FieldTypeText:
-			if val, ok := v["value"].(map[string]interface{}); ok {
-				if str, ok := val["Value"].(string); ok {
-					fieldValue = TextValue{Value: str}
-				}
-			} else if str, ok := v["value"].(string); ok {
-				fieldValue = TextValue{Value: str}
+		fieldValue, err := decodeFieldValue(FieldType(fieldType), v["value"])
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", k, err)
+		}
+		fields[k] = fieldValue
+	}
+	
+	return fields, nil
+}
+
+// decodeFieldValue converts a value written by encodeFieldValue, or by the
+// older struct encoding, back to a FieldValue
+func decodeFieldValue(fieldType FieldType, raw interface{}) (FieldValue, error) {
+	if m, ok := raw.(map[string]interface{}); ok && fieldType != FieldTypeObject && fieldType != FieldTypeGeoPoint {
+		if legacy, ok := m["Value"]; ok {
+			raw = legacy
+		}
+	}
+
+	switch fieldType {
+	case FieldTypeText:
+		if str, ok := raw.(string); ok {
+			return TextValue{Value: str}, nil
+		}
+	case FieldTypeKeyword:
+		if str, ok := raw.(string); ok {
+			return KeywordValue{Value: str}, nil
+		}
+	case FieldTypeNumeric:
+		if num, ok := raw.(float64); ok {
+			return NumericValue{Value: num}, nil
+		}
+	case FieldTypeBoolean:
+		if b, ok := raw.(bool); ok {
+			return BooleanValue{Value: b}, nil
+		}
+	case FieldTypeDate:
+		if str, ok := raw.(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, str)
+			if err != nil {
+				return nil, fmt.Errorf("invalid date %q: %w", str, err)
 			}
-		case FieldTypeKeyword:
-			if val, ok := v["value"].(map[string]interface{}); ok {
-				if str, ok := val["Value"].(string); ok {
-					fieldValue = KeywordValue{Value: str}
+			return DateValue{Value: t.UTC()}, nil
+		}
+	case FieldTypeVector:
+		if elems, ok := raw.([]interface{}); ok {
+			vec := make([]float32, len(elems))
+			for i, e := range elems {
+				num, ok := e.(float64)
+				if !ok {
+					return nil, fmt.Errorf("vector element %d is %T, not a number", i, e)
 				}
-			} else if str, ok := v["value"].(string); ok {
-				fieldValue = KeywordValue{Value: str}
+				vec[i] = float32(num)
 			}
-		case FieldTypeNumeric:
-			if val, ok := v["value"].(map[string]interface{}); ok {
-				if num, ok := val["Value"].(float64); ok {
-					fieldValue = NumericValue{Value: num}
-				}
-			} else if num, ok := v["value"].(float64); ok {
-				fieldValue = NumericValue{Value: num}
+			return VectorValue{Value: vec, Dim: len(vec)}, nil
+		}
+	case FieldTypeGeoPoint:
+		if m, ok := raw.(map[string]interface{}); ok {
+			lat, latOK := m["lat"].(float64)
+			lon, lonOK := m["lon"].(float64)
+			if !latOK || !lonOK {
+				lat, latOK = m["Lat"].(float64)
+				lon, lonOK = m["Lon"].(float64)
 			}
-		case FieldTypeBoolean:
-			if val, ok := v["value"].(map[string]interface{}); ok {
-				if b, ok := val["Value"].(bool); ok {
-					fieldValue = BooleanValue{Value: b}
-				}
-			} else if b, ok := v["value"].(bool); ok {
-				fieldValue = BooleanValue{Value: b}
+			if latOK && lonOK {
+				return GeoPointValue{Lat: lat, Lon: lon}, nil
 			}
 		}
-		
-		if fieldValue != nil {
-			d.Fields[k] = fieldValue
+	case FieldTypeCompletion:
+		if _, ok := raw.([]interface{}); ok {
+			return ParseCompletion(raw)
 		}
+	case FieldTypeObject:
+		if m, ok := raw.(map[string]interface{}); ok {
+			sub := make(map[string]map[string]interface{}, len(m))
+			for name, entry := range m {
+				tagged, ok := entry.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("subfield %s is not a tagged value", name)
+				}
+				sub[name] = tagged
+			}
+			subFields, err := unmarshalFields(sub)
+			if err != nil {
+				return nil, err
+			}
+			return ObjectValue{Fields: subFields}, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown field type %q", fieldType)
 	}
-	
-	return nil
+	return nil, fmt.Errorf("cannot decode %T as a %s value", raw, fieldType)
 }
 
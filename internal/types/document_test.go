@@ -0,0 +1,140 @@
+package types
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fieldValueCases holds a value of every field type, and the value it decodes to
+var fieldValueCases = []struct {
+	name  string
+	value FieldValue
+	want  FieldValue // nil if the value decodes to itself
+}{
+	{name: "text", value: TextValue{Value: "the quick brown fox"}},
+	{name: "keyword", value: KeywordValue{Value: "SKU-123"}},
+	{name: "numeric", value: NumericValue{Value: -12.375}},
+	{name: "boolean", value: BooleanValue{Value: true}},
+	{name: "date", value: DateValue{Value: time.Date(2024, 2, 29, 13, 4, 5, 123456789, time.UTC)}},
+	{
+		name:  "date outside UTC",
+		value: DateValue{Value: time.Date(2024, 2, 29, 23, 4, 5, 0, time.FixedZone("CET", 3600))},
+		want:  DateValue{Value: time.Date(2024, 2, 29, 22, 4, 5, 0, time.UTC)},
+	},
+	{name: "date before 1970", value: DateValue{Value: time.Date(1969, 7, 20, 20, 17, 40, 500, time.UTC)}},
+	{name: "vector", value: VectorValue{Value: []float32{0.5, -1.25, 3}, Dim: 3}},
+	{name: "geo_point", value: GeoPointValue{Lat: 52.52, Lon: 13.405}},
+	{name: "completion", value: CompletionValue{Inputs: []CompletionInput{{Input: "nano", Weight: 3}, {Input: "nano elastic", Weight: 1}}}},
+	{
+		name: "object",
+		value: ObjectValue{Fields: map[string]FieldValue{
+			"city":  KeywordValue{Value: "Berlin"},
+			"since": DateValue{Value: time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)},
+			"geo":   ObjectValue{Fields: map[string]FieldValue{"point": GeoPointValue{Lat: 1, Lon: 2}}},
+		}},
+	},
+}
+
+func testDocument(value FieldValue) *Document {
+	return &Document{
+		ID:      "doc-1",
+		Fields:  map[string]FieldValue{"field": value},
+		Version: 7,
+		Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Updated: time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC),
+	}
+}
+
+func TestFieldValueRoundTrip(t *testing.T) {
+	encodings := []struct {
+		name   string
+		encode func(*Document) ([]byte, error)
+	}{
+		{name: "binary", encode: (*Document).MarshalBinary},
+		{name: "json", encode: func(d *Document) ([]byte, error) { return json.Marshal(d) }},
+	}
+
+	for _, tc := range fieldValueCases {
+		for _, enc := range encodings {
+			t.Run(tc.name+"/"+enc.name, func(t *testing.T) {
+				want := tc.want
+				if want == nil {
+					want = tc.value
+				}
+
+				data, err := enc.encode(testDocument(tc.value))
+				if err != nil {
+					t.Fatalf("encode: %v", err)
+				}
+				doc, err := DecodeDocument(data)
+				if err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+				if got := doc.Fields["field"]; !reflect.DeepEqual(got, want) {
+					t.Errorf("field = %#v, want %#v", got, want)
+				}
+				if doc.ID != "doc-1" || doc.Version != 7 {
+					t.Errorf("id, version = %q, %d, want doc-1, 7", doc.ID, doc.Version)
+				}
+			})
+		}
+	}
+}
+
+func TestDateEncoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		date   time.Time
+		binary []byte // The value after its type tag
+		json   string
+	}{
+		{
+			name:   "epoch",
+			date:   time.Unix(0, 0).UTC(),
+			binary: []byte{0, 0},
+			json:   `"1970-01-01T00:00:00Z"`,
+		},
+		{
+			name:   "seconds",
+			date:   time.Date(2024, 2, 29, 13, 4, 5, 0, time.UTC),
+			binary: append(binary.AppendVarint(nil, 1709211845), 0),
+			json:   `"2024-02-29T13:04:05Z"`,
+		},
+		{
+			name:   "nanoseconds",
+			date:   time.Date(2024, 2, 29, 13, 4, 5, 1500, time.UTC),
+			binary: binary.AppendUvarint(binary.AppendVarint(nil, 1709211845), 1500),
+			json:   `"2024-02-29T13:04:05.0000015Z"`,
+		},
+		{
+			name:   "before 1970",
+			date:   time.Date(1969, 12, 31, 23, 59, 59, 0, time.UTC),
+			binary: []byte{1, 0}, // Zig-zag -1
+			json:   `"1969-12-31T23:59:59Z"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := appendValue(nil, DateValue{Value: tc.date})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := append([]byte{tagDate}, tc.binary...); !bytes.Equal(got, want) {
+				t.Errorf("binary = %x, want %x", got, want)
+			}
+
+			data, err := json.Marshal(encodeFieldValue(DateValue{Value: tc.date}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tc.json {
+				t.Errorf("json = %s, want %s", data, tc.json)
+			}
+		})
+	}
+}
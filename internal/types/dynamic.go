@@ -0,0 +1,225 @@
+package types
+
+import (
+	"fmt"
+	"path"
+	"sort"
+)
+
+// dateDetectionFormats are the formats a string must match to be mapped as a date
+// Stricter than DefaultDateFormats, so numeric strings stay text
+var dateDetectionFormats = []string{
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// DynamicTemplate maps undeclared fields by name and detected type
+// Match is tested against the field's own name, not its full dot path
+//
+//	schema.AddDynamicTemplate("ids", "*_id", FieldTypeKeyword)
+type DynamicTemplate struct {
+	Name      string    `json:"name"`
+	Match     string    `json:"match,omitempty"`      // Field name pattern ('*' matches any run of characters); empty matches every name
+	MatchType FieldType `json:"match_type,omitempty"` // Detected type the value must have; empty matches every type
+	Mapping   FieldDef  `json:"mapping"`
+}
+
+// AddDynamicTemplate maps undeclared fields whose name matches the pattern to the given type
+func (s *Schema) AddDynamicTemplate(name string, match string, fieldType FieldType, options ...FieldOption) {
+	s.DynamicTemplates = append(s.DynamicTemplates, DynamicTemplate{
+		Name:    name,
+		Match:   match,
+		Mapping: NewFieldDef(fieldType, options...),
+	})
+}
+
+// matches reports whether the template applies to a field
+// Objects only match templates that ask for them, e.g. to map {"lat", "lon"} as a geo point
+func (t DynamicTemplate) matches(name string, detected FieldType) bool {
+	if t.MatchType != detected && (t.MatchType != "" || detected == FieldTypeObject) {
+		return false
+	}
+	if t.Match == "" {
+		return true
+	}
+	ok, err := path.Match(t.Match, name)
+	return err == nil && ok
+}
+
+// DetectFieldType infers the field type of a decoded JSON value
+// Strings are dates when they look like one and text otherwise, numbers are
+// numeric, booleans are boolean, number arrays are vectors and JSON objects
+// are objects
+func DetectFieldType(raw interface{}) (FieldType, error) {
+	switch v := raw.(type) {
+	case string:
+		if _, err := ParseDate(v, dateDetectionFormats); err == nil {
+			return FieldTypeDate, nil
+		}
+		return FieldTypeText, nil
+	case float64:
+		return FieldTypeNumeric, nil
+	case bool:
+		return FieldTypeBoolean, nil
+	case map[string]interface{}:
+		return FieldTypeObject, nil
+	case []interface{}:
+		if len(v) == 0 {
+			return "", fmt.Errorf("cannot detect the type of an empty array")
+		}
+		for i, e := range v {
+			if _, ok := e.(float64); !ok {
+				return "", fmt.Errorf("array element %d is %T, not a number", i, e)
+			}
+		}
+		return FieldTypeVector, nil
+	}
+	return "", fmt.Errorf("unsupported value type %T", raw)
+}
+
+// InferFields returns add_field changes for the fields of a raw document that
+// the schema doesn't declare, or nothing if the schema isn't dynamic (or is strict)
+// The first dynamic template matching a field's name (the last element of
+// its dot path) decides its mapping; otherwise the detected type is used.
+// Objects are mapped along with their subfields. Null values are skipped,
+// and dropped fields stay unmapped so their old values don't come back
+func (s *Schema) InferFields(source map[string]interface{}) ([]SchemaChange, error) {
+	if !s.Dynamic || s.Strict {
+		return nil, nil
+	}
+	return s.inferSource(nil, "", source)
+}
+
+// inferSource adds changes for the undeclared fields of a JSON object at prefix
+func (s *Schema) inferSource(changes []SchemaChange, prefix string, source map[string]interface{}) ([]SchemaChange, error) {
+	// Sorted, so fields are mapped in the same order every time
+	names := make([]string, 0, len(source))
+	for name, raw := range source {
+		if raw != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := prefix + name
+		raw := source[name]
+		def, declared := s.Fields[path]
+		if declared && def.Type != FieldTypeObject {
+			continue
+		}
+
+		detected, err := DetectFieldType(raw)
+		if err != nil {
+			if declared {
+				continue // Reported when the value is converted
+			}
+			return nil, &SchemaValidationError{Field: path, Message: err.Error()}
+		}
+		if !declared {
+			dim := 0
+			if detected == FieldTypeVector {
+				dim = len(raw.([]interface{}))
+			}
+			n := len(changes)
+			changes = s.appendInferred(changes, path, name, detected, dim, true)
+			if len(changes) == n || changes[n].Def.Type != FieldTypeObject {
+				continue // Dropped, or mapped as a single value such as a geo point
+			}
+		}
+		if obj, ok := raw.(map[string]interface{}); ok {
+			if changes, err = s.inferSource(changes, path+".", obj); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return changes, nil
+}
+
+// InferDocumentFields is InferFields for a typed document, mapping each
+// undeclared field by the type of its value
+func (s *Schema) InferDocumentFields(doc *Document) []SchemaChange {
+	if !s.Dynamic || s.Strict {
+		return nil
+	}
+	return s.inferFields(nil, "", doc.Fields)
+}
+
+// inferFields adds changes for the undeclared fields of a document or object at prefix
+func (s *Schema) inferFields(changes []SchemaChange, prefix string, fields map[string]FieldValue) []SchemaChange {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := prefix + name
+		value := fields[name]
+		if _, declared := s.Fields[path]; !declared {
+			dim := 0
+			if vec, ok := value.(VectorValue); ok {
+				dim = vec.Dim
+			}
+			changes = s.appendInferred(changes, path, name, value.Type(), dim, false)
+		}
+		if obj, ok := value.(ObjectValue); ok {
+			changes = s.inferFields(changes, path+".", obj.Fields)
+		}
+	}
+	return changes
+}
+
+// appendInferred adds the change mapping one undeclared field, unless it or
+// an object holding it was dropped
+// A template may change the field's type only if the value is still raw JSON
+func (s *Schema) appendInferred(changes []SchemaChange, path string, name string, detected FieldType, dim int, raw bool) []SchemaChange {
+	if s.IsDropped(path) {
+		return changes
+	}
+
+	def := s.dynamicMapping(name, detected)
+	if def.Type != detected && !raw {
+		def = NewFieldDef(detected)
+	}
+	if def.Type == FieldTypeVector && def.VectorDim == 0 {
+		def.VectorDim = dim
+	}
+	return append(changes, SchemaChange{Op: ChangeAddField, Field: path, Def: &def})
+}
+
+// dynamicMapping returns the field definition for an undeclared field
+func (s *Schema) dynamicMapping(name string, detected FieldType) FieldDef {
+	for _, t := range s.DynamicTemplates {
+		if t.matches(name, detected) {
+			return t.Mapping
+		}
+	}
+	return NewFieldDef(detected)
+}
+
+// MapDynamicFields is Migrate for fields inferred from indexed documents
+// The migration is marked dynamic, so the index still opens with the schema
+// as declared before these fields were mapped (see DynamicallyExtends)
+func (s *Schema) MapDynamicFields(changes ...SchemaChange) (*Schema, error) {
+	next, err := s.Migrate(changes...)
+	if err != nil {
+		return nil, err
+	}
+	next.Migrations[len(next.Migrations)-1].Dynamic = true
+	return next, nil
+}
+
+// DynamicallyExtends reports whether the schema is base plus dynamically mapped fields only
+func (s *Schema) DynamicallyExtends(base *Schema) bool {
+	if s.Version <= base.Version {
+		return false
+	}
+	for _, m := range s.Migrations {
+		if m.Version > base.Version && !m.Dynamic {
+			return false
+		}
+	}
+	return s.CheckCompatible(base) == nil
+}
@@ -0,0 +1,72 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GeoPointValue represents a geo-point field value
+type GeoPointValue struct {
+	Lat float64
+	Lon float64
+}
+
+func (v GeoPointValue) Type() FieldType { return FieldTypeGeoPoint }
+func (v GeoPointValue) String() string {
+	return strconv.FormatFloat(v.Lat, 'f', -1, 64) + "," + strconv.FormatFloat(v.Lon, 'f', -1, 64)
+}
+
+// ParseGeoPoint converts a decoded JSON value to a geo point, accepting the
+// Elasticsearch forms {"lat": 52.37, "lon": 4.89}, "52.37,4.89" and [4.89, 52.37]
+// (GeoJSON order: longitude first)
+func ParseGeoPoint(raw interface{}) (GeoPointValue, error) {
+	var p GeoPointValue
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		lat, latOK := v["lat"].(float64)
+		lon, lonOK := v["lon"].(float64)
+		if !latOK || !lonOK || len(v) != 2 {
+			return p, fmt.Errorf("geo point object must have numeric lat and lon only")
+		}
+		p = GeoPointValue{Lat: lat, Lon: lon}
+	case string:
+		parts := strings.Split(v, ",")
+		if len(parts) != 2 {
+			return p, fmt.Errorf("geo point string must be \"lat,lon\", got %q", v)
+		}
+		lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid latitude in %q", v)
+		}
+		lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid longitude in %q", v)
+		}
+		p = GeoPointValue{Lat: lat, Lon: lon}
+	case []interface{}:
+		if len(v) != 2 {
+			return p, fmt.Errorf("geo point array must be [lon, lat]")
+		}
+		lon, lonOK := v[0].(float64)
+		lat, latOK := v[1].(float64)
+		if !latOK || !lonOK {
+			return p, fmt.Errorf("geo point array must hold two numbers")
+		}
+		p = GeoPointValue{Lat: lat, Lon: lon}
+	default:
+		return p, fmt.Errorf("cannot convert %T to a geo point", raw)
+	}
+	return p, p.Validate()
+}
+
+// Validate checks that the point's coordinates are in range
+func (v GeoPointValue) Validate() error {
+	if v.Lat < -90 || v.Lat > 90 {
+		return fmt.Errorf("latitude %v is out of range [-90, 90]", v.Lat)
+	}
+	if v.Lon < -180 || v.Lon > 180 {
+		return fmt.Errorf("longitude %v is out of range [-180, 180]", v.Lon)
+	}
+	return nil
+}
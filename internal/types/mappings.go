@@ -0,0 +1,385 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// mappingTypes maps Elasticsearch field types to schema field types
+var mappingTypes = map[string]FieldType{
+	"text":         FieldTypeText,
+	"keyword":      FieldTypeKeyword,
+	"numeric":      FieldTypeNumeric,
+	"long":         FieldTypeNumeric,
+	"integer":      FieldTypeNumeric,
+	"short":        FieldTypeNumeric,
+	"byte":         FieldTypeNumeric,
+	"double":       FieldTypeNumeric,
+	"float":        FieldTypeNumeric,
+	"half_float":   FieldTypeNumeric,
+	"scaled_float": FieldTypeNumeric,
+	"boolean":      FieldTypeBoolean,
+	"date":         FieldTypeDate,
+	"vector":       FieldTypeVector,
+	"dense_vector": FieldTypeVector,
+	"object":       FieldTypeObject,
+	"geo_point":    FieldTypeGeoPoint,
+	"completion":   FieldTypeCompletion,
+}
+
+// fieldMapping is one field of an Elasticsearch-style mapping
+type fieldMapping struct {
+	Type           string      `json:"type"`
+	Analyzer       string      `json:"analyzer,omitempty"`
+	SearchAnalyzer string      `json:"search_analyzer,omitempty"`
+	Format         string      `json:"format,omitempty"` // Date formats separated by "||"
+	Dims           int         `json:"dims,omitempty"`
+	Index          *bool       `json:"index,omitempty"`
+	Store          *bool       `json:"store,omitempty"`
+	Boost          *float64    `json:"boost,omitempty"`
+	Similarity     string      `json:"similarity,omitempty"`
+	Required       bool        `json:"required,omitempty"`
+	Default        interface{} `json:"default,omitempty"` // Value for documents without the field
+	CopyTo         stringList  `json:"copy_to,omitempty"`
+
+	IndexOptions *vectorIndexOptions      `json:"index_options,omitempty"` // Vector storage, e.g. {"type": "int8_flat"}
+	Properties   map[string]*fieldMapping `json:"properties,omitempty"`    // Subfields of an object
+}
+
+// stringList is a JSON string or array of strings, like copy_to values
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = stringList{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("expected a string or an array of strings, got %s", data)
+	}
+	*l = list
+	return nil
+}
+
+// vectorIndexOptions are the index_options of a dense_vector mapping
+type vectorIndexOptions struct {
+	Type string `json:"type"`
+}
+
+// vectorIndexTypes maps index_options types to vector quantizations
+// Vector search is exact, so the HNSW variants are accepted as their flat equivalents
+var vectorIndexTypes = map[string]VectorQuantization{
+	"flat":      QuantizationNone,
+	"hnsw":      QuantizationNone,
+	"int8_flat": QuantizationInt8,
+	"int8_hnsw": QuantizationInt8,
+	"int8":      QuantizationInt8,
+}
+
+// matchMappingTypes maps Elasticsearch match_mapping_type values to detected field types
+var matchMappingTypes = map[string]FieldType{
+	"string":  FieldTypeText,
+	"long":    FieldTypeNumeric,
+	"double":  FieldTypeNumeric,
+	"boolean": FieldTypeBoolean,
+	"date":    FieldTypeDate,
+	"vector":  FieldTypeVector,
+	"object":  FieldTypeObject,
+}
+
+// templateMapping is one entry of Elasticsearch-style dynamic_templates
+type templateMapping struct {
+	Match            string       `json:"match,omitempty"`
+	MatchMappingType string       `json:"match_mapping_type,omitempty"`
+	Mapping          fieldMapping `json:"mapping"`
+}
+
+// SchemaFromMappings builds a schema from an Elasticsearch-style index body:
+//
+//	{"mappings": {"properties": {"title": {"type": "text", "analyzer": "english"}, "year": {"type": "integer"}}}}
+//
+// Date "format" values are Go time layouts (or epoch_millis/epoch_second) separated by "||"
+// Undeclared fields are mapped dynamically unless "dynamic" is false, and rejected
+// if it is "strict". Documents missing a field mapped with "required": true are
+// rejected, and missing fields with a "default" get its value.
+// Dynamic templates are a list of single-entry objects:
+//
+//	{"mappings": {"dynamic_templates": [{"ids": {"match": "*_id", "mapping": {"type": "keyword"}}}]}}
+//
+// "_ttl" names the date field holding each document's expiry time:
+//
+//	{"mappings": {"_ttl": {"field": "expires_at"}, "properties": {"expires_at": {"type": "date"}}}}
+//
+// Text fields are scored with BM25 unless their "similarity" is "classic",
+// "boolean" or one named in the index settings:
+//
+//	{"settings": {"index": {"similarity": {"short_bm25": {"type": "BM25", "k1": 1.2, "b": 0.3}}}},
+//	 "mappings": {"properties": {"title": {"type": "text", "similarity": "short_bm25"}}}}
+func SchemaFromMappings(name string, data []byte) (*Schema, error) {
+	type similaritySettings struct {
+		Similarity map[string]SimilarityDef `json:"similarity"`
+	}
+	var body struct {
+		Settings struct {
+			Index similaritySettings `json:"index"`
+			similaritySettings
+		} `json:"settings"`
+		Mappings struct {
+			Dynamic          json.RawMessage              `json:"dynamic"`
+			DynamicTemplates []map[string]templateMapping `json:"dynamic_templates"`
+			Properties       map[string]*fieldMapping     `json:"properties"`
+			TTL              *struct {
+				Field string `json:"field"`
+			} `json:"_ttl"`
+		} `json:"mappings"`
+	}
+	if len(strings.TrimSpace(string(data))) > 0 {
+		if err := json.Unmarshal(data, &body); err != nil {
+			return nil, fmt.Errorf("invalid mappings: %w", err)
+		}
+	}
+
+	schema := NewSchema(name)
+	switch dynamic := strings.Trim(string(body.Mappings.Dynamic), `"`); dynamic {
+	case "", "null", "true":
+		schema.Dynamic = true
+	case "false":
+	case "strict":
+		schema.Strict = true
+	default:
+		return nil, fmt.Errorf("invalid dynamic %s: expected true, false or \"strict\"", dynamic)
+	}
+	if err := addProperties(schema, "", body.Mappings.Properties); err != nil {
+		return nil, err
+	}
+	if err := schema.ValidateDefaults(); err != nil {
+		return nil, err
+	}
+	if err := schema.ValidateCopyTo(); err != nil {
+		return nil, err
+	}
+	if err := schema.ValidateStored(); err != nil {
+		return nil, err
+	}
+	// Accept both "settings.index.similarity" and "settings.similarity"
+	for simName, sim := range body.Settings.Index.Similarity {
+		if schema.Similarities == nil {
+			schema.Similarities = make(map[string]SimilarityDef)
+		}
+		schema.Similarities[simName] = sim
+	}
+	for simName, sim := range body.Settings.Similarity {
+		if schema.Similarities == nil {
+			schema.Similarities = make(map[string]SimilarityDef)
+		}
+		schema.Similarities[simName] = sim
+	}
+	if body.Mappings.TTL != nil {
+		schema.TTLField = body.Mappings.TTL.Field
+		if err := schema.ValidateTTL(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, entry := range body.Mappings.DynamicTemplates {
+		if len(entry) != 1 {
+			return nil, fmt.Errorf("dynamic template must have exactly one name, got %d", len(entry))
+		}
+		for templateName, t := range entry {
+			def, err := t.Mapping.fieldDef()
+			if err != nil {
+				return nil, fmt.Errorf("dynamic template %s: %w", templateName, err)
+			}
+			var matchType FieldType
+			if t.MatchMappingType != "" && t.MatchMappingType != "*" {
+				if matchType = matchMappingTypes[t.MatchMappingType]; matchType == "" {
+					return nil, fmt.Errorf("dynamic template %s: unknown match_mapping_type %q", templateName, t.MatchMappingType)
+				}
+			}
+			schema.DynamicTemplates = append(schema.DynamicTemplates, DynamicTemplate{
+				Name:      templateName,
+				Match:     t.Match,
+				MatchType: matchType,
+				Mapping:   def,
+			})
+		}
+	}
+	if err := schema.ValidateSimilarities(); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// addProperties declares mapped fields, with subfields of objects under dot paths
+func addProperties(schema *Schema, prefix string, properties map[string]*fieldMapping) error {
+	for fieldName, m := range properties {
+		path := prefix + fieldName
+		def, err := m.fieldDef()
+		if err != nil {
+			return fmt.Errorf("field %s: %w", path, err)
+		}
+		schema.Fields[path] = def
+
+		if len(m.Properties) > 0 {
+			if def.Type != FieldTypeObject {
+				return fmt.Errorf("field %s: only object fields have properties", path)
+			}
+			if err := addProperties(schema, path+".", m.Properties); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fieldDef converts a field mapping to a field definition
+// A mapping with properties and no type is an object
+func (m fieldMapping) fieldDef() (FieldDef, error) {
+	if m.Type == "" && len(m.Properties) > 0 {
+		return NewFieldDef(FieldTypeObject), nil
+	}
+	fieldType, ok := mappingTypes[m.Type]
+	if !ok {
+		return FieldDef{}, fmt.Errorf("unknown type %q", m.Type)
+	}
+
+	var options []FieldOption
+	if m.Analyzer != "" {
+		options = append(options, WithAnalyzer(m.Analyzer))
+	}
+	if m.SearchAnalyzer != "" {
+		options = append(options, WithSearchAnalyzer(m.SearchAnalyzer))
+	}
+	if m.Format != "" {
+		options = append(options, WithDateFormats(strings.Split(m.Format, "||")...))
+	}
+	if m.Dims > 0 {
+		options = append(options, WithVectorDim(m.Dims))
+	}
+	if m.Index != nil {
+		options = append(options, WithIndexed(*m.Index))
+	}
+	if m.Store != nil {
+		options = append(options, WithStored(*m.Store))
+	}
+	if m.Boost != nil {
+		options = append(options, WithBoost(*m.Boost))
+	}
+	if m.Similarity != "" {
+		options = append(options, WithSimilarity(m.Similarity))
+	}
+	if m.IndexOptions != nil {
+		if fieldType != FieldTypeVector {
+			return FieldDef{}, fmt.Errorf("index_options only apply to dense_vector fields")
+		}
+		q, ok := vectorIndexTypes[m.IndexOptions.Type]
+		if !ok {
+			return FieldDef{}, fmt.Errorf("unknown index_options type %q", m.IndexOptions.Type)
+		}
+		options = append(options, WithQuantization(q))
+	}
+	if m.Required {
+		options = append(options, WithRequired())
+	}
+	if m.Default != nil {
+		options = append(options, WithDefault(m.Default))
+	}
+	if len(m.CopyTo) > 0 {
+		options = append(options, WithCopyTo(m.CopyTo...))
+	}
+	return NewFieldDef(fieldType, options...), nil
+}
+
+// Mappings returns the schema as an Elasticsearch-style mappings object
+func (s *Schema) Mappings() map[string]interface{} {
+	properties := make(map[string]*fieldMapping, len(s.Fields))
+	for name, def := range s.Fields {
+		// Subfields nest inside their object's properties
+		props := properties
+		parts := strings.Split(name, ".")
+		for _, part := range parts[:len(parts)-1] {
+			parent := props[part]
+			if parent == nil {
+				parent = &fieldMapping{Type: string(FieldTypeObject)}
+				props[part] = parent
+			}
+			if parent.Properties == nil {
+				parent.Properties = make(map[string]*fieldMapping)
+			}
+			props = parent.Properties
+		}
+
+		leaf := parts[len(parts)-1]
+		m := mappingFromDef(def)
+		if existing := props[leaf]; existing != nil {
+			m.Properties = existing.Properties // Subfields seen first
+		}
+		props[leaf] = &m
+	}
+	mappings := map[string]interface{}{
+		"dynamic":    s.Dynamic,
+		"properties": properties,
+	}
+	if s.Strict {
+		mappings["dynamic"] = "strict"
+	}
+
+	if len(s.DynamicTemplates) > 0 {
+		templates := make([]map[string]templateMapping, 0, len(s.DynamicTemplates))
+		for _, t := range s.DynamicTemplates {
+			tm := templateMapping{
+				Match:            t.Match,
+				MatchMappingType: matchMappingType(t.MatchType),
+				Mapping:          mappingFromDef(t.Mapping),
+			}
+			templates = append(templates, map[string]templateMapping{t.Name: tm})
+		}
+		mappings["dynamic_templates"] = templates
+	}
+	if s.TTLField != "" {
+		mappings["_ttl"] = map[string]interface{}{"field": s.TTLField}
+	}
+	return mappings
+}
+
+// matchMappingType returns the match_mapping_type for a detected field type
+func matchMappingType(t FieldType) string {
+	switch t {
+	case FieldTypeText:
+		return "string"
+	case FieldTypeNumeric:
+		return "double"
+	}
+	return string(t)
+}
+
+// mappingFromDef converts a field definition to a field mapping
+func mappingFromDef(def FieldDef) fieldMapping {
+	m := fieldMapping{
+		Type:           string(def.Type),
+		Analyzer:       def.Analyzer,
+		SearchAnalyzer: def.SearchAnalyzer,
+		Format:         strings.Join(def.DateFormats, "||"),
+		Dims:           def.VectorDim,
+		Similarity:     def.Similarity,
+		Required:       def.Required,
+		Default:        def.Default,
+		CopyTo:         def.CopyTo,
+	}
+	if !def.Indexed {
+		m.Index = &def.Indexed
+	}
+	if !def.Stored {
+		m.Store = &def.Stored
+	}
+	if def.Type == FieldTypeVector {
+		m.Type = "dense_vector"
+		if def.Quantization == QuantizationInt8 {
+			m.IndexOptions = &vectorIndexOptions{Type: "int8_flat"}
+		}
+	}
+	return m
+}
@@ -0,0 +1,223 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SchemaChangeOp is the kind of a schema change
+type SchemaChangeOp string
+
+const (
+	ChangeAddField      SchemaChangeOp = "add_field"       // Declare a new field; existing documents simply lack it
+	ChangeDropField     SchemaChangeOp = "drop_field"      // Remove a field; stored documents are upgraded lazily on read
+	ChangeFieldAnalyzer SchemaChangeOp = "change_analyzer" // Re-analyze a text field; the field's terms must be rebuilt
+)
+
+// SchemaChange is one step of a migration
+type SchemaChange struct {
+	Op       SchemaChangeOp `json:"op"`
+	Field    string         `json:"field"`
+	Def      *FieldDef      `json:"def,omitempty"`      // For add_field
+	Analyzer string         `json:"analyzer,omitempty"` // For change_analyzer
+}
+
+// AddFieldChange declares a new field
+func AddFieldChange(name string, fieldType FieldType, options ...FieldOption) SchemaChange {
+	def := NewFieldDef(fieldType, options...)
+	return SchemaChange{Op: ChangeAddField, Field: name, Def: &def}
+}
+
+// DropFieldChange removes a field
+func DropFieldChange(name string) SchemaChange {
+	return SchemaChange{Op: ChangeDropField, Field: name}
+}
+
+// ChangeAnalyzerChange switches a text field to another analyzer
+func ChangeAnalyzerChange(field string, analyzer string) SchemaChange {
+	return SchemaChange{Op: ChangeFieldAnalyzer, Field: field, Analyzer: analyzer}
+}
+
+// Migration is the set of changes that takes a schema to Version
+type Migration struct {
+	Version int            `json:"version"`
+	Changes []SchemaChange `json:"changes"`
+	Dynamic bool           `json:"dynamic,omitempty"` // Fields mapped from indexed documents, not declared
+}
+
+// RequiresReanalysis reports whether indexed terms must be rebuilt from the
+// stored documents for the migration to take effect
+func (m Migration) RequiresReanalysis() bool {
+	for _, c := range m.Changes {
+		if c.Op == ChangeFieldAnalyzer || c.Op == ChangeDropField {
+			return true
+		}
+	}
+	return false
+}
+
+// Migrate returns a copy of the schema with the changes applied, one version up
+// The migration is recorded so the new schema can be checked against the
+// stored one when the index is reopened
+func (s *Schema) Migrate(changes ...SchemaChange) (*Schema, error) {
+	next := s.clone()
+	for _, c := range changes {
+		if err := next.apply(c); err != nil {
+			return nil, err
+		}
+	}
+	next.Version = s.Version + 1
+	next.Migrations = append(next.Migrations, Migration{Version: next.Version, Changes: changes})
+	return next, nil
+}
+
+// apply applies one change in place
+func (s *Schema) apply(c SchemaChange) error {
+	def, exists := s.Fields[c.Field]
+	switch c.Op {
+	case ChangeAddField:
+		if exists {
+			return fmt.Errorf("cannot add field %s: it already exists", c.Field)
+		}
+		if c.Def == nil {
+			return fmt.Errorf("cannot add field %s: no field definition", c.Field)
+		}
+		for parent := parentPath(c.Field); parent != ""; parent = parentPath(parent) {
+			if def, ok := s.Fields[parent]; ok && def.Type != FieldTypeObject {
+				return fmt.Errorf("cannot add field %s: %s is a %s field, not an object", c.Field, parent, def.Type)
+			}
+		}
+		s.Fields[c.Field] = *c.Def
+	case ChangeDropField:
+		if !exists {
+			return fmt.Errorf("cannot drop field %s: it doesn't exist", c.Field)
+		}
+		delete(s.Fields, c.Field)
+		for name := range s.Fields {
+			if strings.HasPrefix(name, c.Field+".") {
+				delete(s.Fields, name) // Subfields go with their object
+			}
+		}
+	case ChangeFieldAnalyzer:
+		if !exists {
+			return fmt.Errorf("cannot change analyzer of field %s: it doesn't exist", c.Field)
+		}
+		if def.Type != FieldTypeText {
+			return fmt.Errorf("cannot change analyzer of field %s: it's a %s field", c.Field, def.Type)
+		}
+		def.Analyzer = c.Analyzer
+		def.Analyzed = true
+		s.Fields[c.Field] = def
+	default:
+		return fmt.Errorf("unknown schema change %q", c.Op)
+	}
+	return nil
+}
+
+// DroppedFields returns the fields removed by migrations and not added back since
+// Stored documents may still hold values for them
+func (s *Schema) DroppedFields() map[string]bool {
+	dropped := make(map[string]bool)
+	for _, m := range s.Migrations {
+		for _, c := range m.Changes {
+			switch c.Op {
+			case ChangeDropField:
+				dropped[c.Field] = true
+			case ChangeAddField:
+				// Re-adding a field, or a subfield of a dropped object, brings it back
+				for name := c.Field; name != ""; name = parentPath(name) {
+					delete(dropped, name)
+				}
+			}
+		}
+	}
+	return dropped
+}
+
+// IsDropped reports whether a field, or an object holding it, was dropped
+func (s *Schema) IsDropped(name string) bool {
+	dropped := s.DroppedFields()
+	for ; name != ""; name = parentPath(name) {
+		if dropped[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// UpgradeDocument lazily brings a stored document up to the current schema
+// by removing values of dropped fields
+func (s *Schema) UpgradeDocument(doc *Document) {
+	if s == nil || len(s.Migrations) == 0 {
+		return
+	}
+	for name := range s.DroppedFields() {
+		doc.DeleteField(name)
+	}
+}
+
+// parentPath returns the object path holding a dot-path field, or "" for top-level fields
+func parentPath(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// CheckCompatible checks that the schema can open an index last saved with
+// stored: either the same version with the same fields, or a later version
+// whose recorded migrations lead from stored to it
+func (s *Schema) CheckCompatible(stored *Schema) error {
+	switch {
+	case s.Version < stored.Version:
+		return fmt.Errorf("schema version %d is older than the stored version %d", s.Version, stored.Version)
+	case s.Version == stored.Version:
+		if !sameFields(s, stored) {
+			return fmt.Errorf("schema version %d has different fields than the stored schema of the same version; declare a migration", s.Version)
+		}
+		return nil
+	}
+
+	// Replay the migrations the stored schema hasn't seen
+	current := stored
+	for _, m := range s.Migrations {
+		if m.Version <= stored.Version {
+			continue
+		}
+		if m.Version != current.Version+1 {
+			return fmt.Errorf("no migration from schema version %d to %d", current.Version, current.Version+1)
+		}
+		next, err := current.Migrate(m.Changes...)
+		if err != nil {
+			return fmt.Errorf("migration to version %d doesn't apply: %w", m.Version, err)
+		}
+		current = next
+	}
+	if current.Version != s.Version {
+		return fmt.Errorf("no migration from schema version %d to %d", current.Version, s.Version)
+	}
+	if !sameFields(s, current) {
+		return fmt.Errorf("schema version %d doesn't match the result of its migrations", s.Version)
+	}
+	return nil
+}
+
+// clone returns a deep enough copy for applying changes
+func (s *Schema) clone() *Schema {
+	c := *s
+	c.Fields = make(map[string]FieldDef, len(s.Fields))
+	for name, def := range s.Fields {
+		c.Fields[name] = def
+	}
+	c.Migrations = append([]Migration(nil), s.Migrations...)
+	return &c
+}
+
+// sameFields compares field definitions by their JSON form, so nil and empty
+// option lists are equal
+func sameFields(a, b *Schema) bool {
+	aj, errA := json.Marshal(a.Fields)
+	bj, errB := json.Marshal(b.Fields)
+	return errA == nil && errB == nil && string(aj) == string(bj)
+}
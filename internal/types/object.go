@@ -0,0 +1,99 @@
+package types
+
+import "strings"
+
+// ObjectValue represents a nested object field value
+// Its subfields are addressed with dot paths, e.g. "author.name"
+type ObjectValue struct {
+	Fields map[string]FieldValue
+}
+
+// NewObjectValue creates an empty object value
+func NewObjectValue() ObjectValue {
+	return ObjectValue{Fields: make(map[string]FieldValue)}
+}
+
+func (v ObjectValue) Type() FieldType { return FieldTypeObject }
+func (v ObjectValue) String() string  { return "object" }
+
+// DeleteField removes a field or subfield (by dot path) from the document
+func (d *Document) DeleteField(name string) {
+	if _, ok := d.Fields[name]; ok {
+		delete(d.Fields, name)
+		return
+	}
+	fields, leaf, ok := parentFields(d.Fields, name, false)
+	if ok {
+		delete(fields, leaf)
+	}
+}
+
+// Flatten returns the document with nested objects replaced by their leaf
+// fields under dot paths ("author": {"name": ...} becomes "author.name")
+// Search structures and doc values index the flattened fields
+func (d *Document) Flatten() *Document {
+	nested := false
+	for _, value := range d.Fields {
+		if _, ok := value.(ObjectValue); ok {
+			nested = true
+			break
+		}
+	}
+	if !nested {
+		return d
+	}
+
+	flat := *d
+	flat.Fields = make(map[string]FieldValue, len(d.Fields))
+	flattenInto(flat.Fields, "", d.Fields)
+	return &flat
+}
+
+// flattenInto adds the leaf fields under prefix to out
+func flattenInto(out map[string]FieldValue, prefix string, fields map[string]FieldValue) {
+	for name, value := range fields {
+		if obj, ok := value.(ObjectValue); ok {
+			flattenInto(out, prefix+name+".", obj.Fields)
+			continue
+		}
+		out[prefix+name] = value
+	}
+}
+
+// getPath reads a field by dot path
+// A field whose own name contains dots is found too
+func getPath(fields map[string]FieldValue, name string) (FieldValue, bool) {
+	if value, ok := fields[name]; ok {
+		return value, true
+	}
+	parent, leaf, ok := parentFields(fields, name, false)
+	if !ok {
+		return nil, false
+	}
+	value, ok := parent[leaf]
+	return value, ok
+}
+
+// setPath writes a field by dot path, creating or replacing objects on the way
+func setPath(fields map[string]FieldValue, name string, value FieldValue) {
+	parent, leaf, _ := parentFields(fields, name, true)
+	parent[leaf] = value
+}
+
+// parentFields walks a dot path down to the object holding its last element
+// With create, missing or non-object elements are replaced by empty objects
+func parentFields(fields map[string]FieldValue, name string, create bool) (map[string]FieldValue, string, bool) {
+	parts := strings.Split(name, ".")
+	for _, part := range parts[:len(parts)-1] {
+		obj, ok := fields[part].(ObjectValue)
+		if !ok || obj.Fields == nil {
+			if !create {
+				return nil, "", false
+			}
+			obj = NewObjectValue()
+			fields[part] = obj
+		}
+		fields = obj.Fields
+	}
+	return fields, parts[len(parts)-1], true
+}
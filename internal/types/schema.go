@@ -1,12 +1,26 @@
 package types
 
+import (
+	"fmt"
+	"sort"
+
+	"nano-elastic/internal/errdefs"
+)
+
 // Schema defines the structure of an index
 type Schema struct {
 	Name        string            `json:"name"`
 	Fields      map[string]FieldDef `json:"fields"`
 	PrimaryKey  string            `json:"primary_key"` // Field name used as document ID if not provided
-	Created     int64             `json:"created"`
+	Created     int64             `json:"created"` // Unix time the index was created, 0 if unknown
 	Version     int               `json:"version"` // Schema version for migrations
+	Migrations  []Migration       `json:"migrations,omitempty"` // Migrations applied to reach Version, oldest first
+	Dynamic     bool              `json:"dynamic,omitempty"` // Map undeclared fields of raw documents (see InferFields)
+	Strict      bool              `json:"strict,omitempty"` // Reject documents with undeclared fields; overrides Dynamic
+	DynamicTemplates []DynamicTemplate `json:"dynamic_templates,omitempty"` // Tried in order when mapping undeclared fields
+	TTLField    string            `json:"ttl_field,omitempty"` // Date field holding each document's expiry time (see ExpiresAt)
+	Similarities map[string]SimilarityDef `json:"similarities,omitempty"` // Named similarities for FieldDef.Similarity
+	Settings    *Settings         `json:"settings,omitempty"` // Index settings; nil leaves them all to the engine
 }
 
 // FieldDef defines a field in the schema
@@ -15,11 +29,27 @@ type FieldDef struct {
 	Indexed     bool      `json:"indexed"`      // Whether the field is indexed
 	Stored      bool      `json:"stored"`       // Whether the field is stored for retrieval
 	Analyzed    bool      `json:"analyzed"`     // Whether the field is analyzed (for text fields)
+	Analyzer    string    `json:"analyzer,omitempty"` // Name of the analyzer for text fields (default "standard")
+	SearchAnalyzer string `json:"search_analyzer,omitempty"` // Analyzer for query text (defaults to Analyzer)
 	VectorDim   int       `json:"vector_dim"`   // Dimension for vector fields
+	Quantization VectorQuantization `json:"quantization,omitempty"` // How vector fields are held in memory (default full float32)
+	DateFormats []string  `json:"date_formats,omitempty"` // Accepted input formats for date fields
 	Boost       float64   `json:"boost"`       // Boost factor for scoring (default 1.0)
+	Similarity  string    `json:"similarity,omitempty"` // Scoring formula for text fields (see FieldSimilarity; default BM25)
 	Description string    `json:"description"` // Optional description
+	Required    bool      `json:"required,omitempty"` // Reject documents without the field (see WithRequired)
+	Default     interface{} `json:"default,omitempty"` // JSON value given to documents without the field (see WithDefault)
+	CopyTo      []string  `json:"copy_to,omitempty"` // Text fields the values are also indexed into (see WithCopyTo)
 }
 
+// VectorQuantization is how a vector field's values are held in memory
+type VectorQuantization string
+
+const (
+	QuantizationNone VectorQuantization = ""     // Full float32 precision
+	QuantizationInt8 VectorQuantization = "int8" // One signed byte per dimension; k-NN candidates are rescored at full precision
+)
+
 // NewSchema creates a new schema with the given name
 func NewSchema(name string) *Schema {
 	return &Schema{
@@ -31,6 +61,13 @@ func NewSchema(name string) *Schema {
 
 // AddField adds a field definition to the schema
 func (s *Schema) AddField(name string, fieldType FieldType, options ...FieldOption) *FieldDef {
+	def := NewFieldDef(fieldType, options...)
+	s.Fields[name] = def
+	return &def
+}
+
+// NewFieldDef creates a field definition with the default settings and the given options
+func NewFieldDef(fieldType FieldType, options ...FieldOption) FieldDef {
 	def := FieldDef{
 		Type:    fieldType,
 		Indexed: true,  // Default to indexed
@@ -43,9 +80,7 @@ func (s *Schema) AddField(name string, fieldType FieldType, options ...FieldOpti
 	for _, opt := range options {
 		opt(&def)
 	}
-
-	s.Fields[name] = def
-	return &def
+	return def
 }
 
 // FieldOption is a function that modifies a FieldDef
@@ -72,6 +107,22 @@ func WithAnalyzed(analyzed bool) FieldOption {
 	}
 }
 
+// WithAnalyzer sets the named analyzer used for the field at index and query time
+func WithAnalyzer(name string) FieldOption {
+	return func(f *FieldDef) {
+		f.Analyzer = name
+		f.Analyzed = true
+	}
+}
+
+// WithSearchAnalyzer sets a different named analyzer for query text
+// Useful for query-time expansion such as synonyms
+func WithSearchAnalyzer(name string) FieldOption {
+	return func(f *FieldDef) {
+		f.SearchAnalyzer = name
+	}
+}
+
 // WithVectorDim sets the dimension for vector fields
 func WithVectorDim(dim int) FieldOption {
 	return func(f *FieldDef) {
@@ -79,6 +130,21 @@ func WithVectorDim(dim int) FieldOption {
 	}
 }
 
+// WithQuantization sets how a vector field is held in memory for k-NN search
+func WithQuantization(q VectorQuantization) FieldOption {
+	return func(f *FieldDef) {
+		f.Quantization = q
+	}
+}
+
+// WithDateFormats sets the input formats accepted for a date field
+// Formats are Go time layouts or "epoch_millis"/"epoch_second"
+func WithDateFormats(formats ...string) FieldOption {
+	return func(f *FieldDef) {
+		f.DateFormats = formats
+	}
+}
+
 // WithBoost sets the boost factor for the field
 func WithBoost(boost float64) FieldOption {
 	return func(f *FieldDef) {
@@ -93,6 +159,32 @@ func WithDescription(desc string) FieldOption {
 	}
 }
 
+// Analyzer names used when a field doesn't configure one explicitly
+const (
+	DefaultAnalyzer     = "standard"
+	NotAnalyzedAnalyzer = "keyword"
+)
+
+// AnalyzerName returns the name of the analyzer to use for the field
+// Fields that are not analyzed are treated as a single exact token
+func (f FieldDef) AnalyzerName() string {
+	if !f.Analyzed {
+		return NotAnalyzedAnalyzer
+	}
+	if f.Analyzer == "" {
+		return DefaultAnalyzer
+	}
+	return f.Analyzer
+}
+
+// SearchAnalyzerName returns the name of the analyzer to use for query text on the field
+func (f FieldDef) SearchAnalyzerName() string {
+	if f.SearchAnalyzer != "" && f.Analyzed {
+		return f.SearchAnalyzer
+	}
+	return f.AnalyzerName()
+}
+
 // GetField returns the field definition for the given field name
 func (s *Schema) GetField(name string) (*FieldDef, bool) {
 	def, ok := s.Fields[name]
@@ -103,17 +195,43 @@ func (s *Schema) GetField(name string) (*FieldDef, bool) {
 }
 
 // ValidateDocument validates a document against the schema
+// Declared fields must hold values of their type and required fields must be
+// present (defaults count, once applied; see ApplyDefaults); strict schemas
+// also reject undeclared fields
 func (s *Schema) ValidateDocument(doc *Document) error {
-	// Check if all required fields are present
-	// For now, we'll allow extra fields (flexible schema)
-	// In the future, we can add strict mode
+	if err := s.validateFields("", doc.Fields); err != nil {
+		return err
+	}
 	
+	// Sorted, so the same document always reports the same missing field
+	names := make([]string, 0, len(s.Fields))
+	for name, def := range s.Fields {
+		if def.Required {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := doc.GetField(name); !ok {
+			return &SchemaValidationError{Field: name, Expected: s.Fields[name].Type, Message: "required but missing"}
+		}
+	}
+	return nil
+}
+
+// validateFields validates the fields of a document or object at prefix
+func (s *Schema) validateFields(prefix string, fields map[string]FieldValue) error {
 	// Validate field types
-	for name, value := range doc.Fields {
-		if def, ok := s.Fields[name]; ok {
+	for name, value := range fields {
+		path := prefix + name
+		def, ok := s.Fields[path]
+		if !ok && s.Strict {
+			return &SchemaValidationError{Field: path, Actual: value.Type(), Message: "not declared in the strict schema"}
+		}
+		if ok {
 			if value.Type() != def.Type {
 				return &SchemaValidationError{
-					Field: name,
+					Field: path,
 					Expected: def.Type,
 					Actual: value.Type(),
 				}
@@ -124,13 +242,20 @@ func (s *Schema) ValidateDocument(doc *Document) error {
 				if vec, ok := value.(VectorValue); ok {
 					if vec.Dim != def.VectorDim {
 						return &SchemaValidationError{
-							Field: name,
-							Message: "vector dimension mismatch",
+							Field: path,
+							Message: fmt.Sprintf("vector dimension mismatch: expected %d dimensions, got %d", def.VectorDim, vec.Dim),
 						}
 					}
 				}
 			}
 		}
+		
+		// Subfields are declared under dot paths
+		if obj, ok := value.(ObjectValue); ok {
+			if err := s.validateFields(path+".", obj.Fields); err != nil {
+				return err
+			}
+		}
 	}
 	
 	return nil
@@ -146,8 +271,13 @@ type SchemaValidationError struct {
 
 func (e *SchemaValidationError) Error() string {
 	if e.Message != "" {
-		return e.Message
+		return fmt.Sprintf("field %s: %s", e.Field, e.Message)
 	}
-	return "schema validation error"
+	return fmt.Sprintf("field %s: expected %s value, got %s", e.Field, e.Expected, e.Actual)
+}
+
+// Is matches errdefs.ErrSchemaValidation
+func (e *SchemaValidationError) Is(target error) bool {
+	return target == errdefs.ErrSchemaValidation
 }
 
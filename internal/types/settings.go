@@ -0,0 +1,139 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// Settings are an index's tunables, saved with its schema
+// A zero value leaves the setting to the engine's options, e.g. the server's
+// flags; settings saved with an index override them for it
+// Dynamic settings can be changed on an open index; static ones are fixed
+// when the index is created (see StaticChanges)
+type Settings struct {
+	// How often writes become searchable; negative only on explicit refresh (dynamic)
+	RefreshInterval time.Duration `json:"refresh_interval,omitempty"`
+	// Cap on from+size of searches (dynamic)
+	MaxResultWindow int `json:"max_result_window,omitempty"`
+	// Documents and bytes after which writes roll over to a new segment (dynamic)
+	MaxSegmentDocs  int   `json:"max_segment_docs,omitempty"`
+	MaxSegmentBytes int64 `json:"max_segment_bytes,omitempty"`
+	// Size after which the WAL rolls over to a new file (dynamic)
+	MaxWALFileBytes int64 `json:"max_wal_file_bytes,omitempty"`
+	// When the WAL is synced to disk: write, interval or flush (static)
+	Durability string `json:"durability,omitempty"`
+	// How often the WAL is synced with interval durability (static)
+	SyncInterval time.Duration `json:"sync_interval,omitempty"`
+	// Ingest pipeline run on writes that don't name one; "_none" for none (dynamic)
+	DefaultPipeline string `json:"default_pipeline,omitempty"`
+	// Analyzer of text fields that don't name one (static)
+	DefaultAnalyzer string `json:"default_analyzer,omitempty"`
+}
+
+// SettingsError is returned for settings that are invalid or can't be changed
+type SettingsError struct {
+	Setting string
+	Reason  string
+}
+
+func (e *SettingsError) Error() string {
+	return fmt.Sprintf("invalid setting [%s]: %s", e.Setting, e.Reason)
+}
+
+// Validate checks the settings' values that don't depend on the engine
+// Durability and analyzer names are checked where they are looked up
+func (s Settings) Validate() error {
+	switch {
+	case s.MaxResultWindow < 0:
+		return &SettingsError{Setting: "max_result_window", Reason: "must not be negative"}
+	case s.MaxSegmentDocs < 0:
+		return &SettingsError{Setting: "max_segment_docs", Reason: "must not be negative"}
+	case s.MaxSegmentBytes < 0:
+		return &SettingsError{Setting: "max_segment_bytes", Reason: "must not be negative"}
+	case s.MaxWALFileBytes < 0:
+		return &SettingsError{Setting: "max_wal_file_bytes", Reason: "must not be negative"}
+	case s.SyncInterval < 0:
+		return &SettingsError{Setting: "sync_interval", Reason: "must not be negative"}
+	}
+	return nil
+}
+
+// Merge returns the settings with the ones set in update replacing them
+func (s Settings) Merge(update Settings) Settings {
+	if update.RefreshInterval != 0 {
+		s.RefreshInterval = update.RefreshInterval
+	}
+	if update.MaxResultWindow != 0 {
+		s.MaxResultWindow = update.MaxResultWindow
+	}
+	if update.MaxSegmentDocs != 0 {
+		s.MaxSegmentDocs = update.MaxSegmentDocs
+	}
+	if update.MaxSegmentBytes != 0 {
+		s.MaxSegmentBytes = update.MaxSegmentBytes
+	}
+	if update.MaxWALFileBytes != 0 {
+		s.MaxWALFileBytes = update.MaxWALFileBytes
+	}
+	if update.DefaultPipeline != "" {
+		s.DefaultPipeline = update.DefaultPipeline
+	}
+	if update.Durability != "" {
+		s.Durability = update.Durability
+	}
+	if update.SyncInterval != 0 {
+		s.SyncInterval = update.SyncInterval
+	}
+	if update.DefaultAnalyzer != "" {
+		s.DefaultAnalyzer = update.DefaultAnalyzer
+	}
+	return s
+}
+
+// StaticChanges returns the names of the static settings that differ in other
+func (s Settings) StaticChanges(other Settings) []string {
+	var changed []string
+	if s.Durability != other.Durability {
+		changed = append(changed, "durability")
+	}
+	if s.SyncInterval != other.SyncInterval {
+		changed = append(changed, "sync_interval")
+	}
+	if s.DefaultAnalyzer != other.DefaultAnalyzer {
+		changed = append(changed, "default_analyzer")
+	}
+	return changed
+}
+
+// IndexSettings returns the schema's settings, all unset if it has none
+func (s *Schema) IndexSettings() Settings {
+	if s.Settings == nil {
+		return Settings{}
+	}
+	return *s.Settings
+}
+
+// WithSettings returns a copy of the schema with the given settings
+func (s *Schema) WithSettings(settings Settings) *Schema {
+	c := s.clone()
+	c.Settings = &settings
+	return c
+}
+
+// FieldAnalyzerName is f.AnalyzerName, with the index's default analyzer for
+// analyzed fields that don't name one (see Settings.DefaultAnalyzer)
+func (s *Schema) FieldAnalyzerName(f FieldDef) string {
+	if f.Analyzed && f.Analyzer == "" && s.Settings != nil && s.Settings.DefaultAnalyzer != "" {
+		return s.Settings.DefaultAnalyzer
+	}
+	return f.AnalyzerName()
+}
+
+// FieldSearchAnalyzerName is f.SearchAnalyzerName, with the index's default
+// analyzer for analyzed fields that name neither
+func (s *Schema) FieldSearchAnalyzerName(f FieldDef) string {
+	if f.SearchAnalyzer != "" && f.Analyzed {
+		return f.SearchAnalyzer
+	}
+	return s.FieldAnalyzerName(f)
+}
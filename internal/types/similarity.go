@@ -0,0 +1,106 @@
+package types
+
+import "fmt"
+
+// SimilarityType is a formula for ranking a text field's matches
+type SimilarityType string
+
+const (
+	SimilarityBM25    SimilarityType = "BM25"    // Okapi BM25 (default)
+	SimilarityClassic SimilarityType = "classic" // Lucene's classic TF-IDF
+	SimilarityBoolean SimilarityType = "boolean" // Each matching term scores 1, regardless of frequency
+)
+
+// SimilarityDef configures a similarity, either a built-in one or one named in
+// Schema.Similarities
+type SimilarityDef struct {
+	Type SimilarityType `json:"type"`
+	K1   *float64       `json:"k1,omitempty"` // BM25 term frequency saturation (default 1.2)
+	B    *float64       `json:"b,omitempty"`  // BM25 length normalization, 0..1 (default 0.75)
+}
+
+// Validate checks the similarity's type and parameters
+func (d SimilarityDef) Validate() error {
+	switch d.Type {
+	case SimilarityBM25:
+		if d.K1 != nil && *d.K1 < 0 {
+			return fmt.Errorf("BM25 k1 must be >= 0, got %g", *d.K1)
+		}
+		if d.B != nil && (*d.B < 0 || *d.B > 1) {
+			return fmt.Errorf("BM25 b must be between 0 and 1, got %g", *d.B)
+		}
+	case SimilarityClassic, SimilarityBoolean:
+		if d.K1 != nil || d.B != nil {
+			return fmt.Errorf("k1 and b only apply to BM25 similarities")
+		}
+	default:
+		return fmt.Errorf("unknown similarity type %q", d.Type)
+	}
+	return nil
+}
+
+// WithSimilarity sets the similarity a text field is scored with: a built-in
+// type (BM25, classic, boolean) or the name of one in Schema.Similarities
+func WithSimilarity(name string) FieldOption {
+	return func(f *FieldDef) {
+		f.Similarity = name
+	}
+}
+
+// FieldSimilarity returns the similarity a field is scored with, BM25 with the
+// default parameters unless configured
+func (s *Schema) FieldSimilarity(fieldName string) SimilarityDef {
+	def, ok := s.Fields[fieldName]
+	if !ok || def.Similarity == "" {
+		return SimilarityDef{Type: SimilarityBM25}
+	}
+	sim, _ := s.similarity(def.Similarity)
+	return sim
+}
+
+// similarity resolves a similarity name
+func (s *Schema) similarity(name string) (SimilarityDef, bool) {
+	if sim, ok := s.Similarities[name]; ok {
+		return sim, true
+	}
+	switch SimilarityType(name) {
+	case SimilarityBM25, SimilarityClassic, SimilarityBoolean:
+		return SimilarityDef{Type: SimilarityType(name)}, true
+	}
+	return SimilarityDef{Type: SimilarityBM25}, false
+}
+
+// ValidateSimilarities checks the named similarities, and that the similarity
+// of every field and dynamic template is built-in or named
+func (s *Schema) ValidateSimilarities() error {
+	for name, sim := range s.Similarities {
+		if err := sim.Validate(); err != nil {
+			return fmt.Errorf("similarity %s: %w", name, err)
+		}
+	}
+	for fieldName, def := range s.Fields {
+		if err := s.validateFieldSimilarity(def); err != nil {
+			return fmt.Errorf("field %s: %w", fieldName, err)
+		}
+	}
+	for _, t := range s.DynamicTemplates {
+		if err := s.validateFieldSimilarity(t.Mapping); err != nil {
+			return fmt.Errorf("dynamic template %s: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateFieldSimilarity checks a field definition's similarity
+func (s *Schema) validateFieldSimilarity(def FieldDef) error {
+	if def.Similarity == "" {
+		return nil
+	}
+	if def.Type != FieldTypeText {
+		return fmt.Errorf("similarity only applies to text fields")
+	}
+	if _, ok := s.similarity(def.Similarity); !ok {
+		return fmt.Errorf("unknown similarity %q", def.Similarity)
+	}
+	return nil
+}
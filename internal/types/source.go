@@ -0,0 +1,183 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// DocumentFromSource builds a document from a decoded JSON object, such as an
+// Elasticsearch-style "_source" body: {"title": "Dune", "year": 1965}
+// Declared fields are converted to their schema type (dates are parsed with the
+// field's formats); undeclared fields map strings to text, numbers to numeric,
+// booleans to boolean and number arrays to vectors. JSON objects become object
+// fields whose subfields are declared under dot paths ("author.name")
+func DocumentFromSource(id string, source map[string]interface{}, schema *Schema) (*Document, error) {
+	fields, err := fieldsFromSource("", source, schema)
+	if err != nil {
+		return nil, err
+	}
+	doc := NewDocument(id)
+	doc.Fields = fields
+	return doc, nil
+}
+
+// fieldsFromSource converts the fields of a JSON object found at prefix
+func fieldsFromSource(prefix string, source map[string]interface{}, schema *Schema) (map[string]FieldValue, error) {
+	fields := make(map[string]FieldValue, len(source))
+	for name, raw := range source {
+		if raw == nil {
+			continue
+		}
+		path := prefix + name
+
+		var def *FieldDef
+		if schema != nil {
+			if d, ok := schema.Fields[path]; ok {
+				def = &d
+			}
+		}
+
+		if obj, ok := raw.(map[string]interface{}); ok && (def == nil || def.Type == FieldTypeObject) {
+			sub, err := fieldsFromSource(path+".", obj, schema)
+			if err != nil {
+				return nil, err
+			}
+			fields[name] = ObjectValue{Fields: sub}
+			continue
+		}
+
+		value, err := fieldValueFromSource(raw, def)
+		if err != nil {
+			return nil, &SchemaValidationError{Field: path, Message: err.Error()}
+		}
+		fields[name] = value
+	}
+	return fields, nil
+}
+
+// fieldValueFromSource converts one JSON value, guided by the field definition if any
+func fieldValueFromSource(raw interface{}, def *FieldDef) (FieldValue, error) {
+	if def == nil {
+		switch v := raw.(type) {
+		case string:
+			return TextValue{Value: v}, nil
+		case float64:
+			return NumericValue{Value: v}, nil
+		case bool:
+			return BooleanValue{Value: v}, nil
+		case []interface{}:
+			return vectorFromSource(v, 0)
+		}
+		return nil, fmt.Errorf("unsupported value type %T", raw)
+	}
+
+	switch def.Type {
+	case FieldTypeText:
+		if s, ok := raw.(string); ok {
+			return TextValue{Value: s}, nil
+		}
+	case FieldTypeKeyword:
+		switch v := raw.(type) {
+		case string:
+			return KeywordValue{Value: v}, nil
+		case float64:
+			return KeywordValue{Value: strconv.FormatFloat(v, 'f', -1, 64)}, nil
+		case bool:
+			return KeywordValue{Value: strconv.FormatBool(v)}, nil
+		}
+	case FieldTypeNumeric:
+		switch v := raw.(type) {
+		case float64:
+			return NumericValue{Value: v}, nil
+		case string:
+			if n, err := strconv.ParseFloat(v, 64); err == nil {
+				return NumericValue{Value: n}, nil
+			}
+		}
+	case FieldTypeBoolean:
+		switch v := raw.(type) {
+		case bool:
+			return BooleanValue{Value: v}, nil
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return BooleanValue{Value: b}, nil
+			}
+		}
+	case FieldTypeDate:
+		switch v := raw.(type) {
+		case string:
+			t, err := ParseDate(v, def.DateFormatsOrDefault())
+			if err != nil {
+				return nil, err
+			}
+			return DateValue{Value: t}, nil
+		case float64:
+			return DateValue{Value: time.UnixMilli(int64(v)).UTC()}, nil // Epoch millis
+		}
+	case FieldTypeVector:
+		if v, ok := raw.([]interface{}); ok {
+			return vectorFromSource(v, def.VectorDim)
+		}
+	case FieldTypeGeoPoint:
+		return ParseGeoPoint(raw)
+	case FieldTypeCompletion:
+		return ParseCompletion(raw)
+	}
+	return nil, fmt.Errorf("cannot convert %T to %s", raw, def.Type)
+}
+
+// vectorFromSource converts a JSON array of numbers to a vector
+// dim > 0 requires the array to have exactly that many elements
+func vectorFromSource(values []interface{}, dim int) (FieldValue, error) {
+	if dim > 0 && len(values) != dim {
+		return nil, fmt.Errorf("vector has %d dimensions, expected %d", len(values), dim)
+	}
+
+	vec := make([]float32, len(values))
+	for i, v := range values {
+		n, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("vector element %d is %T, not a number", i, v)
+		}
+		vec[i] = float32(n)
+	}
+	return VectorValue{Value: vec, Dim: len(vec)}, nil
+}
+
+// Source returns the document's fields as plain JSON values, the inverse of
+// DocumentFromSource: dates are formatted as RFC 3339, vectors as number arrays
+// and objects as nested JSON objects
+func (d *Document) Source() map[string]interface{} {
+	return sourceFields(d.Fields)
+}
+
+// sourceFields converts fields to plain JSON values
+func sourceFields(fields map[string]FieldValue) map[string]interface{} {
+	source := make(map[string]interface{}, len(fields))
+	for name, value := range fields {
+		switch v := value.(type) {
+		case TextValue:
+			source[name] = v.Value
+		case KeywordValue:
+			source[name] = v.Value
+		case NumericValue:
+			source[name] = v.Value
+		case BooleanValue:
+			source[name] = v.Value
+		case DateValue:
+			source[name] = v.Value.Format(time.RFC3339Nano)
+		case VectorValue:
+			source[name] = v.Value
+		case GeoPointValue:
+			source[name] = map[string]interface{}{"lat": v.Lat, "lon": v.Lon}
+		case CompletionValue:
+			source[name] = completionSource(v)
+		case ObjectValue:
+			source[name] = sourceFields(v.Fields)
+		default:
+			source[name] = value.String()
+		}
+	}
+	return source
+}
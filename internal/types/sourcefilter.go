@@ -0,0 +1,112 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SourceFilter selects the fields of a document returned as its source, like
+// Elasticsearch's "_source" option
+// Patterns match dot paths ("author.name") and may contain '*' wildcards;
+// including an object includes all of its subfields
+type SourceFilter struct {
+	Disabled bool     // No source at all
+	Includes []string // Fields to return; empty returns every field
+	Excludes []string // Fields left out, even if included
+}
+
+// ParseSourceFilter decodes a "_source" option: true or false, a pattern, an
+// array of patterns, or {"includes": [...], "excludes": [...]} where each may
+// also be a single pattern
+func ParseSourceFilter(raw json.RawMessage) (*SourceFilter, error) {
+	var enabled bool
+	if err := json.Unmarshal(raw, &enabled); err == nil {
+		return &SourceFilter{Disabled: !enabled}, nil
+	}
+	var includes stringList
+	if err := json.Unmarshal(raw, &includes); err == nil {
+		return &SourceFilter{Includes: includes}, nil
+	}
+	var body struct {
+		Includes stringList `json:"includes"`
+		Excludes stringList `json:"excludes"`
+	}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return nil, fmt.Errorf("invalid _source: %s", raw)
+	}
+	return &SourceFilter{Includes: body.Includes, Excludes: body.Excludes}, nil
+}
+
+// IsEmpty reports whether the filter returns documents unchanged
+func (f *SourceFilter) IsEmpty() bool {
+	return f == nil || (!f.Disabled && len(f.Includes) == 0 && len(f.Excludes) == 0)
+}
+
+// Apply returns a copy of the document with only the selected fields, or the
+// document itself if the filter is empty
+// Objects left without fields are dropped
+func (f *SourceFilter) Apply(doc *Document) *Document {
+	if f.IsEmpty() {
+		return doc
+	}
+	filtered := *doc
+	filtered.Fields = map[string]FieldValue{}
+	if !f.Disabled {
+		filtered.Fields = f.filter("", doc.Fields, len(f.Includes) == 0)
+	}
+	return &filtered
+}
+
+// filter copies the selected fields of a document or object at prefix
+// included is whether an enclosing object was included
+func (f *SourceFilter) filter(prefix string, fields map[string]FieldValue, included bool) map[string]FieldValue {
+	kept := make(map[string]FieldValue, len(fields))
+	for name, value := range fields {
+		path := prefix + name
+		if matchAny(f.Excludes, path) {
+			continue
+		}
+		fieldIncluded := included || matchAny(f.Includes, path)
+		if obj, ok := value.(ObjectValue); ok {
+			sub := f.filter(path+".", obj.Fields, fieldIncluded)
+			if len(sub) > 0 || (fieldIncluded && len(obj.Fields) == 0) {
+				kept[name] = ObjectValue{Fields: sub}
+			}
+			continue
+		}
+		if fieldIncluded {
+			kept[name] = value
+		}
+	}
+	return kept
+}
+
+// matchAny reports whether a dot path matches any of the patterns
+func matchAny(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern reports whether text matches a pattern where '*' matches any
+// run of characters, dots included
+func matchPattern(pattern, text string) bool {
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return pattern == text
+	}
+	if !strings.HasPrefix(text, pattern[:star]) {
+		return false
+	}
+	rest := pattern[star+1:]
+	for i := star; i <= len(text); i++ {
+		if matchPattern(rest, text[i:]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package types
+
+import "fmt"
+
+// IsStored reports whether a field's values are kept in stored documents
+// Undeclared fields are stored
+func (s *Schema) IsStored(name string) bool {
+	def, ok := s.Fields[name]
+	return !ok || def.Stored
+}
+
+// IsIndexed reports whether a field's values are added to the search structures
+// Undeclared fields are indexed
+func (s *Schema) IsIndexed(name string) bool {
+	def, ok := s.Fields[name]
+	return !ok || def.Indexed
+}
+
+// ValidateStored checks that unstored fields keep doc values, the only place
+// their values remain: text, geo-point and vector fields are rebuilt from the
+// stored documents, so they must be stored
+func (s *Schema) ValidateStored() error {
+	for name, def := range s.Fields {
+		if def.Stored {
+			continue
+		}
+		switch def.Type {
+		case FieldTypeKeyword, FieldTypeNumeric, FieldTypeDate, FieldTypeBoolean, FieldTypeObject:
+		default:
+			return fmt.Errorf("field %s: %s fields must be stored; only keyword, numeric, date and boolean values are kept as doc values", name, def.Type)
+		}
+	}
+	return nil
+}
+
+// StoredDocument returns the document without its unstored fields (see WithStored)
+// The document itself is returned if the schema stores every field
+func (s *Schema) StoredDocument(doc *Document) *Document {
+	if s == nil || !s.hasField(func(def FieldDef) bool { return !def.Stored }) {
+		return doc
+	}
+	stored := *doc
+	stored.Fields = filterFields("", doc.Fields, s.IsStored)
+	return &stored
+}
+
+// RestoreUnstored sets a document's unstored fields from values kept
+// elsewhere, e.g. doc values, by dot path; other values are ignored
+func (s *Schema) RestoreUnstored(doc *Document, values map[string]FieldValue) {
+	for name, value := range values {
+		if !s.IsStored(name) {
+			setPath(doc.Fields, name, value)
+		}
+	}
+}
+
+// IndexedDocument returns the flattened document (see Document.Flatten)
+// without its unindexed fields (see WithIndexed)
+func (s *Schema) IndexedDocument(doc *Document) *Document {
+	flat := doc.Flatten()
+	if !s.hasField(func(def FieldDef) bool { return !def.Indexed }) {
+		return flat
+	}
+	indexed := *flat
+	indexed.Fields = filterFields("", flat.Fields, s.IsIndexed)
+	return &indexed
+}
+
+// hasField reports whether any field definition matches
+func (s *Schema) hasField(match func(def FieldDef) bool) bool {
+	for _, def := range s.Fields {
+		if match(def) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterFields copies the fields of a document or object at prefix, keeping
+// those whose dot path passes keep
+func filterFields(prefix string, fields map[string]FieldValue, keep func(name string) bool) map[string]FieldValue {
+	kept := make(map[string]FieldValue, len(fields))
+	for name, value := range fields {
+		path := prefix + name
+		if !keep(path) {
+			continue
+		}
+		if obj, ok := value.(ObjectValue); ok {
+			value = ObjectValue{Fields: filterFields(path+".", obj.Fields, keep)}
+		}
+		kept[name] = value
+	}
+	return kept
+}
@@ -0,0 +1,146 @@
+package types
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func storedTestSchema() *Schema {
+	s := NewSchema("test")
+	s.AddField("title", FieldTypeText)
+	s.AddField("price", FieldTypeNumeric, WithStored(false))
+	s.AddField("sku", FieldTypeKeyword, WithIndexed(false))
+	s.AddField("address", FieldTypeObject)
+	s.AddField("address.zip", FieldTypeKeyword, WithStored(false), WithIndexed(false))
+	return s
+}
+
+func storedTestDocument() *Document {
+	return &Document{ID: "1", Fields: map[string]FieldValue{
+		"title": TextValue{Value: "red shoes"},
+		"price": NumericValue{Value: 49.5},
+		"sku":   KeywordValue{Value: "RS-1"},
+		"extra": KeywordValue{Value: "undeclared"},
+		"address": ObjectValue{Fields: map[string]FieldValue{
+			"city": KeywordValue{Value: "Berlin"},
+			"zip":  KeywordValue{Value: "10115"},
+		}},
+	}}
+}
+
+func TestStoredDocument(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema *Schema
+		want   map[string]FieldValue
+	}{
+		{
+			name:   "unstored fields dropped",
+			schema: storedTestSchema(),
+			want: map[string]FieldValue{
+				"title":   TextValue{Value: "red shoes"},
+				"sku":     KeywordValue{Value: "RS-1"},
+				"extra":   KeywordValue{Value: "undeclared"},
+				"address": ObjectValue{Fields: map[string]FieldValue{"city": KeywordValue{Value: "Berlin"}}},
+			},
+		},
+		{
+			name:   "everything stored",
+			schema: NewSchema("test"),
+			want:   storedTestDocument().Fields,
+		},
+		{
+			name: "no schema",
+			want: storedTestDocument().Fields,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := storedTestDocument()
+			stored := tc.schema.StoredDocument(doc)
+			if !reflect.DeepEqual(stored.Fields, tc.want) {
+				t.Errorf("stored fields = %v, want %v", stored.Fields, tc.want)
+			}
+			if !reflect.DeepEqual(doc, storedTestDocument()) {
+				t.Error("StoredDocument modified the document")
+			}
+		})
+	}
+}
+
+func TestRestoreUnstored(t *testing.T) {
+	s := storedTestSchema()
+	doc := s.StoredDocument(storedTestDocument())
+
+	// Doc values hold every field with one; only the unstored ones are taken
+	s.RestoreUnstored(doc, map[string]FieldValue{
+		"price":       NumericValue{Value: 49.5},
+		"sku":         KeywordValue{Value: "ignored"},
+		"address.zip": KeywordValue{Value: "10115"},
+	})
+	if want := storedTestDocument().Fields; !reflect.DeepEqual(doc.Fields, want) {
+		t.Errorf("restored fields = %v, want %v", doc.Fields, want)
+	}
+}
+
+func TestIndexedDocument(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema *Schema
+		want   []string // Dot paths of the indexed fields
+	}{
+		{
+			name:   "unindexed fields dropped",
+			schema: storedTestSchema(),
+			want:   []string{"address.city", "extra", "price", "title"},
+		},
+		{
+			name:   "everything indexed",
+			schema: NewSchema("test"),
+			want:   []string{"address.city", "address.zip", "extra", "price", "sku", "title"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			indexed := tc.schema.IndexedDocument(storedTestDocument())
+			var got []string
+			for name := range indexed.Fields {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("indexed fields = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateStored(t *testing.T) {
+	tests := []struct {
+		fieldType FieldType
+		wantErr   bool
+	}{
+		{FieldTypeKeyword, false},
+		{FieldTypeNumeric, false},
+		{FieldTypeDate, false},
+		{FieldTypeBoolean, false},
+		{FieldTypeObject, false},
+		{FieldTypeText, true},
+		{FieldTypeVector, true},
+		{FieldTypeGeoPoint, true},
+		{FieldTypeCompletion, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(string(tc.fieldType), func(t *testing.T) {
+			s := NewSchema("test")
+			s.AddField("f", tc.fieldType, WithStored(false))
+			if err := s.ValidateStored(); (err != nil) != tc.wantErr {
+				t.Errorf("ValidateStored() = %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// ExpiresAt returns when a document expires: the value of the schema's TTL
+// field. Documents without a TTL field, or without a value in it, never expire
+func (s *Schema) ExpiresAt(doc *Document) (time.Time, bool) {
+	if s.TTLField == "" {
+		return time.Time{}, false
+	}
+	value, ok := doc.GetField(s.TTLField)
+	if !ok {
+		return time.Time{}, false
+	}
+	date, ok := value.(DateValue)
+	if !ok {
+		return time.Time{}, false
+	}
+	return date.Value, true
+}
+
+// IsExpired reports whether a document's expiry time has passed
+func (s *Schema) IsExpired(doc *Document, now time.Time) bool {
+	expires, ok := s.ExpiresAt(doc)
+	return ok && !expires.After(now)
+}
+
+// ValidateTTL checks that the TTL field, if any, is a declared date field
+func (s *Schema) ValidateTTL() error {
+	if s.TTLField == "" {
+		return nil
+	}
+	def, ok := s.GetField(s.TTLField)
+	if !ok {
+		return fmt.Errorf("ttl field %s is not mapped", s.TTLField)
+	}
+	if def.Type != FieldTypeDate {
+		return fmt.Errorf("ttl field %s must be a date field, got %s", s.TTLField, def.Type)
+	}
+	return nil
+}